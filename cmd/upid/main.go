@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/kubilitics/upid-cli/internal/clierr"
 	"github.com/kubilitics/upid-cli/internal/commands"
 	"github.com/kubilitics/upid-cli/internal/config"
 	"github.com/spf13/cobra"
@@ -28,9 +33,24 @@ func main() {
 		Short:   config.GetShortDescription(),
 		Long:    config.GetDescription(),
 		Version: config.GetFullVersion(commit, date),
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Arguments were parsed, so later failures are not usage errors
+			cmd.Root().SilenceUsage = true
+
 			// Global pre-run logic
+			// Config commands still run, so that mistakes can be fixed
+			if err := config.Reload(); err != nil {
+				if !commands.RepairsConfig(cmd) {
+					return err
+				}
+				if cmd.Name() != "validate" {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				}
+			}
 			config.SetupLogging()
+			commands.PrepareCommand(cmd)
+			slog.Info("command started", "command", cmd.CommandPath(), "dry_run", commands.IsDryRun())
+			return nil
 		},
 	}
 
@@ -38,24 +58,65 @@ func main() {
 	rootCmd.AddCommand(commands.AnalyzeCmd())
 	rootCmd.AddCommand(commands.OptimizeCmd())
 	rootCmd.AddCommand(commands.ReportCmd())
+	rootCmd.AddCommand(commands.CostCmd())
 	rootCmd.AddCommand(commands.AuthCmd())
 	rootCmd.AddCommand(commands.MonitorCmd())
 	rootCmd.AddCommand(commands.AICmd())
 	rootCmd.AddCommand(commands.EnterpriseCmd())
+	rootCmd.AddCommand(commands.AgentCmd())
 	rootCmd.AddCommand(commands.ClusterCmd())
 	rootCmd.AddCommand(commands.DashboardCmd())
 	rootCmd.AddCommand(commands.StorageCmd())
 	rootCmd.AddCommand(commands.SystemCmd())
+	rootCmd.AddCommand(commands.ConfigCmd())
+	rootCmd.AddCommand(commands.ShellCmd())
+	rootCmd.AddCommand(commands.PluginCmd())
 
 	// Global flags
-	rootCmd.PersistentFlags().StringP("config", "c", "", "config file (default is $HOME/.upid/config.yaml)")
+	rootCmd.PersistentFlags().StringP("config", "c", "", "config file (default is $XDG_CONFIG_HOME/upid/config.yaml)")
+	rootCmd.PersistentFlags().String("profile", "", "configuration profile to use (also set by UPID_PROFILE)")
 	rootCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug mode")
 	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "enable verbose output")
-	rootCmd.PersistentFlags().StringP("output", "o", "table", "output format (table, json, yaml, csv)")
+	rootCmd.PersistentFlags().StringP("output", "o", "table", "output format (table, wide, json, yaml, csv, markdown, jsonpath=..., go-template=...)")
+	rootCmd.PersistentFlags().StringSlice("columns", nil, "comma-separated columns to show in table and CSV output")
+	rootCmd.PersistentFlags().String("sort-by", "", "sort list output by a column or field (prefix with - for descending)")
+	rootCmd.PersistentFlags().StringArray("filter", nil, "filter list output, e.g. 'confidence>0.9' (repeatable)")
+	rootCmd.PersistentFlags().Int("page-size", 0, "show list output in pages of this many items (0 shows all)")
+	rootCmd.PersistentFlags().Int("page", 1, "page of list output to show with --page-size")
+	rootCmd.PersistentFlags().Bool("pager", false, "send output through $PAGER when writing to a terminal")
+	rootCmd.PersistentFlags().BoolP("quiet", "q", false, "print only identifiers of listed items, one per line")
+	rootCmd.PersistentFlags().Bool("no-color", false, "disable colored output (also set by NO_COLOR)")
+	rootCmd.PersistentFlags().Duration("timeout", 0, "abort commands that run longer than this, e.g. 5m (0 waits forever)")
+	rootCmd.PersistentFlags().Bool("dry-run", false, "print the actions mutating commands would take without making changes")
+	rootCmd.PersistentFlags().Bool("no-cache", false, "ignore cached results and run the analysis again")
+	if err := config.BindFlags(rootCmd.PersistentFlags()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Errors are printed below in the requested output format
+	rootCmd.SilenceErrors = true
+
+	// Ctrl-C cancels the running command instead of killing the CLI, so the
+	// Python runtime is stopped and partial output is reported
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Executables named upid-<command> on PATH run as commands that are not
+	// built in
+	if ok, code, err := commands.ExecutePlugin(ctx, rootCmd, os.Args[1:]); ok {
+		stop()
+		if err != nil {
+			clierr.Print(os.Stderr, err, clierr.PrintOptions{Debug: config.IsDebug()})
+			os.Exit(clierr.From(err).ExitCode())
+		}
+		os.Exit(code)
+	}
 
 	// Execute
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		stop()
+		clierr.Print(os.Stderr, err, clierr.PrintOptions{JSON: commands.IsJSONOutput(), Debug: config.IsDebug()})
+		os.Exit(clierr.From(err).ExitCode())
 	}
 } 
\ No newline at end of file
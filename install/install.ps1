@@ -12,7 +12,7 @@ param(
 # Configuration
 $RepoUrl = "https://github.com/your-org/upid-cli"
 $Version = "v2.0.0"
-$ConfigDir = "$env:USERPROFILE\.upid"
+$ConfigDir = "$env:APPDATA\upid"
 
 # Function to write colored output
 function Write-Status {
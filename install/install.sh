@@ -17,7 +17,7 @@ NC='\033[0m' # No Color
 REPO_URL="https://github.com/your-org/upid-cli"
 VERSION="v2.0.0"
 INSTALL_DIR="/usr/local/bin"
-CONFIG_DIR="$HOME/.upid"
+CONFIG_DIR="${XDG_CONFIG_HOME:-$HOME/.config}/upid"
 
 # Detect platform
 detect_platform() {
@@ -0,0 +1,200 @@
+// Package anomaly learns the seasonal pattern of series such as the cost or
+// the CPU usage of a namespace, and scores how far new values depart from
+// it. Each series keeps a baseline for each hour of the week, and for each
+// hour of the day to score with until the weekly one has learned enough.
+// Baselines learn by days and weeks seen, not by samples, so that they are
+// ready after the same time whatever the interval of the samples.
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// Metrics of the series of a scope
+const (
+	MetricCost   = "cost"
+	MetricCPU    = "cpu"
+	MetricMemory = "memory"
+)
+
+const (
+	weekBuckets = 7 * 24
+	dayBuckets  = 24
+	// minPeriods is how many distinct days a bucket of the day, or weeks a
+	// bucket of the week, learns before it scores
+	minPeriods = 3
+	// maxPeriods bounds the weight of the past in a bucket to that many
+	// days or weeks, so that baselines follow lasting changes of the
+	// pattern
+	maxPeriods = 50
+	// minDeviation is the standard deviation of a bucket relative to its
+	// mean under which values that barely change do not score as anomalies
+	minDeviation = 0.05
+)
+
+// DefaultSensitivity is the deviation, in standard deviations from the
+// baseline, from which values are anomalies
+const DefaultSensitivity = 3.0
+
+// Bucket is the baseline of a series at one hour of the week or the day
+type Bucket struct {
+	N        int     `json:"n"`
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	// Periods counts the distinct days, or weeks, the bucket learned from
+	Periods int `json:"periods"`
+	// Last is the day, counted from the Unix epoch, of the last value
+	Last int64 `json:"last"`
+}
+
+// add learns a value seen on a day counted from the Unix epoch, weighing
+// the past at most maxPeriods days or weeks of values
+func (b *Bucket) add(day int64, x float64) {
+	// A bucket of the week sees one day a week, so distinct days are
+	// distinct weeks
+	if b.Periods == 0 || day != b.Last {
+		b.Periods++
+		b.Last = day
+	}
+	b.N++
+	perPeriod := max(1, b.N/b.Periods)
+	weight := float64(min(b.N, maxPeriods*perPeriod))
+	delta := x - b.Mean
+	b.Mean += delta / weight
+	b.Variance += (delta*(x-b.Mean) - b.Variance) / weight
+}
+
+// ready returns true if the bucket learned minPeriods days or weeks before
+// the day, counted from the Unix epoch, of a value to score
+func (b *Bucket) ready(day int64) bool {
+	learned := b.Periods
+	if b.Periods > 0 && b.Last == day {
+		learned--
+	}
+	return learned >= minPeriods
+}
+
+// deviation returns the standard deviation of the bucket, at least
+// minDeviation of its mean
+func (b *Bucket) deviation() float64 {
+	return math.Max(math.Sqrt(math.Max(b.Variance, 0)), math.Max(minDeviation*math.Abs(b.Mean), 1e-9))
+}
+
+// Series is the seasonal baseline of a metric of a scope
+type Series struct {
+	Week []Bucket `json:"week"`
+	Day  []Bucket `json:"day"`
+}
+
+// Score is how far a value departs from its baseline
+type Score struct {
+	Value float64
+	// Expected is the mean of the baseline
+	Expected float64
+	// Deviation is the distance from Expected in standard deviations,
+	// negative under it
+	Deviation float64
+	// Scored is false while the baseline is learning
+	Scored bool
+}
+
+// Observe scores a value seen at a time against the baseline of its hour,
+// then learns it
+func (s *Series) Observe(t time.Time, x float64) Score {
+	if len(s.Week) != weekBuckets || len(s.Day) != dayBuckets {
+		s.Week, s.Day = make([]Bucket, weekBuckets), make([]Bucket, dayBuckets)
+	}
+	t = t.UTC()
+	week := &s.Week[int(t.Weekday())*24+t.Hour()]
+	day := &s.Day[t.Hour()]
+
+	unixDay := t.Unix() / 86400
+	score := Score{Value: x}
+	for _, b := range []*Bucket{week, day} {
+		if b.ready(unixDay) {
+			score = Score{Value: x, Expected: b.Mean, Deviation: (x - b.Mean) / b.deviation(), Scored: true}
+			break
+		}
+	}
+	week.add(unixDay, x)
+	day.add(unixDay, x)
+	return score
+}
+
+// Sensitivity is the deviation from which values are anomalies, by scope.
+// Lower is more sensitive; 0 does not score a scope.
+type Sensitivity struct {
+	Default float64
+	Scopes  map[string]float64
+}
+
+// ParseSensitivity parses deviations given as <deviation> for all scopes,
+// or <scope>=<deviation> where a scope is cluster or namespace:<name>
+func ParseSensitivity(values []string) (Sensitivity, error) {
+	s := Sensitivity{Default: DefaultSensitivity, Scopes: map[string]float64{}}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		scope, deviation, scoped := strings.Cut(value, "=")
+		if !scoped {
+			deviation, scope = scope, ""
+		}
+		d, err := strconv.ParseFloat(strings.TrimSpace(deviation), 64)
+		valid := err == nil && d >= 0 && !math.IsInf(d, 0)
+		if scoped {
+			name, found := strings.CutPrefix(scope, "namespace:")
+			valid = valid && (scope == "cluster" || (found && name != ""))
+		}
+		if !valid {
+			return Sensitivity{}, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+				fmt.Sprintf("invalid sensitivity %q (expected a deviation such as 3, or <scope>=<deviation> with a scope of cluster or namespace:<name>)", value))
+		}
+		if scoped {
+			s.Scopes[scope] = d
+		} else {
+			s.Default = d
+		}
+	}
+	return s, nil
+}
+
+// For returns the deviation from which values of a scope are anomalies
+func (s Sensitivity) For(scope string) float64 {
+	if d, ok := s.Scopes[scope]; ok {
+		return d
+	}
+	return s.Default
+}
+
+// Enabled returns true if any scope is scored
+func (s Sensitivity) Enabled() bool {
+	if s.Default > 0 {
+		return true
+	}
+	for _, d := range s.Scopes {
+		if d > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the sensitivity as it is given on the command line
+func (s Sensitivity) String() string {
+	parts := []string{strconv.FormatFloat(s.Default, 'g', -1, 64)}
+	scopes := make([]string, 0, len(s.Scopes))
+	for scope := range s.Scopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	for _, scope := range scopes {
+		parts = append(parts, scope+"="+strconv.FormatFloat(s.Scopes[scope], 'g', -1, 64))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,88 @@
+package anomaly
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Model is the local file of the baselines learned from a cluster
+type Model struct {
+	path string
+	// Context is the kubeconfig context of the cluster
+	Context string    `json:"context"`
+	Updated time.Time `json:"updated"`
+	// Series are the baselines by scope and metric, e.g. namespace:shop/cpu
+	Series map[string]*Series `json:"series"`
+}
+
+// Load reads the model file at path; a missing file, or one learned from
+// another cluster, starts an empty model of kubeContext
+func Load(path, kubeContext string) (*Model, error) {
+	model := &Model{path: path, Context: kubeContext, Series: map[string]*Series{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return model, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read anomaly baselines: %v", err)
+	}
+	var stored Model
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("invalid anomaly baselines file %s: %v", path, err)
+	}
+	if stored.Context == kubeContext && stored.Series != nil {
+		model.Updated, model.Series = stored.Updated, stored.Series
+	}
+	return model, nil
+}
+
+// Path returns the location of the model file
+func (m *Model) Path() string {
+	return m.path
+}
+
+// Observe scores a value of a metric of a scope against its baseline, and
+// learns it
+func (m *Model) Observe(scope, metric string, t time.Time, x float64) Score {
+	key := scope + "/" + metric
+	series := m.Series[key]
+	if series == nil {
+		series = &Series{}
+		m.Series[key] = series
+	}
+	if t.After(m.Updated) {
+		m.Updated = t.UTC()
+	}
+	return series.Observe(t, x)
+}
+
+// Save replaces the model file, readable only by the user
+func (m *Model) Save() error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode anomaly baselines: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	// Write to a temporary file first so that a crash never leaves the
+	// baselines half written
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write anomaly baselines: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write anomaly baselines: %v", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), m.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write anomaly baselines: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package awsauth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// Credentials are the access keys requests are signed with
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// LoadCredentials reads the access keys of a profile of the shared
+// credentials file, or those of the environment when no profile is given
+// and they are set
+func LoadCredentials(profile string) (*Credentials, error) {
+	if profile == "" {
+		if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+			return &Credentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+		}
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	values, err := readProfile(path, profile)
+	if err != nil {
+		return nil, err
+	}
+	creds := &Credentials{
+		AccessKeyID:     values["aws_access_key_id"],
+		SecretAccessKey: values["aws_secret_access_key"],
+		SessionToken:    values["aws_session_token"],
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, clierr.New(clierr.CategoryAuth, "AWS_CREDENTIALS_MISSING",
+			fmt.Sprintf("no access keys for AWS profile %q in %s", profile, path)).
+			WithHint("Set aws_access_key_id and aws_secret_access_key in the profile, or export them as AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return creds, nil
+}
+
+// readProfile returns the keys of a profile of an INI credentials file
+func readProfile(path, profile string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, clierr.New(clierr.CategoryAuth, "AWS_CREDENTIALS_MISSING", "no AWS credentials found").
+			WithHint("Export AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or create " + path + " with 'aws configure'")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read AWS credentials: %v", err)
+	}
+	defer file.Close()
+
+	var values map[string]string
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == profile {
+				values = map[string]string{}
+			}
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read AWS credentials: %v", err)
+	}
+	if values == nil {
+		return nil, clierr.New(clierr.CategoryAuth, "AWS_CREDENTIALS_MISSING", fmt.Sprintf("no AWS profile %q in %s", profile, path)).
+			WithHint("Use one of the profiles of the file, or create it with 'aws configure --profile " + profile + "'")
+	}
+	return values, nil
+}
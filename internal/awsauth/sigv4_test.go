@@ -0,0 +1,90 @@
+package awsauth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The vectors are those of the AWS Signature Version 4 test suite
+func TestSign(t *testing.T) {
+	creds := &Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		body    string
+		service string
+		want    string
+	}{
+		{
+			"get-vanilla", http.MethodGet, "https://example.amazonaws.com/", "", "service",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			"get-vanilla-query-order-key-case", http.MethodGet, "https://example.amazonaws.com/?Param2=value2&Param1=value1", "", "service",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			"post-vanilla", http.MethodPost, "https://example.amazonaws.com/", "", "service",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			Sign(req, HashHex([]byte(tt.body)), creds, "us-east-1", tt.service, now)
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization =\n  %s\nwant\n  %s", got, tt.want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %q, want 20150830T123600Z", got)
+			}
+		})
+	}
+}
+
+func TestSignS3(t *testing.T) {
+	creds := &Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", SessionToken: "token"}
+	req, err := http.NewRequest(http.MethodPut, "https://bucket.s3.us-east-1.amazonaws.com/a%20b.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	Sign(req, EmptyHash, creds, "us-east-1", "s3", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	if got := req.Header.Get("X-Amz-Content-Sha256"); got != EmptyHash {
+		t.Errorf("X-Amz-Content-Sha256 = %q, want %q", got, EmptyHash)
+	}
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "token" {
+		t.Errorf("X-Amz-Security-Token = %q, want token", got)
+	}
+	const signed = "SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date;x-amz-security-token,"
+	if got := req.Header.Get("Authorization"); !strings.Contains(got, signed) {
+		t.Errorf("Authorization = %q, want %s", got, signed)
+	}
+}
+
+func TestEncodePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"/bucket/key.txt", "/bucket/key.txt"},
+		{"/a b/c+d", "/a%20b/c%2Bd"},
+		{"/reports/ü~_-.", "/reports/%C3%BC~_-."},
+	}
+	for _, tt := range tests {
+		if got := EncodePath(tt.path); got != tt.want {
+			t.Errorf("EncodePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
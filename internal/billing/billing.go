@@ -0,0 +1,175 @@
+// Package billing reads what AWS billed for the EC2 instances, EBS volumes
+// and load balancers of a cluster, from a Cost and Usage Report (CUR) or the
+// Cost Explorer API. Costs are amortized: usage covered by Reserved Instances
+// and Savings Plans is priced at its effective rate rather than on demand. It
+// also lists the EBS snapshots the account pays for, and reads the I/O of EBS
+// volumes from CloudWatch.
+package billing
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/awsauth"
+)
+
+// Sources of billed costs
+const (
+	SourceCUR          = "aws-cur"
+	SourceCostExplorer = "aws-cost-explorer"
+)
+
+// Resource is the cost billed for one resource
+type Resource struct {
+	// ID is the instance or volume ID, or the ARN of a load balancer
+	ID string
+	// Cost is the amortized cost over the period
+	Cost float64
+	// Covered is the part of Cost for usage covered by Reserved Instances
+	// or Savings Plans
+	Covered float64
+	// First and Last bound the usage of the resource within the period
+	First, Last time.Time
+}
+
+// Hourly returns the cost of the resource per hour it was used, so that
+// resources created during the period are not underpriced
+func (r *Resource) Hourly() float64 {
+	used := r.Last.Sub(r.First)
+	if used < time.Hour {
+		used = time.Hour
+	}
+	return r.Cost / used.Hours()
+}
+
+// Costs are the billed costs of resources over a period
+type Costs struct {
+	// Source is SourceCUR or SourceCostExplorer
+	Source string
+	// Start and End bound the period of the line items read
+	Start, End time.Time
+	// Resources are the costs by resource ID, or ARN for load balancers
+	Resources map[string]*Resource
+}
+
+// add adds the cost of a line item of a resource
+func (c *Costs) add(id string, cost, covered float64, start, end time.Time) {
+	r := c.Resources[id]
+	if r == nil {
+		r = &Resource{ID: id, First: start, Last: end}
+		c.Resources[id] = r
+	}
+	r.Cost += cost
+	r.Covered += covered
+	if start.Before(r.First) {
+		r.First = start
+	}
+	if end.After(r.Last) {
+		r.Last = end
+	}
+}
+
+// LoadBalancers returns the billed load balancers by name, which is how
+// Kubernetes services know them through their hostname
+func (c *Costs) LoadBalancers() map[string]*Resource {
+	byName := map[string]*Resource{}
+	for id, r := range c.Resources {
+		if name := loadBalancerName(id); name != "" {
+			byName[name] = r
+		}
+	}
+	return byName
+}
+
+// loadBalancerName returns the name in the ARN of a load balancer, such as
+// arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/net/web/50dc6c495c0c9188
+// or arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/web
+// for classic load balancers, and "" for other resources
+func loadBalancerName(arn string) string {
+	if !strings.Contains(arn, ":elasticloadbalancing:") {
+		return ""
+	}
+	_, path, ok := strings.Cut(arn, ":loadbalancer/")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(path, "/")
+	switch len(parts) {
+	case 1:
+		return parts[0]
+	case 3:
+		return parts[1]
+	}
+	return ""
+}
+
+// relevant reports whether line items of a resource are kept: those of EC2
+// instances, EBS volumes and load balancers
+func relevant(id string) bool {
+	return strings.HasPrefix(id, "i-") || strings.HasPrefix(id, "vol-") || strings.Contains(id, ":elasticloadbalancing:")
+}
+
+// Options configure access to AWS
+type Options struct {
+	// Region is the region of the S3 bucket of the report, default
+	// $AWS_REGION, $AWS_DEFAULT_REGION or us-east-1
+	Region string
+	// Profile selects the credentials of the shared credentials file,
+	// default $AWS_PROFILE or default. Without one, the credentials of
+	// $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY are used if set.
+	Profile string
+	// Timeout limits each request, 5m if 0 as reports can be large
+	Timeout time.Duration
+}
+
+// Client reads billed costs from AWS
+type Client struct {
+	opts Options
+	http *http.Client
+	// creds are loaded with the first request, as local reports need none
+	creds *awsauth.Credentials
+}
+
+// New creates a client
+func New(opts Options) *Client {
+	if opts.Region == "" {
+		opts.Region = firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Minute
+	}
+	return &Client{opts: opts, http: &http.Client{Timeout: opts.Timeout}}
+}
+
+// credentials returns the credentials, loading them on first use
+func (c *Client) credentials() (*awsauth.Credentials, error) {
+	if c.creds == nil {
+		creds, err := awsauth.LoadCredentials(c.opts.Profile)
+		if err != nil {
+			return nil, err
+		}
+		c.creds = creds
+	}
+	return c.creds, nil
+}
+
+// endpoint returns the endpoint of a service set in the environment, as the
+// AWS SDKs read it, e.g. $AWS_ENDPOINT_URL_S3, and "" if none is set
+func endpoint(service string) string {
+	return strings.TrimSuffix(firstEnv("AWS_ENDPOINT_URL_"+service, "AWS_ENDPOINT_URL"), "/")
+}
+
+// firstEnv returns the first environment variable set
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
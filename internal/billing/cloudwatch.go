@@ -0,0 +1,149 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// cloudWatchVersion is the version of the CloudWatch Query API
+const cloudWatchVersion = "2010-08-01"
+
+// cloudWatch is the CloudWatch service
+var cloudWatch = queryService{name: "CloudWatch", host: "monitoring", signing: "monitoring", iam: "cloudwatch"}
+
+// metricPeriod is the period of the EBS metrics read, over which peaks are
+// averaged
+const metricPeriod = 5 * time.Minute
+
+// ebsMetrics are the CloudWatch metrics of a volume read, all summed over
+// each period
+var ebsMetrics = []string{
+	"VolumeReadOps", "VolumeWriteOps", "VolumeReadBytes", "VolumeWriteBytes",
+	"VolumeIOPSExceededCheck", "VolumeThroughputExceededCheck",
+}
+
+// VolumeIO is the I/O of an EBS volume over a time range
+type VolumeIO struct {
+	// IOPS and Throughput, in bytes per second, are the averages over the
+	// time range, PeakIOPS and PeakThroughput those of the busiest 5
+	// minutes
+	IOPS, PeakIOPS             float64
+	Throughput, PeakThroughput float64
+	// Throttled is the seconds the volume was held to its provisioned IOPS
+	// or throughput
+	Throttled float64
+}
+
+// getMetricDataResponse is the answer of GetMetricData
+type getMetricDataResponse struct {
+	Results []struct {
+		ID     string    `xml:"Id"`
+		Values []float64 `xml:"Values>member"`
+		// Timestamps align with Values
+		Timestamps []time.Time `xml:"Timestamps>member"`
+	} `xml:"GetMetricDataResult>MetricDataResults>member"`
+	NextToken string `xml:"GetMetricDataResult>NextToken"`
+}
+
+// VolumeIO returns the I/O of EBS volumes over the window up to now from
+// their CloudWatch metrics in a region, the region of the client if empty,
+// keyed by volume ID. Volumes without metrics, as those detached for the
+// whole window, are missing. The throttling checks are only reported for
+// volumes attached to Nitro instances.
+func (c *Client) VolumeIO(ctx context.Context, region string, volumeIDs []string, window time.Duration) (map[string]*VolumeIO, error) {
+	if region == "" {
+		region = c.opts.Region
+	}
+	end := time.Now().Truncate(metricPeriod)
+	start := end.Add(-window)
+	volumes := map[string]*VolumeIO{}
+
+	// GetMetricData takes up to 500 queries
+	perRequest := 500 / len(ebsMetrics)
+	for first := 0; first < len(volumeIDs); first += perRequest {
+		batch := volumeIDs[first:min(first+perRequest, len(volumeIDs))]
+		params := url.Values{
+			"Action":    {"GetMetricData"},
+			"Version":   {cloudWatchVersion},
+			"StartTime": {start.UTC().Format(time.RFC3339)},
+			"EndTime":   {end.UTC().Format(time.RFC3339)},
+		}
+		n := 0
+		for v, id := range batch {
+			for m, metric := range ebsMetrics {
+				n++
+				prefix := "MetricDataQueries.member." + strconv.Itoa(n) + "."
+				params.Set(prefix+"Id", fmt.Sprintf("v%d_m%d", v, m))
+				params.Set(prefix+"MetricStat.Metric.Namespace", "AWS/EBS")
+				params.Set(prefix+"MetricStat.Metric.MetricName", metric)
+				params.Set(prefix+"MetricStat.Metric.Dimensions.member.1.Name", "VolumeId")
+				params.Set(prefix+"MetricStat.Metric.Dimensions.member.1.Value", id)
+				params.Set(prefix+"MetricStat.Period", strconv.Itoa(int(metricPeriod.Seconds())))
+				params.Set(prefix+"MetricStat.Stat", "Sum")
+			}
+		}
+
+		// The sums of each metric by period, by volume of the batch
+		sums := make([][]map[time.Time]float64, len(batch))
+		for v := range sums {
+			sums[v] = make([]map[time.Time]float64, len(ebsMetrics))
+			for m := range sums[v] {
+				sums[v][m] = map[time.Time]float64{}
+			}
+		}
+		for {
+			var response getMetricDataResponse
+			if err := c.queryAPI(ctx, cloudWatch, region, params, &response); err != nil {
+				return nil, err
+			}
+			for _, r := range response.Results {
+				var v, m int
+				if _, err := fmt.Sscanf(r.ID, "v%d_m%d", &v, &m); err != nil || v >= len(batch) || m >= len(ebsMetrics) {
+					continue
+				}
+				for i, value := range r.Values {
+					if i < len(r.Timestamps) {
+						sums[v][m][r.Timestamps[i]] += value
+					}
+				}
+			}
+			if response.NextToken == "" {
+				break
+			}
+			params.Set("NextToken", response.NextToken)
+		}
+
+		for v, id := range batch {
+			if len(sums[v][0]) == 0 && len(sums[v][1]) == 0 {
+				continue
+			}
+			// Reads and writes by period
+			ops, bytes := map[time.Time]float64{}, map[time.Time]float64{}
+			for m, into := range []map[time.Time]float64{ops, ops, bytes, bytes} {
+				for t, value := range sums[v][m] {
+					into[t] += value
+				}
+			}
+			io := &VolumeIO{}
+			for _, period := range ops {
+				io.IOPS += period / window.Seconds()
+				io.PeakIOPS = max(io.PeakIOPS, period/metricPeriod.Seconds())
+			}
+			for _, period := range bytes {
+				io.Throughput += period / window.Seconds()
+				io.PeakThroughput = max(io.PeakThroughput, period/metricPeriod.Seconds())
+			}
+			// The checks are 1 for each minute the volume was held back
+			for m := 4; m < len(ebsMetrics); m++ {
+				for _, minutes := range sums[v][m] {
+					io.Throttled += minutes * 60
+				}
+			}
+			volumes[id] = io
+		}
+	}
+	return volumes, nil
+}
@@ -0,0 +1,177 @@
+package billing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/awsauth"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// ResourceHistory is how far back Cost Explorer keeps costs by resource
+const ResourceHistory = 14 * 24 * time.Hour
+
+// costExplorerServices are the Cost Explorer services of the instances,
+// volumes and load balancers of clusters
+var costExplorerServices = []string{
+	"Amazon Elastic Compute Cloud - Compute",
+	"EC2 - Other",
+	"Amazon Elastic Load Balancing",
+}
+
+// costExplorerRequest is the body of GetCostAndUsageWithResources
+type costExplorerRequest struct {
+	TimePeriod struct {
+		Start string `json:"Start"`
+		End   string `json:"End"`
+	} `json:"TimePeriod"`
+	Granularity string   `json:"Granularity"`
+	Metrics     []string `json:"Metrics"`
+	Filter      struct {
+		Dimensions struct {
+			Key    string   `json:"Key"`
+			Values []string `json:"Values"`
+		} `json:"Dimensions"`
+	} `json:"Filter"`
+	GroupBy       []costExplorerGroup `json:"GroupBy"`
+	NextPageToken string              `json:"NextPageToken,omitempty"`
+}
+
+// costExplorerGroup is a dimension costs are grouped by
+type costExplorerGroup struct {
+	Type string `json:"Type"`
+	Key  string `json:"Key"`
+}
+
+// costExplorerResponse is the answer of GetCostAndUsageWithResources
+type costExplorerResponse struct {
+	ResultsByTime []struct {
+		TimePeriod struct {
+			Start string `json:"Start"`
+			End   string `json:"End"`
+		} `json:"TimePeriod"`
+		Groups []struct {
+			Keys    []string `json:"Keys"`
+			Metrics map[string]struct {
+				Amount string `json:"Amount"`
+			} `json:"Metrics"`
+		} `json:"Groups"`
+	} `json:"ResultsByTime"`
+	NextPageToken string `json:"NextPageToken"`
+}
+
+// QueryCostExplorer reads the amortized daily costs of the EC2 instances,
+// EBS volumes and load balancers of the account between start and end from
+// Cost Explorer. Costs by resource are only kept for ResourceHistory and
+// must be enabled in the Cost Explorer preferences; start is moved forward
+// to fit.
+func (c *Client) QueryCostExplorer(ctx context.Context, start, end time.Time) (*Costs, error) {
+	// Days are UTC, the end is exclusive
+	end = end.UTC().Truncate(24 * time.Hour)
+	if earliest := end.Add(-ResourceHistory); start.Before(earliest) {
+		start = earliest
+	}
+	start = start.UTC().Truncate(24 * time.Hour)
+	if !start.Before(end) {
+		start = end.Add(-24 * time.Hour)
+	}
+	costs := &Costs{Source: SourceCostExplorer, Start: start, End: end, Resources: map[string]*Resource{}}
+
+	var request costExplorerRequest
+	request.TimePeriod.Start = start.Format("2006-01-02")
+	request.TimePeriod.End = end.Format("2006-01-02")
+	request.Granularity = "DAILY"
+	request.Metrics = []string{"AmortizedCost"}
+	request.Filter.Dimensions.Key = "SERVICE"
+	request.Filter.Dimensions.Values = costExplorerServices
+	request.GroupBy = []costExplorerGroup{{Type: "DIMENSION", Key: "RESOURCE_ID"}}
+
+	for {
+		var response costExplorerResponse
+		if err := c.costExplorer(ctx, "GetCostAndUsageWithResources", request, &response); err != nil {
+			return nil, err
+		}
+		for _, result := range response.ResultsByTime {
+			dayStart, err1 := time.Parse("2006-01-02", result.TimePeriod.Start)
+			dayEnd, err2 := time.Parse("2006-01-02", result.TimePeriod.End)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			for _, group := range result.Groups {
+				if len(group.Keys) == 0 || !relevant(group.Keys[0]) {
+					continue
+				}
+				amount, _ := strconv.ParseFloat(group.Metrics["AmortizedCost"].Amount, 64)
+				if amount != 0 {
+					costs.add(group.Keys[0], amount, 0, dayStart, dayEnd)
+				}
+			}
+		}
+		if response.NextPageToken == "" {
+			return costs, nil
+		}
+		request.NextPageToken = response.NextPageToken
+	}
+}
+
+// costExplorer calls an action of the Cost Explorer API, which is served
+// from us-east-1 only
+func (c *Client) costExplorer(ctx context.Context, action string, request, response interface{}) error {
+	creds, err := c.credentials()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	target := endpoint("COST_EXPLORER")
+	if target == "" {
+		target = "https://ce.us-east-1.amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AWSInsightsIndexService."+action)
+	awsauth.Sign(req, awsauth.HashHex(body), creds, "us-east-1", "ce", time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return clierr.Wrap(err, clierr.CategoryUnreachable, "AWS_UNREACHABLE", "failed to reach Cost Explorer")
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 256<<20))
+	if err != nil {
+		return clierr.Wrap(err, clierr.CategoryUnreachable, "AWS_UNREACHABLE", "failed to read Cost Explorer response")
+	}
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(data, response); err != nil {
+			return clierr.New(clierr.CategoryGeneral, "AWS_REQUEST_FAILED", fmt.Sprintf("invalid Cost Explorer response: %v", err))
+		}
+		return nil
+	}
+
+	message := awsErrorMessage(data)
+	switch {
+	case resp.StatusCode == http.StatusForbidden || strings.Contains(message, "AccessDenied") ||
+		strings.Contains(message, "UnrecognizedClient") || strings.Contains(message, "InvalidSignature"):
+		return clierr.New(clierr.CategoryAuth, "AWS_ACCESS_DENIED", "Cost Explorer denied access: "+message).
+			WithHint("Allow ce:GetCostAndUsageWithResources to the AWS credentials used")
+	case strings.Contains(message, "DataUnavailable") || strings.Contains(message, "resource"):
+		return clierr.New(clierr.CategoryUsage, "AWS_RESOURCE_DATA_DISABLED", "Cost Explorer has no costs by resource: "+message).
+			WithHint("Enable resource-level data for EC2 in the Cost Explorer preferences of the management account, or use a Cost and Usage Report")
+	}
+	return clierr.New(clierr.CategoryGeneral, "AWS_REQUEST_FAILED", fmt.Sprintf("Cost Explorer answered %s: %s", resp.Status, message))
+}
@@ -0,0 +1,299 @@
+package billing
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/awsauth"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// Line item types of a report that carry the cost of usage. Usage covered
+// by Savings Plans is negated by SavingsPlanNegation items, which are left
+// out along with fees, taxes and credits that are not tied to a resource.
+const (
+	lineItemUsage           = "Usage"
+	lineItemDiscountedUsage = "DiscountedUsage"
+	lineItemSavingsPlan     = "SavingsPlanCoveredUsage"
+)
+
+// columns are the report columns read, by their normalized name. Legacy
+// reports name them lineItem/ResourceId, CUR 2.0 line_item_resource_id.
+var columns = []string{
+	"lineitemresourceid",
+	"lineitemlineitemtype",
+	"lineitemunblendedcost",
+	"lineitemusagestartdate",
+	"lineitemusageenddate",
+	"reservationeffectivecost",
+	"savingsplansavingsplaneffectivecost",
+}
+
+// manifest lists the data files of a report, as reportKeys in the bucket
+// for legacy reports and as dataFiles URLs for CUR 2.0 exports
+type manifest struct {
+	Bucket     string   `json:"bucket"`
+	ReportKeys []string `json:"reportKeys"`
+	DataFiles  []string `json:"dataFiles"`
+}
+
+// ReadReport reads the costs of the EC2 instances, EBS volumes and load
+// balancers of a Cost and Usage Report from the line items that start
+// within start and end. location is an s3:// URL or a local path of the
+// manifest of a report, or of one of its CSV files, which may be gzipped.
+func (c *Client) ReadReport(ctx context.Context, location string, start, end time.Time) (*Costs, error) {
+	costs := &Costs{Source: SourceCUR, Start: start, End: end, Resources: map[string]*Resource{}}
+	if !strings.HasSuffix(strings.ToLower(location), ".json") {
+		return costs, c.readDataFile(ctx, location, costs)
+	}
+
+	body, err := c.open(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	err = json.NewDecoder(body).Decode(&m)
+	body.Close()
+	if err != nil {
+		return nil, clierr.New(clierr.CategoryUsage, "REPORT_INVALID", fmt.Sprintf("invalid report manifest %s: %v", location, err))
+	}
+	files := m.DataFiles
+	for _, key := range m.ReportKeys {
+		files = append(files, "s3://"+m.Bucket+"/"+key)
+	}
+	if len(files) == 0 {
+		return nil, clierr.New(clierr.CategoryUsage, "REPORT_INVALID", fmt.Sprintf("report manifest %s lists no files", location)).
+			WithHint("Wait for AWS to deliver the report, or give the manifest of another billing period")
+	}
+	for _, file := range files {
+		if err := c.readDataFile(ctx, file, costs); err != nil {
+			return nil, err
+		}
+	}
+	return costs, nil
+}
+
+// readDataFile adds the costs of the line items of a CSV file of a report
+func (c *Client) readDataFile(ctx context.Context, location string, costs *Costs) error {
+	name := strings.ToLower(location)
+	if strings.HasSuffix(name, ".parquet") {
+		return clierr.New(clierr.CategoryUsage, "REPORT_UNSUPPORTED", fmt.Sprintf("report file %s is in Parquet format", location)).
+			WithHint("Deliver the report as CSV (text/csv with GZIP compression) instead")
+	}
+	body, err := c.open(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	var reader io.Reader = body
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return clierr.New(clierr.CategoryUsage, "REPORT_INVALID", fmt.Sprintf("invalid report file %s: %v", location, err))
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	if err := readLineItems(reader, costs); err != nil {
+		return clierr.New(clierr.CategoryUsage, "REPORT_INVALID", fmt.Sprintf("invalid report file %s: %v", location, err))
+	}
+	return nil
+}
+
+// readLineItems adds the costs of the line items of a CSV report that start
+// within the period of costs
+func readLineItems(r io.Reader, costs *Costs) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("no header: %v", err)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[normalizeColumn(name)] = i
+	}
+	for _, column := range columns[:4] {
+		if _, ok := index[column]; !ok {
+			return fmt.Errorf("no %s column, is it a Cost and Usage Report with resource IDs?", column)
+		}
+	}
+	field := func(record []string, column string) string {
+		if i, ok := index[column]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		id := field(record, "lineitemresourceid")
+		if !relevant(id) {
+			continue
+		}
+		var column string
+		switch field(record, "lineitemlineitemtype") {
+		case lineItemUsage:
+			column = "lineitemunblendedcost"
+		case lineItemDiscountedUsage:
+			column = "reservationeffectivecost"
+		case lineItemSavingsPlan:
+			column = "savingsplansavingsplaneffectivecost"
+		default:
+			continue
+		}
+		start, err := parseTime(field(record, "lineitemusagestartdate"))
+		if err != nil {
+			return err
+		}
+		if start.Before(costs.Start) || !start.Before(costs.End) {
+			continue
+		}
+		end, err := parseTime(field(record, "lineitemusageenddate"))
+		if err != nil {
+			end = start.Add(time.Hour)
+		}
+		cost, _ := strconv.ParseFloat(field(record, column), 64)
+		covered := 0.0
+		if column != "lineitemunblendedcost" {
+			covered = cost
+		}
+		costs.add(id, cost, covered, start, end)
+	}
+}
+
+// normalizeColumn makes the column names of legacy and CUR 2.0 reports
+// comparable, e.g. lineItem/ResourceId and line_item_resource_id
+func normalizeColumn(name string) string {
+	return strings.NewReplacer("/", "", "_", "", " ", "").Replace(strings.ToLower(strings.TrimPrefix(name, "\ufeff")))
+}
+
+// parseTime parses the dates of line items, which legacy reports write as
+// 2024-05-01T00:00:00Z and CUR 2.0 as 2024-05-01 00:00:00.000
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05.000Z", "2006-01-02 15:04:05.000", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid usage date %q", value)
+}
+
+// open opens a local file or an s3:// URL
+func (c *Client) open(ctx context.Context, location string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(location, "s3://") {
+		file, err := os.Open(location)
+		if err != nil {
+			return nil, clierr.Wrap(err, clierr.CategoryUsage, "REPORT_NOT_FOUND", fmt.Sprintf("failed to open report %s", location))
+		}
+		return file, nil
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+	if bucket == "" || key == "" {
+		return nil, clierr.New(clierr.CategoryUsage, "REPORT_NOT_FOUND", fmt.Sprintf("invalid report location %q", location)).
+			WithHint("Use s3://<bucket>/<prefix>/<report>/<period>/<report>-Manifest.json")
+	}
+	return c.getObject(ctx, bucket, key)
+}
+
+// getObject downloads an object of S3
+func (c *Client) getObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	creds, err := c.credentials()
+	if err != nil {
+		return nil, err
+	}
+	var target string
+	switch base := endpoint("S3"); {
+	case base != "":
+		target = base + "/" + bucket + "/" + key
+	case strings.Contains(bucket, "."):
+		// Dotted bucket names do not match the wildcard certificate of
+		// virtual-hosted endpoints
+		target = "https://s3." + c.opts.Region + ".amazonaws.com/" + bucket + "/" + key
+	default:
+		target = "https://" + bucket + ".s3." + c.opts.Region + ".amazonaws.com/" + key
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	u.RawPath = awsauth.EncodePath(u.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	awsauth.Sign(req, awsauth.EmptyHash, creds, c.opts.Region, "s3", time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, clierr.Wrap(err, clierr.CategoryUnreachable, "AWS_UNREACHABLE", "failed to reach S3")
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp.Body, nil
+	}
+	defer resp.Body.Close()
+	location := "s3://" + bucket + "/" + key
+	switch resp.StatusCode {
+	case http.StatusForbidden:
+		return nil, clierr.New(clierr.CategoryAuth, "AWS_ACCESS_DENIED", fmt.Sprintf("S3 denied access to %s", location)).
+			WithHint("Allow s3:GetObject on the report bucket to the AWS credentials used")
+	case http.StatusNotFound:
+		return nil, clierr.New(clierr.CategoryUsage, "REPORT_NOT_FOUND", fmt.Sprintf("no report at %s", location)).
+			WithHint("Check pricing.cur with 'upid config get pricing.cur'")
+	case http.StatusMovedPermanently, http.StatusBadRequest:
+		if region := resp.Header.Get("X-Amz-Bucket-Region"); region != "" && region != c.opts.Region {
+			return nil, clierr.New(clierr.CategoryUsage, "AWS_WRONG_REGION", fmt.Sprintf("bucket %s is in region %s, not %s", bucket, region, c.opts.Region)).
+				WithHint("Run 'upid config set pricing.aws_region " + region + "'")
+		}
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return nil, clierr.New(clierr.CategoryGeneral, "AWS_REQUEST_FAILED",
+		fmt.Sprintf("S3 answered %s for %s: %s", resp.Status, location, awsErrorMessage(detail)))
+}
+
+// awsErrorMessage extracts the message of an XML or JSON error response of
+// AWS, or returns the response as is
+func awsErrorMessage(body []byte) string {
+	text := string(body)
+	if start := strings.Index(text, "<Message>"); start >= 0 {
+		if end := strings.Index(text[start:], "</Message>"); end >= 0 {
+			return text[start+len("<Message>") : start+end]
+		}
+	}
+	var e struct {
+		Type        string `json:"__type"`
+		Message     string `json:"message"`
+		MessageCaps string `json:"Message"`
+	}
+	if json.Unmarshal(body, &e) == nil && (e.Message != "" || e.MessageCaps != "") {
+		if e.Message == "" {
+			e.Message = e.MessageCaps
+		}
+		if e.Type != "" {
+			return path.Base(strings.ReplaceAll(e.Type, "#", "/")) + ": " + e.Message
+		}
+		return e.Message
+	}
+	return strings.TrimSpace(text)
+}
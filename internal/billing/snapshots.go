@@ -0,0 +1,149 @@
+package billing
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/awsauth"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// ec2Version is the version of the EC2 Query API
+const ec2Version = "2016-11-15"
+
+// Snapshot is an EBS snapshot owned by the account
+type Snapshot struct {
+	ID       string
+	VolumeID string
+	// Size is the size of the volume it was taken of, in GiB
+	Size    int
+	Started time.Time
+	// State is pending, completed or error
+	State       string
+	Description string
+	// Tier is standard, or archive for snapshots moved to the cheaper
+	// archive tier
+	Tier string
+	Tags map[string]string
+}
+
+// describeSnapshotsResponse is the answer of DescribeSnapshots
+type describeSnapshotsResponse struct {
+	Snapshots []struct {
+		ID          string    `xml:"snapshotId"`
+		VolumeID    string    `xml:"volumeId"`
+		Size        int       `xml:"volumeSize"`
+		Started     time.Time `xml:"startTime"`
+		State       string    `xml:"status"`
+		Description string    `xml:"description"`
+		Tier        string    `xml:"storageTier"`
+		Tags        []struct {
+			Key   string `xml:"key"`
+			Value string `xml:"value"`
+		} `xml:"tagSet>item"`
+	} `xml:"snapshotSet>item"`
+	NextToken string `xml:"nextToken"`
+}
+
+// Snapshots lists the EBS snapshots the account owns in a region, the
+// region of the client if empty
+func (c *Client) Snapshots(ctx context.Context, region string) ([]Snapshot, error) {
+	if region == "" {
+		region = c.opts.Region
+	}
+	var snapshots []Snapshot
+	params := url.Values{
+		"Action":     {"DescribeSnapshots"},
+		"Version":    {ec2Version},
+		"Owner.1":    {"self"},
+		"MaxResults": {"1000"},
+	}
+	for {
+		var response describeSnapshotsResponse
+		if err := c.ec2(ctx, region, params, &response); err != nil {
+			return nil, err
+		}
+		for _, s := range response.Snapshots {
+			snapshot := Snapshot{
+				ID: s.ID, VolumeID: s.VolumeID, Size: s.Size, Started: s.Started, State: s.State,
+				Description: s.Description, Tier: s.Tier, Tags: map[string]string{},
+			}
+			for _, tag := range s.Tags {
+				snapshot.Tags[tag.Key] = tag.Value
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+		if response.NextToken == "" {
+			return snapshots, nil
+		}
+		params.Set("NextToken", response.NextToken)
+	}
+}
+
+// ec2 calls an action of the EC2 Query API in a region
+func (c *Client) ec2(ctx context.Context, region string, params url.Values, response interface{}) error {
+	return c.queryAPI(ctx, queryService{name: "EC2", host: "ec2", signing: "ec2", iam: "ec2"}, region, params, response)
+}
+
+// queryService is an AWS service of the Query protocol
+type queryService struct {
+	// name names the service in messages and its endpoint variable
+	name string
+	// host is the first label of its regional endpoint, signing the name
+	// requests are signed for and iam the prefix of its IAM actions
+	host, signing, iam string
+}
+
+// queryAPI calls an action of the Query API of a service in a region
+func (c *Client) queryAPI(ctx context.Context, service queryService, region string, params url.Values, response interface{}) error {
+	creds, err := c.credentials()
+	if err != nil {
+		return err
+	}
+	target := endpoint(strings.ToUpper(service.name))
+	if target == "" {
+		target = "https://" + service.host + "." + region + ".amazonaws.com"
+	}
+	body := []byte(params.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	awsauth.Sign(req, awsauth.HashHex(body), creds, region, service.signing, time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return clierr.Wrap(err, clierr.CategoryUnreachable, "AWS_UNREACHABLE", "failed to reach "+service.name)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 256<<20))
+	if err != nil {
+		return clierr.Wrap(err, clierr.CategoryUnreachable, "AWS_UNREACHABLE", "failed to read "+service.name+" response")
+	}
+	if resp.StatusCode == http.StatusOK {
+		if err := xml.Unmarshal(data, response); err != nil {
+			return clierr.New(clierr.CategoryGeneral, "AWS_REQUEST_FAILED", fmt.Sprintf("invalid %s response: %v", service.name, err))
+		}
+		return nil
+	}
+
+	message := awsErrorMessage(data)
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized ||
+		strings.Contains(string(data), "UnauthorizedOperation") || strings.Contains(string(data), "AuthFailure") ||
+		strings.Contains(string(data), "AccessDenied") {
+		return clierr.New(clierr.CategoryAuth, "AWS_ACCESS_DENIED", service.name+" denied access: "+message).
+			WithHint("Allow " + service.iam + ":" + params.Get("Action") + " to the AWS credentials used")
+	}
+	return clierr.New(clierr.CategoryGeneral, "AWS_REQUEST_FAILED", fmt.Sprintf("%s answered %s: %s", service.name, resp.Status, message))
+}
@@ -0,0 +1,331 @@
+package bridge
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/pyruntime"
+)
+
+// daemonStartTimeout is how long to wait for a started daemon to accept
+// connections
+const daemonStartTimeout = 10 * time.Second
+
+// daemonIdleTimeout is how long the daemon stays up without requests
+const daemonIdleTimeout = 10 * time.Minute
+
+// maxDaemonResponse bounds the length of a daemon reply, so that a corrupt
+// header cannot make the CLI allocate gigabytes
+const maxDaemonResponse = 64 << 20
+
+// errDaemonUnavailable is returned when no daemon can be reached, and the
+// request cannot have reached one
+var errDaemonUnavailable = errors.New("runtime daemon is not running")
+
+// rpcRequest is a JSON-RPC 2.0 request
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int         `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response
+type rpcResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			Traceback string `json:"traceback"`
+		} `json:"data"`
+	} `json:"error"`
+}
+
+// executeParams are the parameters of the execute method
+type executeParams struct {
+	Args []string          `json:"args"`
+	Env  map[string]string `json:"env,omitempty"`
+}
+
+// DaemonStatus describes a running runtime daemon
+type DaemonStatus struct {
+	Running bool   `json:"running"`
+	PID     int    `json:"pid,omitempty"`
+	Address string `json:"address"`
+}
+
+// EnableDaemon makes the bridge send commands to a runtime daemon listening
+// on address. With autoStart the daemon is started on first use; otherwise
+// an already running daemon is used if there is one.
+func (pb *PythonBridge) EnableDaemon(address, logFile string, autoStart bool) {
+	pb.daemonAddress = address
+	pb.daemonLog = logFile
+	pb.daemonAutoStart = autoStart
+}
+
+// SetMutating marks the commands that follow as changing the cluster or
+// other state, so that a command the daemon may have received is never run
+// again in another process
+func (pb *PythonBridge) SetMutating(mutating bool) {
+	pb.mutating = mutating
+}
+
+// StartDaemon starts the runtime daemon in the background and waits until it
+// accepts connections. It is not an error if the daemon is already running.
+func (pb *PythonBridge) StartDaemon(ctx context.Context) (*DaemonStatus, error) {
+	if pb.daemonAddress == "" {
+		return nil, fmt.Errorf("daemon address is not configured")
+	}
+	if status, err := pb.DaemonStatus(ctx); err == nil && status.Running {
+		return status, nil
+	}
+
+	if pb.runtime != nil && pb.runtime.Kind == pyruntime.KindContainer {
+		return nil, clierr.New(clierr.CategoryBridge, "DAEMON_UNSUPPORTED", "the runtime daemon is not supported with container runtimes")
+	}
+
+	args := pb.commandArgs("--serve", []string{"--socket", pb.daemonAddress,
+		"--idle-timeout", strconv.Itoa(int(daemonIdleTimeout.Seconds()))})
+	command := exec.Command(pb.executable(), args...)
+	command.Env = append(os.Environ(), "UPID_DAEMON_LOG="+pb.daemonLog)
+	command.SysProcAttr = detachedProcAttr()
+	if err := command.Start(); err != nil {
+		return nil, commandError(err, nil)
+	}
+	// The daemon outlives this process
+	_ = command.Process.Release()
+
+	deadline := time.Now().Add(daemonStartTimeout)
+	for time.Now().Before(deadline) {
+		if ctx.Err() != nil {
+			return nil, contextError(ctx.Err())
+		}
+		if status, err := pb.DaemonStatus(ctx); err == nil && status.Running {
+			slog.Info("runtime daemon started", "pid", status.PID, "address", pb.daemonAddress)
+			return status, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, clierr.New(clierr.CategoryBridge, "DAEMON_START_FAILED", "runtime daemon did not start within "+daemonStartTimeout.String()).
+		WithHint("Check the daemon log with 'upid system logs --source daemon'")
+}
+
+// StopDaemon asks a running daemon to shut down
+func (pb *PythonBridge) StopDaemon(ctx context.Context) error {
+	if _, err := pb.call(ctx, "shutdown", nil); err != nil {
+		return err
+	}
+	pb.closeDaemon()
+	return nil
+}
+
+// DaemonStatus reports whether the daemon is running
+func (pb *PythonBridge) DaemonStatus(ctx context.Context) (*DaemonStatus, error) {
+	status := &DaemonStatus{Address: pb.daemonAddress}
+	result, err := pb.call(ctx, "ping", nil)
+	if errors.Is(err, errDaemonUnavailable) {
+		return status, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ping struct {
+		PID int `json:"pid"`
+	}
+	if err := json.Unmarshal(result, &ping); err != nil {
+		return nil, fmt.Errorf("invalid daemon response: %v", err)
+	}
+	status.Running = true
+	status.PID = ping.PID
+	return status, nil
+}
+
+// tryDaemon runs a command in the daemon if one is configured and reachable.
+// ok is false if the command should run in a new Python process instead.
+func (pb *PythonBridge) tryDaemon(ctx context.Context, cmd string, args []string) (output []byte, stats *ExecStats, ok bool, err error) {
+	if pb.daemonAddress == "" {
+		return nil, nil, false, nil
+	}
+
+	start := time.Now()
+	output, err = pb.executeDaemon(ctx, cmd, args)
+	if errors.Is(err, errDaemonUnavailable) {
+		return nil, nil, false, nil
+	}
+	stats = &ExecStats{Duration: time.Since(start)}
+	if err != nil {
+		slog.Error("daemon command failed", "command", cmd, "args", strings.Join(args, " "), "duration", stats.Duration, "error", err)
+		return nil, stats, true, err
+	}
+	slog.Debug("daemon command completed", "command", cmd, "args", strings.Join(args, " "), "duration", stats.Duration)
+	return output, stats, true, nil
+}
+
+// executeDaemon runs a command in the daemon and returns output in the same
+// form the runtime prints it. Returns errDaemonUnavailable if there is no
+// daemon to talk to.
+func (pb *PythonBridge) executeDaemon(ctx context.Context, cmd string, args []string) ([]byte, error) {
+	params := executeParams{Args: append([]string{cmd}, args...), Env: map[string]string{}}
+	if fixture := os.Getenv("UPID_FIXTURE_FILE"); fixture != "" {
+		params.Env["UPID_FIXTURE_FILE"] = fixture
+	}
+	for name, value := range pb.env {
+		params.Env[name] = value
+	}
+
+	result, err := pb.call(ctx, "execute", params)
+	if errors.Is(err, errDaemonUnavailable) && pb.daemonAutoStart {
+		if _, startErr := pb.StartDaemon(ctx); startErr != nil {
+			slog.Warn("failed to start runtime daemon", "error", startErr)
+			return nil, errDaemonUnavailable
+		}
+		result, err = pb.call(ctx, "execute", params)
+	}
+	var failure *clierr.Error
+	if errors.As(err, &failure) && failure.Details != "" {
+		stderr := []byte(failure.Details + "\nError: " + failure.Message + "\n")
+		failure.LogFile = pb.writeDiagnostics(pb.commandArgs(cmd, args), err, stderr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if outputFormat(args) == "json" {
+		return append(result, '\n'), nil
+	}
+	var message struct {
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(result, &message)
+	if message.Message == "" {
+		message.Message = "Command completed successfully"
+	}
+	return []byte(message.Message + "\n"), nil
+}
+
+// call sends a JSON-RPC request to the daemon, connecting if needed
+func (pb *PythonBridge) call(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	if pb.daemonAddress == "" {
+		return nil, errDaemonUnavailable
+	}
+	// Mutating commands are sent on a new connection, so that an idle one
+	// closed by the daemon is not mistaken for a failure of the command
+	if pb.mutating {
+		pb.closeDaemon()
+	}
+	reused := pb.daemonConn != nil
+	if !reused {
+		conn, err := dialDaemon(pb.daemonAddress)
+		if err != nil {
+			return nil, errDaemonUnavailable
+		}
+		pb.daemonConn = conn
+	}
+
+	pb.daemonID++
+	request := rpcRequest{JSONRPC: "2.0", ID: pb.daemonID, Method: method, Params: params}
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode daemon request: %v", err)
+	}
+
+	// Unblock the exchange if the command is cancelled, on the connection
+	// itself as the field is cleared when the exchange fails
+	conn := pb.daemonConn
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	response, written, err := roundTrip(conn, payload)
+	if err != nil {
+		pb.closeDaemon()
+		if ctx.Err() != nil {
+			return nil, contextError(ctx.Err())
+		}
+		// A daemon that exited after its idle timeout closed the connection
+		// kept from an earlier request: the request fails to be written, or
+		// the connection ends before any reply. Only then may the command run
+		// elsewhere; once it may have been received, it must not run again.
+		if reused && (!written || errors.Is(err, io.EOF)) {
+			return nil, errDaemonUnavailable
+		}
+		return nil, clierr.Wrap(err, clierr.CategoryBridge, "DAEMON_FAILED", "runtime daemon failed after receiving the request").
+			WithHint("The command may have run, check its effect before running it again; see the daemon log with 'upid system logs --source daemon'")
+	}
+
+	var decoded rpcResponse
+	if err := json.Unmarshal(response, &decoded); err != nil {
+		return nil, fmt.Errorf("invalid daemon response: %v", err)
+	}
+	if decoded.Error != nil {
+		failure := clierr.Classify(decoded.Error.Message)
+		failure.Details = strings.TrimSpace(decoded.Error.Data.Traceback)
+		return nil, failure
+	}
+	return decoded.Result, nil
+}
+
+// roundTrip writes a message with a 4-byte big-endian length prefix to conn
+// and reads the reply, framed the same way on the Unix socket and on the
+// byte-mode named pipe of Windows. written is false if the request could not
+// be written; a connection closed before any byte of the reply is io.EOF.
+func roundTrip(conn net.Conn, payload []byte) (response []byte, written bool, err error) {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(payload)))
+	if _, err := conn.Write(append(header, payload...)); err != nil {
+		return nil, false, err
+	}
+
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, true, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length > maxDaemonResponse {
+		return nil, true, fmt.Errorf("daemon response of %d bytes exceeds the limit of %d", length, maxDaemonResponse)
+	}
+	response = make([]byte, length)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, true, err
+	}
+	return response, true, nil
+}
+
+// closeDaemon drops the daemon connection
+func (pb *PythonBridge) closeDaemon() {
+	if pb.daemonConn != nil {
+		pb.daemonConn.Close()
+		pb.daemonConn = nil
+	}
+}
+
+// outputFormat returns the last --format value in args
+func outputFormat(args []string) string {
+	format := "table"
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "--format" {
+			format = args[i+1]
+		}
+	}
+	return format
+}
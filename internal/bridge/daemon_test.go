@@ -0,0 +1,66 @@
+//go:build !windows
+
+package bridge
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestCallCancelledDuringFailure cancels a command while the daemon drops
+// the connection, which must fail the command without racing on it; run
+// with -race
+func TestCallCancelledDuringFailure(t *testing.T) {
+	address := filepath.Join(t.TempDir(), "daemon.sock")
+	listener, err := net.Listen("unix", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	cancels := make(chan context.CancelFunc)
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			header := make([]byte, 4)
+			if _, err := io.ReadFull(conn, header); err == nil {
+				io.CopyN(io.Discard, conn, int64(binary.BigEndian.Uint32(header)))
+			}
+			// The command is cancelled as the connection drops, a little
+			// before or after
+			cancel := <-cancels
+			delay := time.Duration(i%100-50) * time.Microsecond
+			if delay < 0 {
+				cancel()
+				time.Sleep(-delay)
+				conn.Close()
+			} else {
+				conn.Close()
+				time.Sleep(delay)
+				cancel()
+			}
+		}
+	}()
+
+	pb := NewPythonBridge("python3", "", false)
+	pb.EnableDaemon(address, "", false)
+	for i := 0; i < 200; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() { cancels <- cancel }()
+		if _, err := pb.call(ctx, "execute", executeParams{Args: []string{"status"}}); err == nil {
+			t.Fatalf("call %d succeeded on a dropped connection", i)
+		}
+		if pb.daemonConn != nil {
+			t.Fatalf("call %d kept the dropped connection", i)
+		}
+		cancel()
+	}
+}
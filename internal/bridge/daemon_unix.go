@@ -0,0 +1,31 @@
+//go:build !windows
+
+package bridge
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// DefaultDaemonAddress returns the daemon socket path within stateDir
+func DefaultDaemonAddress(stateDir string) string {
+	return filepath.Join(stateDir, "daemon.sock")
+}
+
+// dialDaemon connects to the daemon's Unix domain socket
+func dialDaemon(address string) (net.Conn, error) {
+	return net.Dial("unix", address)
+}
+
+// detachedProcAttr starts the daemon in its own session so it survives the
+// CLI process and terminal
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// interruptProcess asks a runtime process to stop
+func interruptProcess(p *os.Process) error {
+	return p.Signal(os.Interrupt)
+}
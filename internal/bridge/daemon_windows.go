@@ -0,0 +1,36 @@
+//go:build windows
+
+package bridge
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// DefaultDaemonAddress returns the daemon named pipe for the current user
+func DefaultDaemonAddress(stateDir string) string {
+	return `\\.\pipe\upid-daemon-` + os.Getenv("USERNAME")
+}
+
+// dialDaemon connects to the daemon's named pipe
+func dialDaemon(address string) (net.Conn, error) {
+	timeout := time.Second
+	return winio.DialPipe(address, &timeout)
+}
+
+// detachedProcAttr starts the daemon without a console so it survives the
+// CLI process
+func detachedProcAttr() *syscall.SysProcAttr {
+	const detachedProcess = 0x00000008
+	return &syscall.SysProcAttr{CreationFlags: detachedProcess | syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// interruptProcess stops a runtime process. Windows cannot deliver an
+// interrupt to another process, so it is killed.
+func interruptProcess(p *os.Process) error {
+	return p.Kill()
+}
@@ -0,0 +1,63 @@
+package bridge
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxDiagnostics is the number of diagnostic logs kept
+const maxDiagnostics = 20
+
+// SetDiagnosticsDir sets where failure diagnostics of the Python runtime are
+// written; they are not written if unset
+func (pb *PythonBridge) SetDiagnosticsDir(dir string) {
+	pb.diagnosticsDir = dir
+}
+
+// writeDiagnostics records the invocation and full standard error of a
+// failed runtime command and returns the file written, or "" on failure
+func (pb *PythonBridge) writeDiagnostics(cmdArgs []string, err error, stderr []byte) string {
+	if pb.diagnosticsDir == "" {
+		return ""
+	}
+	if err := os.MkdirAll(pb.diagnosticsDir, 0o700); err != nil {
+		return ""
+	}
+
+	now := time.Now()
+	name := "runtime-" + now.Format("20060102-150405.000")
+	if len(cmdArgs) > 1 {
+		name += "-" + filepath.Base(cmdArgs[1])
+	}
+	path := filepath.Join(pb.diagnosticsDir, strings.ReplaceAll(name, ".", "")+".log")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "time: %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "command: %s %s\n", pb.executable(), strings.Join(cmdArgs, " "))
+	fmt.Fprintf(&b, "error: %v\n\n", err)
+	b.WriteString("stderr:\n")
+	b.Write(stderr)
+
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		return ""
+	}
+	pruneDiagnostics(pb.diagnosticsDir)
+	return path
+}
+
+// pruneDiagnostics removes all but the newest diagnostic logs
+func pruneDiagnostics(dir string) {
+	files, err := filepath.Glob(filepath.Join(dir, "runtime-*.log"))
+	if err != nil || len(files) <= maxDiagnostics {
+		return
+	}
+	// Names sort by time
+	sort.Strings(files)
+	for _, file := range files[:len(files)-maxDiagnostics] {
+		os.Remove(file)
+	}
+}
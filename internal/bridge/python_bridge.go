@@ -1,10 +1,22 @@
 package bridge
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
 	"os/exec"
 	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/pyruntime"
 )
 
 // PythonBridge handles communication between Go CLI and Python core
@@ -12,39 +24,318 @@ type PythonBridge struct {
 	pythonPath string
 	scriptPath  string
 	debug       bool
+
+	// Runtime daemon connection, see EnableDaemon
+	daemonAddress   string
+	daemonLog       string
+	daemonAutoStart bool
+	daemonConn      net.Conn
+	daemonID        int
+
+	// mutating commands are never run again once the daemon may have
+	// received them, see SetMutating
+	mutating bool
+
+	// progress receives output streamed before a JSON result
+	progress io.Writer
+
+	// runtime is the discovered Python runtime, see SetRuntime
+	runtime *pyruntime.Runtime
+
+	// retry controls retries of transient failures, see SetRetryPolicy
+	retry RetryPolicy
+
+	// diagnosticsDir receives logs of failed commands, see SetDiagnosticsDir
+	diagnosticsDir string
+
+	// env holds variables passed to the runtime, see SetEnv
+	env map[string]string
 }
 
+// runtimeScript is the runtime bootstrap script used when no runtime was
+// discovered, relative to a source checkout
+const runtimeScript = "runtime/upid_runtime.py"
+
 // NewPythonBridge creates a new Python bridge instance
 func NewPythonBridge(pythonPath, scriptPath string, debug bool) *PythonBridge {
 	return &PythonBridge{
 		pythonPath: pythonPath,
 		scriptPath:  scriptPath,
 		debug:       debug,
+		retry:       DefaultRetryPolicy(),
 	}
 }
 
+// ExecStats describes the cost of a single bridge invocation
+type ExecStats struct {
+	Duration time.Duration `json:"duration"`
+	MaxRSSKB int64         `json:"max_rss_kb"`
+}
+
 // ExecuteCommand executes a Python command and returns the result
-func (pb *PythonBridge) ExecuteCommand(cmd string, args []string) ([]byte, error) {
-	// Use the runtime bootstrap script instead of module
-	runtimeScript := "runtime/upid_runtime.py"
-	cmdArgs := append([]string{runtimeScript, cmd}, args...)
-	
+func (pb *PythonBridge) ExecuteCommand(ctx context.Context, cmd string, args []string) ([]byte, error) {
+	output, _, err := pb.ExecuteCommandWithStats(ctx, cmd, args)
+	return output, err
+}
+
+// ExecuteCommandWithStats executes a Python command and reports wall time and
+// peak memory of the Python process
+func (pb *PythonBridge) ExecuteCommandWithStats(ctx context.Context, cmd string, args []string) ([]byte, *ExecStats, error) {
+	var output []byte
+	var stats *ExecStats
+	err := pb.withRetry(ctx, cmd, func() error {
+		// Prefer the daemon, which avoids interpreter startup
+		var ok bool
+		var err error
+		if output, stats, ok, err = pb.tryDaemon(ctx, cmd, args); ok {
+			return err
+		}
+
+		output = nil
+		stats, err = pb.run(ctx, cmd, args, func(line []byte) {
+			output = append(output, line...)
+		})
+		return err
+	})
+	if err != nil {
+		return nil, stats, err
+	}
+	return output, stats, nil
+}
+
+// ExecuteCommandStreaming executes a Python command and writes its output to
+// w line by line as it is produced, instead of once the process exits
+func (pb *PythonBridge) ExecuteCommandStreaming(ctx context.Context, cmd string, args []string, w io.Writer) error {
+	var writeErr error
+	_, err := pb.run(ctx, cmd, args, func(line []byte) {
+		if writeErr == nil {
+			_, writeErr = w.Write(line)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return writeErr
+}
+
+// SetEnv passes an environment variable to the runtime for every command;
+// an empty value stops passing it
+func (pb *PythonBridge) SetEnv(name, value string) {
+	if value == "" {
+		delete(pb.env, name)
+		return
+	}
+	if pb.env == nil {
+		pb.env = map[string]string{}
+	}
+	pb.env[name] = value
+}
+
+// SetProgressWriter sets where output printed by the runtime before a JSON
+// result (progress messages, warnings) is streamed. It is discarded if unset.
+func (pb *PythonBridge) SetProgressWriter(w io.Writer) {
+	pb.progress = w
+}
+
+// run executes a Python command, calling onLine for each line of standard
+// output as it arrives. Standard error is captured for error reporting.
+func (pb *PythonBridge) run(ctx context.Context, cmd string, args []string, onLine func(line []byte)) (*ExecStats, error) {
+	cmdArgs := pb.commandArgs(cmd, args)
+
 	if pb.debug {
-		fmt.Printf("Executing Python runtime: %s %s\n", pb.pythonPath, strings.Join(cmdArgs, " "))
+		fmt.Printf("Executing Python runtime: %s %s\n", pb.executable(), strings.Join(cmdArgs, " "))
+	}
+
+	command := exec.CommandContext(ctx, pb.executable(), cmdArgs...)
+	// Python buffers output written to a pipe, which would defeat streaming
+	command.Env = append(os.Environ(), "PYTHONUNBUFFERED=1")
+	for name, value := range pb.env {
+		command.Env = append(command.Env, name+"="+value)
+	}
+	// On cancellation let the runtime clean up before it is killed
+	command.Cancel = func() error { return interruptProcess(command.Process) }
+	command.WaitDelay = cancelGracePeriod
+	stderr := &tailBuffer{limit: maxStderrBytes}
+	command.Stderr = stderr
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output pipe: %v", err)
 	}
 
 	// Execute Python runtime command
-	output, err := exec.Command(pb.pythonPath, cmdArgs...).Output()
+	start := time.Now()
+	if err := command.Start(); err != nil {
+		slog.Error("python command failed to start", "command", cmd, "error", err)
+		return &ExecStats{}, commandError(err, nil)
+	}
+
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			onLine(line)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	err = command.Wait()
+	stats := &ExecStats{Duration: time.Since(start)}
+	if command.ProcessState != nil {
+		stats.MaxRSSKB = maxRSSKB(command.ProcessState)
+	}
+	if ctx.Err() != nil {
+		slog.Warn("python command cancelled", "command", cmd, "args", strings.Join(args, " "), "duration", stats.Duration, "reason", ctx.Err())
+		return stats, contextError(ctx.Err())
+	}
 	if err != nil {
-		return nil, fmt.Errorf("Python command failed: %v", err)
+		failure := commandError(err, stderr.Bytes())
+		failure.LogFile = pb.writeDiagnostics(cmdArgs, err, stderr.Bytes())
+		slog.Error("python command failed", "command", cmd, "args", strings.Join(args, " "), "duration", stats.Duration, "error", failure.Message, "traceback", failure.Details)
+		return stats, failure
+	}
+
+	slog.Debug("python command completed", "command", cmd, "args", strings.Join(args, " "), "duration", stats.Duration)
+	return stats, nil
+}
+
+// commandError converts a failed Python invocation into a structured error,
+// using the runtime's error message from stderr when it reported one
+func commandError(err error, stderr []byte) *clierr.Error {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return clierr.Wrap(err, clierr.CategoryBridge, "RUNTIME_MISSING", "Python interpreter not found").
+			WithHint("Install Python 3 or set python_path in the UPID configuration")
+	}
+
+	if message, details := parseStderr(stderr); message != "" {
+		failure := clierr.Classify(message)
+		failure.Details = details
+		return failure
+	}
+	return clierr.Wrap(err, clierr.CategoryGeneral, "COMMAND_FAILED", "Python command failed")
+}
+
+// parseStderr splits the runtime's standard error into the error message,
+// taken from the last line, and the output before it, which starts at the
+// last traceback when there is one
+func parseStderr(stderr []byte) (message, details string) {
+	text := strings.TrimSpace(string(stderr))
+	if text == "" {
+		return "", ""
 	}
 
-	return output, nil
+	lines := strings.Split(text, "\n")
+	last := strings.TrimSpace(lines[len(lines)-1])
+	message = strings.TrimPrefix(last, "Error: ")
+
+	details = strings.Join(lines[:len(lines)-1], "\n")
+	if i := strings.LastIndex(text, "Traceback (most recent call last):"); i >= 0 {
+		details = text[i:]
+		if strings.HasPrefix(last, "Error: ") {
+			// Drop our own error line, keep the exception line
+			details = strings.TrimSpace(strings.TrimSuffix(details, last))
+		}
+	}
+	return message, details
+}
+
+// contextError reports a command stopped by a timeout or interrupt
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return clierr.Wrap(err, clierr.CategoryTimeout, "TIMEOUT", "command timed out").
+			WithHint("Increase the limit with --timeout; output received so far is shown above")
+	}
+	return clierr.Wrap(err, clierr.CategoryInterrupted, "INTERRUPTED", "command interrupted").
+		WithHint("Output received so far is shown above")
 }
 
-// ExecuteCommandWithJSON executes a Python command and parses JSON response
-func (pb *PythonBridge) ExecuteCommandWithJSON(cmd string, args []string) (map[string]interface{}, error) {
-	output, err := pb.ExecuteCommand(cmd, args)
+// cancelGracePeriod is how long a cancelled runtime process may take to exit
+// before it is killed
+const cancelGracePeriod = 5 * time.Second
+
+// maxStderrBytes is how much of the runtime's standard error is kept
+const maxStderrBytes = 64 * 1024
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	buf   []byte
+	limit int
+}
+
+// Write implements io.Writer
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.limit {
+		t.buf = t.buf[len(t.buf)-t.limit:]
+	}
+	return len(p), nil
+}
+
+// Bytes returns the retained output
+func (t *tailBuffer) Bytes() []byte {
+	return t.buf
+}
+
+// CommandLine returns the exact invocation used for a Python command
+func (pb *PythonBridge) CommandLine(cmd string, args []string) string {
+	return pb.executable() + " " + strings.Join(pb.commandArgs(cmd, args), " ")
+}
+
+// SetRuntime makes the bridge invoke the given runtime
+func (pb *PythonBridge) SetRuntime(rt *pyruntime.Runtime) {
+	pb.runtime = rt
+}
+
+// Runtime returns the runtime the bridge invokes, nil if none was discovered
+func (pb *PythonBridge) Runtime() *pyruntime.Runtime {
+	return pb.runtime
+}
+
+// executable returns the program that runs the Python runtime
+func (pb *PythonBridge) executable() string {
+	if pb.runtime != nil {
+		return pb.runtime.Command
+	}
+	return pb.pythonPath
+}
+
+// commandArgs builds the interpreter arguments for a Python command
+func (pb *PythonBridge) commandArgs(cmd string, args []string) []string {
+	if pb.runtime != nil {
+		return pb.runtime.CommandLine(append([]string{cmd}, args...)...)
+	}
+	// Use the runtime bootstrap script instead of module
+	return append([]string{runtimeScript, cmd}, args...)
+}
+
+// ExecuteCommandWithJSON executes a Python command and parses JSON response.
+// The result is the last line of output; earlier lines are streamed to the
+// progress writer while the command runs.
+func (pb *PythonBridge) ExecuteCommandWithJSON(ctx context.Context, cmd string, args []string) (map[string]interface{}, error) {
+	args = append(args, "--format", "json")
+
+	var output []byte
+	err := pb.withRetry(ctx, cmd, func() error {
+		var ok bool
+		var err error
+		if output, _, ok, err = pb.tryDaemon(ctx, cmd, args); ok {
+			return err
+		}
+
+		output = nil
+		_, err = pb.run(ctx, cmd, args, func(line []byte) {
+			if output != nil && pb.progress != nil {
+				pb.progress.Write(output)
+			}
+			output = line
+		})
+		if err != nil && ctx.Err() != nil && output != nil && pb.progress != nil {
+			// Show everything received before the command was stopped
+			pb.progress.Write(output)
+		}
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -59,8 +350,8 @@ func (pb *PythonBridge) ExecuteCommandWithJSON(cmd string, args []string) (map[s
 }
 
 // ExecuteCommandWithTable executes a Python command and formats as table
-func (pb *PythonBridge) ExecuteCommandWithTable(cmd string, args []string) (string, error) {
-	output, err := pb.ExecuteCommand(cmd, append(args, "--format", "table"))
+func (pb *PythonBridge) ExecuteCommandWithTable(ctx context.Context, cmd string, args []string) (string, error) {
+	output, err := pb.ExecuteCommand(ctx, cmd, append(args, "--format", "table"))
 	if err != nil {
 		return "", err
 	}
@@ -69,14 +360,14 @@ func (pb *PythonBridge) ExecuteCommandWithTable(cmd string, args []string) (stri
 }
 
 // HealthCheck verifies Python bridge is working
-func (pb *PythonBridge) HealthCheck() error {
-	_, err := pb.ExecuteCommand("health", []string{"--check"})
+func (pb *PythonBridge) HealthCheck(ctx context.Context) error {
+	_, err := pb.ExecuteCommand(ctx, "health", []string{"--check"})
 	return err
 }
 
 // GetVersion gets the Python core version
-func (pb *PythonBridge) GetVersion() (string, error) {
-	output, err := pb.ExecuteCommand("version", []string{})
+func (pb *PythonBridge) GetVersion(ctx context.Context) (string, error) {
+	output, err := pb.ExecuteCommand(ctx, "version", []string{})
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,74 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// RetryPolicy controls retries of transient bridge failures. Permanent
+// failures such as bad arguments or authentication errors are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts; 1 disables retries
+	MaxAttempts int
+	// InitialDelay is the wait before the first retry
+	InitialDelay time.Duration
+	// MaxDelay caps the exponentially growing wait between retries
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy returns the policy used unless configured otherwise
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3, InitialDelay: 500 * time.Millisecond, MaxDelay: 10 * time.Second}
+}
+
+// NoRetry is a policy that runs every command exactly once
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// SetRetryPolicy sets how transient failures are retried
+func (pb *PythonBridge) SetRetryPolicy(policy RetryPolicy) {
+	pb.retry = policy
+}
+
+// delay returns the wait before retry number n (1-based), with jitter so
+// concurrent clients do not retry in lockstep
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := p.InitialDelay << (n - 1)
+	if d <= 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// withRetry calls fn until it succeeds, fails permanently, or the policy's
+// attempts are used up
+func (pb *PythonBridge) withRetry(ctx context.Context, cmd string, fn func() error) error {
+	attempts := pb.retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= attempts || !clierr.IsRetryable(err) {
+			return err
+		}
+
+		wait := pb.retry.delay(attempt)
+		slog.Warn("retrying python command", "command", cmd, "attempt", attempt+1, "max_attempts", attempts, "delay", wait, "error", err)
+		if pb.progress != nil {
+			fmt.Fprintf(pb.progress, "Retrying in %s (attempt %d/%d): %v\n", wait.Round(time.Millisecond), attempt+1, attempts, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
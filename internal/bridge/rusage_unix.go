@@ -0,0 +1,22 @@
+//go:build !windows
+
+package bridge
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// maxRSSKB returns the peak resident set size of a finished process in KiB
+func maxRSSKB(state *os.ProcessState) int64 {
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	// Darwin reports bytes, Linux reports kilobytes
+	if runtime.GOOS == "darwin" {
+		return int64(usage.Maxrss) / 1024
+	}
+	return int64(usage.Maxrss)
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package bridge
+
+import "os"
+
+// maxRSSKB is not available on Windows
+func maxRSSKB(state *os.ProcessState) int64 {
+	return 0
+}
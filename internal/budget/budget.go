@@ -0,0 +1,237 @@
+// Package budget keeps monthly cost budgets of a cluster or part of it, and
+// tracks what they spend against the thresholds that fire alerts. Spend is
+// accrued from the cost rate of the scope at each evaluation, so budgets are
+// only as accurate as they are evaluated often, e.g. by 'upid monitor watch'.
+package budget
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// Kinds of scopes
+const (
+	ScopeCluster    = "cluster"
+	ScopeNamespace  = "namespace"
+	ScopeLabel      = "label"
+	ScopeAnnotation = "annotation"
+)
+
+// rateMonth is the month of the cost rates of analyses
+const rateMonth = 30 * 24 * time.Hour
+
+// maxThreshold bounds the thresholds of alerts, in percent of the budget
+const maxThreshold = 1000
+
+// Scope is the part of a cluster a budget covers
+type Scope struct {
+	// Kind is cluster, namespace, label or annotation
+	Kind string
+	// Key is the label or annotation key
+	Key string
+	// Value is the namespace, or the label or annotation value
+	Value string
+}
+
+// ParseScope parses a scope given as cluster, namespace:<name>,
+// label:<key>=<value> or annotation:<key>=<value>
+func ParseScope(value string) (Scope, error) {
+	kind, rest, _ := strings.Cut(value, ":")
+	switch kind {
+	case ScopeCluster:
+		if rest == "" {
+			return Scope{Kind: kind}, nil
+		}
+	case ScopeNamespace:
+		if rest != "" && !strings.ContainsAny(rest, "=:/") {
+			return Scope{Kind: kind, Value: rest}, nil
+		}
+	case ScopeLabel, ScopeAnnotation:
+		if key, v, ok := strings.Cut(rest, "="); ok && key != "" && v != "" {
+			return Scope{Kind: kind, Key: key, Value: v}, nil
+		}
+	}
+	return Scope{}, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+		fmt.Sprintf("invalid scope %q (expected cluster, namespace:<name>, label:<key>=<value> or annotation:<key>=<value>)", value))
+}
+
+// String formats the scope as it is given on the command line
+func (s Scope) String() string {
+	switch {
+	case s.Key != "":
+		return s.Kind + ":" + s.Key + "=" + s.Value
+	case s.Value != "":
+		return s.Kind + ":" + s.Value
+	}
+	return s.Kind
+}
+
+// ParseThresholds parses percentages of a budget such as 80%, or 80, sorted
+// and without duplicates
+func ParseThresholds(values []string) ([]float64, error) {
+	var thresholds []float64
+	seen := map[float64]bool{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		threshold, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || threshold <= 0 || threshold > maxThreshold {
+			return nil, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+				fmt.Sprintf("invalid alert threshold %q (expected a percentage of the budget such as 80%%)", value))
+		}
+		if !seen[threshold] {
+			seen[threshold] = true
+			thresholds = append(thresholds, threshold)
+		}
+	}
+	sort.Float64s(thresholds)
+	return thresholds, nil
+}
+
+// Budget is the amount a scope of a cluster may cost in a calendar month
+type Budget struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+	// Context is the kubeconfig context of the cluster
+	Context string  `json:"context"`
+	Monthly float64 `json:"monthly"`
+	// AlertAt are the percentages of Monthly whose spending fires an alert,
+	// ascending
+	AlertAt []float64 `json:"alert_at"`
+	// Currency is that of the pricing model when the budget was created
+	Currency  string    `json:"currency"`
+	CreatedAt time.Time `json:"created_at"`
+	// Period is the spending of the current month, nil until the budget is
+	// first evaluated
+	Period *Period `json:"period,omitempty"`
+}
+
+// Period is the spending of a budget in a calendar month
+type Period struct {
+	// Month is the calendar month in UTC, e.g. 2024-05
+	Month string  `json:"month"`
+	Spent float64 `json:"spent"`
+	// Rate is the monthly cost of the scope at the last evaluation
+	Rate    float64   `json:"rate"`
+	Updated time.Time `json:"updated"`
+	// Alerted are the thresholds whose alert fired
+	Alerted []float64 `json:"alerted,omitempty"`
+	// Forecasted is set once the alert that the month is projected over
+	// budget fired
+	Forecasted bool `json:"forecasted,omitempty"`
+	// BurnDown is the spending at the last evaluation of each day, oldest
+	// first
+	BurnDown []Point `json:"burn_down,omitempty"`
+}
+
+// Point is the spending of a budget on a day
+type Point struct {
+	// Day is the date in UTC, e.g. 2024-05-03
+	Day   string  `json:"day"`
+	Spent float64 `json:"spent"`
+}
+
+// Alert is fired when a budget spends past a threshold, or is projected to
+// spend past itself by the end of the month
+type Alert struct {
+	Time   time.Time `json:"time"`
+	Budget string    `json:"budget"`
+	Scope  string    `json:"scope"`
+	// Threshold is the percentage of the budget spent, 0 for forecasts
+	Threshold float64 `json:"threshold,omitempty"`
+	Forecast  bool    `json:"forecast,omitempty"`
+	Spent     float64 `json:"spent"`
+	Projected float64 `json:"projected"`
+	Monthly   float64 `json:"monthly"`
+	Currency  string  `json:"currency"`
+}
+
+// Message describes the alert
+func (a Alert) Message() string {
+	if a.Forecast {
+		return fmt.Sprintf("budget %s is projected to spend %.2f of %.2f %s this month", a.Budget, a.Projected, a.Monthly, a.Currency)
+	}
+	return fmt.Sprintf("budget %s spent %.2f of %.2f %s this month, past %g%%", a.Budget, a.Spent, a.Monthly, a.Currency, a.Threshold)
+}
+
+// Evaluate accrues the spending of the budget until now at the monthly cost
+// rate of its scope, and returns the alerts that fire. The first evaluation
+// of a month assumes that the scope cost its current rate since the month
+// started.
+func (b *Budget) Evaluate(rate float64, now time.Time) []Alert {
+	now = now.UTC()
+	month := now.Format("2006-01")
+	if b.Period == nil || b.Period.Month != month {
+		b.Period = &Period{Month: month, Updated: monthStart(now)}
+	}
+	p := b.Period
+	if now.After(p.Updated) {
+		p.Spent += rate * now.Sub(p.Updated).Hours() / rateMonth.Hours()
+	}
+	p.Rate = rate
+	p.Updated = now
+	day := now.Format("2006-01-02")
+	if n := len(p.BurnDown); n > 0 && p.BurnDown[n-1].Day == day {
+		p.BurnDown[n-1].Spent = p.Spent
+	} else {
+		p.BurnDown = append(p.BurnDown, Point{Day: day, Spent: p.Spent})
+	}
+
+	var alerts []Alert
+	alert := Alert{Time: now, Budget: b.Name, Scope: b.Scope, Spent: p.Spent, Projected: b.Projected(), Monthly: b.Monthly, Currency: b.Currency}
+	for _, threshold := range b.AlertAt {
+		if p.Spent < b.Monthly*threshold/100 || p.alerted(threshold) {
+			continue
+		}
+		p.Alerted = append(p.Alerted, threshold)
+		alert.Threshold = threshold
+		alerts = append(alerts, alert)
+	}
+	if !p.Forecasted && p.Spent < b.Monthly && alert.Projected > b.Monthly {
+		p.Forecasted = true
+		alert.Threshold = 0
+		alert.Forecast = true
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+// Spent returns the spending of the current month, 0 if it was not
+// evaluated this month
+func (b *Budget) Spent(now time.Time) float64 {
+	if b.Period == nil || b.Period.Month != now.UTC().Format("2006-01") {
+		return 0
+	}
+	return b.Period.Spent
+}
+
+// Projected returns the spending of the month at its end if the scope keeps
+// costing the rate of the last evaluation
+func (b *Budget) Projected() float64 {
+	if b.Period == nil {
+		return 0
+	}
+	p := b.Period
+	left := monthStart(p.Updated).AddDate(0, 1, 0).Sub(p.Updated)
+	return p.Spent + p.Rate*left.Hours()/rateMonth.Hours()
+}
+
+// alerted reports whether the alert of a threshold fired this period
+func (p *Period) alerted(threshold float64) bool {
+	for _, t := range p.Alerted {
+		if t == threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// monthStart returns the start of the calendar month of t in UTC
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
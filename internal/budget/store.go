@@ -0,0 +1,97 @@
+package budget
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Store is the local file of budgets
+type Store struct {
+	path    string
+	Budgets []*Budget `json:"budgets"`
+}
+
+// Load reads the budgets file at path; a missing file holds no budgets
+func Load(path string) (*Store, error) {
+	store := &Store{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read budgets: %v", err)
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("invalid budgets file %s: %v", path, err)
+	}
+	return store, nil
+}
+
+// Path returns the location of the budgets file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Get returns the budget with a name, nil if there is none
+func (s *Store) Get(name string) *Budget {
+	for _, budget := range s.Budgets {
+		if budget.Name == name {
+			return budget
+		}
+	}
+	return nil
+}
+
+// Put adds a budget, or replaces the one with the same name
+func (s *Store) Put(budget *Budget) {
+	for i, existing := range s.Budgets {
+		if existing.Name == budget.Name {
+			s.Budgets[i] = budget
+			return
+		}
+	}
+	s.Budgets = append(s.Budgets, budget)
+	sort.Slice(s.Budgets, func(i, j int) bool { return s.Budgets[i].Name < s.Budgets[j].Name })
+}
+
+// Remove deletes the budget with a name and returns true if there was one
+func (s *Store) Remove(name string) bool {
+	for i, budget := range s.Budgets {
+		if budget.Name == name {
+			s.Budgets = append(s.Budgets[:i], s.Budgets[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Save replaces the budgets file, readable only by the user
+func (s *Store) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode budgets: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	// Write to a temporary file first so that a monitor reading the file
+	// never sees it half written
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write budgets: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write budgets: %v", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write budgets: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Package cache keeps command results on disk so that repeated invocations
+// within a time-to-live are answered without running the analysis again.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Store is a directory of cached results, one file per key
+type Store struct {
+	dir string
+	ttl time.Duration
+}
+
+// New creates a store in dir whose entries expire after ttl
+func New(dir string, ttl time.Duration) *Store {
+	return &Store{dir: dir, ttl: ttl}
+}
+
+// Key derives a cache key from the parts identifying a result
+func Key(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the data stored under key and when it was stored. Expired
+// entries are removed and reported as missing.
+func (s *Store) Get(key string) ([]byte, time.Time, bool) {
+	path := s.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	if time.Since(info.ModTime()) > s.ttl {
+		os.Remove(path)
+		return nil, time.Time{}, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	return data, info.ModTime(), true
+}
+
+// Put stores data under key, replacing any previous entry
+func (s *Store) Put(key string, data []byte) error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	// Write to a temporary file first so readers never see partial entries
+	tmp, err := os.CreateTemp(s.dir, key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+	return nil
+}
+
+// Clear removes all entries and returns how many were removed
+func (s *Store) Clear() (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cache directory: %v", err)
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil {
+			return removed, fmt.Errorf("failed to remove cache entry: %v", err)
+		}
+		if strings.HasSuffix(entry.Name(), ".json") {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// Dir returns the directory holding the entries
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// path returns the file holding the entry for key
+func (s *Store) path(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
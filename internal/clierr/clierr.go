@@ -0,0 +1,275 @@
+// Package clierr defines the structured errors returned by UPID commands and
+// the exit code used for each class of failure.
+//
+// Exit codes:
+//
+//	0  success
+//	1  general failure
+//	2  invalid usage (unknown command or flag, bad argument)
+//	3  authentication failure
+//	4  Python runtime missing or unusable
+//	5  Kubernetes cluster unreachable
+//	6  partial results (the command completed but some parts failed)
+//	7  timed out (--timeout reached)
+//	8  drift from an approved baseline detected
+//	130 interrupted (Ctrl-C)
+package clierr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// Category is a class of failure with its own exit code
+type Category string
+
+// Failure categories
+const (
+	CategoryGeneral     Category = "general"
+	CategoryUsage       Category = "usage"
+	CategoryAuth        Category = "auth"
+	CategoryBridge      Category = "bridge"
+	CategoryUnreachable Category = "unreachable"
+	CategoryPartial     Category = "partial"
+	CategoryTimeout     Category = "timeout"
+	CategoryDrift       Category = "drift"
+	CategoryInterrupted Category = "interrupted"
+)
+
+// Exit codes per category
+const (
+	ExitOK          = 0
+	ExitGeneral     = 1
+	ExitUsage       = 2
+	ExitAuth        = 3
+	ExitBridge      = 4
+	ExitUnreachable = 5
+	ExitPartial     = 6
+	ExitTimeout     = 7
+	ExitDrift       = 8
+	ExitInterrupted = 130
+)
+
+var exitCodes = map[Category]int{
+	CategoryGeneral:     ExitGeneral,
+	CategoryUsage:       ExitUsage,
+	CategoryAuth:        ExitAuth,
+	CategoryBridge:      ExitBridge,
+	CategoryUnreachable: ExitUnreachable,
+	CategoryPartial:     ExitPartial,
+	CategoryTimeout:     ExitTimeout,
+	CategoryDrift:       ExitDrift,
+	CategoryInterrupted: ExitInterrupted,
+}
+
+// Error is a structured CLI error
+type Error struct {
+	// Code is a stable machine-readable identifier, e.g. AUTH_FAILED
+	Code string `json:"code"`
+	// Category determines the exit code
+	Category Category `json:"category"`
+	// Message describes what went wrong
+	Message string `json:"message"`
+	// Hint suggests how to fix the problem, if known
+	Hint string `json:"hint,omitempty"`
+	// Retryable is true for transient failures that may succeed when retried
+	Retryable bool `json:"retryable"`
+	// Details holds diagnostic output such as a Python traceback, shown in
+	// debug mode
+	Details string `json:"details,omitempty"`
+	// LogFile is a diagnostic log with the full failure output, if written
+	LogFile string `json:"log_file,omitempty"`
+	// Err is the underlying error, if any
+	Err error `json:"-"`
+}
+
+// New creates a structured error
+func New(category Category, code, message string) *Error {
+	return &Error{Code: code, Category: category, Message: message}
+}
+
+// Wrap creates a structured error caused by err
+func Wrap(err error, category Category, code, message string) *Error {
+	return &Error{Code: code, Category: category, Message: message, Err: err}
+}
+
+// WithHint sets the hint shown with the error
+func (e *Error) WithHint(hint string) *Error {
+	e.Hint = hint
+	return e
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if e.Err != nil && !strings.Contains(e.Message, e.Err.Error()) {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the process exit code for the error
+func (e *Error) ExitCode() int {
+	if code, ok := exitCodes[e.Category]; ok {
+		return code
+	}
+	return ExitGeneral
+}
+
+// From returns err as a structured error. Errors that are not already
+// structured are classified by their type, then by their message, without
+// the patterns of Classify that only runtime failures are known by.
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		if err != e {
+			// Keep the context added by wrapping
+			wrapped := *e
+			wrapped.Message = err.Error()
+			wrapped.Err = nil
+			return &wrapped
+		}
+		return e
+	}
+
+	message := err.Error()
+	if isUsageError(message) {
+		return &Error{Code: "INVALID_USAGE", Category: CategoryUsage, Message: message, Hint: "Run with --help to see usage"}
+	}
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		e = &Error{Code: "EXECUTABLE_NOT_FOUND", Category: CategoryGeneral, Message: message, Hint: "Install it or add its directory to PATH"}
+	case errors.Is(err, fs.ErrNotExist):
+		e = &Error{Code: "FILE_NOT_FOUND", Category: CategoryUsage, Message: message, Hint: "Check the path of the file"}
+	case errors.Is(err, fs.ErrPermission):
+		e = &Error{Code: "PERMISSION_DENIED", Category: CategoryGeneral, Message: message, Hint: "Check the permissions of the file"}
+	default:
+		e = classify(message, false)
+	}
+	e.Retryable = isTransient(strings.ToLower(message))
+	return e
+}
+
+// Classify builds a structured error from a failure message reported by the
+// Python runtime, on its standard error or by the daemon
+func Classify(message string) *Error {
+	e := classify(message, true)
+	e.Retryable = isTransient(strings.ToLower(message))
+	return e
+}
+
+// IsRetryable reports whether err is a transient failure worth retrying
+func IsRetryable(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Retryable
+}
+
+// transientStatus matches the HTTP statuses of throttling and unavailable
+// servers where a message reports a status, as in "HTTP 503" or "status
+// code: 429", and not wherever the digits appear
+var transientStatus = regexp.MustCompile(`\b(?:http(?:/[0-9.]+)?|status|code|error)[\s:=(]*(?:code[\s:=(]*)?(?:429|502|503|504)\b`)
+
+// isTransient reports whether a lower-cased failure message describes a
+// temporary condition, such as a dropped connection or API throttling
+func isTransient(lower string) bool {
+	return containsAny(lower,
+		"connection reset", "broken pipe", "unexpected eof", "i/o timeout",
+		"too many requests", "throttl", "rate limit",
+		"temporarily unavailable", "service unavailable", "bad gateway", "gateway timeout",
+	) || transientStatus.MatchString(lower)
+}
+
+// classify builds a structured error from a failure message. A missing
+// runtime and an unreachable cluster are only told from the messages of the
+// runtime, which reports them as text.
+func classify(message string, runtime bool) *Error {
+	lower := strings.ToLower(message)
+	switch {
+	case containsAny(lower, "not logged in", "unauthorized", "invalid credentials", "authentication", "token expired", "forbidden"):
+		return &Error{Code: "AUTH_FAILED", Category: CategoryAuth, Message: message, Hint: "Run 'upid auth login' and try again"}
+	case runtime && containsAny(lower, "no module named", "module not available", "can't open file", "executable file not found", "no such file or directory"):
+		return &Error{Code: "RUNTIME_MISSING", Category: CategoryBridge, Message: message, Hint: "Install the runtime with 'upid system runtime install' or check it with 'upid system runtime info'"}
+	case runtime && containsAny(lower, "connection refused", "unable to connect", "no route to host", "i/o timeout", "kubeconfig", "max retries exceeded", "cluster unreachable"):
+		return &Error{Code: "CLUSTER_UNREACHABLE", Category: CategoryUnreachable, Message: message, Hint: "Check cluster access with 'kubectl cluster-info'"}
+	case containsAny(lower, "unknown command", "unknown analyze command", "unknown optimize command", "invalid argument"):
+		return &Error{Code: "INVALID_USAGE", Category: CategoryUsage, Message: message, Hint: "Run with --help to see usage"}
+	case isTransient(lower):
+		return &Error{Code: "TRANSIENT_FAILURE", Category: CategoryGeneral, Message: message, Hint: "The failure may be temporary, try again later"}
+	}
+	return &Error{Code: "COMMAND_FAILED", Category: CategoryGeneral, Message: message}
+}
+
+// isUsageError reports whether message is a cobra/pflag usage error
+func isUsageError(message string) bool {
+	return containsAny(message,
+		"unknown command", "unknown flag", "unknown shorthand flag",
+		"flag needs an argument", "invalid argument", "accepts ",
+		"requires at least", "requires at most", "required flag",
+	)
+}
+
+func containsAny(s string, substrings ...string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintOptions controls how errors are printed
+type PrintOptions struct {
+	// JSON prints the error as a JSON document
+	JSON bool
+	// Debug includes diagnostic details such as tracebacks
+	Debug bool
+}
+
+// Print writes err to w, as JSON or text as requested
+func Print(w io.Writer, err error, opts PrintOptions) {
+	e := From(err)
+	if e == nil {
+		return
+	}
+	if !opts.Debug && e.Details != "" {
+		copied := *e
+		copied.Details = ""
+		e = &copied
+		defer fmt.Fprintln(w, "Run with --debug to see the full Python traceback")
+	}
+
+	if opts.JSON {
+		out, jsonErr := json.MarshalIndent(struct {
+			Error    *Error `json:"error"`
+			ExitCode int    `json:"exit_code"`
+		}{e, e.ExitCode()}, "", "  ")
+		if jsonErr == nil {
+			fmt.Fprintln(w, string(out))
+			return
+		}
+	}
+
+	if e.Details != "" {
+		fmt.Fprintln(w, strings.TrimRight(e.Details, "\n"))
+	}
+	fmt.Fprintf(w, "Error: %s\n", e.Error())
+	if e.Hint != "" {
+		fmt.Fprintf(w, "Hint: %s\n", e.Hint)
+	}
+	if e.LogFile != "" {
+		fmt.Fprintf(w, "Diagnostics: %s\n", e.LogFile)
+	}
+}
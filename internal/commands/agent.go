@@ -0,0 +1,419 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
+	"github.com/spf13/cobra"
+)
+
+// defaultAgentNamespace is the namespace the agent is installed in
+const defaultAgentNamespace = "upid-system"
+
+// minAgentInterval keeps the agent from loading the API server
+const minAgentInterval = 10 * time.Second
+
+// AgentCmd creates the agent command
+func AgentCmd() *cobra.Command {
+	agentCmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Manage the in-cluster agent",
+		Long: `Manage the UPID agent, a lightweight collector that runs in the cluster and
+continuously gathers usage metrics, so that analyses have history without a
+separate metrics datasource.
+
+The agent pushes its metrics to the UPID enterprise platform, or keeps them
+in the cluster with the local target. One agent runs per cluster.`,
+		Example: `  # Install the agent, keeping metrics in the cluster
+  upid agent install
+
+  # Install the agent pushing to the enterprise platform
+  export ` + apiKeyEnv + `=<key>
+  upid agent install --target enterprise --endpoint https://api.upid.io
+
+  # Preview the manifests without installing
+  upid agent install --dry-run
+
+  # Install the agent with the optimization policy controller
+  upid agent install --controller`,
+	}
+
+	agentCmd.AddCommand(agentInstallCmd())
+	agentCmd.AddCommand(agentStatusCmd())
+	agentCmd.AddCommand(agentUninstallCmd())
+	agentCmd.AddCommand(withColumns(agentControllerCmd(), policyActionColumns))
+
+	return agentCmd
+}
+
+// agentInstallCmd creates the agent install command
+func agentInstallCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install the agent in the cluster",
+		Long: `Install the agent in the cluster of the current kubeconfig context: a
+Deployment with read-only RBAC and its configuration in a ConfigMap. Running
+it again updates the installed agent.
+
+The enterprise target authenticates with an API key, from --api-key,
+--api-key-stdin or $` + apiKeyEnv + `, which is stored in a Secret. Create one with
+'upid auth apikey create'.
+
+With --controller, the OptimizationPolicy resource is installed too, and the
+agent enforces the policies declared with it, see 'upid agent controller'.
+
+With --dry-run, the manifests are printed as YAML instead of applied.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return agentInstall(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", defaultAgentNamespace, "namespace to install the agent in")
+	cmd.Flags().StringP("context", "x", "", "kubernetes context (default current context)")
+	cmd.Flags().String("image", "", "agent image (default "+native.AgentImage+":<version>)")
+	cmd.Flags().String("target", native.AgentTargetLocal, "where the agent pushes metrics ("+strings.Join(native.AgentTargets, ", ")+")")
+	cmd.Flags().String("endpoint", "", "UPID API endpoint of the enterprise target (default the configured endpoint)")
+	cmd.Flags().String("api-key", "", "API key of the enterprise target (default $"+apiKeyEnv+")")
+	cmd.Flags().Bool("api-key-stdin", false, "read the API key from standard input")
+	cmd.Flags().String("interval", "1m", "interval between two collections")
+	cmd.Flags().String("cluster-name", "", "name the agent reports metrics under (default the context name)")
+	cmd.Flags().Bool("controller", false, "also run the optimization policy controller")
+
+	return mutating(cmd)
+}
+
+// agentStatusCmd creates the agent status command
+func agentStatusCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the status of the agent",
+		Long:  "Show whether the agent is installed and running, and where it pushes metrics",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return agentStatus(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", defaultAgentNamespace, "namespace the agent is installed in")
+	cmd.Flags().StringP("context", "x", "", "kubernetes context (default current context)")
+
+	return cmd
+}
+
+// agentUninstallCmd creates the agent uninstall command
+func agentUninstallCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Remove the agent from the cluster",
+		Long: `Remove the agent and its RBAC, configuration and Secret from the cluster.
+The namespace is deleted too if it was created by 'upid agent install'.
+
+The OptimizationPolicy resource is kept, since deleting it would delete all
+policies; workloads scaled by policies keep their current replicas.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return agentUninstall(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", defaultAgentNamespace, "namespace the agent is installed in")
+	cmd.Flags().StringP("context", "x", "", "kubernetes context (default current context)")
+
+	return mutating(cmd)
+}
+
+// policyActionColumns are the columns of the actions of the policy
+// controller
+var policyActionColumns = []output.Column{
+	{Name: "policy", Field: "policy"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "kind", Field: "kind"},
+	{Name: "name", Field: "name"},
+	{Name: "action", Field: "action"},
+	{Name: "replicas", Field: "replicas", Wide: true},
+	{Name: "confidence", Field: "confidence", Wide: true},
+}
+
+// agentControllerCmd creates the agent controller command
+func agentControllerCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "controller",
+		Short: "Enforce optimization policies continuously",
+		Long: `Enforce the OptimizationPolicy resources of the cluster until stopped. The
+agent runs it when installed with --controller; it can also run anywhere
+with access to the cluster.
+
+A policy names the namespaces it applies to. Within its schedule, it scales
+the workloads whose pods are all idle to zero, holding at most maxDisruption
+workloads (a count or a percentage of the Deployments and StatefulSets of
+its namespaces) at zero at once. Outside of its schedule, it restores the
+workloads it scaled. The outcome is reported in the status of each policy.
+
+  apiVersion: upid.io/v1alpha1
+  kind: OptimizationPolicy
+  metadata:
+    name: dev-off-hours
+  spec:
+    namespaces: [dev, staging]
+    idle:
+      minConfidence: 0.9
+      window: 24h
+    schedule:
+      - days: [Mon, Tue, Wed, Thu, Fri]
+        start: "19:00"
+        end: "07:00"
+      - days: [Sat, Sun]
+        start: "00:00"
+        end: "00:00"
+    timezone: Europe/Berlin
+    maxDisruption: 50%
+
+A window whose end is not after its start ends the next day. The idle window
+applies when a metrics datasource is configured.
+
+Each pass prints the workloads that were scaled or restored; with --once, a
+single pass is made and reported in full. With --dry-run, nothing is changed.`,
+		Example: `  # Show what the policies would do now
+  upid agent controller --once --dry-run
+
+  # Enforce the policies every 5 minutes
+  upid agent controller --interval 5m`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return agentController(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("context", "x", "", "kubernetes context (default current context)")
+	cmd.Flags().String("interval", "1m", "interval between two enforcements")
+	cmd.Flags().Bool("once", false, "enforce the policies once and exit")
+
+	return mutating(cmd)
+}
+
+// Implementation functions
+func agentInstall(cmd *cobra.Command, args []string) error {
+	// Get flags
+	namespace, _ := cmd.Flags().GetString("namespace")
+	kubeContext, _ := cmd.Flags().GetString("context")
+	image, _ := cmd.Flags().GetString("image")
+	target, _ := cmd.Flags().GetString("target")
+	endpoint, _ := cmd.Flags().GetString("endpoint")
+	interval, _ := cmd.Flags().GetString("interval")
+	cluster, _ := cmd.Flags().GetString("cluster-name")
+	controller, _ := cmd.Flags().GetBool("controller")
+
+	opts := native.AgentOptions{Namespace: namespace, Image: image, Cluster: cluster, Target: strings.ToLower(target), Controller: controller}
+	if opts.Image == "" {
+		opts.Image = native.AgentImage + ":" + config.GetVersion()
+	}
+	every, err := timeutil.ParseDuration(interval)
+	if err != nil || every < minAgentInterval {
+		return fmt.Errorf("invalid interval %q (expected a duration of at least %s, e.g. 1m)", interval, minAgentInterval)
+	}
+	opts.Interval = every
+
+	switch opts.Target {
+	case native.AgentTargetLocal:
+	case native.AgentTargetEnterprise:
+		opts.Endpoint = endpoint
+		if opts.Endpoint == "" {
+			opts.Endpoint = config.GetEndpoint()
+		}
+		if opts.Endpoint == "" {
+			return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "the enterprise target requires an endpoint").
+				WithHint("Pass --endpoint or set it with 'upid config set endpoint <url>'")
+		}
+		key, err := secretFlag(cmd, "api-key")
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			key = os.Getenv(apiKeyEnv)
+		}
+		if key == "" {
+			return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "the enterprise target requires an API key").
+				WithHint("Create one with 'upid auth apikey create upid-agent' and pass it with --api-key-stdin or $" + apiKeyEnv)
+		}
+		opts.APIKey = key
+	default:
+		return fmt.Errorf("invalid target %q (expected %s)", target, strings.Join(native.AgentTargets, ", "))
+	}
+
+	if IsDryRun() {
+		if opts.Cluster == "" {
+			opts.Cluster = "<context name>"
+		}
+		if opts.APIKey != "" {
+			opts.APIKey = "<redacted>"
+		}
+		data, err := manifestYAML(native.AgentManifests(opts))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Dry run: no changes were made. Would apply:")
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	return executeBuiltin(cmd.Context(), "agent", func(ctx context.Context) (map[string]interface{}, error) {
+		client, err := native.NewClient(kubeContext)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Cluster == "" {
+			opts.Cluster = client.Context()
+		}
+		return client.InstallAgent(ctx, opts)
+	})
+}
+
+func agentStatus(cmd *cobra.Command, args []string) error {
+	// Get flags
+	namespace, _ := cmd.Flags().GetString("namespace")
+	kubeContext, _ := cmd.Flags().GetString("context")
+
+	return executeBuiltin(cmd.Context(), "agent", func(ctx context.Context) (map[string]interface{}, error) {
+		client, err := native.NewClient(kubeContext)
+		if err != nil {
+			return nil, err
+		}
+		return client.AgentStatus(ctx, namespace)
+	})
+}
+
+func agentUninstall(cmd *cobra.Command, args []string) error {
+	// Get flags
+	namespace, _ := cmd.Flags().GetString("namespace")
+	kubeContext, _ := cmd.Flags().GetString("context")
+
+	return executeBuiltin(cmd.Context(), "agent", func(ctx context.Context) (map[string]interface{}, error) {
+		client, err := native.NewClient(kubeContext)
+		if err != nil {
+			return nil, err
+		}
+		return client.UninstallAgent(ctx, namespace, IsDryRun())
+	})
+}
+
+func agentController(cmd *cobra.Command, args []string) error {
+	// Get flags
+	kubeContext, _ := cmd.Flags().GetString("context")
+	interval, _ := cmd.Flags().GetString("interval")
+	once, _ := cmd.Flags().GetBool("once")
+
+	every, err := timeutil.ParseDuration(interval)
+	if err != nil || every < minAgentInterval {
+		return fmt.Errorf("invalid interval %q (expected a duration of at least %s, e.g. 1m)", interval, minAgentInterval)
+	}
+	format := config.GetOutputFormat()
+	if !once && format != output.FormatTable && format != output.FormatWide && format != output.FormatJSON {
+		return fmt.Errorf("agent controller supports table, wide and json output, not %q (use --once for other formats)", format)
+	}
+
+	client, err := native.NewClient(kubeContext)
+	if err != nil {
+		return err
+	}
+	history, err := datasource()
+	if err != nil {
+		return err
+	}
+	if history != nil {
+		client.SetHistory(history)
+	}
+	excluded, err := exclusions()
+	if err != nil {
+		return err
+	}
+	client.SetExclusions(excluded)
+	rails, err := guardrails("")
+	if err != nil {
+		return err
+	}
+	client.SetGuardrails(rails)
+
+	if once {
+		return executeBuiltin(cmd.Context(), "agent", func(ctx context.Context) (map[string]interface{}, error) {
+			return client.EnforcePolicies(ctx, time.Now(), IsDryRun())
+		})
+	}
+
+	ctx := cmd.Context()
+	if quiet, _ := activeFlagBool("quiet"); !quiet {
+		fmt.Fprintf(os.Stderr, "Enforcing optimization policies in context %s every %s, press Ctrl+C to stop\n", client.Context(), interval)
+	}
+	emit := printPolicyAction(format)
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for pass := 1; ; pass++ {
+		result, err := client.EnforcePolicies(ctx, time.Now(), IsDryRun())
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return nil
+		case err != nil && pass == 1:
+			// A cluster or resource that is missing from the start is a
+			// misconfiguration rather than a transient failure
+			return err
+		case err != nil:
+			slog.Warn("policy enforcement failed, retrying", "error", err)
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		default:
+			workloads, _ := result["items"].([]interface{})
+			for _, w := range workloads {
+				if item, ok := w.(map[string]interface{}); ok && item["skipped"] != true {
+					emit(item)
+				}
+			}
+			if errs, ok := result["errors"].([]interface{}); ok {
+				for _, e := range errs {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", e)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// printPolicyAction returns a function that prints the actions of the policy
+// controller as they are taken, as JSON lines or as rows under a table
+// header printed with the first row
+func printPolicyAction(format string) func(map[string]interface{}) {
+	if format == output.FormatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		return func(item map[string]interface{}) {
+			item["time"] = time.Now().UTC().Format(time.RFC3339)
+			_ = encoder.Encode(item)
+		}
+	}
+
+	const row = "%-8s  %-20s  %-16s  %s\n"
+	header := false
+	return func(item map[string]interface{}) {
+		if !header {
+			fmt.Printf(row, "TIME", "POLICY", "NAMESPACE", "ACTION")
+			header = true
+		}
+		fmt.Printf(row, time.Now().Format("15:04:05"), item["policy"], item["namespace"], item["action"])
+	}
+}
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"github.com/kubilitics/upid-cli/internal/config"
 	"github.com/spf13/cobra"
 )
 
@@ -37,9 +38,9 @@ func aiInsightsCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("time-range", "t", "30d", "time range for analysis")
-	cmd.Flags().BoolP("detailed", "d", false, "detailed insights")
+	cmd.Flags().Bool("detailed", false, "detailed insights")
 
-	return cmd
+	return cacheable(cmd)
 }
 
 // aiRecommendationsCmd creates the recommendations command
@@ -54,10 +55,10 @@ func aiRecommendationsCmd() *cobra.Command {
 	}
 
 	// Add flags
-	cmd.Flags().StringP("category", "c", "", "recommendation category")
+	cmd.Flags().String("category", "", "recommendation category")
 	cmd.Flags().BoolP("prioritized", "p", false, "prioritized recommendations")
 
-	return cmd
+	return cacheable(cmd)
 }
 
 // aiPredictCmd creates the predict command
@@ -73,9 +74,9 @@ func aiPredictCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("timeframe", "t", "30d", "prediction timeframe")
-	cmd.Flags().StringP("cluster", "c", "", "cluster name")
+	cmd.Flags().String("cluster", "", "cluster name")
 
-	return cmd
+	return cacheable(cmd)
 }
 
 // aiExplainCmd creates the explain command
@@ -93,12 +94,12 @@ func aiExplainCmd() *cobra.Command {
 	cmd.Flags().StringP("namespace", "n", "", "namespace")
 	cmd.Flags().StringP("time-range", "t", "24h", "time range")
 
-	return cmd
+	return cacheable(cmd)
 }
 
 // Implementation functions
 func aiInsights(cmd *cobra.Command, args []string) error {
-	clusterName := "default"
+	clusterName := config.GetDefaultCluster()
 	if len(args) > 0 {
 		clusterName = args[0]
 	}
@@ -116,11 +117,11 @@ func aiInsights(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--detailed")
 	}
 
-	return executePythonCommand("ai", cmdArgs)
+	return executePythonCommand(cmd.Context(), "ai", cmdArgs)
 }
 
 func aiRecommendations(cmd *cobra.Command, args []string) error {
-	clusterName := "default"
+	clusterName := config.GetDefaultCluster()
 	if len(args) > 0 {
 		clusterName = args[0]
 	}
@@ -138,7 +139,7 @@ func aiRecommendations(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--prioritized")
 	}
 
-	return executePythonCommand("ai", cmdArgs)
+	return executePythonCommand(cmd.Context(), "ai", cmdArgs)
 }
 
 func aiPredict(cmd *cobra.Command, args []string) error {
@@ -160,7 +161,7 @@ func aiPredict(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--cluster", cluster)
 	}
 
-	return executePythonCommand("ai", cmdArgs)
+	return executePythonCommand(cmd.Context(), "ai", cmdArgs)
 }
 
 func aiExplain(cmd *cobra.Command, args []string) error {
@@ -182,5 +183,5 @@ func aiExplain(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--time-range", timeRange)
 	}
 
-	return executePythonCommand("ai", cmdArgs)
+	return executePythonCommand(cmd.Context(), "ai", cmdArgs)
 } 
\ No newline at end of file
@@ -0,0 +1,436 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/notify"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
+	"github.com/spf13/cobra"
+)
+
+// Statuses of open alerts
+const (
+	alertFiring       = "firing"
+	alertAcknowledged = "acknowledged"
+	alertSilenced     = "silenced"
+)
+
+// alertColumns are the table columns for open alerts
+var alertColumns = []output.Column{
+	{Name: "id", Field: "id"},
+	{Name: "severity", Field: "severity"},
+	{Name: "status", Field: "status"},
+	{Name: "context", Field: "context"},
+	{Name: "title", Field: "title"},
+	{Name: "opened", Field: "opened"},
+	{Name: "acknowledged by", Field: "acknowledged_by", Wide: true},
+	{Name: "key", Field: "key", Wide: true},
+}
+
+// silenceColumns are the table columns for silences
+var silenceColumns = []output.Column{
+	{Name: "id", Field: "id"},
+	{Name: "status", Field: "status"},
+	{Name: "matchers", Field: "matchers"},
+	{Name: "starts", Field: "starts_at"},
+	{Name: "ends", Field: "ends_at"},
+	{Name: "created by", Field: "created_by", Wide: true},
+	{Name: "comment", Field: "comment"},
+}
+
+// monitorAlertsAckCmd creates the alerts ack command
+func monitorAlertsAckCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ack <id>...",
+		Short: "Acknowledge open alerts as being handled",
+		Long: `Acknowledge open alerts, given by the ID or key shown by 'upid monitor
+alerts', to mark them as being handled. Acknowledging posts to the
+notification channels of the alert, so that PagerDuty and Opsgenie stop
+escalating the incident and Slack and webhooks show who handles it. The
+alert stays open until its condition clears.`,
+		Example: `  upid monitor alerts ack 3f9a1c2e`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return monitorAlertsAck(cmd, args)
+		},
+	}
+
+	return mutating(cmd)
+}
+
+// monitorSilenceCmd creates the silence command
+func monitorSilenceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "silence",
+		Short: "Hold back alerts during planned events",
+		Long: `Silence the alerts that match all the matchers of a silence for its
+duration, e.g. during planned maintenance or a batch run. Silenced alerts
+are not posted to notification channels; incidents raised while silenced
+are posted if their condition remains when the silence ends. Matchers are
+<label>=<value>, with the operators = and != or the anchored regular
+expressions of =~ and !~, over the labels:
+
+  context     kubeconfig context of the cluster
+  namespace   namespace of the alert, empty for those of the cluster
+  severity    info, warning or critical
+  kind        alert
+  key         deduplication key of the alert, e.g. upid/prod/anomaly/...
+  title       title of the alert
+
+Silences are kept in silences.json in the state directory and shown by
+'upid monitor alerts'.`,
+	}
+
+	// Add subcommands
+	cmd.AddCommand(monitorSilenceCreateCmd())
+	cmd.AddCommand(withColumns(monitorSilenceListCmd(), silenceColumns))
+	cmd.AddCommand(monitorSilenceExpireCmd())
+
+	return cmd
+}
+
+// monitorSilenceCreateCmd creates the silence create command
+func monitorSilenceCreateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Silence the alerts matching all matchers for a duration",
+		Example: `  upid monitor silence create --matcher namespace=batch --duration 8h --comment "Nightly reprocessing"
+  upid monitor silence create --matcher context=staging --matcher severity!=critical --duration 2d
+  upid monitor silence create --matcher 'key=~upid/prod/anomaly/.*' --start 2026-11-02T22:00:00Z --duration 4h`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return monitorSilenceCreate(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringArray("matcher", nil, "label the silenced alerts match, as <label>=<value>, != , =~ or !~ (repeatable)")
+	cmd.Flags().String("duration", "2h", "how long the silence lasts")
+	cmd.Flags().String("start", "", "when the silence starts, as RFC 3339 (default now)")
+	cmd.Flags().String("comment", "", "why the alerts are silenced")
+	_ = cmd.MarkFlagRequired("matcher")
+
+	return mutating(cmd)
+}
+
+// monitorSilenceListCmd creates the silence list command
+func monitorSilenceListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the silences that are active or pending",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return monitorSilenceList(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().Bool("all", false, "include the silences that ended")
+
+	return cmd
+}
+
+// monitorSilenceExpireCmd creates the silence expire command
+func monitorSilenceExpireCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "expire <id>...",
+		Short: "End silences now",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return monitorSilenceExpire(cmd, args)
+		},
+	}
+
+	return mutating(cmd)
+}
+
+// Implementation functions
+
+func monitorAlerts(cmd *cobra.Command, args []string) error {
+	// Get flags
+	timeRange, _ := cmd.Flags().GetString("time-range")
+	severity, _ := cmd.Flags().GetString("severity")
+
+	severity = strings.ToLower(severity)
+	if severity != "" && kube.SeverityRank(severity) < 0 {
+		return fmt.Errorf("invalid severity %q (expected %s)", severity, strings.Join(kube.Severities, ", "))
+	}
+	var since time.Time
+	if timeRange != "" {
+		window, err := timeutil.ParseDuration(timeRange)
+		if err != nil || window <= 0 {
+			return fmt.Errorf("invalid --time-range %q (expected a duration such as 24h)", timeRange)
+		}
+		since = time.Now().Add(-window)
+	}
+
+	incidents, err := notify.LoadIncidents(incidentsFile())
+	if err != nil {
+		return err
+	}
+	silences, err := notify.LoadSilences(silencesFile())
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	alerts := []interface{}{}
+	for _, incident := range incidents.Open {
+		if (len(args) > 0 && incident.Source != args[0]) ||
+			kube.SeverityRank(incident.Severity) < kube.SeverityRank(severity) || incident.Opened.Before(since) {
+			continue
+		}
+		status := alertFiring
+		switch {
+		case incident.AcknowledgedAt != nil:
+			status = alertAcknowledged
+		case incident.Silenced || silences.Matching(incident.Event(), now) != nil:
+			status = alertSilenced
+		}
+		alerts = append(alerts, map[string]interface{}{
+			"id":              incident.ID(),
+			"key":             incident.Key,
+			"severity":        incident.Severity,
+			"status":          status,
+			"context":         incident.Source,
+			"title":           incident.Title,
+			"opened":          incident.Opened.Local().Format(time.RFC3339),
+			"acknowledged_by": incident.AcknowledgedBy,
+		})
+	}
+	items := []interface{}{}
+	for _, silence := range silences.Silences {
+		if now.Before(silence.EndsAt) {
+			items = append(items, silenceItem(silence, now))
+		}
+	}
+
+	message := fmt.Sprintf("%d open alerts, %d silences", len(alerts), len(items))
+	return renderSections(map[string]interface{}{
+		"message":  message,
+		"alerts":   alerts,
+		"silences": items,
+	}, []section{{"alerts", alertColumns}, {"silences", silenceColumns}})
+}
+
+func monitorAlertsAck(cmd *cobra.Command, args []string) error {
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("acknowledge alerts %s and post it to their notification channels", strings.Join(args, ", ")))
+	}
+	store, err := notify.Load(channelsFile())
+	if err != nil {
+		return err
+	}
+	incidents, err := notify.LoadIncidents(incidentsFile())
+	if err != nil {
+		return err
+	}
+	for _, id := range args {
+		if incidents.Find(id) == nil {
+			return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("no open alert %q", id)).
+				WithHint("See the open alerts with 'upid monitor alerts'")
+		}
+	}
+
+	by := localUser()
+	notifier := channelNotifier(store)
+	acknowledged := []interface{}{}
+	var messages []interface{}
+	for _, id := range args {
+		incident, changed, errs := notifier.Acknowledge(cmd.Context(), incidents, id, by)
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		if changed {
+			acknowledged = append(acknowledged, incident.ID())
+		}
+	}
+	if len(acknowledged) > 0 {
+		if err := incidents.Save(); err != nil {
+			return err
+		}
+	}
+
+	result := map[string]interface{}{
+		"message":         fmt.Sprintf("Acknowledged %d alerts as %s", len(acknowledged), by),
+		"acknowledged":    acknowledged,
+		"acknowledged_by": by,
+	}
+	if len(acknowledged) < len(args) {
+		result["hint"] = "Alerts already acknowledged are left as they are"
+	}
+	if len(messages) > 0 {
+		result["partial"] = true
+		result["errors"] = messages
+	}
+	if err := renderResult(result); err != nil {
+		return err
+	}
+	printResultWarning(result)
+	return partialResultError(result)
+}
+
+func monitorSilenceCreate(cmd *cobra.Command, args []string) error {
+	// Get flags
+	matcherFlags, _ := cmd.Flags().GetStringArray("matcher")
+	durationFlag, _ := cmd.Flags().GetString("duration")
+	startFlag, _ := cmd.Flags().GetString("start")
+	comment, _ := cmd.Flags().GetString("comment")
+
+	matchers := make([]notify.Matcher, 0, len(matcherFlags))
+	for _, value := range matcherFlags {
+		matcher, err := notify.ParseMatcher(value)
+		if err != nil {
+			return err
+		}
+		matchers = append(matchers, matcher)
+	}
+	duration, err := timeutil.ParseDuration(durationFlag)
+	if err != nil || duration <= 0 {
+		return fmt.Errorf("invalid --duration %q (expected a duration such as 8h)", durationFlag)
+	}
+	start := time.Now()
+	if startFlag != "" {
+		if start, err = time.Parse(time.RFC3339, startFlag); err != nil {
+			return fmt.Errorf("invalid --start %q (expected a time such as 2026-11-02T22:00:00Z)", startFlag)
+		}
+	}
+	silence := &notify.Silence{
+		Matchers:  matchers,
+		Comment:   comment,
+		CreatedBy: localUser(),
+		StartsAt:  start.UTC(),
+		EndsAt:    start.Add(duration).UTC(),
+	}
+	if !time.Now().Before(silence.EndsAt) {
+		return fmt.Errorf("invalid silence: it ends at %s, in the past", silence.EndsAt.Local().Format(time.RFC3339))
+	}
+
+	silences, err := notify.LoadSilences(silencesFile())
+	if err != nil {
+		return err
+	}
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("silence alerts matching %s until %s in %s",
+			matcherText(matchers), silence.EndsAt.Local().Format(time.RFC3339), silences.Path()))
+	}
+	if err := silences.Add(silence); err != nil {
+		return fmt.Errorf("failed to create silence: %v", err)
+	}
+	if err := silences.Save(); err != nil {
+		return err
+	}
+	result := silenceItem(silence, time.Now())
+	result["message"] = fmt.Sprintf("Silenced alerts matching %s until %s", matcherText(matchers), silence.EndsAt.Local().Format(time.RFC3339))
+	result["hint"] = fmt.Sprintf("End it early with 'upid monitor silence expire %s'", silence.ID)
+	return renderResult(result)
+}
+
+func monitorSilenceList(cmd *cobra.Command, args []string) error {
+	// Get flags
+	all, _ := cmd.Flags().GetBool("all")
+
+	silences, err := notify.LoadSilences(silencesFile())
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	items := make([]interface{}, 0, len(silences.Silences))
+	for _, silence := range silences.Silences {
+		if all || now.Before(silence.EndsAt) {
+			items = append(items, silenceItem(silence, now))
+		}
+	}
+	return renderResult(map[string]interface{}{
+		"message":  fmt.Sprintf("%d silences", len(items)),
+		"file":     silences.Path(),
+		"silences": items,
+	})
+}
+
+func monitorSilenceExpire(cmd *cobra.Command, args []string) error {
+	silences, err := notify.LoadSilences(silencesFile())
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, id := range args {
+		silence := silences.Get(id)
+		if silence == nil {
+			return fmt.Errorf("no silence %q, see 'upid monitor silence list'", id)
+		}
+		if !now.Before(silence.EndsAt) {
+			return fmt.Errorf("silence %s already ended at %s", id, silence.EndsAt.Local().Format(time.RFC3339))
+		}
+	}
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("end silences %s in %s", strings.Join(args, ", "), silences.Path()))
+	}
+	for _, id := range args {
+		silence := silences.Get(id)
+		silence.EndsAt = now.UTC()
+		if silence.StartsAt.After(silence.EndsAt) {
+			silence.StartsAt = silence.EndsAt
+		}
+	}
+	if err := silences.Save(); err != nil {
+		return err
+	}
+	return renderResult(map[string]interface{}{
+		"message": fmt.Sprintf("Ended %d silences; alerts they held back are posted when raised again", len(args)),
+	})
+}
+
+// silenceItem returns the result of a silence, with its status at a time
+func silenceItem(silence *notify.Silence, now time.Time) map[string]interface{} {
+	status := "active"
+	switch {
+	case now.Before(silence.StartsAt):
+		status = "pending"
+	case !now.Before(silence.EndsAt):
+		status = "expired"
+	}
+	return map[string]interface{}{
+		"id":         silence.ID,
+		"status":     status,
+		"matchers":   matcherText(silence.Matchers),
+		"starts_at":  silence.StartsAt.Local().Format(time.RFC3339),
+		"ends_at":    silence.EndsAt.Local().Format(time.RFC3339),
+		"created_by": silence.CreatedBy,
+		"comment":    silence.Comment,
+	}
+}
+
+// matcherText formats matchers as they are given on the command line
+func matcherText(matchers []notify.Matcher) string {
+	parts := make([]string, len(matchers))
+	for i, m := range matchers {
+		parts[i] = m.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// localUser returns the name of the user running UPID, who creates
+// silences and acknowledges alerts
+func localUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// silencesFile returns the local file of silences
+func silencesFile() string {
+	return filepath.Join(config.GetStateDir(), "silences.json")
+}
@@ -1,9 +1,24 @@
 package commands
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/kubilitics/upid-cli/internal/billing"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // AnalyzeCmd creates the analyze command
@@ -17,7 +32,15 @@ Examples:
   upid analyze cluster                    # Analyze entire cluster
   upid analyze pod my-pod --namespace default  # Analyze specific pod
   upid analyze idle --confidence 0.85    # Find idle workloads
-  upid analyze resources --time-range 24h # Analyze resource usage`,
+  upid analyze resources --time-range 24h # Analyze resource usage
+  upid analyze autoscaling               # Check HPAs and VPAs
+  upid analyze workload deployment/web   # Right-size one workload
+  upid analyze network                   # Traffic, cross-zone and egress costs
+  upid analyze diff --from 2024-05 --to 2024-06  # Compare two months
+  upid analyze baseline check            # Detect drift from an approved baseline
+  upid analyze binpack                   # Nodes that consolidation would remove
+  upid analyze scheduling -t 24h         # Pending pods, crashloops, OOM kills
+  upid analyze allocation --by label:team  # Cluster cost per team`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return analyzeCluster(cmd, args)
 		},
@@ -30,8 +53,16 @@ Examples:
 	analyzeCmd.AddCommand(analyzeResourcesCmd())
 	analyzeCmd.AddCommand(analyzeCostCmd())
 	analyzeCmd.AddCommand(analyzePerformanceCmd())
+	analyzeCmd.AddCommand(analyzeAutoscalingCmd())
+	analyzeCmd.AddCommand(analyzeWorkloadCmd())
+	analyzeCmd.AddCommand(analyzeNetworkCmd())
+	analyzeCmd.AddCommand(analyzeDiffCmd())
+	analyzeCmd.AddCommand(analyzeBaselineCmd())
+	analyzeCmd.AddCommand(analyzeBinpackCmd())
+	analyzeCmd.AddCommand(analyzeSchedulingCmd())
+	analyzeCmd.AddCommand(analyzeAllocationCmd())
 
-	return analyzeCmd
+	return cacheable(analyzeCmd)
 }
 
 // analyzeClusterCmd creates the cluster analysis command
@@ -48,10 +79,10 @@ func analyzeClusterCmd() *cobra.Command {
 	// Add flags
 	cmd.Flags().StringP("namespace", "n", "", "namespace to analyze")
 	cmd.Flags().StringP("time-range", "t", "24h", "time range for analysis")
-	cmd.Flags().BoolP("detailed", "d", false, "detailed analysis")
-	cmd.Flags().BoolP("include-costs", "c", false, "include cost analysis")
+	cmd.Flags().Bool("detailed", false, "detailed analysis")
+	cmd.Flags().Bool("include-costs", false, "include cost analysis")
 
-	return cmd
+	return cacheable(cmd)
 }
 
 // analyzePodCmd creates the pod analysis command
@@ -70,7 +101,23 @@ func analyzePodCmd() *cobra.Command {
 	cmd.Flags().StringP("namespace", "n", "default", "namespace of the pod")
 	cmd.Flags().StringP("time-range", "t", "24h", "time range for analysis")
 
-	return cmd
+	return cacheable(cmd)
+}
+
+// idleColumns are the table columns for idle workload results
+var idleColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "kind", Field: "workload_type", Wide: true},
+	{Name: "cpu", Header: "CPU %", Field: "cpu_usage_percent"},
+	{Name: "memory", Header: "MEMORY %", Field: "memory_usage_percent"},
+	{Name: "idle", Header: "IDLE HOURS", Field: "idle_duration_hours"},
+	{Name: "confidence", Field: "confidence"},
+	{Name: "savings", Header: "MONTHLY SAVINGS", Field: "potential_savings_monthly"},
+	{Name: "network", Header: "NETWORK KIB/S", Field: "network_kib_s", Wide: true},
+	{Name: "last-activity", Field: "last_activity", Wide: true},
+	{Name: "risk", Field: "risk_assessment", Wide: true},
+	{Name: "recommendation", Field: "recommendation", Wide: true},
 }
 
 // analyzeIdleCmd creates the idle analysis command
@@ -85,11 +132,11 @@ func analyzeIdleCmd() *cobra.Command {
 	}
 
 	// Add flags
-	cmd.Flags().Float64P("confidence", "c", 0.85, "confidence threshold")
+	cmd.Flags().Float64("confidence", 0.85, "confidence threshold")
 	cmd.Flags().StringP("time-range", "t", "7d", "time range for analysis")
-	cmd.Flags().BoolP("include-health-checks", "h", true, "include health check filtering")
+	cmd.Flags().Bool("include-health-checks", true, "include health check filtering")
 
-	return cmd
+	return cacheable(withColumns(cmd, idleColumns))
 }
 
 // analyzeResourcesCmd creates the resource analysis command
@@ -97,7 +144,13 @@ func analyzeResourcesCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "resources [resource-type]",
 		Short: "Analyze resource usage",
-		Long:  "Analyze CPU, memory, and network usage patterns",
+		Long: `Analyze CPU, memory, and network usage patterns.
+
+With a datasource (see 'upid config datasource') the average, 95th
+percentile and peak usage over --time-range are reported, including network
+traffic. Otherwise current usage is read from metrics-server (the
+metrics.k8s.io API). On clusters without it the requests and limits of
+running pods are reported, with a warning that usage is unknown.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return analyzeResources(cmd, args)
 		},
@@ -107,7 +160,47 @@ func analyzeResourcesCmd() *cobra.Command {
 	cmd.Flags().StringP("time-range", "t", "24h", "time range for analysis")
 	cmd.Flags().StringP("namespace", "n", "", "namespace to analyze")
 
-	return cmd
+	return cacheable(cmd)
+}
+
+// costSections are the lists of a cost analysis with their table columns,
+// in the order they are shown
+var costSections = []section{
+	{"breakdown", []output.Column{
+		{Name: "category", Field: "category"},
+		{Name: "resources", Field: "resources"},
+		{Name: "billed", Field: "billed"},
+		{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+		{Name: "list-cost", Header: "LIST COST", Field: "list_cost"},
+	}},
+	{"nodes", []output.Column{
+		{Name: "name", Field: "name"},
+		{Name: "instance-type", Field: "instance_type"},
+		{Name: "zone", Field: "zone", Wide: true},
+		{Name: "resource-id", Header: "INSTANCE ID", Field: "resource_id", Wide: true},
+		{Name: "pricing", Field: "pricing"},
+		{Name: "covered", Header: "COVERED %", Field: "covered_percent", Wide: true},
+		{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+		{Name: "list-cost", Header: "LIST COST", Field: "list_cost"},
+	}},
+	{"volumes", []output.Column{
+		{Name: "name", Field: "name"},
+		{Name: "claim", Field: "claim"},
+		{Name: "storage-class", Field: "storage_class", Wide: true},
+		{Name: "size", Header: "SIZE GIB", Field: "size_gib"},
+		{Name: "resource-id", Header: "VOLUME ID", Field: "resource_id", Wide: true},
+		{Name: "pricing", Field: "pricing"},
+		{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+		{Name: "list-cost", Header: "LIST COST", Field: "list_cost"},
+	}},
+	{"load_balancers", []output.Column{
+		{Name: "namespace", Field: "namespace"},
+		{Name: "name", Field: "name"},
+		{Name: "load-balancer", Field: "load_balancer", Wide: true},
+		{Name: "pricing", Field: "pricing"},
+		{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+		{Name: "list-cost", Header: "LIST COST", Field: "list_cost"},
+	}},
 }
 
 // analyzeCostCmd creates the cost analysis command
@@ -115,7 +208,45 @@ func analyzeCostCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "cost [cluster-name]",
 		Short: "Analyze cluster costs",
-		Long:  "Analyze cost breakdown and optimization opportunities",
+		Long: `Analyze cost breakdown and optimization opportunities.
+
+With list pricing, the default, the analysis runs in the Python runtime if
+it is installed. Otherwise, and with billed pricing, the nodes, persistent
+volumes and load balancers of the current kubeconfig context are priced by
+the built-in implementation: nodes by their capacity at --cpu-price and
+--memory-price, volumes by their size at --storage-price and load balancers
+at --load-balancer-price.
+
+List prices are those of the pricing model in config, which on-premises and
+air-gapped clusters set to their own rates and currency:
+
+  upid config set pricing.cpu 0.021       # per vCPU-hour
+  upid config set pricing.memory 0.003    # per GiB-hour of memory
+  upid config set pricing.gpu 1.10        # per GPU-hour
+  upid config set pricing.storage 0.05    # per GiB-month of volumes
+  upid config set pricing.currency EUR
+
+Billed pricing replaces list prices with what AWS billed for the EC2
+instances, EBS volumes and load balancers of the cluster over --time-range,
+amortized so that usage covered by Reserved Instances and Savings Plans is
+priced at its effective rate:
+
+  aws-cur            reads a Cost and Usage Report in CSV format from S3 or
+                     a local file (pricing.cur), which must include resource
+                     IDs
+  aws-cost-explorer  queries Cost Explorer for the last 14 days at most,
+                     which needs resource-level data enabled
+
+AWS credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+or from the profile pricing.aws_profile of the shared credentials file.
+Billed costs are in US dollars, so the pricing model of resources missing
+from the billing data should be too.
+
+Examples:
+  upid analyze cost --detailed                      # Cost of each node, volume and load balancer
+  upid config set pricing.cur s3://billing/cur/k8s/20240501-20240601/k8s-Manifest.json
+  upid analyze cost --pricing aws-cur -t 30d        # Billed prices from the report
+  upid analyze cost --pricing aws-cost-explorer     # Billed prices of the last 14 days`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return analyzeCost(cmd, args)
 		},
@@ -123,9 +254,15 @@ func analyzeCostCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("time-range", "t", "30d", "time range for analysis")
-	cmd.Flags().BoolP("detailed", "d", false, "detailed cost breakdown")
+	cmd.Flags().Bool("detailed", false, "detailed cost breakdown")
+	cmd.Flags().String("pricing", "", "where prices come from: list, aws-cur or aws-cost-explorer (default pricing.source)")
+	cmd.Flags().String("cur", "", "s3:// URL or path of a Cost and Usage Report manifest or CSV file (default pricing.cur)")
+	cmd.Flags().Float64("cpu-price", 0, "list price per core-hour of node capacity (default pricing.cpu)")
+	cmd.Flags().Float64("memory-price", 0, "list price per GiB-hour of node capacity (default pricing.memory)")
+	cmd.Flags().Float64("storage-price", 0, "list price per GiB-month of persistent volumes (default pricing.storage)")
+	cmd.Flags().Float64("load-balancer-price", native.DefaultLoadBalancerPrice, "list price per hour of a load balancer")
 
-	return cmd
+	return cacheable(cmd)
 }
 
 // analyzePerformanceCmd creates the performance analysis command
@@ -141,14 +278,517 @@ func analyzePerformanceCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("time-range", "t", "24h", "time range for analysis")
-	cmd.Flags().BoolP("detailed", "d", false, "detailed performance analysis")
+	cmd.Flags().Bool("detailed", false, "detailed performance analysis")
+
+	return cacheable(cmd)
+}
+
+// autoscalingColumns are the table columns for autoscaler analysis results
+var autoscalingColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "kind", Field: "kind"},
+	{Name: "target", Field: "target", Wide: true},
+	{Name: "status", Field: "status"},
+	{Name: "replicas", Field: "replicas"},
+	{Name: "min", Field: "min_replicas"},
+	{Name: "max", Field: "max_replicas"},
+	{Name: "cpu", Header: "CPU %", Field: "current_utilization"},
+	{Name: "cpu-target", Header: "CPU TARGET %", Field: "target_utilization"},
+	{Name: "recommended-min", Field: "recommended_min_replicas", Wide: true},
+	{Name: "recommended-max", Field: "recommended_max_replicas", Wide: true},
+	{Name: "recommended-target", Header: "RECOMMENDED TARGET %", Field: "recommended_target_utilization", Wide: true},
+	{Name: "recommendation", Field: "recommendation"},
+	{Name: "finding", Field: "finding", Wide: true},
+}
+
+// analyzeAutoscalingCmd creates the autoscaling analysis command
+func analyzeAutoscalingCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "autoscaling",
+		Short: "Analyze HPAs and VPAs",
+		Long: `Inspect the HorizontalPodAutoscalers and VerticalPodAutoscalers of a cluster
+for autoscalers that never scale, stay pinned at maxReplicas, are
+misconfigured, or conflict with each other, and recommend minReplicas,
+maxReplicas and target CPU utilization for each HPA.
+
+HPAs that stayed at minReplicas for the whole --time-range at a fraction of
+their target are reported as never scaling. --export writes the HPAs with
+changed settings as YAML manifests that can be reviewed and applied with
+'kubectl apply -f'. The analysis reads the Kubernetes API directly and does
+not need the Python runtime.
+
+Examples:
+  upid analyze autoscaling                          # All namespaces
+  upid analyze autoscaling -n shop -o wide          # Show recommended values
+  upid analyze autoscaling --export hpa.yaml        # Write recommended HPAs`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return analyzeAutoscaling(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", "", "namespace to analyze (default all namespaces)")
+	cmd.Flags().StringP("time-range", "t", "7d", "time range an HPA must not have scaled in to be reported as never scaling")
+	cmd.Flags().StringP("export", "e", "", "write recommended HPA manifests as YAML to this file (- for stdout)")
+
+	return withColumns(cmd, autoscalingColumns)
+}
+
+// workloadSections are the lists of a workload analysis with their table
+// columns, in the order they are shown
+var workloadSections = []section{
+	{"recommendations", []output.Column{
+		{Name: "resource", Field: "resource"},
+		{Name: "action", Field: "action"},
+		{Name: "current", Field: "current"},
+		{Name: "suggested", Field: "suggested"},
+		{Name: "change", Header: "CHANGE %", Field: "change_percent"},
+		{Name: "reason", Field: "reason"},
+	}},
+	{"timeline", []output.Column{
+		{Name: "time", Field: "time"},
+		{Name: "pods", Field: "pods"},
+		{Name: "cpu", Header: "CPU", Field: "cpu_usage"},
+		{Name: "cpu-percent", Header: "CPU %", Field: "cpu_usage_percent"},
+		{Name: "memory", Header: "MEMORY MIB", Field: "memory_usage_mib"},
+		{Name: "memory-percent", Header: "MEMORY %", Field: "memory_usage_percent"},
+	}},
+	{"pods", []output.Column{
+		{Name: "name", Field: "name"},
+		{Name: "phase", Field: "phase"},
+		{Name: "node", Field: "node", Wide: true},
+		{Name: "restarts", Field: "restarts"},
+		{Name: "cpu", Header: "CPU", Field: "cpu_usage"},
+		{Name: "cpu-percent", Header: "CPU %", Field: "cpu_usage_percent"},
+		{Name: "cpu-p95", Header: "CPU P95", Field: "cpu_p95", Wide: true},
+		{Name: "memory", Header: "MEMORY MIB", Field: "memory_usage_mib"},
+		{Name: "memory-percent", Header: "MEMORY %", Field: "memory_usage_percent"},
+		{Name: "memory-max", Header: "MEMORY MAX MIB", Field: "memory_max_mib", Wide: true},
+		{Name: "created", Field: "created", Wide: true},
+	}},
+	{"restart_history", []output.Column{
+		{Name: "pod", Field: "pod"},
+		{Name: "container", Field: "container"},
+		{Name: "restarts", Field: "restarts"},
+		{Name: "reason", Field: "reason"},
+		{Name: "exit-code", Field: "exit_code"},
+		{Name: "last-restart", Field: "last_restart"},
+	}},
+	{"events", []output.Column{
+		{Name: "last-seen", Field: "last_seen"},
+		{Name: "object", Field: "object"},
+		{Name: "reason", Field: "reason"},
+		{Name: "count", Field: "count"},
+		{Name: "message", Field: "message"},
+	}},
+}
+
+// analyzeWorkloadCmd creates the workload analysis command
+func analyzeWorkloadCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "workload <kind>/<name>",
+		Short: "Analyze all pods of a workload",
+		Long: `Aggregate the pods of a Deployment, StatefulSet, DaemonSet, Job or CronJob:
+their usage against requests, the restarts of their containers and the
+warning events of the last hour, and suggest right-sized requests per pod.
+
+With a configured datasource the usage is shown over --time-range and the
+CPU suggestion fits the 95th percentile and the memory suggestion the peak
+of the busiest pod. Without one the current usage from metrics-server is
+used and the suggestion has low confidence. The analysis reads the
+Kubernetes API directly and does not need the Python runtime.
+
+Kinds may be abbreviated as deploy, sts, ds and cj.
+
+Examples:
+  upid analyze workload deployment/web                  # Namespace default
+  upid analyze workload sts/db -n shop -t 7d            # Usage over a week
+  upid analyze workload cronjob/report -n shop -o json  # Full result`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return analyzeWorkload(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", "default", "namespace of the workload")
+	cmd.Flags().StringP("time-range", "t", "24h", "time range of usage history, with a datasource")
+
+	return cacheable(cmd)
+}
+
+// networkColumns are the table columns for network traffic results
+var networkColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "kind", Field: "kind", Wide: true},
+	{Name: "pods", Field: "pods", Wide: true},
+	{Name: "zones", Field: "zones", Wide: true},
+	{Name: "received", Header: "RECEIVED GB", Field: "received_gb"},
+	{Name: "sent", Header: "SENT GB", Field: "sent_gb"},
+	{Name: "receive-rate", Header: "RECEIVE KIB/S", Field: "receive_kib_s", Wide: true},
+	{Name: "transmit-rate", Header: "TRANSMIT KIB/S", Field: "transmit_kib_s", Wide: true},
+	{Name: "cross-zone", Header: "CROSS-ZONE GB", Field: "cross_zone_gb"},
+	{Name: "egress", Header: "EGRESS GB", Field: "egress_gb"},
+	{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+}
+
+// analyzeNetworkCmd creates the network traffic analysis command
+func analyzeNetworkCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "network",
+		Short: "Analyze network traffic and its cost",
+		Long: `Report the bytes each workload received and sent over --time-range, from
+the cAdvisor metrics of the configured datasource.
+
+With Istio sidecar metrics (istio_request_bytes, istio_response_bytes and
+istio_tcp_*_bytes_total) the traffic between workloads is attributed to
+availability zones and traffic to peers outside the mesh is counted as
+egress. Both are priced per GB and projected to a month. Cross-zone traffic
+is estimated from the zones of the pods on both sides, assuming requests
+spread evenly over them. Cilium's Hubble metrics count flows but not bytes,
+so on Cilium clusters without Istio only cAdvisor traffic is reported.
+
+The same traffic keeps 'analyze idle' and 'optimize zero-pod' from
+reporting pods that use little CPU but serve or move data as idle. The
+analysis needs a datasource and does not need the Python runtime.
+
+Examples:
+  upid analyze network                              # All namespaces, last 24h
+  upid analyze network -n shop -t 7d -o wide        # Show zones and rates
+  upid analyze network --cross-zone-price 0.01      # Price per GB between zones`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return analyzeNetwork(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", "", "namespace to analyze (default all namespaces)")
+	cmd.Flags().StringP("time-range", "t", "24h", "time range of traffic")
+	cmd.Flags().Float64("cross-zone-price", native.DefaultNetworkPrices.CrossZone, "price per GB of traffic between zones, both directions")
+	cmd.Flags().Float64("egress-price", native.DefaultNetworkPrices.Egress, "price per GB of traffic leaving the cluster")
+
+	return cacheable(withColumns(cmd, networkColumns))
+}
+
+// diffColumns are the table columns for period comparison results
+var diffColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "changes", Field: "changes"},
+	{Name: "from-cost", Header: "FROM COST", Field: "from_monthly_cost"},
+	{Name: "to-cost", Header: "TO COST", Field: "to_monthly_cost"},
+	{Name: "cost-change", Header: "CHANGE", Field: "cost_change"},
+	{Name: "cost-change-percent", Header: "CHANGE %", Field: "cost_change_percent", Wide: true},
+	{Name: "from-cpu", Header: "FROM CPU %", Field: "from_cpu_efficiency"},
+	{Name: "to-cpu", Header: "TO CPU %", Field: "to_cpu_efficiency"},
+	{Name: "from-memory", Header: "FROM MEMORY %", Field: "from_memory_efficiency", Wide: true},
+	{Name: "to-memory", Header: "TO MEMORY %", Field: "to_memory_efficiency", Wide: true},
+	{Name: "from-cores", Header: "FROM CORES", Field: "from_cpu_cores", Wide: true},
+	{Name: "to-cores", Header: "TO CORES", Field: "to_cpu_cores", Wide: true},
+}
+
+// analyzeDiffCmd creates the period comparison command
+func analyzeDiffCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Compare two time periods",
+		Long: `Compare the usage of workloads between two periods from the configured
+datasource and report what got more expensive, which workloads regressed in
+efficiency, and which became idle, appeared or disappeared.
+
+Periods are a month (2024-05), a day (2024-05-10), a range of days
+(2024-05-01..2024-05-15) or a time range ending now (7d). Without --from the
+period of the same length right before --to is used. Dates are in UTC.
+
+Costs are those of the requested CPU and memory, read from kube-state-metrics,
+at --cpu-price and --memory-price, projected to a month so that periods of
+different lengths compare. Efficiency is the share of requests used. Only
+workloads with changes are listed. The analysis needs a datasource and does
+not need the Python runtime.
+
+Examples:
+  upid analyze diff                                  # Last 7 days against the 7 before
+  upid analyze diff --from 2024-05 --to 2024-06      # May against June
+  upid analyze diff --to 30d -n shop -o wide         # Last 30 days in one namespace`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return analyzeDiff(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("from", "", "earlier period (default the period of the same length before --to)")
+	cmd.Flags().String("to", "7d", "later period")
+	cmd.Flags().StringP("namespace", "n", "", "namespace to analyze (default all namespaces)")
+	cmd.Flags().Float64("cpu-price", 0, "price per requested core-hour (default pricing.cpu)")
+	cmd.Flags().Float64("memory-price", 0, "price per requested GiB-hour (default pricing.memory)")
+
+	return cacheable(withColumns(cmd, diffColumns))
+}
+
+// defaultBaselineFile is where baselines are saved and checked, meant to be
+// committed next to the manifests they approve
+const defaultBaselineFile = "upid-baseline.json"
+
+// baselineColumns are the table columns for saved baselines
+var baselineColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "kind", Field: "kind"},
+	{Name: "replicas", Field: "replicas"},
+	{Name: "cpu", Header: "CPU REQUEST", Field: "cpu_request"},
+	{Name: "memory", Header: "MEMORY REQUEST MIB", Field: "memory_request_mib"},
+	{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+}
+
+// driftColumns are the table columns of the workloads that drifted from a
+// baseline
+var driftColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "kind", Field: "kind", Wide: true},
+	{Name: "drift", Field: "drift"},
+	{Name: "baseline-replicas", Field: "baseline_replicas", Wide: true},
+	{Name: "replicas", Field: "replicas", Wide: true},
+	{Name: "baseline-cpu", Header: "BASELINE CPU", Field: "baseline_cpu_request"},
+	{Name: "cpu", Header: "CPU", Field: "cpu_request"},
+	{Name: "baseline-memory", Header: "BASELINE MEMORY MIB", Field: "baseline_memory_request_mib"},
+	{Name: "memory", Header: "MEMORY MIB", Field: "memory_request_mib"},
+	{Name: "baseline-cost", Header: "BASELINE COST", Field: "baseline_monthly_cost"},
+	{Name: "cost", Header: "COST", Field: "monthly_cost"},
+}
+
+// analyzeBaselineCmd creates the baseline command
+func analyzeBaselineCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "baseline",
+		Short: "Save and check an approved resource and cost profile",
+		Long: `Save the replicas, pod requests and cost of the workloads of a cluster as an
+approved baseline, and later check the cluster against it for drift: new
+workloads, inflated requests and cost growth.
+
+'check' exits with code 8 when it finds drift, so it can gate CI pipelines.
+Baselines are JSON files meant to be reviewed and committed. Both commands
+read the Kubernetes API directly and do not need the Python runtime.
+
+Examples:
+  upid analyze baseline save -n shop                # Approve the current profile
+  upid analyze baseline check                       # Fail on drift
+  upid analyze baseline check --tolerance 20        # Allow 20% growth`,
+	}
+
+	cmd.AddCommand(analyzeBaselineSaveCmd())
+	cmd.AddCommand(analyzeBaselineCheckCmd())
+	return cmd
+}
+
+// analyzeBaselineSaveCmd creates the baseline save command
+func analyzeBaselineSaveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "save",
+		Short: "Save the current profile as the baseline",
+		Long: `Record the replicas, requests per pod and monthly cost of the Deployments,
+StatefulSets, DaemonSets and CronJobs of a namespace, or all namespaces, in a
+baseline file. Costs are those of the requests at --cpu-price and
+--memory-price, which 'check' reuses.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return analyzeBaselineSave(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("file", "f", defaultBaselineFile, "baseline file to write")
+	cmd.Flags().StringP("namespace", "n", "", "namespace to capture (default all namespaces)")
+	cmd.Flags().Float64("cpu-price", 0, "price per requested core-hour (default pricing.cpu)")
+	cmd.Flags().Float64("memory-price", 0, "price per requested GiB-hour (default pricing.memory)")
+
+	return withColumns(cmd, baselineColumns)
+}
+
+// analyzeBaselineCheckCmd creates the baseline check command
+func analyzeBaselineCheckCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check the cluster for drift from the baseline",
+		Long: `Compare the workloads of the cluster with a saved baseline. New workloads,
+requests per pod or workload costs that grew by more than --tolerance
+percent, and a total cost that grew by more than it are drift, and make the
+command exit with code 8. Removed workloads are reported but are not drift.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return analyzeBaselineCheck(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("file", "f", defaultBaselineFile, "baseline file to check against")
+	cmd.Flags().Float64("tolerance", 10, "growth in percent allowed before it counts as drift")
 
 	return cmd
 }
 
+// binpackColumns are the table columns for node consolidation simulations
+var binpackColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "instance-type", Field: "instance_type", Wide: true},
+	{Name: "zone", Field: "zone", Wide: true},
+	{Name: "pods", Field: "pods"},
+	{Name: "requested", Header: "REQUESTED %", Field: "requested_percent"},
+	{Name: "action", Field: "action"},
+	{Name: "reason", Field: "reason"},
+	{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+}
+
+// analyzeBinpackCmd creates the node consolidation command
+func analyzeBinpackCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "binpack",
+		Short: "Simulate repacking pods onto fewer or different nodes",
+		Long: `Simulate repacking the running pods of the cluster by their requests, and
+report how many nodes could be removed and the monthly savings.
+
+By default nodes are drained one at a time, least requested first, and are
+removable if their pods fit on the remaining nodes. Node selectors and node
+affinity, taints and tolerations, required pod affinity and anti-affinity,
+the pod limit of nodes and PodDisruptionBudgets are respected. Pods without
+a controller keep their node; DaemonSet and static pods go with it.
+
+With --node-cpu and --node-memory, all pods are instead repacked onto as few
+nodes of that capacity as fit them, each running the DaemonSets of the
+current nodes.
+
+Nodes are priced by their capacity at --cpu-price and --memory-price. The
+simulation reads the Kubernetes API directly and does not need the Python
+runtime.
+
+Examples:
+  upid analyze binpack                                   # Removable nodes
+  upid analyze binpack --node-cpu 16 --node-memory 64Gi  # Repack onto larger nodes
+  upid analyze binpack -o wide                           # With instance types and zones`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return analyzeBinpack(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().Float64("node-cpu", 0, "cores of the nodes to repack onto")
+	cmd.Flags().String("node-memory", "", "memory of the nodes to repack onto, e.g. 64Gi")
+	cmd.Flags().Float64("cpu-price", 0, "price per core-hour of node capacity (default pricing.cpu)")
+	cmd.Flags().Float64("memory-price", 0, "price per GiB-hour of node capacity (default pricing.memory)")
+	cmd.MarkFlagsRequiredTogether("node-cpu", "node-memory")
+
+	return cacheable(withColumns(cmd, binpackColumns))
+}
+
+// schedulingColumns are the table columns for scheduling and churn issues
+var schedulingColumns = []output.Column{
+	{Name: "type", Field: "type"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "pod", Field: "pod"},
+	{Name: "container", Field: "container", Wide: true},
+	{Name: "workload", Field: "workload", Wide: true},
+	{Name: "count", Field: "count"},
+	{Name: "reason", Field: "reason", Wide: true},
+	{Name: "message", Field: "message"},
+	{Name: "last-seen", Field: "last_seen", Wide: true},
+}
+
+// analyzeSchedulingCmd creates the scheduling and churn command
+func analyzeSchedulingCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "scheduling",
+		Short: "Analyze pending pods, crashloops, OOM kills, evictions and preemptions",
+		Long: `List the pods that are pending or crashlooping, and the OOM kills, evictions,
+preemptions and failed scheduling attempts over --time-range. They waste
+capacity and hurt reliability, and optimizations must leave room for them.
+
+Pending pods and crashloops are read from the cluster, the rest from events,
+which the API server keeps for about an hour by default. With a datasource,
+OOM kills and evictions are counted from kube-state-metrics over the whole
+time range. The analysis does not need the Python runtime.
+
+Examples:
+  upid analyze scheduling                   # Last 24 hours
+  upid analyze scheduling -n shop -t 7d     # One namespace over a week
+  upid analyze scheduling -o wide           # With containers and workloads`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return analyzeScheduling(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", "", "namespace to analyze (default all namespaces)")
+	cmd.Flags().StringP("time-range", "t", "24h", "time range to analyze")
+
+	return cacheable(withColumns(cmd, schedulingColumns))
+}
+
+// allocationColumns are the table columns for cost allocation
+var allocationColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "pods", Field: "pods"},
+	{Name: "cpu", Header: "CPU REQUESTS", Field: "cpu_requests", Wide: true},
+	{Name: "memory", Header: "MEMORY REQUESTS", Field: "memory_requests", Wide: true},
+	{Name: "direct", Header: "DIRECT", Field: "direct_cost"},
+	{Name: "shared", Header: "SHARED", Field: "shared_cost"},
+	{Name: "idle", Header: "IDLE", Field: "idle_cost"},
+	{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+	{Name: "percent", Header: "% OF CLUSTER", Field: "cost_percent"},
+}
+
+// analyzeAllocationCmd creates the cost allocation command
+func analyzeAllocationCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "allocation",
+		Short: "Allocate cluster cost to teams, namespaces or workloads",
+		Long: `Attribute the monthly cost of the cluster to the values of a dimension: the
+namespace, the owning workload, or a pod label or annotation such as team or
+cost-center. Pods without the label or annotation are grouped as
+(unallocated).
+
+Nodes are priced by their capacity and running pods by their requests at
+--cpu-price and --memory-price. The capacity that is not requested (idle)
+and the pods of --shared-namespaces (shared) are spread over the groups by
+--idle:
+  proportional  in proportion to the requests of each group (default)
+  even          evenly over the groups
+  separate      reported as the (idle) and (shared) groups
+
+With every policy the groups add up to the cost of the cluster. The
+analysis reads the Kubernetes API directly and does not need the Python
+runtime.
+
+Examples:
+  upid analyze allocation                               # Per namespace
+  upid analyze allocation --by label:team               # Per team label
+  upid analyze allocation --by annotation:cost-center --idle separate
+  upid analyze allocation --by owner --shared-namespaces kube-system,monitoring`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return analyzeAllocation(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("by", "namespace", "dimension to allocate by: namespace, owner, label:<key> or annotation:<key>")
+	cmd.Flags().String("idle", native.IdleProportional, "how idle and shared cost is spread: proportional, even or separate")
+	cmd.Flags().StringSlice("shared-namespaces", []string{"kube-system"}, "namespaces whose cost is shared by all groups")
+	cmd.Flags().Float64("cpu-price", 0, "price per core-hour of node capacity (default pricing.cpu)")
+	cmd.Flags().Float64("memory-price", 0, "price per GiB-hour of node capacity (default pricing.memory)")
+
+	return cacheable(withColumns(cmd, allocationColumns))
+}
+
 // Implementation functions
 func analyzeCluster(cmd *cobra.Command, args []string) error {
-	clusterName := "default"
+	clusterName := config.GetDefaultCluster()
 	if len(args) > 0 {
 		clusterName = args[0]
 	}
@@ -174,7 +814,7 @@ func analyzeCluster(cmd *cobra.Command, args []string) error {
 		args = append(args, "--include-costs")
 	}
 
-	return executePythonCommand("analyze", args)
+	return executePythonCommand(cmd.Context(), "analyze", args)
 }
 
 func analyzePod(cmd *cobra.Command, args []string) error {
@@ -191,7 +831,7 @@ func analyzePod(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--time-range", timeRange)
 	}
 
-	return executePythonCommand("analyze", cmdArgs)
+	return executePythonCommand(cmd.Context(), "analyze", cmdArgs)
 }
 
 func analyzeIdle(cmd *cobra.Command, args []string) error {
@@ -215,7 +855,16 @@ func analyzeIdle(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--no-health-check-filtering")
 	}
 
-	return executePythonCommand("analyze", cmdArgs)
+	if runtimeMissing() {
+		return executeNative(cmd.Context(), "analyze", cmdArgs, func(ctx context.Context) (map[string]interface{}, error) {
+			client, window, err := nativeClient(timeRange)
+			if err != nil {
+				return nil, err
+			}
+			return client.FindIdle(ctx, namespace, confidence, window)
+		})
+	}
+	return executePythonCommand(cmd.Context(), "analyze", cmdArgs)
 }
 
 func analyzeResources(cmd *cobra.Command, args []string) error {
@@ -237,11 +886,20 @@ func analyzeResources(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--namespace", namespace)
 	}
 
-	return executePythonCommand("analyze", cmdArgs)
+	if runtimeMissing() {
+		return executeNative(cmd.Context(), "analyze", cmdArgs, func(ctx context.Context) (map[string]interface{}, error) {
+			client, window, err := nativeClient(timeRange)
+			if err != nil {
+				return nil, err
+			}
+			return client.AnalyzeResources(ctx, namespace, resourceType, window)
+		})
+	}
+	return executePythonCommand(cmd.Context(), "analyze", cmdArgs)
 }
 
 func analyzeCost(cmd *cobra.Command, args []string) error {
-	clusterName := "default"
+	clusterName := config.GetDefaultCluster()
 	if len(args) > 0 {
 		clusterName = args[0]
 	}
@@ -249,21 +907,103 @@ func analyzeCost(cmd *cobra.Command, args []string) error {
 	// Get flags
 	timeRange, _ := cmd.Flags().GetString("time-range")
 	detailed, _ := cmd.Flags().GetBool("detailed")
+	pricing, _ := cmd.Flags().GetString("pricing")
+	cur, _ := cmd.Flags().GetString("cur")
+	loadBalancerPrice, _ := cmd.Flags().GetFloat64("load-balancer-price")
 
-	// Build arguments
-	cmdArgs := []string{"cost", clusterName}
-	if timeRange != "" {
-		cmdArgs = append(cmdArgs, "--time-range", timeRange)
+	if pricing == "" {
+		pricing = config.GetPricingConfig().Source
 	}
-	if detailed {
-		cmdArgs = append(cmdArgs, "--detailed")
+	switch pricing {
+	case "", "list":
+		pricing = "list"
+	case billing.SourceCUR, billing.SourceCostExplorer:
+	default:
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", fmt.Sprintf("invalid --pricing %q", pricing)).
+			WithHint("Use list, aws-cur or aws-cost-explorer")
+	}
+
+	if pricing == "list" && !runtimeMissing() {
+		// Build arguments
+		cmdArgs := []string{"cost", clusterName}
+		if timeRange != "" {
+			cmdArgs = append(cmdArgs, "--time-range", timeRange)
+		}
+		if detailed {
+			cmdArgs = append(cmdArgs, "--detailed")
+		}
+		return executePythonCommand(cmd.Context(), "analyze", cmdArgs)
+	}
+
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return err
+	}
+	storage, err := storagePrice(cmd)
+	if err != nil {
+		return err
+	}
+	if loadBalancerPrice < 0 {
+		return fmt.Errorf("invalid --load-balancer-price %g (expected 0 or more)", loadBalancerPrice)
+	}
+	window, err := timeutil.ParseDuration(timeRange)
+	if err != nil || window <= 0 {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", fmt.Sprintf("invalid --time-range %q", timeRange)).
+			WithHint("Use a time range such as 7d or 30d")
+	}
+	opts := native.CostOptions{
+		Prices:            prices,
+		StoragePrice:      storage,
+		LoadBalancerPrice: loadBalancerPrice,
+		Detailed:          detailed,
+	}
+
+	ctx := cmd.Context()
+	if pricing != "list" {
+		if opts.Billed, err = billedCosts(ctx, pricing, cur, window); err != nil {
+			return fmt.Errorf("failed to execute analyze command: %w", err)
+		}
+	}
+	client, err := native.NewClient("")
+	if err != nil {
+		return err
+	}
+	result, err := priced(client.AnalyzeCost(ctx, opts))
+	if err != nil {
+		return fmt.Errorf("failed to execute analyze command: %w", err)
+	}
+	if opts.Billed != nil {
+		// AWS bills in US dollars
+		result["currency"] = "USD"
+	}
+	if err := renderSections(result, costSections); err != nil {
+		return err
 	}
+	printResultWarning(result)
+	return nil
+}
 
-	return executePythonCommand("analyze", cmdArgs)
+// billedCosts reads what AWS billed over the window before now, from the
+// Cost and Usage Report at cur (pricing.cur if empty) or Cost Explorer
+func billedCosts(ctx context.Context, source, cur string, window time.Duration) (*billing.Costs, error) {
+	settings := config.GetPricingConfig()
+	client := billing.New(billing.Options{Region: settings.AWSRegion, Profile: settings.AWSProfile})
+	end := time.Now()
+	if source == billing.SourceCostExplorer {
+		return client.QueryCostExplorer(ctx, end.Add(-window), end)
+	}
+	if cur == "" {
+		cur = settings.CUR
+	}
+	if cur == "" {
+		return nil, clierr.New(clierr.CategoryUsage, "REPORT_NOT_CONFIGURED", "no Cost and Usage Report configured").
+			WithHint("Give one with --cur, or run 'upid config set pricing.cur s3://<bucket>/<path>-Manifest.json'")
+	}
+	return client.ReadReport(ctx, cur, end.Add(-window), end)
 }
 
 func analyzePerformance(cmd *cobra.Command, args []string) error {
-	clusterName := "default"
+	clusterName := config.GetDefaultCluster()
 	if len(args) > 0 {
 		clusterName = args[0]
 	}
@@ -281,7 +1021,338 @@ func analyzePerformance(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--detailed")
 	}
 
-	return executePythonCommand("analyze", cmdArgs)
+	return executePythonCommand(cmd.Context(), "analyze", cmdArgs)
 }
 
- 
\ No newline at end of file
+func analyzeAutoscaling(cmd *cobra.Command, args []string) error {
+	// Get flags
+	namespace, _ := cmd.Flags().GetString("namespace")
+	timeRange, _ := cmd.Flags().GetString("time-range")
+	export, _ := cmd.Flags().GetString("export")
+
+	window, err := timeutil.ParseDuration(timeRange)
+	if err != nil {
+		return err
+	}
+	client, err := native.NewClient("")
+	if err != nil {
+		return err
+	}
+	result, manifests, err := client.AnalyzeAutoscaling(cmd.Context(), namespace, window)
+	if err != nil {
+		return fmt.Errorf("failed to execute analyze command: %w", err)
+	}
+
+	if export == "" {
+		return renderResult(result)
+	}
+	data, err := manifestYAML(manifests)
+	if err != nil {
+		return err
+	}
+	if export == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := renderResult(result); err != nil {
+		return err
+	}
+	if err := os.WriteFile(export, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", export, err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d recommended HPA manifests to %s\n", len(manifests), export)
+	return nil
+}
+
+func analyzeWorkload(cmd *cobra.Command, args []string) error {
+	ref, err := native.ParseWorkloadRef(args[0])
+	if err != nil {
+		return err
+	}
+
+	// Get flags
+	namespace, _ := cmd.Flags().GetString("namespace")
+	timeRange, _ := cmd.Flags().GetString("time-range")
+
+	client, window, err := nativeClient(timeRange)
+	if err != nil {
+		return err
+	}
+	result, err := client.AnalyzeWorkload(cmd.Context(), ref, namespace, window)
+	if err != nil {
+		return fmt.Errorf("failed to execute analyze command: %w", err)
+	}
+	if err := renderSections(result, workloadSections); err != nil {
+		return err
+	}
+	printResultWarning(result)
+	return nil
+}
+
+// section is a list of records in a result, shown as its own table
+type section struct {
+	key     string
+	columns []output.Column
+}
+
+func analyzeNetwork(cmd *cobra.Command, args []string) error {
+	// Get flags
+	namespace, _ := cmd.Flags().GetString("namespace")
+	timeRange, _ := cmd.Flags().GetString("time-range")
+	crossZonePrice, _ := cmd.Flags().GetFloat64("cross-zone-price")
+	egressPrice, _ := cmd.Flags().GetFloat64("egress-price")
+
+	if crossZonePrice < 0 {
+		return fmt.Errorf("invalid --cross-zone-price %g (expected 0 or more)", crossZonePrice)
+	}
+	if egressPrice < 0 {
+		return fmt.Errorf("invalid --egress-price %g (expected 0 or more)", egressPrice)
+	}
+	prices := native.NetworkPrices{CrossZone: crossZonePrice, Egress: egressPrice}
+
+	return executeBuiltin(cmd.Context(), "analyze", func(ctx context.Context) (map[string]interface{}, error) {
+		client, window, err := nativeClient(timeRange)
+		if err != nil {
+			return nil, err
+		}
+		return client.AnalyzeNetwork(ctx, namespace, window, prices)
+	})
+}
+
+func analyzeDiff(cmd *cobra.Command, args []string) error {
+	// Get flags
+	fromFlag, _ := cmd.Flags().GetString("from")
+	toFlag, _ := cmd.Flags().GetString("to")
+	namespace, _ := cmd.Flags().GetString("namespace")
+
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	to, err := parsePeriod("--to", toFlag, now)
+	if err != nil {
+		return err
+	}
+	from := native.Period{Start: to.Start.Add(-to.End.Sub(to.Start)), End: to.Start}
+	if fromFlag != "" {
+		if from, err = parsePeriod("--from", fromFlag, now); err != nil {
+			return err
+		}
+	}
+
+	return executeBuiltin(cmd.Context(), "analyze", func(ctx context.Context) (map[string]interface{}, error) {
+		client, err := native.NewClient("")
+		if err != nil {
+			return nil, err
+		}
+		history, err := datasource()
+		if err != nil {
+			return nil, err
+		}
+		if history != nil {
+			client.SetHistory(history)
+		}
+		return priced(client.AnalyzeDiff(ctx, namespace, from, to, prices))
+	})
+}
+
+// parsePeriod parses the period of a flag, cut off at now
+func parsePeriod(flag, value string, now time.Time) (native.Period, error) {
+	start, end, err := timeutil.ParsePeriod(value, now)
+	if err != nil {
+		return native.Period{}, fmt.Errorf("%s: %v", flag, err)
+	}
+	if !start.Before(now) {
+		return native.Period{}, fmt.Errorf("invalid %s %q (the period has not started yet)", flag, value)
+	}
+	if end.After(now) {
+		end = now
+	}
+	return native.Period{Start: start, End: end}, nil
+}
+
+func analyzeBaselineSave(cmd *cobra.Command, args []string) error {
+	// Get flags
+	file, _ := cmd.Flags().GetString("file")
+	namespace, _ := cmd.Flags().GetString("namespace")
+
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return err
+	}
+
+	return executeBuiltin(cmd.Context(), "analyze", func(ctx context.Context) (map[string]interface{}, error) {
+		client, err := native.NewClient("")
+		if err != nil {
+			return nil, err
+		}
+		return priced(client.SaveBaseline(ctx, namespace, prices, file))
+	})
+}
+
+func analyzeBaselineCheck(cmd *cobra.Command, args []string) error {
+	// Get flags
+	file, _ := cmd.Flags().GetString("file")
+	tolerance, _ := cmd.Flags().GetFloat64("tolerance")
+
+	if tolerance < 0 {
+		return fmt.Errorf("invalid --tolerance %g (expected a percentage of 0 or more)", tolerance)
+	}
+	baseline, err := native.LoadBaseline(file)
+	if err != nil {
+		return err
+	}
+	client, err := native.NewClient("")
+	if err != nil {
+		return err
+	}
+	result, drifted, err := client.CheckBaseline(cmd.Context(), baseline, tolerance/100)
+	if err != nil {
+		return fmt.Errorf("failed to execute analyze command: %w", err)
+	}
+	if err := renderSections(result, []section{{"workloads", driftColumns}}); err != nil {
+		return err
+	}
+	printResultWarning(result)
+	if drifted {
+		return clierr.New(clierr.CategoryDrift, "BASELINE_DRIFT", fmt.Sprintf("the cluster drifted from the baseline in %s", file)).
+			WithHint("Review the changes, and approve them with 'upid analyze baseline save'")
+	}
+	return nil
+}
+
+func analyzeBinpack(cmd *cobra.Command, args []string) error {
+	// Get flags
+	nodeCPU, _ := cmd.Flags().GetFloat64("node-cpu")
+	nodeMemory, _ := cmd.Flags().GetString("node-memory")
+
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return err
+	}
+	opts := native.BinpackOptions{Prices: prices}
+	if cmd.Flags().Changed("node-cpu") {
+		if nodeCPU <= 0 {
+			return fmt.Errorf("invalid --node-cpu %g (expected more than 0)", nodeCPU)
+		}
+		memory, err := resource.ParseQuantity(nodeMemory)
+		if err != nil || memory.Sign() <= 0 {
+			return fmt.Errorf("invalid --node-memory %q (expected a quantity such as 64Gi)", nodeMemory)
+		}
+		opts.NodeSize = &kube.Resources{CPU: nodeCPU, Memory: memory.AsApproximateFloat64()}
+	}
+
+	return executeBuiltin(cmd.Context(), "analyze", func(ctx context.Context) (map[string]interface{}, error) {
+		client, err := native.NewClient("")
+		if err != nil {
+			return nil, err
+		}
+		return priced(client.Binpack(ctx, opts))
+	})
+}
+
+func analyzeScheduling(cmd *cobra.Command, args []string) error {
+	// Get flags
+	namespace, _ := cmd.Flags().GetString("namespace")
+	timeRange, _ := cmd.Flags().GetString("time-range")
+
+	// Events are read over the time range without a datasource too
+	window, err := timeutil.ParseDuration(timeRange)
+	if err != nil || window == 0 {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", fmt.Sprintf("invalid --time-range %q", timeRange)).
+			WithHint("Use a time range such as 6h, 7d or 2w")
+	}
+
+	return executeBuiltin(cmd.Context(), "analyze", func(ctx context.Context) (map[string]interface{}, error) {
+		client, _, err := nativeClient(timeRange)
+		if err != nil {
+			return nil, err
+		}
+		return client.AnalyzeScheduling(ctx, namespace, window)
+	})
+}
+
+func analyzeAllocation(cmd *cobra.Command, args []string) error {
+	// Get flags
+	by, _ := cmd.Flags().GetString("by")
+	idle, _ := cmd.Flags().GetString("idle")
+	sharedNamespaces, _ := cmd.Flags().GetStringSlice("shared-namespaces")
+
+	dimension, err := native.ParseDimension(by)
+	if err != nil {
+		return err
+	}
+	policy, err := native.ParseIdlePolicy(idle)
+	if err != nil {
+		return err
+	}
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return err
+	}
+	opts := native.AllocationOptions{
+		By:               dimension,
+		Idle:             policy,
+		SharedNamespaces: sharedNamespaces,
+		Prices:           prices,
+	}
+
+	return executeBuiltin(cmd.Context(), "analyze", func(ctx context.Context) (map[string]interface{}, error) {
+		client, err := native.NewClient("")
+		if err != nil {
+			return nil, err
+		}
+		return priced(client.AnalyzeAllocation(ctx, opts))
+	})
+}
+
+// renderSections renders a result with several lists of records. Tables
+// show the remaining fields followed by a titled table per non-empty list;
+// other formats render the result as is.
+func renderSections(result map[string]interface{}, sections []section) error {
+	opts := renderOptions()
+	if opts.Quiet || (opts.Format != output.FormatTable && opts.Format != output.FormatWide && opts.Format != "") {
+		return renderResult(result)
+	}
+	summary := make(map[string]interface{}, len(result))
+	for key, value := range result {
+		summary[key] = value
+	}
+	for _, section := range sections {
+		delete(summary, section.key)
+	}
+	return output.WithPager(usePager(), func(w io.Writer) error {
+		if err := output.Render(w, summary, opts); err != nil {
+			return err
+		}
+		for _, section := range sections {
+			list, _ := result[section.key].([]interface{})
+			if len(list) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "\n%s:\n", strings.ToUpper(strings.ReplaceAll(section.key, "_", " ")))
+			opts.Columns = section.columns
+			if err := output.Render(w, list, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// manifestYAML encodes Kubernetes manifests as a multi-document YAML stream
+func manifestYAML(manifests []map[string]interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	for _, manifest := range manifests {
+		if err := encoder.Encode(manifest); err != nil {
+			return nil, fmt.Errorf("failed to encode manifest: %v", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,159 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
+	"github.com/spf13/cobra"
+)
+
+// apiKeyEnv holds an API key that authenticates every command in place of
+// the stored login
+const apiKeyEnv = "UPID_API_KEY"
+
+// apiKeyColumns are the table columns of listed API keys
+var apiKeyColumns = []output.Column{
+	{Name: "id", Field: "id"},
+	{Name: "name", Field: "name"},
+	{Name: "scopes", Field: "scopes"},
+	{Name: "created", Field: "created_at"},
+	{Name: "expires", Field: "expires_at"},
+	{Name: "last used", Field: "last_used_at", Wide: true},
+	{Name: "created by", Field: "created_by", Wide: true},
+}
+
+// authAPIKeyCmd creates the apikey command
+func authAPIKeyCmd() *cobra.Command {
+	apiKeyCmd := &cobra.Command{
+		Use:   "apikey",
+		Short: "Manage API keys",
+		Long: `Manage API keys, which let CI pipelines and the in-cluster agent
+authenticate without a human login.
+
+Set ` + apiKeyEnv + ` to use a key: it authenticates every command and takes
+precedence over the stored login.`,
+		Example: `  # Create a read-only key for a CI pipeline, valid for 30 days
+  upid auth apikey create ci-reports --scope read --expires 30d
+
+  # Use the key
+  export ` + apiKeyEnv + `=<key>
+  upid analyze cluster prod`,
+	}
+
+	apiKeyCmd.AddCommand(authAPIKeyCreateCmd())
+	apiKeyCmd.AddCommand(withColumns(authAPIKeyListCmd(), apiKeyColumns))
+	apiKeyCmd.AddCommand(authAPIKeyRevokeCmd())
+
+	return apiKeyCmd
+}
+
+// authAPIKeyCreateCmd creates the apikey create command
+func authAPIKeyCreateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create <name>",
+		Short: "Create an API key",
+		Long: `Create an API key with the given scopes. The key is shown only once, store
+it in your CI secret store right away.
+
+Scopes limit what the key can do, e.g. read (view clusters and reports),
+analyze, optimize and admin.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return createAPIKey(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringSlice("scope", []string{"read"}, "scopes granted to the key (repeatable)")
+	cmd.Flags().String("expires", "90d", "lifetime of the key, e.g. 30d or 12w (never for no expiry)")
+	cmd.Flags().String("description", "", "what the key is used for")
+
+	return mutating(cmd)
+}
+
+// authAPIKeyListCmd creates the apikey list command
+func authAPIKeyListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List API keys",
+		Long:  "List the API keys of your organization. Keys themselves are never shown again.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listAPIKeys(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().Bool("include-expired", false, "include expired and revoked keys")
+
+	return cmd
+}
+
+// authAPIKeyRevokeCmd creates the apikey revoke command
+func authAPIKeyRevokeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "revoke <id>...",
+		Short: "Revoke API keys",
+		Long:  "Revoke API keys. Commands using a revoked key fail immediately.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return revokeAPIKeys(cmd, args)
+		},
+	}
+
+	return mutating(cmd)
+}
+
+// Implementation functions
+func createAPIKey(cmd *cobra.Command, args []string) error {
+	scopes, _ := cmd.Flags().GetStringSlice("scope")
+	expires, _ := cmd.Flags().GetString("expires")
+	description, _ := cmd.Flags().GetString("description")
+
+	cmdArgs := []string{"apikey", "create", args[0]}
+	for _, scope := range scopes {
+		cmdArgs = append(cmdArgs, "--scope", strings.TrimSpace(scope))
+	}
+	if expires != "never" {
+		lifetime, err := timeutil.ParseDuration(expires)
+		if err != nil || lifetime == 0 {
+			return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", fmt.Sprintf("invalid --expires %q", expires)).
+				WithHint("Use a lifetime such as 30d or 12w, or never")
+		}
+		cmdArgs = append(cmdArgs, "--expires-at", time.Now().Add(lifetime).UTC().Format(time.RFC3339))
+	}
+	if description != "" {
+		cmdArgs = append(cmdArgs, "--description", description)
+	}
+
+	pb := getBridge()
+	if IsDryRun() {
+		return printDryRun(pb.CommandLine("auth", append(cmdArgs, "--format", "json")))
+	}
+	result, err := pb.ExecuteCommandWithJSON(cmd.Context(), "auth", cmdArgs)
+	if err != nil {
+		return fmt.Errorf("failed to execute auth command: %w", err)
+	}
+	if _, ok := result["message"]; !ok {
+		result["message"] = fmt.Sprintf("Created API key %s. Store the key now, it is not shown again.", args[0])
+	}
+	return renderResult(result)
+}
+
+func listAPIKeys(cmd *cobra.Command, args []string) error {
+	includeExpired, _ := cmd.Flags().GetBool("include-expired")
+
+	cmdArgs := []string{"apikey", "list"}
+	if includeExpired {
+		cmdArgs = append(cmdArgs, "--include-expired")
+	}
+	return executePythonCommand(cmd.Context(), "auth", cmdArgs)
+}
+
+func revokeAPIKeys(cmd *cobra.Command, args []string) error {
+	return executePythonCommand(cmd.Context(), "auth", append([]string{"apikey", "revoke"}, args...))
+}
@@ -0,0 +1,228 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/spf13/cobra"
+)
+
+// approvalColumns are the table columns for changes that need approval
+var approvalColumns = []output.Column{
+	{Name: "id", Field: "id"},
+	{Name: "status", Field: "status"},
+	{Name: "command", Field: "command"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "changes", Field: "changes", Wide: true},
+	{Name: "override", Field: "override", Wide: true},
+	{Name: "requested-by", Header: "REQUESTED BY", Field: "requested_by"},
+	{Name: "requested-at", Header: "REQUESTED AT", Field: "requested_at", Wide: true},
+	{Name: "reviewed-by", Header: "REVIEWED BY", Field: "reviewed_by"},
+	{Name: "reviewed-at", Header: "REVIEWED AT", Field: "reviewed_at", Wide: true},
+	{Name: "reason", Field: "reason", Wide: true},
+	{Name: "applied-at", Header: "APPLIED AT", Field: "applied_at", Wide: true},
+	{Name: "expires-at", Header: "EXPIRES AT", Field: "expires_at", Wide: true},
+}
+
+// optimizeApprovalsCmd creates the optimize approvals command
+func optimizeApprovalsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "approvals",
+		Short: "Review optimizations awaiting approval",
+		Long: `Review the optimizations that wait for the approval of a second user.
+
+On protected clusters, 'upid optimize apply' and 'upid optimize zero-pod
+--apply' do not make changes right away. They record a pending change in a
+ConfigMap in the approvals namespace of the cluster, so that every user of
+the cluster sees it. Another user approves or rejects it; the user who
+requested a change cannot review it. Once approved, running the same
+command again applies the change, once.
+
+A change records what it does: the workloads it scales, from and to how
+many replicas, and the --override of guardrails it was requested with. Only
+that change is applied once approved; if the command would now scale other
+workloads, or use another override, it records a new change instead. A
+change must be approved within approvals.ttl (24h) of its request, and
+applied within approvals.ttl of its approval, or it expires.
+
+The policy is set in the config file, or for a whole team in its team config:
+approvals.clusters lists the protected kubeconfig contexts and
+approvals.namespaces their protected namespaces (all if unset), as
+comma-separated glob patterns. Changes to the whole cluster always need
+approval on a protected cluster. approvals.namespace (upid-system) holds the
+changes; RBAC on its ConfigMaps controls who can request and review them.
+Users are identified by the cluster, with the SelfSubjectReview API.
+
+Examples:
+  upid config set approvals.clusters 'prod-*'
+  upid optimize approvals list
+  upid optimize approvals list --status pending
+  upid optimize approvals approve 3f9a1c2e
+  upid optimize approvals reject 3f9a1c2e --reason "wait for the sale to end"`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return optimizeApprovalsList(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("status", "", "list only changes with this status: pending, approved, rejected, applied or expired")
+
+	cmd.AddCommand(withColumns(optimizeApprovalsListCmd(), approvalColumns))
+	cmd.AddCommand(withColumns(optimizeApprovalsApproveCmd(), approvalColumns))
+	cmd.AddCommand(withColumns(optimizeApprovalsRejectCmd(), approvalColumns))
+
+	return withColumns(cmd, approvalColumns)
+}
+
+// optimizeApprovalsListCmd creates the approvals list command
+func optimizeApprovalsListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the changes awaiting approval and their history",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return optimizeApprovalsList(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("status", "", "list only changes with this status: pending, approved, rejected, applied or expired")
+
+	return cmd
+}
+
+// optimizeApprovalsApproveCmd creates the approvals approve command
+func optimizeApprovalsApproveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "approve <id>",
+		Short: "Approve a pending change",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return optimizeApprovalsReview(cmd, args, true)
+		},
+	}
+
+	return mutating(cmd)
+}
+
+// optimizeApprovalsRejectCmd creates the approvals reject command
+func optimizeApprovalsRejectCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reject <id>",
+		Short: "Reject a pending change",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return optimizeApprovalsReview(cmd, args, false)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("reason", "", "why the change is rejected, shown to the requester")
+
+	return mutating(cmd)
+}
+
+// Implementation functions
+func optimizeApprovalsList(cmd *cobra.Command, args []string) error {
+	// Get flags
+	status, _ := cmd.Flags().GetString("status")
+
+	switch status {
+	case "", kube.ApprovalPending, kube.ApprovalApproved, kube.ApprovalRejected, kube.ApprovalApplied, kube.ApprovalExpired:
+	default:
+		return fmt.Errorf("invalid --status %q (expected pending, approved, rejected, applied or expired)", status)
+	}
+
+	return executeBuiltin(cmd.Context(), "optimize", func(ctx context.Context) (map[string]interface{}, error) {
+		client, err := native.NewClient("")
+		if err != nil {
+			return nil, err
+		}
+		return client.ListApprovals(ctx, config.GetApprovalsConfig().Namespace, status)
+	})
+}
+
+func optimizeApprovalsReview(cmd *cobra.Command, args []string, approve bool) error {
+	// Get flags
+	reason, _ := cmd.Flags().GetString("reason")
+
+	if IsDryRun() {
+		action := "approve"
+		if !approve {
+			action = "reject"
+		}
+		return printDryRun(fmt.Sprintf("%s change %s", action, args[0]))
+	}
+	return executeBuiltin(cmd.Context(), "optimize", func(ctx context.Context) (map[string]interface{}, error) {
+		client, err := native.NewClient("")
+		if err != nil {
+			return nil, err
+		}
+		policy := config.GetApprovalsConfig()
+		return client.ReviewApproval(ctx, policy.Namespace, args[0], approve, reason, policy.TTL)
+	})
+}
+
+// approvalRequired returns true if changes to a namespace of the current
+// cluster, or to the whole cluster if empty, need approval
+func approvalRequired(namespace string) bool {
+	policy := config.GetApprovalsConfig()
+	if policy.Clusters == "" {
+		return false
+	}
+	kubeconfig, err := native.LoadKubeconfig()
+	return err != nil || policy.Required(kubeconfig.CurrentContext, namespace)
+}
+
+// awaitApproval holds back a change to the namespace of request, or to the
+// whole cluster if empty, on clusters where the approvals policy protects
+// it. It returns false after reporting the pending change if the change is
+// not approved. Otherwise the change may be made, and the returned function
+// records that it was.
+func awaitApproval(ctx context.Context, request *kube.Approval) (bool, func() error, error) {
+	pending, done, err := checkApproval(ctx, request)
+	if err != nil {
+		return false, nil, err
+	}
+	if pending != nil {
+		if err := renderSections(pending, []section{{"approvals", approvalColumns}}); err != nil {
+			return false, nil, err
+		}
+		printResultWarning(pending)
+		return false, nil, nil
+	}
+	return true, done, nil
+}
+
+// checkApproval is awaitApproval without output: it returns the result
+// reporting the pending change if the change is not approved, and otherwise
+// the function recording that it was made
+func checkApproval(ctx context.Context, request *kube.Approval) (map[string]interface{}, func() error, error) {
+	done := func() error { return nil }
+	policy := config.GetApprovalsConfig()
+	if policy.Clusters == "" {
+		return nil, done, nil
+	}
+	client, err := native.NewClient("")
+	if err != nil {
+		return nil, nil, err
+	}
+	if !policy.Required(client.Context(), request.Namespace) {
+		return nil, done, nil
+	}
+
+	approval, result, err := client.RequestApproval(ctx, policy.Namespace, request, policy.TTL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check approvals: %w", err)
+	}
+	if approval == nil {
+		return result, nil, nil
+	}
+	return nil, func() error {
+		return client.CompleteApproval(ctx, policy.Namespace, approval)
+	}, nil
+}
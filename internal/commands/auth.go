@@ -1,6 +1,12 @@
 package commands
 
 import (
+	"fmt"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/oidc"
 	"github.com/spf13/cobra"
 )
 
@@ -20,6 +26,11 @@ func AuthCmd() *cobra.Command {
 	authCmd.AddCommand(authLogoutCmd())
 	authCmd.AddCommand(authStatusCmd())
 	authCmd.AddCommand(authConfigureCmd())
+	authCmd.AddCommand(authKubeCredentialCmd())
+	authCmd.AddCommand(authAPIKeyCmd())
+	authCmd.AddCommand(authWhoamiCmd())
+	authCmd.AddCommand(authCanICmd())
+	authCmd.AddCommand(authSessionsCmd())
 
 	return authCmd
 }
@@ -29,7 +40,33 @@ func authLoginCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "login [provider]",
 		Short: "Login to UPID",
-		Long:  "Authenticate with UPID using various providers",
+		Long: `Authenticate with UPID using various providers.
+
+With --sso, sign in with your organization's identity provider in the
+browser. On machines without a browser, such as bastion hosts and CI
+runners, --device shows a URL and code to complete the login on another
+device. The issuer and client ID are read from auth.issuer and
+auth.client_id, and the session is kept in the credential store.
+
+Accounts protected by a second factor are asked for a code from their
+authenticator app or to confirm the login with a security key in the
+browser. Scripts can pass the code with --totp-code if the organization's
+policy allows it. With --sso the identity provider asks for the second
+factor itself.`,
+		Example: `  # Sign in with single sign-on
+  upid auth login --sso
+
+  # Sign in from a script with a one-time code
+  upid auth login -u ci-bot --password "$PASSWORD" --totp-code "$(oathtool --totp -b "$SEED")"
+
+  # Sign in from an SSH session, using the browser on your laptop
+  upid auth login --device
+
+  # Stay logged in to another organization in a separate session
+  upid auth login --sso --session acme --issuer https://login.acme.com --client-id upid
+
+  # Sign in with an identity provider that is not configured
+  upid auth login --sso --issuer https://login.example.com --client-id upid-cli`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return authLogin(cmd, args)
 		},
@@ -39,8 +76,17 @@ func authLoginCmd() *cobra.Command {
 	cmd.Flags().StringP("username", "u", "", "username")
 	cmd.Flags().StringP("password", "p", "", "password")
 	cmd.Flags().StringP("token", "t", "", "access token")
+	cmd.Flags().Bool("token-stdin", false, "read the access token from standard input")
+	cmd.Flags().String("totp-code", "", "one-time code from an authenticator app, for logins without a terminal")
+	cmd.Flags().Bool("totp-code-stdin", false, "read the one-time code from standard input")
+	cmd.Flags().Bool("sso", false, "sign in with the identity provider in the browser")
+	cmd.Flags().Bool("device", false, "sign in with the identity provider on another device, using a code")
+	cmd.Flags().String("issuer", "", "OpenID Connect issuer for --sso and --device (default auth.issuer)")
+	cmd.Flags().String("client-id", "", "OpenID Connect client ID for --sso and --device (default auth.client_id)")
+	cmd.Flags().String("session", "", "log in to this session, created if needed, and switch to it")
+	cmd.MarkFlagsMutuallyExclusive("sso", "device")
 
-	return cmd
+	return mutating(cmd)
 }
 
 // authLogoutCmd creates the logout command
@@ -54,7 +100,7 @@ func authLogoutCmd() *cobra.Command {
 		},
 	}
 
-	return cmd
+	return mutating(cmd)
 }
 
 // authStatusCmd creates the status command
@@ -62,12 +108,21 @@ func authStatusCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "status",
 		Short: "Check authentication status",
-		Long:  "Check current authentication status and token validity",
+		Long: `Check current authentication status and token validity.
+
+Tokens that are JWTs are validated locally against the cached signing keys
+of the issuer of the SSO session or auth.issuer, which are downloaded at most
+once a day. Tokens of another issuer, or not issued to auth.client_id, are
+invalid; tokens whose signature cannot be checked are reported as
+unverified.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return authStatus(cmd, args)
 		},
 	}
 
+	// Add flags
+	cmd.Flags().Bool("offline", false, "never download signing keys, use only cached ones")
+
 	return cmd
 }
 
@@ -84,15 +139,77 @@ func authConfigureCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("endpoint", "e", "", "authentication endpoint")
-	cmd.Flags().StringP("client-id", "c", "", "client ID")
+	cmd.Flags().String("client-id", "", "client ID")
 	cmd.Flags().StringP("client-secret", "s", "", "client secret")
+	cmd.Flags().Bool("client-secret-stdin", false, "read the client secret from standard input")
+
+	return mutating(cmd)
+}
+
+// authKubeCredentialCmd creates the kube-credential command
+func authKubeCredentialCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kube-credential",
+		Short: "Provide cluster credentials to kubectl",
+		Long: `Print the token of the SSO session as a Kubernetes ExecCredential, so
+kubectl and other client-go tools can use UPID as a credential plugin.
+Expired sessions are refreshed automatically.
+
+Reference the command in the user section of a kubeconfig:
+
+  users:
+  - name: upid
+    user:
+      exec:
+        apiVersion: client.authentication.k8s.io/v1
+        command: upid
+        args: ["auth", "kube-credential"]
+        interactiveMode: IfAvailable`,
+		Example: `  # Print the credential kubectl receives
+  upid auth kube-credential
+
+  # Sign in in the browser when there is no valid session
+  upid auth kube-credential --login`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return authKubeCredential(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().Bool("access-token", false, "send the access token instead of the ID token")
+	cmd.Flags().Bool("login", false, "sign in in the browser when there is no valid session")
 
 	return cmd
 }
 
 // Implementation functions
 func authLogin(cmd *cobra.Command, args []string) error {
-	provider := "default"
+	name, _ := cmd.Flags().GetString("session")
+	if name == "" {
+		return login(cmd, args)
+	}
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("log in to session %s and make it the default", name))
+	}
+	if err := enterSession(cmd, name); err != nil {
+		return err
+	}
+	if err := login(cmd, args); err != nil {
+		return err
+	}
+	return setCurrentProfile(sessionProfile(name))
+}
+
+// login logs in to the active profile
+func login(cmd *cobra.Command, args []string) error {
+	sso, _ := cmd.Flags().GetBool("sso")
+	device, _ := cmd.Flags().GetBool("device")
+	if sso || device {
+		return loginSSO(cmd, device)
+	}
+
+	provider := config.GetAuthProvider()
 	if len(args) > 0 {
 		provider = args[0]
 	}
@@ -100,9 +217,17 @@ func authLogin(cmd *cobra.Command, args []string) error {
 	// Get flags
 	username, _ := cmd.Flags().GetString("username")
 	password, _ := cmd.Flags().GetString("password")
-	token, _ := cmd.Flags().GetString("token")
+	token, err := secretFlag(cmd, "token")
+	if err != nil {
+		return err
+	}
+	totpCode, err := secretFlag(cmd, "totp-code")
+	if err != nil {
+		return err
+	}
 
 	// Build arguments
+	pb := getBridge()
 	cmdArgs := []string{"login", provider}
 	if username != "" {
 		cmdArgs = append(cmdArgs, "--username", username)
@@ -111,22 +236,94 @@ func authLogin(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--password", password)
 	}
 	if token != "" {
-		cmdArgs = append(cmdArgs, "--token", token)
+		// Pass the token in the environment, where other users cannot see it
+		pb.SetEnv("UPID_LOGIN_TOKEN", token)
+		defer pb.SetEnv("UPID_LOGIN_TOKEN", "")
+		cmdArgs = append(cmdArgs, "--token-env", "UPID_LOGIN_TOKEN")
+	}
+	defer pb.SetEnv(mfaCodeEnv, "")
+	if totpCode != "" {
+		pb.SetEnv(mfaCodeEnv, totpCode)
+		cmdArgs = append(cmdArgs, "--totp-code-env", mfaCodeEnv)
 	}
 
-	return executePythonCommand("auth", cmdArgs)
+	if IsDryRun() {
+		return printDryRun(pb.CommandLine("auth", append(cmdArgs, "--format", "json")))
+	}
+	result, err := pb.ExecuteCommandWithJSON(cmd.Context(), "auth", cmdArgs)
+	if err != nil {
+		return fmt.Errorf("failed to execute auth command: %w", err)
+	}
+
+	// Answer second-factor challenges, asking the user unless a code was given
+	for attempt := 1; ; attempt++ {
+		challenge := parseMFAChallenge(result)
+		if challenge == nil {
+			break
+		}
+		if totpCode != "" || attempt > mfaMaxAttempts {
+			message := "the second factor was not accepted"
+			if challenge.Message != "" {
+				message = challenge.Message
+			}
+			return clierr.New(clierr.CategoryAuth, "MFA_FAILED", message)
+		}
+		answer, err := answerMFA(pb, challenge)
+		if err != nil {
+			return err
+		}
+		result, err = pb.ExecuteCommandWithJSON(cmd.Context(), "auth", append(cmdArgs, answer...))
+		if err != nil {
+			return fmt.Errorf("failed to execute auth command: %w", err)
+		}
+	}
+
+	// Keep the session token in the keychain, never print it
+	if authenticated, _ := result["authenticated"].(bool); authenticated {
+		session, _ := result["token"].(string)
+		if token != "" {
+			session = token
+		}
+		delete(result, "token")
+		if session != "" {
+			backend, err := storeCredential(credentialAuthToken, session)
+			if err != nil {
+				return err
+			}
+			result["token_store"] = backend
+		}
+	}
+	return renderResult(result)
 }
 
 func authLogout(cmd *cobra.Command, args []string) error {
-	return executePythonCommand("auth", []string{"logout"})
+	if !IsDryRun() {
+		for _, name := range []string{credentialAuthToken, credentialAuthSession} {
+			if err := credentialStore().Delete(credentialName(name)); err != nil {
+				return fmt.Errorf("failed to remove stored token: %w", err)
+			}
+		}
+	}
+	return executePythonCommand(cmd.Context(), "auth", []string{"logout"})
 }
 
 func authStatus(cmd *cobra.Command, args []string) error {
-	return executePythonCommand("auth", []string{"status"})
+	offline, _ := cmd.Flags().GetBool("offline")
+
+	// Logins with JWTs are checked locally, others by the runtime
+	session, err := loadSession()
+	if err != nil {
+		return err
+	}
+	token := loginToken(session)
+	if apiKeyInUse() || !oidc.IsJWT(token) {
+		return executePythonCommand(cmd.Context(), "auth", []string{"status"})
+	}
+	return renderResult(tokenStatus(cmd.Context(), token, session, offline))
 }
 
 func authConfigure(cmd *cobra.Command, args []string) error {
-	provider := "default"
+	provider := config.GetAuthProvider()
 	if len(args) > 0 {
 		provider = args[0]
 	}
@@ -134,7 +331,10 @@ func authConfigure(cmd *cobra.Command, args []string) error {
 	// Get flags
 	endpoint, _ := cmd.Flags().GetString("endpoint")
 	clientID, _ := cmd.Flags().GetString("client-id")
-	clientSecret, _ := cmd.Flags().GetString("client-secret")
+	clientSecret, err := secretFlag(cmd, "client-secret")
+	if err != nil {
+		return err
+	}
 
 	// Build arguments
 	cmdArgs := []string{"configure", provider}
@@ -144,9 +344,39 @@ func authConfigure(cmd *cobra.Command, args []string) error {
 	if clientID != "" {
 		cmdArgs = append(cmdArgs, "--client-id", clientID)
 	}
-	if clientSecret != "" {
-		cmdArgs = append(cmdArgs, "--client-secret", clientSecret)
+	if clientSecret != "" && !IsDryRun() {
+		// The runtime receives the stored secret in its environment
+		if _, err := storeCredential(credentialClientSecret, clientSecret); err != nil {
+			return err
+		}
 	}
 
-	return executePythonCommand("auth", cmdArgs)
-} 
\ No newline at end of file
+	return executePythonCommand(cmd.Context(), "auth", cmdArgs)
+}
+
+func authKubeCredential(cmd *cobra.Command, args []string) error {
+	accessToken, _ := cmd.Flags().GetBool("access-token")
+	login, _ := cmd.Flags().GetBool("login")
+
+	session, err := currentSession(cmd.Context())
+	if err != nil && login && clierr.From(err).Category == clierr.CategoryAuth {
+		if session, err = ssoLogin(cmd, false); err == nil {
+			_, err = storeSession(session)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Clusters that trust the identity provider verify its ID tokens
+	token, expiry := session.IDToken, session.IDTokenExpiry()
+	if accessToken || token == "" {
+		token, expiry = session.AccessToken, session.Expiry
+	}
+	credential, err := native.ExecCredential(token, expiry)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(credential))
+	return nil
+}
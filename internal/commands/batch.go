@@ -0,0 +1,368 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// Statuses of the recommendations of a batch
+const (
+	batchApplied   = "applied"
+	batchFailed    = "failed"
+	batchPending   = "awaiting approval"
+	batchCancelled = "cancelled"
+)
+
+// batchColumns are the table columns for recommendations applied in a batch
+var batchColumns = []output.Column{
+	{Name: "id", Field: "id"},
+	{Name: "status", Field: "status"},
+	{Name: "savings", Header: "MONTHLY SAVINGS", Field: "monthly_savings"},
+	{Name: "duration", Field: "duration", Wide: true},
+	{Name: "message", Field: "message"},
+}
+
+// batchItem is a recommendation to apply in a batch
+type batchItem struct {
+	id string
+	// savings is the monthly savings, nil if unknown
+	savings interface{}
+}
+
+func optimizeApplyBatch(cmd *cobra.Command, args []string, confirm, dryRun bool) error {
+	// Get flags
+	all, _ := cmd.Flags().GetBool("all")
+	minSavings, _ := cmd.Flags().GetFloat64("min-savings")
+	fromFile, _ := cmd.Flags().GetString("from-file")
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+	override, _ := cmd.Flags().GetString("override")
+
+	if concurrency < 1 {
+		return fmt.Errorf("invalid --concurrency %d (expected at least 1)", concurrency)
+	}
+	if minSavings < 0 {
+		return fmt.Errorf("invalid --min-savings %g (expected at least 0)", minSavings)
+	}
+	if cmd.Flags().Changed("min-savings") && !all {
+		return fmt.Errorf("--min-savings selects among all recommendations and requires --all")
+	}
+
+	ctx := cmd.Context()
+	items := make([]batchItem, 0, len(args))
+	for _, id := range args {
+		items = append(items, batchItem{id: id})
+	}
+	if fromFile != "" {
+		ids, err := readRecommendationIDs(fromFile)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			items = append(items, batchItem{id: id})
+		}
+	}
+	if all {
+		selected, err := selectRecommendations(ctx, minSavings)
+		if err != nil {
+			return err
+		}
+		items = append(items, selected...)
+	}
+	items = uniqueItems(items)
+	if len(items) == 0 {
+		return renderBatchResult(map[string]interface{}{
+			"recommendations": []interface{}{},
+			"message":         "No recommendations to apply",
+		})
+	}
+
+	if !confirm && !dryRun {
+		ok, err := confirmBatch(len(items))
+		if err != nil || !ok {
+			return err
+		}
+	}
+	result, err := applyBatch(ctx, items, concurrency, dryRun, override)
+	if err != nil || result == nil {
+		return err
+	}
+	return renderBatchResult(result)
+}
+
+// applyBatch applies recommendations, up to concurrency at a time, and
+// returns the summary of the batch. With dryRun, the commands that would
+// run are printed instead and the result is nil. During business hours,
+// nothing is applied unless override gives a reason.
+func applyBatch(ctx context.Context, items []batchItem, concurrency int, dryRun bool, override string) (map[string]interface{}, error) {
+	if dryRun {
+		bridge := getBridge()
+		actions := make([]string, len(items))
+		for i, item := range items {
+			actions[i] = bridge.CommandLine("optimize", []string{"apply", item.id, "--confirm", "--format", "json"})
+		}
+		return nil, printDryRun(actions...)
+	}
+	if err := checkLogin(ctx); err != nil {
+		return nil, err
+	}
+	actions := make([]string, len(items))
+	for i, item := range items {
+		actions[i] = "optimize apply " + item.id
+	}
+	if err := guardBusinessHours(override, actions...); err != nil {
+		return nil, err
+	}
+
+	// The bridge of a shell session is shared and runs one command at a time
+	if inShell {
+		concurrency = 1
+	}
+	results := make([]map[string]interface{}, len(items))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, item batchItem) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = applyBatchItem(ctx, item, override)
+		}(i, item)
+	}
+	wg.Wait()
+	result := summarizeBatch(results)
+	if result["applied"].(int)+result["failed"].(int) > 0 {
+		notifyEvent(ctx, optimizationEvent("UPID applied recommendations", result))
+	}
+	return result, nil
+}
+
+// applyBatchItem applies one recommendation of a batch and reports its
+// status. Protected clusters apply approved recommendations only, approved
+// with the same override.
+func applyBatchItem(ctx context.Context, item batchItem, override string) map[string]interface{} {
+	result := map[string]interface{}{"id": item.id, "monthly_savings": item.savings}
+	if ctx.Err() != nil {
+		result["status"] = batchCancelled
+		result["message"] = "not started before the command was stopped"
+		return result
+	}
+	started := time.Now()
+	defer func() { result["duration"] = time.Since(started).Round(time.Millisecond).String() }()
+
+	pending, done, err := checkApproval(ctx, &kube.Approval{Command: "optimize apply " + item.id, Override: override})
+	if err != nil {
+		result["status"] = batchFailed
+		result["message"] = clierr.From(err).Message
+		return result
+	}
+	if pending != nil {
+		result["status"] = batchPending
+		result["message"] = pending["message"]
+		return result
+	}
+
+	applied, err := getBridge().ExecuteCommandWithJSON(ctx, "optimize", []string{"apply", item.id, "--confirm"})
+	if err != nil {
+		result["status"] = batchFailed
+		result["message"] = clierr.From(err).Message
+		return result
+	}
+	result["status"] = batchApplied
+	if message, ok := applied["message"].(string); ok {
+		result["message"] = message
+	}
+	if err := done(); err != nil {
+		result["message"] = fmt.Sprintf("applied, but failed to record it for approvals: %s", clierr.From(err).Message)
+	}
+	return result
+}
+
+// summarizeBatch builds the result of a batch from the status of its
+// recommendations. Failures make the result partial.
+func summarizeBatch(results []map[string]interface{}) map[string]interface{} {
+	counts := map[string]int{}
+	savings := 0.0
+	var errs []interface{}
+	list := make([]interface{}, len(results))
+	for i, r := range results {
+		list[i] = r
+		status := r["status"].(string)
+		counts[status]++
+		if value, ok := r["monthly_savings"].(float64); ok && status == batchApplied {
+			savings += value
+		}
+		if status == batchFailed {
+			errs = append(errs, fmt.Sprintf("%s: %v", r["id"], r["message"]))
+		}
+	}
+
+	message := fmt.Sprintf("Applied %d of %d recommendations", counts[batchApplied], len(results))
+	for _, status := range []string{batchFailed, batchPending, batchCancelled} {
+		if counts[status] > 0 {
+			message += fmt.Sprintf(", %d %s", counts[status], status)
+		}
+	}
+	result := map[string]interface{}{
+		"recommendations":   list,
+		"message":           message,
+		"applied":           counts[batchApplied],
+		"failed":            counts[batchFailed],
+		"awaiting_approval": counts[batchPending],
+		"cancelled":         counts[batchCancelled],
+		"monthly_savings":   math.Round(savings*100) / 100,
+	}
+	if len(errs) > 0 {
+		result["partial"] = true
+		result["errors"] = errs
+	}
+	if counts[batchPending] > 0 {
+		result["hint"] = "Recommendations awaiting approval are applied by running the command again once approved, see 'upid optimize approvals list'"
+	}
+	return result
+}
+
+// renderBatchResult renders the result of a batch
+func renderBatchResult(result map[string]interface{}) error {
+	if err := renderSections(result, []section{{"recommendations", batchColumns}}); err != nil {
+		return err
+	}
+	printResultWarning(result)
+	return partialResultError(result)
+}
+
+// selectRecommendations lists the recommendations saving at least
+// minSavings a month. Recommendations without known savings are only
+// selected if minSavings is 0.
+func selectRecommendations(ctx context.Context, minSavings float64) ([]batchItem, error) {
+	records, err := listRecommendations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var items []batchItem
+	for _, record := range records {
+		item := recommendationItem(record)
+		if value, ok := item.savings.(float64); minSavings > 0 && (!ok || value < minSavings) {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// listRecommendations returns the recommendations of 'upid optimize
+// resources' on the default cluster that have an ID
+func listRecommendations(ctx context.Context) ([]map[string]interface{}, error) {
+	if err := checkLogin(ctx); err != nil {
+		return nil, err
+	}
+	bridge := getBridge()
+	defer provideSnapshot(ctx, bridge, "optimize")()
+	result, err := bridge.ExecuteCommandWithJSON(ctx, "optimize", []string{"resources", config.GetDefaultCluster()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recommendations: %w", err)
+	}
+	var records []map[string]interface{}
+	for _, record := range output.Rows(result) {
+		if recommendationItem(record).id != "" {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// recommendationItem reads the ID and the monthly savings of a
+// recommendation
+func recommendationItem(record map[string]interface{}) batchItem {
+	item := batchItem{}
+	for _, field := range []string{"id", "recommendation_id"} {
+		if value, ok := record[field]; ok && value != nil {
+			item.id = output.FormatValue(value)
+			break
+		}
+	}
+	for _, field := range []string{"monthly_savings", "estimated_savings", "savings"} {
+		if value, ok := record[field].(float64); ok {
+			item.savings = value
+			break
+		}
+	}
+	return item
+}
+
+// readRecommendationIDs reads the IDs in a file, or standard input for
+// "-", one per line. Empty lines and lines starting with # are skipped.
+func readRecommendationIDs(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --from-file: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	var ids []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			ids = append(ids, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read --from-file: %v", err)
+	}
+	return ids, nil
+}
+
+// uniqueItems drops repeated recommendations, keeping the first, which may
+// lack the savings a later one knows
+func uniqueItems(items []batchItem) []batchItem {
+	seen := map[string]int{}
+	unique := items[:0]
+	for _, item := range items {
+		if i, ok := seen[item.id]; ok {
+			if unique[i].savings == nil {
+				unique[i].savings = item.savings
+			}
+			continue
+		}
+		seen[item.id] = len(unique)
+		unique = append(unique, item)
+	}
+	return unique
+}
+
+// confirmBatch asks once whether to apply a number of recommendations
+func confirmBatch(count int) (bool, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false, clierr.New(clierr.CategoryUsage, "CONFIRMATION_REQUIRED",
+			fmt.Sprintf("applying %d recommendations needs confirmation and no terminal is available to ask for it", count)).
+			WithHint("Pass --confirm to apply them without asking")
+	}
+	fmt.Fprintf(os.Stderr, "Are you sure you want to apply %d recommendations? (y/N): ", count)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return false, fmt.Errorf("failed to read confirmation: %v", err)
+	}
+	if answer := strings.ToLower(strings.TrimSpace(line)); answer != "y" && answer != "yes" {
+		fmt.Println("Operation cancelled")
+		return false, nil
+	}
+	return true, nil
+}
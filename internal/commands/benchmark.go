@@ -0,0 +1,238 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/bridge"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// benchmarkStage is a single analysis run as part of a benchmark
+type benchmarkStage struct {
+	Name    string
+	Command string
+	Args    []string
+}
+
+// benchmarkResult holds the measurements for one stage
+type benchmarkResult struct {
+	Stage          string        `json:"stage"`
+	Runs           int           `json:"runs"`
+	Failures       int           `json:"failures"`
+	Avg            time.Duration `json:"avg_ns"`
+	Min            time.Duration `json:"min_ns"`
+	Max            time.Duration `json:"max_ns"`
+	PeakMemoryKB   int64         `json:"peak_memory_kb"`
+	BridgeOverhead time.Duration `json:"bridge_overhead_ns"`
+}
+
+// benchmarkReport is the full benchmark output
+type benchmarkReport struct {
+	Version    string            `json:"version"`
+	Cluster    string            `json:"cluster"`
+	Synthetic  bool              `json:"synthetic"`
+	Iterations int               `json:"iterations"`
+	Stages     []benchmarkResult `json:"stages"`
+}
+
+// systemBenchmarkCmd creates the system benchmark command
+func systemBenchmarkCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "benchmark",
+		Short: "Benchmark analysis performance",
+		Long: `Run representative analyses and report wall time, peak memory and bridge
+overhead per stage, so performance can be compared between CLI versions.
+
+The bridge overhead is the cost of starting the Python runtime without doing
+any analysis work; it is measured once per run and reported next to each stage.
+
+Examples:
+  upid system benchmark --cluster prod          # Benchmark against a cluster
+  upid system benchmark --synthetic --pods 5000 # Benchmark with fixture data
+  upid system benchmark -n 10 --format json     # Ten iterations, JSON output`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return systemBenchmark(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("cluster", "default", "cluster to run analyses against")
+	cmd.Flags().BoolP("synthetic", "s", false, "use generated fixture data instead of a live cluster")
+	cmd.Flags().Int("pods", 1000, "number of pods in the synthetic fixture")
+	cmd.Flags().IntP("iterations", "n", 3, "number of runs per stage")
+	cmd.Flags().StringP("format", "f", "table", "output format (table, json)")
+
+	return cmd
+}
+
+func systemBenchmark(cmd *cobra.Command, args []string) error {
+	// Get flags
+	cluster, _ := cmd.Flags().GetString("cluster")
+	synthetic, _ := cmd.Flags().GetBool("synthetic")
+	pods, _ := cmd.Flags().GetInt("pods")
+	iterations, _ := cmd.Flags().GetInt("iterations")
+	format, _ := cmd.Flags().GetString("format")
+
+	if iterations < 1 {
+		return fmt.Errorf("iterations must be at least 1")
+	}
+
+	if synthetic {
+		fixture, err := writeBenchmarkFixture(pods)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(fixture)
+
+		// The Python runtime reads fixture data instead of querying a cluster
+		os.Setenv("UPID_FIXTURE_FILE", fixture)
+		defer os.Unsetenv("UPID_FIXTURE_FILE")
+	}
+
+	pb := getBridge()
+	// Retries would distort the timings
+	pb.SetRetryPolicy(bridge.NoRetry)
+	stages := []benchmarkStage{
+		{Name: "analyze cluster", Command: "analyze", Args: []string{"cluster", cluster}},
+		{Name: "analyze idle", Command: "analyze", Args: []string{"idle", "default"}},
+		{Name: "analyze resources", Command: "analyze", Args: []string{"resources", "all"}},
+	}
+
+	// Warm up interpreter and file caches so the first stage is not penalised
+	_, _, _ = pb.ExecuteCommandWithStats(cmd.Context(), "health", nil)
+
+	// Measure the cost of starting the runtime on its own
+	startup := runBenchmarkStage(cmd.Context(), pb, benchmarkStage{Name: "bridge startup", Command: "health"}, iterations, 0)
+
+	report := benchmarkReport{
+		Version:    config.GetVersion(),
+		Cluster:    cluster,
+		Synthetic:  synthetic,
+		Iterations: iterations,
+		Stages:     []benchmarkResult{startup},
+	}
+	for _, stage := range stages {
+		report.Stages = append(report.Stages, runBenchmarkStage(cmd.Context(), pb, stage, iterations, startup.Avg))
+	}
+
+	if format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode benchmark report: %v", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	printBenchmarkReport(report)
+	return nil
+}
+
+// runBenchmarkStage runs a stage the requested number of times
+func runBenchmarkStage(ctx context.Context, pb *bridge.PythonBridge, stage benchmarkStage, iterations int, overhead time.Duration) benchmarkResult {
+	result := benchmarkResult{Stage: stage.Name, BridgeOverhead: overhead}
+
+	var total time.Duration
+	for i := 0; i < iterations && ctx.Err() == nil; i++ {
+		result.Runs++
+		_, stats, err := pb.ExecuteCommandWithStats(ctx, stage.Command, stage.Args)
+		if err != nil {
+			result.Failures++
+		}
+		if stats == nil {
+			continue
+		}
+
+		total += stats.Duration
+		if result.Min == 0 || stats.Duration < result.Min {
+			result.Min = stats.Duration
+		}
+		if stats.Duration > result.Max {
+			result.Max = stats.Duration
+		}
+		if stats.MaxRSSKB > result.PeakMemoryKB {
+			result.PeakMemoryKB = stats.MaxRSSKB
+		}
+	}
+	if result.Runs > 0 {
+		result.Avg = total / time.Duration(result.Runs)
+	}
+
+	return result
+}
+
+// printBenchmarkReport renders the benchmark report as a table
+func printBenchmarkReport(report benchmarkReport) {
+	source := "cluster " + report.Cluster
+	if report.Synthetic {
+		source = "synthetic fixture"
+	}
+	fmt.Printf("UPID %s benchmark (%s, %d iterations)\n\n", report.Version, source, report.Iterations)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STAGE\tAVG\tMIN\tMAX\tPEAK MEMORY\tBRIDGE OVERHEAD\tFAILURES")
+	for _, r := range report.Stages {
+		overhead := "-"
+		if r.BridgeOverhead > 0 {
+			overhead = r.BridgeOverhead.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.1f MiB\t%s\t%d\n",
+			r.Stage,
+			r.Avg.Round(time.Millisecond),
+			r.Min.Round(time.Millisecond),
+			r.Max.Round(time.Millisecond),
+			float64(r.PeakMemoryKB)/1024,
+			overhead,
+			r.Failures)
+	}
+	w.Flush()
+}
+
+// writeBenchmarkFixture generates synthetic pod usage data for benchmarking
+func writeBenchmarkFixture(pods int) (string, error) {
+	type fixturePod struct {
+		Name          string  `json:"name"`
+		Namespace     string  `json:"namespace"`
+		CPURequest    float64 `json:"cpu_request"`
+		CPUUsage      float64 `json:"cpu_usage"`
+		MemoryRequest int64   `json:"memory_request"`
+		MemoryUsage   int64   `json:"memory_usage"`
+	}
+
+	// Fixed seed so runs are comparable between versions
+	rng := rand.New(rand.NewSource(42))
+	fixture := struct {
+		Pods []fixturePod `json:"pods"`
+	}{}
+	for i := 0; i < pods; i++ {
+		cpuRequest := 0.1 + rng.Float64()*2
+		memoryRequest := int64(64+rng.Intn(4032)) << 20
+		fixture.Pods = append(fixture.Pods, fixturePod{
+			Name:          fmt.Sprintf("workload-%d", i),
+			Namespace:     fmt.Sprintf("namespace-%d", i%20),
+			CPURequest:    cpuRequest,
+			CPUUsage:      cpuRequest * rng.Float64(),
+			MemoryRequest: memoryRequest,
+			MemoryUsage:   int64(float64(memoryRequest) * rng.Float64()),
+		})
+	}
+
+	data, err := json.Marshal(fixture)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode fixture data: %v", err)
+	}
+
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("upid-benchmark-%d.json", os.Getpid()))
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write fixture data: %v", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,158 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/cache"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// annotationCacheable marks read-only commands whose results may be served
+// from the result cache
+const annotationCacheable = "upid.io/cacheable"
+
+// cacheable marks cmd as a command whose results may be cached
+func cacheable(cmd *cobra.Command) *cobra.Command {
+	if cmd.Annotations == nil {
+		cmd.Annotations = map[string]string{}
+	}
+	cmd.Annotations[annotationCacheable] = "true"
+	return cmd
+}
+
+// resultCache returns the cache store and key for a runtime command, or a nil
+// store if the result of the active command must not be cached
+func resultCache(command string, args []string) (*cache.Store, string) {
+	settings := config.GetCacheConfig()
+	if !settings.Enabled || settings.TTL <= 0 || IsDryRun() {
+		return nil, ""
+	}
+	if activeCommand == nil || activeCommand.Annotations[annotationCacheable] != "true" {
+		return nil, ""
+	}
+
+	// Arguments carry the cluster name and time range; the kubeconfig
+	// context tells apart clusters reached under the same name, and the
+	// profile, endpoint and datasource the accounts and metrics they were
+	// analyzed with
+	parts := append([]string{command, kubeContext(), config.GetProfile(), config.GetEndpoint(), config.GetDatasourceConfig().URL}, args...)
+	return cache.New(config.GetCacheDir(), settings.TTL), cache.Key(parts...)
+}
+
+// loadCachedResult returns a cached result unless --no-cache was given
+func loadCachedResult(store *cache.Store, key string) (map[string]interface{}, bool) {
+	if store == nil {
+		return nil, false
+	}
+	if noCache, _ := activeFlagBool("no-cache"); noCache {
+		return nil, false
+	}
+
+	data, stored, ok := store.Get(key)
+	if !ok {
+		return nil, false
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		slog.Warn("ignoring unreadable cache entry", "key", key, "error", err)
+		return nil, false
+	}
+
+	age := time.Since(stored).Round(time.Second)
+	slog.Debug("serving result from cache", "key", key, "age", age)
+	if quiet, _ := activeFlagBool("quiet"); !quiet {
+		fmt.Fprintf(os.Stderr, "Using cached result from %s ago (--no-cache to refresh)\n", age)
+	}
+	return result, true
+}
+
+// storeCachedResult caches a complete result for later invocations
+func storeCachedResult(store *cache.Store, key string, result map[string]interface{}) {
+	if store == nil {
+		return
+	}
+	if partial, _ := result["partial"].(bool); partial {
+		return
+	}
+	data, err := json.Marshal(result)
+	if err == nil {
+		err = store.Put(key, data)
+	}
+	if err != nil {
+		slog.Warn("failed to cache result", "error", err)
+	}
+}
+
+// kubeContext identifies the Kubernetes cluster commands run against by the
+// kubeconfig file and its current context
+func kubeContext() string {
+	path := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		path = filepath.Join(home, ".kube", "config")
+	}
+	if paths := filepath.SplitList(os.Getenv("KUBECONFIG")); len(paths) > 0 && paths[0] != "" {
+		path = paths[0]
+	}
+
+	var kubeconfig struct {
+		CurrentContext string `yaml:"current-context"`
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		_ = yaml.Unmarshal(data, &kubeconfig)
+	}
+	return path + "#" + kubeconfig.CurrentContext
+}
+
+// systemCacheCmd creates the system cache command
+func systemCacheCmd() *cobra.Command {
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the result cache",
+		Long: `Manage the cache of analysis results.
+
+When enabled with "cache.enabled: true" in the configuration (or
+UPID_CACHE_ENABLED=true), results of read-only analysis commands are kept
+for cache.ttl (default 5m). Repeating a command with the same arguments
+against the same cluster within that time returns the cached result.
+Pass --no-cache to any command to bypass and refresh the cache.
+
+Examples:
+  upid system cache clear     # Remove all cached results`,
+	}
+
+	// Add subcommands
+	cacheCmd.AddCommand(mutating(&cobra.Command{
+		Use:   "clear",
+		Short: "Remove all cached results",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return systemCacheClear(cmd, args)
+		},
+	}))
+
+	return cacheCmd
+}
+
+// Implementation functions
+func systemCacheClear(cmd *cobra.Command, args []string) error {
+	store := cache.New(config.GetCacheDir(), config.GetCacheConfig().TTL)
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("remove cached results in %s", store.Dir()))
+	}
+
+	removed, err := store.Clear()
+	if err != nil {
+		return err
+	}
+	return renderResult(map[string]interface{}{
+		"message": fmt.Sprintf("Removed %d cached results", removed),
+		"removed": removed,
+	})
+}
@@ -0,0 +1,896 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/budget"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/notify"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/spf13/cobra"
+)
+
+// channelTypes are the names of the types of channels
+var channelTypes = map[string]string{
+	notify.TypeSlack:     "Slack",
+	notify.TypePagerDuty: "PagerDuty",
+	notify.TypeOpsgenie:  "Opsgenie",
+	notify.TypeWebhook:   "webhook",
+}
+
+// credentialChannel prefixes the stored webhook URL or token of a
+// notification channel
+const credentialChannel = "notify."
+
+// channelColumns are the table columns for notification channels
+var channelColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "type", Field: "type"},
+	{Name: "destination", Field: "destination"},
+	{Name: "severity", Header: "MIN SEVERITY", Field: "severity"},
+	{Name: "events", Field: "events"},
+	{Name: "created", Field: "created_at", Wide: true},
+}
+
+// monitorChannelsCmd creates the channels command
+func monitorChannelsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "channels",
+		Short: "Post alerts and savings to Slack, and page PagerDuty or Opsgenie",
+		Long: `Manage the channels UPID posts events to:
+
+  alerts           changes seen by 'upid monitor watch', budget alerts and
+                   incidents, from the minimum --severity of the channel
+  optimizations    recommendations applied and workloads scaled to zero
+  summaries        savings summaries posted by 'upid monitor channels summary'
+
+Slack channels post to an incoming webhook, or as a Slack app with a bot
+token to the --channel given. PagerDuty and Opsgenie channels page with
+alerts only, critical by default. Incidents are alerts, critical unless
+noted, that stay open until their condition clears, when they are resolved:
+
+  cluster unreachable   'upid monitor serve' cannot read the cluster
+  cost spike            the requests of the cluster cost --spike-threshold
+                        more than their average of the last day, seen by
+                        'upid monitor serve'
+  failed rollback       restoring workloads scaled to zero failed, until a
+                        rollback of their namespace succeeds
+  anomaly               the cost, CPU or memory of the cluster or of a
+                        namespace departs from its baseline by
+                        --anomaly-sensitivity, seen by 'upid monitor serve';
+                        a warning, critical from twice the sensitivity
+
+Alerts of the same condition share a deduplication key, so that paging
+services group them in one incident. The webhook URL, token or key of a
+channel is kept in the credential store; the channels are kept in
+channels.json and the open incidents in incidents.json in the state
+directory. Failing to notify a channel is logged and does not fail the
+command that notified it.
+
+To post a savings summary every week, schedule it:
+
+  upid optimize schedule @weekly --name savings-summary --command "monitor channels summary"
+
+Examples:
+  upid monitor channels add slack ops --webhook-stdin --severity critical
+  upid monitor channels add slack finops --token-stdin --channel '#finops' --events optimizations,summaries
+  upid monitor channels add pagerduty --routing-key-stdin
+  upid monitor channels add opsgenie --api-key-stdin --region eu
+  upid monitor channels list
+  upid monitor channels test ops
+  upid monitor channels summary
+  upid monitor channels remove ops`,
+	}
+
+	// Add subcommands
+	cmd.AddCommand(monitorChannelsAddCmd())
+	cmd.AddCommand(withColumns(monitorChannelsListCmd(), channelColumns))
+	cmd.AddCommand(monitorChannelsRemoveCmd())
+	cmd.AddCommand(monitorChannelsTestCmd())
+	cmd.AddCommand(monitorChannelsSummaryCmd())
+
+	return cmd
+}
+
+// monitorChannelsAddCmd creates the channels add command
+func monitorChannelsAddCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add",
+		Short: "Add or update a notification channel",
+	}
+
+	// Add subcommands
+	cmd.AddCommand(monitorChannelsAddSlackCmd())
+	cmd.AddCommand(monitorChannelsAddPagerDutyCmd())
+	cmd.AddCommand(monitorChannelsAddOpsgenieCmd())
+	cmd.AddCommand(monitorChannelsAddWebhookCmd())
+
+	return cmd
+}
+
+// monitorChannelsAddSlackCmd creates the channels add slack command
+func monitorChannelsAddSlackCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "slack [name]",
+		Short: "Post events to Slack",
+		Long: `Add a Slack channel, named slack unless a name is given, that posts to an
+incoming webhook with --webhook, or as an app with a bot token with --token
+to the --channel given; the app needs the chat:write scope and to be a
+member of the channel. Adding a channel with the name of an existing one
+replaces it.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return monitorChannelsAddSlack(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("webhook", "", "URL of a Slack incoming webhook")
+	cmd.Flags().Bool("webhook-stdin", false, "read the webhook URL from standard input")
+	cmd.Flags().String("token", "", "bot token of a Slack app (xoxb-...)")
+	cmd.Flags().Bool("token-stdin", false, "read the bot token from standard input")
+	cmd.Flags().String("channel", "", "Slack channel an app posts to, e.g. #finops or a channel ID")
+	cmd.Flags().StringP("severity", "s", kube.SeverityWarning, "minimum severity of the alerts posted (info, warning or critical)")
+	cmd.Flags().StringSlice("events", []string{"alerts", "optimizations", "summaries"}, "comma-separated events posted: alerts, optimizations, summaries")
+
+	return mutating(cmd)
+}
+
+// monitorChannelsAddPagerDutyCmd creates the channels add pagerduty command
+func monitorChannelsAddPagerDutyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pagerduty [name]",
+		Short: "Page a PagerDuty service with alerts",
+		Long: `Add a PagerDuty channel, named pagerduty unless a name is given, that
+triggers alerts in the service of the --routing-key of an Events API v2
+integration. Alerts of the same condition share a deduplication key, so
+that PagerDuty groups them in one incident, and the incidents UPID raises
+resolve when their condition clears. Adding a channel with the name of an
+existing one replaces it.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return monitorChannelsAddPaging(cmd, args, notify.TypePagerDuty, "routing-key",
+				map[string]string{"us": notify.PagerDutyUS, "eu": notify.PagerDutyEU})
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("routing-key", "", "integration key of an Events API v2 integration of the service")
+	cmd.Flags().Bool("routing-key-stdin", false, "read the routing key from standard input")
+	addPagingFlags(cmd)
+
+	return mutating(cmd)
+}
+
+// monitorChannelsAddOpsgenieCmd creates the channels add opsgenie command
+func monitorChannelsAddOpsgenieCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "opsgenie [name]",
+		Short: "Page Opsgenie with alerts",
+		Long: `Add an Opsgenie channel, named opsgenie unless a name is given, that creates
+alerts with the --api-key of an API integration. Alerts of the same
+condition share an alias, so that Opsgenie counts them as one alert, and
+the incidents UPID raises are closed when their condition clears. Adding a
+channel with the name of an existing one replaces it.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return monitorChannelsAddPaging(cmd, args, notify.TypeOpsgenie, "api-key",
+				map[string]string{"us": notify.OpsgenieUS, "eu": notify.OpsgenieEU})
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("api-key", "", "key of an API integration")
+	cmd.Flags().Bool("api-key-stdin", false, "read the API key from standard input")
+	addPagingFlags(cmd)
+
+	return mutating(cmd)
+}
+
+// monitorChannelsAddWebhookCmd creates the channels add webhook command
+func monitorChannelsAddWebhookCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "webhook [name]",
+		Short: "Post events to any HTTP endpoint",
+		Long: `Add a webhook channel, named webhook unless a name is given, that posts each
+event to --url as JSON rendered by a Go template, given with --template or
+--template-file, over the event:
+
+  .Kind        alert, optimization or summary
+  .Status      firing, acknowledged when someone handles an incident, or
+               resolved when its condition clears
+  .Severity    info, warning or critical
+  .Title .Message .Source
+  .Key         deduplication key of alerts
+  .Time        when it happened
+  .Details     its details by name, e.g. {{index .Details "Namespace"}}
+
+Templates may call json, which encodes a value with its quotes, upper,
+lower, rfc3339 and unix of a time. The payload must be JSON, so strings are
+given with json, e.g. {"text": {{json .Title}}}. Without a template, the
+payload is:
+
+` + notify.DefaultTemplate + `
+
+With a --signing-secret, requests carry the headers ` + notify.TimestampHeader + `, the Unix
+time they were sent, and ` + notify.SignatureHeader + `, sha256= followed by the hex
+HMAC-SHA256 of the timestamp, a dot and the body, keyed by the secret.
+Receivers should compute it again and reject old timestamps. The URL, the
+values of the headers and the signing secret are kept in the credential
+store. Adding a channel with the name of an existing one replaces it.
+
+Examples:
+  upid monitor channels add webhook events --url https://events.example.com/upid --signing-secret-stdin
+  upid monitor channels add webhook itsm --url https://itsm.example.com/api/incidents \
+    --header 'Authorization: Bearer ...' --template-file itsm.tmpl --events alerts -s critical`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return monitorChannelsAddWebhook(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("url", "", "URL the events are posted to")
+	cmd.Flags().StringArray("header", nil, "header of the requests as 'Name: value' (repeatable)")
+	cmd.Flags().String("template", "", "Go template of the JSON payload (default the event as JSON)")
+	cmd.Flags().String("template-file", "", "file with the Go template of the JSON payload")
+	cmd.Flags().String("signing-secret", "", "secret the payloads are signed with by HMAC-SHA256")
+	cmd.Flags().Bool("signing-secret-stdin", false, "read the signing secret from standard input")
+	cmd.Flags().StringP("severity", "s", kube.SeverityWarning, "minimum severity of the alerts posted (info, warning or critical)")
+	cmd.Flags().StringSlice("events", []string{"alerts", "optimizations", "summaries"}, "comma-separated events posted: alerts, optimizations, summaries")
+	_ = cmd.MarkFlagRequired("url")
+
+	return mutating(cmd)
+}
+
+// addPagingFlags adds the flags shared by the channels of paging services
+func addPagingFlags(cmd *cobra.Command) {
+	cmd.Flags().String("region", "us", "region of the account: us or eu")
+	cmd.Flags().String("endpoint", "", "base URL of the API, e.g. of a proxy (default by --region)")
+	cmd.Flags().StringP("severity", "s", kube.SeverityCritical, "minimum severity of the alerts paged (info, warning or critical)")
+}
+
+// monitorChannelsListCmd creates the channels list command
+func monitorChannelsListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the notification channels",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return monitorChannelsList(cmd, args)
+		},
+	}
+}
+
+// monitorChannelsRemoveCmd creates the channels remove command
+func monitorChannelsRemoveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove a notification channel and its stored secret",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return monitorChannelsRemove(cmd, args)
+		},
+	}
+
+	return mutating(cmd)
+}
+
+// monitorChannelsTestCmd creates the channels test command
+func monitorChannelsTestCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "test <name>",
+		Short: "Post a test message to a notification channel",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return monitorChannelsTest(cmd, args)
+		},
+	}
+}
+
+// monitorChannelsSummaryCmd creates the channels summary command
+func monitorChannelsSummaryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "summary",
+		Short: "Post a savings summary of the cluster to the channels",
+		Long: `Post the savings of the cluster to the channels that take summaries: the
+monthly savings of the workloads UPID scaled to zero, the cost of the
+requests pods do not use, the recommendations by severity and the status of
+the budgets of the cluster. Costs are per month in the currency of the
+pricing model in config. Run it weekly from a schedule, see 'upid monitor
+channels --help'.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return monitorChannelsSummary(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("time-range", "t", "", "time range of usage at the datasource (default current usage)")
+	cmd.Flags().Float64("confidence", 0.90, "confidence from which pods are idle")
+	cmd.Flags().Float64("cpu-price", 0, "price per requested core-hour (default pricing.cpu)")
+	cmd.Flags().Float64("memory-price", 0, "price per requested GiB-hour (default pricing.memory)")
+
+	return mutating(cmd)
+}
+
+// Implementation functions
+func monitorChannelsAddSlack(cmd *cobra.Command, args []string) error {
+	// Get flags
+	slackChannel, _ := cmd.Flags().GetString("channel")
+	severity, _ := cmd.Flags().GetString("severity")
+	events, _ := cmd.Flags().GetStringSlice("events")
+
+	webhook, err := secretFlag(cmd, "webhook")
+	if err != nil {
+		return err
+	}
+	token, err := secretFlag(cmd, "token")
+	if err != nil {
+		return err
+	}
+	name := notify.TypeSlack
+	if len(args) > 0 {
+		name = args[0]
+	}
+	channel := &notify.Channel{Name: name, Type: notify.TypeSlack, CreatedAt: time.Now().UTC()}
+	secret := webhook
+	switch {
+	case webhook != "" && token != "":
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "give either a webhook or a token, not both")
+	case webhook != "":
+		if err := notify.ValidateWebhook(webhook); err != nil {
+			return err
+		}
+		if slackChannel != "" {
+			return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "--channel applies only to --token, webhooks post to the channel they were created for")
+		}
+		channel.Auth = notify.AuthWebhook
+	case token != "":
+		if slackChannel == "" {
+			return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "--token requires the --channel to post to")
+		}
+		channel.Auth = notify.AuthToken
+		channel.SlackChannel = slackChannel
+		secret = token
+	default:
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "no Slack webhook or token").
+			WithHint("Give an incoming webhook with --webhook-stdin, or the bot token of an app with --token-stdin and --channel")
+	}
+	channel.Severity = strings.ToLower(severity)
+	if kube.SeverityRank(channel.Severity) < 0 {
+		return fmt.Errorf("invalid severity %q (expected %s)", severity, strings.Join(kube.Severities, ", "))
+	}
+	if channel.Events, err = notify.ParseEvents(events); err != nil {
+		return err
+	}
+
+	return saveChannel(channel, secret)
+}
+
+// saveChannel stores the secret of a channel and adds it, or replaces the
+// one with the same name
+func saveChannel(channel *notify.Channel, secret string) error {
+	store, err := notify.Load(channelsFile())
+	if err != nil {
+		return err
+	}
+	action := "Added"
+	if existing := store.Get(channel.Name); existing != nil {
+		action = "Updated"
+		channel.CreatedAt = existing.CreatedAt
+	}
+	if IsDryRun() {
+		return printDryRun(
+			fmt.Sprintf("store the %s of channel %s in the credential store", channel.Auth, channel.Name),
+			fmt.Sprintf("save channel %s in %s: %s", channel.Name, store.Path(), channelDestination(channel)))
+	}
+	backend, err := storeCredential(credentialChannel+channel.Name, secret)
+	if err != nil {
+		return err
+	}
+	store.Put(channel)
+	if err := store.Save(); err != nil {
+		return err
+	}
+
+	result := channelItem(channel)
+	result["message"] = fmt.Sprintf("%s %s channel %s posting to %s", action, channelTypes[channel.Type], channel.Name, channelDestination(channel))
+	result["credential_store"] = backend
+	result["hint"] = fmt.Sprintf("Check that it posts with 'upid monitor channels test %s'", channel.Name)
+	return renderResult(result)
+}
+
+// monitorChannelsAddPaging adds a channel of a paging service, which takes
+// alerts only, authenticated by the secret of keyFlag
+func monitorChannelsAddPaging(cmd *cobra.Command, args []string, channelType, keyFlag string, regions map[string]string) error {
+	// Get flags
+	region, _ := cmd.Flags().GetString("region")
+	endpointFlag, _ := cmd.Flags().GetString("endpoint")
+	severity, _ := cmd.Flags().GetString("severity")
+
+	key, err := secretFlag(cmd, keyFlag)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", fmt.Sprintf("no --%s", keyFlag)).
+			WithHint(fmt.Sprintf("Give it with --%s-stdin to keep it out of shell history", keyFlag))
+	}
+	endpoint, ok := regions[strings.ToLower(region)]
+	if !ok {
+		return fmt.Errorf("invalid --region %q (expected us or eu)", region)
+	}
+	if endpointFlag != "" {
+		if err := notify.ValidateWebhook(endpointFlag); err != nil {
+			return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("invalid --endpoint %q (expected an HTTP URL)", endpointFlag))
+		}
+		endpoint = strings.TrimSuffix(endpointFlag, "/")
+	}
+	name := channelType
+	if len(args) > 0 {
+		name = args[0]
+	}
+	channel := &notify.Channel{
+		Name:      name,
+		Type:      channelType,
+		Auth:      notify.AuthRoutingKey,
+		Endpoint:  endpoint,
+		Severity:  strings.ToLower(severity),
+		Events:    []string{notify.EventAlert},
+		CreatedAt: time.Now().UTC(),
+	}
+	if channelType == notify.TypeOpsgenie {
+		channel.Auth = notify.AuthAPIKey
+	}
+	if kube.SeverityRank(channel.Severity) < 0 {
+		return fmt.Errorf("invalid severity %q (expected %s)", severity, strings.Join(kube.Severities, ", "))
+	}
+	return saveChannel(channel, key)
+}
+
+func monitorChannelsAddWebhook(cmd *cobra.Command, args []string) error {
+	// Get flags
+	webhookURL, _ := cmd.Flags().GetString("url")
+	headers, _ := cmd.Flags().GetStringArray("header")
+	templateText, _ := cmd.Flags().GetString("template")
+	templateFile, _ := cmd.Flags().GetString("template-file")
+	severity, _ := cmd.Flags().GetString("severity")
+	events, _ := cmd.Flags().GetStringSlice("events")
+
+	signingSecret, err := secretFlag(cmd, "signing-secret")
+	if err != nil {
+		return err
+	}
+	if err := notify.ValidateWebhook(webhookURL); err != nil {
+		return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", "invalid --url (expected an HTTP URL)")
+	}
+	if templateFile != "" {
+		if templateText != "" {
+			return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "give either --template or --template-file, not both")
+		}
+		data, err := os.ReadFile(templateFile)
+		if err != nil {
+			return fmt.Errorf("failed to read --template-file: %v", err)
+		}
+		templateText = string(data)
+	}
+	if _, err := notify.ParseTemplate(templateText); err != nil {
+		return err
+	}
+	name := notify.TypeWebhook
+	if len(args) > 0 {
+		name = args[0]
+	}
+	channel := &notify.Channel{
+		Name:      name,
+		Type:      notify.TypeWebhook,
+		Auth:      notify.AuthWebhook,
+		Template:  templateText,
+		Signed:    signingSecret != "",
+		Severity:  strings.ToLower(severity),
+		CreatedAt: time.Now().UTC(),
+	}
+	secret := notify.WebhookSecret{URL: webhookURL, SigningSecret: signingSecret}
+	for _, header := range headers {
+		key, value, ok := strings.Cut(header, ":")
+		key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("invalid --header %q (expected 'Name: value')", header))
+		}
+		if secret.Headers == nil {
+			secret.Headers = map[string]string{}
+		}
+		secret.Headers[key] = strings.TrimSpace(value)
+		channel.Headers = append(channel.Headers, key)
+	}
+	if kube.SeverityRank(channel.Severity) < 0 {
+		return fmt.Errorf("invalid severity %q (expected %s)", severity, strings.Join(kube.Severities, ", "))
+	}
+	if channel.Events, err = notify.ParseEvents(events); err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+	return saveChannel(channel, string(encoded))
+}
+
+func monitorChannelsList(cmd *cobra.Command, args []string) error {
+	store, err := notify.Load(channelsFile())
+	if err != nil {
+		return err
+	}
+	items := make([]interface{}, 0, len(store.Channels))
+	for _, channel := range store.Channels {
+		items = append(items, channelItem(channel))
+	}
+	return renderResult(map[string]interface{}{
+		"message":  fmt.Sprintf("%d notification channels", len(items)),
+		"file":     store.Path(),
+		"channels": items,
+	})
+}
+
+func monitorChannelsRemove(cmd *cobra.Command, args []string) error {
+	store, err := notify.Load(channelsFile())
+	if err != nil {
+		return err
+	}
+	if store.Get(args[0]) == nil {
+		return fmt.Errorf("no notification channel named %q, see 'upid monitor channels list'", args[0])
+	}
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("remove channel %s from %s and its secret from the credential store", args[0], store.Path()))
+	}
+	store.Remove(args[0])
+	if err := store.Save(); err != nil {
+		return err
+	}
+	deleteCredential(credentialChannel + args[0])
+	return renderResult(map[string]interface{}{"message": fmt.Sprintf("Removed notification channel %s", args[0])})
+}
+
+func monitorChannelsTest(cmd *cobra.Command, args []string) error {
+	store, err := notify.Load(channelsFile())
+	if err != nil {
+		return err
+	}
+	channel := store.Get(args[0])
+	if channel == nil {
+		return fmt.Errorf("no notification channel named %q, see 'upid monitor channels list'", args[0])
+	}
+	event := notify.Event{
+		Kind:     notify.EventAlert,
+		Severity: kube.SeverityInfo,
+		Title:    "UPID test message",
+		Message:  fmt.Sprintf("Notifications of channel %s reach this destination.", channel.Name),
+		Source:   "upid",
+		Key:      "upid/test/" + channel.Name,
+		Fields:   []notify.Field{{Name: "Events", Value: strings.Join(channel.Events, ", ")}},
+		Time:     time.Now(),
+	}
+	notifier := channelNotifier(store)
+	err = notifier.Send(cmd.Context(), channel, event)
+	if err == nil && (channel.Type == notify.TypePagerDuty || channel.Type == notify.TypeOpsgenie) {
+		// Resolve the test alert at once rather than leave an incident open
+		event.Resolved = true
+		err = notifier.Send(cmd.Context(), channel, event)
+	}
+	if err != nil {
+		return clierr.New(clierr.CategoryUnreachable, "NOTIFY_FAILED", fmt.Sprintf("failed to post to channel %s: %v", channel.Name, err))
+	}
+	return renderResult(map[string]interface{}{
+		"message": fmt.Sprintf("Posted a test message to %s", channelDestination(channel)),
+	})
+}
+
+func monitorChannelsSummary(cmd *cobra.Command, args []string) error {
+	// Get flags
+	timeRange, _ := cmd.Flags().GetString("time-range")
+	confidence, _ := cmd.Flags().GetFloat64("confidence")
+
+	if confidence < 0 || confidence > 1 {
+		return fmt.Errorf("invalid --confidence %g (expected 0 to 1)", confidence)
+	}
+	store, err := notify.Load(channelsFile())
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, channel := range store.Channels {
+		if channel.Accepts(notify.Event{Kind: notify.EventSummary}) {
+			names = append(names, channel.Name)
+		}
+	}
+	if len(names) == 0 {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "no notification channel takes summaries").
+			WithHint("Add one with 'upid monitor channels add slack --events summaries'")
+	}
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return err
+	}
+	client, window, err := nativeClient(timeRange)
+	if err != nil {
+		return err
+	}
+	metrics, err := client.CollectMetrics(cmd.Context(), native.MetricsOptions{Window: window, MinConfidence: confidence, Prices: prices})
+	if err != nil {
+		return fmt.Errorf("failed to execute monitor command: %w", err)
+	}
+	event := savingsSummary(metrics)
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("post the savings summary of context %s to channels %s", metrics.Context, strings.Join(names, ", ")))
+	}
+
+	errs := channelNotifier(store).Notify(cmd.Context(), event)
+	result := map[string]interface{}{
+		"message":  fmt.Sprintf("Posted the savings summary of context %s to %d of %d channels", metrics.Context, len(names)-len(errs), len(names)),
+		"context":  metrics.Context,
+		"channels": names,
+	}
+	for _, f := range event.Fields {
+		result[strings.ReplaceAll(strings.ToLower(f.Name), " ", "_")] = f.Value
+	}
+	if len(errs) > 0 {
+		messages := make([]interface{}, len(errs))
+		for i, err := range errs {
+			messages[i] = err.Error()
+		}
+		result["partial"] = true
+		result["errors"] = messages
+	}
+	if err := renderResult(result); err != nil {
+		return err
+	}
+	printResultWarning(result)
+	return partialResultError(result)
+}
+
+// savingsSummary builds the summary event of the metrics of a cluster and
+// the status of its budgets
+func savingsSummary(m *native.Metrics) notify.Event {
+	total := func(values map[string]float64) float64 {
+		sum := 0.0
+		for _, value := range values {
+			sum += value
+		}
+		return sum
+	}
+	count := func(values map[string]int) int {
+		sum := 0
+		for _, value := range values {
+			sum += value
+		}
+		return sum
+	}
+	realized, waste := total(m.RealizedSavings), total(m.Waste)
+	event := notify.Event{
+		Kind:     notify.EventSummary,
+		Severity: kube.SeverityInfo,
+		Title:    fmt.Sprintf("UPID savings summary of %s", m.Context),
+		Source:   m.Context,
+		Message: fmt.Sprintf("UPID saves %s per month by scaling %d workloads to zero; pods leave %s per month of requests unused.",
+			formatCost(realized), count(m.ScaledWorkloads), formatCost(waste)),
+		Fields: []notify.Field{
+			{Name: "Realized savings", Value: formatCost(realized) + " per month"},
+			{Name: "Scaled workloads", Value: fmt.Sprint(count(m.ScaledWorkloads))},
+			{Name: "Estimated waste", Value: formatCost(waste) + " per month"},
+			{Name: "Idle workloads", Value: fmt.Sprint(count(m.IdleWorkloads))},
+			{Name: "Recommendations", Value: fmt.Sprintf("%d critical, %d warning, %d info",
+				m.Recommendations[kube.SeverityCritical], m.Recommendations[kube.SeverityWarning], m.Recommendations[kube.SeverityInfo])},
+		},
+		Time: m.CollectedAt,
+	}
+
+	store, err := budget.Load(budgetFile())
+	if err != nil {
+		slog.Warn("failed to read budgets", "error", err)
+		return event
+	}
+	statuses := map[string]int{}
+	budgets := 0
+	for _, b := range store.Budgets {
+		if b.Context == m.Context {
+			statuses[budgetItem(b, m.CollectedAt)["status"].(string)]++
+			budgets++
+		}
+	}
+	if budgets > 0 {
+		var parts []string
+		for _, status := range []string{budgetOver, budgetAtRisk, budgetOK, budgetUnevaluated} {
+			if statuses[status] > 0 {
+				parts = append(parts, fmt.Sprintf("%d %s", statuses[status], status))
+			}
+		}
+		event.Fields = append(event.Fields, notify.Field{Name: "Budgets", Value: strings.Join(parts, ", ")})
+	}
+	return event
+}
+
+// notifyEvent posts an event to the notification channels that accept it.
+// Notifying is best effort: failures are logged and do not fail the command.
+func notifyEvent(ctx context.Context, event notify.Event) {
+	store, err := notify.Load(channelsFile())
+	if err != nil {
+		slog.Warn("failed to read notification channels", "error", err)
+		return
+	}
+	for _, err := range channelNotifier(store).Notify(ctx, event) {
+		slog.Warn("failed to notify", "error", err)
+	}
+}
+
+// raiseIncident posts an alert, critical unless it has a severity, that
+// stays open until its condition clears and resolveIncident is called with
+// its key. Raising an incident that is open posts nothing, so it may be
+// raised each time the condition is seen. Failures are logged.
+func raiseIncident(ctx context.Context, event notify.Event) {
+	event.Kind = notify.EventAlert
+	if event.Severity == "" {
+		event.Severity = kube.SeverityCritical
+	}
+	updateIncidents(func(notifier *notify.Notifier, incidents *notify.Incidents) (bool, []error) {
+		return notifier.Raise(ctx, incidents, event)
+	})
+}
+
+// resolveIncident resolves the incident with a key, if it is open
+func resolveIncident(ctx context.Context, key, message string) {
+	updateIncidents(func(notifier *notify.Notifier, incidents *notify.Incidents) (bool, []error) {
+		return notifier.Resolve(ctx, incidents, key, message)
+	})
+}
+
+// updateIncidents raises or resolves an incident and saves the incidents
+// if they changed
+func updateIncidents(update func(*notify.Notifier, *notify.Incidents) (bool, []error)) {
+	store, err := notify.Load(channelsFile())
+	if err != nil {
+		slog.Warn("failed to read notification channels", "error", err)
+		return
+	}
+	incidents, err := notify.LoadIncidents(incidentsFile())
+	if err != nil {
+		slog.Warn("failed to read incidents", "error", err)
+		return
+	}
+	changed, errs := update(channelNotifier(store), incidents)
+	for _, err := range errs {
+		slog.Warn("failed to notify", "error", err)
+	}
+	if changed {
+		if err := incidents.Save(); err != nil {
+			slog.Warn("failed to save incidents", "error", err)
+		}
+	}
+}
+
+// incidentKey identifies a condition of a cluster, e.g. its cost spiking
+func incidentKey(kubeContext, condition string, parts ...string) string {
+	return strings.Join(append([]string{"upid", kubeContext, condition}, parts...), "/")
+}
+
+// changeEvent reports a change of a cluster as an alert
+func changeEvent(kubeContext string, change kube.Change) notify.Event {
+	fields := []notify.Field{{Name: "Context", Value: kubeContext}}
+	if change.Namespace != "" {
+		fields = append(fields, notify.Field{Name: "Namespace", Value: change.Namespace})
+	}
+	return notify.Event{
+		Kind:     notify.EventAlert,
+		Severity: change.Severity,
+		Title:    fmt.Sprintf("%s: %s/%s", change.Reason, change.Kind, change.Name),
+		Message:  change.Message,
+		Source:   kubeContext,
+		Key:      incidentKey(kubeContext, strings.ToLower(change.Reason), change.Namespace, change.Kind, change.Name),
+		Fields:   fields,
+		Time:     change.Time,
+	}
+}
+
+// optimizationEvent reports an optimization that was applied, from its
+// result
+func optimizationEvent(title string, result map[string]interface{}) notify.Event {
+	message, _ := result["message"].(string)
+	event := notify.Event{
+		Kind:     notify.EventOptimization,
+		Severity: kube.SeverityInfo,
+		Title:    title,
+		Message:  message,
+		Time:     time.Now(),
+	}
+	if kubeContext, ok := result["context"].(string); ok {
+		event.Source = kubeContext
+		event.Fields = append(event.Fields, notify.Field{Name: "Context", Value: kubeContext})
+	}
+	if savings, ok := result["monthly_savings"].(float64); ok && savings > 0 {
+		event.Fields = append(event.Fields, notify.Field{Name: "Monthly savings", Value: formatCost(savings)})
+	}
+	if errs, ok := result["errors"].([]interface{}); ok && len(errs) > 0 {
+		event.Severity = kube.SeverityWarning
+		event.Fields = append(event.Fields, notify.Field{Name: "Failures", Value: fmt.Sprint(len(errs))})
+	}
+	return event
+}
+
+// channelNotifier returns the notifier of the channels of a store
+func channelNotifier(store *notify.Store) *notify.Notifier {
+	notifier := &notify.Notifier{Channels: store.Channels, Secret: channelSecret, Routes: config.GetAlertsConfig().Routes.Channels()}
+	silences, err := notify.LoadSilences(silencesFile())
+	if err != nil {
+		slog.Warn("alerts are not silenced", "error", err)
+		return notifier
+	}
+	notifier.Silences = silences
+	return notifier
+}
+
+// channelSecret returns the stored webhook URL or token of a channel
+func channelSecret(channel *notify.Channel) (string, error) {
+	secret, err := credentialStore().Get(credentialName(credentialChannel + channel.Name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the %s of the channel: %w", channel.Auth, err)
+	}
+	return secret, nil
+}
+
+// channelDestination describes where a channel posts
+func channelDestination(channel *notify.Channel) string {
+	switch {
+	case channel.Type == notify.TypeWebhook:
+		return "its URL"
+	case channel.Auth == notify.AuthToken:
+		return channel.SlackChannel
+	case channel.Endpoint != "":
+		return strings.TrimPrefix(strings.TrimPrefix(channel.Endpoint, "https://"), "http://")
+	}
+	return "its webhook"
+}
+
+// channelItem formats a channel for output
+func channelItem(channel *notify.Channel) map[string]interface{} {
+	destination := channelDestination(channel)
+	switch {
+	case channel.Type == notify.TypeWebhook:
+		destination = "URL"
+	case channel.Auth == notify.AuthWebhook:
+		destination = "webhook"
+	}
+	return map[string]interface{}{
+		"name":          channel.Name,
+		"type":          channel.Type,
+		"auth":          channel.Auth,
+		"destination":   destination,
+		"severity":      channel.Severity,
+		"events":        strings.Join(channel.Events, ","),
+		"created_at":    channel.CreatedAt.Local().Format(time.RFC3339),
+		"secret_stored": hasCredential(credentialChannel + channel.Name),
+		"headers":       strings.Join(channel.Headers, ","),
+		"signed":        channel.Signed,
+		"template":      channel.Template != "",
+	}
+}
+
+// incidentsFile returns the local file of open incidents
+func incidentsFile() string {
+	return filepath.Join(config.GetStateDir(), "incidents.json")
+}
+
+// channelsFile returns the local file of notification channels
+func channelsFile() string {
+	return filepath.Join(config.GetStateDir(), "channels.json")
+}
@@ -0,0 +1,228 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/reports"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
+	"github.com/spf13/cobra"
+)
+
+// chargebackColumns are the table columns for chargeback invoices
+var chargebackColumns = []output.Column{
+	{Name: "group", Field: "group"},
+	{Name: "pods", Field: "pods"},
+	{Name: "direct", Header: "DIRECT", Field: "direct_cost"},
+	{Name: "shared", Header: "SHARED", Field: "shared_cost"},
+	{Name: "total", Header: "TOTAL", Field: "total_cost"},
+	{Name: "percent", Header: "% OF CLUSTER", Field: "cost_percent"},
+}
+
+// reportChargeback generates the chargeback invoices of a cluster and writes
+// them to a PDF or CSV file
+func reportChargeback(cmd *cobra.Command, cluster, timeRange, format string) error {
+	// Get flags
+	by, _ := cmd.Flags().GetString("by")
+	shared, _ := cmd.Flags().GetString("shared")
+	overhead, _ := cmd.Flags().GetString("overhead")
+	sharedNamespaces, _ := cmd.Flags().GetStringSlice("shared-namespaces")
+	outputFile, _ := cmd.Flags().GetString("output-file")
+
+	if !strings.Contains(by, ":") && by != "namespace" && by != "owner" {
+		by = "label:" + by
+	}
+	dimension, err := native.ParseDimension(by)
+	if err != nil {
+		return err
+	}
+	policy, err := native.ParseSharedPolicy(shared)
+	if err != nil {
+		return err
+	}
+	if !cmd.Flags().Changed("time-range") {
+		timeRange = "month"
+	}
+	start, end, err := chargebackPeriod(timeRange, time.Now().UTC())
+	if err != nil {
+		return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", err.Error())
+	}
+	if format != "pdf" && format != "csv" {
+		return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+			fmt.Sprintf("invalid chargeback format %q (expected pdf or csv)", format))
+	}
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return err
+	}
+	opts := native.ChargebackOptions{
+		By:               dimension,
+		Shared:           policy,
+		SharedNamespaces: sharedNamespaces,
+		Prices:           prices,
+		Start:            start,
+		End:              end,
+	}
+	if policy == native.SharedFixed {
+		if opts.Overhead, opts.OverheadPercent, err = parseOverhead(overhead); err != nil {
+			return err
+		}
+	} else if cmd.Flags().Changed("overhead") {
+		return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", "--overhead applies only to --shared fixed")
+	}
+
+	client, err := native.NewClient(cluster)
+	if err != nil {
+		return err
+	}
+	result, err := priced(client.Chargeback(cmd.Context(), opts))
+	if err != nil {
+		return fmt.Errorf("failed to execute report command: %w", err)
+	}
+
+	var data []byte
+	if format == "csv" {
+		data, err = chargebackCSV(result)
+	} else {
+		var b bytes.Buffer
+		err = output.WritePDF(&b, chargebackPages(result))
+		data = b.Bytes()
+	}
+	if err != nil {
+		return err
+	}
+	if outputFile == "" {
+		name := dimension.Key
+		if name == "" {
+			name = dimension.Kind
+		}
+		outputFile = fmt.Sprintf("chargeback-%s-%s.%s", strings.ReplaceAll(name, "/", "-"), start.Format("2006-01-02"), format)
+	}
+	id := keepReport(&reports.Report{Type: "chargeback", Context: client.Context(), Period: timeRange, Format: format}, outputFile, data)
+	if outputFile == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	result["report_id"] = id
+	if err := renderSections(result, []section{{"invoices", chargebackColumns}}); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputFile, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", outputFile, err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d invoices to %s\n", len(result["invoices"].([]interface{})), outputFile)
+	return nil
+}
+
+// chargebackPeriod parses the period of a chargeback: month (the current
+// calendar month), last-month, or a period of timeutil.ParsePeriod
+func chargebackPeriod(value string, now time.Time) (time.Time, time.Time, error) {
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	switch value {
+	case "month":
+		return start, start.AddDate(0, 1, 0), nil
+	case "last-month":
+		return start.AddDate(0, -1, 0), start, nil
+	}
+	return timeutil.ParsePeriod(value, now)
+}
+
+// parseOverhead parses the overhead of the fixed shared cost policy: a
+// percentage of direct cost such as 15%, or a monthly amount per group
+func parseOverhead(value string) (float64, bool, error) {
+	value = strings.TrimSpace(value)
+	percent := strings.HasSuffix(value, "%")
+	overhead, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || overhead < 0 {
+		return 0, false, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+			fmt.Sprintf("invalid overhead %q (expected a percentage of direct cost such as 15%% or a monthly amount per group)", value))
+	}
+	return overhead, percent, nil
+}
+
+// chargebackCSV formats the invoices of a chargeback as CSV, with a row per
+// workload followed by the shared cost and total of each invoice
+func chargebackCSV(result map[string]interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	writer := csv.NewWriter(&b)
+	currency, _ := result["currency"].(string)
+	start, end := result["period_start"].(string), result["period_end"].(string)
+	writer.Write([]string{"group", "item", "namespace", "workload", "pods", "cpu_requests", "memory_requests", "amount", "currency", "period_start", "period_end"})
+	row := func(group, item, namespace, workload string, pods, cpu, memory, amount interface{}) {
+		record := []string{group, item, namespace, workload, "", "", "", output.FormatValue(amount), currency, start, end}
+		for i, value := range []interface{}{pods, cpu, memory} {
+			if value != nil {
+				record[4+i] = output.FormatValue(value)
+			}
+		}
+		writer.Write(record)
+	}
+	for _, value := range result["invoices"].([]interface{}) {
+		invoice := value.(map[string]interface{})
+		group := invoice["group"].(string)
+		for _, value := range invoice["lines"].([]interface{}) {
+			line := value.(map[string]interface{})
+			row(group, "workload", line["namespace"].(string), line["workload"].(string),
+				line["pods"], line["cpu_requests"], line["memory_requests"], line["cost"])
+		}
+		row(group, "shared", "", "", nil, nil, nil, invoice["shared_cost"])
+		row(group, "total", "", "", invoice["pods"], nil, nil, invoice["total_cost"])
+	}
+	writer.Flush()
+	return b.Bytes(), writer.Error()
+}
+
+// chargebackPages lays out the invoices of a chargeback as a page each
+func chargebackPages(result map[string]interface{}) [][]string {
+	period := fmt.Sprintf("%s to %s", result["period_start"], result["period_end"])
+	sharedLabel := "Shared and idle cost (" + result["shared_policy"].(string) + ")"
+	if result["shared_policy"] == native.SharedFixed {
+		sharedLabel = "Fixed overhead"
+	}
+	cost := func(value interface{}) string {
+		v, _ := value.(float64)
+		return formatCost(v)
+	}
+
+	var pages [][]string
+	for _, value := range result["invoices"].([]interface{}) {
+		invoice := value.(map[string]interface{})
+		lines := []string{
+			fmt.Sprintf("CHARGEBACK INVOICE - %s %s", result["by"], invoice["group"]),
+			"",
+			fmt.Sprintf("Cluster: %s", result["context"]),
+			fmt.Sprintf("Period:  %s", period),
+			"",
+			fmt.Sprintf("%-20s %-34s %4s %14s", "NAMESPACE", "WORKLOAD", "PODS", "COST"),
+		}
+		for _, value := range invoice["lines"].([]interface{}) {
+			line := value.(map[string]interface{})
+			lines = append(lines, fmt.Sprintf("%-20s %-34s %4v %14s",
+				truncate(line["namespace"].(string), 20), truncate(line["workload"].(string), 34), line["pods"], cost(line["cost"])))
+		}
+		lines = append(lines,
+			strings.Repeat("-", 75),
+			fmt.Sprintf("%-60s %14s", "Direct cost", cost(invoice["direct_cost"])),
+			fmt.Sprintf("%-60s %14s", sharedLabel, cost(invoice["shared_cost"])),
+			fmt.Sprintf("%-60s %14s", "TOTAL", cost(invoice["total_cost"])),
+		)
+		pages = append(pages, lines)
+	}
+	return pages
+}
+
+// truncate shortens text to a width, marking the cut with '~'
+func truncate(text string, width int) string {
+	if len(text) <= width {
+		return text
+	}
+	return text[:width-1] + "~"
+}
@@ -1,8 +1,14 @@
 package commands
 
 import (
+	"context"
 	"fmt"
+	"path/filepath"
 
+	"github.com/kubilitics/upid-cli/internal/bridge"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/output"
 	"github.com/spf13/cobra"
 )
 
@@ -17,6 +23,8 @@ Examples:
   upid cluster list                    # List all clusters
   upid cluster get my-cluster          # Get cluster details
   upid cluster add my-cluster          # Add a new cluster
+  upid cluster import --all            # Add every kubeconfig context
+  upid cluster snapshot                # Show workloads read from the cluster
   upid cluster status my-cluster       # Get cluster health status`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return listClusters(cmd, args)
@@ -27,19 +35,48 @@ Examples:
 	clusterCmd.AddCommand(listClustersCmd())
 	clusterCmd.AddCommand(getClusterCmd())
 	clusterCmd.AddCommand(addClusterCmd())
+	clusterCmd.AddCommand(importClustersCmd())
 	clusterCmd.AddCommand(updateClusterCmd())
 	clusterCmd.AddCommand(deleteClusterCmd())
 	clusterCmd.AddCommand(clusterStatusCmd())
+	clusterCmd.AddCommand(clusterSnapshotCmd())
 
 	return clusterCmd
 }
 
+// clusterColumns are the table columns for cluster listings
+var clusterColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "id", Field: "id", Wide: true},
+	{Name: "status", Field: "status"},
+	{Name: "version", Field: "kubernetes_version"},
+	{Name: "nodes", Field: "node_count"},
+	{Name: "pods", Field: "pod_count", Wide: true},
+	{Name: "cpu", Header: "CPU %", Field: "cpu_utilization"},
+	{Name: "memory", Header: "MEMORY %", Field: "memory_utilization"},
+	{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+	{Name: "region", Field: "region", Wide: true},
+	{Name: "organization", Field: "organization", Wide: true},
+	{Name: "created", Field: "created_at", Wide: true},
+}
+
 // listClustersCmd creates the list clusters command
 func listClustersCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List all clusters",
-		Long:  "List all clusters accessible to the current user",
+		Long: `List all clusters accessible to the current user.
+
+With --from-kubeconfig the contexts of the kubeconfig are listed instead,
+with whether their API server answers and whether they are registered in
+UPID. --register-missing also registers every reachable context that is not
+registered yet, like 'upid cluster import' does.
+
+Examples:
+  upid cluster list                                   # Registered clusters
+  upid cluster list --from-kubeconfig                 # Kubeconfig contexts and their registration
+  upid cluster list --register-missing                # Register the reachable contexts that are missing
+  upid cluster list --register-missing --dry-run      # Show the clusters that would be registered`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return listClusters(cmd, args)
 		},
@@ -47,10 +84,13 @@ func listClustersCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("status", "s", "", "filter by status (active, inactive, error)")
-	cmd.Flags().StringP("organization", "o", "", "filter by organization")
-	cmd.Flags().BoolP("detailed", "d", false, "detailed output")
+	cmd.Flags().String("organization", "", "filter by organization")
+	cmd.Flags().Bool("detailed", false, "detailed output")
+	cmd.Flags().Bool("from-kubeconfig", false, "list the kubeconfig contexts and whether they are registered")
+	cmd.Flags().Bool("register-missing", false, "register reachable kubeconfig contexts that are not registered (implies --from-kubeconfig)")
+	cmd.Flags().StringP("kubeconfig", "k", "", "kubeconfig file (default $KUBECONFIG or ~/.kube/config)")
 
-	return cmd
+	return withColumns(cmd, clusterColumns)
 }
 
 // getClusterCmd creates the get cluster command
@@ -67,7 +107,7 @@ func getClusterCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().BoolP("include-metrics", "m", false, "include cluster metrics")
-	cmd.Flags().BoolP("include-costs", "c", false, "include cost information")
+	cmd.Flags().Bool("include-costs", false, "include cost information")
 
 	return cmd
 }
@@ -88,11 +128,62 @@ func addClusterCmd() *cobra.Command {
 	cmd.Flags().StringP("kubeconfig", "k", "", "path to kubeconfig file")
 	cmd.Flags().StringP("context", "x", "", "kubernetes context to use")
 	cmd.Flags().StringP("namespace", "n", "default", "default namespace")
-	cmd.Flags().StringP("description", "d", "", "cluster description")
-	cmd.Flags().StringP("organization", "o", "", "organization ID")
+	cmd.Flags().String("description", "", "cluster description")
+	cmd.Flags().String("organization", "", "organization ID")
 	cmd.Flags().BoolP("auto-monitor", "m", true, "enable automatic monitoring")
 
-	return cmd
+	return mutating(cmd)
+}
+
+// importClustersCmd creates the import clusters command
+func importClustersCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import [context...]",
+		Short: "Add clusters from kubeconfig contexts",
+		Long: `Add the clusters of kubeconfig contexts to UPID, one cluster per context.
+
+Each cluster is named after its context, shortened to the cluster name for
+contexts generated by EKS (arn:aws:eks:...:cluster/<name>), GKE
+(gke_<project>_<location>_<name>) and user@cluster contexts.
+
+Examples:
+  upid cluster import --all                           # Every context in ~/.kube/config
+  upid cluster import --kubeconfig prod.yaml --all    # Every context in another file
+  upid cluster import kind-dev staging                # Selected contexts
+  upid cluster import --all --dry-run                 # Show the clusters that would be added`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return importClusters(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("kubeconfig", "k", "", "kubeconfig file (default $KUBECONFIG or ~/.kube/config)")
+	cmd.Flags().BoolP("all", "a", false, "import every context")
+
+	return withColumns(mutating(cmd), importColumns)
+}
+
+// importColumns are the table columns for imported clusters
+var importColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "context", Field: "context"},
+	{Name: "server", Field: "server", Wide: true},
+	{Name: "namespace", Field: "namespace", Wide: true},
+	{Name: "status", Field: "status"},
+	{Name: "error", Field: "error"},
+}
+
+// kubeconfigColumns are the table columns for kubeconfig contexts
+var kubeconfigColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "context", Field: "context"},
+	{Name: "current", Field: "current", Wide: true},
+	{Name: "server", Field: "server", Wide: true},
+	{Name: "status", Field: "status"},
+	{Name: "version", Field: "kubernetes_version"},
+	{Name: "latency", Header: "LATENCY MS", Field: "latency_ms", Wide: true},
+	{Name: "registered", Field: "registered"},
+	{Name: "error", Field: "error"},
 }
 
 // updateClusterCmd creates the update cluster command
@@ -109,12 +200,12 @@ func updateClusterCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("name", "n", "", "new cluster name")
-	cmd.Flags().StringP("description", "d", "", "cluster description")
+	cmd.Flags().String("description", "", "cluster description")
 	cmd.Flags().StringP("kubeconfig", "k", "", "path to kubeconfig file")
 	cmd.Flags().StringP("context", "x", "", "kubernetes context")
 	cmd.Flags().BoolP("auto-monitor", "m", false, "enable/disable automatic monitoring")
 
-	return cmd
+	return mutating(cmd)
 }
 
 // deleteClusterCmd creates the delete cluster command
@@ -131,9 +222,9 @@ func deleteClusterCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().BoolP("force", "f", false, "force deletion without confirmation")
-	cmd.Flags().BoolP("cleanup-data", "c", false, "cleanup all associated data")
+	cmd.Flags().Bool("cleanup-data", false, "cleanup all associated data")
 
-	return cmd
+	return mutating(cmd)
 }
 
 // clusterStatusCmd creates the cluster status command
@@ -149,7 +240,7 @@ func clusterStatusCmd() *cobra.Command {
 	}
 
 	// Add flags
-	cmd.Flags().BoolP("detailed", "d", false, "detailed status information")
+	cmd.Flags().Bool("detailed", false, "detailed status information")
 	cmd.Flags().StringP("time-range", "t", "1h", "time range for metrics")
 
 	return cmd
@@ -161,6 +252,13 @@ func listClusters(cmd *cobra.Command, args []string) error {
 	status, _ := cmd.Flags().GetString("status")
 	organization, _ := cmd.Flags().GetString("organization")
 	detailed, _ := cmd.Flags().GetBool("detailed")
+	fromKubeconfig, _ := cmd.Flags().GetBool("from-kubeconfig")
+	registerMissing, _ := cmd.Flags().GetBool("register-missing")
+	kubeconfig, _ := cmd.Flags().GetString("kubeconfig")
+
+	if fromKubeconfig || registerMissing {
+		return listKubeconfigClusters(cmd, kubeconfig, registerMissing)
+	}
 
 	// Build arguments
 	cmdArgs := []string{"clusters", "list"}
@@ -174,7 +272,12 @@ func listClusters(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--detailed")
 	}
 
-	return executePythonCommand("clusters", cmdArgs)
+	if runtimeMissing() {
+		return executeNative(cmd.Context(), "clusters", cmdArgs, func(ctx context.Context) (map[string]interface{}, error) {
+			return native.ListClusters(ctx, status)
+		})
+	}
+	return executePythonCommand(cmd.Context(), "clusters", cmdArgs)
 }
 
 func getCluster(cmd *cobra.Command, args []string) error {
@@ -191,7 +294,7 @@ func getCluster(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--include-costs")
 	}
 
-	return executePythonCommand("clusters", cmdArgs)
+	return executePythonCommand(cmd.Context(), "clusters", cmdArgs)
 }
 
 func addCluster(cmd *cobra.Command, args []string) error {
@@ -224,7 +327,255 @@ func addCluster(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--no-auto-monitor")
 	}
 
-	return executePythonCommand("clusters", cmdArgs)
+	return executePythonCommand(cmd.Context(), "clusters", cmdArgs)
+}
+
+func importClusters(cmd *cobra.Command, args []string) error {
+	kubeconfig, _ := cmd.Flags().GetString("kubeconfig")
+	all, _ := cmd.Flags().GetBool("all")
+
+	if all == (len(args) > 0) {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "name the contexts to import or use --all").
+			WithHint("List the contexts with 'kubectl config get-contexts'")
+	}
+	kubeconfig = absKubeconfig(kubeconfig)
+
+	contexts, err := native.ReadContexts(kubeconfig)
+	if err != nil {
+		return err
+	}
+	if !all {
+		selected := make([]native.KubeContext, 0, len(args))
+		for _, name := range args {
+			found := false
+			for _, context := range contexts {
+				if context.Name == name {
+					selected = append(selected, context)
+					found = true
+				}
+			}
+			if !found {
+				return clierr.New(clierr.CategoryUsage, "UNKNOWN_CONTEXT", fmt.Sprintf("context %q not found in kubeconfig", name)).
+					WithHint("List the contexts with 'kubectl config get-contexts'")
+			}
+		}
+		contexts = selected
+	}
+	names := native.ClusterNames(contexts)
+
+	pb := getBridge()
+	if IsDryRun() {
+		return printDryRun(registerActions(pb, kubeconfig, contexts, names)...)
+	}
+	if runtimeMissing() {
+		return requiresRuntime("registering clusters")
+	}
+
+	items := make([]interface{}, 0, len(contexts))
+	var failures []interface{}
+	for i, context := range contexts {
+		item := map[string]interface{}{
+			"name":      names[i],
+			"context":   context.Name,
+			"server":    context.Server,
+			"namespace": context.Namespace,
+			"status":    "imported",
+		}
+		if err := registerContext(cmd.Context(), pb, kubeconfig, context, names[i]); err != nil {
+			if cmd.Context().Err() != nil {
+				return err
+			}
+			item["status"] = "failed"
+			item["error"] = clierr.From(err).Message
+			failures = append(failures, fmt.Sprintf("%s: %s", context.Name, item["error"]))
+		}
+		items = append(items, item)
+	}
+
+	result := map[string]interface{}{
+		"message":  fmt.Sprintf("Imported %d of %d clusters", len(contexts)-len(failures), len(contexts)),
+		"clusters": items,
+	}
+	if len(failures) > 0 {
+		result["partial"] = true
+		result["errors"] = failures
+	}
+	if err := renderResult(result); err != nil {
+		return err
+	}
+	return partialResultError(result)
+}
+
+// listKubeconfigClusters lists the kubeconfig contexts with their
+// reachability and registration, and registers the missing ones if asked to
+func listKubeconfigClusters(cmd *cobra.Command, kubeconfig string, registerMissing bool) error {
+	ctx := cmd.Context()
+	withColumns(cmd, kubeconfigColumns)
+	kubeconfig = absKubeconfig(kubeconfig)
+
+	contexts, err := native.ReadContexts(kubeconfig)
+	if err != nil {
+		return err
+	}
+	names := native.ClusterNames(contexts)
+
+	// Registration is only known to the runtime
+	var registry []interface{}
+	if !runtimeMissing() {
+		if err := checkLogin(ctx); err != nil {
+			return err
+		}
+		result, err := getBridge().ExecuteCommandWithJSON(ctx, "clusters", []string{"clusters", "list"})
+		if err != nil {
+			return fmt.Errorf("failed to list registered clusters: %w", err)
+		}
+		registry, _ = result["clusters"].([]interface{})
+	} else if registerMissing {
+		return requiresRuntime("registering clusters")
+	}
+
+	reachability := native.ProbeContexts(ctx, kubeconfig, contexts)
+	items := make([]map[string]interface{}, len(contexts))
+	var missing []int
+	for i, context := range contexts {
+		item := map[string]interface{}{
+			"name":    names[i],
+			"context": context.Name,
+			"server":  context.Server,
+			"current": context.Current,
+			"status":  "unreachable",
+		}
+		if r := reachability[i]; r.Reachable {
+			item["status"] = "reachable"
+			item["kubernetes_version"] = r.Version
+			item["latency_ms"] = r.Latency.Milliseconds()
+		} else {
+			item["error"] = r.Error
+		}
+		if !runtimeMissing() {
+			registered := isRegistered(registry, context.Name, names[i])
+			item["registered"] = registered
+			if !registered && reachability[i].Reachable {
+				missing = append(missing, i)
+			}
+		}
+		items[i] = item
+	}
+
+	result := map[string]interface{}{
+		"message": fmt.Sprintf("Found %d contexts in kubeconfig, %d reachable", len(contexts), countReachable(reachability)),
+	}
+	if !runtimeMissing() {
+		result["message"] = fmt.Sprintf("%s, %d of them not registered", result["message"], len(missing))
+	}
+
+	if registerMissing && len(missing) > 0 {
+		pb := getBridge()
+		selected := make([]native.KubeContext, len(missing))
+		selectedNames := make([]string, len(missing))
+		for j, i := range missing {
+			selected[j], selectedNames[j] = contexts[i], names[i]
+		}
+		if preview, _ := activeFlagBool("dry-run"); preview {
+			return printDryRun(registerActions(pb, kubeconfig, selected, selectedNames)...)
+		}
+
+		// A failed registration may have been partly applied, never repeat it
+		pb.SetRetryPolicy(bridge.NoRetry)
+		var failures []interface{}
+		for _, i := range missing {
+			if err := registerContext(ctx, pb, kubeconfig, contexts[i], names[i]); err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				items[i]["error"] = clierr.From(err).Message
+				failures = append(failures, fmt.Sprintf("%s: %s", contexts[i].Name, items[i]["error"]))
+				continue
+			}
+			items[i]["registered"] = true
+		}
+		result["message"] = fmt.Sprintf("Registered %d of %d missing clusters", len(missing)-len(failures), len(missing))
+		if len(failures) > 0 {
+			result["partial"] = true
+			result["errors"] = failures
+		}
+	}
+
+	clusters := make([]interface{}, len(items))
+	for i, item := range items {
+		clusters[i] = item
+	}
+	result["clusters"] = clusters
+	if err := renderResult(result); err != nil {
+		return err
+	}
+	return partialResultError(result)
+}
+
+// absKubeconfig makes a kubeconfig path absolute, as the runtime may run in
+// another working directory
+func absKubeconfig(kubeconfig string) string {
+	if kubeconfig == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(kubeconfig); err == nil {
+		return abs
+	}
+	return kubeconfig
+}
+
+// registerArgs are the runtime arguments that register a context as a
+// cluster, like 'cluster add' does
+func registerArgs(kubeconfig string, context native.KubeContext, name string) []string {
+	cmdArgs := []string{"clusters", "add", name, "--context", context.Name}
+	if kubeconfig != "" {
+		cmdArgs = append(cmdArgs, "--kubeconfig", kubeconfig)
+	}
+	if context.Namespace != "" {
+		cmdArgs = append(cmdArgs, "--namespace", context.Namespace)
+	}
+	return cmdArgs
+}
+
+// registerActions describes the registration of contexts for a dry run
+func registerActions(pb *bridge.PythonBridge, kubeconfig string, contexts []native.KubeContext, names []string) []string {
+	actions := make([]string, len(contexts))
+	for i, context := range contexts {
+		actions[i] = pb.CommandLine("clusters", append(registerArgs(kubeconfig, context, names[i]), "--format", "json"))
+	}
+	return actions
+}
+
+// registerContext registers a context as a cluster
+func registerContext(ctx context.Context, pb *bridge.PythonBridge, kubeconfig string, kubeContext native.KubeContext, name string) error {
+	_, err := pb.ExecuteCommandWithJSON(ctx, "clusters", registerArgs(kubeconfig, kubeContext, name))
+	return err
+}
+
+// isRegistered returns true if a registered cluster uses the context or has
+// the name suggested for it
+func isRegistered(registry []interface{}, kubeContext, name string) bool {
+	for _, entry := range registry {
+		cluster, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if cluster["context"] == kubeContext || cluster["name"] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// countReachable returns the number of reachable contexts
+func countReachable(reachability []native.Reachability) int {
+	n := 0
+	for _, r := range reachability {
+		if r.Reachable {
+			n++
+		}
+	}
+	return n
 }
 
 func updateCluster(cmd *cobra.Command, args []string) error {
@@ -251,7 +602,7 @@ func updateCluster(cmd *cobra.Command, args []string) error {
 	}
 	cmdArgs = append(cmdArgs, "--auto-monitor", fmt.Sprintf("%t", autoMonitor))
 
-	return executePythonCommand("clusters", cmdArgs)
+	return executePythonCommand(cmd.Context(), "clusters", cmdArgs)
 }
 
 func deleteCluster(cmd *cobra.Command, args []string) error {
@@ -268,7 +619,7 @@ func deleteCluster(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--cleanup-data")
 	}
 
-	return executePythonCommand("clusters", cmdArgs)
+	return executePythonCommand(cmd.Context(), "clusters", cmdArgs)
 }
 
 func clusterStatus(cmd *cobra.Command, args []string) error {
@@ -285,7 +636,7 @@ func clusterStatus(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--time-range", timeRange)
 	}
 
-	return executePythonCommand("clusters", cmdArgs)
+	return executePythonCommand(cmd.Context(), "clusters", cmdArgs)
 }
 
  
\ No newline at end of file
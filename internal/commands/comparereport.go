@@ -0,0 +1,204 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/reports"
+	"github.com/spf13/cobra"
+)
+
+// compareColumns are the table columns for the clusters of a comparison
+var compareColumns = []output.Column{
+	{Name: "cluster", Field: "cluster"},
+	{Name: "status", Field: "status"},
+	{Name: "workloads", Field: "workloads"},
+	{Name: "monthly", Header: "MONTHLY COST", Field: "monthly_cost"},
+	{Name: "efficiency", Header: "EFFICIENCY %", Field: "efficiency"},
+	{Name: "per-workload", Header: "PER WORKLOAD", Field: "cost_per_workload"},
+	{Name: "waste", Header: "WASTE", Field: "waste"},
+	{Name: "waste-percent", Header: "WASTE %", Field: "waste_percent"},
+	{Name: "recommendations", Field: "recommendations", Wide: true},
+	{Name: "attention", Field: "attention"},
+	{Name: "error", Field: "error", Wide: true},
+}
+
+// reportCompare compares the clusters of --clusters side by side and writes
+// the comparison to a standalone HTML or PDF file
+func reportCompare(cmd *cobra.Command, timeRange, format string) error {
+	// Get flags
+	clusters, _ := cmd.Flags().GetStringSlice("clusters")
+	top, _ := cmd.Flags().GetInt("top")
+	confidence, _ := cmd.Flags().GetFloat64("confidence")
+	sharedNamespaces, _ := cmd.Flags().GetStringSlice("shared-namespaces")
+	outputFile, _ := cmd.Flags().GetString("output-file")
+
+	if format != "html" && format != "pdf" {
+		return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("invalid compare format %q (expected pdf or html)", format))
+	}
+	if confidence < 0 || confidence > 1 {
+		return fmt.Errorf("invalid --confidence %g (expected 0 to 1)", confidence)
+	}
+	if len(clusters) == 0 {
+		contexts, err := native.ReadContexts("")
+		if err != nil {
+			return err
+		}
+		for _, context := range contexts {
+			clusters = append(clusters, context.Name)
+		}
+	}
+	seen := map[string]bool{}
+	unique := clusters[:0]
+	for _, cluster := range clusters {
+		if !seen[cluster] {
+			seen[cluster] = true
+			unique = append(unique, cluster)
+		}
+	}
+	clusters = unique
+	if len(clusters) < 2 {
+		return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", "a comparison needs two clusters or more").
+			WithHint("List the kubeconfig contexts to compare, e.g. --clusters prod-us,prod-eu")
+	}
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return err
+	}
+	storage, err := storagePrice(cmd)
+	if err != nil {
+		return err
+	}
+	clients := make([]*native.Client, 0, len(clusters))
+	var window time.Duration
+	for _, cluster := range clusters {
+		client, clientWindow, err := nativeClientFor(cluster, timeRange)
+		if err != nil {
+			return err
+		}
+		clients = append(clients, client)
+		window = clientWindow
+	}
+
+	result, err := priced(native.CompareClusters(cmd.Context(), clients, native.ReportOptions{
+		Window:            window,
+		MinConfidence:     confidence,
+		Prices:            prices,
+		StoragePrice:      storage,
+		LoadBalancerPrice: native.DefaultLoadBalancerPrice,
+		SharedNamespaces:  sharedNamespaces,
+		Top:               top,
+	}), nil)
+	if err != nil {
+		return err
+	}
+
+	report := compareReport(result)
+	var b bytes.Buffer
+	if format == "pdf" {
+		err = output.WritePDFReport(&b, report)
+	} else {
+		err = output.WriteHTML(&b, report)
+	}
+	if err != nil {
+		return err
+	}
+	if outputFile == "" {
+		outputFile = fmt.Sprintf("upid-compare-%s.%s", time.Now().Format("2006-01-02"), format)
+	}
+	id := keepReport(&reports.Report{Type: "compare", Period: timeRange, Format: format}, outputFile, b.Bytes())
+	if outputFile == "-" {
+		_, err := os.Stdout.Write(b.Bytes())
+		return err
+	}
+	result["report_id"] = id
+	result["file"] = outputFile
+	if err := renderSections(result, []section{{"clusters", compareColumns}}); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputFile, b.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", outputFile, err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote the comparison to %s\n", outputFile)
+	return nil
+}
+
+// compareReport lays out the comparison of clusters: the figures of the
+// fleet, a chart of each metric by cluster and a table of all of them
+func compareReport(result map[string]interface{}) output.HTMLReport {
+	fleet := result["fleet"].(map[string]interface{})
+	number := func(value interface{}) float64 {
+		switch v := value.(type) {
+		case float64:
+			return v
+		case int:
+			return float64(v)
+		}
+		return 0
+	}
+	share := func(value interface{}) string {
+		if value == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", number(value))
+	}
+	cost := func(value interface{}) string {
+		if value == nil {
+			return "-"
+		}
+		return formatCost(number(value))
+	}
+
+	report := output.HTMLReport{
+		Title: fmt.Sprintf("Comparison of %d clusters", fleet["clusters"]),
+		Subtitle: fmt.Sprintf("Generated %s. Costs are monthly, in %s. Efficiency is the share of the cost of requests that is used; waste is the savings of the recommendations of each cluster.",
+			time.Now().Format("January 2, 2006 15:04 MST"), result["currency"]),
+		Figures: []output.HTMLFigure{
+			{Label: "Monthly cost", Value: cost(fleet["monthly_cost"]), Note: fmt.Sprintf("%d workloads", fleet["workloads"])},
+			{Label: "Efficiency", Value: share(fleet["efficiency"]), Note: "of requests used"},
+			{Label: "Cost per workload", Value: cost(fleet["cost_per_workload"]), Note: "average of the fleet"},
+			{Label: "Waste", Value: cost(fleet["waste"]), Note: share(fleet["waste_percent"]) + " of the cost"},
+		},
+		Footer: fmt.Sprintf("Generated by UPID %s", config.GetVersion()),
+	}
+
+	efficiency := output.HTMLSection{Title: "Efficiency", Text: "The share of the cost of requests that is used.", Chart: &output.BarChart{}}
+	perWorkload := output.HTMLSection{Title: "Cost per workload", Chart: &output.BarChart{}}
+	waste := output.HTMLSection{Title: "Waste", Text: "The monthly savings of scaling idle workloads to zero and right-sizing the others.", Chart: &output.BarChart{}}
+	table := output.HTMLSection{
+		Title: "Clusters",
+		Table: &output.HTMLTable{
+			Columns: []string{"Cluster", "Workloads", "Monthly cost", "Efficiency", "Per workload", "Waste", "Waste %", "Attention"},
+			Numeric: []bool{false, true, true, true, true, true, true, false},
+		},
+	}
+	clusters, _ := result["clusters"].([]interface{})
+	for _, value := range clusters {
+		item := value.(map[string]interface{})
+		name := fmt.Sprint(item["cluster"])
+		if item["status"] != "ok" {
+			table.Table.Rows = append(table.Table.Rows, []string{name, "-", "-", "-", "-", "-", "-", fmt.Sprint(item["error"])})
+			continue
+		}
+		efficiency.Chart.Bars = append(efficiency.Chart.Bars, output.Bar{Label: name, Values: []float64{number(item["efficiency"])}, Text: share(item["efficiency"])})
+		perWorkload.Chart.Bars = append(perWorkload.Chart.Bars, output.Bar{Label: name, Values: []float64{number(item["cost_per_workload"])}, Text: cost(item["cost_per_workload"])})
+		waste.Chart.Bars = append(waste.Chart.Bars, output.Bar{Label: name, Values: []float64{number(item["waste"])}, Text: cost(item["waste"])})
+		attention := fmt.Sprint(item["attention"])
+		if attention == "" {
+			attention = "-"
+		}
+		table.Table.Rows = append(table.Table.Rows, []string{
+			name, output.FormatValue(item["workloads"]), cost(item["monthly_cost"]), share(item["efficiency"]),
+			cost(item["cost_per_workload"]), cost(item["waste"]), share(item["waste_percent"]), attention,
+		})
+	}
+
+	report.Sections = []output.HTMLSection{table, efficiency, perWorkload, waste}
+	return report
+}
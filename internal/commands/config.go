@@ -0,0 +1,443 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigCmd creates the config command
+func ConfigCmd() *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "View and change UPID configuration",
+		Long: `View and change the UPID configuration file ($XDG_CONFIG_HOME/upid/config.yaml,
+~/.config/upid/config.yaml by default, or the file given with --config).
+
+Values are validated before they are written. Settings from environment
+variables (UPID_*) and command-line flags still override the file. With
+--profile, set and unset change the settings of that profile.
+
+Values may reference environment variables as ${VAR} or $VAR, which are
+expanded when the file is loaded, so secrets can stay out of the file and
+paths work on every machine. Write $$ for a literal $.
+
+Examples:
+  upid config view                                  # Show the effective configuration
+  upid config get timeout                           # Show a single setting
+  upid config set python_path /usr/bin/python3.11   # Change a setting
+  upid config set retry.max_attempts 5              # Change a nested setting
+  upid config set endpoint '${UPID_API_URL}'        # Read a setting from the environment
+  upid config unset python_path                     # Restore the default
+  upid config edit                                  # Edit the file in $EDITOR
+  upid config validate                              # Check the file for mistakes
+  upid config profile list                          # List configuration profiles
+  upid config sync --from https://example.com/team.yaml  # Use shared team settings
+  upid config encrypt --passphrase                  # Encrypt the file at rest
+  upid config datasource set --url https://prometheus.example.com  # Query historical usage`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return configView(cmd, args)
+		},
+	}
+
+	// Add subcommands
+	configCmd.AddCommand(configGetCmd())
+	configCmd.AddCommand(configSetCmd())
+	configCmd.AddCommand(configUnsetCmd())
+	configCmd.AddCommand(configViewCmd())
+	configCmd.AddCommand(configEditCmd())
+	configCmd.AddCommand(configValidateCmd())
+	configCmd.AddCommand(configProfileCmd())
+	configCmd.AddCommand(configSyncCmd())
+	configCmd.AddCommand(configEncryptCmd())
+	configCmd.AddCommand(configDecryptCmd())
+	configCmd.AddCommand(configDatasourceCmd())
+
+	return configCmd
+}
+
+// configGetCmd creates the config get command
+func configGetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "get [key]",
+		Short: "Show the effective value of a setting",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return configGet(cmd, args)
+		},
+		ValidArgsFunction: completeConfigKeys,
+	}
+}
+
+// configSetCmd creates the config set command
+func configSetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set [key] [value]",
+		Short: "Set a value in the config file",
+		Long:  "Set a value in the config file. Available keys:\n\n" + configKeyHelp(),
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return configSet(cmd, args)
+		},
+		ValidArgsFunction: completeConfigKeys,
+	}
+	return mutating(cmd)
+}
+
+// configUnsetCmd creates the config unset command
+func configUnsetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "unset [key]",
+		Short: "Remove a value from the config file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return configUnset(cmd, args)
+		},
+		ValidArgsFunction: completeConfigKeys,
+	}
+	return mutating(cmd)
+}
+
+// configViewCmd creates the config view command
+func configViewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "view",
+		Short: "Show the configuration",
+		Long:  "Show the effective configuration from all sources as YAML, or only the config file with --file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return configView(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().Bool("file", false, "show the config file as written instead of the effective configuration")
+
+	return cmd
+}
+
+// configEditCmd creates the config edit command
+func configEditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "edit",
+		Short: "Edit the config file in $EDITOR",
+		Long: `Open the config file in $VISUAL or $EDITOR. The edited file is validated
+and only written back when it is valid.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return configEdit(cmd, args)
+		},
+	}
+	return mutating(cmd)
+}
+
+// configValidateCmd creates the config validate command
+func configValidateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the configuration for mistakes",
+		Long: `Check the config file and UPID_* environment variables for unknown keys
+and invalid values, such as malformed durations or endpoints and a
+python_path that does not exist. Each problem is reported with its line and
+a suggested fix.
+
+Other commands refuse to run while the configuration has errors; unknown
+keys are only reported as warnings.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return configValidate(cmd, args)
+		},
+	}
+	return withColumns(cmd, validateColumns)
+}
+
+// validateColumns are the table columns for configuration problems
+var validateColumns = []output.Column{
+	{Name: "location", Field: "location"},
+	{Name: "severity", Field: "severity"},
+	{Name: "problem", Field: "message"},
+	{Name: "fix", Field: "fix"},
+}
+
+// RepairsConfig returns true if cmd must run even when the configuration is
+// invalid, because it is used to inspect or fix it
+func RepairsConfig(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Name() == "config" && c.Parent() == cmd.Root() {
+			return true
+		}
+	}
+	return false
+}
+
+// configKeyHelp lists the keys that can be set
+func configKeyHelp() string {
+	var b strings.Builder
+	for _, key := range config.Keys() {
+		fmt.Fprintf(&b, "  %-22s %-9s %s\n", key.Name, key.Kind, key.Description)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// completeConfigKeys completes configuration key names
+func completeConfigKeys(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for _, key := range config.Keys() {
+		names = append(names, key.Name)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// lookupConfigKey returns a settable key or a usage error naming the keys
+func lookupConfigKey(name string) (config.Key, error) {
+	key, ok := config.LookupKey(name)
+	if !ok {
+		return key, clierr.New(clierr.CategoryUsage, "UNKNOWN_CONFIG_KEY", fmt.Sprintf("unknown configuration key %q", name)).
+			WithHint("Run 'upid config set --help' to list the available keys")
+	}
+	return key, nil
+}
+
+// profileKey returns where a key is written: in the profile given with
+// --profile, or at the top level
+func profileKey(cmd *cobra.Command, key string) string {
+	if !cmd.Flags().Changed("profile") || key == "current_profile" {
+		return key
+	}
+	profile, _ := cmd.Flags().GetString("profile")
+	return "profiles." + profile + "." + key
+}
+
+// Implementation functions
+func configGet(cmd *cobra.Command, args []string) error {
+	if _, err := lookupConfigKey(args[0]); err != nil {
+		return err
+	}
+	value := viper.Get(args[0])
+	if cmd.Flags().Changed("output") {
+		return renderResult(map[string]interface{}{"key": args[0], "value": value})
+	}
+	fmt.Println(output.FormatValue(value))
+	return nil
+}
+
+func configSet(cmd *cobra.Command, args []string) error {
+	key, err := lookupConfigKey(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := key.Parse(args[1])
+	if err != nil {
+		return clierr.Wrap(err, clierr.CategoryUsage, "INVALID_CONFIG_VALUE", err.Error())
+	}
+
+	path := config.FilePath()
+	target := profileKey(cmd, key.Name)
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("set %s to %v in %s", target, value, path))
+	}
+	file, err := config.OpenFile(path)
+	if err != nil {
+		return err
+	}
+	if err := file.Set(target, value); err != nil {
+		return err
+	}
+	if err := file.Save(); err != nil {
+		return err
+	}
+	if err := config.ReadConfigFile(); err != nil {
+		return err
+	}
+
+	return renderResult(map[string]interface{}{
+		"message": fmt.Sprintf("Set %s to %v in %s", target, value, path),
+	})
+}
+
+func configUnset(cmd *cobra.Command, args []string) error {
+	key, err := lookupConfigKey(args[0])
+	if err != nil {
+		return err
+	}
+
+	path := config.FilePath()
+	target := profileKey(cmd, key.Name)
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("remove %s from %s", target, path))
+	}
+	file, err := config.OpenFile(path)
+	if err != nil {
+		return err
+	}
+	if !file.Unset(target) {
+		return renderResult(map[string]interface{}{
+			"message": fmt.Sprintf("%s is not set in %s", target, path),
+		})
+	}
+	if err := file.Save(); err != nil {
+		return err
+	}
+	if err := config.ReadConfigFile(); err != nil {
+		return err
+	}
+
+	return renderResult(map[string]interface{}{
+		"message": fmt.Sprintf("Removed %s from %s", target, path),
+	})
+}
+
+func configView(cmd *cobra.Command, args []string) error {
+	fileOnly, _ := cmd.Flags().GetBool("file")
+
+	if fileOnly {
+		data, err := config.ReadConfigData(config.FilePath())
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read config file: %w", err)
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	settings := viper.AllSettings()
+	// config_file only reflects the --config flag
+	delete(settings, "config_file")
+	if cmd.Flags().Changed("output") {
+		return renderResult(settings)
+	}
+	fmt.Printf("# %s\n", config.FilePath())
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(settings); err != nil {
+		return fmt.Errorf("failed to encode configuration: %v", err)
+	}
+	return encoder.Close()
+}
+
+func configValidate(cmd *cobra.Command, args []string) error {
+	problems := config.Validate()
+	items := make([]interface{}, 0, len(problems))
+	errorCount := 0
+	for _, p := range problems {
+		location := p.Source
+		if p.Line > 0 {
+			location = fmt.Sprintf("%s:%d", p.Source, p.Line)
+		}
+		severity := "warning"
+		if !p.Warning {
+			severity = "error"
+			errorCount++
+		}
+		items = append(items, map[string]interface{}{
+			"location": location,
+			"line":     p.Line,
+			"key":      p.Key,
+			"severity": severity,
+			"message":  p.Message,
+			"fix":      p.Fix,
+		})
+	}
+
+	message := fmt.Sprintf("%s is valid", config.FilePath())
+	if len(problems) > 0 {
+		message = fmt.Sprintf("%d errors, %d warnings in the configuration", errorCount, len(problems)-errorCount)
+	}
+	if err := renderResult(map[string]interface{}{
+		"message":  message,
+		"valid":    errorCount == 0,
+		"problems": items,
+	}); err != nil {
+		return err
+	}
+	if errorCount > 0 {
+		return clierr.New(clierr.CategoryUsage, "INVALID_CONFIG", fmt.Sprintf("configuration has %d errors", errorCount)).
+			WithHint("Fix them with 'upid config set' or 'upid config edit'")
+	}
+	return nil
+}
+
+func configEdit(cmd *cobra.Command, args []string) error {
+	path := config.FilePath()
+	editor := editorCommand()
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("edit %s with %s", path, editor))
+	}
+
+	original, err := config.ReadConfigData(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Edit a copy so an invalid result never replaces the file
+	tmp, err := os.CreateTemp("", "upid-config-*.yaml")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	tmp.Write(original)
+	tmp.Close()
+
+	if err := runEditor(editor, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	edited, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("failed to read edited file: %v", err)
+	}
+	if string(edited) == string(original) {
+		os.Remove(tmp.Name())
+		return renderResult(map[string]interface{}{"message": "Edit cancelled, no changes made"})
+	}
+	if err := config.ValidationError(config.ValidateData(path, edited)); err != nil {
+		failure := clierr.From(err)
+		failure.Message = strings.Replace(failure.Message, "invalid configuration", "edited configuration is invalid", 1)
+		return failure.WithHint(fmt.Sprintf("Your changes were kept in %s; fix them and copy the file to %s", tmp.Name(), path))
+	}
+	os.Remove(tmp.Name())
+
+	if err := config.WriteConfigData(path, edited); err != nil {
+		return err
+	}
+	if err := config.ReadConfigFile(); err != nil {
+		return err
+	}
+	return renderResult(map[string]interface{}{"message": fmt.Sprintf("Saved %s", path)})
+}
+
+// editorCommand returns the user's editor, $VISUAL or $EDITOR
+func editorCommand() string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := os.Getenv(name); editor != "" {
+			return editor
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return "notepad"
+	}
+	return "vi"
+}
+
+// runEditor opens path in editor, which may include arguments
+func runEditor(editor, path string) error {
+	fields := strings.Fields(editor)
+	command := exec.Command(fields[0], append(fields[1:], path)...)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("editor %s failed: %v", editor, err)
+	}
+	return nil
+}
@@ -0,0 +1,613 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/budget"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/notify"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/spf13/cobra"
+)
+
+// budgetColumns are the table columns for budgets
+var budgetColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "scope", Field: "scope"},
+	{Name: "context", Field: "context", Wide: true},
+	{Name: "monthly", Field: "monthly"},
+	{Name: "spent", Field: "spent"},
+	{Name: "spent-percent", Header: "SPENT %", Field: "spent_percent"},
+	{Name: "projected", Field: "projected"},
+	{Name: "remaining", Field: "remaining", Wide: true},
+	{Name: "alert-at", Header: "ALERT AT", Field: "alert_at", Wide: true},
+	{Name: "currency", Field: "currency", Wide: true},
+	{Name: "status", Field: "status"},
+	{Name: "evaluated", Field: "evaluated", Wide: true},
+}
+
+// budgetPointColumns are the table columns for the burn-down of a budget
+var budgetPointColumns = []output.Column{
+	{Name: "day", Field: "day"},
+	{Name: "spent", Field: "spent"},
+	{Name: "remaining", Field: "remaining"},
+	{Name: "spent-percent", Header: "SPENT %", Field: "spent_percent"},
+	{Name: "planned", Field: "planned", Wide: true},
+}
+
+// budgetAlertColumns are the table columns for budget alerts
+var budgetAlertColumns = []output.Column{
+	{Name: "budget", Field: "budget"},
+	{Name: "severity", Field: "severity"},
+	{Name: "threshold", Field: "threshold"},
+	{Name: "message", Field: "message"},
+}
+
+// Statuses of budgets
+const (
+	budgetUnevaluated = "not evaluated"
+	budgetOK          = "ok"
+	// budgetAtRisk has passed an alert threshold or is projected over budget
+	budgetAtRisk = "at risk"
+	budgetOver   = "over budget"
+)
+
+// CostCmd creates the cost command
+func CostCmd() *cobra.Command {
+	costCmd := &cobra.Command{
+		Use:   "cost",
+		Short: "Manage cost budgets",
+		Long:  "Manage monthly cost budgets of clusters, namespaces and teams, and the alerts they fire",
+	}
+
+	// Add subcommands
+	costCmd.AddCommand(costBudgetCmd())
+
+	return costCmd
+}
+
+// costBudgetCmd creates the budget command
+func costBudgetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "budget",
+		Short: "Set monthly budgets and alert when they are spent",
+		Long: `Set monthly budgets of a cluster or part of it, and alert when they are spent.
+
+A budget covers a scope of the cluster of a kubeconfig context:
+
+  cluster                    all its nodes
+  namespace:<name>           the pods of a namespace
+  label:<key>=<value>        the pods with a label, e.g. label:team=payments
+  annotation:<key>=<value>   the pods with an annotation
+
+Scopes are priced as by 'upid analyze allocation', with their share of the
+idle capacity of the cluster, at the prices of the pricing model in config.
+Each evaluation accrues the spending of the calendar month (UTC) at the
+current cost of the scope; the first one of a month assumes that the scope
+cost as much since the month started. Budgets are evaluated by 'upid cost
+budget evaluate', e.g. from a schedule, and while 'upid monitor watch' runs.
+
+An alert fires once a month for each threshold of --alert-at passed, and
+once when the month is projected to spend more than the budget. Alerts are
+posted to the notification channels that take them, see 'upid monitor
+channels'. Budgets are kept in budgets.json in the state directory, with the
+daily burn-down of the month shown by 'upid cost budget show' and 'upid
+report generate budgets'.
+
+Examples:
+  upid cost budget create --scope namespace:payments --monthly 5000 --alert-at 80%,100%
+  upid cost budget create platform --scope label:team=platform --monthly 12000
+  upid cost budget list
+  upid cost budget evaluate
+  upid cost budget show namespace:payments
+  upid cost budget delete namespace:payments`,
+	}
+
+	// Add subcommands
+	cmd.AddCommand(withColumns(costBudgetCreateCmd(), budgetColumns))
+	cmd.AddCommand(withColumns(costBudgetListCmd(), budgetColumns))
+	cmd.AddCommand(costBudgetShowCmd())
+	cmd.AddCommand(costBudgetEvaluateCmd())
+	cmd.AddCommand(costBudgetDeleteCmd())
+
+	return cmd
+}
+
+// costBudgetCreateCmd creates the budget create command
+func costBudgetCreateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create [name]",
+		Short: "Create or update a budget",
+		Long: `Create a monthly budget of a scope, named after the scope unless a name is
+given. Creating a budget with the name of an existing one updates it and
+keeps the spending of the month.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return costBudgetCreate(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("scope", budget.ScopeCluster, "what the budget covers: cluster, namespace:<name>, label:<key>=<value> or annotation:<key>=<value>")
+	cmd.Flags().Float64("monthly", 0, "amount that may be spent in a calendar month, in the currency of the pricing model")
+	cmd.Flags().StringSlice("alert-at", []string{"80%", "100%"}, "comma-separated percentages of the budget whose spending fires an alert")
+	cmd.Flags().StringP("context", "x", "", "kubernetes context of the cluster (default current context)")
+	_ = cmd.MarkFlagRequired("monthly")
+
+	return mutating(cmd)
+}
+
+// costBudgetListCmd creates the budget list command
+func costBudgetListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the budgets and their spending this month",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return costBudgetList(cmd, args)
+		},
+	}
+}
+
+// costBudgetShowCmd creates the budget show command
+func costBudgetShowCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show <name>",
+		Short: "Show a budget and the burn-down of its month",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return costBudgetShow(cmd, args)
+		},
+	}
+}
+
+// costBudgetEvaluateCmd creates the budget evaluate command
+func costBudgetEvaluateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "evaluate [name]",
+		Short: "Accrue the spending of the budgets and fire their alerts",
+		Long: `Price the scope of each budget, or of the one named, accrue its spending
+of the month and list the alerts that fire. The command fails with exit
+code 1 if a budget is spent, e.g. to notify from a schedule.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return costBudgetEvaluate(cmd, args)
+		},
+	}
+
+	return mutating(cmd)
+}
+
+// costBudgetDeleteCmd creates the budget delete command
+func costBudgetDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete <name>",
+		Short: "Delete a budget and its spending",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return costBudgetDelete(cmd, args)
+		},
+	}
+
+	return mutating(cmd)
+}
+
+// Implementation functions
+func costBudgetCreate(cmd *cobra.Command, args []string) error {
+	// Get flags
+	scopeFlag, _ := cmd.Flags().GetString("scope")
+	monthly, _ := cmd.Flags().GetFloat64("monthly")
+	alertAt, _ := cmd.Flags().GetStringSlice("alert-at")
+	kubeContext, _ := cmd.Flags().GetString("context")
+
+	scope, err := budget.ParseScope(scopeFlag)
+	if err != nil {
+		return err
+	}
+	if monthly <= 0 {
+		return fmt.Errorf("invalid --monthly %g (expected more than 0)", monthly)
+	}
+	thresholds, err := budget.ParseThresholds(alertAt)
+	if err != nil {
+		return err
+	}
+	if kubeContext == "" {
+		kubeconfig, err := native.LoadKubeconfig()
+		if err != nil {
+			return err
+		}
+		kubeContext = kubeconfig.CurrentContext
+	}
+	if kubeContext == "" {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "no current kubeconfig context").
+			WithHint("Give the context of the cluster with --context")
+	}
+	name := scope.String()
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	store, err := budget.Load(budgetFile())
+	if err != nil {
+		return err
+	}
+	b := &budget.Budget{
+		Name:      name,
+		Scope:     scope.String(),
+		Context:   kubeContext,
+		Monthly:   monthly,
+		AlertAt:   thresholds,
+		Currency:  config.GetPricingConfig().Currency,
+		CreatedAt: time.Now().UTC(),
+	}
+	action := "Created"
+	if existing := store.Get(name); existing != nil {
+		action = "Updated"
+		b.CreatedAt = existing.CreatedAt
+		// The spending of the month carries over unless the scope
+		// changed; new thresholds already passed fire at the next
+		// evaluation
+		b.Period = existing.Period
+		if b.Period != nil && (existing.Scope != b.Scope || existing.Context != b.Context) {
+			b.Period = nil
+		}
+	}
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("save budget %s in %s: %s of %s, %s per month", name, store.Path(), b.Scope, kubeContext, formatCost(monthly)))
+	}
+	store.Put(b)
+	if err := store.Save(); err != nil {
+		return err
+	}
+
+	result := budgetItem(b, time.Now())
+	result["message"] = fmt.Sprintf("%s budget %s of %s per month", action, name, formatCost(monthly))
+	result["hint"] = "Budgets are evaluated by 'upid cost budget evaluate' and while 'upid monitor watch' runs"
+	return renderResult(result)
+}
+
+func costBudgetList(cmd *cobra.Command, args []string) error {
+	store, err := budget.Load(budgetFile())
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	items := make([]interface{}, 0, len(store.Budgets))
+	for _, b := range store.Budgets {
+		items = append(items, budgetItem(b, now))
+	}
+	return renderResult(map[string]interface{}{
+		"message": fmt.Sprintf("%d budgets", len(items)),
+		"file":    store.Path(),
+		"budgets": items,
+	})
+}
+
+func costBudgetShow(cmd *cobra.Command, args []string) error {
+	store, err := budget.Load(budgetFile())
+	if err != nil {
+		return err
+	}
+	b := store.Get(args[0])
+	if b == nil {
+		return fmt.Errorf("no budget named %q, see 'upid cost budget list'", args[0])
+	}
+	result := budgetItem(b, time.Now())
+	result["burn_down"] = budgetBurnDown(b, time.Now())
+	return renderSections(result, []section{{"burn_down", budgetPointColumns}})
+}
+
+func costBudgetEvaluate(cmd *cobra.Command, args []string) error {
+	store, err := budget.Load(budgetFile())
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 && store.Get(args[0]) == nil {
+		return fmt.Errorf("no budget named %q, see 'upid cost budget list'", args[0])
+	}
+	if IsDryRun() {
+		var actions []string
+		for _, b := range store.Budgets {
+			if len(args) == 0 || b.Name == args[0] {
+				actions = append(actions, fmt.Sprintf("accrue the spending of budget %s in %s", b.Name, store.Path()))
+			}
+		}
+		return printDryRun(actions...)
+	}
+
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return err
+	}
+	alerts, evaluated, warnings, err := evaluateBudgets(cmd.Context(), prices, func(b *budget.Budget) bool {
+		return len(args) == 0 || b.Name == args[0]
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	items := make([]interface{}, 0, len(evaluated))
+	spent := 0
+	for _, b := range evaluated {
+		items = append(items, budgetItem(b, now))
+		if b.Spent(now) >= b.Monthly {
+			spent++
+		}
+	}
+	alertItems := make([]interface{}, 0, len(alerts))
+	for _, alert := range alerts {
+		alertItems = append(alertItems, budgetAlertItem(alert))
+		notifyEvent(cmd.Context(), budgetEvent(alert))
+	}
+	result := map[string]interface{}{
+		"message": fmt.Sprintf("Evaluated %d budgets, %d alerts fired, %d spent", len(items), len(alertItems), spent),
+		"budgets": items,
+		"alerts":  alertItems,
+	}
+	if err := renderSections(result, []section{{"budgets", budgetColumns}, {"alerts", budgetAlertColumns}}); err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	if spent > 0 {
+		return clierr.New(clierr.CategoryGeneral, "BUDGET_SPENT", fmt.Sprintf("%d budgets are spent", spent))
+	}
+	return nil
+}
+
+func costBudgetDelete(cmd *cobra.Command, args []string) error {
+	store, err := budget.Load(budgetFile())
+	if err != nil {
+		return err
+	}
+	if store.Get(args[0]) == nil {
+		return fmt.Errorf("no budget named %q, see 'upid cost budget list'", args[0])
+	}
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("delete budget %s from %s", args[0], store.Path()))
+	}
+	store.Remove(args[0])
+	if err := store.Save(); err != nil {
+		return err
+	}
+	return renderResult(map[string]interface{}{"message": fmt.Sprintf("Deleted budget %s", args[0])})
+}
+
+// evaluateBudgets accrues the spending of the budgets selected and saves
+// it, returning the alerts that fired and the budgets evaluated. Budgets of
+// clusters that cannot be priced are skipped with a warning.
+func evaluateBudgets(ctx context.Context, prices native.ComputePrices, selected func(*budget.Budget) bool) ([]budget.Alert, []*budget.Budget, []string, error) {
+	store, err := budget.Load(budgetFile())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	byContext := map[string][]*budget.Budget{}
+	var contexts []string
+	for _, b := range store.Budgets {
+		if !selected(b) {
+			continue
+		}
+		if byContext[b.Context] == nil {
+			contexts = append(contexts, b.Context)
+		}
+		byContext[b.Context] = append(byContext[b.Context], b)
+	}
+	sort.Strings(contexts)
+
+	rates := map[string]float64{}
+	var warnings []string
+	for _, kubeContext := range contexts {
+		if err := scopeRates(ctx, kubeContext, byContext[kubeContext], prices, rates); err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, nil, ctx.Err()
+			}
+			slog.Warn("failed to price budgets", "context", kubeContext, "error", err)
+			warnings = append(warnings, fmt.Sprintf("budgets of context %s were not evaluated: %v", kubeContext, err))
+		}
+	}
+
+	// Read the budgets again, as they may have changed while the clusters
+	// were priced
+	store, err = budget.Load(store.Path())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	now := time.Now()
+	var alerts []budget.Alert
+	var evaluated []*budget.Budget
+	for _, b := range store.Budgets {
+		rate, ok := rates[b.Context+"\x00"+b.Scope]
+		if !ok || !selected(b) {
+			continue
+		}
+		alerts = append(alerts, b.Evaluate(rate, now)...)
+		evaluated = append(evaluated, b)
+	}
+	if len(evaluated) > 0 {
+		if err := store.Save(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return alerts, evaluated, warnings, nil
+}
+
+// scopeRates adds the monthly cost of the scopes of the budgets of a
+// cluster to rates, by context and scope, pricing the cluster once for each
+// dimension of the scopes
+func scopeRates(ctx context.Context, kubeContext string, budgets []*budget.Budget, prices native.ComputePrices, rates map[string]float64) error {
+	client, err := native.NewClient(kubeContext)
+	if err != nil {
+		return err
+	}
+	allocations := map[string]map[string]interface{}{}
+	for _, b := range budgets {
+		scope, err := budget.ParseScope(b.Scope)
+		if err != nil {
+			return err
+		}
+		dimension := native.Dimension{Kind: scope.Kind, Key: scope.Key}
+		if scope.Kind == budget.ScopeCluster {
+			dimension = native.Dimension{Kind: budget.ScopeNamespace}
+		}
+		allocation := allocations[dimension.String()]
+		if allocation == nil {
+			allocation, err = client.AnalyzeAllocation(ctx, native.AllocationOptions{
+				By:     dimension,
+				Idle:   native.IdleProportional,
+				Prices: prices,
+			})
+			if err != nil {
+				return err
+			}
+			allocations[dimension.String()] = allocation
+		}
+
+		rate := 0.0
+		if scope.Kind == budget.ScopeCluster {
+			rate, _ = allocation["monthly_cost"].(float64)
+		} else {
+			groups, _ := allocation["groups"].([]interface{})
+			for _, group := range groups {
+				if g := group.(map[string]interface{}); g["name"] == scope.Value {
+					rate, _ = g["monthly_cost"].(float64)
+				}
+			}
+		}
+		rates[b.Context+"\x00"+b.Scope] = rate
+	}
+	return nil
+}
+
+// budgetItem describes a budget and its spending this month
+func budgetItem(b *budget.Budget, now time.Time) map[string]interface{} {
+	alertAt := make([]string, len(b.AlertAt))
+	for i, threshold := range b.AlertAt {
+		alertAt[i] = fmt.Sprintf("%g%%", threshold)
+	}
+	spent := b.Spent(now)
+	item := map[string]interface{}{
+		"name":          b.Name,
+		"scope":         b.Scope,
+		"context":       b.Context,
+		"monthly":       b.Monthly,
+		"currency":      b.Currency,
+		"alert_at":      strings.Join(alertAt, ","),
+		"spent":         roundCost(spent),
+		"spent_percent": math.Round(spent/b.Monthly*1000) / 10,
+		"remaining":     roundCost(b.Monthly - spent),
+		"projected":     nil,
+		"evaluated":     nil,
+		"status":        budgetUnevaluated,
+	}
+	if spent == 0 && (b.Period == nil || b.Period.Month != now.UTC().Format("2006-01")) {
+		return item
+	}
+	projected := b.Projected()
+	item["projected"] = roundCost(projected)
+	item["evaluated"] = b.Period.Updated.Local().Format(time.RFC3339)
+	switch {
+	case spent >= b.Monthly:
+		item["status"] = budgetOver
+	case projected > b.Monthly || len(b.Period.Alerted) > 0:
+		item["status"] = budgetAtRisk
+	default:
+		item["status"] = budgetOK
+	}
+	return item
+}
+
+// budgetBurnDown lists the spending of each day evaluated this month, with
+// the spending planned by then if the budget were spent evenly
+func budgetBurnDown(b *budget.Budget, now time.Time) []interface{} {
+	if b.Period == nil || b.Period.Month != now.UTC().Format("2006-01") {
+		return []interface{}{}
+	}
+	start, _ := time.Parse("2006-01", b.Period.Month)
+	days := start.AddDate(0, 1, 0).Sub(start).Hours() / 24
+	points := make([]interface{}, 0, len(b.Period.BurnDown))
+	for _, point := range b.Period.BurnDown {
+		day, _ := time.Parse("2006-01-02", point.Day)
+		elapsed := day.Sub(start).Hours()/24 + 1
+		points = append(points, map[string]interface{}{
+			"day":           point.Day,
+			"spent":         roundCost(point.Spent),
+			"remaining":     roundCost(b.Monthly - point.Spent),
+			"spent_percent": math.Round(point.Spent/b.Monthly*1000) / 10,
+			"planned":       roundCost(b.Monthly * elapsed / days),
+		})
+	}
+	return points
+}
+
+// budgetAlertItem describes an alert of a budget
+func budgetAlertItem(alert budget.Alert) map[string]interface{} {
+	threshold := "forecast"
+	if !alert.Forecast {
+		threshold = fmt.Sprintf("%g%%", alert.Threshold)
+	}
+	return map[string]interface{}{
+		"time":      alert.Time.Local().Format(time.RFC3339),
+		"budget":    alert.Budget,
+		"scope":     alert.Scope,
+		"severity":  budgetAlertSeverity(alert),
+		"threshold": threshold,
+		"spent":     roundCost(alert.Spent),
+		"projected": roundCost(alert.Projected),
+		"monthly":   alert.Monthly,
+		"currency":  alert.Currency,
+		"message":   alert.Message(),
+	}
+}
+
+// budgetAlertSeverity is critical for spent budgets, else a warning
+func budgetAlertSeverity(alert budget.Alert) string {
+	if !alert.Forecast && alert.Threshold >= 100 {
+		return kube.SeverityCritical
+	}
+	return kube.SeverityWarning
+}
+
+// budgetEvent reports an alert of a budget to notification channels
+func budgetEvent(alert budget.Alert) notify.Event {
+	event := notify.Event{
+		Kind:     notify.EventAlert,
+		Severity: budgetAlertSeverity(alert),
+		Title:    "Budget " + alert.Budget,
+		Message:  alert.Message(),
+		Key:      "upid/budget/" + alert.Budget,
+		Fields: []notify.Field{
+			{Name: "Scope", Value: alert.Scope},
+			{Name: "Spent", Value: formatCost(alert.Spent)},
+			{Name: "Projected", Value: formatCost(alert.Projected)},
+			{Name: "Monthly budget", Value: formatCost(alert.Monthly)},
+		},
+		Time: alert.Time,
+	}
+	if scope, err := budget.ParseScope(alert.Scope); err == nil && scope.Kind == budget.ScopeNamespace {
+		event.Fields = append(event.Fields, notify.Field{Name: "Namespace", Value: scope.Value})
+	}
+	return event
+}
+
+// roundCost rounds an amount to cents
+func roundCost(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// budgetFile returns the local file of budgets
+func budgetFile() string {
+	return filepath.Join(config.GetStateDir(), "budgets.json")
+}
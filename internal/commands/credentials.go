@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/bridge"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+// Names of stored credentials, scoped to a profile by credentialName
+const (
+	credentialAuthToken       = "auth.token"
+	credentialAuthSession     = "auth.session"
+	credentialClientSecret    = "auth.client_secret"
+	credentialEnterpriseToken = "enterprise.token"
+	credentialDatasourceToken = "datasource.token"
+	credentialDatasourcePass  = "datasource.password"
+	credentialDashboardToken  = "dashboard.token"
+	credentialDashboardPass   = "dashboard.password"
+)
+
+// credentialEnv maps stored credentials to the variables that pass them to
+// the runtime
+var credentialEnv = map[string]string{
+	credentialAuthToken:       "UPID_TOKEN",
+	credentialClientSecret:    "UPID_CLIENT_SECRET",
+	credentialEnterpriseToken: "UPID_ENTERPRISE_TOKEN",
+	credentialDatasourceToken: "UPID_DATASOURCE_TOKEN",
+	credentialDatasourcePass:  "UPID_DATASOURCE_PASSWORD",
+}
+
+// credentialStore returns the store holding tokens and secrets
+func credentialStore() *secrets.Store {
+	return secrets.New(config.GetCredentialStore(), config.GetCredentialsFile())
+}
+
+// credentialName scopes a credential to the active profile
+func credentialName(name string) string {
+	return profileCredentialName(config.GetProfile(), name)
+}
+
+// profileCredentialName scopes a credential to a profile, "" for the
+// settings outside of profiles
+func profileCredentialName(profile, name string) string {
+	if profile == "" {
+		profile = defaultSession
+	}
+	return profile + "/" + name
+}
+
+// setCredentialEnv passes the stored credentials of the active profile to
+// the runtime, and removes those of a previously used profile. An API key in
+// $UPID_API_KEY replaces the stored login.
+func setCredentialEnv(pb *bridge.PythonBridge) {
+	pb.SetEnv(apiKeyEnv, os.Getenv(apiKeyEnv))
+
+	store := credentialStore()
+	for name, variable := range credentialEnv {
+		if apiKeyInUse() && name == credentialAuthToken {
+			pb.SetEnv(variable, "")
+			continue
+		}
+		value, err := store.Get(credentialName(name))
+		if err != nil && !errors.Is(err, secrets.ErrNotFound) {
+			slog.Warn("failed to read stored credential", "name", name, "error", err)
+		}
+		pb.SetEnv(variable, value)
+	}
+}
+
+// storeCredential saves a credential of the active profile and returns where
+// it was stored
+func storeCredential(name, value string) (string, error) {
+	backend, err := credentialStore().Set(credentialName(name), value)
+	if err != nil {
+		return "", fmt.Errorf("failed to store credential: %w", err)
+	}
+	slog.Info("stored credential", "name", credentialName(name), "backend", backend)
+	return backend, nil
+}
+
+// secretFlag returns a secret given with --<flag>, or read from standard
+// input with --<flag>-stdin, which keeps it out of shell history
+func secretFlag(cmd *cobra.Command, flag string) (string, error) {
+	fromStdin, _ := cmd.Flags().GetBool(flag + "-stdin")
+	if !fromStdin {
+		value, _ := cmd.Flags().GetString(flag)
+		if value != "" {
+			slog.Debug("secret given on the command line", "flag", flag)
+		}
+		return value, nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read --%s from standard input: %v", flag, err)
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// systemDaemonCmd creates the system daemon command
+func systemDaemonCmd() *cobra.Command {
+	daemonCmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Manage the runtime daemon",
+		Long: `Manage the long-running Python runtime daemon.
+
+While the daemon is running, commands are sent to it over a local socket
+instead of starting a new Python interpreter each time, which removes the
+interpreter startup cost from every command. Set "daemon: true" in the
+configuration (or UPID_DAEMON=true) to start it automatically on first use.
+The daemon exits after 10 minutes without requests.
+
+Examples:
+  upid system daemon start    # Start the daemon in the background
+  upid system daemon status   # Show whether the daemon is running
+  upid system daemon stop     # Stop the daemon`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return systemDaemonStatus(cmd, args)
+		},
+	}
+
+	// Add subcommands
+	daemonCmd.AddCommand(&cobra.Command{
+		Use:   "start",
+		Short: "Start the runtime daemon",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return systemDaemonStart(cmd, args)
+		},
+	})
+	daemonCmd.AddCommand(&cobra.Command{
+		Use:   "stop",
+		Short: "Stop the runtime daemon",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return systemDaemonStop(cmd, args)
+		},
+	})
+	daemonCmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show runtime daemon status",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return systemDaemonStatus(cmd, args)
+		},
+	})
+
+	return daemonCmd
+}
+
+// Implementation functions
+func systemDaemonStart(cmd *cobra.Command, args []string) error {
+	status, err := getBridge().StartDaemon(cmd.Context())
+	if err != nil {
+		return err
+	}
+	return renderResult(map[string]interface{}{
+		"message": fmt.Sprintf("Runtime daemon running (pid %d)", status.PID),
+		"pid":     status.PID,
+		"address": status.Address,
+	})
+}
+
+func systemDaemonStop(cmd *cobra.Command, args []string) error {
+	pb := getBridge()
+	status, err := pb.DaemonStatus(cmd.Context())
+	if err != nil {
+		return err
+	}
+	if !status.Running {
+		return renderResult(map[string]interface{}{"message": "Runtime daemon is not running"})
+	}
+	if err := pb.StopDaemon(cmd.Context()); err != nil {
+		return err
+	}
+	return renderResult(map[string]interface{}{
+		"message": fmt.Sprintf("Runtime daemon stopped (pid %d)", status.PID),
+		"pid":     status.PID,
+	})
+}
+
+func systemDaemonStatus(cmd *cobra.Command, args []string) error {
+	status, err := getBridge().DaemonStatus(cmd.Context())
+	if err != nil {
+		return err
+	}
+	result := map[string]interface{}{
+		"message": "Runtime daemon is not running",
+		"running": false,
+		"address": status.Address,
+	}
+	if status.Running {
+		result["message"] = fmt.Sprintf("Runtime daemon is running (pid %d)", status.PID)
+		result["running"] = true
+		result["pid"] = status.PID
+	}
+	return renderResult(result)
+}
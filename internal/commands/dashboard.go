@@ -1,8 +1,26 @@
 package commands
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/dashboard"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/oidc"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
 	"github.com/spf13/cobra"
 )
 
@@ -15,15 +33,23 @@ func DashboardCmd() *cobra.Command {
 
 Examples:
   upid dashboard start                    # Start interactive dashboard
+  upid dashboard tui                      # Show the dashboard in the terminal
+  upid dashboard auth set --mode oidc     # Sign users in to the dashboard
   upid dashboard metrics                  # View dashboard metrics
   upid dashboard export                   # Export dashboard data`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return dashboardStart(cmd, args)
 		},
 	}
 
+	// Add flags
+	addDashboardFlags(dashboardCmd)
+
 	// Add subcommands
 	dashboardCmd.AddCommand(dashboardStartCmd())
+	dashboardCmd.AddCommand(dashboardTUICmd())
+	dashboardCmd.AddCommand(dashboardAuthCmd())
 	dashboardCmd.AddCommand(dashboardMetricsCmd())
 	dashboardCmd.AddCommand(dashboardExportCmd())
 	dashboardCmd.AddCommand(dashboardConfigCmd())
@@ -36,21 +62,88 @@ func dashboardStartCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "start",
 		Short: "Start interactive dashboard",
-		Long:  "Start the interactive UPID dashboard in your browser",
+		Long: `Start the UPID dashboard and open it in your browser.
+
+The dashboard is a web page served by UPID itself at --host and --port, until
+interrupted: the cost of each cluster by resource and namespace, its trend,
+and the recommendations to scale idle workloads to zero and right-size those
+that use less than half their requests. It reads the Kubernetes API directly
+and does not need the Python runtime.
+
+It shows the clusters of all kubeconfig contexts, or those of --cluster. With
+several, the fleet view totals their cost and waste, compares them as 'upid
+report generate compare' does, and ranks the recommendations of all of them
+by savings; each cluster is then shown on its own page.
+
+The clusters are collected every --refresh, and the page reloads them as often.
+Usage is the average over --time-range at the configured datasource, or the
+current usage from metrics-server. The cost of each collection is kept in
+the dashboard directory of the state directory, by context, one point per
+hour for 90 days, and drawn as the cost trend.
+
+Idle workloads can be scaled to zero from the recommendations, within the
+guardrails and unless changes to their namespace need approval, like with
+'upid optimize zero-pod --apply'; --read-only hides that. Shared snapshots
+are frozen, read-only copies of the page at a tokenized URL that anyone can
+open until it expires, also without signing in; they end when the dashboard
+stops.
+
+The REST API serves the state last collected to other tools as JSON:
+/api/v1/clusters, /api/v1/recommendations and /api/v1/costs, documented in
+docs/DASHBOARD_API.md. It is authenticated like the page, with the bearer
+token, basic authentication or an ID token of the identity provider.
+
+Served on other addresses than localhost, the dashboard authenticates its
+users as 'upid dashboard auth' configures, or as --auth picks for this run.
+
+` + serverTLSHelp + `
+
+Examples:
+  upid dashboard start                          # Serve on localhost:8080
+  upid dashboard start --cluster prod-us -t 7d  # Weekly usage of prod-us
+  upid dashboard start --cluster prod-us,prod-eu
+  upid dashboard start --port 9000 --open-browser=false
+  upid dashboard start --read-only              # Only show, never change the cluster
+  upid dashboard start --host 0.0.0.0 --auth token --tls-self-signed
+  upid dashboard start --host 0.0.0.0 --port 443 --auth oidc --tls-acme upid.example.com
+  curl -H "Authorization: Bearer $ID_TOKEN" https://upid.example.com/api/v1/recommendations`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return dashboardStart(cmd, args)
 		},
 	}
 
 	// Add flags
-	cmd.Flags().StringP("port", "p", "8080", "port to run dashboard on")
-	cmd.Flags().StringP("host", "h", "localhost", "host to bind dashboard to")
-	cmd.Flags().BoolP("open-browser", "o", true, "automatically open browser")
-	cmd.Flags().StringP("cluster", "c", "", "default cluster to show")
+	addDashboardFlags(cmd)
 
 	return cmd
 }
 
+// addDashboardFlags adds the flags of the dashboard server to cmd
+func addDashboardFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("port", "p", "8080", "port to run dashboard on")
+	cmd.Flags().String("host", "localhost", "host to bind dashboard to")
+	cmd.Flags().Bool("open-browser", true, "automatically open browser")
+	cmd.Flags().StringSlice("cluster", nil, "kubeconfig contexts of the clusters to show (default all)")
+	cmd.Flags().String("auth", "", "authentication of users (none, basic, token, oidc; default dashboard.auth)")
+	cmd.Flags().Bool("allow-unauthenticated", false, "serve on other addresses than localhost without authentication")
+	cmd.Flags().Bool("read-only", false, "do not allow scaling workloads from the dashboard")
+	cmd.Flags().StringP("time-range", "t", "", "time range of usage at the datasource (default current usage)")
+	cmd.Flags().String("refresh", "1m", "how often the clusters are collected")
+	addTLSFlags(cmd)
+	addCollectFlags(cmd)
+}
+
+// addCollectFlags adds the flags of how the dashboard collects the clusters
+// to cmd
+func addCollectFlags(cmd *cobra.Command) {
+	cmd.Flags().Float64("confidence", 0.90, "confidence from which pods are idle")
+	cmd.Flags().Int("top", native.DefaultReportTop, "number of workloads listed as recommendations")
+	cmd.Flags().Float64("cpu-price", 0, "price per requested core-hour (default pricing.cpu)")
+	cmd.Flags().Float64("memory-price", 0, "price per requested GiB-hour (default pricing.memory)")
+	cmd.Flags().Float64("storage-price", 0, "price per GiB-month of persistent volumes (default pricing.storage)")
+}
+
 // dashboardMetricsCmd creates the dashboard metrics command
 func dashboardMetricsCmd() *cobra.Command {
 	cmd := &cobra.Command{
@@ -63,7 +156,7 @@ func dashboardMetricsCmd() *cobra.Command {
 	}
 
 	// Add flags
-	cmd.Flags().StringP("cluster", "c", "", "cluster to get metrics for")
+	cmd.Flags().String("cluster", "", "cluster to get metrics for")
 	cmd.Flags().StringP("time-range", "t", "24h", "time range for metrics")
 	cmd.Flags().StringP("format", "f", "table", "output format (table, json, yaml)")
 
@@ -75,40 +168,36 @@ func dashboardExportCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "export",
 		Short: "Export dashboard data",
-		Long:  "Export dashboard data and reports",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			return dashboardExport(cmd, args)
-		},
-	}
+		Long: `Export dashboard data and reports.
 
-	// Add flags
-	cmd.Flags().StringP("cluster", "c", "", "cluster to export data for")
-	cmd.Flags().StringP("format", "f", "json", "export format (json, csv, pdf)")
-	cmd.Flags().StringP("output", "o", "", "output file path")
-	cmd.Flags().StringP("time-range", "t", "30d", "time range for export")
+With --format html-bundle, the dashboard of the clusters is collected once
+and written to the directory --output-file (default upid-dashboard-<time>)
+as static files: index.html, its script and stylesheet, and the state as
+data.json and data.js. Opening index.html shows the dashboard as it was,
+read-only, without UPID, a server or a network, for air-gapped review or to
+attach to a ticket. Like 'dashboard start', it shows all kubeconfig
+contexts, or those of --cluster; --time-range applies when a datasource is
+configured.
 
-	return cmd
-}
+` + uploadHelp + `
 
-// dashboardConfigCmd creates the dashboard config command
-func dashboardConfigCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "config",
-		Short: "Configure dashboard settings",
-		Long:  "Configure dashboard settings and preferences",
+Examples:
+  upid dashboard export --format csv --upload azblob://acmereports/finops/upid
+  upid dashboard export --format html-bundle --cluster prod-us --output-file INC-1234-dashboard`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return dashboardConfig(cmd, args)
+			return dashboardExport(cmd, args)
 		},
 	}
 
 	// Add flags
-	cmd.Flags().StringP("theme", "", "dark", "dashboard theme (light, dark, auto)")
-	cmd.Flags().BoolP("auto-refresh", "r", true, "enable auto-refresh")
-	cmd.Flags().StringP("refresh-interval", "i", "30s", "refresh interval")
-	cmd.Flags().BoolP("show-costs", "c", true, "show cost information")
-	cmd.Flags().BoolP("show-alerts", "a", true, "show alerts")
+	cmd.Flags().StringSlice("cluster", nil, "clusters to export data for (default the current one, all with html-bundle)")
+	cmd.Flags().StringP("format", "f", "json", "export format (json, csv, pdf, html-bundle)")
+	cmd.Flags().String("output-file", "", "output file path, the directory of an html-bundle")
+	cmd.Flags().StringP("time-range", "t", "30d", "time range for export")
+	addCollectFlags(cmd)
+	addUploadFlags(cmd)
 
-	return cmd
+	return mutating(cmd)
 }
 
 // Implementation functions
@@ -117,24 +206,265 @@ func dashboardStart(cmd *cobra.Command, args []string) error {
 	port, _ := cmd.Flags().GetString("port")
 	host, _ := cmd.Flags().GetString("host")
 	openBrowser, _ := cmd.Flags().GetBool("open-browser")
-	cluster, _ := cmd.Flags().GetString("cluster")
+	readOnly, _ := cmd.Flags().GetBool("read-only")
 
-	// Build arguments
-	cmdArgs := []string{"dashboard", "start"}
-	if port != "" {
-		cmdArgs = append(cmdArgs, "--port", port)
+	refresh, err := dashboardRefresh(cmd)
+	if err != nil {
+		return err
 	}
-	if host != "" {
-		cmdArgs = append(cmdArgs, "--host", host)
+	clusters, err := dashboardClusters(cmd)
+	if err != nil {
+		return err
 	}
-	if !openBrowser {
-		cmdArgs = append(cmdArgs, "--no-open-browser")
+	collector, err := dashboardCollector(cmd, clusters)
+	if err != nil {
+		return err
 	}
-	if cluster != "" {
-		cmdArgs = append(cmdArgs, "--cluster", cluster)
+	auth, err := dashboardAuth(cmd, host)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := serverTLS(cmd, net.JoinHostPort(host, port))
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", net.JoinHostPort(host, port), err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	ctx := cmd.Context()
+	server := dashboard.NewServer(collector, refresh)
+	if !readOnly {
+		server.Apply = dashboardApply(cmd, collector)
+	}
+	go server.Run(ctx)
+
+	httpServer := &http.Server{Handler: auth.Handler(server.Handler()), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), exporterShutdown)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdown)
+	}()
+
+	url := fmt.Sprintf("%s://%s/", serverScheme(tlsConfig), dashboardAddress(host, listener.Addr()))
+	if auth != nil && auth.Mode == dashboard.AuthToken {
+		url += "?token=" + auth.Token
+	}
+	if quiet, _ := activeFlagBool("quiet"); !quiet {
+		shown := "context " + collector.Clients[0].Context()
+		if len(collector.Clients) > 1 {
+			shown = fmt.Sprintf("%d clusters", len(collector.Clients))
+		}
+		fmt.Fprintf(os.Stderr, "Serving the dashboard of %s on %s, press Ctrl+C to stop\n", shown, url)
+	}
+	if openBrowser {
+		if err := oidc.OpenBrowser(url); err != nil {
+			slog.Warn("failed to open the browser", "url", url, "error", err)
+		}
+	}
+	if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve the dashboard: %v", err)
+	}
+	return nil
+}
+
+// dashboardClusters returns the kubeconfig contexts of --cluster, all
+// contexts of the kubeconfig without it, or the current one ("") if it has
+// none
+func dashboardClusters(cmd *cobra.Command) ([]string, error) {
+	// Get flags
+	clusters, _ := cmd.Flags().GetStringSlice("cluster")
+
+	if len(clusters) == 0 {
+		contexts, err := native.ReadContexts("")
+		if err != nil {
+			return nil, err
+		}
+		for _, context := range contexts {
+			clusters = append(clusters, context.Name)
+		}
+	}
+	if len(clusters) == 0 {
+		clusters = []string{""}
+	}
+	return clusters, nil
+}
+
+// dashboardRefresh returns how often the dashboard collects the clusters,
+// by --refresh
+func dashboardRefresh(cmd *cobra.Command) (time.Duration, error) {
+	// Get flags
+	refreshFlag, _ := cmd.Flags().GetString("refresh")
+
+	if configured := config.GetDashboardConfig().Refresh; configured > 0 && !cmd.Flags().Changed("refresh") {
+		return configured, nil
+	}
+	refresh, err := timeutil.ParseDuration(refreshFlag)
+	if err != nil || refresh < 10*time.Second {
+		return 0, fmt.Errorf("invalid --refresh %q (expected a duration of 10s or more)", refreshFlag)
+	}
+	return refresh, nil
+}
+
+// dashboardTimeRange returns the time range of usage the dashboard shows:
+// --time-range, or dashboard.time_range without it
+func dashboardTimeRange(cmd *cobra.Command) string {
+	// Get flags
+	timeRange, _ := cmd.Flags().GetString("time-range")
+
+	if configured := config.GetDashboardConfig().TimeRange; configured != "" && !cmd.Flags().Changed("time-range") {
+		return configured
+	}
+	return timeRange
+}
+
+// dashboardCollector returns the collector of clusters, by kubeconfig
+// context ("" for the current one), as the flags of cmd and the layout of
+// the config file give. Pinned clusters come first.
+func dashboardCollector(cmd *cobra.Command, clusters []string) (*dashboard.Collector, error) {
+	// Get flags
+	confidence, _ := cmd.Flags().GetFloat64("confidence")
+	top, _ := cmd.Flags().GetInt("top")
+
+	timeRange := dashboardTimeRange(cmd)
+	settings := config.GetDashboardConfig()
+	pinned := settings.PinnedList()
+	rank := func(cluster string) int {
+		for i, name := range pinned {
+			if name == cluster {
+				return i
+			}
+		}
+		return len(pinned)
+	}
+	clusters = append([]string(nil), clusters...)
+	sort.SliceStable(clusters, func(i, j int) bool { return rank(clusters[i]) < rank(clusters[j]) })
+
+	if confidence < 0 || confidence > 1 {
+		return nil, fmt.Errorf("invalid --confidence %g (expected 0 to 1)", confidence)
+	}
+	if top <= 0 {
+		return nil, fmt.Errorf("invalid --top %d (expected 1 or more)", top)
+	}
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := storagePrice(cmd)
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]*native.Client, 0, len(clusters))
+	seen := map[string]bool{}
+	var window time.Duration
+	for _, cluster := range clusters {
+		if seen[cluster] {
+			continue
+		}
+		seen[cluster] = true
+		client, clientWindow, err := nativeClientFor(cluster, timeRange)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+		window = clientWindow
+	}
+	return &dashboard.Collector{
+		Clients: clients,
+		Options: native.ReportOptions{
+			Window:            window,
+			MinConfidence:     confidence,
+			Prices:            prices,
+			StoragePrice:      storage,
+			LoadBalancerPrice: native.DefaultLoadBalancerPrice,
+			Top:               top,
+		},
+		Currency:  config.GetPricingConfig().Currency,
+		Layout:    dashboard.Layout{Widgets: settings.WidgetList(), Pinned: pinned},
+		TrendFile: dashboardTrendFile,
+	}, nil
+}
+
+// dashboardApply returns how the dashboard scales an idle workload of one of
+// the clusters of collector to zero: as 'optimize zero-pod --apply' would,
+// just that workload, refusing changes that need approval
+func dashboardApply(cmd *cobra.Command, collector *dashboard.Collector) func(ctx context.Context, action dashboard.Action) (map[string]interface{}, error) {
+	// Get flags
+	confidence, _ := cmd.Flags().GetFloat64("confidence")
+
+	timeRange := dashboardTimeRange(cmd)
+	shown := map[string]bool{}
+	for _, client := range collector.Clients {
+		shown[client.Context()] = true
 	}
+	return func(ctx context.Context, action dashboard.Action) (map[string]interface{}, error) {
+		if !shown[action.Cluster] {
+			return nil, fmt.Errorf("the dashboard does not show cluster %q", action.Cluster)
+		}
+		if config.GetApprovalsConfig().Required(action.Cluster, action.Namespace) {
+			return nil, fmt.Errorf("changes to namespace %s of %s need approval, request it with 'upid optimize zero-pod %s --apply'",
+				action.Namespace, action.Cluster, action.Namespace)
+		}
+		client, window, err := nativeClientFor(action.Cluster, timeRange)
+		if err != nil {
+			return nil, err
+		}
+		rails, err := guardrails("")
+		if err != nil {
+			return nil, err
+		}
+		client.SetGuardrails(rails)
+		changes, err := client.ZeroPodChanges(ctx, action.Namespace, confidence, window)
+		if err != nil {
+			return nil, err
+		}
+		var only []kube.ApprovalChange
+		for _, change := range changes {
+			if change.Kind == action.Kind && change.Name == action.Workload {
+				only = append(only, change)
+			}
+		}
+		if len(only) == 0 {
+			return nil, fmt.Errorf("%s/%s is no longer idle or the guardrails block scaling it, see 'upid optimize zero-pod %s'",
+				action.Kind, action.Workload, action.Namespace)
+		}
+		records, err := native.LoadScaleRecords(zeroPodStateFile())
+		if err != nil {
+			return nil, err
+		}
+		result, err := client.ApplyZeroPod(ctx, action.Namespace, confidence, window, records, false, only)
+		if err != nil {
+			return nil, err
+		}
+		if errs, _ := result["errors"].([]interface{}); len(errs) > 0 {
+			return nil, fmt.Errorf("failed to scale %s/%s to zero: %v", action.Kind, action.Workload, errs[0])
+		}
+		result["message"] = fmt.Sprintf("Scaled %s/%s in namespace %s to zero, roll it back with 'upid optimize zero-pod %s --rollback --workload %s/%s'",
+			action.Kind, action.Workload, action.Namespace, action.Namespace, action.Kind, action.Workload)
+		notifyEvent(ctx, optimizationEvent("UPID scaled idle workloads to zero in "+action.Namespace, result))
+		return result, nil
+	}
+}
+
+// dashboardAddress returns the address to open the dashboard at: the
+// address it listens on, by the host it was given unless that binds all
+// interfaces
+func dashboardAddress(host string, addr net.Addr) string {
+	_, port, _ := net.SplitHostPort(addr.String())
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
 
-	return executePythonCommand("dashboard", cmdArgs)
+// dashboardTrendFile returns the local file of the cost trend of a context
+func dashboardTrendFile(kubeContext string) string {
+	return filepath.Join(config.GetStateDir(), "dashboard", fileSafe(kubeContext)+".json")
 }
 
 func dashboardMetrics(cmd *cobra.Command, args []string) error {
@@ -155,16 +485,30 @@ func dashboardMetrics(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--format", format)
 	}
 
-	return executePythonCommand("dashboard", cmdArgs)
+	return executePythonCommand(cmd.Context(), "dashboard", cmdArgs)
 }
 
 func dashboardExport(cmd *cobra.Command, args []string) error {
 	// Get flags
-	cluster, _ := cmd.Flags().GetString("cluster")
+	clusters, _ := cmd.Flags().GetStringSlice("cluster")
 	format, _ := cmd.Flags().GetString("format")
-	output, _ := cmd.Flags().GetString("output")
+	outputFile, _ := cmd.Flags().GetString("output-file")
 	timeRange, _ := cmd.Flags().GetString("time-range")
 
+	if format == "html-bundle" {
+		return dashboardExportBundle(cmd, outputFile)
+	}
+	cluster := strings.Join(clusters, ",")
+	location, opts, err := uploadTarget(cmd)
+	if err != nil {
+		return err
+	}
+	outputFile, cleanup, err := exportFile(outputFile, "dashboard."+format, location)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
 	// Build arguments
 	cmdArgs := []string{"dashboard", "export"}
 	if cluster != "" {
@@ -173,37 +517,65 @@ func dashboardExport(cmd *cobra.Command, args []string) error {
 	if format != "" {
 		cmdArgs = append(cmdArgs, "--format", format)
 	}
-	if output != "" {
-		cmdArgs = append(cmdArgs, "--output", output)
+	if outputFile != "" {
+		cmdArgs = append(cmdArgs, "--output", outputFile)
 	}
 	if timeRange != "" {
 		cmdArgs = append(cmdArgs, "--time-range", timeRange)
 	}
 
-	return executePythonCommand("dashboard", cmdArgs)
+	if err := executePythonCommand(cmd.Context(), "dashboard", cmdArgs); err != nil || location == nil {
+		return err
+	}
+	return uploadFile(cmd, location, opts, outputFile, "dashboards", cluster)
 }
 
-func dashboardConfig(cmd *cobra.Command, args []string) error {
-	// Get flags
-	theme, _ := cmd.Flags().GetString("theme")
-	autoRefresh, _ := cmd.Flags().GetBool("auto-refresh")
-	refreshInterval, _ := cmd.Flags().GetString("refresh-interval")
-	showCosts, _ := cmd.Flags().GetBool("show-costs")
-	showAlerts, _ := cmd.Flags().GetBool("show-alerts")
-
-	// Build arguments
-	cmdArgs := []string{"dashboard", "config"}
-	if theme != "" {
-		cmdArgs = append(cmdArgs, "--theme", theme)
+// dashboardExportBundle collects the clusters once and writes the dashboard
+// showing them to the directory outputFile
+func dashboardExportBundle(cmd *cobra.Command, outputFile string) error {
+	if cmd.Flags().Changed("upload") {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "--upload does not support html-bundle, which is a directory").
+			WithHint("Export json or csv to upload, or archive the bundle and attach it yourself")
+	}
+	if outputFile == "" {
+		outputFile = "upid-dashboard-" + time.Now().Format("20060102-150405")
+	}
+	if info, err := os.Stat(outputFile); err == nil && !info.IsDir() {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", fmt.Sprintf("%s exists and is not a directory", outputFile)).
+			WithHint("An html-bundle is a directory, give another --output-file")
+	}
+	clusters, err := dashboardClusters(cmd)
+	if err != nil {
+		return err
+	}
+	collector, err := dashboardCollector(cmd, clusters)
+	if err != nil {
+		return err
 	}
-	cmdArgs = append(cmdArgs, "--auto-refresh", fmt.Sprintf("%t", autoRefresh))
-	if refreshInterval != "" {
-		cmdArgs = append(cmdArgs, "--refresh-interval", refreshInterval)
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("write the dashboard of %d clusters to %s", len(collector.Clients), outputFile))
 	}
-	cmdArgs = append(cmdArgs, "--show-costs", fmt.Sprintf("%t", showCosts))
-	cmdArgs = append(cmdArgs, "--show-alerts", fmt.Sprintf("%t", showAlerts))
 
-	return executePythonCommand("dashboard", cmdArgs)
+	state := collector.Collect(cmd.Context())
+	if err := cmd.Context().Err(); err != nil {
+		return err
+	}
+	if err := dashboard.WriteBundle(outputFile, state); err != nil {
+		return err
+	}
+	result := map[string]interface{}{
+		"message":   fmt.Sprintf("Exported the dashboard of %d clusters to %s, open %s", len(state.Clusters), outputFile, filepath.Join(outputFile, "index.html")),
+		"directory": outputFile,
+		"files":     dashboard.BundleFiles,
+	}
+	var failed []string
+	for _, cluster := range state.Clusters {
+		if cluster.Error != "" {
+			failed = append(failed, cluster.Context)
+		}
+	}
+	if len(failed) > 0 {
+		result["warning"] = fmt.Sprintf("the bundle shows the errors of clusters that failed to collect: %s", strings.Join(failed, ", "))
+	}
+	return renderResult(result)
 }
-
- 
\ No newline at end of file
@@ -0,0 +1,327 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/dashboard"
+	"github.com/kubilitics/upid-cli/internal/oidc"
+	"github.com/spf13/cobra"
+)
+
+// dashboardAuthFlags maps the flags of 'dashboard auth set' to the keys
+// they write
+var dashboardAuthFlags = map[string]string{
+	"mode":     "dashboard.auth",
+	"username": "dashboard.username",
+	"users":    "dashboard.users",
+}
+
+// dashboardAuthCmd creates the dashboard auth command
+func dashboardAuthCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Configure who can open the dashboard",
+		Long: `Configure how the dashboard server authenticates its users, which it needs
+to be served on other addresses than localhost.
+
+  none   anyone reaching the dashboard sees it (the default)
+  basic  the browser asks for --username and the password
+  token  requests carry a bearer token; the URL 'upid dashboard start'
+         prints carries it once and a session cookie replaces it. Without
+         a stored token, each start generates one.
+  oidc   users sign in at the identity provider of 'upid auth login --sso'
+         (auth.issuer and auth.client_id), optionally only --users. The
+         client must allow the redirect URL <dashboard URL>/auth/callback.
+         Clients of the REST API send an ID token of the provider as their
+         bearer token.
+
+Passwords and tokens are kept in the credential store, the other settings
+in the config file under dashboard.*. 'upid dashboard start --auth' picks
+another mode for one run.
+
+Examples:
+  upid dashboard auth set --mode basic --username admin --password-stdin
+  upid dashboard auth set --mode token --token-stdin
+  upid dashboard auth set --mode oidc --users '*@example.com'
+  upid dashboard auth show
+  upid dashboard auth remove`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dashboardAuthShow(cmd, args)
+		},
+	}
+
+	cmd.AddCommand(dashboardAuthSetCmd())
+	cmd.AddCommand(dashboardAuthShowCmd())
+	cmd.AddCommand(dashboardAuthRemoveCmd())
+
+	return cmd
+}
+
+// dashboardAuthSetCmd creates the dashboard auth set command
+func dashboardAuthSetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set",
+		Short: "Set how the dashboard authenticates users",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dashboardAuthSet(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("mode", "", "authentication (none, basic, token, oidc)")
+	cmd.Flags().String("username", "", "user for basic authentication")
+	cmd.Flags().String("password", "", "password for basic authentication (prefer --password-stdin)")
+	cmd.Flags().Bool("password-stdin", false, "read the password from standard input")
+	cmd.Flags().String("token", "", "bearer token (prefer --token-stdin)")
+	cmd.Flags().Bool("token-stdin", false, "read the bearer token from standard input")
+	cmd.Flags().String("users", "", "comma-separated emails or subjects (globs) allowed with oidc")
+	cmd.MarkFlagsMutuallyExclusive("password", "password-stdin")
+	cmd.MarkFlagsMutuallyExclusive("token", "token-stdin")
+	cmd.MarkFlagsMutuallyExclusive("password-stdin", "token-stdin")
+
+	return mutating(cmd)
+}
+
+// dashboardAuthShowCmd creates the dashboard auth show command
+func dashboardAuthShowCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show",
+		Short: "Show how the dashboard authenticates users",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dashboardAuthShow(cmd, args)
+		},
+	}
+}
+
+// dashboardAuthRemoveCmd creates the dashboard auth remove command
+func dashboardAuthRemoveCmd() *cobra.Command {
+	return mutating(&cobra.Command{
+		Use:   "remove",
+		Short: "Remove the dashboard authentication settings and credentials",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dashboardAuthRemove(cmd, args)
+		},
+	})
+}
+
+// Implementation functions
+func dashboardAuthSet(cmd *cobra.Command, args []string) error {
+	updates := map[string]interface{}{}
+	for flag, name := range dashboardAuthFlags {
+		if !cmd.Flags().Changed(flag) {
+			continue
+		}
+		key, _ := config.LookupKey(name)
+		value, err := key.Parse(cmd.Flags().Lookup(flag).Value.String())
+		if err != nil {
+			return clierr.Wrap(err, clierr.CategoryUsage, "INVALID_CONFIG_VALUE", err.Error())
+		}
+		updates[profileKey(cmd, name)] = value
+	}
+	token, err := secretFlag(cmd, "token")
+	if err != nil {
+		return err
+	}
+	password, err := secretFlag(cmd, "password")
+	if err != nil {
+		return err
+	}
+	if len(updates) == 0 && token == "" && password == "" {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "nothing to set").
+			WithHint("Give at least --mode, see 'upid dashboard auth set --help'")
+	}
+
+	path := config.FilePath()
+	if IsDryRun() {
+		var actions []string
+		for key, value := range updates {
+			actions = append(actions, fmt.Sprintf("set %s to %v in %s", key, value, path))
+		}
+		if token != "" {
+			actions = append(actions, "store the dashboard token")
+		}
+		if password != "" {
+			actions = append(actions, "store the dashboard password")
+		}
+		return printDryRun(actions...)
+	}
+
+	if len(updates) > 0 {
+		file, err := config.OpenFile(path)
+		if err != nil {
+			return err
+		}
+		for key, value := range updates {
+			if err := file.Set(key, value); err != nil {
+				return err
+			}
+		}
+		if err := file.Save(); err != nil {
+			return err
+		}
+		if err := config.ReadConfigFile(); err != nil {
+			return err
+		}
+		if err := config.Reload(); err != nil {
+			return err
+		}
+	}
+	if token != "" {
+		if _, err := storeCredential(credentialDashboardToken, token); err != nil {
+			return err
+		}
+	}
+	if password != "" {
+		if _, err := storeCredential(credentialDashboardPass, password); err != nil {
+			return err
+		}
+	}
+
+	result := dashboardAuthSettings()
+	result["message"] = fmt.Sprintf("Dashboard authentication set to %s", config.GetDashboardConfig().Auth)
+	if missing := dashboardAuthMissing(); missing != "" {
+		result["warning"] = missing
+	}
+	return renderResult(result)
+}
+
+func dashboardAuthShow(cmd *cobra.Command, args []string) error {
+	result := dashboardAuthSettings()
+	result["message"] = "Dashboard authentication " + config.GetDashboardConfig().Auth
+	if missing := dashboardAuthMissing(); missing != "" {
+		result["warning"] = missing
+	}
+	return renderResult(result)
+}
+
+func dashboardAuthRemove(cmd *cobra.Command, args []string) error {
+	path := config.FilePath()
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("unset %s in %s", profileKey(cmd, "dashboard"), path), "delete the stored dashboard credentials")
+	}
+	file, err := config.OpenFile(path)
+	if err != nil {
+		return err
+	}
+	if file.Unset(profileKey(cmd, "dashboard")) {
+		if err := file.Save(); err != nil {
+			return err
+		}
+	}
+	deleteCredential(credentialDashboardToken)
+	deleteCredential(credentialDashboardPass)
+	return renderResult(map[string]interface{}{
+		"message": "Removed the dashboard authentication, the dashboard is served on localhost only",
+	})
+}
+
+// dashboardAuthSettings describes the dashboard authentication without
+// secrets
+func dashboardAuthSettings() map[string]interface{} {
+	settings := config.GetDashboardConfig()
+	result := map[string]interface{}{"auth": settings.Auth}
+	switch settings.Auth {
+	case dashboard.AuthBasic:
+		result["username"] = settings.Username
+		result["password"] = hasCredential(credentialDashboardPass)
+	case dashboard.AuthToken:
+		result["token"] = hasCredential(credentialDashboardToken)
+	case dashboard.AuthOIDC:
+		result["issuer"] = config.GetAuthConfig().Issuer
+		result["users"] = "all"
+		if settings.Users != "" {
+			result["users"] = settings.Users
+		}
+	}
+	return result
+}
+
+// dashboardAuthMissing returns what the configured authentication lacks,
+// "" if nothing
+func dashboardAuthMissing() string {
+	settings := config.GetDashboardConfig()
+	switch settings.Auth {
+	case dashboard.AuthBasic:
+		if settings.Username == "" || !hasCredential(credentialDashboardPass) {
+			return "basic authentication needs --username and --password"
+		}
+	case dashboard.AuthOIDC:
+		if auth := config.GetAuthConfig(); auth.Issuer == "" || auth.ClientID == "" {
+			return "oidc needs auth.issuer and auth.client_id, set them with 'upid config set'"
+		}
+	}
+	return ""
+}
+
+// dashboardAuth returns the authentication of the dashboard served on host,
+// in the configured mode or that of --auth, nil if none
+func dashboardAuth(cmd *cobra.Command, host string) (*dashboard.Auth, error) {
+	// Get flags
+	mode := config.GetDashboardConfig().Auth
+	if cmd.Flags().Changed("auth") {
+		mode, _ = cmd.Flags().GetString("auth")
+	}
+	unauthenticated, _ := cmd.Flags().GetBool("allow-unauthenticated")
+
+	settings := config.GetDashboardConfig()
+	store := credentialStore()
+	switch mode {
+	case "", dashboard.AuthNone:
+		if !loopback(host) && !unauthenticated {
+			return nil, clierr.New(clierr.CategoryUsage, "DASHBOARD_UNAUTHENTICATED", fmt.Sprintf("refusing to serve the dashboard on %s without authentication", host)).
+				WithHint("Set it up with 'upid dashboard auth set', or pass --allow-unauthenticated behind an authenticating proxy")
+		}
+		return nil, nil
+	case dashboard.AuthBasic:
+		password, _ := store.Get(credentialName(credentialDashboardPass))
+		if settings.Username == "" || password == "" {
+			return nil, clierr.New(clierr.CategoryUsage, "DASHBOARD_AUTH_NOT_CONFIGURED", "basic authentication of the dashboard is not configured").
+				WithHint("Set it with 'upid dashboard auth set --mode basic --username <user> --password-stdin'")
+		}
+		return &dashboard.Auth{Mode: mode, Username: settings.Username, Password: password}, nil
+	case dashboard.AuthToken:
+		token, _ := store.Get(credentialName(credentialDashboardToken))
+		if token == "" {
+			token = dashboard.NewToken()
+		}
+		return &dashboard.Auth{Mode: mode, Token: token}, nil
+	case dashboard.AuthOIDC:
+		provider, err := ssoProvider(cmd)
+		if err != nil {
+			return nil, err
+		}
+		return &dashboard.Auth{Mode: mode, Provider: provider, Allow: settings.Allows, VerifyToken: dashboardVerifyToken}, nil
+	}
+	return nil, fmt.Errorf("invalid --auth %q (expected none, basic, token or oidc)", mode)
+}
+
+// dashboardVerifyToken returns the user of an ID token that a client of the
+// dashboard API sends, once its signature is checked against the keys of the
+// configured issuer
+func dashboardVerifyToken(ctx context.Context, token string) (string, error) {
+	auth := config.GetAuthConfig()
+	verification, err := keyCache().Verify(ctx, token, oidc.Trust{Issuer: auth.Issuer, ClientID: auth.ClientID}, false)
+	if err != nil {
+		return "", err
+	}
+	if !verification.Verified {
+		return "", fmt.Errorf("the token could not be verified: %s", verification.Reason)
+	}
+	return (&oidc.Session{IDToken: token}).Subject(), nil
+}
+
+// loopback reports whether host only accepts local connections
+func loopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,189 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// dashboardConfigFlags maps the flags of 'dashboard config' to the keys
+// they write
+var dashboardConfigFlags = map[string]string{
+	"widgets":    "dashboard.widgets",
+	"pin":        "dashboard.pinned",
+	"time-range": "dashboard.time_range",
+	"refresh":    "dashboard.refresh",
+}
+
+// dashboardConfigCmd creates the dashboard config command
+func dashboardConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Configure dashboard settings",
+		Long: `Configure the layout of the dashboard, kept in the config file under
+dashboard.*. Without flags, it shows the layout.
+
+  --widgets     the widgets of the pages, in order: summary, trend,
+                resources, namespaces, recommendations and clusters (the
+                default). Cluster pages show summary, trend, resources,
+                namespaces and recommendations, the fleet view summary,
+                clusters and recommendations; resources and namespaces side
+                by side when they follow each other.
+  --pin         clusters listed first in the cluster picker and the fleet
+                view, by kubeconfig context
+  --time-range  time range of usage at the datasource the dashboard shows
+                without --time-range (default current usage)
+  --refresh     how often the dashboard collects the clusters without
+                --refresh (default 1m, 30s for 'dashboard tui')
+
+'upid dashboard start', 'tui' and 'export --format html-bundle' honor it.
+An empty value restores the default, --reset all of them.
+
+Examples:
+  upid dashboard config
+  upid dashboard config --widgets summary,recommendations,trend
+  upid dashboard config --pin prod-us,prod-eu --time-range 7d
+  upid dashboard config --refresh 5m
+  upid dashboard config --reset`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dashboardConfig(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("widgets", "", "comma-separated widgets of the dashboard, in order")
+	cmd.Flags().String("pin", "", "comma-separated kubeconfig contexts listed first")
+	cmd.Flags().StringP("time-range", "t", "", "default time range of usage at the datasource")
+	cmd.Flags().StringP("refresh", "i", "", "default interval of collecting the clusters")
+	cmd.Flags().Bool("reset", false, "restore the default layout")
+	for flag := range dashboardConfigFlags {
+		cmd.MarkFlagsMutuallyExclusive(flag, "reset")
+	}
+
+	return mutating(cmd)
+}
+
+// Implementation functions
+func dashboardConfig(cmd *cobra.Command, args []string) error {
+	// Get flags
+	reset, _ := cmd.Flags().GetBool("reset")
+
+	if reset {
+		return dashboardConfigReset(cmd)
+	}
+	updates := map[string]interface{}{}
+	for flag, name := range dashboardConfigFlags {
+		if !cmd.Flags().Changed(flag) {
+			continue
+		}
+		key, _ := config.LookupKey(name)
+		raw := cmd.Flags().Lookup(flag).Value.String()
+		if raw == "" && key.Kind == config.KindDuration {
+			raw = "0s"
+		}
+		value, err := key.Parse(raw)
+		if err != nil {
+			return clierr.Wrap(err, clierr.CategoryUsage, "INVALID_CONFIG_VALUE", err.Error()).
+				WithHint("See 'upid dashboard config --help'")
+		}
+		updates[profileKey(cmd, name)] = value
+	}
+	if len(updates) == 0 {
+		result := dashboardLayout()
+		result["message"] = "Dashboard layout"
+		return renderResult(result)
+	}
+
+	path := config.FilePath()
+	if IsDryRun() {
+		var actions []string
+		for key, value := range updates {
+			actions = append(actions, fmt.Sprintf("set %s to %v in %s", key, value, path))
+		}
+		sort.Strings(actions)
+		return printDryRun(actions...)
+	}
+	file, err := config.OpenFile(path)
+	if err != nil {
+		return err
+	}
+	for key, value := range updates {
+		if err := file.Set(key, value); err != nil {
+			return err
+		}
+	}
+	if err := file.Save(); err != nil {
+		return err
+	}
+	if err := config.ReadConfigFile(); err != nil {
+		return err
+	}
+	if err := config.Reload(); err != nil {
+		return err
+	}
+
+	result := dashboardLayout()
+	result["message"] = "Saved the dashboard layout to " + path
+	return renderResult(result)
+}
+
+// dashboardConfigReset restores the default layout
+func dashboardConfigReset(cmd *cobra.Command) error {
+	path := config.FilePath()
+	names := make([]string, 0, len(dashboardConfigFlags))
+	for _, name := range dashboardConfigFlags {
+		names = append(names, profileKey(cmd, name))
+	}
+	sort.Strings(names)
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("unset %s in %s", strings.Join(names, ", "), path))
+	}
+	file, err := config.OpenFile(path)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, name := range names {
+		changed = file.Unset(name) || changed
+	}
+	if changed {
+		if err := file.Save(); err != nil {
+			return err
+		}
+		if err := config.ReadConfigFile(); err != nil {
+			return err
+		}
+		if err := config.Reload(); err != nil {
+			return err
+		}
+	}
+	result := dashboardLayout()
+	result["message"] = "Restored the default dashboard layout"
+	return renderResult(result)
+}
+
+// dashboardLayout describes the layout of the dashboard
+func dashboardLayout() map[string]interface{} {
+	settings := config.GetDashboardConfig()
+	result := map[string]interface{}{
+		"widgets":    settings.WidgetList(),
+		"pinned":     []string{},
+		"time_range": "current usage",
+		"refresh":    "1m",
+	}
+	if pinned := settings.PinnedList(); len(pinned) > 0 {
+		result["pinned"] = pinned
+	}
+	if settings.TimeRange != "" {
+		result["time_range"] = settings.TimeRange
+	}
+	if settings.Refresh > 0 {
+		result["refresh"] = settings.Refresh.String()
+	}
+	return result
+}
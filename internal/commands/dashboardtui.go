@@ -0,0 +1,369 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/dashboard"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/notify"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// hoursPerMonth converts monthly costs to hourly rates, as the pricing
+// model does
+const hoursPerMonth = 730
+
+// sparkBlocks are the bars of a sparkline, from lowest to highest
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// dashboardTUICmd creates the dashboard tui command
+func dashboardTUICmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tui",
+		Short: "Show the dashboard in the terminal",
+		Long: `Show the dashboard full screen in the terminal, for servers without a
+browser. Its panels are:
+
+  Cost rate       the cost of the cluster per month, day and hour, and how
+                  much of the requests of its pods is used
+  Idle workloads  workloads whose pods are all idle, with the savings of
+                  scaling them to zero
+  Recent alerts   the open alerts of 'upid monitor alerts', newest first
+  Savings trend   the potential savings over time, as kept by the dashboard
+
+The cluster is collected every --refresh, as by 'upid dashboard start', and
+its cost is added to the same trend. It reads the Kubernetes API directly
+and does not need the Python runtime.
+
+Keys:
+  r               collect the cluster now
+  q, esc, ctrl+c  quit
+
+Examples:
+  upid dashboard tui
+  upid dashboard tui --cluster prod-us --refresh 5m -t 24h`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dashboardTUI(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("cluster", "", "kubeconfig context of the cluster to show (default the current context)")
+	cmd.Flags().StringP("time-range", "t", "", "time range of usage at the datasource (default current usage)")
+	cmd.Flags().String("refresh", "30s", "how often the cluster is collected")
+	addCollectFlags(cmd)
+
+	return cmd
+}
+
+// Implementation functions
+func dashboardTUI(cmd *cobra.Command, args []string) error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return clierr.New(clierr.CategoryUsage, "TERMINAL_REQUIRED", "the terminal dashboard needs an interactive terminal").
+			WithHint("Serve the dashboard with 'upid dashboard start', or report with 'upid report generate'")
+	}
+	cluster, _ := cmd.Flags().GetString("cluster")
+	refresh, err := dashboardRefresh(cmd)
+	if err != nil {
+		return err
+	}
+	collector, err := dashboardCollector(cmd, []string{cluster})
+	if err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+	model := &tuiModel{
+		ctx:       ctx,
+		collector: collector,
+		refresh:   refresh,
+		context:   collector.Clients[0].Context(),
+		color:     output.ColorEnabled(config.IsNoColor(), os.Stdout),
+	}
+	if _, err := tea.NewProgram(model, tea.WithAltScreen(), tea.WithContext(ctx)).Run(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("dashboard failed: %w", err)
+	}
+	return nil
+}
+
+// tuiModel is the terminal UI of dashboard tui
+type tuiModel struct {
+	ctx       context.Context
+	collector *dashboard.Collector
+	refresh   time.Duration
+	context   string
+	color     bool
+
+	state  *dashboard.State
+	alerts []*notify.Incident
+	// collecting is set while a collection is in progress, and generation
+	// counts them so that only the timer of the last one collects again
+	collecting bool
+	generation int
+	width      int
+	height     int
+}
+
+// tuiCollected is the result of a collection
+type tuiCollected struct {
+	state  *dashboard.State
+	alerts []*notify.Incident
+}
+
+// tuiTick asks for a collection, unless another started since it was set
+type tuiTick struct {
+	generation int
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return m.collect()
+}
+
+// collect collects the cluster and reads the open alerts
+func (m *tuiModel) collect() tea.Cmd {
+	m.collecting = true
+	m.generation++
+	return func() tea.Msg {
+		collected := tuiCollected{state: m.collector.Collect(m.ctx)}
+		if incidents, err := notify.LoadIncidents(incidentsFile()); err == nil {
+			collected.alerts = incidents.Open
+		}
+		return collected
+	}
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "esc", "ctrl+c":
+			return m, tea.Quit
+		case "r":
+			if !m.collecting {
+				return m, m.collect()
+			}
+		}
+	case tuiCollected:
+		m.collecting = false
+		m.state = msg.state
+		m.alerts = msg.alerts
+		sort.SliceStable(m.alerts, func(i, j int) bool { return m.alerts[i].Opened.After(m.alerts[j].Opened) })
+		generation := m.generation
+		return m, tea.Tick(m.refresh, func(time.Time) tea.Msg { return tuiTick{generation: generation} })
+	case tuiTick:
+		if msg.generation == m.generation && !m.collecting {
+			return m, m.collect()
+		}
+	}
+	return m, nil
+}
+
+func (m *tuiModel) View() string {
+	width, height := m.width, m.height
+	if width == 0 {
+		width, height = 100, 30
+	}
+
+	status := "collecting…"
+	if m.state != nil {
+		status = "collected " + m.state.CollectedAt.Local().Format("15:04:05")
+		if m.collecting {
+			status += ", collecting…"
+		}
+	}
+	header := m.style(lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("69"))).Render("UPID") +
+		fmt.Sprintf(" dashboard of %s · %s · every %s", m.context, status, m.refresh)
+	footer := "r refresh · q quit"
+
+	var cluster *dashboard.Cluster
+	if m.state != nil && len(m.state.Clusters) > 0 {
+		cluster = m.state.Clusters[0]
+	}
+	// Two rows of two panels fill the terminal below the header and above
+	// the footer; each panel has a border of one cell
+	panelWidth := max((width-2)/2, 20)
+	panelHeight := max((height-4)/2, 4)
+	lines := panelHeight - 2
+	top := lipgloss.JoinHorizontal(lipgloss.Top,
+		m.panel("Cost rate", m.costLines(cluster), panelWidth, panelHeight),
+		m.panel("Idle workloads", m.idleLines(cluster, lines), panelWidth, panelHeight))
+	bottom := lipgloss.JoinHorizontal(lipgloss.Top,
+		m.panel("Recent alerts", m.alertLines(lines), panelWidth, panelHeight),
+		m.panel("Savings trend", m.trendLines(cluster, panelWidth-4), panelWidth, panelHeight))
+
+	var b strings.Builder
+	b.WriteString(lipgloss.NewStyle().MaxWidth(width).Render(header) + "\n")
+	b.WriteString(top + "\n" + bottom + "\n")
+	if cluster != nil && cluster.Error != "" {
+		footer = m.style(lipgloss.NewStyle().Foreground(lipgloss.Color("9"))).Render("Failed to collect: "+cluster.Error) + " · " + footer
+	}
+	b.WriteString(lipgloss.NewStyle().MaxWidth(width).Render(footer))
+	return b.String()
+}
+
+// panel renders lines in a bordered panel of a size, cutting them to fit
+func (m *tuiModel) panel(title string, lines []string, width, height int) string {
+	inner := width - 4
+	cut := lipgloss.NewStyle().MaxWidth(inner)
+	body := []string{m.style(lipgloss.NewStyle().Bold(true)).Render(title)}
+	for _, line := range lines {
+		if len(body) == height-2 {
+			break
+		}
+		body = append(body, cut.Render(line))
+	}
+	border := lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).Padding(0, 1).Width(width - 2).Height(height - 2)
+	if m.color {
+		border = border.BorderForeground(lipgloss.Color("62"))
+	}
+	return border.Render(strings.Join(body, "\n"))
+}
+
+// costLines are the lines of the cost rate panel
+func (m *tuiModel) costLines(cluster *dashboard.Cluster) []string {
+	if cluster == nil || cluster.Report == nil {
+		return []string{"No cost yet."}
+	}
+	r := cluster.Report
+	number := func(key string) float64 {
+		v, _ := r[key].(float64)
+		return v
+	}
+	monthly := number("monthly_cost")
+	used := "none"
+	if requested := number("requested_cost"); requested > 0 {
+		used = fmt.Sprintf("%.1f%%", 100*number("used_cost")/requested)
+	}
+	return []string{
+		"",
+		fmt.Sprintf("%-18s %s", "Per month", m.style(lipgloss.NewStyle().Bold(true)).Render(formatCost(monthly))),
+		fmt.Sprintf("%-18s %s", "Per day", formatCost(monthly*24/hoursPerMonth)),
+		fmt.Sprintf("%-18s %s", "Per hour", formatCost(monthly/hoursPerMonth)),
+		"",
+		fmt.Sprintf("%-18s %s, %s used", "Requested by pods", formatCost(number("requested_cost")), used),
+		fmt.Sprintf("%-18s %s", "Idle capacity", formatCost(number("idle_cost"))),
+		fmt.Sprintf("%-18s %s", "Potential savings", m.style(lipgloss.NewStyle().Foreground(lipgloss.Color("2"))).Render(formatCost(number("savings")))),
+		fmt.Sprintf("%-18s %v pods in %v workloads", "Running", r["pods"], r["workloads"]),
+	}
+}
+
+// idleLines are the lines of the idle workloads panel: the offenders of
+// the report to scale to zero
+func (m *tuiModel) idleLines(cluster *dashboard.Cluster, limit int) []string {
+	if cluster == nil || cluster.Report == nil {
+		return nil
+	}
+	offenders, _ := cluster.Report["offenders"].([]interface{})
+	var lines []string
+	for _, value := range offenders {
+		item, _ := value.(map[string]interface{})
+		if item == nil || item["action"] != "scale to zero" {
+			continue
+		}
+		savings, _ := item["savings"].(float64)
+		lines = append(lines, fmt.Sprintf("%10s  %v/%v (%v pods)", formatCost(savings), item["namespace"], item["workload"], item["pods"]))
+	}
+	if len(lines) == 0 {
+		return []string{"No idle workloads."}
+	}
+	if len(lines) > limit-1 {
+		lines = append(lines[:limit-2], fmt.Sprintf("and %d more", len(lines)-limit+2))
+	}
+	return lines
+}
+
+// alertLines are the lines of the recent alerts panel
+func (m *tuiModel) alertLines(limit int) []string {
+	if len(m.alerts) == 0 {
+		return []string{"No open alerts."}
+	}
+	var lines []string
+	for _, incident := range m.alerts {
+		severity := fmt.Sprintf("%-8s", incident.Severity)
+		if m.color {
+			switch kube.SeverityRank(incident.Severity) {
+			case kube.SeverityRank(kube.SeverityCritical):
+				severity = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("9")).Render(severity)
+			case kube.SeverityRank(kube.SeverityWarning):
+				severity = lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Render(severity)
+			}
+		}
+		age := time.Since(incident.Opened).Truncate(time.Minute)
+		lines = append(lines, fmt.Sprintf("%s %6s  %s", severity, shortDuration(age), incident.Title))
+	}
+	if len(lines) > limit-1 {
+		lines = append(lines[:limit-2], fmt.Sprintf("and %d more, see 'upid monitor alerts'", len(lines)-limit+2))
+	}
+	return lines
+}
+
+// trendLines are the lines of the savings trend panel: a sparkline of the
+// savings of the trend, its last width samples
+func (m *tuiModel) trendLines(cluster *dashboard.Cluster, width int) []string {
+	if cluster == nil || len(cluster.Trend) == 0 {
+		return []string{"No trend yet."}
+	}
+	samples := cluster.Trend
+	if len(samples) > width {
+		samples = samples[len(samples)-width:]
+	}
+	low, high := samples[0].Savings, samples[0].Savings
+	for _, s := range samples {
+		low, high = min(low, s.Savings), max(high, s.Savings)
+	}
+	spark := make([]rune, len(samples))
+	for i, s := range samples {
+		level := len(sparkBlocks) - 1
+		if high > low {
+			level = int((s.Savings - low) / (high - low) * float64(len(sparkBlocks)-1))
+		}
+		spark[i] = sparkBlocks[level]
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	lines := []string{
+		"",
+		m.style(lipgloss.NewStyle().Foreground(lipgloss.Color("2"))).Render(string(spark)),
+		"",
+		fmt.Sprintf("%-10s %s per month", "Now", formatCost(last.Savings)),
+		fmt.Sprintf("%-10s %s to %s", "Range", formatCost(low), formatCost(high)),
+	}
+	if len(samples) > 1 {
+		lines = append(lines, fmt.Sprintf("%-10s %+.2f since %s", "Change", last.Savings-first.Savings, first.Time.Local().Format("Jan 2 15:04")))
+	} else {
+		lines = append(lines, "One point per hour as the cluster is collected.")
+	}
+	return lines
+}
+
+// style returns s, or a plain style when color is disabled
+func (m *tuiModel) style(s lipgloss.Style) lipgloss.Style {
+	if !m.color {
+		return lipgloss.NewStyle().Bold(s.GetBold())
+	}
+	return s
+}
+
+// shortDuration formats a duration in its largest unit, such as 3d or 5h
+func shortDuration(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dm", int(d.Minutes()))
+}
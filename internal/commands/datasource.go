@@ -0,0 +1,374 @@
+package commands
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/bridge"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/prometheus"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
+	"github.com/spf13/cobra"
+)
+
+// datasourceFlags maps the flags of 'config datasource set' to the keys
+// they write
+var datasourceFlags = map[string]string{
+	"url":                  "datasource.url",
+	"username":             "datasource.username",
+	"tenant":               "datasource.tenant",
+	"ca-file":              "datasource.ca_file",
+	"cert-file":            "datasource.cert_file",
+	"key-file":             "datasource.key_file",
+	"insecure-skip-verify": "datasource.insecure_skip_verify",
+	"timeout":              "datasource.timeout",
+}
+
+// configDatasourceCmd creates the config datasource command
+func configDatasourceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "datasource",
+		Short: "Configure the historical metrics datasource",
+		Long: `Configure the Prometheus compatible datasource (Prometheus, Thanos or Mimir)
+queried for historical CPU, memory and network usage.
+
+With a datasource, analyze and idle detection commands use the usage over
+the whole --time-range instead of a point-in-time reading of metrics-server.
+The series come from cAdvisor (container_cpu_usage_seconds_total,
+container_memory_working_set_bytes and container_network_*_bytes_total).
+
+Tokens and passwords are kept in the credential store, the other settings in
+the config file under datasource.*.
+
+Examples:
+  upid config datasource set --url https://prometheus.example.com
+  upid config datasource set --url https://mimir.example.com/prometheus --tenant team-a --token-stdin
+  upid config datasource set --url https://thanos.example.com --ca-file ca.pem --username upid --password-stdin
+  upid config datasource show
+  upid config datasource test
+  upid config datasource remove`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return datasourceShow(cmd, args)
+		},
+	}
+
+	cmd.AddCommand(datasourceSetCmd())
+	cmd.AddCommand(datasourceShowCmd())
+	cmd.AddCommand(datasourceTestCmd())
+	cmd.AddCommand(datasourceRemoveCmd())
+
+	return cmd
+}
+
+// datasourceSetCmd creates the datasource set command
+func datasourceSetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set",
+		Short: "Set the datasource URL, credentials and TLS settings",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return datasourceSet(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("url", "", "base URL of the Prometheus API")
+	cmd.Flags().String("username", "", "user for basic authentication")
+	cmd.Flags().String("password", "", "password for basic authentication (prefer --password-stdin)")
+	cmd.Flags().Bool("password-stdin", false, "read the password from standard input")
+	cmd.Flags().String("token", "", "bearer token (prefer --token-stdin)")
+	cmd.Flags().Bool("token-stdin", false, "read the bearer token from standard input")
+	cmd.Flags().String("tenant", "", "tenant sent as X-Scope-OrgID (Mimir, multi-tenant Thanos)")
+	cmd.Flags().String("ca-file", "", "CA certificate file verifying the datasource")
+	cmd.Flags().String("cert-file", "", "client certificate file for mutual TLS")
+	cmd.Flags().String("key-file", "", "client key file for mutual TLS")
+	cmd.Flags().Bool("insecure-skip-verify", false, "do not verify the datasource certificate")
+	cmd.Flags().String("timeout", "", "time limit of each query, e.g. 30s")
+	cmd.MarkFlagsMutuallyExclusive("password", "password-stdin")
+	cmd.MarkFlagsMutuallyExclusive("token", "token-stdin")
+	cmd.MarkFlagsMutuallyExclusive("password-stdin", "token-stdin")
+
+	return mutating(cmd)
+}
+
+// datasourceShowCmd creates the datasource show command
+func datasourceShowCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show",
+		Short: "Show the datasource settings",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return datasourceShow(cmd, args)
+		},
+	}
+}
+
+// datasourceTestCmd creates the datasource test command
+func datasourceTestCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "test",
+		Short: "Check that the datasource answers and has usage series",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return datasourceTest(cmd, args)
+		},
+	}
+}
+
+// datasourceRemoveCmd creates the datasource remove command
+func datasourceRemoveCmd() *cobra.Command {
+	return mutating(&cobra.Command{
+		Use:   "remove",
+		Short: "Remove the datasource settings and credentials",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return datasourceRemove(cmd, args)
+		},
+	})
+}
+
+// Implementation functions
+func datasourceSet(cmd *cobra.Command, args []string) error {
+	updates := map[string]interface{}{}
+	for flag, name := range datasourceFlags {
+		if !cmd.Flags().Changed(flag) {
+			continue
+		}
+		key, _ := config.LookupKey(name)
+		value, err := key.Parse(cmd.Flags().Lookup(flag).Value.String())
+		if err != nil {
+			return clierr.Wrap(err, clierr.CategoryUsage, "INVALID_CONFIG_VALUE", err.Error())
+		}
+		updates[profileKey(cmd, name)] = value
+	}
+	token, err := secretFlag(cmd, "token")
+	if err != nil {
+		return err
+	}
+	password, err := secretFlag(cmd, "password")
+	if err != nil {
+		return err
+	}
+	if len(updates) == 0 && token == "" && password == "" {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "nothing to set").
+			WithHint("Give at least --url, see 'upid config datasource set --help'")
+	}
+	if config.GetDatasourceConfig().URL == "" && updates[profileKey(cmd, "datasource.url")] == nil {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "no datasource configured yet").
+			WithHint("Set its URL with --url")
+	}
+
+	path := config.FilePath()
+	if IsDryRun() {
+		var actions []string
+		for key, value := range updates {
+			actions = append(actions, fmt.Sprintf("set %s to %v in %s", key, value, path))
+		}
+		if token != "" {
+			actions = append(actions, "store the datasource token")
+		}
+		if password != "" {
+			actions = append(actions, "store the datasource password")
+		}
+		return printDryRun(actions...)
+	}
+
+	if len(updates) > 0 {
+		file, err := config.OpenFile(path)
+		if err != nil {
+			return err
+		}
+		for key, value := range updates {
+			if err := file.Set(key, value); err != nil {
+				return err
+			}
+		}
+		if err := file.Save(); err != nil {
+			return err
+		}
+		if err := config.ReadConfigFile(); err != nil {
+			return err
+		}
+		if err := config.Reload(); err != nil {
+			return err
+		}
+	}
+	// Bearer tokens and basic authentication exclude each other
+	if token != "" {
+		if _, err := storeCredential(credentialDatasourceToken, token); err != nil {
+			return err
+		}
+		deleteCredential(credentialDatasourcePass)
+	}
+	if password != "" {
+		if _, err := storeCredential(credentialDatasourcePass, password); err != nil {
+			return err
+		}
+		deleteCredential(credentialDatasourceToken)
+	}
+
+	result := datasourceSettings()
+	result["message"] = fmt.Sprintf("Datasource set to %s, check it with 'upid config datasource test'", config.GetDatasourceConfig().URL)
+	return renderResult(result)
+}
+
+func datasourceShow(cmd *cobra.Command, args []string) error {
+	if config.GetDatasourceConfig().URL == "" {
+		return renderResult(map[string]interface{}{
+			"message":    "No datasource configured, usage is read from metrics-server at one point in time",
+			"configured": false,
+		})
+	}
+	result := datasourceSettings()
+	result["message"] = "Datasource " + config.GetDatasourceConfig().URL
+	return renderResult(result)
+}
+
+func datasourceTest(cmd *cobra.Command, args []string) error {
+	client, err := datasource()
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return clierr.New(clierr.CategoryUsage, "DATASOURCE_NOT_CONFIGURED", "no datasource configured").
+			WithHint("Set one with 'upid config datasource set --url <url>'")
+	}
+
+	ctx := cmd.Context()
+	started := time.Now()
+	if _, err := client.Query(ctx, "vector(1)", started); err != nil {
+		return err
+	}
+	latency := time.Since(started)
+
+	// Analysis needs the cAdvisor series of the kubelets
+	series, err := client.Query(ctx, "count(container_cpu_usage_seconds_total)", time.Now())
+	if err != nil {
+		return err
+	}
+	result := map[string]interface{}{
+		"url":        client.URL(),
+		"status":     "reachable",
+		"latency_ms": latency.Milliseconds(),
+	}
+	if len(series) == 0 || len(series[0].Samples) == 0 {
+		result["message"] = fmt.Sprintf("Datasource %s answers but has no container_cpu_usage_seconds_total series", client.URL())
+		result["warning"] = "the datasource does not scrape cAdvisor, usage cannot be analyzed"
+		if err := renderResult(result); err != nil {
+			return err
+		}
+		return clierr.New(clierr.CategoryGeneral, "DATASOURCE_NO_SERIES", "datasource has no container usage series").
+			WithHint("Scrape the cAdvisor endpoint of the kubelets, e.g. with kube-prometheus-stack")
+	}
+	result["container_series"] = int(series[0].Samples[0].Value)
+	result["message"] = fmt.Sprintf("Datasource %s answers with %d container series", client.URL(), int(series[0].Samples[0].Value))
+	return renderResult(result)
+}
+
+func datasourceRemove(cmd *cobra.Command, args []string) error {
+	path := config.FilePath()
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("unset %s in %s", profileKey(cmd, "datasource"), path), "delete the stored datasource credentials")
+	}
+	file, err := config.OpenFile(path)
+	if err != nil {
+		return err
+	}
+	if file.Unset(profileKey(cmd, "datasource")) {
+		if err := file.Save(); err != nil {
+			return err
+		}
+	}
+	deleteCredential(credentialDatasourceToken)
+	deleteCredential(credentialDatasourcePass)
+	return renderResult(map[string]interface{}{
+		"message": "Removed the datasource, usage is read from metrics-server at one point in time",
+	})
+}
+
+// datasourceSettings describes the datasource settings without secrets
+func datasourceSettings() map[string]interface{} {
+	settings := config.GetDatasourceConfig()
+	auth := "none"
+	switch {
+	case hasCredential(credentialDatasourceToken):
+		auth = "bearer token"
+	case settings.Username != "":
+		auth = "basic (" + settings.Username + ")"
+	}
+	result := map[string]interface{}{
+		"configured":           true,
+		"url":                  settings.URL,
+		"authentication":       auth,
+		"insecure_skip_verify": settings.InsecureSkipVerify,
+		"timeout":              settings.Timeout.String(),
+	}
+	for key, value := range map[string]string{"tenant": settings.Tenant, "ca_file": settings.CAFile, "cert_file": settings.CertFile, "key_file": settings.KeyFile} {
+		if value != "" {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// datasource returns a client for the configured datasource, nil if none is
+// configured
+func datasource() (*prometheus.Client, error) {
+	settings := config.GetDatasourceConfig()
+	if settings.URL == "" {
+		return nil, nil
+	}
+	store := credentialStore()
+	token, _ := store.Get(credentialName(credentialDatasourceToken))
+	password, _ := store.Get(credentialName(credentialDatasourcePass))
+	return prometheus.New(prometheus.Options{
+		URL:                settings.URL,
+		Token:              token,
+		Username:           settings.Username,
+		Password:           password,
+		Tenant:             settings.Tenant,
+		CAFile:             settings.CAFile,
+		CertFile:           settings.CertFile,
+		KeyFile:            settings.KeyFile,
+		InsecureSkipVerify: settings.InsecureSkipVerify,
+		Timeout:            settings.Timeout,
+	})
+}
+
+// setDatasourceEnv passes the datasource settings to the runtime, which
+// receives the token and password with the other stored credentials
+func setDatasourceEnv(pb *bridge.PythonBridge) {
+	settings := config.GetDatasourceConfig()
+	pb.SetEnv("UPID_DATASOURCE_URL", settings.URL)
+	pb.SetEnv("UPID_DATASOURCE_USERNAME", settings.Username)
+	pb.SetEnv("UPID_DATASOURCE_TENANT", settings.Tenant)
+}
+
+// historyWindow parses a --time-range for queries of the datasource. It is
+// 0 without a datasource, when only point-in-time usage is available.
+func historyWindow(client *prometheus.Client, timeRange string) (time.Duration, error) {
+	if client == nil || timeRange == "" {
+		return 0, nil
+	}
+	window, err := timeutil.ParseDuration(timeRange)
+	if err != nil || window == 0 {
+		return 0, clierr.New(clierr.CategoryUsage, "INVALID_USAGE", fmt.Sprintf("invalid --time-range %q", timeRange)).
+			WithHint("Use a time range such as 6h, 7d or 2w")
+	}
+	return window, nil
+}
+
+// hasCredential returns true if a credential of the active profile is stored
+func hasCredential(name string) bool {
+	value, err := credentialStore().Get(credentialName(name))
+	return err == nil && value != ""
+}
+
+// deleteCredential removes a credential of the active profile, if stored
+func deleteCredential(name string) {
+	if err := credentialStore().Delete(credentialName(name)); err != nil {
+		slog.Warn("failed to delete stored credential", "name", name, "error", err)
+	}
+}
@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+)
+
+// Command annotations used by the command framework
+const (
+	// annotationMutating marks commands that change cluster or UPID state
+	annotationMutating = "upid.io/mutating"
+
+	// annotationNativeDryRun marks mutating commands whose backend simulates
+	// the change itself when passed --dry-run
+	annotationNativeDryRun = "upid.io/native-dry-run"
+)
+
+// dryRun is true when the running command must not make any changes
+var dryRun bool
+
+// mutating marks cmd as a command that makes changes
+func mutating(cmd *cobra.Command) *cobra.Command {
+	if cmd.Annotations == nil {
+		cmd.Annotations = map[string]string{}
+	}
+	cmd.Annotations[annotationMutating] = "true"
+	return cmd
+}
+
+// mutatingWithNativeDryRun marks cmd as a command that makes changes and
+// forwards --dry-run to the backend for a simulated run
+func mutatingWithNativeDryRun(cmd *cobra.Command) *cobra.Command {
+	mutating(cmd)
+	cmd.Annotations[annotationNativeDryRun] = "true"
+	return cmd
+}
+
+// prepareDryRun enables dry-run enforcement for cmd when the global --dry-run
+// flag is set
+func prepareDryRun(cmd *cobra.Command) {
+	dryRun = false
+	if cmd.Annotations[annotationMutating] != "true" || cmd.Annotations[annotationNativeDryRun] == "true" {
+		return
+	}
+	dryRun, _ = cmd.Flags().GetBool("dry-run")
+}
+
+// IsDryRun returns true if the running command must not make any changes
+func IsDryRun() bool {
+	return dryRun
+}
+
+// printDryRun reports the action a mutating command would have taken
+func printDryRun(actions ...string) error {
+	fmt.Println("Dry run: no changes were made. Would execute:")
+	for _, action := range actions {
+		slog.Info("dry run", "action", action)
+		fmt.Printf("  %s\n", action)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// configEncryptCmd creates the config encrypt command
+func configEncryptCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "encrypt",
+		Short: "Encrypt the config file at rest",
+		Long: `Encrypt the config file with age, so that it can be kept in a dotfiles
+repository even when it holds enterprise tokens. The file stays encrypted:
+commands decrypt it when they load it, and 'config set', 'unset', 'edit' and
+'sync' encrypt it again when they change it.
+
+Choose who can decrypt the file:
+  --passphrase         a passphrase, read from $UPID_CONFIG_PASSPHRASE or asked for
+  --recipient age1...  an age public key; repeat for several people or machines
+  --recipient age1<plugin>1...
+                       a key held by an age plugin, e.g. a cloud KMS or hardware
+                       token, which must be installed as age-plugin-<plugin>
+  --new-identity       a new age key in $XDG_DATA_HOME/upid/identity.txt
+
+The recipients are listed in <config>.recipients next to the config file;
+it holds only public keys and should be committed with it. To decrypt, UPID
+uses the age identity file in $UPID_CONFIG_IDENTITY (default
+$XDG_DATA_HOME/upid/identity.txt), or the passphrase. Run encrypt again to
+change the recipients.
+
+Examples:
+  upid config encrypt --passphrase
+  upid config encrypt --new-identity --recipient age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return configEncrypt(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().Bool("passphrase", false, "encrypt with a passphrase")
+	cmd.Flags().StringArray("recipient", nil, "age public key or plugin recipient that can decrypt the file (repeatable)")
+	cmd.Flags().Bool("new-identity", false, "create an age key for this machine and add it as a recipient")
+
+	return mutating(cmd)
+}
+
+// configDecryptCmd creates the config decrypt command
+func configDecryptCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "decrypt",
+		Short: "Store the config file in plain text again",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return configDecrypt(cmd, args)
+		},
+	}
+	return mutating(cmd)
+}
+
+// Implementation functions
+func configEncrypt(cmd *cobra.Command, args []string) error {
+	passphrase, _ := cmd.Flags().GetBool("passphrase")
+	recipients, _ := cmd.Flags().GetStringArray("recipient")
+	newIdentity, _ := cmd.Flags().GetBool("new-identity")
+
+	if passphrase == (len(recipients) > 0 || newIdentity) {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "use either --passphrase or --recipient/--new-identity").
+			WithHint("Run 'upid config encrypt --help' for the choices")
+	}
+
+	path := config.FilePath()
+	if IsDryRun() {
+		who := "a passphrase"
+		if !passphrase {
+			who = fmt.Sprintf("%d recipients", len(recipients))
+			if newIdentity {
+				who = fmt.Sprintf("a new identity in %s and %s", config.IdentityFile(), who)
+			}
+		}
+		return printDryRun(fmt.Sprintf("encrypt %s for %s", path, who))
+	}
+
+	if passphrase {
+		recipients = []string{"passphrase"}
+	}
+	if newIdentity {
+		recipient, err := config.GenerateIdentity()
+		if err != nil {
+			return err
+		}
+		recipients = append([]string{recipient}, recipients...)
+	}
+	if err := config.EncryptFile(path, recipients); err != nil {
+		return err
+	}
+
+	result := map[string]interface{}{
+		"message":         fmt.Sprintf("Encrypted %s; recipients are listed in %s", path, config.RecipientsFile(path)),
+		"file":            path,
+		"recipients_file": config.RecipientsFile(path),
+		"recipients":      recipients,
+	}
+	if newIdentity {
+		result["identity_file"] = config.IdentityFile()
+		result["message"] = fmt.Sprintf("%s. Back up %s, it is needed to decrypt the file", result["message"], config.IdentityFile())
+	}
+	return renderResult(result)
+}
+
+func configDecrypt(cmd *cobra.Command, args []string) error {
+	path := config.FilePath()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if !config.IsEncrypted(data) {
+		return renderResult(map[string]interface{}{"message": fmt.Sprintf("%s is not encrypted", path)})
+	}
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("decrypt %s and remove %s", path, config.RecipientsFile(path)))
+	}
+
+	if err := config.DecryptFile(path); err != nil {
+		return err
+	}
+	return renderResult(map[string]interface{}{
+		"message": fmt.Sprintf("Decrypted %s; remove it from version control if it holds secrets", path),
+	})
+}
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"github.com/kubilitics/upid-cli/internal/config"
 	"github.com/spf13/cobra"
 )
 
@@ -51,8 +52,9 @@ func enterpriseConfigureCmd() *cobra.Command {
 	// Add flags
 	cmd.Flags().StringP("endpoint", "e", "", "enterprise endpoint")
 	cmd.Flags().StringP("token", "t", "", "enterprise token")
+	cmd.Flags().Bool("token-stdin", false, "read the enterprise token from standard input")
 
-	return cmd
+	return mutating(cmd)
 }
 
 // enterpriseSyncCmd creates the sync command
@@ -70,12 +72,12 @@ func enterpriseSyncCmd() *cobra.Command {
 	cmd.Flags().BoolP("force", "f", false, "force sync")
 	cmd.Flags().StringP("time-range", "t", "24h", "time range to sync")
 
-	return cmd
+	return mutating(cmd)
 }
 
 // Implementation functions
 func enterpriseStatus(cmd *cobra.Command, args []string) error {
-	return executePythonCommand("enterprise", []string{"status"})
+	return executePythonCommand(cmd.Context(), "enterprise", []string{"status"})
 }
 
 func enterpriseConfigure(cmd *cobra.Command, args []string) error {
@@ -86,22 +88,28 @@ func enterpriseConfigure(cmd *cobra.Command, args []string) error {
 
 	// Get flags
 	endpoint, _ := cmd.Flags().GetString("endpoint")
-	token, _ := cmd.Flags().GetString("token")
+	token, err := secretFlag(cmd, "token")
+	if err != nil {
+		return err
+	}
 
 	// Build arguments
 	cmdArgs := []string{"configure", feature}
 	if endpoint != "" {
 		cmdArgs = append(cmdArgs, "--endpoint", endpoint)
 	}
-	if token != "" {
-		cmdArgs = append(cmdArgs, "--token", token)
+	if token != "" && !IsDryRun() {
+		// The runtime receives the stored token in its environment
+		if _, err := storeCredential(credentialEnterpriseToken, token); err != nil {
+			return err
+		}
 	}
 
-	return executePythonCommand("enterprise", cmdArgs)
+	return executePythonCommand(cmd.Context(), "enterprise", cmdArgs)
 }
 
 func enterpriseSync(cmd *cobra.Command, args []string) error {
-	clusterName := "default"
+	clusterName := config.GetDefaultCluster()
 	if len(args) > 0 {
 		clusterName = args[0]
 	}
@@ -119,5 +127,5 @@ func enterpriseSync(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--time-range", timeRange)
 	}
 
-	return executePythonCommand("enterprise", cmdArgs)
+	return executePythonCommand(cmd.Context(), "enterprise", cmdArgs)
 } 
\ No newline at end of file
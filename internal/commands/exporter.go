@@ -0,0 +1,474 @@
+package commands
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/anomaly"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/notify"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
+	"github.com/spf13/cobra"
+)
+
+// exporterShutdown bounds how long scrapes in progress may finish when the
+// exporter stops
+const exporterShutdown = 5 * time.Second
+
+// The cost of a cluster spikes when it is more than the threshold over its
+// average in spikeBaseline, once there are spikeMinSamples collections in it
+const (
+	spikeBaseline   = 24 * time.Hour
+	spikeMinSamples = 3
+)
+
+// monitorServeCmd creates the Prometheus exporter command
+func monitorServeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Expose UPID metrics to Prometheus",
+		Long: `Serve the metrics of the cluster in the Prometheus text format at /metrics
+of --listen, until interrupted, so that existing scrapes and alerting rules
+can consume them:
+
+  upid_requests_cost_monthly            cost of the requests of running pods, by namespace
+  upid_idle_workloads                   workloads whose pods are all idle, by namespace
+  upid_estimated_waste_monthly          cost of the requests pods do not use, by namespace
+  upid_scaled_workloads                 workloads UPID scaled to zero, by namespace
+  upid_realized_savings_monthly         cost of the replicas UPID scaled to zero, by namespace
+  upid_recommendations                  recommendations by severity of their savings
+  upid_anomaly_deviation                deviation of cost, cpu and memory from their baseline,
+                                        by scope, in standard deviations
+  upid_up                               1 if the last collection succeeded
+  upid_last_collection_timestamp_seconds
+  upid_collection_duration_seconds
+
+Recommendations are scaling idle workloads to zero and right-sizing
+workloads that use less than half of their requests; they are critical
+from 100 and warnings from 20 of monthly savings. The metrics are
+collected every --interval rather than on each scrape. Usage is the
+average over --time-range at the configured datasource, or the current
+usage from metrics-server. Costs are per month in the currency of the
+pricing model in config. The exporter reads the Kubernetes API directly
+and does not need the Python runtime.
+
+The exporter raises incidents in the notification channels that take
+alerts, see 'upid monitor channels', when the cluster is unreachable and
+when the cost of its requests is --spike-threshold percent more than their
+average of the last day, and resolves them when the condition clears.
+
+It also learns the pattern of the cost of the requests and of the CPU and
+memory used by the cluster and by each namespace, with a baseline for each
+hour of the week, and raises an anomaly when a value departs from the
+baseline of its hour by more than --anomaly-sensitivity standard
+deviations: a warning, critical from twice the sensitivity. A scope is
+scored once its hour of the day has been seen on 3 days, and by the hour of
+the week once that has been seen in 3 weeks, whatever the --interval.
+Sensitivity is given for all scopes, and for a scope as cluster=<deviation>
+or namespace:<name>=<deviation>; lower is more sensitive and 0 does not
+raise anomalies of a scope. Baselines are kept in the anomaly directory of the
+state directory, by context, and learn across restarts.
+
+` + serverTLSHelp + `
+
+Examples:
+  upid monitor serve                          # Serve on :9877
+  upid monitor serve --listen 127.0.0.1:9100  # Local scrapes only
+  upid monitor serve -t 24h --interval 15m    # Daily usage from a datasource
+  upid monitor serve --anomaly-sensitivity 3,namespace:batch=0,namespace:payments=2
+  upid monitor serve --tls-cert exporter.pem --tls-key exporter-key.pem`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return monitorServe(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("listen", ":9877", "address to serve metrics on")
+	cmd.Flags().String("interval", "5m", "how often metrics are collected")
+	cmd.Flags().StringP("time-range", "t", "", "time range of usage at the datasource (default current usage)")
+	cmd.Flags().Float64("confidence", 0.90, "confidence from which pods are idle")
+	cmd.Flags().Float64("spike-threshold", 50, "percent over its average of the last day from which the cost spikes (0 to not raise incidents of spikes)")
+	cmd.Flags().StringSlice("anomaly-sensitivity", []string{"3"}, "standard deviations from the baseline from which values are anomalies, for all scopes or as <scope>=<deviation>")
+	cmd.Flags().Float64("cpu-price", 0, "price per requested core-hour (default pricing.cpu)")
+	cmd.Flags().Float64("memory-price", 0, "price per requested GiB-hour (default pricing.memory)")
+	addTLSFlags(cmd)
+
+	return cmd
+}
+
+func monitorServe(cmd *cobra.Command, args []string) error {
+	// Get flags
+	listen, _ := cmd.Flags().GetString("listen")
+	intervalFlag, _ := cmd.Flags().GetString("interval")
+	timeRange, _ := cmd.Flags().GetString("time-range")
+	confidence, _ := cmd.Flags().GetFloat64("confidence")
+	spikeThreshold, _ := cmd.Flags().GetFloat64("spike-threshold")
+	sensitivityFlag, _ := cmd.Flags().GetStringSlice("anomaly-sensitivity")
+
+	interval, err := timeutil.ParseDuration(intervalFlag)
+	if err != nil || interval < time.Minute {
+		return fmt.Errorf("invalid --interval %q (expected a duration of 1m or more)", intervalFlag)
+	}
+	if confidence < 0 || confidence > 1 {
+		return fmt.Errorf("invalid --confidence %g (expected 0 to 1)", confidence)
+	}
+	if spikeThreshold < 0 {
+		return fmt.Errorf("invalid --spike-threshold %g (expected at least 0)", spikeThreshold)
+	}
+	sensitivity, err := anomaly.ParseSensitivity(sensitivityFlag)
+	if err != nil {
+		return err
+	}
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return err
+	}
+	client, window, err := nativeClient(timeRange)
+	if err != nil {
+		return err
+	}
+	model, err := anomaly.Load(anomalyFile(client.Context()), client.Context())
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := serverTLS(cmd, listen)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", listen, err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	ctx := cmd.Context()
+	e := &exporter{
+		context:        client.Context(),
+		currency:       config.GetPricingConfig().Currency,
+		spikeThreshold: spikeThreshold,
+		sensitivity:    sensitivity,
+		model:          model,
+	}
+	opts := native.MetricsOptions{Window: window, MinConfidence: confidence, Prices: prices}
+	go e.collect(ctx, client, opts, interval)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		e.write(w)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "UPID exporter: metrics are at /metrics")
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), exporterShutdown)
+		defer cancel()
+		_ = server.Shutdown(shutdown)
+	}()
+
+	if quiet, _ := activeFlagBool("quiet"); !quiet {
+		fmt.Fprintf(os.Stderr, "Serving metrics of context %s on %s://%s/metrics, press Ctrl+C to stop\n", e.context, serverScheme(tlsConfig), listener.Addr())
+		if sensitivity.Enabled() {
+			fmt.Fprintf(os.Stderr, "Raising anomalies from %s standard deviations, baselines in %s\n", sensitivity, model.Path())
+		}
+	}
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve metrics: %v", err)
+	}
+	return nil
+}
+
+// exporter holds the last metrics collected from a cluster
+type exporter struct {
+	context        string
+	currency       string
+	spikeThreshold float64
+	// costs are the costs of the cluster collected in spikeBaseline
+	costs       []costSample
+	sensitivity anomaly.Sensitivity
+	model       *anomaly.Model
+
+	mu       sync.Mutex
+	metrics  *native.Metrics
+	up       bool
+	duration time.Duration
+	// deviations are the last scores of the series of the model, by scope
+	// and metric
+	deviations map[string]map[string]float64
+}
+
+// costSample is the cost of a cluster at a collection
+type costSample struct {
+	time time.Time
+	cost float64
+}
+
+// collect collects the metrics every interval until ctx is done. Failed
+// collections keep the metrics of the last one that succeeded.
+func (e *exporter) collect(ctx context.Context, client *native.Client, opts native.MetricsOptions, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		metrics, err := client.CollectMetrics(ctx, opts)
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("failed to collect metrics", "error", err)
+		}
+		e.mu.Lock()
+		e.up = err == nil
+		e.duration = time.Since(start)
+		if err == nil {
+			e.metrics = metrics
+		}
+		e.mu.Unlock()
+		if ctx.Err() == nil {
+			e.page(ctx, metrics, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// page raises and resolves the incidents of the cluster after a collection:
+// the cluster is unreachable, or its cost spikes
+func (e *exporter) page(ctx context.Context, metrics *native.Metrics, err error) {
+	unreachable := incidentKey(e.context, "unreachable")
+	if err != nil {
+		if clierr.From(err).Code == "CLUSTER_UNREACHABLE" {
+			raiseIncident(ctx, notify.Event{
+				Title:   "Cluster unreachable: " + e.context,
+				Message: fmt.Sprintf("UPID cannot read the cluster: %v", err),
+				Source:  e.context,
+				Key:     unreachable,
+			})
+		}
+		return
+	}
+	resolveIncident(ctx, unreachable, "UPID reads the cluster again")
+	e.detect(ctx, metrics)
+	if e.spikeThreshold == 0 {
+		return
+	}
+
+	cost := 0.0
+	for _, value := range metrics.Cost {
+		cost += value
+	}
+	now := metrics.CollectedAt
+	for len(e.costs) > 0 && now.Sub(e.costs[0].time) > spikeBaseline {
+		e.costs = e.costs[1:]
+	}
+	if len(e.costs) >= spikeMinSamples {
+		baseline := 0.0
+		for _, sample := range e.costs {
+			baseline += sample.cost
+		}
+		baseline /= float64(len(e.costs))
+		key := incidentKey(e.context, "cost-spike")
+		if baseline > 0 && cost > baseline*(1+e.spikeThreshold/100) {
+			raiseIncident(ctx, notify.Event{
+				Title: "Cost spike: " + e.context,
+				Message: fmt.Sprintf("The requests of the cluster cost %s per month, %.0f%% more than their average of %s over the last day",
+					formatCost(cost), (cost/baseline-1)*100, formatCost(baseline)),
+				Source: e.context,
+				Key:    key,
+				Fields: []notify.Field{{Name: "Cost", Value: formatCost(cost) + " per month"}, {Name: "Average", Value: formatCost(baseline) + " per month"}},
+			})
+		} else {
+			resolveIncident(ctx, key, fmt.Sprintf("The requests of the cluster cost %s per month, back within %g%% of their average", formatCost(cost), e.spikeThreshold))
+		}
+	}
+	e.costs = append(e.costs, costSample{time: now, cost: cost})
+}
+
+// detect scores the cost and usage of the cluster and of each namespace
+// against their baselines, learns them, and raises an incident for each
+// series whose deviation passes the sensitivity of its scope
+func (e *exporter) detect(ctx context.Context, metrics *native.Metrics) {
+	values := map[string]map[string]float64{"cluster": {}}
+	add := func(namespace, metric string, value float64) {
+		scope := "namespace:" + namespace
+		if values[scope] == nil {
+			values[scope] = map[string]float64{}
+		}
+		values[scope][metric] += value
+		values["cluster"][metric] += value
+	}
+	for namespace, cost := range metrics.Cost {
+		add(namespace, anomaly.MetricCost, cost)
+	}
+	for namespace, used := range metrics.Usage {
+		add(namespace, anomaly.MetricCPU, used.CPU)
+		add(namespace, anomaly.MetricMemory, used.Memory)
+	}
+
+	deviations := map[string]map[string]float64{}
+	for _, scope := range sortedKeys(values) {
+		threshold := e.sensitivity.For(scope)
+		for _, metric := range []string{anomaly.MetricCost, anomaly.MetricCPU, anomaly.MetricMemory} {
+			// Series are learned even where anomalies are not raised, so
+			// that they score as soon as a sensitivity is set
+			score := e.model.Observe(scope, metric, metrics.CollectedAt, values[scope][metric])
+			if !score.Scored {
+				continue
+			}
+			if deviations[scope] == nil {
+				deviations[scope] = map[string]float64{}
+			}
+			deviations[scope][metric] = score.Deviation
+			key := incidentKey(e.context, "anomaly", scope, metric)
+			if threshold == 0 || math.Abs(score.Deviation) < threshold {
+				resolveIncident(ctx, key, fmt.Sprintf("The %s of %s is back within %g standard deviations of its baseline", metric, scope, threshold))
+				continue
+			}
+			raiseIncident(ctx, anomalyEvent(e.context, scope, metric, score, threshold))
+		}
+	}
+	if err := e.model.Save(); err != nil {
+		slog.Warn("failed to save anomaly baselines", "error", err)
+	}
+	e.mu.Lock()
+	e.deviations = deviations
+	e.mu.Unlock()
+}
+
+// anomalyEvent reports a value that departs from its baseline
+func anomalyEvent(kubeContext, scope, metric string, score anomaly.Score, threshold float64) notify.Event {
+	format := func(value float64) string {
+		switch metric {
+		case anomaly.MetricCost:
+			return formatCost(value) + " per month"
+		case anomaly.MetricMemory:
+			return fmt.Sprintf("%dMi", int64(math.Round(value/(1<<20))))
+		}
+		return fmt.Sprintf("%dm", int64(math.Round(value*1000)))
+	}
+	direction := "above"
+	if score.Deviation < 0 {
+		direction = "below"
+	}
+	severity := kube.SeverityWarning
+	if math.Abs(score.Deviation) >= 2*threshold {
+		severity = kube.SeverityCritical
+	}
+	event := notify.Event{
+		Severity: severity,
+		Title:    fmt.Sprintf("Anomalous %s of %s in %s", metric, scope, kubeContext),
+		Message: fmt.Sprintf("The %s of %s is %s, %.1f standard deviations %s the %s expected at this hour",
+			metric, scope, format(score.Value), math.Abs(score.Deviation), direction, format(score.Expected)),
+		Source: kubeContext,
+		Key:    incidentKey(kubeContext, "anomaly", scope, metric),
+		Fields: []notify.Field{
+			{Name: "Value", Value: format(score.Value)},
+			{Name: "Expected", Value: format(score.Expected)},
+			{Name: "Deviation", Value: fmt.Sprintf("%+.1f", score.Deviation)},
+		},
+	}
+	if namespace, found := strings.CutPrefix(scope, "namespace:"); found {
+		event.Fields = append(event.Fields, notify.Field{Name: "Namespace", Value: namespace})
+	}
+	return event
+}
+
+// anomalyFile returns the local file of the anomaly baselines of a context
+func anomalyFile(kubeContext string) string {
+	return filepath.Join(config.GetStateDir(), "anomaly", fileSafe(kubeContext)+".json")
+}
+
+// write writes the metrics in the Prometheus text format
+func (e *exporter) write(w io.Writer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	cluster := promLabel("context", e.context)
+	up := 0
+	if e.up {
+		up = 1
+	}
+	promFamily(w, "upid_up", "Whether the last collection of metrics succeeded.")
+	fmt.Fprintf(w, "upid_up{%s} %d\n", cluster, up)
+	promFamily(w, "upid_collection_duration_seconds", "How long the last collection of metrics took.")
+	fmt.Fprintf(w, "upid_collection_duration_seconds{%s} %g\n", cluster, e.duration.Seconds())
+	m := e.metrics
+	if m == nil {
+		return
+	}
+	promFamily(w, "upid_last_collection_timestamp_seconds", "When the metrics were last collected.")
+	fmt.Fprintf(w, "upid_last_collection_timestamp_seconds{%s} %d\n", cluster, m.CollectedAt.Unix())
+
+	counts := func(name, help string, values map[string]int) {
+		promFamily(w, name, help)
+		for _, namespace := range sortedKeys(values) {
+			fmt.Fprintf(w, "%s{%s,%s} %d\n", name, cluster, promLabel("namespace", namespace), values[namespace])
+		}
+	}
+	costs := func(name, help string, values map[string]float64) {
+		promFamily(w, name, help)
+		for _, namespace := range sortedKeys(values) {
+			fmt.Fprintf(w, "%s{%s,%s,%s} %.2f\n", name, cluster, promLabel("namespace", namespace), promLabel("currency", e.currency), values[namespace])
+		}
+	}
+	costs("upid_requests_cost_monthly", "Monthly cost of the requests of running pods.", m.Cost)
+	counts("upid_idle_workloads", "Workloads whose pods are all idle and could be scaled to zero.", m.IdleWorkloads)
+	costs("upid_estimated_waste_monthly", "Monthly cost of the requests running pods do not use.", m.Waste)
+	counts("upid_scaled_workloads", "Workloads scaled to zero by UPID.", m.ScaledWorkloads)
+	costs("upid_realized_savings_monthly", "Monthly cost of the replicas scaled to zero by UPID.", m.RealizedSavings)
+	promFamily(w, "upid_recommendations", "Recommendations by severity of their savings.")
+	for _, severity := range kube.Severities {
+		fmt.Fprintf(w, "upid_recommendations{%s,%s} %d\n", cluster, promLabel("severity", severity), m.Recommendations[severity])
+	}
+	promFamily(w, "upid_anomaly_deviation", "Deviation of the cost and usage from their baseline, in standard deviations.")
+	for _, scope := range sortedKeys(e.deviations) {
+		for _, metric := range sortedKeys(e.deviations[scope]) {
+			fmt.Fprintf(w, "upid_anomaly_deviation{%s,%s,%s} %.2f\n", cluster, promLabel("scope", scope), promLabel("metric", metric), e.deviations[scope][metric])
+		}
+	}
+}
+
+// promFamily writes the help and type of a gauge
+func promFamily(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+// promLabel formats a label with its value escaped
+func promLabel(name, value string) string {
+	return fmt.Sprintf(`%s="%s"`, name, strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value))
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,169 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/oidc"
+)
+
+// keySetTTL is how long cached signing keys of identity providers are used
+// before they are downloaded again
+const keySetTTL = 24 * time.Hour
+
+// expiryWarning warns about logins that expire within this time and cannot
+// be refreshed
+const expiryWarning = 10 * time.Minute
+
+// loginFreeGroups are command groups that work without a valid login
+var loginFreeGroups = map[string]bool{"auth": true, "config": true, "system": true}
+
+// keyCache returns the cache of identity provider signing keys
+func keyCache() *oidc.KeyCache {
+	return &oidc.KeyCache{Dir: filepath.Join(config.GetCacheDir(), "jwks"), TTL: keySetTTL}
+}
+
+// checkLogin fails fast, before the runtime is started, if the login of the
+// active profile has expired. SSO sessions about to expire are refreshed,
+// and logins that cannot be refreshed are reported when they expire soon.
+// Expiry is read from the stored tokens without a network round trip.
+func checkLogin(ctx context.Context) error {
+	if IsDryRun() || apiKeyInUse() || !requiresLogin() {
+		return nil
+	}
+	session, err := loadSession()
+	if err != nil {
+		slog.Warn("failed to read stored session", "error", err)
+		return nil
+	}
+	if session != nil && session.RefreshToken != "" {
+		_, err := currentSession(ctx)
+		return err
+	}
+
+	expiry := loginExpiry(session)
+	if expiry.IsZero() {
+		return nil
+	}
+	remaining := time.Until(expiry)
+	if remaining <= 0 {
+		return clierr.New(clierr.CategoryAuth, "TOKEN_EXPIRED", fmt.Sprintf("token expired at %s", expiry.Format(time.RFC3339))).
+			WithHint("Run 'upid auth login' to sign in again")
+	}
+	if quiet, _ := activeFlagBool("quiet"); !quiet && remaining < expiryWarning {
+		fmt.Fprintf(os.Stderr, "Warning: login expires in %s, run 'upid auth login' to renew it\n", remaining.Round(time.Second))
+	}
+	return nil
+}
+
+// requiresLogin returns true if the active command needs a valid login
+func requiresLogin() bool {
+	if activeCommand == nil {
+		return false
+	}
+	group := activeCommand
+	for group.HasParent() && group.Parent().HasParent() {
+		group = group.Parent()
+	}
+	return !loginFreeGroups[group.Name()]
+}
+
+// loginExpiry returns when the login of the active profile expires, the zero
+// time if unknown
+func loginExpiry(session *oidc.Session) time.Time {
+	if session != nil {
+		return session.Expiry
+	}
+	token, _ := credentialStore().Get(credentialName(credentialAuthToken))
+	if parsed, err := oidc.ParseJWT(token); err == nil {
+		return parsed.Expiry()
+	}
+	return time.Time{}
+}
+
+// loginToken returns the stored token of the active profile that identifies
+// the user, preferring access tokens that are JWTs over ID tokens
+func loginToken(session *oidc.Session) string {
+	if session == nil {
+		token, _ := credentialStore().Get(credentialName(credentialAuthToken))
+		return token
+	}
+	if oidc.IsJWT(session.AccessToken) {
+		return session.AccessToken
+	}
+	return session.IDToken
+}
+
+// loginTrust returns the issuer and client a stored token must belong to:
+// those of the SSO session, else auth.issuer and auth.client_id
+func loginTrust(session *oidc.Session) oidc.Trust {
+	if session != nil && session.Issuer != "" {
+		return oidc.Trust{Issuer: session.Issuer, ClientID: session.ClientID}
+	}
+	auth := config.GetAuthConfig()
+	return oidc.Trust{Issuer: auth.Issuer, ClientID: auth.ClientID}
+}
+
+// tokenStatus describes a stored login, verifying its token against the
+// cached signing keys of the trusted issuer. A token whose signature could
+// not be checked is reported as unverified, not as authenticated.
+func tokenStatus(ctx context.Context, token string, session *oidc.Session, offline bool) map[string]interface{} {
+	verification, err := keyCache().Verify(ctx, token, loginTrust(session), offline)
+	result := map[string]interface{}{
+		"authenticated": err == nil,
+		"status":        "valid",
+		"profile":       config.GetProfile(),
+	}
+	if err == nil && !verification.Verified {
+		result["authenticated"] = false
+		result["status"] = "unverified"
+	}
+	if config.GetProfile() == "" {
+		result["profile"] = defaultSession
+	}
+	if verification != nil {
+		claims := verification.Token.Claims
+		for _, claim := range []string{"email", "preferred_username", "sub"} {
+			if user, ok := claims[claim].(string); ok && user != "" {
+				result["user"] = user
+				break
+			}
+		}
+		result["issuer"] = verification.Token.Issuer()
+		if expiry := verification.Token.Expiry(); !expiry.IsZero() {
+			result["expires_at"] = expiry.Format(time.RFC3339)
+			if remaining := time.Until(expiry); remaining > 0 {
+				result["expires_in"] = remaining.Round(time.Second).String()
+			}
+		}
+		if verification.Verified {
+			result["signature"] = "verified"
+			result["keys_fetched_at"] = verification.KeysFetchedAt.Format(time.RFC3339)
+		} else if verification.Reason != "" {
+			result["signature"] = "not verified: " + verification.Reason
+		}
+	}
+	if err != nil {
+		e := clierr.From(err)
+		result["status"] = "invalid"
+		result["error"] = e.Message
+		if e.Code == "TOKEN_EXPIRED" {
+			result["status"] = "expired"
+			if session != nil && session.RefreshToken != "" {
+				// The next command refreshes the session
+				result["authenticated"] = true
+				result["status"] = "expired, refreshed on next use"
+			}
+		}
+	}
+	if session != nil {
+		result["auth_method"] = "sso"
+	}
+	return result
+}
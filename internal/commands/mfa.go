@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/bridge"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/oidc"
+	"golang.org/x/term"
+)
+
+// mfaCodeEnv passes a one-time code to the runtime, where other users cannot
+// see it
+const mfaCodeEnv = "UPID_MFA_CODE"
+
+// mfaMaxAttempts limits how often a second factor is asked for
+const mfaMaxAttempts = 3
+
+// Second-factor methods offered by a login challenge
+const (
+	mfaTOTP     = "totp"
+	mfaWebAuthn = "webauthn"
+)
+
+// mfaChallenge is a request for a second factor, returned by the runtime as
+// a login result with mfa_required set
+type mfaChallenge struct {
+	// ID identifies the challenge when it is answered
+	ID string
+	// Methods are the accepted second factors, e.g. totp and webauthn
+	Methods []string
+	// WebAuthnURL is the page that asks for a security key or passkey
+	WebAuthnURL string
+	// Message explains why a previous answer was rejected, if it was
+	Message string
+}
+
+// parseMFAChallenge returns the second-factor challenge of a login result,
+// nil if the login needs none
+func parseMFAChallenge(result map[string]interface{}) *mfaChallenge {
+	if required, _ := result["mfa_required"].(bool); !required {
+		return nil
+	}
+	challenge := &mfaChallenge{}
+	challenge.ID, _ = result["mfa_challenge"].(string)
+	challenge.WebAuthnURL, _ = result["webauthn_url"].(string)
+	challenge.Message, _ = result["message"].(string)
+	if methods, ok := result["mfa_methods"].([]interface{}); ok {
+		for _, method := range methods {
+			if name, ok := method.(string); ok {
+				challenge.Methods = append(challenge.Methods, name)
+			}
+		}
+	}
+	return challenge
+}
+
+// offers returns true if the challenge accepts method
+func (c *mfaChallenge) offers(method string) bool {
+	for _, m := range c.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// answerMFA asks the user for a second factor and returns the login
+// arguments that answer the challenge. A one-time code is passed to the
+// runtime in its environment.
+func answerMFA(pb *bridge.PythonBridge, challenge *mfaChallenge) ([]string, error) {
+	totp := challenge.offers(mfaTOTP)
+	webAuthn := challenge.offers(mfaWebAuthn) && challenge.WebAuthnURL != ""
+	if !totp && !webAuthn {
+		return nil, clierr.New(clierr.CategoryAuth, "MFA_UNSUPPORTED", fmt.Sprintf("login requires a second factor this CLI does not support (%s)", strings.Join(challenge.Methods, ", "))).
+			WithHint("Use 'upid auth login --sso' to sign in in the browser")
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		hint := "Run the login in a terminal"
+		if totp {
+			hint = "Pass the code from your authenticator app with --totp-code or --totp-code-stdin"
+		}
+		return nil, clierr.New(clierr.CategoryAuth, "MFA_REQUIRED", "login requires a second factor and no terminal is available to ask for it").
+			WithHint(hint)
+	}
+	if challenge.Message != "" {
+		fmt.Fprintln(os.Stderr, challenge.Message)
+	}
+
+	args := []string{"--mfa-challenge", challenge.ID}
+	if totp {
+		prompt := "Authentication code: "
+		if webAuthn {
+			prompt = "Authentication code (leave empty to use a security key): "
+		}
+		fmt.Fprint(os.Stderr, prompt)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return nil, fmt.Errorf("failed to read authentication code: %v", err)
+		}
+		if code := strings.ReplaceAll(strings.TrimSpace(line), " ", ""); code != "" || !webAuthn {
+			pb.SetEnv(mfaCodeEnv, code)
+			return append(args, "--totp-code-env", mfaCodeEnv), nil
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Confirm the login with your security key in the browser. If it does not open, visit:\n\n  %s\n\n", challenge.WebAuthnURL)
+	if err := oidc.OpenBrowser(challenge.WebAuthnURL); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open the browser: %v\n", err)
+	}
+	return append(args, "--mfa-method", mfaWebAuthn), nil
+}
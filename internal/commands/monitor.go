@@ -1,6 +1,19 @@
 package commands
 
 import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/budget"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
 	"github.com/spf13/cobra"
 )
 
@@ -20,6 +33,10 @@ func MonitorCmd() *cobra.Command {
 	monitorCmd.AddCommand(monitorStopCmd())
 	monitorCmd.AddCommand(monitorStatusCmd())
 	monitorCmd.AddCommand(monitorAlertsCmd())
+	monitorCmd.AddCommand(monitorSilenceCmd())
+	monitorCmd.AddCommand(monitorWatchCmd())
+	monitorCmd.AddCommand(monitorServeCmd())
+	monitorCmd.AddCommand(monitorChannelsCmd())
 
 	return monitorCmd
 }
@@ -37,10 +54,10 @@ func monitorStartCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("namespace", "n", "", "namespace to monitor")
-	cmd.Flags().BoolP("daemon", "d", false, "run as daemon")
+	cmd.Flags().Bool("daemon", false, "run as daemon")
 	cmd.Flags().StringP("interval", "i", "30s", "monitoring interval")
 
-	return cmd
+	return mutating(cmd)
 }
 
 // monitorStopCmd creates the stop monitoring command
@@ -54,7 +71,7 @@ func monitorStopCmd() *cobra.Command {
 		},
 	}
 
-	return cmd
+	return mutating(cmd)
 }
 
 // monitorStatusCmd creates the status command
@@ -74,24 +91,71 @@ func monitorStatusCmd() *cobra.Command {
 // monitorAlertsCmd creates the alerts command
 func monitorAlertsCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "alerts [cluster-name]",
-		Short: "View monitoring alerts",
-		Long:  "View current and historical monitoring alerts",
+		Use:   "alerts [context]",
+		Short: "View open alerts and silences",
+		Long: `View the alerts that are open until their condition clears, of all
+contexts or of the context given, with whether they fire, are acknowledged
+with 'upid monitor alerts ack' or are held back by a silence, and the
+silences that are active or pending.`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return monitorAlerts(cmd, args)
 		},
 	}
 
 	// Add flags
-	cmd.Flags().StringP("time-range", "t", "24h", "time range for alerts")
-	cmd.Flags().StringP("severity", "s", "", "filter by severity")
+	cmd.Flags().StringP("time-range", "t", "", "only alerts opened within the time range, e.g. 24h")
+	cmd.Flags().StringP("severity", "s", "", "minimum severity of the alerts (info, warning, critical)")
+
+	// Add subcommands
+	cmd.AddCommand(monitorAlertsAckCmd())
+
+	return cmd
+}
+
+// watchNotifyQueue bounds the alerts of a watch waiting to be posted to
+// notification channels
+const watchNotifyQueue = 100
+
+// monitorWatchCmd creates the watch command
+func monitorWatchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Stream cluster events live",
+		Long: `Stream pod restarts, OOM kills, evictions and workload scaling as they
+happen, until interrupted. Events are read directly from the Kubernetes API
+and do not need the Python runtime. With --output json, each event is
+printed as one JSON object per line.
+
+The budgets of the cluster, see 'upid cost budget', are evaluated every
+--budget-interval, and the alerts they fire are printed as Budget events:
+warnings for thresholds under 100% and forecasts, critical once spent.
+Events are posted as alerts to the notification channels whose minimum
+severity they reach, see 'upid monitor channels'.
+
+Examples:
+  upid monitor watch                          # Watch all namespaces
+  upid monitor watch -n shop -s warning       # Only warnings and worse in shop
+  upid monitor watch -o json | jq .message    # Process events as JSON
+  upid monitor watch --budget-interval 0      # Do not evaluate budgets`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return monitorWatch(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", "", "namespace to watch (default all namespaces)")
+	cmd.Flags().StringP("severity", "s", "", "minimum severity to show (info, warning or critical)")
+	cmd.Flags().StringP("context", "x", "", "kubernetes context (default current context)")
+	cmd.Flags().String("budget-interval", "15m", "how often the budgets of the cluster are evaluated (0 to not evaluate them)")
 
 	return cmd
 }
 
 // Implementation functions
 func monitorStart(cmd *cobra.Command, args []string) error {
-	clusterName := "default"
+	clusterName := config.GetDefaultCluster()
 	if len(args) > 0 {
 		clusterName = args[0]
 	}
@@ -113,45 +177,171 @@ func monitorStart(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--interval", interval)
 	}
 
-	return executePythonCommand("monitor", cmdArgs)
+	if !daemon {
+		// Foreground monitoring runs until interrupted, show updates as they come
+		return streamPythonCommand(cmd.Context(), "monitor", cmdArgs)
+	}
+	return executePythonCommand(cmd.Context(), "monitor", cmdArgs)
 }
 
 func monitorStop(cmd *cobra.Command, args []string) error {
-	clusterName := "default"
+	clusterName := config.GetDefaultCluster()
 	if len(args) > 0 {
 		clusterName = args[0]
 	}
 
-	return executePythonCommand("monitor", []string{"stop", clusterName})
+	return executePythonCommand(cmd.Context(), "monitor", []string{"stop", clusterName})
 }
 
 func monitorStatus(cmd *cobra.Command, args []string) error {
-	clusterName := "default"
+	clusterName := config.GetDefaultCluster()
 	if len(args) > 0 {
 		clusterName = args[0]
 	}
 
-	return executePythonCommand("monitor", []string{"status", clusterName})
+	return executePythonCommand(cmd.Context(), "monitor", []string{"status", clusterName})
 }
 
-func monitorAlerts(cmd *cobra.Command, args []string) error {
-	clusterName := "default"
-	if len(args) > 0 {
-		clusterName = args[0]
-	}
-
+func monitorWatch(cmd *cobra.Command, args []string) error {
 	// Get flags
-	timeRange, _ := cmd.Flags().GetString("time-range")
+	namespace, _ := cmd.Flags().GetString("namespace")
 	severity, _ := cmd.Flags().GetString("severity")
+	kubeContext, _ := cmd.Flags().GetString("context")
+	budgetIntervalFlag, _ := cmd.Flags().GetString("budget-interval")
 
-	// Build arguments
-	cmdArgs := []string{"alerts", clusterName}
-	if timeRange != "" {
-		cmdArgs = append(cmdArgs, "--time-range", timeRange)
+	severity = strings.ToLower(severity)
+	if severity != "" && kube.SeverityRank(severity) < 0 {
+		return fmt.Errorf("invalid severity %q (expected %s)", severity, strings.Join(kube.Severities, ", "))
+	}
+	format := config.GetOutputFormat()
+	if format != output.FormatTable && format != output.FormatWide && format != output.FormatJSON {
+		return fmt.Errorf("monitor watch supports table, wide and json output, not %q", format)
+	}
+	budgetInterval, err := timeutil.ParseDuration(budgetIntervalFlag)
+	if err != nil || budgetInterval < 0 {
+		return fmt.Errorf("invalid --budget-interval %q (expected a duration such as 15m)", budgetIntervalFlag)
+	}
+
+	client, err := kube.NewClient(kubeContext)
+	if err != nil {
+		return err
+	}
+	if quiet, _ := activeFlagBool("quiet"); !quiet {
+		scope := "all namespaces"
+		if namespace != "" {
+			scope = "namespace " + namespace
+		}
+		fmt.Fprintf(os.Stderr, "Watching %s in context %s, press Ctrl+C to stop\n", scope, client.Context)
+	}
+
+	// Budget alerts are printed between the changes of the watch, and
+	// posted to notification channels in the background so that slow
+	// channels do not hold up the watch
+	var mu sync.Mutex
+	printRow := printChange(format)
+	alerts := make(chan kube.Change, watchNotifyQueue)
+	go func() {
+		for change := range alerts {
+			notifyEvent(cmd.Context(), changeEvent(client.Context, change))
+		}
+	}()
+	emit := func(change kube.Change) {
+		mu.Lock()
+		defer mu.Unlock()
+		printRow(change)
+		select {
+		case alerts <- change:
+		default:
+			slog.Warn("notification channels are behind, dropped an alert", "reason", change.Reason, "object", change.Kind+"/"+change.Name)
+		}
+	}
+	if budgetInterval > 0 {
+		go watchBudgets(cmd, client.Context, budgetInterval, func(change kube.Change) {
+			if kube.SeverityRank(change.Severity) >= kube.SeverityRank(severity) &&
+				(namespace == "" || change.Namespace == namespace) {
+				emit(change)
+			}
+		})
+	}
+	return client.Watch(cmd.Context(), kube.WatchOptions{Namespace: namespace, MinSeverity: severity}, emit)
+}
+
+// watchBudgets evaluates the budgets of a cluster every interval until the
+// command is done, and emits the alerts they fire
+func watchBudgets(cmd *cobra.Command, kubeContext string, interval time.Duration, emit func(kube.Change)) {
+	ctx := cmd.Context()
+	prices, err := computePrices(cmd)
+	if err != nil {
+		slog.Warn("budgets are not evaluated", "error", err)
+		return
 	}
-	if severity != "" {
-		cmdArgs = append(cmdArgs, "--severity", severity)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		store, err := budget.Load(budgetFile())
+		if err != nil {
+			slog.Warn("failed to read budgets", "error", err)
+		} else if len(store.Budgets) > 0 {
+			alerts, _, warnings, err := evaluateBudgets(ctx, prices, func(b *budget.Budget) bool { return b.Context == kubeContext })
+			if err != nil && ctx.Err() == nil {
+				slog.Warn("failed to evaluate budgets", "error", err)
+			}
+			for _, warning := range warnings {
+				slog.Warn("failed to evaluate budgets", "error", warning)
+			}
+			for _, alert := range alerts {
+				emit(budgetChange(alert))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
+}
 
-	return executePythonCommand("monitor", cmdArgs)
-} 
\ No newline at end of file
+// budgetChange reports an alert of a budget as a change of the cluster
+func budgetChange(alert budget.Alert) kube.Change {
+	change := kube.Change{
+		Time:     alert.Time,
+		Severity: budgetAlertSeverity(alert),
+		Reason:   "Budget",
+		Kind:     "Budget",
+		Name:     alert.Budget,
+		Message:  alert.Message(),
+	}
+	if scope, err := budget.ParseScope(alert.Scope); err == nil && scope.Kind == budget.ScopeNamespace {
+		change.Namespace = scope.Value
+	}
+	return change
+}
+
+// printChange returns a function that prints changes as they are watched,
+// as JSON lines or as rows under a table header printed with the first row
+func printChange(format string) func(kube.Change) {
+	if format == output.FormatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		return func(change kube.Change) {
+			_ = encoder.Encode(change)
+		}
+	}
+
+	color := output.ColorEnabled(config.IsNoColor(), os.Stdout)
+	// The severity is padded before it is colored, which would otherwise
+	// count the color codes as width
+	const row = "%-8s  %s  %-9s  %-16s  %-32s  %s\n"
+	header := false
+	return func(change kube.Change) {
+		if !header {
+			fmt.Printf(row, "TIME", fmt.Sprintf("%-8s", "SEVERITY"), "REASON", "NAMESPACE", "OBJECT", "MESSAGE")
+			header = true
+		}
+		severity := fmt.Sprintf("%-8s", change.Severity)
+		if color {
+			severity = output.ColorizeSeverity(severity, change.Severity)
+		}
+		fmt.Printf(row, change.Time.Format("15:04:05"), severity, change.Reason, change.Namespace,
+			change.Kind+"/"+change.Name, change.Message)
+	}
+}
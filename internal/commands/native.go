@@ -0,0 +1,222 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/output"
+)
+
+// runtimeMissing returns true if no Python runtime is installed, in which
+// case commands with a built-in implementation fall back to it
+func runtimeMissing() bool {
+	return getBridge().Runtime() == nil
+}
+
+// executeNative runs the built-in Go implementation of a command and renders
+// its result like executePythonCommand. The runtime arguments only key the
+// result cache.
+func executeNative(ctx context.Context, command string, args []string, run func(ctx context.Context) (map[string]interface{}, error)) error {
+	slog.Info("python runtime not found, using built-in implementation", "command", command)
+	if quiet, _ := activeFlagBool("quiet"); !quiet {
+		fmt.Fprintln(os.Stderr, "Python runtime not found, using the built-in implementation (install the full runtime with 'upid system runtime install')")
+	}
+
+	store, key := resultCache("native:"+command, args)
+	if result, ok := loadCachedResult(store, key); ok {
+		if err := renderBuiltinResult(result); err != nil {
+			return err
+		}
+		printResultWarning(result)
+		return partialResultError(result)
+	}
+
+	result, err := run(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to execute %s command: %w", command, err)
+	}
+	storeCachedResult(store, key, result)
+
+	if err := renderBuiltinResult(result); err != nil {
+		return err
+	}
+	printResultWarning(result)
+	return partialResultError(result)
+}
+
+// executeBuiltin runs a command that is always implemented in Go, whether
+// or not the runtime is installed, and renders its result
+func executeBuiltin(ctx context.Context, command string, run func(ctx context.Context) (map[string]interface{}, error)) error {
+	result, err := run(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to execute %s command: %w", command, err)
+	}
+
+	if err := renderBuiltinResult(result); err != nil {
+		return err
+	}
+	printResultWarning(result)
+	return partialResultError(result)
+}
+
+// renderBuiltinResult renders the result of a built-in implementation. In
+// tables, the workloads an optimization left alone because they are
+// excluded or blocked by guardrails follow the records it acted on.
+func renderBuiltinResult(result map[string]interface{}) error {
+	sections := []section{{"excluded", excludedColumns}, {"blocked", blockedColumns}}
+	excluded, _ := result["excluded"].([]interface{})
+	blocked, _ := result["blocked"].([]interface{})
+	opts := renderOptions()
+	if len(excluded)+len(blocked) == 0 || opts.Quiet || (opts.Format != output.FormatTable && opts.Format != output.FormatWide && opts.Format != "") {
+		return renderResult(result)
+	}
+	rest := make(map[string]interface{}, len(result))
+	for key, value := range result {
+		if key != "excluded" && key != "blocked" {
+			rest[key] = value
+		}
+	}
+	return output.WithPager(usePager(), func(w io.Writer) error {
+		if err := output.Render(w, rest, opts); err != nil {
+			return err
+		}
+		opts.Select, opts.SortBy = nil, ""
+		for _, section := range sections {
+			list, _ := result[section.key].([]interface{})
+			if len(list) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "\n%s:\n", strings.ToUpper(section.key))
+			opts.Columns = section.columns
+			if err := output.Render(w, list, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// printResultWarning shows the warning of a degraded result, which table
+// output would not show
+func printResultWarning(result map[string]interface{}) {
+	warning, _ := result["warning"].(string)
+	if warning == "" {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	if hint, _ := result["hint"].(string); hint != "" {
+		fmt.Fprintf(os.Stderr, "Hint: %s\n", hint)
+	}
+}
+
+// nativeClient connects to the cluster of the current kubeconfig context.
+// With a configured datasource, analyses use its usage history over the
+// returned window of timeRange.
+func nativeClient(timeRange string) (*native.Client, time.Duration, error) {
+	return nativeClientFor("", timeRange)
+}
+
+// nativeClientFor is nativeClient for a kubeconfig context, the current one
+// if empty
+func nativeClientFor(kubeContext, timeRange string) (*native.Client, time.Duration, error) {
+	client, err := native.NewClient(kubeContext)
+	if err != nil {
+		return nil, 0, err
+	}
+	history, err := datasource()
+	if err != nil {
+		return nil, 0, err
+	}
+	window, err := historyWindow(history, timeRange)
+	if err != nil {
+		return nil, 0, err
+	}
+	if history != nil {
+		client.SetHistory(history)
+	}
+	excluded, err := exclusions()
+	if err != nil {
+		return nil, 0, err
+	}
+	client.SetExclusions(excluded)
+	rails, err := guardrails("")
+	if err != nil {
+		return nil, 0, err
+	}
+	client.SetGuardrails(rails)
+	return client, window, nil
+}
+
+// exclusions returns the workloads the config file excludes from
+// optimizations
+func exclusions() (*kube.Exclusions, error) {
+	settings := config.GetExcludeConfig()
+	return kube.ParseExclusions(settings.Namespaces, settings.Selector, settings.Workloads)
+}
+
+// guardrails returns the guardrails the config file sets for optimizations,
+// overridden for a reason if not empty
+func guardrails(override string) (*native.Guardrails, error) {
+	settings := config.GetGuardrailsConfig()
+	hours, err := kube.ParseWindows(settings.BusinessHours)
+	if err != nil {
+		return nil, fmt.Errorf("invalid guardrails.business_hours: %v", err)
+	}
+	rails := &native.Guardrails{
+		MinReplicas:   int32(settings.MinReplicas),
+		MaxReduction:  float64(settings.MaxReduction) / 100,
+		BusinessHours: hours,
+		Timezone:      settings.Timezone,
+		PDB:           settings.PDB,
+		HPA:           settings.HPA,
+		Override:      override,
+		AuditPath:     filepath.Join(config.GetStateDir(), "audit.jsonl"),
+	}
+	for _, pattern := range strings.Split(settings.ProtectedNamespaces, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			rails.ProtectedNamespaces = append(rails.ProtectedNamespaces, pattern)
+		}
+	}
+	return rails, nil
+}
+
+// guardBusinessHours blocks changes made through the runtime during the
+// business hours of the guardrails, unless override gives the reason to make
+// them anyway, which is audited for each action
+func guardBusinessHours(override string, actions ...string) error {
+	rails, err := guardrails(override)
+	if err != nil {
+		return err
+	}
+	violation, err := rails.DuringBusinessHours(time.Now())
+	if err != nil || violation == nil {
+		return err
+	}
+	if override == "" {
+		return clierr.New(clierr.CategoryUsage, "GUARDRAIL_VIOLATION", "blocked by the business-hours guardrail: "+violation.Message).
+			WithHint("Apply it outside of guardrails.business_hours, or pass --override <reason> to apply it anyway, which is audited")
+	}
+	for _, action := range actions {
+		slog.Warn("guardrails overridden", "action", action, "reason", override)
+		if err := rails.Audit(native.AuditEntry{Action: action, Violations: []native.Violation{*violation}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requiresRuntime reports that a command has no built-in implementation
+func requiresRuntime(action string) error {
+	return clierr.New(clierr.CategoryBridge, "RUNTIME_MISSING", action+" requires the Python runtime").
+		WithHint("Install the runtime with 'upid system runtime install'")
+}
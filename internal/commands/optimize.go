@@ -1,8 +1,18 @@
 package commands
 
 import (
+	"context"
 	"fmt"
-
+	"path/filepath"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/gitops"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/notify"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
 	"github.com/spf13/cobra"
 )
 
@@ -17,7 +27,11 @@ Examples:
   upid optimize resources                    # Get resource optimization recommendations
   upid optimize zero-pod --dry-run         # Simulate zero-pod scaling
   upid optimize cost --time-range 30d      # Optimize costs
-  upid optimize apply --recommendation-id 123 # Apply optimization`,
+  upid optimize rightsize -n shop          # Right-size requests and limits
+  upid optimize purchasing                 # Spot and commitment purchases
+  upid optimize apply rec-123              # Apply a recommendation
+  upid optimize review                     # Review recommendations one by one
+  upid optimize approvals list             # Changes awaiting approval`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return optimizeResources(cmd, args)
 		},
@@ -28,8 +42,12 @@ Examples:
 	optimizeCmd.AddCommand(optimizeZeroPodCmd())
 	optimizeCmd.AddCommand(optimizeCostCmd())
 	optimizeCmd.AddCommand(optimizeApplyCmd())
+	optimizeCmd.AddCommand(optimizeReviewCmd())
 	optimizeCmd.AddCommand(optimizePreviewCmd())
 	optimizeCmd.AddCommand(optimizeScheduleCmd())
+	optimizeCmd.AddCommand(optimizeRightsizeCmd())
+	optimizeCmd.AddCommand(optimizeApprovalsCmd())
+	optimizeCmd.AddCommand(optimizePurchasingCmd())
 
 	return optimizeCmd
 }
@@ -39,7 +57,19 @@ func optimizeResourcesCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "resources [cluster-name]",
 		Short: "Get resource optimization recommendations",
-		Long:  "Get ML-powered recommendations for resource optimization",
+		Long: `Get ML-powered recommendations for resource optimization.
+
+With --export-manifests, requests and limits are instead recommended built
+in, as by 'upid optimize rightsize' with its defaults, and for each workload
+to resize a patch and the updated manifest are written to the directory, as
+<namespace>/<kind>-<name>.patch.yaml (or .patch.json with --patch-type json)
+and <namespace>/<kind>-<name>.yaml. They can be reviewed and applied through
+existing pipelines, or with 'kubectl patch --patch-file'.
+
+Examples:
+  upid optimize resources -n shop                               # Recommendations
+  upid optimize resources --export-manifests ./patches          # Strategic merge patches
+  upid optimize resources --export-manifests ./patches --patch-type json`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return optimizeResources(cmd, args)
 		},
@@ -47,8 +77,11 @@ func optimizeResourcesCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("namespace", "n", "", "namespace to optimize")
-	cmd.Flags().BoolP("detailed", "d", false, "detailed recommendations")
-	cmd.Flags().BoolP("include-costs", "c", false, "include cost analysis")
+	cmd.Flags().Bool("detailed", false, "detailed recommendations")
+	cmd.Flags().Bool("include-costs", false, "include cost analysis")
+	cmd.Flags().String("export-manifests", "", "directory to write patches and updated manifests of the recommendations to")
+	cmd.Flags().String("patch-type", native.PatchStrategic, "type of the exported patches: strategic or json")
+	cmd.Flags().StringP("time-range", "t", "7d", "time range of usage to size to with --export-manifests")
 
 	return cmd
 }
@@ -58,18 +91,51 @@ func optimizeZeroPodCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "zero-pod [namespace]",
 		Short: "Zero-pod scaling optimization",
-		Long:  "Scale idle pods to zero with safety guarantees",
+		Long: `Scale idle pods to zero with safety guarantees.
+
+Without --apply, the workloads that would be scaled are only listed.
+--apply scales the Deployments and StatefulSets whose replicas are all idle
+to zero through the scale subresource. Their previous replicas are stored in
+the upid.io/zero-pod-replicas annotation and in zero-pod.json in the state
+directory, and --rollback restores them. With --auto-rollback, a failure
+restores the workloads scaled so far.
+
+Workloads annotated upid.io/exclude: "true", on themselves or their pods, or
+matched by exclude.namespaces, exclude.selector or exclude.workloads in the
+config file are never scaled, and are listed as excluded.
+
+Scaling must also pass the guardrails set in the config file:
+guardrails.min_replicas, guardrails.max_reduction (in percent),
+guardrails.protected_namespaces, guardrails.business_hours (e.g. Mon-Fri
+09:00-18:00, in guardrails.timezone), and unless disabled, the
+PodDisruptionBudgets and HorizontalPodAutoscalers of the workloads
+(guardrails.pdb and guardrails.hpa). Workloads failing one are listed as
+blocked and left alone, unless --override gives the reason to scale them
+anyway, which is appended to audit.jsonl in the state directory.
+
+Examples:
+  upid optimize zero-pod staging                    # List idle workloads
+  upid optimize zero-pod staging --apply            # Scale them to zero
+  upid optimize zero-pod staging --rollback         # Restore their replicas
+  upid optimize zero-pod staging --rollback --workload deployment/web
+  upid optimize zero-pod staging --apply --override "load test cleanup, INC-4211"`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return optimizeZeroPod(cmd, args)
 		},
 	}
 
 	// Add flags
-	cmd.Flags().BoolP("dry-run", "d", true, "simulate optimization without applying")
-	cmd.Flags().Float64P("confidence", "c", 0.90, "confidence threshold")
+	cmd.Flags().Bool("dry-run", true, "simulate optimization without applying")
+	cmd.Flags().Float64("confidence", 0.90, "confidence threshold")
 	cmd.Flags().BoolP("auto-rollback", "r", true, "enable automatic rollback")
-
-	return cmd
+	cmd.Flags().StringP("time-range", "t", "7d", "time range pods must have been idle for (with a datasource)")
+	cmd.Flags().Bool("apply", false, "scale the idle workloads to zero")
+	cmd.Flags().Bool("rollback", false, "restore the replicas of workloads scaled to zero")
+	cmd.Flags().StringSlice("workload", nil, "workloads to restore with --rollback, as name or kind/name")
+	cmd.Flags().String("override", "", "scale workloads that fail guardrails, for this reason (audited)")
+	cmd.MarkFlagsMutuallyExclusive("apply", "rollback")
+
+	return mutatingWithNativeDryRun(cmd)
 }
 
 // optimizeCostCmd creates the cost optimization command
@@ -85,7 +151,7 @@ func optimizeCostCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("time-range", "t", "30d", "time range for analysis")
-	cmd.Flags().BoolP("detailed", "d", false, "detailed cost breakdown")
+	cmd.Flags().Bool("detailed", false, "detailed cost breakdown")
 	cmd.Flags().BoolP("include-forecasts", "f", false, "include cost forecasts")
 
 	return cmd
@@ -94,10 +160,53 @@ func optimizeCostCmd() *cobra.Command {
 // optimizeApplyCmd creates the apply optimization command
 func optimizeApplyCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "apply [recommendation-id]",
-		Short: "Apply optimization recommendation",
-		Long:  "Apply a specific optimization recommendation",
-		Args:  cobra.ExactArgs(1),
+		Use:   "apply [recommendation-id...]",
+		Short: "Apply optimization recommendations",
+		Long: `Apply optimization recommendations.
+
+Several recommendations are applied at once by giving several IDs, by
+reading IDs from a file with --from-file (one per line, '-' for standard
+input), or by selecting with --all every recommendation of 'upid optimize
+resources', those saving at least --min-savings a month only if set. Up to
+--concurrency recommendations are applied at a time; after a single
+confirmation unless --confirm is set. The status of every recommendation
+and a summary are shown. If some fail, the others are still applied and the
+command exits with the partial results status. During the business hours of
+the guardrails (guardrails.business_hours in the config file), nothing is
+applied unless --override gives a reason, which is audited.
+
+With --via-pr, the right-sizing recommendations of 'upid optimize rightsize'
+(with its defaults) are instead proposed through the Git repository the
+workloads are deployed from. The repository is cloned with git, the
+manifests of the workloads are located by kind, name and namespace, or for
+workloads installed with Helm the values of their chart, and the new
+requests and limits are committed on a branch. The branch is pushed and a
+pull request (a merge request on GitLab) is opened with the savings in its
+description. The API token is read from $GITHUB_TOKEN or $GH_TOKEN, or
+$GITLAB_TOKEN. With --dry-run, the diff is shown and nothing is pushed.
+Workloads whose new requests fail the guardrails, such as
+guardrails.max_reduction or a conflicting HorizontalPodAutoscaler, are left
+out and listed as blocked, unless --override is given.
+
+Examples:
+  upid optimize apply rec-123                                   # Apply a recommendation
+  upid optimize apply rec-123 rec-124 rec-125 --confirm
+  upid optimize apply --all --min-savings 50                    # Everything saving $50 a month
+  upid optimize resources -q > ids.txt && upid optimize apply --from-file ids.txt
+  upid optimize apply --via-pr --repo git@github.com:acme/deploy.git
+  upid optimize apply --via-pr --repo git@gitlab.com:acme/deploy.git --path apps/shop -n shop
+  upid optimize apply --via-pr --repo git@github.com:acme/deploy.git --dry-run`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if viaPR, _ := cmd.Flags().GetBool("via-pr"); viaPR {
+				return cobra.NoArgs(cmd, args)
+			}
+			all, _ := cmd.Flags().GetBool("all")
+			fromFile, _ := cmd.Flags().GetString("from-file")
+			if all || fromFile != "" {
+				return nil
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return optimizeApply(cmd, args)
 		},
@@ -105,9 +214,26 @@ func optimizeApplyCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().BoolP("confirm", "y", false, "skip confirmation prompt")
-	cmd.Flags().BoolP("dry-run", "d", false, "simulate application")
-
-	return cmd
+	cmd.Flags().Bool("dry-run", false, "simulate application")
+	cmd.Flags().Bool("all", false, "apply all recommendations of 'upid optimize resources'")
+	cmd.Flags().Float64("min-savings", 0, "with --all, apply only recommendations saving at least this much a month")
+	cmd.Flags().String("from-file", "", "file with the IDs of the recommendations to apply, one per line ('-' for standard input)")
+	cmd.Flags().Int("concurrency", 4, "number of recommendations applied at a time")
+	cmd.Flags().String("override", "", "apply changes that fail guardrails, for this reason (audited)")
+	cmd.Flags().Bool("via-pr", false, "propose the right-sizing recommendations as a pull request")
+	cmd.Flags().String("repo", "", "clone URL of the repository the workloads are deployed from")
+	cmd.Flags().String("path", "", "directory of the repository with the manifests or charts (default all of it)")
+	cmd.Flags().String("base", "", "branch to open the pull request against (default the default branch)")
+	cmd.Flags().String("branch", "", "branch to push the changes to (default upid/rightsize-<time>)")
+	cmd.Flags().String("provider", "", "github or gitlab (default guessed from the repository host)")
+	cmd.Flags().String("api-url", "", "API URL of a GitHub Enterprise or self-managed GitLab server")
+	cmd.Flags().StringP("namespace", "n", "", "namespace to right-size with --via-pr (default all namespaces)")
+	cmd.Flags().StringP("time-range", "t", "7d", "time range of usage to size to with --via-pr")
+	cmd.MarkFlagsRequiredTogether("via-pr", "repo")
+	cmd.MarkFlagsMutuallyExclusive("via-pr", "all")
+	cmd.MarkFlagsMutuallyExclusive("via-pr", "from-file")
+
+	return mutatingWithNativeDryRun(cmd)
 }
 
 // optimizePreviewCmd creates the preview optimization command
@@ -123,32 +249,210 @@ func optimizePreviewCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("namespace", "n", "", "namespace to preview")
-	cmd.Flags().BoolP("detailed", "d", false, "detailed preview")
+	cmd.Flags().Bool("detailed", false, "detailed preview")
 
 	return cmd
 }
 
-// optimizeScheduleCmd creates the schedule optimization command
-func optimizeScheduleCmd() *cobra.Command {
+// rightsizeColumns are the table columns for right-sizing recommendations
+var rightsizeColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "kind", Field: "kind", Wide: true},
+	{Name: "container", Field: "container"},
+	{Name: "pods", Field: "pods", Wide: true},
+	{Name: "action", Field: "action"},
+	{Name: "cpu-request", Header: "CPU REQUEST", Field: "cpu_request"},
+	{Name: "recommended-cpu-request", Header: "RECOMMENDED", Field: "recommended_cpu_request"},
+	{Name: "cpu-limit", Header: "CPU LIMIT", Field: "cpu_limit", Wide: true},
+	{Name: "recommended-cpu-limit", Header: "RECOMMENDED", Field: "recommended_cpu_limit", Wide: true},
+	{Name: "memory-request", Header: "MEMORY REQUEST", Field: "memory_request"},
+	{Name: "recommended-memory-request", Header: "RECOMMENDED", Field: "recommended_memory_request"},
+	{Name: "memory-limit", Header: "MEMORY LIMIT", Field: "memory_limit"},
+	{Name: "recommended-memory-limit", Header: "RECOMMENDED", Field: "recommended_memory_limit"},
+	{Name: "savings", Header: "MONTHLY SAVINGS", Field: "monthly_savings"},
+}
+
+// manifestColumns are the table columns for exported patches and manifests
+var manifestColumns = []output.Column{
+	{Name: "namespace", Field: "namespace"},
+	{Name: "kind", Field: "kind"},
+	{Name: "name", Field: "name"},
+	{Name: "containers", Field: "containers", Wide: true},
+	{Name: "patch", Field: "patch"},
+	{Name: "manifest", Field: "manifest", Wide: true},
+}
+
+// excludedColumns are the table columns for workloads excluded from
+// optimizations
+var excludedColumns = []output.Column{
+	{Name: "namespace", Field: "namespace"},
+	{Name: "kind", Field: "kind"},
+	{Name: "name", Field: "name"},
+	{Name: "reason", Field: "reason"},
+}
+
+// blockedColumns are the table columns for workloads whose changes fail
+// guardrails
+var blockedColumns = []output.Column{
+	{Name: "namespace", Field: "namespace"},
+	{Name: "kind", Field: "kind"},
+	{Name: "name", Field: "name"},
+	{Name: "guardrails", Field: "guardrails"},
+	{Name: "reason", Field: "reason"},
+}
+
+// changeColumns are the table columns for files changed in a repository
+var changeColumns = []output.Column{
+	{Name: "namespace", Field: "namespace"},
+	{Name: "kind", Field: "kind"},
+	{Name: "name", Field: "name"},
+	{Name: "file", Field: "file"},
+	{Name: "source", Field: "source", Wide: true},
+}
+
+// optimizeRightsizeCmd creates the right-sizing command
+func optimizeRightsizeCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "schedule [cron-expression]",
-		Short: "Schedule automated optimizations",
-		Long:  "Schedule automated optimization runs",
+		Use:   "rightsize",
+		Short: "Recommend requests and limits from usage history",
+		Long: `Recommend requests and limits for every container of the running workloads
+from their usage over --time-range at the configured datasource.
+
+Requests fit the --percentile of the CPU and memory usage of the busiest pod
+plus --headroom. Memory limits fit the peak plus --oom-buffer, or the current
+limit plus the buffer for containers that were OOM killed. CPU limits are
+only recommended where set, at the peak plus headroom. Savings are those of
+the requests of all pods at --cpu-price and --memory-price, per month,
+which default to the pricing model in config.
+
+With --export-manifests, a patch and the updated manifest of each workload
+to resize are written to the directory for review, as with
+'upid optimize resources --export-manifests'.
+
+Workloads excluded from optimizations, by the upid.io/exclude: "true"
+annotation or the exclude.* settings of the config file, are not sized and
+are listed as excluded.
+
+Examples:
+  upid optimize rightsize                                 # All namespaces, p95
+  upid optimize rightsize -n shop --percentile p99        # Size to p99
+  upid optimize rightsize --headroom 30 --oom-buffer 50   # More margin
+  upid optimize rightsize --export-manifests ./patches    # Write patches`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return optimizeSchedule(cmd, args)
+			return optimizeRightsize(cmd, args)
 		},
 	}
 
 	// Add flags
-	cmd.Flags().StringP("cluster", "c", "", "cluster to schedule for")
-	cmd.Flags().BoolP("enabled", "e", true, "enable the schedule")
+	cmd.Flags().StringP("namespace", "n", "", "namespace to right-size (default all namespaces)")
+	cmd.Flags().StringP("time-range", "t", "7d", "time range of usage to size to")
+	cmd.Flags().String("percentile", "p95", "usage percentile requests fit: p90, p95 or p99")
+	cmd.Flags().Float64("headroom", 20, "margin over the percentile for requests, in percent")
+	cmd.Flags().Float64("oom-buffer", 25, "margin over the peak memory for memory limits, in percent")
+	cmd.Flags().Float64("cpu-price", 0, "price per requested core-hour (default pricing.cpu)")
+	cmd.Flags().Float64("memory-price", 0, "price per requested GiB-hour (default pricing.memory)")
+	cmd.Flags().String("export-manifests", "", "directory to write patches and updated manifests of the recommendations to")
+	cmd.Flags().String("patch-type", native.PatchStrategic, "type of the exported patches: strategic or json")
+
+	return withColumns(cmd, rightsizeColumns)
+}
 
-	return cmd
+// purchasingColumns are the table columns for purchasing recommendations
+var purchasingColumns = []output.Column{
+	{Name: "pool", Field: "pool"},
+	{Name: "purchase", Field: "purchase"},
+	{Name: "nodes", Field: "nodes"},
+	{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+	{Name: "savings", Header: "MONTHLY SAVINGS", Field: "monthly_savings"},
+	{Name: "commitment", Header: "HOURLY COMMITMENT", Field: "hourly_commitment", Wide: true},
+	{Name: "break-even", Header: "BREAK-EVEN MONTHS", Field: "break_even_months"},
+	{Name: "risk", Field: "risk"},
+	{Name: "reason", Field: "reason", Wide: true},
+}
+
+// purchasingPoolColumns are the table columns for the node pools of
+// purchasing recommendations
+var purchasingPoolColumns = []output.Column{
+	{Name: "pool", Field: "pool"},
+	{Name: "nodes", Field: "nodes"},
+	{Name: "spot", Header: "SPOT", Field: "spot_nodes"},
+	{Name: "steady", Header: "STEADY", Field: "steady_nodes"},
+	{Name: "cpu", Header: "CPU REQUESTED %", Field: "cpu_requested", Wide: true},
+	{Name: "memory", Header: "MEMORY REQUESTED %", Field: "memory_requested", Wide: true},
+	{Name: "on-demand", Header: "ON-DEMAND COST", Field: "on_demand_cost"},
+	{Name: "steady-cost", Header: "STEADY COST", Field: "steady_cost"},
+	{Name: "risk", Header: "RISK", Field: "interruption_risk"},
+}
+
+// interruptionColumns are the table columns for the risk of workloads
+// tolerating interruption
+var interruptionColumns = []output.Column{
+	{Name: "namespace", Field: "namespace"},
+	{Name: "kind", Field: "kind"},
+	{Name: "name", Field: "name"},
+	{Name: "pods", Field: "pods"},
+	{Name: "pools", Field: "pools", Wide: true},
+	{Name: "risk", Field: "risk"},
+	{Name: "reason", Field: "reason"},
+}
+
+// optimizePurchasingCmd creates the purchasing recommendations command
+func optimizePurchasingCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "purchasing",
+		Short: "Recommend spot capacity and commitment purchases",
+		Long: `Recommend how to buy the capacity of each node pool: spot capacity, or a
+commitment discount such as a Savings Plan or Reserved Instances on AWS, a
+committed use discount on GCP, or a savings plan on Azure.
+
+On-demand nodes that have run for the whole --time-range are steady state.
+The workloads of each pool are rated by their risk of tolerating
+interruption:
+  low     stateless with several replicas
+  medium  Jobs and CronJobs, whose interrupted runs start over
+  high    single replicas, StatefulSets, bare pods, and pods with persistent
+          volume claims or annotated as not safe to evict
+
+Pools of low risk are recommended for spot capacity. Pools of medium risk
+are recommended for spot beyond their steady state, with a commitment for
+the steady state. Pools of high risk get a commitment for their steady
+state only. Spot and commitments save --spot-discount and
+--commitment-discount off on-demand prices, and a commitment of --term
+years breaks even if its nodes keep running for (100 - discount)% of the
+term. Nodes are priced by their capacity at --cpu-price and --memory-price,
+which default to the pricing model in config.
+
+Node pools are read from the labels of EKS node groups, Karpenter node
+pools, GKE node pools and AKS agent pools, or are the instance types of the
+nodes. The analysis reads the Kubernetes API directly and does not need the
+Python runtime.
+
+Examples:
+  upid optimize purchasing                           # Steady over the last 30 days
+  upid optimize purchasing -t 90d --term 3           # 3-year commitments
+  upid optimize purchasing --spot-discount 70 --commitment-discount 35`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return optimizePurchasing(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("time-range", "t", "30d", "how long on-demand nodes must have run to be steady state")
+	cmd.Flags().Int("term", 1, "term of commitments in years: 1 or 3")
+	cmd.Flags().Float64("commitment-discount", 0, "discount of commitments off on-demand prices, in percent (default 30 for 1 year, 50 for 3 years)")
+	cmd.Flags().Float64("spot-discount", 65, "discount of spot capacity off on-demand prices, in percent")
+	cmd.Flags().Float64("cpu-price", 0, "price per core-hour of node capacity (default pricing.cpu)")
+	cmd.Flags().Float64("memory-price", 0, "price per GiB-hour of node capacity (default pricing.memory)")
+
+	return cacheable(withColumns(cmd, purchasingColumns))
 }
 
 // Implementation functions
 func optimizeResources(cmd *cobra.Command, args []string) error {
-	clusterName := "default"
+	clusterName := config.GetDefaultCluster()
 	if len(args) > 0 {
 		clusterName = args[0]
 	}
@@ -157,6 +461,30 @@ func optimizeResources(cmd *cobra.Command, args []string) error {
 	namespace, _ := cmd.Flags().GetString("namespace")
 	detailed, _ := cmd.Flags().GetBool("detailed")
 	includeCosts, _ := cmd.Flags().GetBool("include-costs")
+	exportDir, _ := cmd.Flags().GetString("export-manifests")
+	patchTypeFlag, _ := cmd.Flags().GetString("patch-type")
+	timeRange, _ := cmd.Flags().GetString("time-range")
+
+	// Manifests are exported from the built-in right-sizing
+	if exportDir != "" {
+		patchType, err := native.ParsePatchType(patchTypeFlag)
+		if err != nil {
+			return err
+		}
+		prices, err := computePrices(cmd)
+		if err != nil {
+			return err
+		}
+		return exportRightsize(cmd.Context(), timeRange, native.RightsizeOptions{
+			Namespace:  namespace,
+			Percentile: 95,
+			Headroom:   0.2,
+			OOMBuffer:  0.25,
+			Prices:     prices,
+			ExportDir:  exportDir,
+			PatchType:  patchType,
+		})
+	}
 
 	// Build arguments
 	cmdArgs := []string{"resources", clusterName}
@@ -170,7 +498,7 @@ func optimizeResources(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--include-costs")
 	}
 
-	return executePythonCommand("optimize", cmdArgs)
+	return executePythonCommand(cmd.Context(), "optimize", cmdArgs)
 }
 
 func optimizeZeroPod(cmd *cobra.Command, args []string) error {
@@ -183,22 +511,289 @@ func optimizeZeroPod(cmd *cobra.Command, args []string) error {
 	dryRun, _ := cmd.Flags().GetBool("dry-run")
 	confidence, _ := cmd.Flags().GetFloat64("confidence")
 	autoRollback, _ := cmd.Flags().GetBool("auto-rollback")
+	timeRange, _ := cmd.Flags().GetString("time-range")
+	apply, _ := cmd.Flags().GetBool("apply")
+	rollback, _ := cmd.Flags().GetBool("rollback")
+	workloads, _ := cmd.Flags().GetStringSlice("workload")
+	override, _ := cmd.Flags().GetString("override")
+
+	// Scaling always runs in Go. --apply and --rollback make changes unless
+	// --dry-run is given explicitly, as does --dry-run=false alone.
+	if apply || rollback {
+		dryRun = dryRun && cmd.Flags().Changed("dry-run")
+	}
+	if rollback {
+		return executeBuiltin(cmd.Context(), "optimize", func(ctx context.Context) (map[string]interface{}, error) {
+			client, err := native.NewClient("")
+			if err != nil {
+				return nil, err
+			}
+			records, err := native.LoadScaleRecords(zeroPodStateFile())
+			if err != nil {
+				return nil, err
+			}
+			result, err := client.RollbackZeroPod(ctx, namespace, records, workloads, dryRun)
+			if err == nil && !dryRun {
+				checkRollback(ctx, result, namespace, true)
+			}
+			return result, err
+		})
+	}
+	if !dryRun {
+		// A change that needs approval records the workloads it scales, and
+		// only those are scaled once it is approved
+		request := &kube.Approval{Command: "optimize zero-pod " + namespace + " --apply", Namespace: namespace, Override: override}
+		if override != "" {
+			request.Command += fmt.Sprintf(" --override %q", override)
+		}
+		if approvalRequired(namespace) {
+			changes, err := zeroPodChanges(cmd.Context(), namespace, confidence, timeRange, override)
+			if err != nil {
+				return err
+			}
+			request.Changes = changes
+		}
+		done := func() error { return nil }
+		if request.Changes == nil || len(request.Changes) > 0 {
+			approved, complete, err := awaitApproval(cmd.Context(), request)
+			if err != nil || !approved {
+				return err
+			}
+			done = complete
+		}
+		err := executeBuiltin(cmd.Context(), "optimize", func(ctx context.Context) (map[string]interface{}, error) {
+			client, window, err := nativeClient(timeRange)
+			if err != nil {
+				return nil, err
+			}
+			rails, err := guardrails(override)
+			if err != nil {
+				return nil, err
+			}
+			client.SetGuardrails(rails)
+			records, err := native.LoadScaleRecords(zeroPodStateFile())
+			if err != nil {
+				return nil, err
+			}
+			result, err := client.ApplyZeroPod(ctx, namespace, confidence, window, records, autoRollback, request.Changes)
+			if workloads, _ := result["workloads"].([]interface{}); err == nil && len(workloads) > 0 {
+				notifyEvent(ctx, optimizationEvent("UPID scaled idle workloads to zero in "+namespace, result))
+				if autoRollback {
+					checkRollback(ctx, result, namespace, false)
+				}
+			}
+			return result, err
+		})
+		if err != nil {
+			return err
+		}
+		return done()
+	}
 
 	// Build arguments
-	cmdArgs := []string{"zero-pod", namespace}
-	if dryRun {
-		cmdArgs = append(cmdArgs, "--dry-run")
-	}
+	cmdArgs := []string{"zero-pod", namespace, "--dry-run"}
 	cmdArgs = append(cmdArgs, "--confidence", fmt.Sprintf("%.2f", confidence))
 	if autoRollback {
 		cmdArgs = append(cmdArgs, "--auto-rollback")
 	}
+	if cmd.Flags().Changed("time-range") {
+		cmdArgs = append(cmdArgs, "--time-range", timeRange)
+	}
+
+	if runtimeMissing() {
+		return executeNative(cmd.Context(), "optimize", cmdArgs, func(ctx context.Context) (map[string]interface{}, error) {
+			client, window, err := nativeClient(timeRange)
+			if err != nil {
+				return nil, err
+			}
+			return client.ZeroPodPlan(ctx, namespace, confidence, window)
+		})
+	}
+	return executePythonCommand(cmd.Context(), "optimize", cmdArgs)
+}
+
+// zeroPodChanges lists the workloads 'optimize zero-pod --apply' would scale
+// to zero with the guardrails of override
+func zeroPodChanges(ctx context.Context, namespace string, confidence float64, timeRange, override string) ([]kube.ApprovalChange, error) {
+	client, window, err := nativeClient(timeRange)
+	if err != nil {
+		return nil, err
+	}
+	rails, err := guardrails(override)
+	if err != nil {
+		return nil, err
+	}
+	client.SetGuardrails(rails)
+	return client.ZeroPodChanges(ctx, namespace, confidence, window)
+}
 
-	return executePythonCommand("optimize", cmdArgs)
+// checkRollback raises an incident when restoring the workloads of a
+// namespace scaled to zero failed. The result is that of a rollback, whose
+// failures are all failed restores and whose success resolves the incident,
+// or of an apply, whose failures count only if its auto-rollback failed.
+func checkRollback(ctx context.Context, result map[string]interface{}, namespace string, rollback bool) {
+	kubeContext, _ := result["context"].(string)
+	key := incidentKey(kubeContext, "rollback", namespace)
+	errs, _ := result["errors"].([]interface{})
+	var failures []string
+	for _, err := range errs {
+		if message := fmt.Sprint(err); rollback || strings.Contains(message, "rollback failed") {
+			failures = append(failures, message)
+		}
+	}
+	if len(failures) == 0 {
+		if rollback {
+			resolveIncident(ctx, key, fmt.Sprintf("The workloads of namespace %s were restored", namespace))
+		}
+		return
+	}
+	raiseIncident(ctx, notify.Event{
+		Title:   fmt.Sprintf("Rollback failed in %s/%s", kubeContext, namespace),
+		Message: fmt.Sprintf("UPID could not restore %d workloads scaled to zero: %s", len(failures), strings.Join(failures, "; ")),
+		Source:  kubeContext,
+		Key:     key,
+		Fields:  []notify.Field{{Name: "Context", Value: kubeContext}, {Name: "Namespace", Value: namespace}},
+	})
+}
+
+func optimizeRightsize(cmd *cobra.Command, args []string) error {
+	// Get flags
+	namespace, _ := cmd.Flags().GetString("namespace")
+	timeRange, _ := cmd.Flags().GetString("time-range")
+	percentileFlag, _ := cmd.Flags().GetString("percentile")
+	headroom, _ := cmd.Flags().GetFloat64("headroom")
+	oomBuffer, _ := cmd.Flags().GetFloat64("oom-buffer")
+	exportDir, _ := cmd.Flags().GetString("export-manifests")
+	patchTypeFlag, _ := cmd.Flags().GetString("patch-type")
+
+	percentile, err := native.ParsePercentile(percentileFlag)
+	if err != nil {
+		return err
+	}
+	patchType, err := native.ParsePatchType(patchTypeFlag)
+	if err != nil {
+		return err
+	}
+	if headroom < 0 {
+		return fmt.Errorf("invalid --headroom %g (expected 0 or more)", headroom)
+	}
+	if oomBuffer < 0 {
+		return fmt.Errorf("invalid --oom-buffer %g (expected 0 or more)", oomBuffer)
+	}
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return err
+	}
+
+	opts := native.RightsizeOptions{
+		Namespace:  namespace,
+		Percentile: percentile,
+		Headroom:   headroom / 100,
+		OOMBuffer:  oomBuffer / 100,
+		Prices:     prices,
+		ExportDir:  exportDir,
+		PatchType:  patchType,
+	}
+	if exportDir != "" {
+		return exportRightsize(cmd.Context(), timeRange, opts)
+	}
+
+	return executeBuiltin(cmd.Context(), "optimize", func(ctx context.Context) (map[string]interface{}, error) {
+		client, window, err := nativeClient(timeRange)
+		if err != nil {
+			return nil, err
+		}
+		opts.Window = window
+		return priced(client.Rightsize(ctx, opts))
+	})
+}
+
+func optimizePurchasing(cmd *cobra.Command, args []string) error {
+	// Get flags
+	timeRange, _ := cmd.Flags().GetString("time-range")
+	term, _ := cmd.Flags().GetInt("term")
+	commitmentDiscount, _ := cmd.Flags().GetFloat64("commitment-discount")
+	spotDiscount, _ := cmd.Flags().GetFloat64("spot-discount")
+
+	window, err := timeutil.ParseDuration(timeRange)
+	if err != nil || window == 0 {
+		return fmt.Errorf("invalid --time-range %q (expected a time range such as 30d)", timeRange)
+	}
+	if term != 1 && term != 3 {
+		return fmt.Errorf("invalid --term %d (expected 1 or 3)", term)
+	}
+	if !cmd.Flags().Changed("commitment-discount") {
+		commitmentDiscount = 30
+		if term == 3 {
+			commitmentDiscount = 50
+		}
+	}
+	for _, discount := range []struct {
+		flag  string
+		value float64
+	}{{"commitment-discount", commitmentDiscount}, {"spot-discount", spotDiscount}} {
+		if discount.value < 0 || discount.value >= 100 {
+			return fmt.Errorf("invalid --%s %g (expected a percentage from 0 to less than 100)", discount.flag, discount.value)
+		}
+	}
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return err
+	}
+	opts := native.PurchasingOptions{
+		Window:             window,
+		Term:               term,
+		CommitmentDiscount: commitmentDiscount / 100,
+		SpotDiscount:       spotDiscount / 100,
+		Prices:             prices,
+	}
+
+	client, err := native.NewClient("")
+	if err != nil {
+		return err
+	}
+	result, err := priced(client.RecommendPurchasing(cmd.Context(), opts))
+	if err != nil {
+		return fmt.Errorf("failed to execute optimize command: %w", err)
+	}
+	if err := renderSections(result, []section{
+		{"recommendations", purchasingColumns},
+		{"pools", purchasingPoolColumns},
+		{"workloads", interruptionColumns},
+	}); err != nil {
+		return err
+	}
+	printResultWarning(result)
+	return nil
+}
+
+// exportRightsize right-sizes over timeRange, writes the patches and
+// manifests of the workloads to resize, and lists them after the
+// containers
+func exportRightsize(ctx context.Context, timeRange string, opts native.RightsizeOptions) error {
+	client, window, err := nativeClient(timeRange)
+	if err != nil {
+		return err
+	}
+	opts.Window = window
+	result, err := priced(client.Rightsize(ctx, opts))
+	if err != nil {
+		return fmt.Errorf("failed to execute optimize command: %w", err)
+	}
+	if err := renderSections(result, []section{{"containers", rightsizeColumns}, {"manifests", manifestColumns}, {"excluded", excludedColumns}}); err != nil {
+		return err
+	}
+	printResultWarning(result)
+	return nil
+}
+
+// zeroPodStateFile returns the local record of workloads scaled to zero
+func zeroPodStateFile() string {
+	return filepath.Join(config.GetStateDir(), "zero-pod.json")
 }
 
 func optimizeCost(cmd *cobra.Command, args []string) error {
-	clusterName := "default"
+	clusterName := config.GetDefaultCluster()
 	if len(args) > 0 {
 		clusterName = args[0]
 	}
@@ -220,15 +815,36 @@ func optimizeCost(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--include-forecasts")
 	}
 
-	return executePythonCommand("optimize", cmdArgs)
+	return executePythonCommand(cmd.Context(), "optimize", cmdArgs)
 }
 
 func optimizeApply(cmd *cobra.Command, args []string) error {
-	recommendationID := args[0]
-
 	// Get flags
 	confirm, _ := cmd.Flags().GetBool("confirm")
 	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	viaPR, _ := cmd.Flags().GetBool("via-pr")
+	override, _ := cmd.Flags().GetString("override")
+
+	if viaPR {
+		return optimizeApplyViaPR(cmd, dryRun)
+	}
+	if all, _ := cmd.Flags().GetBool("all"); all || len(args) != 1 || cmd.Flags().Changed("from-file") || cmd.Flags().Changed("min-savings") {
+		return optimizeApplyBatch(cmd, args, confirm, dryRun)
+	}
+	recommendationID := args[0]
+
+	// Protected clusters apply approved changes only
+	done := func() error { return nil }
+	if !dryRun {
+		approved, complete, err := awaitApproval(cmd.Context(), &kube.Approval{Command: "optimize apply " + recommendationID, Override: override})
+		if err != nil || !approved {
+			return err
+		}
+		if err := guardBusinessHours(override, "optimize apply "+recommendationID); err != nil {
+			return err
+		}
+		done = complete
+	}
 
 	// Build arguments
 	cmdArgs := []string{"apply", recommendationID}
@@ -239,11 +855,80 @@ func optimizeApply(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--dry-run")
 	}
 
-	return executePythonCommand("optimize", cmdArgs)
+	if err := executePythonCommand(cmd.Context(), "optimize", cmdArgs); err != nil {
+		return err
+	}
+	return done()
+}
+
+func optimizeApplyViaPR(cmd *cobra.Command, dryRun bool) error {
+	// Get flags
+	repo, _ := cmd.Flags().GetString("repo")
+	path, _ := cmd.Flags().GetString("path")
+	base, _ := cmd.Flags().GetString("base")
+	branch, _ := cmd.Flags().GetString("branch")
+	provider, _ := cmd.Flags().GetString("provider")
+	apiURL, _ := cmd.Flags().GetString("api-url")
+	namespace, _ := cmd.Flags().GetString("namespace")
+	timeRange, _ := cmd.Flags().GetString("time-range")
+	override, _ := cmd.Flags().GetString("override")
+
+	if provider != "" && provider != gitops.ProviderGitHub && provider != gitops.ProviderGitLab {
+		return fmt.Errorf("invalid --provider %q (expected github or gitlab)", provider)
+	}
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, window, err := nativeClient(timeRange)
+	if err != nil {
+		return err
+	}
+	rails, err := guardrails(override)
+	if err != nil {
+		return err
+	}
+	client.SetGuardrails(rails)
+	result, err := client.RightsizePullRequest(cmd.Context(), native.RightsizeOptions{
+		Namespace:  namespace,
+		Window:     window,
+		Percentile: 95,
+		Headroom:   0.2,
+		OOMBuffer:  0.25,
+		Prices:     prices,
+	}, native.PullRequestOptions{
+		Repo:     repo,
+		Path:     path,
+		Base:     base,
+		Branch:   branch,
+		Provider: provider,
+		API:      apiURL,
+		DryRun:   dryRun,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to execute optimize command: %w", err)
+	}
+
+	// The diff follows the tables rather than being one of their fields
+	opts := renderOptions()
+	table := !opts.Quiet && (opts.Format == output.FormatTable || opts.Format == output.FormatWide || opts.Format == "")
+	diff, _ := result["diff"].(string)
+	if table {
+		delete(result, "diff")
+	}
+	if err := renderSections(result, []section{{"containers", rightsizeColumns}, {"changes", changeColumns}, {"excluded", excludedColumns}, {"blocked", blockedColumns}}); err != nil {
+		return err
+	}
+	if table && diff != "" {
+		fmt.Printf("\n%s\n", diff)
+	}
+	printResultWarning(result)
+	return nil
 }
 
 func optimizePreview(cmd *cobra.Command, args []string) error {
-	clusterName := "default"
+	clusterName := config.GetDefaultCluster()
 	if len(args) > 0 {
 		clusterName = args[0]
 	}
@@ -261,27 +946,5 @@ func optimizePreview(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--detailed")
 	}
 
-	return executePythonCommand("optimize", cmdArgs)
+	return executePythonCommand(cmd.Context(), "optimize", cmdArgs)
 }
-
-func optimizeSchedule(cmd *cobra.Command, args []string) error {
-	cronExpr := "0 2 * * *" // Default: daily at 2 AM
-	if len(args) > 0 {
-		cronExpr = args[0]
-	}
-
-	// Get flags
-	cluster, _ := cmd.Flags().GetString("cluster")
-	enabled, _ := cmd.Flags().GetBool("enabled")
-
-	// Build arguments
-	cmdArgs := []string{"schedule", cronExpr}
-	if cluster != "" {
-		cmdArgs = append(cmdArgs, "--cluster", cluster)
-	}
-	if enabled {
-		cmdArgs = append(cmdArgs, "--enabled")
-	}
-
-	return executePythonCommand("optimize", cmdArgs)
-} 
\ No newline at end of file
@@ -0,0 +1,276 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+// pluginPrefix names the executables on PATH that extend upid, upid-foo
+// running as 'upid foo'
+const pluginPrefix = "upid-"
+
+// cobraCommands are the commands cobra adds when the CLI runs, which
+// plugins cannot replace
+var cobraCommands = map[string]bool{"help": true, "completion": true, "__complete": true, "__completeNoDesc": true}
+
+// PluginCmd creates the plugin command
+func PluginCmd() *cobra.Command {
+	pluginCmd := &cobra.Command{
+		Use:   "plugin",
+		Short: "Manage plugins",
+		Long: `Manage the plugins extending upid.
+
+Any executable on PATH named upid-<name> runs as 'upid <name>', with the
+arguments that follow. Dashes separate subcommands, upid-foo-bar runs as
+'upid foo bar', and underscores stand for dashes in names, upid-foo_bar
+runs as 'upid foo-bar'. Plugins cannot replace or extend built-in commands,
+and the first plugin of a name on PATH shadows later ones.
+
+Plugins run with the environment of upid and:
+
+  UPID_BIN          the upid executable, to call back into it
+  UPID_CONFIG_FILE  the config file in use
+  UPID_PROFILE      the active profile
+  UPID_CLUSTER      the cluster used when a command names none
+  UPID_API_URL      the endpoint of the UPID API
+  UPID_TOKEN        the auth token of the active profile, refreshed first
+                    for single sign-on; empty when UPID_API_KEY is set,
+                    which is passed as is
+
+Global flags are not parsed for plugins: give them to 'upid' commands the
+plugin runs, or select a profile with UPID_PROFILE.
+
+Examples:
+  upid plugin list   # List the plugins on PATH
+  upid foo --bar     # Run upid-foo --bar`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return pluginList(cmd, args)
+		},
+	}
+
+	// Add subcommands
+	pluginCmd.AddCommand(withColumns(&cobra.Command{
+		Use:   "list",
+		Short: "List the plugins on PATH",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return pluginList(cmd, args)
+		},
+	}, pluginColumns))
+
+	return pluginCmd
+}
+
+// pluginColumns are the table columns for plugin listings
+var pluginColumns = []output.Column{
+	{Name: "command", Field: "command"},
+	{Name: "path", Field: "path"},
+	{Name: "status", Field: "status"},
+}
+
+// pluginFile is an executable on PATH named as a plugin
+type pluginFile struct {
+	// command is what runs it, "foo bar" for upid-foo-bar
+	command string
+	path    string
+}
+
+// findPlugins lists the plugins in the directories of PATH, in order, with
+// those of a name found again in a later directory
+func findPlugins() []pluginFile {
+	var plugins []pluginFile
+	seen := map[string]bool{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !strings.HasPrefix(name, pluginPrefix) || entry.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			if !executable(path) {
+				continue
+			}
+			if runtime.GOOS == "windows" {
+				name = strings.TrimSuffix(name, filepath.Ext(name))
+			}
+			words := strings.Split(strings.TrimPrefix(name, pluginPrefix), "-")
+			for i, word := range words {
+				words[i] = strings.ReplaceAll(word, "_", "-")
+			}
+			plugins = append(plugins, pluginFile{command: strings.Join(words, " "), path: path})
+		}
+	}
+	return plugins
+}
+
+// executable returns whether a file can be run
+func executable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		ext := strings.ToLower(filepath.Ext(path))
+		return ext == ".exe" || ext == ".bat" || ext == ".cmd"
+	}
+	return info.Mode()&0o111 != 0
+}
+
+// builtin returns whether the words of a command name a built-in command
+func builtin(root *cobra.Command, words []string) bool {
+	if cobraCommands[words[0]] {
+		return true
+	}
+	found, _, err := root.Find(words)
+	return err == nil && found != root
+}
+
+// ExecutePlugin runs the plugin named by the leading arguments when they
+// are not a built-in command, the longest name first, with the remaining
+// arguments. It returns false if there is no such plugin, and the exit code
+// of the plugin otherwise.
+func ExecutePlugin(ctx context.Context, root *cobra.Command, args []string) (bool, int, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") || builtin(root, args[:1]) {
+		return false, 0, nil
+	}
+	// The leading words that may name a plugin
+	n := 0
+	for n < len(args) && !strings.HasPrefix(args[n], "-") {
+		n++
+	}
+	path := ""
+	for ; n > 0; n-- {
+		words := make([]string, n)
+		for i, word := range args[:n] {
+			words[i] = strings.ReplaceAll(word, "-", "_")
+		}
+		if found, err := exec.LookPath(pluginPrefix + strings.Join(words, "-")); err == nil {
+			path = found
+			break
+		}
+	}
+	if path == "" {
+		return false, 0, nil
+	}
+	if err := config.Reload(); err != nil {
+		return true, 1, err
+	}
+	config.SetupLogging()
+	slog.Info("plugin started", "plugin", path)
+
+	command := exec.Command(path, args[n:]...)
+	command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stdout, os.Stderr
+	command.Env = append(os.Environ(), pluginEnv(ctx)...)
+	// Interrupts reach the plugin from the terminal, which ends on its own
+	err := command.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// A plugin killed by a signal exits with 128 and the signal, as in
+		// shells
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return true, 128 + int(status.Signal()), nil
+		}
+		if exitErr.ExitCode() < 0 {
+			return true, 1, fmt.Errorf("plugin %s did not exit: %v", path, err)
+		}
+		return true, exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return true, 1, fmt.Errorf("failed to run plugin %s: %w", path, err)
+	}
+	return true, 0, nil
+}
+
+// pluginEnv returns the variables passing the configuration, the active
+// profile with its cluster and endpoint, and its auth token to plugins
+func pluginEnv(ctx context.Context) []string {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	env := []string{
+		"UPID_BIN=" + exe,
+		"UPID_CONFIG_FILE=" + config.FilePath(),
+		"UPID_PROFILE=" + config.GetProfile(),
+		"UPID_CLUSTER=" + config.GetDefaultCluster(),
+		"UPID_API_URL=" + config.GetEndpoint(),
+	}
+	if apiKeyInUse() {
+		return append(env, "UPID_TOKEN=")
+	}
+	// Sessions of single sign-on are refreshed before their token expires
+	if session, err := loadSession(); err == nil && session != nil && session.RefreshToken != "" {
+		if _, err := currentSession(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to refresh the login: %v\n", err)
+		}
+	}
+	token, err := credentialStore().Get(credentialName(credentialAuthToken))
+	if err != nil && !errors.Is(err, secrets.ErrNotFound) {
+		slog.Warn("failed to read stored credential", "name", credentialAuthToken, "error", err)
+	}
+	return append(env, "UPID_TOKEN="+token)
+}
+
+// Implementation functions
+func pluginList(cmd *cobra.Command, args []string) error {
+	plugins := findPlugins()
+	sort.SliceStable(plugins, func(i, j int) bool { return plugins[i].command < plugins[j].command })
+	items := make([]interface{}, 0, len(plugins))
+	first := map[string]string{}
+	warnings := 0
+	for _, p := range plugins {
+		status := "ok"
+		switch words := strings.Fields(p.command); {
+		case len(words) == 0:
+			status = "no command name"
+		case builtin(cmd.Root(), words):
+			status = "shadowed by a built-in command"
+		case first[p.command] != "":
+			status = "shadowed by " + first[p.command]
+		default:
+			first[p.command] = p.path
+		}
+		if status != "ok" {
+			warnings++
+		}
+		items = append(items, map[string]interface{}{
+			"command": p.command,
+			"path":    p.path,
+			"status":  status,
+		})
+	}
+	result := map[string]interface{}{
+		"message": fmt.Sprintf("%d plugins on PATH", len(items)),
+		"plugins": items,
+	}
+	if warnings > 0 {
+		result["warning"] = fmt.Sprintf("%d plugins cannot run", warnings)
+	}
+	if err := renderResult(result); err != nil {
+		return err
+	}
+	printResultWarning(result)
+	return nil
+}
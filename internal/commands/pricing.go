@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kubilitics/upid-cli/internal/bridge"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/spf13/cobra"
+)
+
+// computePrices returns the prices of compute of the pricing model in config,
+// pricing.cpu, pricing.memory and pricing.gpu, with those given by the
+// --cpu-price and --memory-price flags of cmd instead
+func computePrices(cmd *cobra.Command) (native.ComputePrices, error) {
+	settings := config.GetPricingConfig()
+	prices := native.ComputePrices{CPU: settings.CPU, Memory: settings.Memory, GPU: settings.GPU}
+	for _, flag := range []struct {
+		name  string
+		price *float64
+	}{{"cpu-price", &prices.CPU}, {"memory-price", &prices.Memory}} {
+		if !cmd.Flags().Changed(flag.name) {
+			continue
+		}
+		*flag.price, _ = cmd.Flags().GetFloat64(flag.name)
+		if *flag.price < 0 {
+			return prices, fmt.Errorf("invalid --%s %g (expected 0 or more)", flag.name, *flag.price)
+		}
+	}
+	return prices, nil
+}
+
+// storagePrice returns the price of a GiB-month of persistent volumes given
+// by the --storage-price flag of cmd, or pricing.storage
+func storagePrice(cmd *cobra.Command) (float64, error) {
+	if !cmd.Flags().Changed("storage-price") {
+		return config.GetPricingConfig().Storage, nil
+	}
+	price, _ := cmd.Flags().GetFloat64("storage-price")
+	if price < 0 {
+		return 0, fmt.Errorf("invalid --storage-price %g (expected 0 or more)", price)
+	}
+	return price, nil
+}
+
+// priced adds the currency of the pricing model to a result with costs
+func priced(result map[string]interface{}, err error) (map[string]interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	result["currency"] = config.GetPricingConfig().Currency
+	return result, nil
+}
+
+// formatCost formats a cost in the currency of the pricing model, with the
+// dollar sign for USD
+func formatCost(value float64) string {
+	currency := config.GetPricingConfig().Currency
+	if currency == "" || currency == "USD" {
+		return fmt.Sprintf("$%.2f", value)
+	}
+	return fmt.Sprintf("%.2f %s", value, currency)
+}
+
+// setPricingEnv passes the pricing model to the runtime, which prices costs
+// with it instead of its list prices
+func setPricingEnv(pb *bridge.PythonBridge) {
+	settings := config.GetPricingConfig()
+	pb.SetEnv("UPID_PRICING_CPU", strconv.FormatFloat(settings.CPU, 'g', -1, 64))
+	pb.SetEnv("UPID_PRICING_MEMORY", strconv.FormatFloat(settings.Memory, 'g', -1, 64))
+	pb.SetEnv("UPID_PRICING_GPU", strconv.FormatFloat(settings.GPU, 'g', -1, 64))
+	pb.SetEnv("UPID_PRICING_STORAGE", strconv.FormatFloat(settings.Storage, 'g', -1, 64))
+	pb.SetEnv("UPID_PRICING_CURRENCY", settings.Currency)
+}
@@ -0,0 +1,226 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/spf13/cobra"
+)
+
+// configProfileCmd creates the config profile command
+func configProfileCmd() *cobra.Command {
+	profileCmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Manage configuration profiles",
+		Long: `Manage named configuration profiles.
+
+A profile holds its own endpoint, default cluster, auth provider and output
+preferences, which override the top-level settings while it is selected.
+Select a profile for one command with --profile or UPID_PROFILE, or make it
+the default with 'upid config profile use'.
+
+Examples:
+  upid config profile list                                    # List profiles
+  upid config profile create prod --endpoint https://api.upid.io --cluster prod-eu
+  upid config profile use prod                                # Make prod the default
+  upid --profile dev analyze cluster                          # Use dev for one command
+  upid --profile prod config set output_format json           # Change a profile setting`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return configProfileList(cmd, args)
+		},
+	}
+
+	// Add subcommands
+	profileCmd.AddCommand(withColumns(&cobra.Command{
+		Use:   "list",
+		Short: "List configuration profiles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return configProfileList(cmd, args)
+		},
+	}, profileColumns))
+	profileCmd.AddCommand(configProfileCreateCmd())
+	profileCmd.AddCommand(mutating(&cobra.Command{
+		Use:               "use [name]",
+		Short:             "Select the default profile",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeProfiles,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return configProfileUse(cmd, args)
+		},
+	}))
+
+	return profileCmd
+}
+
+// profileColumns are the table columns for profile listings
+var profileColumns = []output.Column{
+	{Name: "current", Header: " ", Field: "marker"},
+	{Name: "name", Field: "name"},
+	{Name: "endpoint", Field: "endpoint"},
+	{Name: "cluster", Field: "cluster"},
+	{Name: "auth", Header: "AUTH PROVIDER", Field: "auth_provider"},
+	{Name: "output", Field: "output_format"},
+}
+
+// configProfileCreateCmd creates the config profile create command
+func configProfileCreateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create [name]",
+		Short: "Create a configuration profile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return configProfileCreate(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("endpoint", "", "UPID API endpoint")
+	cmd.Flags().String("cluster", "", "cluster used when a command names none")
+	cmd.Flags().String("auth-provider", "", "provider used by 'auth login'")
+	cmd.Flags().String("output-format", "", "default output format")
+	cmd.Flags().Bool("use", false, "make the new profile the default")
+
+	return mutating(cmd)
+}
+
+// completeProfiles completes profile names
+func completeProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return config.ProfileNames(), cobra.ShellCompDirectiveNoFileComp
+}
+
+// Implementation functions
+func configProfileList(cmd *cobra.Command, args []string) error {
+	current := config.GetProfile()
+	items := make([]interface{}, 0)
+	for _, name := range config.ProfileNames() {
+		settings, _ := config.Profile(name)
+		item := map[string]interface{}{
+			"name":    name,
+			"current": name == current,
+			"marker":  "",
+		}
+		if name == current {
+			item["marker"] = "*"
+		}
+		for _, field := range []string{"endpoint", "cluster", "output_format"} {
+			if value, ok := settings[field]; ok {
+				item[field] = value
+			}
+		}
+		if auth, ok := settings["auth"].(map[string]interface{}); ok {
+			item["auth_provider"] = auth["provider"]
+		}
+		items = append(items, item)
+	}
+	return renderResult(map[string]interface{}{
+		"message":  fmt.Sprintf("%d profiles in %s", len(items), config.FilePath()),
+		"profiles": items,
+	})
+}
+
+func configProfileCreate(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	if _, exists := config.Profile(name); exists {
+		return clierr.New(clierr.CategoryUsage, "PROFILE_EXISTS", fmt.Sprintf("profile %q already exists", name)).
+			WithHint(fmt.Sprintf("Change its settings with 'upid --profile %s config set <key> <value>'", name))
+	}
+
+	// Get flags
+	use, _ := cmd.Flags().GetBool("use")
+	settings := map[string]interface{}{}
+	for _, setting := range []struct{ key, flag string }{
+		{"endpoint", "endpoint"},
+		{"cluster", "cluster"},
+		{"auth.provider", "auth-provider"},
+		{"output_format", "output-format"},
+	} {
+		raw, _ := cmd.Flags().GetString(setting.flag)
+		if raw == "" {
+			continue
+		}
+		key, _ := config.LookupKey(setting.key)
+		value, err := key.Parse(raw)
+		if err != nil {
+			return clierr.Wrap(err, clierr.CategoryUsage, "INVALID_CONFIG_VALUE", err.Error())
+		}
+		settings[setting.key] = value
+	}
+
+	path := config.FilePath()
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("create profile %s in %s", name, path))
+	}
+	file, err := config.OpenFile(path)
+	if err != nil {
+		return err
+	}
+	if err := file.Set("profiles."+name, map[string]interface{}{}); err != nil {
+		return err
+	}
+	for _, key := range []string{"endpoint", "cluster", "auth.provider", "output_format"} {
+		if value, ok := settings[key]; ok {
+			if err := file.Set("profiles."+name+"."+key, value); err != nil {
+				return err
+			}
+		}
+	}
+	if use {
+		if err := file.Set("current_profile", name); err != nil {
+			return err
+		}
+	}
+	if err := file.Save(); err != nil {
+		return err
+	}
+	if err := config.ReadConfigFile(); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Created profile %s in %s", name, path)
+	if use {
+		message += " and made it the default"
+	}
+	return renderResult(map[string]interface{}{"message": message})
+}
+
+func configProfileUse(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	if _, exists := config.Profile(name); !exists {
+		return clierr.New(clierr.CategoryUsage, "UNKNOWN_PROFILE", fmt.Sprintf("profile %q not found", name)).
+			WithHint("List profiles with 'upid config profile list'")
+	}
+
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("make %s the default profile in %s", name, config.FilePath()))
+	}
+	if err := setCurrentProfile(name); err != nil {
+		return err
+	}
+	return renderResult(map[string]interface{}{
+		"message": fmt.Sprintf("Switched to profile %s", name),
+	})
+}
+
+// setCurrentProfile makes a profile the default in the config file, "" for
+// the settings outside of profiles
+func setCurrentProfile(name string) error {
+	file, err := config.OpenFile(config.FilePath())
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		file.Unset("current_profile")
+	} else if err := file.Set("current_profile", name); err != nil {
+		return err
+	}
+	if err := file.Save(); err != nil {
+		return err
+	}
+	return config.ReadConfigFile()
+}
@@ -1,6 +1,13 @@
 package commands
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/budget"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/output"
 	"github.com/spf13/cobra"
 )
 
@@ -17,8 +24,11 @@ func ReportCmd() *cobra.Command {
 
 	// Add subcommands
 	reportCmd.AddCommand(reportGenerateCmd())
+	reportCmd.AddCommand(reportListCmd())
+	reportCmd.AddCommand(reportGetCmd())
 	reportCmd.AddCommand(reportExportCmd())
 	reportCmd.AddCommand(reportScheduleCmd())
+	reportCmd.AddCommand(withColumns(reportTemplatesCmd(), templateColumns))
 
 	return reportCmd
 }
@@ -28,16 +38,98 @@ func reportGenerateCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "generate [report-type]",
 		Short: "Generate a report",
-		Long:  "Generate various types of reports",
+		Long: `Generate various types of reports.
+
+The summary report, the default, is a standalone PDF or HTML file
+(--format), without the Python runtime: the cost of the cluster by kind of
+resource and by namespace, the workloads that waste the most (--top), and a
+summary of the recommendations to scale idle workloads to zero or right-size
+those using less than half their requests. Usage is the average over
+--time-range from the datasource, else the current usage from
+metrics-server. Charts are drawn inline, so the file opens offline. The PDF
+has a table of contents, linked and in the bookmarks, and each page is
+headed by the --company, its --logo (PNG or JPEG) and the title of the
+report; --brand-color colors the headings and rules of both formats.
+
+The compare report puts the clusters of --clusters, kubeconfig contexts
+(all by default), side by side: their efficiency (the share of the cost of
+requests that is used), cost per workload and waste (the savings of the
+recommendations of the summary report), so that fleet owners see which
+clusters need attention: those using less than half their requests, or
+whose waste or cost per workload is above the average of the fleet. The
+comparison is written to a PDF or HTML file (--format) and printed, those
+that need attention first, without the Python runtime.
+
+The slo report joins the recommendations of the summary report with the
+error budgets of the SLOs recorded by Sloth rules in the datasource
+(slo:period_error_budget_remaining:ratio, slo:objective:ratio and
+slo:current_burn_rate:ratio), so that each recommendation shows whether its
+workload can absorb the change:
+  headroom   at least --min-budget percent of the budget is left, burning
+             no faster than the period allows: apply
+  limited    less budget left, or burning faster: apply with care
+  exhausted  no budget left: defer until it recovers
+  no-slo     no SLO of the workload
+The SLOs of a workload are those whose sloth_service is its name, in its
+namespace if they have a namespace label, or those listed by the
+upid.io/slo annotation of the workload (sloth_service or sloth_id values,
+comma separated); the one with the least budget left counts. The report is
+written to a PDF or HTML file (--format) and printed, without the Python
+runtime.
+
+The budgets report lists the budgets of 'upid cost budget' with the
+burn-down of their month, without the Python runtime.
+
+The chargeback report invoices the cost of the cluster to the values of
+--by: a pod label such as team, or namespace, owner, label:<key> or
+annotation:<key>. Each invoice lists the workloads of its group priced by
+their requests, plus a share of the idle capacity and of the pods of
+--shared-namespaces by --shared:
+  proportional  in proportion to the direct cost of each group (default)
+  even          evenly over the groups
+  fixed         a fixed --overhead per group instead, either a percentage
+                of its direct cost such as 15% or a monthly amount; the
+                shared cost it does not recover is reported as unrecovered
+
+--time-range is month (the current calendar month, the default), last-month,
+a month such as 2024-05, <date>..<date> or a time range such as 30d. Costs
+are those of the current requests and node capacity over the period. The
+invoices are written to --output-file as pdf or csv (--format), and a
+summary is printed. The chargeback report does not need the Python runtime.
+
+` + reportTemplateHelp + `
+
+Examples:
+  upid report generate --company "Acme Corp" --logo acme.png
+  upid report generate --format html --output-file cost-report.html
+  upid report generate --template quarterly-review --time-range 90d
+  upid report generate compare --clusters prod-us,prod-eu,staging
+  upid report generate slo --time-range 7d --min-budget 30
+  upid report generate chargeback --by team --time-range month
+  upid report generate chargeback --by namespace --shared even --format csv
+  upid report generate chargeback --by team --shared fixed --overhead 15%`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return reportGenerate(cmd, args)
 		},
 	}
 
 	// Add flags
-	cmd.Flags().StringP("cluster", "c", "", "cluster name")
+	cmd.Flags().String("cluster", "", "cluster name")
+	cmd.Flags().StringSlice("clusters", nil, "compare: kubeconfig contexts of the clusters to compare (default all)")
 	cmd.Flags().StringP("time-range", "t", "30d", "time range")
 	cmd.Flags().StringP("format", "f", "pdf", "output format")
+	cmd.Flags().String("by", "namespace", "chargeback: label or dimension to invoice by, e.g. team, namespace or annotation:cost-center")
+	cmd.Flags().String("shared", native.IdleProportional, "chargeback: how idle and shared cost is charged: proportional, even or fixed")
+	cmd.Flags().String("overhead", "0", "chargeback: overhead per group with --shared fixed, e.g. 15% or 200")
+	cmd.Flags().StringSlice("shared-namespaces", []string{"kube-system"}, "chargeback, compare and summary: namespaces whose cost is shared by all groups")
+	cmd.Flags().String("output-file", "", "file to write the report to, - for stdout (default upid-report-<context>-<date>.<format>, chargeback-<by>-<start>.<format>, upid-compare-<date>.<format> or upid-slo-<context>-<date>.<format>)")
+	cmd.Flags().Int("top", native.DefaultReportTop, "summary: number of workloads listed as top offenders")
+	cmd.Flags().Float64("confidence", 0.90, "summary, compare and slo: confidence from which pods are idle")
+	cmd.Flags().Float64("min-budget", 25, "slo: percent of the error budget that must be left for a workload to have headroom")
+	cmd.Flags().String("company", "", "summary: company name on top of each page")
+	cmd.Flags().String("brand-color", "", "summary: hex color of headings and rules, e.g. #0b5fff")
+	cmd.Flags().String("logo", "", "summary: PNG or JPEG logo on top of each page")
+	cmd.Flags().String("template", "", "render the summary report with a template of the template directory, or a template file")
 
 	return cmd
 }
@@ -47,7 +139,18 @@ func reportExportCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "export [report-id]",
 		Short: "Export a report",
-		Long:  "Export a generated report",
+		Long: `Export a generated report.
+
+Reports generated on this machine are listed by 'upid report list'; their
+file is written to --output-file, <report-id>.<format> by default, in the
+format it was generated in. Other IDs are exported by the enterprise server.
+
+` + uploadHelp + `
+
+Examples:
+  upid report export summary-20240501-a1b2c3
+  upid report export summary-20240501-a1b2c3 --upload s3://acme-reports/upid --sse aws:kms
+  upid report export weekly-cost --format csv --upload gs://acme-reports/upid`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return reportExport(cmd, args)
 		},
@@ -55,9 +158,10 @@ func reportExportCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("format", "f", "pdf", "export format")
-	cmd.Flags().StringP("output", "o", "", "output file")
+	cmd.Flags().String("output-file", "", "output file")
+	addUploadFlags(cmd)
 
-	return cmd
+	return mutating(cmd)
 }
 
 // reportScheduleCmd creates the report scheduling command
@@ -73,9 +177,9 @@ func reportScheduleCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("report-type", "r", "", "report type")
-	cmd.Flags().StringP("cluster", "c", "", "cluster name")
+	cmd.Flags().String("cluster", "", "cluster name")
 
-	return cmd
+	return mutating(cmd)
 }
 
 // Implementation functions
@@ -89,6 +193,31 @@ func reportGenerate(cmd *cobra.Command, args []string) error {
 	cluster, _ := cmd.Flags().GetString("cluster")
 	timeRange, _ := cmd.Flags().GetString("time-range")
 	format, _ := cmd.Flags().GetString("format")
+	template, _ := cmd.Flags().GetString("template")
+
+	if template != "" {
+		if reportType != "summary" {
+			return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("--template renders the summary report, not the %s report", reportType))
+		}
+		return reportTemplate(cmd, cluster, timeRange, template)
+	}
+	switch reportType {
+	case "budgets":
+		return reportBudgets(cluster)
+	case "chargeback":
+		return reportChargeback(cmd, cluster, timeRange, format)
+	case "compare":
+		return reportCompare(cmd, timeRange, format)
+	case "slo":
+		return reportSLO(cmd, cluster, timeRange, format)
+	}
+	if reportType == "summary" && (format == "html" || format == "pdf") {
+		return reportSummary(cmd, cluster, timeRange, format)
+	}
+	if format == "html" {
+		return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("the %s report has no html format", reportType)).
+			WithHint("Generate the summary report with 'upid report generate --format html'")
+	}
 
 	// Build arguments
 	cmdArgs := []string{"generate", reportType}
@@ -102,26 +231,46 @@ func reportGenerate(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--format", format)
 	}
 
-	return executePythonCommand("report", cmdArgs)
+	return executePythonCommand(cmd.Context(), "report", cmdArgs)
 }
 
 func reportExport(cmd *cobra.Command, args []string) error {
 	reportID := args[0]
+	store, report, err := findReport(reportID)
+	if err != nil {
+		return err
+	}
+	if report != nil {
+		return reportExportLocal(cmd, store, report)
+	}
 
 	// Get flags
 	format, _ := cmd.Flags().GetString("format")
-	output, _ := cmd.Flags().GetString("output")
+	outputFile, _ := cmd.Flags().GetString("output-file")
+
+	location, opts, err := uploadTarget(cmd)
+	if err != nil {
+		return err
+	}
+	outputFile, cleanup, err := exportFile(outputFile, fmt.Sprintf("report-%s.%s", fileSafe(reportID), format), location)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
 
 	// Build arguments
 	cmdArgs := []string{"export", reportID}
 	if format != "" {
 		cmdArgs = append(cmdArgs, "--format", format)
 	}
-	if output != "" {
-		cmdArgs = append(cmdArgs, "--output", output)
+	if outputFile != "" {
+		cmdArgs = append(cmdArgs, "--output", outputFile)
 	}
 
-	return executePythonCommand("report", cmdArgs)
+	if err := executePythonCommand(cmd.Context(), "report", cmdArgs); err != nil || location == nil {
+		return err
+	}
+	return uploadFile(cmd, location, opts, outputFile, "reports", "")
 }
 
 func reportSchedule(cmd *cobra.Command, args []string) error {
@@ -143,5 +292,50 @@ func reportSchedule(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--cluster", cluster)
 	}
 
-	return executePythonCommand("report", cmdArgs)
-} 
\ No newline at end of file
+	return executePythonCommand(cmd.Context(), "report", cmdArgs)
+}
+
+// reportBudgets reports the budgets of a cluster, all if empty, with the
+// burn-down of their month
+func reportBudgets(cluster string) error {
+	store, err := budget.Load(budgetFile())
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	items := make([]interface{}, 0, len(store.Budgets))
+	var monthly, spent, projected float64
+	var burnDown []interface{}
+	for _, b := range store.Budgets {
+		if cluster != "" && b.Context != cluster {
+			continue
+		}
+		item := budgetItem(b, now)
+		items = append(items, item)
+		monthly += b.Monthly
+		spent += b.Spent(now)
+		if p, ok := item["projected"].(float64); ok {
+			projected += p
+		}
+		for _, point := range budgetBurnDown(b, now) {
+			point.(map[string]interface{})["budget"] = b.Name
+			burnDown = append(burnDown, point)
+		}
+	}
+	if burnDown == nil {
+		burnDown = []interface{}{}
+	}
+	result := map[string]interface{}{
+		"message":   fmt.Sprintf("%d budgets of %s, %s spent this month", len(items), formatCost(monthly), formatCost(spent)),
+		"month":     now.UTC().Format("2006-01"),
+		"monthly":   roundCost(monthly),
+		"spent":     roundCost(spent),
+		"projected": roundCost(projected),
+		"budgets":   items,
+		"burn_down": burnDown,
+	}
+	return renderSections(result, []section{
+		{"budgets", budgetColumns},
+		{"burn_down", append([]output.Column{{Name: "budget", Field: "budget"}}, budgetPointColumns...)},
+	})
+}
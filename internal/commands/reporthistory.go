@@ -0,0 +1,310 @@
+package commands
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/reports"
+	"github.com/spf13/cobra"
+)
+
+// reportColumns are the table columns for generated reports
+var reportColumns = []output.Column{
+	{Name: "id", Header: "ID", Field: "id"},
+	{Name: "type", Field: "type"},
+	{Name: "context", Field: "context"},
+	{Name: "period", Field: "period"},
+	{Name: "format", Field: "format"},
+	{Name: "size", Field: "size"},
+	{Name: "created", Field: "created"},
+	{Name: "generator", Field: "generator", Wide: true},
+	{Name: "created-by", Header: "CREATED BY", Field: "created_by", Wide: true},
+	{Name: "source", Field: "source", Wide: true},
+}
+
+// reportListCmd creates the report list command
+func reportListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List generated reports",
+		Long: `List the reports generated on this machine, newest first.
+
+Each report UPID generates is kept with its type, cluster, period and
+generator in the reports directory of the state directory (see 'upid system
+paths'), up to the last 100, whether it was written to a file or to standard
+output. Their IDs are those 'upid report get' and 'upid report export' take.
+With --enterprise, the reports kept by the UPID enterprise server are listed
+as well.
+
+Examples:
+  upid report list
+  upid report list --type chargeback --cluster prod-us
+  upid report list --enterprise -o wide`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return reportList(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("type", "", "only reports of this type")
+	cmd.Flags().String("cluster", "", "only reports of this kubeconfig context")
+	cmd.Flags().Bool("enterprise", false, "also list the reports of the enterprise server")
+
+	return withColumns(cmd, reportColumns)
+}
+
+// reportGetCmd creates the report get command
+func reportGetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "get <report-id>",
+		Short: "Show a generated report",
+		Long: `Show the metadata of a generated report, and write its content with
+--output-file ("-" for standard output). The ID may be shortened to a prefix
+that only one report has. Reports not kept on this machine are looked up on
+the enterprise server.
+
+Examples:
+  upid report get summary-20240501-a1b2c3
+  upid report get summary-20240501 --output-file report.pdf
+  upid report get chargeback-20240501-d4e5f6 --output-file - | less`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return reportGet(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("output-file", "", "write the content of the report to this file, - for standard output")
+
+	return cmd
+}
+
+// Implementation functions
+func reportList(cmd *cobra.Command, args []string) error {
+	// Get flags
+	kind, _ := cmd.Flags().GetString("type")
+	cluster, _ := cmd.Flags().GetString("cluster")
+	enterprise, _ := cmd.Flags().GetBool("enterprise")
+
+	store, err := reports.Load(reportsDir())
+	if err != nil {
+		return err
+	}
+	items := make([]interface{}, 0, len(store.Reports))
+	for _, report := range store.Reports {
+		if (kind == "" || report.Type == kind) && (cluster == "" || report.Context == cluster) {
+			items = append(items, reportItem(report, "local"))
+		}
+	}
+	local := len(items)
+
+	if enterprise {
+		if runtimeMissing() {
+			return requiresRuntime("listing the reports of the enterprise server")
+		}
+		if err := checkLogin(cmd.Context()); err != nil {
+			return err
+		}
+		cmdArgs := []string{"list"}
+		if kind != "" {
+			cmdArgs = append(cmdArgs, "--type", kind)
+		}
+		if cluster != "" {
+			cmdArgs = append(cmdArgs, "--cluster", cluster)
+		}
+		result, err := getBridge().ExecuteCommandWithJSON(cmd.Context(), "report", cmdArgs)
+		if err != nil {
+			return fmt.Errorf("failed to list enterprise reports: %w", err)
+		}
+		remote, _ := result["reports"].([]interface{})
+		for _, value := range remote {
+			if item, ok := value.(map[string]interface{}); ok {
+				item["source"] = "enterprise"
+				items = append(items, item)
+			}
+		}
+	}
+
+	message := fmt.Sprintf("%d reports in %s", local, store.Dir())
+	if enterprise {
+		message = fmt.Sprintf("%s, %d on the enterprise server", message, len(items)-local)
+	}
+	return renderResult(map[string]interface{}{
+		"message": message,
+		"reports": items,
+	})
+}
+
+func reportGet(cmd *cobra.Command, args []string) error {
+	// Get flags
+	outputFile, _ := cmd.Flags().GetString("output-file")
+
+	store, report, err := findReport(args[0])
+	if err != nil {
+		return err
+	}
+	if report == nil {
+		// Reports not generated here may be kept by the enterprise server
+		cmdArgs := []string{"get", args[0]}
+		if outputFile != "" {
+			cmdArgs = append(cmdArgs, "--output", outputFile)
+		}
+		return executePythonCommand(cmd.Context(), "report", cmdArgs)
+	}
+
+	if outputFile != "" {
+		data, err := store.Read(report)
+		if err != nil {
+			return err
+		}
+		if outputFile == "-" {
+			_, err := os.Stdout.Write(data)
+			return err
+		}
+		if err := os.WriteFile(outputFile, data, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", outputFile, err)
+		}
+		fmt.Fprintf(os.Stderr, "Wrote report %s to %s\n", report.ID, outputFile)
+	}
+	item := reportItem(report, "local")
+	item["message"] = fmt.Sprintf("Report %s: %s report of %s", report.ID, report.Type, reportScope(report))
+	item["file"] = store.Path(report)
+	return renderResult(item)
+}
+
+// reportExportLocal exports a report kept on this machine: its file is
+// copied to --output-file, <id>.<format> by default, or uploaded
+func reportExportLocal(cmd *cobra.Command, store *reports.Store, report *reports.Report) error {
+	// Get flags
+	format, _ := cmd.Flags().GetString("format")
+	outputFile, _ := cmd.Flags().GetString("output-file")
+
+	if cmd.Flags().Changed("format") && format != report.Format {
+		return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("report %s was generated as %s, not %s", report.ID, report.Format, format)).
+			WithHint(fmt.Sprintf("Generate it again with 'upid report generate %s --format %s'", report.Type, format))
+	}
+	location, opts, err := uploadTarget(cmd)
+	if err != nil {
+		return err
+	}
+	if IsDryRun() && location == nil {
+		return printDryRun(fmt.Sprintf("write report %s to %s", report.ID, exportName(outputFile, report)))
+	}
+	outputFile, cleanup, err := exportFile(outputFile, report.ID+"."+report.Format, location)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if outputFile == "" {
+		outputFile = exportName(outputFile, report)
+	}
+
+	data, err := store.Read(report)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputFile, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", outputFile, err)
+	}
+	if location != nil {
+		return uploadFile(cmd, location, opts, outputFile, "reports", report.Context)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote report %s to %s\n", report.ID, outputFile)
+	return nil
+}
+
+// exportName returns the file a report is exported to by default
+func exportName(outputFile string, report *reports.Report) string {
+	if outputFile != "" {
+		return outputFile
+	}
+	return report.ID + "." + report.Format
+}
+
+// keepReport keeps a generated report in the reports directory and returns
+// its ID. outputFile is where it is written, "-" for standard output.
+// Failing to keep it does not fail the command that generated it.
+func keepReport(report *reports.Report, outputFile string, data []byte) string {
+	store, err := reports.Load(reportsDir())
+	if err != nil {
+		slog.Warn("failed to keep report", "type", report.Type, "error", err)
+		return ""
+	}
+	if report.Generator == "" {
+		report.Generator = "upid " + config.GetVersion()
+	}
+	report.CreatedBy = localUser()
+	if outputFile != "-" {
+		if abs, err := filepath.Abs(outputFile); err == nil {
+			outputFile = abs
+		}
+		report.Written = outputFile
+	}
+	if err := store.Add(report, data); err != nil {
+		slog.Warn("failed to keep report", "type", report.Type, "error", err)
+		return ""
+	}
+	return report.ID
+}
+
+// findReport returns the store of the reports generated on this machine and
+// the report with an ID, or a unique prefix of one; nil if there is none
+func findReport(id string) (*reports.Store, *reports.Report, error) {
+	store, err := reports.Load(reportsDir())
+	if err != nil {
+		return nil, nil, err
+	}
+	report, err := store.Get(id)
+	if err != nil {
+		return nil, nil, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", err.Error()).
+			WithHint("Give more of the ID, see 'upid report list'")
+	}
+	return store, report, nil
+}
+
+// reportItem returns the fields of a report as they are rendered
+func reportItem(report *reports.Report, source string) map[string]interface{} {
+	item := map[string]interface{}{
+		"id":         report.ID,
+		"type":       report.Type,
+		"context":    report.Context,
+		"period":     report.Period,
+		"format":     report.Format,
+		"size":       report.Size,
+		"created":    report.Created.Local().Format(time.RFC3339),
+		"generator":  report.Generator,
+		"created_by": report.CreatedBy,
+		"source":     source,
+	}
+	if report.Written != "" {
+		item["written"] = report.Written
+	}
+	return item
+}
+
+// reportScope describes what a report covers
+func reportScope(report *reports.Report) string {
+	var scope []string
+	if report.Context != "" {
+		scope = append(scope, "context "+report.Context)
+	} else {
+		scope = append(scope, "several clusters")
+	}
+	if report.Period != "" {
+		scope = append(scope, "over "+report.Period)
+	}
+	return strings.Join(scope, " ")
+}
+
+// reportsDir returns the directory generated reports are kept in
+func reportsDir() string {
+	return filepath.Join(config.GetStateDir(), "reports")
+}
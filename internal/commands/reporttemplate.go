@@ -0,0 +1,244 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/reports"
+	"github.com/spf13/cobra"
+)
+
+// reportTemplateHelp documents report templates and the data passed to
+// them in the help of commands
+const reportTemplateHelp = `--template renders a Go template of your own with the data of the summary
+report. A name is looked up in the template directory (templates in the
+config directory, see 'upid system paths') as <name>.tmpl or
+<name>.<ext>.tmpl, where <ext> is the extension of the file written, such
+as quarterly-review.md.tmpl; a path is used as is. Templates ending in
+.html.tmpl are escaped as HTML. Templates have the functions of
+-o go-template, plus cost to format an amount in the currency of the
+pricing model, and these fields:
+  .Name       name of the template
+  .Context    kubeconfig context of the cluster
+  .TimeRange  --time-range of usage
+  .Generated  time the report was generated
+  .Company    --company
+  .Version    version of UPID
+  .Currency   currency of costs, which are monthly
+  .Report     the summary report:
+    .usage            where usage comes from
+    .pods .workloads  number of running pods and of their workloads
+    .monthly_cost .requested_cost .used_cost .idle_cost .savings
+    .breakdown        category, resources, monthly_cost
+    .namespaces       name, pods, cpu_requests, memory_requests,
+                      direct_cost, idle_cost, shared_cost, monthly_cost,
+                      cost_percent
+    .offenders        namespace, workload, kind, pods, action,
+                      requested_cost, used_cost, utilization, savings,
+                      severity
+    .recommendations  action, recommendations, savings
+    .severities       severity, recommendations, savings
+For example, {{ cost .Report.savings }} or
+{{ range .Report.offenders }}{{ .workload }}: {{ cost .savings }}{{ end }}.`
+
+// templateColumns are the table columns for report templates
+var templateColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "format", Field: "format"},
+	{Name: "file", Field: "file"},
+}
+
+// reportTemplateData is the data passed to report templates, documented by
+// reportTemplateHelp
+type reportTemplateData struct {
+	Name      string
+	Context   string
+	TimeRange string
+	Generated time.Time
+	Company   string
+	Version   string
+	Currency  string
+	Report    map[string]interface{}
+}
+
+// reportTemplatesCmd creates the report templates command
+func reportTemplatesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "templates",
+		Short: "List the report templates of the template directory",
+		Long: `List the report templates of the template directory, which
+'upid report generate --template <name>' renders.
+
+` + reportTemplateHelp,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return reportTemplates(cmd, args)
+		},
+	}
+
+	return cmd
+}
+
+// Implementation functions
+func reportTemplates(cmd *cobra.Command, args []string) error {
+	dir := config.GetTemplateDir()
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	items := make([]interface{}, 0, len(files))
+	for _, file := range files {
+		name, format := templateName(file)
+		items = append(items, map[string]interface{}{"name": name, "format": format, "file": file})
+	}
+	return renderResult(map[string]interface{}{
+		"message":   fmt.Sprintf("%d report templates in %s", len(items), dir),
+		"directory": dir,
+		"templates": items,
+	})
+}
+
+// reportTemplate renders the summary report of a cluster with a template of
+// the user and writes it to --output-file
+func reportTemplate(cmd *cobra.Command, cluster, timeRange, name string) error {
+	// Get flags
+	outputFile, _ := cmd.Flags().GetString("output-file")
+	company, _ := cmd.Flags().GetString("company")
+
+	file, err := findReportTemplate(name)
+	if err != nil {
+		return err
+	}
+	name, format := templateName(file)
+	render, err := parseReportTemplate(file, format)
+	if err != nil {
+		return err
+	}
+	result, err := summaryResult(cmd, cluster, timeRange)
+	if err != nil {
+		return err
+	}
+
+	data := reportTemplateData{
+		Name:      name,
+		Context:   fmt.Sprint(result["context"]),
+		TimeRange: timeRange,
+		Generated: time.Now(),
+		Company:   company,
+		Version:   config.GetVersion(),
+		Currency:  fmt.Sprint(result["currency"]),
+		Report:    result,
+	}
+	var b bytes.Buffer
+	if err := render(&b, data); err != nil {
+		return clierr.Wrap(err, clierr.CategoryUsage, "TEMPLATE_FAILED", fmt.Sprintf("failed to render the report template %s", file))
+	}
+	if outputFile == "" {
+		outputFile = fmt.Sprintf("upid-%s-%s-%s.%s", fileSafe(name), fileSafe(data.Context), data.Generated.Format("2006-01-02"), format)
+	}
+	id := keepReport(&reports.Report{Type: name, Context: data.Context, Period: timeRange, Format: format, Generator: fmt.Sprintf("template %s, upid %s", file, data.Version)}, outputFile, b.Bytes())
+	if outputFile == "-" {
+		_, err := os.Stdout.Write(b.Bytes())
+		return err
+	}
+	if err := renderResult(map[string]interface{}{
+		"message":   result["message"],
+		"context":   data.Context,
+		"template":  file,
+		"file":      outputFile,
+		"report_id": id,
+	}); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputFile, b.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", outputFile, err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote the report to %s\n", outputFile)
+	return nil
+}
+
+// findReportTemplate returns the file of a report template: name itself if
+// it is a path, else <name>.tmpl or <name>.<ext>.tmpl in the template
+// directory
+func findReportTemplate(name string) (string, error) {
+	if strings.ContainsRune(name, filepath.Separator) || strings.Contains(name, "/") {
+		if _, err := os.Stat(name); err != nil {
+			return "", fmt.Errorf("failed to read --template: %v", err)
+		}
+		return name, nil
+	}
+	dir := config.GetTemplateDir()
+	var found []string
+	for _, pattern := range []string{name + ".tmpl", name + ".*.tmpl"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, match := range matches {
+			if base, _ := templateName(match); base == name {
+				found = append(found, match)
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", clierr.New(clierr.CategoryUsage, "TEMPLATE_NOT_FOUND", fmt.Sprintf("no report template %q in %s", name, dir)).
+			WithHint("Add " + filepath.Join(dir, name+".md.tmpl") + ", or see 'upid report templates'")
+	case 1:
+		return found[0], nil
+	}
+	return "", clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("report template %q is ambiguous: %s", name, strings.Join(found, ", "))).
+		WithHint("Pass the path of the template to --template")
+}
+
+// templateName returns the name of a template file and the extension of the
+// files it renders, txt if it has none
+func templateName(file string) (string, string) {
+	name := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+	ext := filepath.Ext(name)
+	if ext == "" {
+		return name, "txt"
+	}
+	return strings.TrimSuffix(name, ext), ext[1:]
+}
+
+// parseReportTemplate parses a report template, as HTML if it renders HTML
+func parseReportTemplate(file, format string) (func(io.Writer, reportTemplateData) error, error) {
+	text, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report template: %v", err)
+	}
+	funcs := output.TemplateFuncs()
+	funcs["cost"] = func(v interface{}) string {
+		switch n := v.(type) {
+		case float64:
+			return formatCost(n)
+		case int:
+			return formatCost(float64(n))
+		}
+		return output.FormatValue(v)
+	}
+	name := filepath.Base(file)
+
+	if format == "html" || format == "htm" {
+		tmpl, err := htmltemplate.New(name).Funcs(htmltemplate.FuncMap(funcs)).Option("missingkey=zero").Parse(string(text))
+		if err != nil {
+			return nil, clierr.Wrap(err, clierr.CategoryUsage, "INVALID_ARGUMENT", "failed to parse the report template")
+		}
+		return func(w io.Writer, data reportTemplateData) error { return tmpl.Execute(w, data) }, nil
+	}
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=zero").Parse(string(text))
+	if err != nil {
+		return nil, clierr.Wrap(err, clierr.CategoryUsage, "INVALID_ARGUMENT", "failed to parse the report template")
+	}
+	return func(w io.Writer, data reportTemplateData) error { return tmpl.Execute(w, data) }, nil
+}
@@ -0,0 +1,462 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// Decisions on reviewed recommendations
+const (
+	reviewAccepted = "accepted"
+	reviewSkipped  = "skipped"
+	reviewDeferred = "deferred"
+)
+
+// summaryFields are the record fields tried, in order, as the one-line
+// description of a recommendation
+var summaryFields = []string{"title", "description", "summary", "workload", "name", "action", "type", "strategy"}
+
+// optimizeReviewCmd creates the optimize review command
+func optimizeReviewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "review",
+		Short: "Review recommendations one by one in the terminal",
+		Long: `Review the recommendations of 'upid optimize resources' in an interactive
+terminal UI, and apply the accepted ones at the end.
+
+Each recommendation is accepted, skipped or deferred. Accepted
+recommendations are applied as by 'upid optimize apply', after a final
+confirmation. Skipped recommendations are remembered and hidden from later
+reviews unless --include-skipped is set; deferred and undecided ones are
+shown again next time. Quitting with esc or ctrl+c applies nothing and forgets the decisions.
+During the business hours of the guardrails (guardrails.business_hours in
+the config file), nothing is applied unless --override gives a reason.
+
+Keys:
+  up/down, j/k    move between recommendations
+  enter           show or hide the details of a recommendation
+  a, s, d         accept, skip or defer it
+  u               undo the decision
+  A               accept all undecided recommendations
+  q               finish and apply the accepted recommendations
+  esc, ctrl+c     quit without applying anything
+
+Examples:
+  upid optimize review
+  upid optimize review --min-savings 50
+  upid optimize review --include-skipped --concurrency 2`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return optimizeReview(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().Float64("min-savings", 0, "review only recommendations saving at least this much a month")
+	cmd.Flags().Bool("include-skipped", false, "also review recommendations skipped in earlier reviews")
+	cmd.Flags().Int("concurrency", 4, "number of recommendations applied at a time")
+	cmd.Flags().String("override", "", "apply during the business hours of the guardrails, for this reason (audited)")
+
+	return mutating(cmd)
+}
+
+// Implementation functions
+func optimizeReview(cmd *cobra.Command, args []string) error {
+	// Get flags
+	minSavings, _ := cmd.Flags().GetFloat64("min-savings")
+	includeSkipped, _ := cmd.Flags().GetBool("include-skipped")
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+	override, _ := cmd.Flags().GetString("override")
+
+	if concurrency < 1 {
+		return fmt.Errorf("invalid --concurrency %d (expected at least 1)", concurrency)
+	}
+	if minSavings < 0 {
+		return fmt.Errorf("invalid --min-savings %g (expected at least 0)", minSavings)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return clierr.New(clierr.CategoryUsage, "TERMINAL_REQUIRED", "reviewing recommendations needs an interactive terminal").
+			WithHint("Apply recommendations without reviewing them with 'upid optimize apply --all'")
+	}
+
+	ctx := cmd.Context()
+	state, err := loadReviewState(reviewStateFile())
+	if err != nil {
+		return err
+	}
+	records, err := listRecommendations(ctx)
+	if err != nil {
+		return err
+	}
+	model := &reviewModel{
+		cluster: config.GetDefaultCluster(),
+		color:   output.ColorEnabled(config.IsNoColor(), os.Stdout),
+	}
+	for _, record := range records {
+		item := reviewItem{batchItem: recommendationItem(record), record: record}
+		if value, ok := item.savings.(float64); minSavings > 0 && (!ok || value < minSavings) {
+			continue
+		}
+		if _, ok := state.Skipped[item.id]; ok {
+			if !includeSkipped {
+				model.hidden++
+				continue
+			}
+			item.decision = reviewSkipped
+		}
+		model.items = append(model.items, item)
+	}
+	if len(model.items) == 0 {
+		result := map[string]interface{}{"recommendations": []interface{}{}, "message": "No recommendations to review"}
+		if model.hidden > 0 {
+			result["hint"] = fmt.Sprintf("%d recommendations skipped before are hidden, review them with --include-skipped", model.hidden)
+		}
+		return renderBatchResult(result)
+	}
+
+	final, err := tea.NewProgram(model, tea.WithAltScreen(), tea.WithContext(ctx)).Run()
+	if err != nil {
+		return fmt.Errorf("review failed: %w", err)
+	}
+	model = final.(*reviewModel)
+	if !model.finished {
+		fmt.Println("Review cancelled, nothing was applied")
+		return nil
+	}
+
+	var accepted []batchItem
+	counts := map[string]int{}
+	for _, item := range model.items {
+		counts[item.decision]++
+		switch item.decision {
+		case reviewAccepted:
+			accepted = append(accepted, item.batchItem)
+			delete(state.Skipped, item.id)
+		case reviewSkipped:
+			if _, ok := state.Skipped[item.id]; !ok {
+				state.Skipped[item.id] = time.Now().UTC()
+			}
+		default:
+			delete(state.Skipped, item.id)
+		}
+	}
+	if err := state.save(); err != nil {
+		return err
+	}
+
+	decided := fmt.Sprintf("%d skipped, %d deferred", counts[reviewSkipped], len(model.items)-counts[reviewAccepted]-counts[reviewSkipped])
+	if len(accepted) == 0 {
+		return renderBatchResult(map[string]interface{}{
+			"recommendations": []interface{}{},
+			"message":         "No recommendations accepted, " + decided,
+		})
+	}
+	result, err := applyBatch(ctx, accepted, concurrency, IsDryRun(), override)
+	if err != nil || result == nil {
+		return err
+	}
+	result["message"] = fmt.Sprintf("%s; %s", result["message"], decided)
+	return renderBatchResult(result)
+}
+
+// reviewItem is a recommendation under review
+type reviewItem struct {
+	batchItem
+	record   map[string]interface{}
+	decision string
+}
+
+// reviewModel is the terminal UI of optimize review
+type reviewModel struct {
+	cluster string
+	items   []reviewItem
+	// hidden counts the recommendations skipped in earlier reviews
+	hidden int
+	cursor int
+	// offset is the first recommendation shown
+	offset  int
+	details bool
+	// confirming is set while asking whether to apply the accepted ones
+	confirming bool
+	// finished is set when the review ends with the decisions to be kept
+	finished bool
+	width    int
+	height   int
+	color    bool
+}
+
+func (m *reviewModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m *reviewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case tea.KeyMsg:
+		key := msg.String()
+		if m.confirming {
+			switch key {
+			case "y", "Y", "enter":
+				m.finished = true
+				return m, tea.Quit
+			case "ctrl+c":
+				return m, tea.Quit
+			default:
+				m.confirming = false
+			}
+			return m, nil
+		}
+		switch key {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "esc":
+			if !m.details {
+				return m, tea.Quit
+			}
+			m.details = false
+		case "up", "k":
+			m.move(-1)
+		case "down", "j":
+			m.move(1)
+		case "home", "g":
+			m.move(-len(m.items))
+		case "end", "G":
+			m.move(len(m.items))
+		case "enter", "right", "l":
+			m.details = !m.details
+		case "left", "h":
+			m.details = false
+		case "a":
+			m.decide(reviewAccepted)
+		case "s":
+			m.decide(reviewSkipped)
+		case "d":
+			m.decide(reviewDeferred)
+		case "u":
+			m.items[m.cursor].decision = ""
+		case "A":
+			for i := range m.items {
+				if m.items[i].decision == "" {
+					m.items[i].decision = reviewAccepted
+				}
+			}
+		case "q":
+			if len(m.accepted()) == 0 {
+				m.finished = true
+				return m, tea.Quit
+			}
+			m.confirming = true
+		}
+	}
+	return m, nil
+}
+
+// move moves the cursor by delta recommendations
+func (m *reviewModel) move(delta int) {
+	m.cursor = min(max(m.cursor+delta, 0), len(m.items)-1)
+}
+
+// decide records a decision on the current recommendation and moves to the
+// next undecided one, or the next one if all are decided
+func (m *reviewModel) decide(decision string) {
+	m.items[m.cursor].decision = decision
+	for i := 1; i < len(m.items); i++ {
+		next := (m.cursor + i) % len(m.items)
+		if m.items[next].decision == "" {
+			m.cursor = next
+			return
+		}
+	}
+	m.move(1)
+}
+
+// accepted returns the accepted recommendations
+func (m *reviewModel) accepted() []reviewItem {
+	var accepted []reviewItem
+	for _, item := range m.items {
+		if item.decision == reviewAccepted {
+			accepted = append(accepted, item)
+		}
+	}
+	return accepted
+}
+
+func (m *reviewModel) View() string {
+	var b strings.Builder
+
+	counts := map[string]int{}
+	for _, item := range m.items {
+		counts[item.decision]++
+	}
+	savings := 0.0
+	for _, item := range m.accepted() {
+		if value, ok := item.savings.(float64); ok {
+			savings += value
+		}
+	}
+	b.WriteString(m.line(fmt.Sprintf("Review of %d recommendations for %s: %d accepted (%s a month), %d skipped, %d deferred, %d undecided",
+		len(m.items), m.cluster, counts[reviewAccepted], formatCost(savings), counts[reviewSkipped], counts[reviewDeferred], counts[""])))
+	if m.hidden > 0 {
+		b.WriteString(m.line(fmt.Sprintf("%d recommendations skipped before are hidden (--include-skipped)", m.hidden)))
+	}
+	b.WriteString("\n")
+
+	var details []string
+	if m.details {
+		details = recordLines(m.items[m.cursor].record)
+	}
+
+	// Keep the cursor within the rows that fit the terminal
+	rows := len(m.items)
+	if m.height > 0 {
+		rows = max(m.height-6-len(details), 3)
+	}
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	} else if m.cursor >= m.offset+rows {
+		m.offset = m.cursor - rows + 1
+	}
+	m.offset = max(min(m.offset, len(m.items)-rows), 0)
+
+	idWidth := 2
+	for _, item := range m.items {
+		idWidth = max(idWidth, len(item.id))
+	}
+	for i := m.offset; i < len(m.items) && i < m.offset+rows; i++ {
+		item := m.items[i]
+		cursor := "  "
+		if i == m.cursor {
+			cursor = "> "
+		}
+		decision := fmt.Sprintf("%-9s", item.decision)
+		if item.decision == "" {
+			decision = fmt.Sprintf("%-9s", "-")
+		}
+		savings := "-"
+		if value, ok := item.savings.(float64); ok {
+			savings = formatCost(value)
+		}
+		row := m.line(fmt.Sprintf("%s%s  %-*s  %10s  %s", cursor, decision, idWidth, item.id, savings, recordSummary(item.record, item.id)))
+		// The row is cut to the terminal before the decision is colored,
+		// which would otherwise count the color codes as width
+		if m.color && len(row) > len(cursor)+len(decision) {
+			row = cursor + output.ColorizeStatus(decision, item.decision) + row[len(cursor)+len(decision):]
+		}
+		b.WriteString(row)
+	}
+
+	if m.details {
+		b.WriteString("\n")
+		for _, line := range details {
+			b.WriteString(m.line("  " + line))
+		}
+	}
+
+	b.WriteString("\n")
+	if m.confirming {
+		accepted := m.accepted()
+		b.WriteString(m.line(fmt.Sprintf("Apply %d accepted recommendations, saving %s a month? (y/N)", len(accepted), formatCost(savings))))
+	} else {
+		b.WriteString(m.line("↑/↓ move · enter details · a accept · s skip · d defer · u undo · A accept all · q finish · esc quit"))
+	}
+	return b.String()
+}
+
+// line cuts text to the width of the terminal and ends it
+func (m *reviewModel) line(text string) string {
+	if m.width > 0 {
+		if runes := []rune(text); len(runes) > m.width {
+			text = string(runes[:m.width])
+		}
+	}
+	return text + "\n"
+}
+
+// recordSummary returns a one-line description of a recommendation
+func recordSummary(record map[string]interface{}, id string) string {
+	for _, field := range summaryFields {
+		if value, ok := record[field].(string); ok && value != "" && value != id {
+			return value
+		}
+	}
+	return ""
+}
+
+// recordLines lists the fields of a recommendation, one per line
+func recordLines(record map[string]interface{}) []string {
+	keys := make([]string, 0, len(record))
+	for key := range record {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := record[key]
+		text := output.FormatValue(value)
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			data, _ := json.Marshal(value)
+			text = string(data)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", key, text))
+	}
+	return lines
+}
+
+// reviewState remembers the recommendations skipped in reviews
+type reviewState struct {
+	path    string
+	Skipped map[string]time.Time `json:"skipped"`
+}
+
+// reviewStateFile returns the location of the review state
+func reviewStateFile() string {
+	return filepath.Join(config.GetStateDir(), "review.json")
+}
+
+// loadReviewState reads the review state at path; a missing file skipped
+// nothing
+func loadReviewState(path string) (*reviewState, error) {
+	state := &reviewState{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read review state: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("invalid review state file %s: %v", path, err)
+		}
+	}
+	if state.Skipped == nil {
+		state.Skipped = map[string]time.Time{}
+	}
+	return state, nil
+}
+
+// save writes the review state, readable only by the user
+func (s *reviewState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode review state: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	if err := os.WriteFile(s.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write review state: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/pyruntime"
+	"github.com/spf13/cobra"
+)
+
+// systemRuntimeCmd creates the system runtime command
+func systemRuntimeCmd() *cobra.Command {
+	runtimeCmd := &cobra.Command{
+		Use:   "runtime",
+		Short: "Manage the Python runtime",
+		Long: `Show and install the Python runtime that performs UPID analyses.
+
+The runtime is looked up in this order:
+  1. $UPID_HOME/runtime with its own venv (installed by 'upid system runtime install')
+  2. upid-runtime.pyz next to the upid binary
+  3. a runtime directory next to the upid binary
+  4. runtime/upid_runtime.py in the working directory (source checkouts)
+  5. the container image set by runtime_image, if docker is available
+
+Without a runtime, 'cluster list', 'analyze resources', 'analyze idle' and
+'optimize zero-pod --dry-run' use a built-in implementation that reads
+current usage from metrics-server; other commands require the runtime.
+'agent', 'analyze allocation', 'analyze autoscaling', 'analyze baseline',
+'analyze binpack', 'analyze diff', 'analyze network', 'analyze scheduling',
+'analyze workload', 'monitor watch', 'optimize apply --via-pr',
+'optimize approvals', 'optimize resources --export-manifests',
+'optimize rightsize', 'optimize zero-pod --apply' and '--rollback' always
+run built in.
+
+Examples:
+  upid system runtime info                          # Show the runtime in use
+  upid system runtime install                       # Install the matching runtime
+  upid system runtime install --from ./runtime.tar.gz --sha256 <sum>`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return systemRuntimeInfo(cmd, args)
+		},
+	}
+
+	// Add subcommands
+	runtimeCmd.AddCommand(systemRuntimeInfoCmd())
+	runtimeCmd.AddCommand(systemRuntimeInstallCmd())
+
+	return runtimeCmd
+}
+
+// systemRuntimeInfoCmd creates the system runtime info command
+func systemRuntimeInfoCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "info",
+		Short: "Show the Python runtime in use",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return systemRuntimeInfo(cmd, args)
+		},
+	}
+}
+
+// systemRuntimeInstallCmd creates the system runtime install command
+func systemRuntimeInstallCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: "Download and verify the Python runtime",
+		Long: `Download the runtime release matching this CLI, verify its SHA-256 checksum
+and install it with its own virtual environment into $UPID_HOME/runtime.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return systemRuntimeInstall(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("version", "", "runtime version to install (default: the CLI version)")
+	cmd.Flags().String("from", "", "install from this archive URL or local file instead of the release")
+	cmd.Flags().String("sha256", "", "expected SHA-256 of the archive (default: read from <archive>.sha256)")
+	cmd.Flags().Bool("force", false, "replace an installed runtime")
+	cmd.Flags().Bool("skip-deps", false, "do not install Python dependencies")
+
+	return mutating(cmd)
+}
+
+// Implementation functions
+func systemRuntimeInfo(cmd *cobra.Command, args []string) error {
+	home := config.GetHomeDir()
+	rt, err := pyruntime.Discover(pyruntime.Options{
+		Home:   home,
+		Python: config.GetPythonPath(),
+		Image:  config.GetRuntimeImage(),
+	})
+	if err != nil {
+		return clierr.New(clierr.CategoryBridge, "RUNTIME_MISSING", err.Error()).
+			WithHint("Install it with 'upid system runtime install'")
+	}
+
+	result := map[string]interface{}{
+		"message": fmt.Sprintf("Using %s runtime from %s", rt.Kind, rt.Root),
+		"kind":    rt.Kind,
+		"command": strings.Join(append([]string{rt.Command}, rt.Args...), " "),
+		"home":    home,
+	}
+	if version := pyruntime.InstalledVersion(home); version != "" && rt.Kind == pyruntime.KindVenv {
+		result["version"] = version
+	}
+	return renderResult(result)
+}
+
+func systemRuntimeInstall(cmd *cobra.Command, args []string) error {
+	// Get flags
+	version, _ := cmd.Flags().GetString("version")
+	source, _ := cmd.Flags().GetString("from")
+	checksum, _ := cmd.Flags().GetString("sha256")
+	force, _ := cmd.Flags().GetBool("force")
+	skipDeps, _ := cmd.Flags().GetBool("skip-deps")
+
+	if version == "" {
+		version = config.GetVersion()
+	}
+	if source == "" {
+		source = pyruntime.ReleaseURL(config.GetRepository(), version)
+	}
+
+	home := config.GetHomeDir()
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("install runtime %s from %s into %s/runtime", version, source, home))
+	}
+
+	rt, err := pyruntime.Install(cmd.Context(), pyruntime.InstallOptions{
+		Home:     home,
+		Version:  version,
+		Source:   source,
+		Checksum: checksum,
+		Python:   config.GetPythonPath(),
+		SkipDeps: skipDeps,
+		Force:    force,
+		Log:      os.Stderr,
+	})
+	if err != nil {
+		return err
+	}
+
+	return renderResult(map[string]interface{}{
+		"message": fmt.Sprintf("Installed runtime %s in %s", version, rt.Root),
+		"version": version,
+		"kind":    rt.Kind,
+	})
+}
@@ -0,0 +1,577 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/scheduler"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
+	"github.com/spf13/cobra"
+)
+
+// defaultScheduleCommand is what a schedule runs unless --command is given
+const defaultScheduleCommand = "optimize apply --all --confirm"
+
+// maxRunOutput is how much of the end of the output of a run is kept
+const maxRunOutput = 2000
+
+// scheduleColumns are the table columns for schedules
+var scheduleColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "cron", Field: "cron"},
+	{Name: "command", Field: "command"},
+	{Name: "cluster", Field: "cluster", Wide: true},
+	{Name: "enabled", Field: "enabled"},
+	{Name: "next-run", Header: "NEXT RUN", Field: "next_run"},
+	{Name: "last-run", Header: "LAST RUN", Field: "last_run"},
+	{Name: "last-status", Header: "LAST STATUS", Field: "last_status"},
+	{Name: "jitter", Field: "jitter", Wide: true},
+	{Name: "catch-up", Header: "CATCH UP", Field: "catch_up", Wide: true},
+}
+
+// scheduleRunColumns are the table columns for runs of schedules
+var scheduleRunColumns = []output.Column{
+	{Name: "schedule", Field: "schedule"},
+	{Name: "due", Field: "due", Wide: true},
+	{Name: "started", Field: "started"},
+	{Name: "duration", Field: "duration"},
+	{Name: "trigger", Field: "trigger"},
+	{Name: "status", Field: "status"},
+	{Name: "exit-code", Header: "EXIT CODE", Field: "exit_code", Wide: true},
+	{Name: "output", Field: "output", Wide: true},
+}
+
+// optimizeScheduleCmd creates the schedule optimization command
+func optimizeScheduleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schedule [cron-expression]",
+		Short: "Schedule automated optimizations",
+		Long: `Schedule automated optimization runs.
+
+A schedule runs a upid command, 'upid ` + defaultScheduleCommand + `' unless
+--command is given, on a cron expression in local time (default "0 2 * * *",
+daily at 2 AM). Expressions have the five fields minute, hour, day of month,
+month and day of week, with lists, ranges, steps and names, or are one of
+@hourly, @daily, @weekly, @monthly and @yearly. Running the command again
+with the same --name updates the schedule.
+
+Schedules are kept in schedules.json in the state directory and run by 'upid
+optimize schedule serve', which runs in the foreground, e.g. as a systemd
+user service. --jitter delays each run by a random duration of up to its
+value, so that the schedules of many machines do not run at once. Runs due
+while no scheduler was running are caught up once when it starts, or with
+--catch-up=false recorded as missed. The latest runs of every schedule are
+kept with the end of their output.
+
+Examples:
+  upid optimize schedule "0 2 * * *"                           # Apply all recommendations nightly
+  upid optimize schedule @hourly --name idle --command "optimize zero-pod staging --apply"
+  upid optimize schedule "30 1 * * 1-5" --cluster prod --jitter 15m
+  upid optimize schedule list
+  upid optimize schedule run-now idle
+  upid optimize schedule disable idle
+  upid optimize schedule history idle
+  upid optimize schedule serve`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return optimizeSchedule(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("cluster", "", "cluster to schedule for")
+	cmd.Flags().BoolP("enabled", "e", true, "enable the schedule")
+	cmd.Flags().String("name", "", "name of the schedule (default the cluster, or default)")
+	cmd.Flags().String("command", defaultScheduleCommand, "upid command to run, without 'upid'")
+	cmd.Flags().String("jitter", "0s", "delay each run by a random duration of up to this, e.g. 10m")
+	cmd.Flags().Bool("catch-up", true, "run once when the scheduler starts after runs were missed")
+
+	// Add subcommands
+	cmd.AddCommand(withColumns(optimizeScheduleListCmd(), scheduleColumns))
+	cmd.AddCommand(withColumns(optimizeScheduleHistoryCmd(), scheduleRunColumns))
+	cmd.AddCommand(withColumns(optimizeScheduleEnableCmd(true), scheduleColumns))
+	cmd.AddCommand(withColumns(optimizeScheduleEnableCmd(false), scheduleColumns))
+	cmd.AddCommand(withColumns(optimizeScheduleRunNowCmd(), scheduleRunColumns))
+	cmd.AddCommand(optimizeScheduleRemoveCmd())
+	cmd.AddCommand(optimizeScheduleServeCmd())
+
+	return withColumns(mutating(cmd), scheduleColumns)
+}
+
+// optimizeScheduleListCmd creates the schedule list command
+func optimizeScheduleListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the schedules and their next and last runs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return optimizeScheduleList(cmd, args)
+		},
+	}
+}
+
+// optimizeScheduleHistoryCmd creates the schedule history command
+func optimizeScheduleHistoryCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "history [name]",
+		Short: "Show the latest runs of the schedules, newest first",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return optimizeScheduleHistory(cmd, args)
+		},
+	}
+}
+
+// optimizeScheduleEnableCmd creates the schedule enable or disable command
+func optimizeScheduleEnableCmd(enable bool) *cobra.Command {
+	use, short := "enable <name>", "Enable a schedule, from its next time on"
+	if !enable {
+		use, short = "disable <name>", "Disable a schedule"
+	}
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return optimizeScheduleEnable(cmd, args, enable)
+		},
+	}
+
+	return mutating(cmd)
+}
+
+// optimizeScheduleRunNowCmd creates the schedule run-now command
+func optimizeScheduleRunNowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run-now <name>",
+		Short: "Run a schedule right away and record the run",
+		Long: `Run the command of a schedule right away, whether or not it is enabled, and
+record the run in its history. Its next scheduled run is unchanged. The
+command fails if the run does.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return optimizeScheduleRunNow(cmd, args)
+		},
+	}
+
+	return mutating(cmd)
+}
+
+// optimizeScheduleRemoveCmd creates the schedule remove command
+func optimizeScheduleRemoveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove a schedule and its history",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return optimizeScheduleRemove(cmd, args)
+		},
+	}
+
+	return mutating(cmd)
+}
+
+// optimizeScheduleServeCmd creates the schedule serve command
+func optimizeScheduleServeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the schedules when they are due",
+		Long: `Run the enabled schedules when they are due, in the foreground until
+interrupted. Runs are made one at a time by running this upid executable,
+and printed as they finish, as rows or with -o json as JSON lines. Changes
+to the schedules are picked up within a minute. Run a single scheduler per
+state directory.
+
+With --once, the schedules due now are run and the command exits, e.g. to
+run it from the system cron.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return optimizeScheduleServe(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().Bool("once", false, "run the schedules due now and exit")
+
+	return mutating(withColumns(cmd, scheduleRunColumns))
+}
+
+// Implementation functions
+func optimizeSchedule(cmd *cobra.Command, args []string) error {
+	cronExpr := "0 2 * * *" // Default: daily at 2 AM
+	if len(args) > 0 {
+		cronExpr = args[0]
+	}
+
+	// Get flags
+	cluster, _ := cmd.Flags().GetString("cluster")
+	enabled, _ := cmd.Flags().GetBool("enabled")
+	name, _ := cmd.Flags().GetString("name")
+	command, _ := cmd.Flags().GetString("command")
+	jitterFlag, _ := cmd.Flags().GetString("jitter")
+	catchUp, _ := cmd.Flags().GetBool("catch-up")
+
+	if _, err := scheduler.ParseCron(cronExpr); err != nil {
+		return err
+	}
+	jitter, err := timeutil.ParseDuration(jitterFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --jitter %q (expected a duration such as 10m)", jitterFlag)
+	}
+	commandArgs, err := splitShellWords(command)
+	if err != nil {
+		return fmt.Errorf("invalid --command: %v", err)
+	}
+	if len(commandArgs) > 0 && commandArgs[0] == "upid" {
+		commandArgs = commandArgs[1:]
+	}
+	if found, _, err := cmd.Root().Find(commandArgs); err != nil || found == cmd.Root() {
+		return fmt.Errorf("invalid --command %q (expected a upid command such as %q)", command, defaultScheduleCommand)
+	}
+	if name == "" {
+		name = cluster
+	}
+	if name == "" {
+		name = "default"
+	}
+
+	store, err := scheduler.Load(scheduleFile())
+	if err != nil {
+		return err
+	}
+	schedule := &scheduler.Schedule{
+		Name:      name,
+		Cron:      cronExpr,
+		Args:      commandArgs,
+		Cluster:   cluster,
+		Enabled:   enabled,
+		Jitter:    jitter,
+		CatchUp:   catchUp,
+		CreatedAt: time.Now().UTC(),
+	}
+	action := "Created"
+	if existing := store.Get(name); existing != nil {
+		action = "Updated"
+		schedule.CreatedAt = existing.CreatedAt
+		schedule.History = existing.History
+	}
+	if enabled {
+		if err := schedule.Advance(time.Now()); err != nil {
+			return err
+		}
+	}
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("save schedule %s in %s: %s, run 'upid %s'", name, store.Path(), cronExpr, strings.Join(commandArgs, " ")))
+	}
+	store.Put(schedule)
+	if err := store.Save(); err != nil {
+		return err
+	}
+
+	result := scheduleItem(schedule)
+	result["message"] = fmt.Sprintf("%s schedule %s", action, name)
+	if enabled {
+		result["hint"] = "Schedules run while 'upid optimize schedule serve' runs"
+	}
+	if err := renderResult(result); err != nil {
+		return err
+	}
+	printResultWarning(result)
+	return nil
+}
+
+func optimizeScheduleList(cmd *cobra.Command, args []string) error {
+	store, err := scheduler.Load(scheduleFile())
+	if err != nil {
+		return err
+	}
+	items := make([]interface{}, 0, len(store.Schedules))
+	enabled := 0
+	for _, schedule := range store.Schedules {
+		items = append(items, scheduleItem(schedule))
+		if schedule.Enabled {
+			enabled++
+		}
+	}
+	return renderResult(map[string]interface{}{
+		"message":   fmt.Sprintf("%d schedules, %d enabled", len(items), enabled),
+		"file":      store.Path(),
+		"schedules": items,
+	})
+}
+
+func optimizeScheduleHistory(cmd *cobra.Command, args []string) error {
+	store, err := scheduler.Load(scheduleFile())
+	if err != nil {
+		return err
+	}
+	var runs []scheduler.ScheduleRun
+	for _, schedule := range store.Schedules {
+		if len(args) > 0 && schedule.Name != args[0] {
+			continue
+		}
+		for _, run := range schedule.History {
+			runs = append(runs, scheduler.ScheduleRun{Schedule: schedule.Name, Run: run})
+		}
+	}
+	if len(args) > 0 && store.Get(args[0]) == nil {
+		return fmt.Errorf("no schedule named %q, see 'upid optimize schedule list'", args[0])
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Started.After(runs[j].Started) })
+
+	items := make([]interface{}, 0, len(runs))
+	for _, run := range runs {
+		items = append(items, scheduleRunItem(run))
+	}
+	return renderResult(map[string]interface{}{
+		"message": fmt.Sprintf("%d runs", len(items)),
+		"runs":    items,
+	})
+}
+
+func optimizeScheduleEnable(cmd *cobra.Command, args []string, enable bool) error {
+	store, err := scheduler.Load(scheduleFile())
+	if err != nil {
+		return err
+	}
+	schedule := store.Get(args[0])
+	if schedule == nil {
+		return fmt.Errorf("no schedule named %q, see 'upid optimize schedule list'", args[0])
+	}
+	action := "enable"
+	if !enable {
+		action = "disable"
+	}
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("%s schedule %s in %s", action, schedule.Name, store.Path()))
+	}
+
+	schedule.Enabled = enable
+	schedule.Next = time.Time{}
+	if enable {
+		// Runs missed while disabled are not caught up
+		if err := schedule.Advance(time.Now()); err != nil {
+			return err
+		}
+	}
+	if err := store.Save(); err != nil {
+		return err
+	}
+	result := scheduleItem(schedule)
+	result["message"] = fmt.Sprintf("Schedule %s %sd", schedule.Name, action)
+	return renderResult(result)
+}
+
+func optimizeScheduleRunNow(cmd *cobra.Command, args []string) error {
+	store, err := scheduler.Load(scheduleFile())
+	if err != nil {
+		return err
+	}
+	schedule := store.Get(args[0])
+	if schedule == nil {
+		return fmt.Errorf("no schedule named %q, see 'upid optimize schedule list'", args[0])
+	}
+	if IsDryRun() {
+		return printDryRun("upid " + strings.Join(schedule.Args, " "))
+	}
+
+	s := &scheduler.Scheduler{Path: store.Path(), Runner: runScheduledCommand}
+	run, err := s.RunNow(cmd.Context(), schedule.Name)
+	if err != nil {
+		return err
+	}
+	result := scheduleRunItem(*run)
+	result["message"] = fmt.Sprintf("Schedule %s %s", schedule.Name, run.Status)
+	if err := renderResult(result); err != nil {
+		return err
+	}
+	if run.ExitCode != 0 {
+		return clierr.New(clierr.CategoryGeneral, "SCHEDULE_RUN_FAILED",
+			fmt.Sprintf("schedule %s failed with exit code %d", schedule.Name, run.ExitCode))
+	}
+	return nil
+}
+
+func optimizeScheduleRemove(cmd *cobra.Command, args []string) error {
+	store, err := scheduler.Load(scheduleFile())
+	if err != nil {
+		return err
+	}
+	if store.Get(args[0]) == nil {
+		return fmt.Errorf("no schedule named %q, see 'upid optimize schedule list'", args[0])
+	}
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("remove schedule %s from %s", args[0], store.Path()))
+	}
+	store.Remove(args[0])
+	if err := store.Save(); err != nil {
+		return err
+	}
+	return renderResult(map[string]interface{}{"message": fmt.Sprintf("Removed schedule %s", args[0])})
+}
+
+func optimizeScheduleServe(cmd *cobra.Command, args []string) error {
+	// Get flags
+	once, _ := cmd.Flags().GetBool("once")
+
+	format := config.GetOutputFormat()
+	if !once && format != output.FormatTable && format != output.FormatWide && format != output.FormatJSON {
+		return fmt.Errorf("schedule serve supports table, wide and json output, not %q (use --once for other formats)", format)
+	}
+	s := &scheduler.Scheduler{Path: scheduleFile(), Runner: runScheduledCommand}
+	if IsDryRun() {
+		store, err := scheduler.Load(s.Path)
+		if err != nil {
+			return err
+		}
+		var actions []string
+		for _, schedule := range store.Schedules {
+			if schedule.Enabled && !schedule.Next.After(time.Now()) {
+				actions = append(actions, "upid "+strings.Join(schedule.Args, " "))
+			}
+		}
+		return printDryRun(actions...)
+	}
+
+	if once {
+		runs, err := s.RunDue(cmd.Context(), time.Now())
+		if err != nil {
+			return err
+		}
+		items := make([]interface{}, 0, len(runs))
+		failed := 0
+		for _, run := range runs {
+			items = append(items, scheduleRunItem(run))
+			if run.Status != scheduler.StatusSucceeded {
+				failed++
+			}
+		}
+		result := map[string]interface{}{
+			"message": fmt.Sprintf("Ran %d due schedules, %d did not succeed", len(items), failed),
+			"runs":    items,
+		}
+		return renderResult(result)
+	}
+
+	if quiet, _ := activeFlagBool("quiet"); !quiet {
+		fmt.Fprintf(os.Stderr, "Running the schedules in %s, press Ctrl+C to stop\n", s.Path)
+	}
+	return s.Serve(cmd.Context(), printScheduleRun(format))
+}
+
+// runScheduledCommand runs the command of a schedule with this executable,
+// without a terminal to prompt on
+func runScheduledCommand(ctx context.Context, schedule *scheduler.Schedule) (int, string) {
+	exe, err := os.Executable()
+	if err != nil {
+		return -1, fmt.Sprintf("failed to locate the upid executable: %v", err)
+	}
+	command := exec.CommandContext(ctx, exe, schedule.Args...)
+	command.Env = os.Environ()
+	if schedule.Cluster != "" {
+		command.Env = append(command.Env, "UPID_CLUSTER="+schedule.Cluster)
+	}
+	var out bytes.Buffer
+	command.Stdout, command.Stderr = &out, &out
+	err = command.Run()
+
+	text := strings.TrimSpace(out.String())
+	if len(text) > maxRunOutput {
+		text = "..." + text[len(text)-maxRunOutput:]
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode(), text
+	case err != nil:
+		return -1, strings.TrimSpace(text + "\n" + err.Error())
+	}
+	return 0, text
+}
+
+// printScheduleRun returns a function that prints runs as they finish, as
+// JSON lines or as rows under a table header printed with the first row
+func printScheduleRun(format string) func(scheduler.ScheduleRun) {
+	if format == output.FormatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		return func(run scheduler.ScheduleRun) {
+			_ = encoder.Encode(scheduleRunItem(run))
+		}
+	}
+
+	const row = "%-8s  %-20s  %-9s  %-10s  %s\n"
+	header := false
+	return func(run scheduler.ScheduleRun) {
+		if !header {
+			fmt.Printf(row, "TIME", "SCHEDULE", "TRIGGER", "STATUS", "DURATION")
+			header = true
+		}
+		fmt.Printf(row, run.Finished.Local().Format("15:04:05"), run.Schedule, run.Trigger, run.Status, runDuration(run.Run))
+	}
+}
+
+// scheduleItem describes a schedule
+func scheduleItem(schedule *scheduler.Schedule) map[string]interface{} {
+	item := map[string]interface{}{
+		"name":     schedule.Name,
+		"cron":     schedule.Cron,
+		"command":  strings.Join(schedule.Args, " "),
+		"cluster":  schedule.Cluster,
+		"enabled":  schedule.Enabled,
+		"jitter":   schedule.Jitter.String(),
+		"catch_up": schedule.CatchUp,
+		"next_run": nil,
+		"last_run": nil,
+	}
+	if schedule.Cluster == "" {
+		item["cluster"] = config.GetDefaultCluster()
+	}
+	if schedule.Enabled && !schedule.Next.IsZero() {
+		item["next_run"] = schedule.Next.Local().Format(time.RFC3339)
+	}
+	if last := schedule.Last(); last != nil {
+		item["last_run"] = last.Started.Local().Format(time.RFC3339)
+		item["last_status"] = last.Status
+	}
+	return item
+}
+
+// scheduleRunItem describes a run of a schedule
+func scheduleRunItem(run scheduler.ScheduleRun) map[string]interface{} {
+	item := map[string]interface{}{
+		"schedule":  run.Schedule,
+		"due":       nil,
+		"started":   run.Started.Local().Format(time.RFC3339),
+		"duration":  runDuration(run.Run),
+		"trigger":   run.Trigger,
+		"status":    run.Status,
+		"exit_code": run.ExitCode,
+		"output":    run.Output,
+	}
+	if !run.Due.IsZero() {
+		item["due"] = run.Due.Local().Format(time.RFC3339)
+	}
+	return item
+}
+
+// runDuration formats how long a run took
+func runDuration(run scheduler.Run) string {
+	return run.Finished.Sub(run.Started).Round(time.Second).String()
+}
+
+// scheduleFile returns the local file of schedules
+func scheduleFile() string {
+	return filepath.Join(config.GetStateDir(), "schedules.json")
+}
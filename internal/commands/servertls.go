@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/servertls"
+	"github.com/spf13/cobra"
+)
+
+// serverTLSHelp describes the TLS flags of the servers UPID runs
+const serverTLSHelp = `Served with --tls-cert and --tls-key, or --tls-self-signed, the server
+answers HTTPS only. Self-signed certificates cover localhost, the host name
+and the address bound, and are kept in the tls directory of the state
+directory for a year. --tls-acme obtains and renews a certificate of public
+domains from Let's Encrypt, which must reach the server at the domains on
+port 443.`
+
+// addTLSFlags adds the flags of serving over TLS to cmd
+func addTLSFlags(cmd *cobra.Command) {
+	cmd.Flags().String("tls-cert", "", "PEM certificate file to serve HTTPS with")
+	cmd.Flags().String("tls-key", "", "PEM private key file of --tls-cert")
+	cmd.Flags().Bool("tls-self-signed", false, "serve HTTPS with a generated self-signed certificate")
+	cmd.Flags().StringSlice("tls-acme", nil, "public domains to serve HTTPS for with a Let's Encrypt certificate")
+	cmd.Flags().String("tls-acme-email", "", "contact email of the Let's Encrypt account")
+	cmd.MarkFlagsRequiredTogether("tls-cert", "tls-key")
+	cmd.MarkFlagsMutuallyExclusive("tls-cert", "tls-self-signed", "tls-acme")
+}
+
+// serverTLS returns the TLS configuration the flags of cmd give to a server
+// listening on address, nil to serve plain HTTP
+func serverTLS(cmd *cobra.Command, address string) (*tls.Config, error) {
+	// Get flags
+	certFile, _ := cmd.Flags().GetString("tls-cert")
+	keyFile, _ := cmd.Flags().GetString("tls-key")
+	selfSigned, _ := cmd.Flags().GetBool("tls-self-signed")
+	domains, _ := cmd.Flags().GetStringSlice("tls-acme")
+	email, _ := cmd.Flags().GetString("tls-acme-email")
+
+	opts := servertls.Options{
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		SelfSigned: selfSigned,
+		ACMEEmail:  email,
+		Dir:        serverTLSDir(),
+	}
+	for _, domain := range domains {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			opts.ACMEDomains = append(opts.ACMEDomains, domain)
+		}
+	}
+	if email != "" && len(opts.ACMEDomains) == 0 {
+		return nil, fmt.Errorf("invalid --tls-acme-email without --tls-acme")
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if len(opts.ACMEDomains) > 0 && port != "443" {
+		slog.Warn("Let's Encrypt validates the domains on port 443, forward it to the server", "address", address)
+	}
+	config, err := opts.Config(host)
+	if err != nil {
+		return nil, err
+	}
+	if selfSigned {
+		if quiet, _ := activeFlagBool("quiet"); !quiet {
+			fmt.Fprintf(os.Stderr, "Serving HTTPS with the self-signed certificate %s, browsers will warn about it\n", servertls.SelfSignedFile(opts.Dir))
+		}
+	}
+	return config, nil
+}
+
+// serverTLSDir returns the directory of the certificates UPID generates or
+// obtains for its servers
+func serverTLSDir() string {
+	return filepath.Join(config.GetStateDir(), "tls")
+}
+
+// serverScheme returns the scheme of URLs of a server served with config
+func serverScheme(config *tls.Config) string {
+	if config != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,200 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+// defaultSession names the login of the settings outside of profiles
+const defaultSession = "default"
+
+// sessionColumns are the table columns of listed sessions
+var sessionColumns = []output.Column{
+	{Name: "current", Header: " ", Field: "marker"},
+	{Name: "name", Field: "name"},
+	{Name: "user", Field: "user"},
+	{Name: "server", Field: "server"},
+	{Name: "status", Field: "status"},
+	{Name: "expires", Field: "expires_at"},
+}
+
+// authSessionsCmd creates the sessions command
+func authSessionsCmd() *cobra.Command {
+	sessionsCmd := &cobra.Command{
+		Use:   "sessions",
+		Short: "Manage concurrent logins",
+		Long: `Stay logged in to several UPID backends or organizations at once and
+switch between them.
+
+Each session is a configuration profile with its own login. Log in to a new
+session with 'upid auth login --session <name>', switch the default with
+'upid auth sessions use', or use a session for one command with --profile.`,
+		Example: `  # Log in to two customers
+  upid auth login --sso --session acme --issuer https://login.acme.com --client-id upid
+  upid auth login --sso --session globex --issuer https://sso.globex.io --client-id upid
+
+  # Switch between them
+  upid auth sessions list
+  upid auth sessions use acme
+  upid --profile globex analyze cluster`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return authSessionsList(cmd, args)
+		},
+	}
+
+	// Add subcommands
+	sessionsCmd.AddCommand(withColumns(&cobra.Command{
+		Use:   "list",
+		Short: "List sessions",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return authSessionsList(cmd, args)
+		},
+	}, sessionColumns))
+	sessionsCmd.AddCommand(mutating(&cobra.Command{
+		Use:               "use [name]",
+		Short:             "Switch to a session",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeSessions,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return authSessionsUse(cmd, args)
+		},
+	}))
+
+	return withColumns(sessionsCmd, sessionColumns)
+}
+
+// completeSessions completes session names
+func completeSessions(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return append([]string{defaultSession}, config.ProfileNames()...), cobra.ShellCompDirectiveNoFileComp
+}
+
+// sessionProfile returns the profile of a session name
+func sessionProfile(name string) string {
+	if _, exists := config.Profile(name); !exists && name == defaultSession {
+		return ""
+	}
+	return name
+}
+
+// enterSession switches the running command to a session, creating its
+// profile if needed. New sessions keep the identity provider given with
+// --issuer and --client-id.
+func enterSession(cmd *cobra.Command, name string) error {
+	profile := sessionProfile(name)
+	if _, exists := config.Profile(profile); profile != "" && !exists {
+		file, err := config.OpenFile(config.FilePath())
+		if err != nil {
+			return err
+		}
+		if err := file.Set("profiles."+profile, map[string]interface{}{}); err != nil {
+			return err
+		}
+		for key, flag := range map[string]string{"auth.issuer": "issuer", "auth.client_id": "client-id"} {
+			if value, _ := cmd.Flags().GetString(flag); value != "" {
+				if err := file.Set("profiles."+profile+"."+key, value); err != nil {
+					return err
+				}
+			}
+		}
+		if err := file.Save(); err != nil {
+			return err
+		}
+		if err := config.ReadConfigFile(); err != nil {
+			return err
+		}
+	}
+	return config.SelectProfile(profile)
+}
+
+// describeSession summarizes the login of a profile
+func describeSession(profile string) map[string]interface{} {
+	name := profile
+	if name == "" {
+		name = defaultSession
+	}
+	item := map[string]interface{}{
+		"name":    name,
+		"current": profile == config.GetProfile(),
+		"marker":  "",
+		"status":  "logged out",
+	}
+	if profile == config.GetProfile() {
+		item["marker"] = "*"
+	}
+	if settings, ok := config.Profile(profile); ok {
+		if endpoint, ok := settings["endpoint"]; ok {
+			item["server"] = endpoint
+		}
+	}
+
+	session, err := loadProfileSession(profile)
+	if err != nil {
+		item["status"] = "error"
+		item["error"] = err.Error()
+		return item
+	}
+	if session != nil {
+		item["user"] = session.Subject()
+		item["server"] = session.Issuer
+		item["status"] = "logged in"
+		if !session.Expiry.IsZero() {
+			item["expires_at"] = session.Expiry.Format(time.RFC3339)
+		}
+		if session.Expired(0) && session.RefreshToken == "" {
+			item["status"] = "expired"
+		}
+		return item
+	}
+
+	token, err := credentialStore().Get(profileCredentialName(profile, credentialAuthToken))
+	if err != nil && !errors.Is(err, secrets.ErrNotFound) {
+		item["status"] = "error"
+		item["error"] = err.Error()
+	} else if token != "" {
+		item["status"] = "logged in"
+	}
+	return item
+}
+
+// Implementation functions
+func authSessionsList(cmd *cobra.Command, args []string) error {
+	items := []interface{}{describeSession("")}
+	for _, profile := range config.ProfileNames() {
+		items = append(items, describeSession(profile))
+	}
+	return renderResult(map[string]interface{}{
+		"sessions": items,
+	})
+}
+
+func authSessionsUse(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	profile := sessionProfile(name)
+	if _, exists := config.Profile(profile); profile != "" && !exists {
+		return clierr.New(clierr.CategoryUsage, "UNKNOWN_SESSION", fmt.Sprintf("session %q not found", name)).
+			WithHint(fmt.Sprintf("Log in to a new session with 'upid auth login --session %s'", name))
+	}
+
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("make %s the default session in %s", name, config.FilePath()))
+	}
+	if err := setCurrentProfile(profile); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Switched to session %s", name)
+	if describeSession(profile)["status"] != "logged in" {
+		message += ", which is not logged in. Log in with 'upid auth login'"
+	}
+	return renderResult(map[string]interface{}{"message": message})
+}
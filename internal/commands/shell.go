@@ -0,0 +1,461 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
+)
+
+const shellPrompt = "upid> "
+
+// shellRecalled is how many lines the terminal recalls with the arrow keys
+const shellRecalled = 100
+
+// ShellCmd creates the interactive shell command
+func ShellCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "shell",
+		Short: "Interactive UPID shell",
+		Long: `Start an interactive shell that runs multiple UPID commands in one session.
+
+The session shares authentication and the Python bridge between commands,
+keeps a command history and supports tab completion of commands and flags.
+Commands that name a token, password, passphrase, secret or API key are kept
+out of the history file.
+
+Built-in commands:
+  help       Show available commands
+  history    Show command history
+  exit       Leave the shell (also: quit, Ctrl-D)
+
+Examples:
+  upid shell
+  upid> analyze idle --confidence 0.9
+  upid> cluster list -o json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runShell(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().Bool("no-history", false, "do not record commands in the history file")
+
+	return cmd
+}
+
+// Implementation functions
+func runShell(cmd *cobra.Command, args []string) error {
+	noHistory, _ := cmd.Flags().GetBool("no-history")
+
+	root := cmd.Root()
+	history := loadShellHistory()
+	historyFile := shellHistoryPath()
+
+	// Global flags given to the shell itself apply to every command in it
+	startup := shellFlags(root)
+
+	// Keep a single bridge for the whole session
+	inShell = true
+	defer func() { inShell = false }()
+
+	readLine, err := newShellReader(root, history)
+	if err != nil {
+		return err
+	}
+
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			fmt.Println()
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read input: %v", err)
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if !noHistory {
+			history = append(history, line)
+			// Secrets typed in a command stay in the session
+			if !sensitiveShellLine(line) {
+				appendShellHistory(historyFile, line)
+			}
+		}
+
+		words, err := splitShellWords(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			continue
+		}
+		if len(words) > 0 && words[0] == root.Name() {
+			words = words[1:]
+		}
+		if len(words) == 0 {
+			continue
+		}
+
+		switch words[0] {
+		case "exit", "quit":
+			return nil
+		case "history":
+			for i, entry := range history {
+				fmt.Printf("%5d  %s\n", i+1, entry)
+			}
+			continue
+		case "help":
+			if len(words) == 1 {
+				printShellHelp(root)
+				continue
+			}
+		case cmd.Name():
+			fmt.Fprintln(os.Stderr, "Error: already running in the UPID shell")
+			continue
+		}
+
+		resetCommandFlags(root)
+		startup.restore()
+		root.SetArgs(words)
+		root.SilenceUsage = false
+		// Ctrl-C stops the running command, not the shell
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err = root.ExecuteContext(ctx)
+		stop()
+		// Report errors but keep the session alive
+		if err != nil {
+			clierr.Print(os.Stderr, err, clierr.PrintOptions{JSON: IsJSONOutput(), Debug: config.IsDebug()})
+		}
+	}
+}
+
+// newShellReader returns a line reader for the shell. Interactive terminals get
+// line editing, history recalled with the arrow keys, starting with that of
+// earlier sessions, and tab completion; piped input is read line by line so
+// scripts can drive the shell.
+func newShellReader(root *cobra.Command, history []string) (func() (string, error), error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		scanner := bufio.NewScanner(os.Stdin)
+		return func() (string, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.EOF
+			}
+			return scanner.Text(), nil
+		}, nil
+	}
+
+	// The terminal only records the lines it reads, so the history of
+	// earlier sessions is read from it first without being shown. It keeps
+	// the last shellRecalled lines.
+	if len(history) > shellRecalled {
+		history = history[len(history)-shellRecalled:]
+	}
+	screen := &struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(strings.Join(history, "\r") + "\r"), io.Discard}
+	terminal := term.NewTerminal(screen, shellPrompt)
+	for range history {
+		if _, err := terminal.ReadLine(); err != nil {
+			break
+		}
+	}
+	screen.Reader, screen.Writer = os.Stdin, os.Stdout
+	terminal.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		return completeShellLine(root, line, pos)
+	}
+
+	readLine := func() (string, error) {
+		// Only hold the terminal in raw mode while editing, so command
+		// output is rendered normally.
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return "", err
+		}
+		defer term.Restore(fd, state)
+		return terminal.ReadLine()
+	}
+
+	return readLine, nil
+}
+
+// completeShellLine completes the last word of line against the command tree
+func completeShellLine(root *cobra.Command, line string, pos int) (string, int, bool) {
+	head := line[:pos]
+	words := strings.Fields(head)
+	prefix := ""
+	if len(words) > 0 && !strings.HasSuffix(head, " ") {
+		prefix = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	// Walk down to the deepest matching subcommand
+	current := root
+	for _, word := range words {
+		if strings.HasPrefix(word, "-") {
+			continue
+		}
+		if next, _, err := current.Find([]string{word}); err == nil && next != current {
+			current = next
+		}
+	}
+
+	var candidates []string
+	if strings.HasPrefix(prefix, "-") {
+		visit := func(f *pflag.Flag) {
+			name := "--" + f.Name
+			if strings.HasPrefix(name, prefix) {
+				candidates = append(candidates, name)
+			}
+		}
+		current.Flags().VisitAll(visit)
+		current.InheritedFlags().VisitAll(visit)
+	} else {
+		for _, sub := range current.Commands() {
+			if sub.IsAvailableCommand() && strings.HasPrefix(sub.Name(), prefix) {
+				candidates = append(candidates, sub.Name())
+			}
+		}
+		if current == root {
+			for _, builtin := range []string{"exit", "history", "quit"} {
+				if strings.HasPrefix(builtin, prefix) {
+					candidates = append(candidates, builtin)
+				}
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", 0, false
+	}
+	sort.Strings(candidates)
+
+	completion := commonPrefix(candidates)
+	if len(candidates) == 1 {
+		completion += " "
+	} else if completion == prefix {
+		fmt.Printf("\r\n%s\r\n", strings.Join(candidates, "  "))
+	}
+
+	newHead := head[:len(head)-len(prefix)] + completion
+	return newHead + line[pos:], len(newHead), true
+}
+
+// commonPrefix returns the longest prefix shared by all values
+func commonPrefix(values []string) string {
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// startupFlags are the values of the global flags the shell was started
+// with, such as -o json, --profile or --timeout
+type startupFlags map[*pflag.Flag][]string
+
+// shellFlags records the global flags set on the command line of the shell
+func shellFlags(root *cobra.Command) startupFlags {
+	flags := startupFlags{}
+	// The flags were parsed by the flag set of the shell command, which
+	// shares them with the root, so Visit of the root does not see them
+	root.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			flags[f] = slice.GetSlice()
+		} else {
+			flags[f] = []string{f.Value.String()}
+		}
+	})
+	return flags
+}
+
+// restore sets the global flags of the shell again after a reset, as if
+// they were given to each command
+func (s startupFlags) restore() {
+	for f, values := range s {
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			_ = slice.Replace(values)
+		} else {
+			_ = f.Value.Set(values[0])
+		}
+		f.Changed = true
+	}
+}
+
+// resetCommandFlags restores every flag in the command tree to its default so
+// values from a previous shell command do not leak into the next one.
+func resetCommandFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			_ = slice.Replace(sliceDefault(f.DefValue))
+		} else {
+			_ = f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+
+	for _, sub := range cmd.Commands() {
+		resetCommandFlags(sub)
+	}
+}
+
+// sliceDefault parses the default of a slice flag, printed as [a,b] with
+// the values quoted as CSV fields when needed
+func sliceDefault(def string) []string {
+	def = strings.TrimSuffix(strings.TrimPrefix(def, "["), "]")
+	if def == "" {
+		return nil
+	}
+	values, err := csv.NewReader(strings.NewReader(def)).Read()
+	if err != nil {
+		return strings.Split(def, ",")
+	}
+	return values
+}
+
+// splitShellWords splits a command line into words, honouring single quotes,
+// double quotes and backslash escapes.
+func splitShellWords(line string) ([]string, error) {
+	var words []string
+	var current strings.Builder
+	var quote rune
+	inWord := false
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in command line")
+	}
+	if inWord {
+		words = append(words, current.String())
+	}
+	return words, nil
+}
+
+// printShellHelp lists the commands available in the shell
+func printShellHelp(root *cobra.Command) {
+	fmt.Println("Available commands:")
+	for _, sub := range root.Commands() {
+		if !sub.IsAvailableCommand() || sub.Name() == "shell" {
+			continue
+		}
+		fmt.Printf("  %-12s %s\n", sub.Name(), sub.Short)
+	}
+	fmt.Println()
+	fmt.Println("Shell commands:")
+	fmt.Printf("  %-12s %s\n", "history", "Show command history")
+	fmt.Printf("  %-12s %s\n", "exit", "Leave the shell")
+	fmt.Println()
+	fmt.Println("Use \"<command> --help\" for more information about a command.")
+}
+
+// shellHistoryPath returns the location of the persistent shell history
+func shellHistoryPath() string {
+	return filepath.Join(config.GetStateDir(), "shell_history")
+}
+
+// loadShellHistory reads previously recorded shell commands
+func loadShellHistory() []string {
+	data, err := os.ReadFile(shellHistoryPath())
+	if err != nil {
+		return nil
+	}
+
+	var history []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			history = append(history, line)
+		}
+	}
+	return history
+}
+
+// sensitiveWords mark the flags and config keys that take secrets, as in
+// 'auth login --token' or 'config set api_token'
+var sensitiveWords = []string{"token", "password", "passphrase", "secret", "api_key", "api-key", "apikey"}
+
+// sensitiveShellLine returns whether a command line may hold a secret, which
+// is not to be written to the history file
+func sensitiveShellLine(line string) bool {
+	lower := strings.ToLower(line)
+	for _, word := range sensitiveWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendShellHistory records a command in the history file
+func appendShellHistory(path, line string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
@@ -0,0 +1,176 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/reports"
+	"github.com/spf13/cobra"
+)
+
+// sloColumns are the table columns for recommendations with the error
+// budget of their workload
+var sloColumns = []output.Column{
+	{Name: "namespace", Field: "namespace"},
+	{Name: "workload", Field: "workload"},
+	{Name: "kind", Field: "kind", Wide: true},
+	{Name: "action", Field: "action"},
+	{Name: "savings", Header: "SAVINGS", Field: "savings"},
+	{Name: "slo", Header: "SLO", Field: "slo"},
+	{Name: "objective", Header: "OBJECTIVE %", Field: "objective", Wide: true},
+	{Name: "budget", Header: "BUDGET LEFT %", Field: "budget_remaining"},
+	{Name: "burn-rate", Header: "BURN RATE", Field: "burn_rate"},
+	{Name: "headroom", Field: "headroom"},
+}
+
+// headroomColumns are the table columns for recommendations summarized by
+// error budget headroom
+var headroomColumns = []output.Column{
+	{Name: "headroom", Field: "headroom"},
+	{Name: "recommendations", Field: "recommendations"},
+	{Name: "savings", Header: "SAVINGS", Field: "savings"},
+}
+
+// reportSLO generates the SLO-aware efficiency report of a cluster and
+// writes it to a standalone HTML or PDF file
+func reportSLO(cmd *cobra.Command, cluster, timeRange, format string) error {
+	// Get flags
+	confidence, _ := cmd.Flags().GetFloat64("confidence")
+	minBudget, _ := cmd.Flags().GetFloat64("min-budget")
+	outputFile, _ := cmd.Flags().GetString("output-file")
+
+	if format != "html" && format != "pdf" {
+		return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("invalid slo format %q (expected pdf or html)", format))
+	}
+	if confidence < 0 || confidence > 1 {
+		return fmt.Errorf("invalid --confidence %g (expected 0 to 1)", confidence)
+	}
+	if minBudget < 0 || minBudget > 100 {
+		return fmt.Errorf("invalid --min-budget %g (expected 0 to 100)", minBudget)
+	}
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return err
+	}
+	client, window, err := nativeClientFor(cluster, timeRange)
+	if err != nil {
+		return err
+	}
+	result, err := priced(client.SLOReport(cmd.Context(), native.SLOReportOptions{
+		Window:        window,
+		MinConfidence: confidence,
+		Prices:        prices,
+		MinBudget:     minBudget / 100,
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to execute report command: %w", err)
+	}
+
+	var b bytes.Buffer
+	if format == "pdf" {
+		err = output.WritePDFReport(&b, sloReport(result))
+	} else {
+		err = output.WriteHTML(&b, sloReport(result))
+	}
+	if err != nil {
+		return err
+	}
+	if outputFile == "" {
+		outputFile = fmt.Sprintf("upid-slo-%s-%s.%s", fileSafe(client.Context()), time.Now().Format("2006-01-02"), format)
+	}
+	id := keepReport(&reports.Report{Type: "slo", Context: client.Context(), Period: timeRange, Format: format}, outputFile, b.Bytes())
+	if outputFile == "-" {
+		_, err := os.Stdout.Write(b.Bytes())
+		return err
+	}
+	result["report_id"] = id
+	result["file"] = outputFile
+	if err := renderSections(result, []section{{"summary", headroomColumns}, {"recommendations", sloColumns}}); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputFile, b.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", outputFile, err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote the report to %s\n", outputFile)
+	return nil
+}
+
+// sloReport lays out the SLO-aware efficiency report: the savings by error
+// budget headroom and each recommendation with the budget of its workload
+func sloReport(result map[string]interface{}) output.HTMLReport {
+	list := func(value interface{}) []map[string]interface{} {
+		values, _ := value.([]interface{})
+		items := make([]map[string]interface{}, 0, len(values))
+		for _, v := range values {
+			if item, ok := v.(map[string]interface{}); ok {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	cost := func(value interface{}) string {
+		v, _ := value.(float64)
+		return formatCost(v)
+	}
+	optional := func(value interface{}, format string) string {
+		if value == nil {
+			return "-"
+		}
+		return fmt.Sprintf(format, value)
+	}
+
+	report := output.HTMLReport{
+		Title: fmt.Sprintf("SLO-aware efficiency of %s", result["context"]),
+		Subtitle: fmt.Sprintf("Generated %s. Savings are monthly, in %s. A workload has headroom when at least %v%% of the error budget of its SLOs is left and it is not burning faster than the period allows.",
+			time.Now().Format("January 2, 2006 15:04 MST"), result["currency"], result["min_budget"]),
+		Footer: fmt.Sprintf("Generated by UPID %s", config.GetVersion()),
+	}
+	notes := map[string]string{
+		native.HeadroomAvailable: "apply",
+		native.HeadroomLimited:   "apply with care",
+		native.HeadroomExhausted: "defer until the budget recovers",
+		native.HeadroomUnknown:   "no SLO",
+	}
+	summary := output.HTMLSection{
+		Title: "Savings by headroom",
+		Chart: &output.BarChart{},
+		Table: &output.HTMLTable{Columns: []string{"Headroom", "Recommendations", "Savings"}, Numeric: []bool{false, true, true}},
+	}
+	for _, item := range list(result["summary"]) {
+		headroom := fmt.Sprint(item["headroom"])
+		report.Figures = append(report.Figures, output.HTMLFigure{
+			Label: headroom, Value: cost(item["savings"]), Note: fmt.Sprintf("%v recommendations, %s", item["recommendations"], notes[headroom]),
+		})
+		summary.Chart.Bars = append(summary.Chart.Bars, output.Bar{Label: headroom, Values: []float64{item["savings"].(float64)}, Text: cost(item["savings"])})
+		summary.Table.Rows = append(summary.Table.Rows, []string{headroom, output.FormatValue(item["recommendations"]), cost(item["savings"])})
+	}
+
+	recommendations := output.HTMLSection{
+		Title: "Recommendations",
+		Text:  "Idle workloads scaled to zero and those using less than half their requests right-sized, with the SLO of their workload that has the least budget left.",
+		Table: &output.HTMLTable{
+			Columns: []string{"Namespace", "Workload", "Action", "Savings", "SLO", "Objective", "Budget left", "Burn rate", "Headroom"},
+			Numeric: []bool{false, false, false, true, false, true, true, true, false},
+		},
+	}
+	for _, item := range list(result["recommendations"]) {
+		recommendations.Table.Rows = append(recommendations.Table.Rows, []string{
+			fmt.Sprint(item["namespace"]), fmt.Sprint(item["workload"]), fmt.Sprint(item["action"]), cost(item["savings"]),
+			optional(item["slo"], "%v"), optional(item["objective"], "%v%%"), optional(item["budget_remaining"], "%v%%"),
+			optional(item["burn_rate"], "%vx"), fmt.Sprint(item["headroom"]),
+		})
+	}
+	if len(recommendations.Table.Rows) == 0 {
+		recommendations.Text = "No workload is idle or uses less than half its requests."
+		recommendations.Table = nil
+	}
+
+	report.Sections = []output.HTMLSection{summary, recommendations}
+	return report
+}
@@ -0,0 +1,250 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/bridge"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/pyruntime"
+	"github.com/spf13/cobra"
+)
+
+// snapshotEnv names the file holding the cluster data collected for the
+// runtime, which then analyzes it instead of reading the cluster itself
+const snapshotEnv = "UPID_CLUSTER_SNAPSHOT"
+
+// snapshotTimeout limits the collection of cluster data for the runtime
+const snapshotTimeout = 30 * time.Second
+
+// snapshotGroups are the command groups that work on collected cluster data
+var snapshotGroups = map[string]bool{"analyze": true, "optimize": true}
+
+// provideSnapshot collects the cluster data of the current kubeconfig
+// context and passes it to the runtime. When collection fails the runtime
+// reads the cluster itself. The returned function removes the data.
+func provideSnapshot(ctx context.Context, pb *bridge.PythonBridge, command string) func() {
+	pb.SetEnv(snapshotEnv, "")
+	if !snapshotGroups[command] {
+		return func() {}
+	}
+	if rt := pb.Runtime(); rt != nil && rt.Kind == pyruntime.KindContainer {
+		// The container cannot read files of the host
+		return func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+	path, err := writeSnapshot(ctx, command == "optimize")
+	if err != nil {
+		slog.Warn("failed to collect cluster data, the runtime reads the cluster itself", "error", err)
+		return func() {}
+	}
+	pb.SetEnv(snapshotEnv, path)
+	return func() {
+		pb.SetEnv(snapshotEnv, "")
+		os.Remove(path)
+	}
+}
+
+// writeSnapshot collects the cluster data to a private temporary file. For
+// optimizations, the workloads excluded from them are left out.
+func writeSnapshot(ctx context.Context, optimize bool) (string, error) {
+	client, err := kube.NewClient("")
+	if err != nil {
+		return "", err
+	}
+	snapshot, err := client.Collect(ctx, kube.CollectOptions{Events: true, Metrics: true})
+	if err != nil {
+		return "", err
+	}
+	if optimize {
+		excluded, err := exclusions()
+		if err != nil {
+			return "", err
+		}
+		snapshot = snapshot.WithoutExcluded(excluded)
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return "", err
+	}
+
+	dir := config.GetCacheDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(dir, "snapshot-*.json")
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	slog.Debug("collected cluster data", "context", snapshot.Context, "nodes", len(snapshot.Nodes),
+		"pods", len(snapshot.Pods), "workloads", len(snapshot.Workloads), "file", file.Name())
+	return file.Name(), nil
+}
+
+// snapshotColumns are the table columns for collected workloads
+var snapshotColumns = []output.Column{
+	{Name: "namespace", Field: "namespace"},
+	{Name: "kind", Field: "kind"},
+	{Name: "name", Field: "name"},
+	{Name: "replicas", Field: "replicas"},
+	{Name: "ready", Field: "ready_replicas"},
+	{Name: "pods", Field: "pods"},
+	{Name: "cpu", Header: "CPU REQUEST", Field: "cpu_request"},
+	{Name: "memory", Header: "MEMORY REQUEST MIB", Field: "memory_request_mib"},
+	{Name: "cpu-usage", Header: "CPU USAGE", Field: "cpu_usage", Wide: true},
+	{Name: "memory-usage", Header: "MEMORY USAGE MIB", Field: "memory_usage_mib", Wide: true},
+	{Name: "restarts", Field: "restarts", Wide: true},
+}
+
+// clusterSnapshotCmd creates the cluster snapshot command
+func clusterSnapshotCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Show the data collected from a cluster",
+		Long: `Read nodes, pods, workloads and recent events directly from the Kubernetes
+API and show the workloads with the requests of their pods, and their current
+usage when metrics-server is installed.
+
+This is the data analyze and optimize commands work on. It is collected with
+the kubeconfig credentials and does not need the Python runtime.
+
+Examples:
+  upid cluster snapshot                          # Current kubeconfig context
+  upid cluster snapshot -n shop --context prod   # One namespace of another context
+  upid cluster snapshot --events -o json         # Everything, including events`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return clusterSnapshot(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", "", "namespace (default all namespaces)")
+	cmd.Flags().StringP("context", "x", "", "kubernetes context (default current context)")
+	cmd.Flags().Bool("events", false, "include the events of the last hour")
+
+	return withColumns(cmd, snapshotColumns)
+}
+
+func clusterSnapshot(cmd *cobra.Command, args []string) error {
+	namespace, _ := cmd.Flags().GetString("namespace")
+	kubeContext, _ := cmd.Flags().GetString("context")
+	events, _ := cmd.Flags().GetBool("events")
+
+	client, err := kube.NewClient(kubeContext)
+	if err != nil {
+		return err
+	}
+	snapshot, err := client.Collect(cmd.Context(), kube.CollectOptions{Namespace: namespace, Events: events, Metrics: true})
+	if err != nil {
+		return err
+	}
+	result := snapshotResult(snapshot)
+	if err := renderResult(result); err != nil {
+		return err
+	}
+	printResultWarning(result)
+	return nil
+}
+
+// snapshotResult summarizes collected cluster data per workload
+func snapshotResult(snapshot *kube.Snapshot) map[string]interface{} {
+	type totals struct {
+		pods     int
+		requests kube.Resources
+		usage    kube.Resources
+		restarts int32
+	}
+	byWorkload := map[string]*totals{}
+	for i := range snapshot.Pods {
+		pod := &snapshot.Pods[i]
+		key := pod.Namespace + "/" + pod.Workload.Kind + "/" + pod.Workload.Name
+		t, ok := byWorkload[key]
+		if !ok {
+			t = &totals{}
+			byWorkload[key] = t
+		}
+		t.pods++
+		t.requests = t.requests.Add(pod.Requests())
+		if pod.Usage != nil {
+			t.usage = t.usage.Add(*pod.Usage)
+		}
+		t.restarts += pod.Restarts()
+	}
+
+	var allocatable kube.Resources
+	for _, node := range snapshot.Nodes {
+		allocatable = allocatable.Add(node.Allocatable)
+	}
+	var requested kube.Resources
+	workloads := make([]interface{}, 0, len(snapshot.Workloads))
+	for _, w := range snapshot.Workloads {
+		t := byWorkload[w.Namespace+"/"+w.Kind+"/"+w.Name]
+		if t == nil {
+			t = &totals{}
+		}
+		requested = requested.Add(t.requests)
+		item := map[string]interface{}{
+			"namespace":          w.Namespace,
+			"kind":               w.Kind,
+			"name":               w.Name,
+			"ready_replicas":     w.ReadyReplicas,
+			"pods":               t.pods,
+			"cpu_request":        fmt.Sprintf("%.3f", t.requests.CPU),
+			"memory_request_mib": fmt.Sprintf("%.0f", t.requests.Memory/(1<<20)),
+			"restarts":           t.restarts,
+		}
+		if w.Replicas != nil {
+			item["replicas"] = *w.Replicas
+		}
+		if snapshot.Metrics.Available {
+			item["cpu_usage"] = fmt.Sprintf("%.3f", t.usage.CPU)
+			item["memory_usage_mib"] = fmt.Sprintf("%.0f", t.usage.Memory/(1<<20))
+		}
+		workloads = append(workloads, item)
+	}
+
+	result := map[string]interface{}{
+		"message": fmt.Sprintf("Collected %d nodes, %d pods and %d workloads from context %s",
+			len(snapshot.Nodes), len(snapshot.Pods), len(snapshot.Workloads), snapshot.Context),
+		"context":            snapshot.Context,
+		"collected_at":       snapshot.CollectedAt.Format(time.RFC3339),
+		"nodes":              snapshot.Nodes,
+		"pods":               snapshot.Pods,
+		"workloads":          workloads,
+		"allocatable_cpu":    fmt.Sprintf("%.2f", allocatable.CPU),
+		"allocatable_memory": fmt.Sprintf("%.1f GiB", allocatable.Memory/(1<<30)),
+		"requested_cpu":      fmt.Sprintf("%.2f", requested.CPU),
+		"requested_memory":   fmt.Sprintf("%.1f GiB", requested.Memory/(1<<30)),
+	}
+	if snapshot.Namespace != "" {
+		result["namespace"] = snapshot.Namespace
+	}
+	if snapshot.Events != nil {
+		result["events"] = snapshot.Events
+	}
+	if err := snapshot.MetricsError(); err != nil {
+		metricsErr := clierr.From(err)
+		result["warning"] = "usage unknown, " + metricsErr.Message
+		result["hint"] = metricsErr.Hint
+	}
+	return result
+}
@@ -0,0 +1,158 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/oidc"
+	"github.com/kubilitics/upid-cli/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+// ssoLoginTimeout bounds the time to complete a login in the browser
+const ssoLoginTimeout = 5 * time.Minute
+
+// ssoProvider discovers the configured OpenID provider, with --issuer and
+// --client-id taking precedence over the configuration
+func ssoProvider(cmd *cobra.Command) (*oidc.Provider, error) {
+	auth := config.GetAuthConfig()
+	if issuer, _ := cmd.Flags().GetString("issuer"); issuer != "" {
+		auth.Issuer = issuer
+	}
+	if clientID, _ := cmd.Flags().GetString("client-id"); clientID != "" {
+		auth.ClientID = clientID
+	}
+	return oidc.Discover(cmd.Context(), auth.Issuer, auth.ClientID, strings.Fields(auth.Scopes))
+}
+
+// loginSSO signs in with the identity provider, in the browser or with the
+// device code flow on machines without one
+func loginSSO(cmd *cobra.Command, device bool) error {
+	if IsDryRun() {
+		if device {
+			return printDryRun("show a code to sign in with the identity provider on another device, then store the session")
+		}
+		return printDryRun("open the browser to sign in with the identity provider, then store the session")
+	}
+	session, err := ssoLogin(cmd, device)
+	if err != nil {
+		return err
+	}
+	return saveSession(session)
+}
+
+// ssoLogin runs a login flow with the identity provider, printing its
+// instructions to standard error
+func ssoLogin(cmd *cobra.Command, device bool) (*oidc.Session, error) {
+	provider, err := ssoProvider(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if device {
+		// The user code expires on its own, no further limit is needed
+		return provider.DeviceLogin(cmd.Context(), os.Stderr)
+	}
+	ctx, cancel := context.WithTimeout(cmd.Context(), ssoLoginTimeout)
+	defer cancel()
+	return provider.BrowserLogin(ctx, os.Stderr)
+}
+
+// saveSession stores an SSO session of the active profile and reports the
+// signed-in user. The access token is also passed to the runtime.
+func saveSession(session *oidc.Session) error {
+	backend, err := storeSession(session)
+	if err != nil {
+		return err
+	}
+
+	result := map[string]interface{}{
+		"authenticated": true,
+		"message":       fmt.Sprintf("Logged in to %s as %s", session.Issuer, session.Subject()),
+		"issuer":        session.Issuer,
+		"user":          session.Subject(),
+		"token_store":   backend,
+	}
+	if !session.Expiry.IsZero() {
+		result["expires_at"] = session.Expiry.Format(time.RFC3339)
+	}
+	if session.RefreshToken == "" {
+		result["warning"] = "the identity provider issued no refresh token, log in again when the session expires"
+	}
+	return renderResult(result)
+}
+
+// sessionRefreshMargin refreshes sessions this long before they expire, so
+// tokens handed out stay valid while they are used
+const sessionRefreshMargin = time.Minute
+
+// storeSession saves an SSO session of the active profile, and its access
+// token for the runtime, and returns where they were stored
+func storeSession(session *oidc.Session) (string, error) {
+	encoded, err := session.Encode()
+	if err != nil {
+		return "", err
+	}
+	backend, err := storeCredential(credentialAuthSession, encoded)
+	if err != nil {
+		return "", err
+	}
+	if _, err := storeCredential(credentialAuthToken, session.AccessToken); err != nil {
+		return "", err
+	}
+	return backend, nil
+}
+
+// loadSession returns the stored SSO session of the active profile, nil if
+// the profile has none
+func loadSession() (*oidc.Session, error) {
+	return loadProfileSession(config.GetProfile())
+}
+
+// loadProfileSession returns the stored SSO session of a profile, nil if the
+// profile has none
+func loadProfileSession(profile string) (*oidc.Session, error) {
+	encoded, err := credentialStore().Get(profileCredentialName(profile, credentialAuthSession))
+	if errors.Is(err, secrets.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stored session: %w", err)
+	}
+	return oidc.ParseSession(encoded)
+}
+
+// currentSession returns the SSO session of the active profile, refreshing
+// and storing it again if its tokens are about to expire
+func currentSession(ctx context.Context) (*oidc.Session, error) {
+	session, err := loadSession()
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, clierr.New(clierr.CategoryAuth, "NOT_LOGGED_IN", "not logged in with single sign-on").
+			WithHint("Run 'upid auth login --sso' or 'upid auth login --device'")
+	}
+
+	idExpiry := session.IDTokenExpiry()
+	if !session.Expired(sessionRefreshMargin) && (idExpiry.IsZero() || time.Until(idExpiry) > sessionRefreshMargin) {
+		return session, nil
+	}
+	provider, err := oidc.Discover(ctx, session.Issuer, session.ClientID, strings.Fields(config.GetAuthConfig().Scopes))
+	if err != nil {
+		return nil, err
+	}
+	refreshed, err := provider.Refresh(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := storeSession(refreshed); err != nil {
+		return nil, err
+	}
+	return refreshed, nil
+}
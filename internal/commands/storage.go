@@ -1,9 +1,19 @@
 package commands
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"time"
 
+	"github.com/kubilitics/upid-cli/internal/billing"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // StorageCmd creates the storage command
@@ -14,9 +24,17 @@ func StorageCmd() *cobra.Command {
 		Long: `Storage analysis and optimization for Kubernetes clusters.
 
 Examples:
-  upid storage analyze my-cluster          # Analyze storage usage
-  upid storage volumes my-cluster          # List storage volumes
-  upid storage optimize my-cluster         # Optimize storage costs`,
+  upid storage analyze my-cluster                # Analyze storage usage
+  upid storage analyze --performance             # IOPS and throughput of volumes
+  upid storage volumes my-cluster                # List storage volumes
+  upid storage optimize my-cluster               # Optimize storage costs
+  upid storage costs --backups                   # Volume and Velero backup costs
+  upid storage rightsize -t 14d                  # Shrink or expand claims to their usage
+  upid storage cleanup                           # List orphaned volumes
+  upid storage snapshots --max-age 90d           # Plan deleting old snapshots
+  upid storage stale -e stale.yaml               # Unused ConfigMaps, Secrets, claims, Services
+  upid storage migrate-plan --from gp2 --to gp3  # Plan moving volumes to gp3
+  upid storage images                            # Image sizes and unused cached images`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return storageAnalyze(cmd, args)
 		},
@@ -28,17 +46,69 @@ Examples:
 	storageCmd.AddCommand(storageOptimizeCmd())
 	storageCmd.AddCommand(storageCostsCmd())
 	storageCmd.AddCommand(storageRecommendationsCmd())
+	storageCmd.AddCommand(storageRightsizeCmd())
+	storageCmd.AddCommand(storageCleanupCmd())
+	storageCmd.AddCommand(storageSnapshotsCmd())
+	storageCmd.AddCommand(storageStaleCmd())
+	storageCmd.AddCommand(storageMigratePlanCmd())
+	storageCmd.AddCommand(storageImagesCmd())
 
 	return storageCmd
 }
 
+// storageAnalysisColumns are the table columns for the built-in storage
+// analysis
+var storageAnalysisColumns = []output.Column{
+	{Name: "claim", Field: "claim"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "volume", Field: "name", Wide: true},
+	{Name: "volume-id", Header: "VOLUME ID", Field: "volume_id", Wide: true},
+	{Name: "class", Header: "STORAGE CLASS", Field: "storage_class", Wide: true},
+	{Name: "type", Field: "type"},
+	{Name: "capacity", Header: "CAPACITY GIB", Field: "capacity_gib"},
+	{Name: "utilization", Header: "UTILIZATION %", Field: "utilization", Wide: true},
+	{Name: "iops", Header: "IOPS", Field: "iops"},
+	{Name: "peak-iops", Header: "PEAK IOPS", Field: "peak_iops"},
+	{Name: "avg-iops", Header: "AVG IOPS", Field: "avg_iops", Wide: true},
+	{Name: "throughput", Header: "MIB/S", Field: "throughput", Wide: true},
+	{Name: "peak-throughput", Header: "PEAK MIB/S", Field: "peak_throughput", Wide: true},
+	{Name: "throttled", Header: "THROTTLED MIN", Field: "throttled_minutes", Wide: true},
+	{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+	{Name: "finding", Field: "finding"},
+	{Name: "recommendation", Field: "recommendation", Wide: true},
+	{Name: "cost-change", Header: "MONTHLY COST CHANGE", Field: "monthly_cost_change"},
+}
+
 // storageAnalyzeCmd creates the storage analyze command
 func storageAnalyzeCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "analyze [cluster-id]",
 		Short: "Analyze storage usage",
-		Long:  "Analyze storage usage patterns and identify optimization opportunities",
-		Args:  cobra.ExactArgs(1),
+		Long: `Analyze storage usage patterns and identify optimization opportunities.
+
+With --performance, or without the Python runtime, the built-in analysis
+prices the persistent volumes of the claims and compares the IOPS and
+throughput of their EBS volumes over --time-range against their baseline:
+
+  throttled         the volume peaked near its baseline or was held to it,
+                    it is recommended more IOPS or throughput
+  over-provisioned  the volume pays for IOPS (io1, io2, gp3 above 3000) or
+                    throughput (gp3 above 125 MiB/s) it peaks below half of,
+                    it is recommended gp3 sized to its peak
+  gp2               the volume is recommended gp3 of the same baseline,
+                    which costs less
+
+The I/O is read from the metrics of the node plugin of the EBS CSI driver
+(aws_ebs_csi_*) at the configured datasource, and for volumes it has none
+of from CloudWatch with the AWS credentials of pricing.aws_profile in the
+region of the nodes, or --region; --cloud=false skips CloudWatch. EBS
+volumes are priced at the us-east-1 list prices, others at --storage-price.
+
+Examples:
+  upid storage analyze my-cluster                    # Analyze storage usage
+  upid storage analyze --performance -t 14d          # IOPS and throughput of volumes
+  upid storage analyze --performance -n db -o wide   # With recommendations`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return storageAnalyze(cmd, args)
 		},
@@ -47,10 +117,14 @@ func storageAnalyzeCmd() *cobra.Command {
 	// Add flags
 	cmd.Flags().StringP("namespace", "n", "", "namespace to analyze")
 	cmd.Flags().StringP("time-range", "t", "7d", "time range for analysis")
-	cmd.Flags().BoolP("detailed", "d", false, "detailed analysis")
-	cmd.Flags().BoolP("include-costs", "c", false, "include cost analysis")
+	cmd.Flags().Bool("detailed", false, "detailed analysis")
+	cmd.Flags().Bool("include-costs", false, "include cost analysis")
+	cmd.Flags().Bool("performance", false, "analyze the IOPS and throughput of the volumes with the built-in analysis")
+	cmd.Flags().Bool("cloud", true, "read the I/O of EBS volumes the datasource has no metrics of from CloudWatch")
+	cmd.Flags().String("region", "", "AWS region of the volumes (default that of the nodes)")
+	cmd.Flags().Float64("storage-price", 0, "price per GiB-month of volumes that are not EBS volumes (default pricing.storage)")
 
-	return cmd
+	return cacheable(withColumns(cmd, storageAnalysisColumns))
 }
 
 // storageVolumesCmd creates the storage volumes command
@@ -69,9 +143,9 @@ func storageVolumesCmd() *cobra.Command {
 	cmd.Flags().StringP("namespace", "n", "", "namespace to filter")
 	cmd.Flags().StringP("type", "t", "", "storage type filter")
 	cmd.Flags().BoolP("unused", "u", false, "show only unused volumes")
-	cmd.Flags().BoolP("orphaned", "o", false, "show orphaned volumes")
+	cmd.Flags().Bool("orphaned", false, "show orphaned volumes")
 
-	return cmd
+	return cacheable(cmd)
 }
 
 // storageOptimizeCmd creates the storage optimize command
@@ -90,9 +164,60 @@ func storageOptimizeCmd() *cobra.Command {
 	cmd.Flags().BoolP("simulate", "s", false, "simulate optimization without applying")
 	cmd.Flags().BoolP("aggressive", "a", false, "apply aggressive optimization")
 	cmd.Flags().StringP("strategy", "", "balanced", "optimization strategy")
-	cmd.Flags().BoolP("include-orphaned", "o", false, "include orphaned volumes")
+	cmd.Flags().Bool("include-orphaned", false, "include orphaned volumes")
+
+	return mutating(cmd)
+}
 
-	return cmd
+// storageCostSections are the lists of the built-in storage cost analysis
+// with their table columns, in the order they are shown
+var storageCostSections = []section{
+	{"breakdown", []output.Column{
+		{Name: "group", Field: "group"},
+		{Name: "volumes", Field: "volumes"},
+		{Name: "capacity", Header: "CAPACITY GIB", Field: "capacity_gib"},
+		{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+		{Name: "share", Header: "SHARE %", Field: "share"},
+	}},
+	{"persistent_volumes", []output.Column{
+		{Name: "claim", Field: "claim"},
+		{Name: "namespace", Field: "namespace"},
+		{Name: "volume", Field: "name", Wide: true},
+		{Name: "class", Header: "STORAGE CLASS", Field: "storage_class"},
+		{Name: "type", Field: "type"},
+		{Name: "capacity", Header: "CAPACITY GIB", Field: "capacity_gib"},
+		{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+	}},
+	{"backup_namespaces", []output.Column{
+		{Name: "namespace", Field: "namespace"},
+		{Name: "backups", Field: "backups"},
+		{Name: "backup-gib", Header: "BACKUP GIB", Field: "backup_gib"},
+		{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+	}},
+	{"backup_schedules", []output.Column{
+		{Name: "name", Field: "name"},
+		{Name: "schedule", Field: "schedule", Wide: true},
+		{Name: "ttl", Header: "TTL", Field: "ttl"},
+		{Name: "interval", Field: "interval", Wide: true},
+		{Name: "backups", Field: "backups"},
+		{Name: "retained", Header: "AT RETENTION", Field: "retained_backups", Wide: true},
+		{Name: "backup-gib", Header: "BACKUP GIB", Field: "backup_gib"},
+		{Name: "growth", Header: "GROWTH GIB", Field: "growth_gib"},
+		{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+		{Name: "projected-cost", Header: "PROJECTED COST", Field: "projected_cost"},
+		{Name: "failed", Field: "failed"},
+		{Name: "status", Header: "LAST STATUS", Field: "last_status", Wide: true},
+		{Name: "flags", Field: "flags"},
+	}},
+	{"failed_backups", []output.Column{
+		{Name: "name", Field: "name"},
+		{Name: "schedule", Field: "schedule"},
+		{Name: "phase", Field: "phase"},
+		{Name: "started", Field: "started"},
+		{Name: "errors", Field: "errors"},
+		{Name: "warnings", Field: "warnings", Wide: true},
+		{Name: "reason", Field: "reason", Wide: true},
+	}},
 }
 
 // storageCostsCmd creates the storage costs command
@@ -100,19 +225,47 @@ func storageCostsCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "costs [cluster-id]",
 		Short: "Analyze storage costs",
-		Long:  "Analyze storage costs and cost optimization opportunities",
-		Args:  cobra.ExactArgs(1),
+		Long: `Analyze storage costs and cost optimization opportunities.
+
+With --backups, or without the Python runtime, the built-in analysis prices
+the persistent volumes of the claims, grouped by --group-by. EBS volumes are
+priced at the us-east-1 list prices, others at --storage-price.
+
+With --backups, it also analyzes the Velero backups (velero.io/v1):
+
+  backup namespaces  the volume data the kept backups uploaded to their
+                     backup storage locations by file system backup or
+                     snapshot data movement, priced at --backup-price
+  backup schedules   the data each schedule keeps now and once its TTL is
+                     full at the interval between its backups, flagged
+                     paused, failing or stale when overdue
+  failed backups     backups that failed or partially failed within
+                     --time-range
+
+Velero deduplicates the data of file system backups, so backup costs are
+upper bounds. Volumes backed up by snapshot are priced by 'upid storage
+snapshots'.
+
+Examples:
+  upid storage costs my-cluster                  # Storage costs by namespace
+  upid storage costs --backups -t 7d             # With Velero backups
+  upid storage costs --backups -g class -o wide  # By storage class`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return storageCosts(cmd, args)
 		},
 	}
 
 	// Add flags
+	cmd.Flags().StringP("namespace", "n", "", "namespace of the claims and backed up volumes (default all namespaces)")
 	cmd.Flags().StringP("time-range", "t", "30d", "time range for cost analysis")
-	cmd.Flags().BoolP("detailed", "d", false, "detailed cost breakdown")
+	cmd.Flags().Bool("detailed", false, "detailed cost breakdown")
 	cmd.Flags().StringP("group-by", "g", "namespace", "group costs by (namespace, type, class)")
+	cmd.Flags().Bool("backups", false, "analyze the cost of Velero backups with the built-in analysis")
+	cmd.Flags().Float64("backup-price", native.DefaultBackupPrice, "price per GB-month of the backup storage locations")
+	cmd.Flags().Float64("storage-price", 0, "price per GiB-month of volumes that are not EBS volumes (default pricing.storage)")
 
-	return cmd
+	return cacheable(cmd)
 }
 
 // storageRecommendationsCmd creates the storage recommendations command
@@ -129,19 +282,431 @@ func storageRecommendationsCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("priority", "p", "medium", "recommendation priority (low, medium, high)")
-	cmd.Flags().BoolP("include-costs", "c", true, "include cost impact analysis")
+	cmd.Flags().Bool("include-costs", true, "include cost impact analysis")
 	cmd.Flags().BoolP("include-risks", "r", true, "include risk assessment")
 
-	return cmd
+	return cacheable(cmd)
+}
+
+// volumeRightsizeColumns are the table columns for volume right-sizing
+// results
+var volumeRightsizeColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "class", Header: "STORAGE CLASS", Field: "storage_class", Wide: true},
+	{Name: "capacity", Header: "CAPACITY GIB", Field: "capacity_gib"},
+	{Name: "used", Header: "USED GIB", Field: "used_gib", Wide: true},
+	{Name: "peak", Header: "PEAK GIB", Field: "peak_gib"},
+	{Name: "utilization", Header: "UTILIZATION %", Field: "utilization"},
+	{Name: "growth", Header: "GROWTH GIB/DAY", Field: "growth_gib_day", Wide: true},
+	{Name: "days-to-full", Header: "DAYS TO FULL", Field: "days_to_full", Wide: true},
+	{Name: "action", Field: "action"},
+	{Name: "recommended", Header: "RECOMMENDED GIB", Field: "recommended_gib"},
+	{Name: "cost-change", Header: "MONTHLY COST CHANGE", Field: "monthly_cost_change"},
+	{Name: "how", Field: "how", Wide: true},
+}
+
+// storageRightsizeCmd creates the storage rightsize command
+func storageRightsizeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rightsize",
+		Short: "Recommend sizes of persistent volume claims from their usage",
+		Long: `Compare the capacity of the persistent volume claims against the filesystem
+usage the kubelet reports (kubelet_volume_stats_used_bytes) over --time-range
+at the configured datasource, and recommend shrinking or expanding them.
+
+Claims are sized to their peak usage, or the usage a month from now at the
+growth over the time range if more, plus --headroom percent:
+
+  expand  the claim needs more than its capacity. Claims of storage classes
+          with allowVolumeExpansion are expanded in place by raising their
+          storage request, others are migrated to a larger volume. Claims
+          filling up within a month are listed first.
+  shrink  the claim would give up at least a quarter of its capacity.
+          Kubernetes cannot shrink volumes, the data is migrated to a new,
+          smaller claim.
+
+The cost impact of the new sizes is projected per month at --storage-price.
+Only bound claims mounted by a pod have usage; the others are counted in a
+warning. The analysis needs a datasource and does not need the Python
+runtime.
+
+Examples:
+  upid storage rightsize                          # All namespaces, last 7d
+  upid storage rightsize -n shop -t 30d -o wide   # Show growth and how to resize
+  upid storage rightsize --headroom 30            # More margin over the peak`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return storageRightsize(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", "", "namespace to right-size (default all namespaces)")
+	cmd.Flags().StringP("time-range", "t", "7d", "time range of usage")
+	cmd.Flags().Float64("headroom", 20, "margin over the peak or projected usage, in percent")
+	cmd.Flags().Float64("storage-price", 0, "price per GiB-month of persistent volumes (default pricing.storage)")
+
+	return cacheable(withColumns(cmd, volumeRightsizeColumns))
+}
+
+// cleanupColumns are the table columns for orphaned volume results
+var cleanupColumns = []output.Column{
+	{Name: "kind", Field: "kind"},
+	{Name: "name", Field: "name"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "class", Header: "STORAGE CLASS", Field: "storage_class", Wide: true},
+	{Name: "reclaim", Header: "RECLAIM POLICY", Field: "reclaim_policy", Wide: true},
+	{Name: "capacity", Header: "CAPACITY GIB", Field: "capacity_gib"},
+	{Name: "days", Header: "ORPHANED DAYS", Field: "orphaned_days", Wide: true},
+	{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+	{Name: "reason", Field: "reason"},
+	{Name: "action", Field: "action"},
+	{Name: "volume-id", Header: "VOLUME ID", Field: "volume_id", Wide: true},
+	{Name: "note", Field: "note", Wide: true},
+}
+
+// storageCleanupCmd creates the storage cleanup command
+func storageCleanupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Find and delete orphaned persistent volumes and claims",
+		Long: `Find the orphaned volumes of the cluster, orphaned for at least --older-than:
+
+  - persistent volumes Released because their claim was deleted
+  - persistent volumes whose claim was in a namespace since deleted
+  - bound claims no pod mounted for --older-than, from the kubelet volume
+    stats of the configured datasource, or without one, claims no pod
+    mounts now that were created more than --older-than ago
+
+Without --apply, the orphaned volumes are only listed with their monthly
+cost at --storage-price. --apply deletes them unless --dry-run is given
+explicitly. Deleting a claim deletes its volume, and the disk behind it if
+the volume has the Delete reclaim policy.
+
+Some orphans are kept and listed with the reason: volumes with the Retain
+reclaim policy and the claims of their volumes, whose disk outlives them
+and is to be deleted at the provider once its data is no longer needed,
+claims created from the volume claim templates of a StatefulSet, which
+finds them when it scales up, and volumes in namespaces protected by
+guardrails.protected_namespaces. Like other changes, deletions are not made
+during guardrails.business_hours unless --override gives the reason.
+
+Each deletion is appended to audit.jsonl in the state directory before it
+is made. The cleanup does not need the Python runtime.
+
+Examples:
+  upid storage cleanup                              # List orphans of 7d
+  upid storage cleanup -n staging --older-than 30d  # Orphans of a month
+  upid storage cleanup --apply                      # Delete them
+  upid storage cleanup --apply --override "cost review, INC-4211"`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return storageCleanup(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", "", "namespace to clean up (default all namespaces)")
+	cmd.Flags().String("older-than", "7d", "how long volumes must have been orphaned")
+	cmd.Flags().Bool("dry-run", true, "list the orphaned volumes without deleting them")
+	cmd.Flags().Bool("apply", false, "delete the orphaned volumes")
+	cmd.Flags().String("override", "", "delete during the business hours of the guardrails, for this reason (audited)")
+	cmd.Flags().Float64("storage-price", 0, "price per GiB-month of persistent volumes (default pricing.storage)")
+
+	return mutatingWithNativeDryRun(withColumns(cmd, cleanupColumns))
+}
+
+// volumeSnapshotColumns are the table columns for volume snapshot results
+var volumeSnapshotColumns = []output.Column{
+	{Name: "kind", Field: "kind"},
+	{Name: "name", Field: "name"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "source", Field: "source"},
+	{Name: "snapshot-id", Header: "SNAPSHOT ID", Field: "snapshot_id", Wide: true},
+	{Name: "size", Header: "SIZE GIB", Field: "size_gib"},
+	{Name: "age", Header: "AGE DAYS", Field: "age_days"},
+	{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+	{Name: "flags", Field: "flags"},
+	{Name: "action", Field: "action"},
+	{Name: "command", Field: "command", Wide: true},
+}
+
+// storageSnapshotsCmd creates the storage snapshots command
+func storageSnapshotsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshots",
+		Short: "Analyze volume snapshots and plan their cleanup",
+		Long: `List the CSI VolumeSnapshots of the cluster, the VolumeSnapshotContents whose
+VolumeSnapshot was deleted, and on AWS the EBS snapshots of the volumes of
+the cluster, with their monthly cost at --snapshot-price per GB.
+
+EBS bills the snapshots of a volume incrementally, by the blocks that
+changed since the previous one, so the cost at their full size is an upper
+bound. Snapshots in the archive tier cost a quarter of the price.
+
+Snapshots are flagged:
+
+  old        taken more than --max-age ago
+  duplicate  followed by another of the same claim within a day
+  orphaned   a VolumeSnapshotContent whose VolumeSnapshot was deleted
+
+The cleanup plan deletes the old snapshots but the newest ready one of each
+claim, with the commands listed with -o wide, or as the plan with -o json.
+Nothing is deleted. VolumeSnapshots whose content has the Retain deletion policy
+keep it and the snapshot at the provider, which the plan deletes too.
+
+EBS snapshots are listed with the AWS credentials of pricing.aws_profile in
+the region of the nodes, or --region; --cloud=false skips them. The analysis
+does not need the Python runtime.
+
+Examples:
+  upid storage snapshots                          # All namespaces
+  upid storage snapshots -n shop --max-age 90d    # Old after three months
+  upid storage snapshots -o wide --cloud=false    # CSI snapshots, with commands`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return storageSnapshots(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", "", "namespace of the snapshots (default all namespaces)")
+	cmd.Flags().String("max-age", "30d", "age beyond which snapshots are old")
+	cmd.Flags().Float64("snapshot-price", native.DefaultSnapshotPrice, "price per GB-month of snapshots")
+	cmd.Flags().Bool("cloud", true, "list the EBS snapshots of the volumes of the cluster")
+	cmd.Flags().String("region", "", "AWS region of the EBS snapshots (default that of the nodes)")
+
+	return cacheable(withColumns(cmd, volumeSnapshotColumns))
+}
+
+// classMigrationColumns are the table columns for storage class migration
+// plans
+var classMigrationColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "volume", Field: "volume", Wide: true},
+	{Name: "capacity", Header: "CAPACITY GIB", Field: "capacity_gib"},
+	{Name: "from-iops", Header: "IOPS", Field: "from_iops"},
+	{Name: "to-iops", Header: "NEW IOPS", Field: "to_iops"},
+	{Name: "from-throughput", Header: "MIB/S", Field: "from_throughput", Wide: true},
+	{Name: "to-throughput", Header: "NEW MIB/S", Field: "to_throughput", Wide: true},
+	{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+	{Name: "new-cost", Header: "NEW MONTHLY COST", Field: "new_monthly_cost"},
+	{Name: "savings", Field: "savings"},
+	{Name: "pods", Field: "pods", Wide: true},
+	{Name: "command", Field: "command", Wide: true},
+	{Name: "note", Field: "note", Wide: true},
+}
+
+// staleColumns are the table columns for stale resources
+var staleColumns = []output.Column{
+	{Name: "kind", Field: "kind"},
+	{Name: "namespace", Field: "namespace"},
+	{Name: "name", Field: "name"},
+	{Name: "type", Field: "type", Wide: true},
+	{Name: "size", Field: "size", Wide: true},
+	{Name: "age", Header: "AGE DAYS", Field: "age_days"},
+	{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+	{Name: "reason", Field: "reason", Wide: true},
+	{Name: "action", Field: "action"},
+	{Name: "note", Field: "note", Wide: true},
+}
+
+// storageStaleCmd creates the storage stale command
+func storageStaleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stale",
+		Short: "Find ConfigMaps, Secrets, claims and Services nothing refers to",
+		Long: `Find the resources nothing refers to, created at least --older-than ago:
+
+  ConfigMap              no pod or pod template of a workload mounts it or
+                         reads it into its environment
+  Secret                 as ConfigMaps, and no service account or Ingress
+                         uses it; service account tokens whose account was
+                         deleted
+  PersistentVolumeClaim  no pod or pod template of a workload mounts it
+  Service                its selector matches no pod or pod template, and
+                         no Ingress, StatefulSet or admission webhook names
+                         it
+
+Pod templates count even when their workload is scaled to zero, as do those
+of the ReplicaSets a Deployment keeps for rollbacks. Objects owned by
+another object, managed by Helm, cert-manager or leader election, Services
+without a selector and the system namespaces, unless given with
+--namespace, are left out. Claims of StatefulSets and resources in
+namespaces protected by guardrails.protected_namespaces are listed but
+kept. Claims are priced at --storage-price, LoadBalancer Services at
+--load-balancer-price.
+
+Nothing is deleted. --export writes the manifests of the resources to
+delete, without the fields the API server sets, to review and then delete
+with kubectl delete -f. The same file restores them with kubectl apply -f,
+except for the data of Secrets, which is left out, and that of claims,
+whose volumes are deleted with them unless retained. The sweep does not
+need the Python runtime.
+
+Examples:
+  upid storage stale                             # Stale resources of 7d
+  upid storage stale -n staging --older-than 30d -o wide
+  upid storage stale -e stale.yaml               # Review, then kubectl delete -f stale.yaml`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return storageStale(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", "", "namespace to sweep (default all but the system namespaces)")
+	cmd.Flags().String("older-than", "7d", "how old resources must be to be stale")
+	cmd.Flags().StringP("export", "e", "", "write the manifests of the stale resources as YAML to this file (- for stdout)")
+	cmd.Flags().Float64("storage-price", 0, "price per GiB-month of persistent volumes (default pricing.storage)")
+	cmd.Flags().Float64("load-balancer-price", native.DefaultLoadBalancerPrice, "list price per hour of a load balancer")
+
+	return withColumns(cmd, staleColumns)
+}
+
+// storageMigratePlanCmd creates the storage migrate-plan command
+func storageMigratePlanCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate-plan",
+		Short: "Plan migrating persistent volume claims to another storage class",
+		Long: `List the bound persistent volume claims of the storage class --from, compare
+the monthly cost, IOPS and throughput of their volumes in --from and --to,
+and plan their migration to --to. Nothing is changed: the plan is listed
+with -o json and the manifests it applies are written with --export.
+
+EBS volumes are priced at the us-east-1 list prices of the volume type,
+IOPS and throughput of the parameters of the storage classes; other volumes
+cost --storage-price in both classes. Volumes that would get fewer IOPS or
+less throughput are flagged.
+
+The methods of migration are:
+
+  snapshot  the pods mounting the claims are stopped, each claim is
+            snapshotted, deleted while its volume is retained, and created
+            again under the same name in --to from the VolumeSnapshot, so
+            that the workloads need no change. Works across CSI drivers
+            that support snapshots.
+  vac       the volumes are modified online by setting the
+            VolumeAttributesClass --to on their claims, created from the
+            parameters of the storage class --to if the cluster has none.
+            Needs Kubernetes 1.34, or the beta API enabled, and a CSI driver
+            that modifies volumes, such as the EBS CSI driver. The claims
+            keep their storage class.
+
+The plan does not need the Python runtime.
+
+Examples:
+  upid storage migrate-plan --from gp2 --to gp3                  # Snapshot and restore
+  upid storage migrate-plan --from gp2 --to gp3 --method vac -e gp3.yaml
+  upid storage migrate-plan --from gp2 --to gp3 -n shop -e - | kubectl apply -f - -l upid.io/migration-step=snapshot`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return storageMigratePlan(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", "", "namespace of the claims (default all namespaces)")
+	cmd.Flags().String("from", "", "storage class of the claims to migrate")
+	cmd.Flags().String("to", "", "storage class, or volume attributes class with --method vac, to migrate to")
+	cmd.Flags().String("method", native.MigrateSnapshot, "how to migrate (snapshot, vac)")
+	cmd.Flags().StringP("export", "e", "", "write the manifests of the plan as YAML to this file (- for stdout)")
+	cmd.Flags().Float64("storage-price", 0, "price per GiB-month of volumes that are not EBS volumes (default pricing.storage)")
+	_ = cmd.MarkFlagRequired("from")
+	_ = cmd.MarkFlagRequired("to")
+
+	return withColumns(cmd, classMigrationColumns)
+}
+
+// imageSections are the lists of an image analysis with their table
+// columns, in the order they are shown
+var imageSections = []section{
+	{"images", []output.Column{
+		{Name: "image", Field: "image"},
+		{Name: "size", Header: "SIZE GIB", Field: "size_gib"},
+		{Name: "nodes", Field: "nodes"},
+		{Name: "unused-nodes", Header: "UNUSED ON", Field: "unused_nodes"},
+		{Name: "pods", Field: "pods", Wide: true},
+		{Name: "workloads", Field: "workloads", Wide: true},
+		{Name: "node-disk", Header: "NODE DISK GIB", Field: "node_disk_gib", Wide: true},
+		{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+		{Name: "flags", Field: "flags"},
+	}},
+	{"nodes", []output.Column{
+		{Name: "name", Field: "name"},
+		{Name: "images", Field: "images"},
+		{Name: "image-gib", Header: "IMAGE GIB", Field: "image_gib"},
+		{Name: "unused-images", Header: "UNUSED IMAGES", Field: "unused_images"},
+		{Name: "unused-gib", Header: "UNUSED GIB", Field: "unused_gib"},
+		{Name: "unused-cost", Header: "UNUSED COST", Field: "unused_cost"},
+	}},
+	{"oversized", []output.Column{
+		{Name: "namespace", Field: "namespace"},
+		{Name: "workload", Field: "workload"},
+		{Name: "container", Field: "container"},
+		{Name: "image", Field: "image", Wide: true},
+		{Name: "size", Header: "SIZE GIB", Field: "size_gib"},
+		{Name: "excess", Header: "EXCESS GIB", Field: "excess_gib"},
+		{Name: "nodes", Field: "nodes", Wide: true},
+		{Name: "cost", Header: "MONTHLY COST", Field: "monthly_cost"},
+	}},
+}
+
+// storageImagesCmd creates the storage images command
+func storageImagesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "images",
+		Short: "Analyze the container images cached on the nodes",
+		Long: `Inventory the container images cached on the nodes from their status, with
+their size on disk, the nodes caching them and the pods and workloads
+running them, and estimate what they cost:
+
+  unused     images cached on a node that no pod of the node runs, whose
+             disk is priced at --storage-price until the kubelet garbage
+             collects them
+  oversized  images larger than --max-size, whose excess is priced on the
+             disks of the nodes caching them and at --registry-price in
+             the registry
+
+Sizes are those of the unpacked images on the nodes, more than the
+compressed layers registries store and share between images, so registry
+costs are upper bounds. The kubelet lists at most 50 images per node by
+default. With --namespace, the images of its workloads are inventoried,
+the nodes are analyzed whole. The analysis does not need the Python
+runtime.
+
+Examples:
+  upid storage images                        # All images
+  upid storage images -n shop --max-size 500Mi
+  upid storage images -o json | jq '.nodes'  # Unused images by node`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return storageImages(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().StringP("namespace", "n", "", "namespace of the workloads (default all namespaces)")
+	cmd.Flags().String("max-size", "1Gi", "size beyond which images are oversized")
+	cmd.Flags().Float64("storage-price", 0, "price per GiB-month of the disks of the nodes (default pricing.storage)")
+	cmd.Flags().Float64("registry-price", native.DefaultRegistryPrice, "price per GB-month of the registry")
+
+	return cacheable(cmd)
 }
 
 // Implementation functions
 func storageAnalyze(cmd *cobra.Command, args []string) error {
-	clusterID := args[0]
+	clusterID := config.GetDefaultCluster()
+	if len(args) > 0 {
+		clusterID = args[0]
+	}
 	namespace, _ := cmd.Flags().GetString("namespace")
 	timeRange, _ := cmd.Flags().GetString("time-range")
 	detailed, _ := cmd.Flags().GetBool("detailed")
 	includeCosts, _ := cmd.Flags().GetBool("include-costs")
+	performance, _ := cmd.Flags().GetBool("performance")
 
 	// Build arguments
 	cmdArgs := []string{"storage", "analyze", clusterID}
@@ -158,7 +723,41 @@ func storageAnalyze(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--include-costs")
 	}
 
-	return executePythonCommand("storage", cmdArgs)
+	if !performance && !runtimeMissing() {
+		return executePythonCommand(cmd.Context(), "storage", cmdArgs)
+	}
+	cloud, _ := cmd.Flags().GetBool("cloud")
+	region, _ := cmd.Flags().GetString("region")
+	if timeRange == "" {
+		// 'upid storage' without a subcommand has no flags
+		timeRange = "7d"
+	}
+	window, err := timeutil.ParseDuration(timeRange)
+	if err != nil || window <= 0 {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", fmt.Sprintf("invalid --time-range %q", timeRange)).
+			WithHint("Use a time range such as 7d or 30d")
+	}
+	price, err := storagePrice(cmd)
+	if err != nil {
+		return err
+	}
+	opts := native.StorageAnalysisOptions{Namespace: namespace, Window: window, StoragePrice: price, Region: region}
+	if cloud {
+		settings := config.GetPricingConfig()
+		client := billing.New(billing.Options{Region: settings.AWSRegion, Profile: settings.AWSProfile, Timeout: time.Minute})
+		opts.Cloud = client.VolumeIO
+	}
+	run := func(ctx context.Context) (map[string]interface{}, error) {
+		client, _, err := nativeClient(timeRange)
+		if err != nil {
+			return nil, err
+		}
+		return priced(client.AnalyzeStorage(ctx, opts))
+	}
+	if !performance {
+		return executeNative(cmd.Context(), "storage", cmdArgs, run)
+	}
+	return executeBuiltin(cmd.Context(), "storage", run)
 }
 
 func storageVolumes(cmd *cobra.Command, args []string) error {
@@ -183,7 +782,7 @@ func storageVolumes(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--orphaned")
 	}
 
-	return executePythonCommand("storage", cmdArgs)
+	return executePythonCommand(cmd.Context(), "storage", cmdArgs)
 }
 
 func storageOptimize(cmd *cobra.Command, args []string) error {
@@ -208,17 +807,25 @@ func storageOptimize(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--include-orphaned")
 	}
 
-	return executePythonCommand("storage", cmdArgs)
+	return executePythonCommand(cmd.Context(), "storage", cmdArgs)
 }
 
 func storageCosts(cmd *cobra.Command, args []string) error {
-	clusterID := args[0]
+	clusterID := config.GetDefaultCluster()
+	if len(args) > 0 {
+		clusterID = args[0]
+	}
+	namespace, _ := cmd.Flags().GetString("namespace")
 	timeRange, _ := cmd.Flags().GetString("time-range")
 	detailed, _ := cmd.Flags().GetBool("detailed")
 	groupBy, _ := cmd.Flags().GetString("group-by")
+	backups, _ := cmd.Flags().GetBool("backups")
 
 	// Build arguments
 	cmdArgs := []string{"storage", "costs", clusterID}
+	if namespace != "" {
+		cmdArgs = append(cmdArgs, "--namespace", namespace)
+	}
 	if timeRange != "" {
 		cmdArgs = append(cmdArgs, "--time-range", timeRange)
 	}
@@ -229,7 +836,49 @@ func storageCosts(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--group-by", groupBy)
 	}
 
-	return executePythonCommand("storage", cmdArgs)
+	if !backups && !runtimeMissing() {
+		return executePythonCommand(cmd.Context(), "storage", cmdArgs)
+	}
+	switch groupBy {
+	case "namespace", "type", "class":
+	default:
+		return fmt.Errorf("invalid --group-by %q (expected namespace, type or class)", groupBy)
+	}
+	window, err := timeutil.ParseDuration(timeRange)
+	if err != nil || window <= 0 {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", fmt.Sprintf("invalid --time-range %q", timeRange)).
+			WithHint("Use a time range such as 7d or 30d")
+	}
+	backupPrice, _ := cmd.Flags().GetFloat64("backup-price")
+	if backupPrice < 0 {
+		return fmt.Errorf("invalid --backup-price %g (expected 0 or more)", backupPrice)
+	}
+	price, err := storagePrice(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := native.NewClient("")
+	if err != nil {
+		return err
+	}
+	result, err := priced(client.StorageCosts(cmd.Context(), native.StorageCostOptions{
+		Namespace:    namespace,
+		GroupBy:      groupBy,
+		Detailed:     detailed,
+		Window:       window,
+		StoragePrice: price,
+		BackupPrice:  backupPrice,
+		Backups:      backups,
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to execute storage command: %w", err)
+	}
+	if err := renderSections(result, storageCostSections); err != nil {
+		return err
+	}
+	printResultWarning(result)
+	return nil
 }
 
 func storageRecommendations(cmd *cobra.Command, args []string) error {
@@ -246,7 +895,266 @@ func storageRecommendations(cmd *cobra.Command, args []string) error {
 	cmdArgs = append(cmdArgs, "--include-costs", fmt.Sprintf("%t", includeCosts))
 	cmdArgs = append(cmdArgs, "--include-risks", fmt.Sprintf("%t", includeRisks))
 
-	return executePythonCommand("storage", cmdArgs)
+	return executePythonCommand(cmd.Context(), "storage", cmdArgs)
+}
+
+func storageRightsize(cmd *cobra.Command, args []string) error {
+	// Get flags
+	namespace, _ := cmd.Flags().GetString("namespace")
+	timeRange, _ := cmd.Flags().GetString("time-range")
+	headroom, _ := cmd.Flags().GetFloat64("headroom")
+
+	if headroom < 0 {
+		return fmt.Errorf("invalid --headroom %g (expected 0 or more)", headroom)
+	}
+	price, err := storagePrice(cmd)
+	if err != nil {
+		return err
+	}
+
+	return executeBuiltin(cmd.Context(), "storage", func(ctx context.Context) (map[string]interface{}, error) {
+		client, window, err := nativeClient(timeRange)
+		if err != nil {
+			return nil, err
+		}
+		return priced(client.RightsizeVolumes(ctx, native.VolumeRightsizeOptions{
+			Namespace:    namespace,
+			Window:       window,
+			Headroom:     headroom / 100,
+			StoragePrice: price,
+		}))
+	})
+}
+
+func storageCleanup(cmd *cobra.Command, args []string) error {
+	// Get flags
+	namespace, _ := cmd.Flags().GetString("namespace")
+	olderThan, _ := cmd.Flags().GetString("older-than")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	apply, _ := cmd.Flags().GetBool("apply")
+	override, _ := cmd.Flags().GetString("override")
+
+	minAge, err := timeutil.ParseDuration(olderThan)
+	if err != nil || minAge <= 0 {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", fmt.Sprintf("invalid --older-than %q", olderThan)).
+			WithHint("Use a duration such as 7d, 2w or 720h")
+	}
+	price, err := storagePrice(cmd)
+	if err != nil {
+		return err
+	}
+	// --apply deletes unless --dry-run is given explicitly
+	if apply {
+		dryRun = dryRun && cmd.Flags().Changed("dry-run")
+	}
+	if !dryRun {
+		if err := guardBusinessHours(override, "storage cleanup"); err != nil {
+			return err
+		}
+	}
+
+	return executeBuiltin(cmd.Context(), "storage", func(ctx context.Context) (map[string]interface{}, error) {
+		client, _, err := nativeClient(olderThan)
+		if err != nil {
+			return nil, err
+		}
+		rails, err := guardrails(override)
+		if err != nil {
+			return nil, err
+		}
+		client.SetGuardrails(rails)
+		return priced(client.CleanupVolumes(ctx, native.CleanupOptions{
+			Namespace:    namespace,
+			MinAge:       minAge,
+			StoragePrice: price,
+			Apply:        !dryRun,
+		}))
+	})
+}
+
+func storageSnapshots(cmd *cobra.Command, args []string) error {
+	// Get flags
+	namespace, _ := cmd.Flags().GetString("namespace")
+	maxAgeFlag, _ := cmd.Flags().GetString("max-age")
+	price, _ := cmd.Flags().GetFloat64("snapshot-price")
+	cloud, _ := cmd.Flags().GetBool("cloud")
+	region, _ := cmd.Flags().GetString("region")
+
+	maxAge, err := timeutil.ParseDuration(maxAgeFlag)
+	if err != nil || maxAge <= 0 {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", fmt.Sprintf("invalid --max-age %q", maxAgeFlag)).
+			WithHint("Use a duration such as 30d, 12w or 720h")
+	}
+	if price < 0 {
+		return fmt.Errorf("invalid --snapshot-price %g (expected 0 or more)", price)
+	}
+	opts := native.SnapshotOptions{Namespace: namespace, MaxAge: maxAge, Price: price, Region: region}
+	if cloud {
+		settings := config.GetPricingConfig()
+		client := billing.New(billing.Options{Region: settings.AWSRegion, Profile: settings.AWSProfile, Timeout: time.Minute})
+		opts.Cloud = client.Snapshots
+	}
+
+	return executeBuiltin(cmd.Context(), "storage", func(ctx context.Context) (map[string]interface{}, error) {
+		client, _, err := nativeClient("")
+		if err != nil {
+			return nil, err
+		}
+		return priced(client.AnalyzeSnapshots(ctx, opts))
+	})
+}
+
+func storageMigratePlan(cmd *cobra.Command, args []string) error {
+	// Get flags
+	namespace, _ := cmd.Flags().GetString("namespace")
+	from, _ := cmd.Flags().GetString("from")
+	to, _ := cmd.Flags().GetString("to")
+	methodFlag, _ := cmd.Flags().GetString("method")
+	export, _ := cmd.Flags().GetString("export")
+
+	method, err := native.ParseMigrationMethod(methodFlag)
+	if err != nil {
+		return err
+	}
+	price, err := storagePrice(cmd)
+	if err != nil {
+		return err
+	}
+	client, _, err := nativeClient("")
+	if err != nil {
+		return err
+	}
+	opts := native.ClassMigrationOptions{
+		Namespace:    namespace,
+		From:         from,
+		To:           to,
+		Method:       method,
+		StoragePrice: price,
+		Manifests:    export,
+	}
+	if export == "-" {
+		opts.Manifests = ""
+	}
+	result, manifests, err := client.PlanClassMigration(cmd.Context(), opts)
+	if err != nil {
+		return fmt.Errorf("failed to execute storage command: %w", err)
+	}
+	result, _ = priced(result, nil)
+
+	if export != "" {
+		data, err := manifestYAML(manifests)
+		if err != nil {
+			return err
+		}
+		if export == "-" {
+			_, err := os.Stdout.Write(data)
+			printResultWarning(result)
+			return err
+		}
+		if err := os.WriteFile(export, data, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", export, err)
+		}
+	}
+	err = executeBuiltin(cmd.Context(), "storage", func(ctx context.Context) (map[string]interface{}, error) {
+		return result, nil
+	})
+	if err == nil && export != "" {
+		fmt.Fprintf(os.Stderr, "Wrote %d manifests to %s\n", len(manifests), export)
+	}
+	return err
 }
 
- 
\ No newline at end of file
+func storageStale(cmd *cobra.Command, args []string) error {
+	// Get flags
+	namespace, _ := cmd.Flags().GetString("namespace")
+	olderThan, _ := cmd.Flags().GetString("older-than")
+	export, _ := cmd.Flags().GetString("export")
+	loadBalancerPrice, _ := cmd.Flags().GetFloat64("load-balancer-price")
+
+	minAge, err := timeutil.ParseDuration(olderThan)
+	if err != nil || minAge < 0 {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", fmt.Sprintf("invalid --older-than %q", olderThan)).
+			WithHint("Use a duration such as 7d, 2w or 720h")
+	}
+	if loadBalancerPrice < 0 {
+		return fmt.Errorf("invalid --load-balancer-price %g (expected 0 or more)", loadBalancerPrice)
+	}
+	price, err := storagePrice(cmd)
+	if err != nil {
+		return err
+	}
+	client, _, err := nativeClient("")
+	if err != nil {
+		return err
+	}
+	result, manifests, err := client.SweepStale(cmd.Context(), native.StaleOptions{
+		Namespace:         namespace,
+		MinAge:            minAge,
+		StoragePrice:      price,
+		LoadBalancerPrice: loadBalancerPrice,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to execute storage command: %w", err)
+	}
+	result, _ = priced(result, nil)
+
+	if export != "" {
+		data, err := manifestYAML(manifests)
+		if err != nil {
+			return err
+		}
+		if export == "-" {
+			_, err := os.Stdout.Write(data)
+			printResultWarning(result)
+			return err
+		}
+		if err := os.WriteFile(export, data, 0o600); err != nil {
+			return fmt.Errorf("failed to write %s: %v", export, err)
+		}
+	}
+	err = executeBuiltin(cmd.Context(), "storage", func(ctx context.Context) (map[string]interface{}, error) {
+		return result, nil
+	})
+	if err == nil && export != "" {
+		fmt.Fprintf(os.Stderr, "Wrote %d manifests to %s\n", len(manifests), export)
+	}
+	return err
+}
+
+func storageImages(cmd *cobra.Command, args []string) error {
+	// Get flags
+	namespace, _ := cmd.Flags().GetString("namespace")
+	maxSizeFlag, _ := cmd.Flags().GetString("max-size")
+	registryPrice, _ := cmd.Flags().GetFloat64("registry-price")
+
+	maxSize, err := resource.ParseQuantity(maxSizeFlag)
+	if err != nil || maxSize.Sign() <= 0 {
+		return fmt.Errorf("invalid --max-size %q (expected a quantity such as 1Gi)", maxSizeFlag)
+	}
+	if registryPrice < 0 {
+		return fmt.Errorf("invalid --registry-price %g (expected 0 or more)", registryPrice)
+	}
+	diskPrice, err := storagePrice(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := native.NewClient("")
+	if err != nil {
+		return err
+	}
+	result, err := priced(client.AnalyzeImages(cmd.Context(), native.ImageOptions{
+		Namespace:     namespace,
+		MaxSize:       maxSize.AsApproximateFloat64(),
+		DiskPrice:     diskPrice,
+		RegistryPrice: registryPrice,
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to execute storage command: %w", err)
+	}
+	if err := renderSections(result, imageSections); err != nil {
+		return err
+	}
+	printResultWarning(result)
+	return nil
+}
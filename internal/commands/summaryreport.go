@@ -0,0 +1,284 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/reports"
+	"github.com/spf13/cobra"
+)
+
+// offenderColumns are the table columns for the top offenders of a report
+var offenderColumns = []output.Column{
+	{Name: "namespace", Field: "namespace"},
+	{Name: "workload", Field: "workload"},
+	{Name: "kind", Field: "kind", Wide: true},
+	{Name: "action", Field: "action"},
+	{Name: "utilization", Header: "USED %", Field: "utilization"},
+	{Name: "savings", Header: "SAVINGS", Field: "savings"},
+	{Name: "severity", Field: "severity"},
+}
+
+// recommendationSummaryColumns are the table columns for recommendations
+// summarized by action
+var recommendationSummaryColumns = []output.Column{
+	{Name: "action", Field: "action"},
+	{Name: "recommendations", Field: "recommendations"},
+	{Name: "savings", Header: "SAVINGS", Field: "savings"},
+}
+
+// reportSummary generates the summary report of a cluster and writes it to
+// a standalone HTML or PDF file
+func reportSummary(cmd *cobra.Command, cluster, timeRange, format string) error {
+	// Get flags
+	outputFile, _ := cmd.Flags().GetString("output-file")
+	company, _ := cmd.Flags().GetString("company")
+	brandColor, _ := cmd.Flags().GetString("brand-color")
+	logoFile, _ := cmd.Flags().GetString("logo")
+
+	brand := output.Brand{Name: company, Color: brandColor}
+	if logoFile != "" {
+		logo, err := os.ReadFile(logoFile)
+		if err != nil {
+			return fmt.Errorf("failed to read --logo: %v", err)
+		}
+		brand.Logo = logo
+	}
+	if err := brand.Validate(); err != nil {
+		return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", err.Error())
+	}
+	result, err := summaryResult(cmd, cluster, timeRange)
+	if err != nil {
+		return err
+	}
+
+	report := summaryReport(result)
+	report.Brand = brand
+	var b bytes.Buffer
+	if format == "pdf" {
+		err = output.WritePDFReport(&b, report)
+	} else {
+		err = output.WriteHTML(&b, report)
+	}
+	if err != nil {
+		return err
+	}
+	if outputFile == "" {
+		outputFile = fmt.Sprintf("upid-report-%s-%s.%s", fileSafe(fmt.Sprint(result["context"])), time.Now().Format("2006-01-02"), format)
+	}
+	id := keepReport(&reports.Report{Type: "summary", Context: fmt.Sprint(result["context"]), Period: timeRange, Format: format}, outputFile, b.Bytes())
+	if outputFile == "-" {
+		_, err := os.Stdout.Write(b.Bytes())
+		return err
+	}
+	summary := map[string]interface{}{
+		"message":         result["message"],
+		"context":         result["context"],
+		"currency":        result["currency"],
+		"monthly_cost":    result["monthly_cost"],
+		"savings":         result["savings"],
+		"file":            outputFile,
+		"report_id":       id,
+		"recommendations": result["recommendations"],
+		"offenders":       result["offenders"],
+	}
+	if err := renderSections(summary, []section{{"recommendations", recommendationSummaryColumns}, {"offenders", offenderColumns}}); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputFile, b.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", outputFile, err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote the report to %s\n", outputFile)
+	return nil
+}
+
+// summaryResult computes the summary report of a cluster from the flags of
+// report generate
+func summaryResult(cmd *cobra.Command, cluster, timeRange string) (map[string]interface{}, error) {
+	// Get flags
+	top, _ := cmd.Flags().GetInt("top")
+	confidence, _ := cmd.Flags().GetFloat64("confidence")
+	sharedNamespaces, _ := cmd.Flags().GetStringSlice("shared-namespaces")
+
+	if top <= 0 {
+		return nil, fmt.Errorf("invalid --top %d (expected 1 or more)", top)
+	}
+	if confidence < 0 || confidence > 1 {
+		return nil, fmt.Errorf("invalid --confidence %g (expected 0 to 1)", confidence)
+	}
+	prices, err := computePrices(cmd)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := storagePrice(cmd)
+	if err != nil {
+		return nil, err
+	}
+	client, window, err := nativeClientFor(cluster, timeRange)
+	if err != nil {
+		return nil, err
+	}
+	result, err := priced(client.Report(cmd.Context(), native.ReportOptions{
+		Window:            window,
+		MinConfidence:     confidence,
+		Prices:            prices,
+		StoragePrice:      storage,
+		LoadBalancerPrice: native.DefaultLoadBalancerPrice,
+		SharedNamespaces:  sharedNamespaces,
+		Top:               top,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute report command: %w", err)
+	}
+	return result, nil
+}
+
+// summaryReport lays out the summary report of a cluster: its headline
+// costs, its cost by kind of resource and by namespace, its top offenders
+// and its recommendations
+func summaryReport(result map[string]interface{}) output.HTMLReport {
+	number := func(value interface{}) float64 {
+		switch v := value.(type) {
+		case float64:
+			return v
+		case int:
+			return float64(v)
+		}
+		return 0
+	}
+	list := func(value interface{}) []map[string]interface{} {
+		values, _ := value.([]interface{})
+		items := make([]map[string]interface{}, 0, len(values))
+		for _, v := range values {
+			if item, ok := v.(map[string]interface{}); ok {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	cost := func(value interface{}) string { return formatCost(number(value)) }
+	share := func(value interface{}) string {
+		if value == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", number(value))
+	}
+
+	monthly, requested, used := number(result["monthly_cost"]), number(result["requested_cost"]), number(result["used_cost"])
+	var recommendations int
+	var actions []string
+	for _, item := range list(result["recommendations"]) {
+		n := int(number(item["recommendations"]))
+		recommendations += n
+		actions = append(actions, fmt.Sprintf("%d to %s, saving %s", n, item["action"], cost(item["savings"])))
+	}
+	report := output.HTMLReport{
+		Title: fmt.Sprintf("Cost report of %s", result["context"]),
+		Subtitle: fmt.Sprintf("Generated %s. Costs are monthly, in %s; usage is the %s.",
+			time.Now().Format("January 2, 2006 15:04 MST"), result["currency"], result["usage"]),
+		Figures: []output.HTMLFigure{
+			{Label: "Monthly cost", Value: formatCost(monthly), Note: "nodes, volumes and load balancers"},
+			{Label: "Requested by pods", Value: formatCost(requested), Note: fmt.Sprintf("%s of it used", share(percentOf(used, requested)))},
+			{Label: "Idle capacity", Value: cost(result["idle_cost"]), Note: "node capacity that no pod requests"},
+			{Label: "Potential savings", Value: cost(result["savings"]), Note: fmt.Sprintf("in %d recommendations", recommendations)},
+		},
+		Footer: fmt.Sprintf("Generated by UPID %s", config.GetVersion()),
+	}
+
+	resources := output.HTMLSection{
+		Title: "Cost by resource",
+		Chart: &output.BarChart{},
+		Table: &output.HTMLTable{Columns: []string{"Resource", "Count", "Monthly cost", "Share"}, Numeric: []bool{false, true, true, true}},
+	}
+	for _, item := range list(result["breakdown"]) {
+		resources.Chart.Bars = append(resources.Chart.Bars, output.Bar{
+			Label: fmt.Sprint(item["category"]), Values: []float64{number(item["monthly_cost"])}, Text: cost(item["monthly_cost"]),
+		})
+		resources.Table.Rows = append(resources.Table.Rows, []string{
+			fmt.Sprint(item["category"]), output.FormatValue(item["resources"]), cost(item["monthly_cost"]), share(percentOf(number(item["monthly_cost"]), monthly)),
+		})
+	}
+
+	namespaces := output.HTMLSection{
+		Title: "Cost by namespace",
+		Text:  "The cost of the compute of each namespace: its requests, and its share of idle capacity and of the shared namespaces.",
+		Chart: &output.BarChart{Series: []string{"requests", "idle", "shared"}},
+		Table: &output.HTMLTable{
+			Columns: []string{"Namespace", "Pods", "CPU", "Memory", "Requests", "Idle", "Shared", "Total", "Share"},
+			Numeric: []bool{false, true, true, true, true, true, true, true, true},
+		},
+	}
+	for _, item := range list(result["namespaces"]) {
+		name := fmt.Sprint(item["name"])
+		namespaces.Chart.Bars = append(namespaces.Chart.Bars, output.Bar{
+			Label:  name,
+			Values: []float64{number(item["direct_cost"]), number(item["idle_cost"]), number(item["shared_cost"])},
+			Text:   cost(item["monthly_cost"]),
+		})
+		namespaces.Table.Rows = append(namespaces.Table.Rows, []string{
+			name, output.FormatValue(item["pods"]), output.FormatValue(item["cpu_requests"]), output.FormatValue(item["memory_requests"]),
+			cost(item["direct_cost"]), cost(item["idle_cost"]), cost(item["shared_cost"]), cost(item["monthly_cost"]), share(item["cost_percent"]),
+		})
+	}
+
+	offenders := output.HTMLSection{
+		Title: "Top offenders",
+		Text:  "The workloads that would save the most: idle ones scaled to zero, and those using less than half their requests right-sized.",
+		Chart: &output.BarChart{},
+		Table: &output.HTMLTable{
+			Columns: []string{"Namespace", "Workload", "Kind", "Pods", "Action", "Requested", "Used", "Savings", "Severity"},
+			Numeric: []bool{false, false, false, true, false, true, true, true, false},
+		},
+	}
+	for _, item := range list(result["offenders"]) {
+		label := fmt.Sprintf("%s/%s", item["namespace"], item["workload"])
+		offenders.Chart.Bars = append(offenders.Chart.Bars, output.Bar{Label: label, Values: []float64{number(item["savings"])}, Text: cost(item["savings"])})
+		offenders.Table.Rows = append(offenders.Table.Rows, []string{
+			fmt.Sprint(item["namespace"]), fmt.Sprint(item["workload"]), fmt.Sprint(item["kind"]), output.FormatValue(item["pods"]), fmt.Sprint(item["action"]),
+			cost(item["requested_cost"]), cost(item["used_cost"]), cost(item["savings"]), fmt.Sprint(item["severity"]),
+		})
+	}
+	if len(offenders.Table.Rows) == 0 {
+		offenders.Text = "No workload is idle or uses less than half its requests."
+		offenders.Chart, offenders.Table = nil, nil
+	}
+
+	severities := output.HTMLSection{
+		Title: "Recommendations",
+		Text:  strings.Join(actions, "; ") + ".",
+		Table: &output.HTMLTable{Columns: []string{"Severity", "Recommendations", "Savings"}, Numeric: []bool{false, true, true}},
+	}
+	for _, item := range list(result["severities"]) {
+		severities.Table.Rows = append(severities.Table.Rows, []string{
+			fmt.Sprint(item["severity"]), output.FormatValue(item["recommendations"]), cost(item["savings"]),
+		})
+	}
+
+	report.Sections = []output.HTMLSection{resources, namespaces, offenders, severities}
+	return report
+}
+
+// percentOf returns part as a percentage of whole, nil if whole is 0
+func percentOf(part, whole float64) interface{} {
+	if whole == 0 {
+		return nil
+	}
+	return 100 * part / whole
+}
+
+// fileSafe replaces the characters of a name that are unsafe in file names
+func fileSafe(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -1,6 +1,15 @@
 package commands
 
 import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/bridge"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/logging"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
 	"github.com/spf13/cobra"
 )
 
@@ -15,7 +24,12 @@ Examples:
   upid system health                    # Check system health
   upid system metrics                   # Get system metrics
   upid system version                   # Get version information
-  upid system diagnostics               # Run system diagnostics`,
+  upid system diagnostics               # Run system diagnostics
+  upid system benchmark --synthetic     # Benchmark analysis performance
+  upid system daemon start              # Keep the Python runtime running
+  upid system runtime install           # Install the Python runtime
+  upid system cache clear               # Remove cached analysis results
+  upid system paths                     # Show where UPID keeps its files`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return systemHealth(cmd, args)
 		},
@@ -28,6 +42,11 @@ Examples:
 	systemCmd.AddCommand(systemDiagnosticsCmd())
 	systemCmd.AddCommand(systemConfigCmd())
 	systemCmd.AddCommand(systemLogsCmd())
+	systemCmd.AddCommand(systemBenchmarkCmd())
+	systemCmd.AddCommand(systemDaemonCmd())
+	systemCmd.AddCommand(systemRuntimeCmd())
+	systemCmd.AddCommand(systemCacheCmd())
+	systemCmd.AddCommand(systemPathsCmd())
 
 	return systemCmd
 }
@@ -44,7 +63,7 @@ func systemHealthCmd() *cobra.Command {
 	}
 
 	// Add flags
-	cmd.Flags().BoolP("detailed", "d", false, "detailed health information")
+	cmd.Flags().Bool("detailed", false, "detailed health information")
 	cmd.Flags().BoolP("include-dependencies", "i", false, "include dependency health")
 
 	return cmd
@@ -63,7 +82,7 @@ func systemMetricsCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().StringP("time-range", "t", "1h", "time range for metrics")
-	cmd.Flags().BoolP("detailed", "d", false, "detailed metrics")
+	cmd.Flags().Bool("detailed", false, "detailed metrics")
 	cmd.Flags().StringP("format", "f", "table", "output format (table, json, yaml)")
 
 	return cmd
@@ -81,8 +100,8 @@ func systemVersionCmd() *cobra.Command {
 	}
 
 	// Add flags
-	cmd.Flags().BoolP("detailed", "d", false, "detailed version information")
-	cmd.Flags().BoolP("check-updates", "c", false, "check for available updates")
+	cmd.Flags().Bool("detailed", false, "detailed version information")
+	cmd.Flags().Bool("check-updates", false, "check for available updates")
 
 	return cmd
 }
@@ -101,7 +120,7 @@ func systemDiagnosticsCmd() *cobra.Command {
 	// Add flags
 	cmd.Flags().BoolP("verbose", "v", false, "verbose output")
 	cmd.Flags().BoolP("fix-issues", "f", false, "attempt to fix detected issues")
-	cmd.Flags().StringP("output", "o", "", "output file for diagnostics report")
+	cmd.Flags().String("output-file", "", "output file for diagnostics report")
 
 	return cmd
 }
@@ -119,7 +138,7 @@ func systemConfigCmd() *cobra.Command {
 
 	// Add flags
 	cmd.Flags().BoolP("show-secrets", "s", false, "show sensitive configuration values")
-	cmd.Flags().BoolP("validate", "v", false, "validate configuration")
+	cmd.Flags().Bool("validate", false, "validate configuration")
 	cmd.Flags().StringP("export", "e", "", "export configuration to file")
 
 	return cmd
@@ -130,7 +149,13 @@ func systemLogsCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "logs",
 		Short: "View system logs",
-		Long:  "View and manage system logs",
+		Long: `View the structured logs written by the UPID CLI and daemon.
+
+Examples:
+  upid system logs                          # Info and above from the last hour
+  upid system logs -l error -t 7d           # Errors from the last week
+  upid system logs --source daemon -f       # Follow the daemon log
+  upid system logs --filter cluster-prod    # Entries mentioning cluster-prod`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return systemLogs(cmd, args)
 		},
@@ -141,10 +166,39 @@ func systemLogsCmd() *cobra.Command {
 	cmd.Flags().StringP("time-range", "t", "1h", "time range for logs")
 	cmd.Flags().BoolP("follow", "f", false, "follow log output")
 	cmd.Flags().StringP("filter", "", "", "filter logs by text")
+	cmd.Flags().StringP("source", "s", "all", "log source (cli, daemon, all)")
 
 	return cmd
 }
 
+// systemPathsCmd creates the system paths command
+func systemPathsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "paths",
+		Short: "Show where UPID keeps its files",
+		Long: `Show the files and directories used by UPID.
+
+UPID follows the XDG base directory specification: configuration is kept in
+$XDG_CONFIG_HOME/upid (~/.config/upid), cached results in $XDG_CACHE_HOME/upid
+(~/.cache/upid), logs and other state in $XDG_STATE_HOME/upid
+(~/.local/state/upid) and the installed runtime in $XDG_DATA_HOME/upid
+(~/.local/share/upid, or $UPID_HOME). Files in ~/.upid from earlier versions
+are moved there automatically.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return systemPaths(cmd, args)
+		},
+	}
+	return withColumns(cmd, pathColumns)
+}
+
+// pathColumns are the table columns for system paths
+var pathColumns = []output.Column{
+	{Name: "name", Field: "name"},
+	{Name: "path", Field: "path"},
+	{Name: "exists", Field: "exists"},
+}
+
 // Implementation functions
 func systemHealth(cmd *cobra.Command, args []string) error {
 	// Get flags
@@ -160,7 +214,7 @@ func systemHealth(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--include-dependencies")
 	}
 
-	return executePythonCommand("system", cmdArgs)
+	return executePythonCommand(cmd.Context(), "system", cmdArgs)
 }
 
 func systemMetrics(cmd *cobra.Command, args []string) error {
@@ -181,7 +235,7 @@ func systemMetrics(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--format", format)
 	}
 
-	return executePythonCommand("system", cmdArgs)
+	return executePythonCommand(cmd.Context(), "system", cmdArgs)
 }
 
 func systemVersion(cmd *cobra.Command, args []string) error {
@@ -198,14 +252,14 @@ func systemVersion(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--check-updates")
 	}
 
-	return executePythonCommand("system", cmdArgs)
+	return executePythonCommand(cmd.Context(), "system", cmdArgs)
 }
 
 func systemDiagnostics(cmd *cobra.Command, args []string) error {
 	// Get flags
 	verbose, _ := cmd.Flags().GetBool("verbose")
 	fixIssues, _ := cmd.Flags().GetBool("fix-issues")
-	output, _ := cmd.Flags().GetString("output")
+	outputFile, _ := cmd.Flags().GetString("output-file")
 
 	// Build arguments
 	cmdArgs := []string{"system", "diagnostics"}
@@ -215,11 +269,11 @@ func systemDiagnostics(cmd *cobra.Command, args []string) error {
 	if fixIssues {
 		cmdArgs = append(cmdArgs, "--fix-issues")
 	}
-	if output != "" {
-		cmdArgs = append(cmdArgs, "--output", output)
+	if outputFile != "" {
+		cmdArgs = append(cmdArgs, "--output", outputFile)
 	}
 
-	return executePythonCommand("system", cmdArgs)
+	return executePythonCommand(cmd.Context(), "system", cmdArgs)
 }
 
 func systemConfig(cmd *cobra.Command, args []string) error {
@@ -240,7 +294,39 @@ func systemConfig(cmd *cobra.Command, args []string) error {
 		cmdArgs = append(cmdArgs, "--export", export)
 	}
 
-	return executePythonCommand("system", cmdArgs)
+	return executePythonCommand(cmd.Context(), "system", cmdArgs)
+}
+
+func systemPaths(cmd *cobra.Command, args []string) error {
+	paths := []struct{ name, path string }{
+		{"config", config.FilePath()},
+		{"config_dir", config.GetConfigDir()},
+		{"credentials", config.GetCredentialsFile()},
+		{"team_config", config.TeamConfigFile()},
+		{"templates", config.GetTemplateDir()},
+		{"cache_dir", config.GetCacheDir()},
+		{"state_dir", config.GetStateDir()},
+		{"reports", reportsDir()},
+		{"certificates", serverTLSDir()},
+		{"log_dir", config.GetLogDir()},
+		{"shell_history", shellHistoryPath()},
+		{"daemon", bridge.DefaultDaemonAddress(config.GetStateDir())},
+		{"runtime_home", config.GetHomeDir()},
+	}
+
+	items := make([]interface{}, 0, len(paths))
+	for _, p := range paths {
+		_, err := os.Stat(p.path)
+		items = append(items, map[string]interface{}{
+			"name":   p.name,
+			"path":   p.path,
+			"exists": err == nil,
+		})
+	}
+	return renderResult(map[string]interface{}{
+		"message": "UPID files and directories",
+		"paths":   items,
+	})
 }
 
 func systemLogs(cmd *cobra.Command, args []string) error {
@@ -249,23 +335,49 @@ func systemLogs(cmd *cobra.Command, args []string) error {
 	timeRange, _ := cmd.Flags().GetString("time-range")
 	follow, _ := cmd.Flags().GetBool("follow")
 	filter, _ := cmd.Flags().GetString("filter")
+	source, _ := cmd.Flags().GetString("source")
 
-	// Build arguments
-	cmdArgs := []string{"system", "logs"}
-	if level != "" {
-		cmdArgs = append(cmdArgs, "--level", level)
+	// Build query
+	query := logging.Query{
+		MinLevel: logging.ParseLevel(level),
+		Text:     filter,
 	}
 	if timeRange != "" {
-		cmdArgs = append(cmdArgs, "--time-range", timeRange)
+		window, err := timeutil.ParseDuration(timeRange)
+		if err != nil {
+			return err
+		}
+		query.Since = time.Now().Add(-window)
 	}
-	if follow {
-		cmdArgs = append(cmdArgs, "--follow")
+	switch source {
+	case "cli":
+		query.Sources = []string{logging.CLILog}
+	case "daemon":
+		query.Sources = []string{logging.DaemonLog}
+	case "all":
+		query.Sources = []string{logging.CLILog, logging.DaemonLog}
+	default:
+		return fmt.Errorf("invalid log source %q (expected cli, daemon or all)", source)
 	}
-	if filter != "" {
-		cmdArgs = append(cmdArgs, "--filter", filter)
+
+	logDir := config.GetLogDir()
+	entries, err := logging.Read(logDir, query)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fmt.Println(entry)
 	}
 
-	return executePythonCommand("system", cmdArgs)
-}
+	if !follow {
+		if len(entries) == 0 {
+			fmt.Fprintf(os.Stderr, "No log entries found in %s\n", logDir)
+		}
+		return nil
+	}
 
- 
\ No newline at end of file
+	// Follow until interrupted
+	return logging.Follow(cmd.Context(), logDir, query, func(entry logging.Entry) {
+		fmt.Println(entry)
+	})
+}
@@ -0,0 +1,147 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// teamRefreshTimeout bounds the automatic download of a stale team config
+const teamRefreshTimeout = 5 * time.Second
+
+// configSyncCmd creates the config sync command
+func configSyncCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Download shared team settings",
+		Long: `Download a team config published by your platform team and use its
+settings as defaults, e.g. pricing models, excluded namespaces or alert
+routes. Settings in your own config file, UPID_* environment variables and
+flags always override the team config.
+
+The URL must be https:// and is saved as sync.url; the team config is
+downloaded again when it is older than sync.interval (24h by default) the
+next time a command runs. A team config sets only shared policies: pricing.*,
+exclude.*, guardrails.*, approvals.* and alerts.routes.*. Other settings,
+which choose programs to run, where secrets are kept or which servers are
+trusted, are skipped.
+
+Examples:
+  upid config sync --from https://config.example.com/team-config.yaml
+  upid config sync                          # Download again now
+  upid config sync --interval 1h            # Check for changes every hour
+  upid config unset sync.url                # Stop using the team config`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return configSync(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("from", "", "URL of the team config (saved as sync.url)")
+	cmd.Flags().Duration("interval", 0, "how often to download the team config again (saved as sync.interval)")
+
+	return mutating(cmd)
+}
+
+// Implementation functions
+func configSync(cmd *cobra.Command, args []string) error {
+	url, _ := cmd.Flags().GetString("from")
+	if url == "" {
+		url = config.GetSyncConfig().URL
+	}
+	if url == "" {
+		return clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "no team config URL configured").
+			WithHint("Give the URL with 'upid config sync --from <url>'")
+	}
+
+	// Remember the settings, validated like 'config set'
+	changes := map[string]string{}
+	if cmd.Flags().Changed("from") {
+		changes["sync.url"] = url
+	}
+	if cmd.Flags().Changed("interval") {
+		interval, _ := cmd.Flags().GetDuration("interval")
+		changes["sync.interval"] = interval.String()
+	}
+	for name, raw := range changes {
+		key, _ := config.LookupKey(name)
+		if _, err := key.Parse(raw); err != nil {
+			return clierr.Wrap(err, clierr.CategoryUsage, "INVALID_CONFIG_VALUE", err.Error())
+		}
+	}
+
+	if IsDryRun() {
+		return printDryRun(fmt.Sprintf("download %s to %s", url, config.TeamConfigFile()))
+	}
+	result, err := config.SyncTeamConfig(cmd.Context(), url)
+	if err != nil {
+		return err
+	}
+
+	if len(changes) > 0 {
+		file, err := config.OpenFile(config.FilePath())
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{"sync.url", "sync.interval"} {
+			if raw, ok := changes[name]; ok {
+				if err := file.Set(name, raw); err != nil {
+					return err
+				}
+			}
+		}
+		if err := file.Save(); err != nil {
+			return err
+		}
+	}
+	if err := config.ReadConfigFile(); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Applied %d settings from %s", len(result.Applied), url)
+	if !result.Changed {
+		message = fmt.Sprintf("Team config from %s is unchanged (%d settings)", url, len(result.Applied))
+	}
+	if len(result.Skipped) > 0 {
+		message += fmt.Sprintf("; ignored %s, which a team config may not set", strings.Join(result.Skipped, ", "))
+	}
+	return renderResult(map[string]interface{}{
+		"message": message,
+		"url":     url,
+		"file":    config.TeamConfigFile(),
+		"changed": result.Changed,
+		"applied": result.Applied,
+		"skipped": result.Skipped,
+	})
+}
+
+// refreshTeamConfig downloads the team config again once the sync interval
+// has passed. Failures only warn, the previous copy stays in use.
+func refreshTeamConfig(cmd *cobra.Command) {
+	if RepairsConfig(cmd) || !config.TeamConfigStale() {
+		return
+	}
+	url := config.GetSyncConfig().URL
+	ctx, cancel := context.WithTimeout(cmd.Context(), teamRefreshTimeout)
+	defer cancel()
+	if _, err := config.SyncTeamConfig(ctx, url); err != nil {
+		slog.Warn("team config refresh failed", "url", url, "error", err)
+		if quiet, _ := activeFlagBool("quiet"); !quiet {
+			fmt.Fprintf(os.Stderr, "Warning: could not refresh team config from %s: %v\n", url, err)
+		}
+		return
+	}
+	slog.Info("team config refreshed", "url", url)
+	// Pick up the new defaults
+	if err := config.Reload(); err != nil {
+		slog.Warn("failed to reload configuration", "error", err)
+	}
+}
@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/native"
+	"github.com/kubilitics/upid-cli/internal/objectstore"
+	"github.com/spf13/cobra"
+)
+
+// uploadHelp documents the flags of addUploadFlags in the help of commands
+const uploadHelp = `--upload copies the file to object storage: s3://<bucket>/<prefix>,
+gs://<bucket>/<prefix> or azblob://<account>/<container>/<prefix>. Files
+are kept under <prefix>/<kind>/<context>/<yyyy>/<mm>/<dd>/, named with the
+time of the upload, and tagged with upid-kind and upid-context, so that
+lifecycle rules can expire them; a location with an extension, such as
+s3://reports/cost/latest.pdf, is the key itself. Objects are encrypted with
+--sse (S3: AES256 or aws:kms) and --sse-kms-key (the KMS key of S3, the
+Cloud KMS key of GCS or the encryption scope of Azure). Credentials are
+those of the AWS, gcloud and Azure tools: AWS_PROFILE or the AWS access keys,
+GOOGLE_APPLICATION_CREDENTIALS or the application default credentials,
+and AZURE_STORAGE_CONNECTION_STRING, AZURE_STORAGE_KEY or
+AZURE_STORAGE_SAS_TOKEN.`
+
+// addUploadFlags adds the flags that upload the file a command writes to
+// object storage
+func addUploadFlags(cmd *cobra.Command) {
+	cmd.Flags().String("upload", "", "upload the file to s3://, gs:// or azblob:// object storage")
+	cmd.Flags().String("sse", "", "upload: server-side encryption of S3, AES256 or aws:kms")
+	cmd.Flags().String("sse-kms-key", "", "upload: KMS key of S3 or GCS, or encryption scope of Azure")
+}
+
+// uploadTarget returns the location and options of --upload, nil if not
+// set. They are checked before the file is written, so that a bad location
+// fails fast.
+func uploadTarget(cmd *cobra.Command) (*objectstore.Location, objectstore.Options, error) {
+	// Get flags
+	upload, _ := cmd.Flags().GetString("upload")
+	sse, _ := cmd.Flags().GetString("sse")
+	kmsKey, _ := cmd.Flags().GetString("sse-kms-key")
+
+	opts := objectstore.Options{Encryption: sse, KMSKey: kmsKey}
+	if upload == "" {
+		if sse != "" || kmsKey != "" {
+			return nil, opts, fmt.Errorf("--sse and --sse-kms-key apply only to --upload")
+		}
+		return nil, opts, nil
+	}
+	location, err := objectstore.Parse(upload)
+	if err != nil {
+		return nil, opts, err
+	}
+	if err := location.Validate(opts); err != nil {
+		return nil, opts, err
+	}
+	return location, opts, nil
+}
+
+// exportFile returns the file an export is written to: --output-file, or a
+// temporary file to upload when it is not given. The returned function
+// removes the temporary file.
+func exportFile(outputFile, name string, location *objectstore.Location) (string, func(), error) {
+	if outputFile != "" || location == nil {
+		return outputFile, func() {}, nil
+	}
+	dir, err := os.MkdirTemp("", "upid-export-")
+	if err != nil {
+		return "", nil, err
+	}
+	return filepath.Join(dir, name), func() { os.RemoveAll(dir) }, nil
+}
+
+// uploadFile uploads a file to a location, under a key named by its kind,
+// such as reports, the kubeconfig context and the day. A dry run lists the
+// upload after the command that would write the file.
+func uploadFile(cmd *cobra.Command, location *objectstore.Location, opts objectstore.Options, file, kind, kubeContext string) error {
+	if kubeContext == "" {
+		if kubeconfig, err := native.LoadKubeconfig(); err == nil {
+			kubeContext = kubeconfig.CurrentContext
+		}
+	}
+	opts.Tags = map[string]string{"upid-kind": kind}
+	if kubeContext != "" {
+		kubeContext = fileSafe(kubeContext)
+		opts.Tags["upid-context"] = kubeContext
+	}
+	key := location.Key(kind, kubeContext, file, time.Now())
+	if IsDryRun() {
+		action := fmt.Sprintf("upload %s to %s", filepath.Base(file), location.Object(key))
+		slog.Info("dry run", "action", action)
+		fmt.Printf("  %s\n", action)
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s to upload: %v", file, err)
+	}
+	if err := objectstore.Upload(cmd.Context(), location, key, data, opts); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Uploaded %s to %s\n", filepath.Base(file), location.Object(key))
+	return nil
+}
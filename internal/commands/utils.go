@@ -1,27 +1,255 @@
 package commands
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
 	"github.com/kubilitics/upid-cli/internal/bridge"
+	"github.com/kubilitics/upid-cli/internal/clierr"
 	"github.com/kubilitics/upid-cli/internal/config"
+	"github.com/kubilitics/upid-cli/internal/logging"
+	"github.com/kubilitics/upid-cli/internal/output"
+	"github.com/kubilitics/upid-cli/internal/pyruntime"
+	"github.com/spf13/cobra"
 )
 
-// executePythonCommand executes a Python command through the bridge
-func executePythonCommand(command string, args []string) error {
+var (
+	// inShell is true while commands run inside `upid shell`
+	inShell bool
+
+	// sessionBridge is reused across commands in an interactive shell session
+	sessionBridge *bridge.PythonBridge
+
+	// activeCommand is the command being executed
+	activeCommand *cobra.Command
+
+	// commandColumns holds the registered table columns per command
+	commandColumns = map[*cobra.Command][]output.Column{}
+
+	// cancelTimeout releases the timeout of the previous command
+	cancelTimeout context.CancelFunc
+)
+
+// PrepareCommand sets up framework state for cmd. It must run before every
+// command.
+func PrepareCommand(cmd *cobra.Command) {
+	activeCommand = cmd
+	refreshTeamConfig(cmd)
+	prepareDryRun(cmd)
+	prepareTimeout(cmd)
+	warnConfigProblems(cmd)
+}
+
+// warnConfigProblems prints configuration problems that do not stop commands,
+// such as unknown keys
+func warnConfigProblems(cmd *cobra.Command) {
+	if quiet, _ := activeFlagBool("quiet"); quiet || RepairsConfig(cmd) {
+		return
+	}
+	for _, p := range config.Warnings() {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", p)
+	}
+}
+
+// prepareTimeout limits the run time of cmd to the configured timeout
+func prepareTimeout(cmd *cobra.Command) {
+	if cancelTimeout != nil {
+		cancelTimeout()
+		cancelTimeout = nil
+	}
+	if timeout := config.GetTimeout(); timeout > 0 {
+		ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+		cmd.SetContext(ctx)
+		cancelTimeout = cancel
+	}
+}
+
+// withColumns registers the table columns shown for cmd
+func withColumns(cmd *cobra.Command, columns []output.Column) *cobra.Command {
+	commandColumns[cmd] = columns
+	return cmd
+}
+
+// usePager returns true if output of the active command should be paged
+func usePager() bool {
+	pager, _ := activeFlagBool("pager")
+	return pager
+}
+
+// activeFlagBool returns a boolean flag of the active command
+func activeFlagBool(name string) (bool, error) {
+	if activeCommand == nil {
+		return false, nil
+	}
+	return activeCommand.Flags().GetBool(name)
+}
+
+// renderOptions returns the output options for the active command
+func renderOptions() output.Options {
+	opts := output.Options{
+		Format: config.GetOutputFormat(),
+		Color:  output.ColorEnabled(config.IsNoColor(), os.Stdout),
+	}
+	if activeCommand != nil {
+		opts.Columns = commandColumns[activeCommand]
+		opts.Select, _ = activeCommand.Flags().GetStringSlice("columns")
+		opts.SortBy, _ = activeCommand.Flags().GetString("sort-by")
+		opts.Filters, _ = activeCommand.Flags().GetStringArray("filter")
+		opts.PageSize, _ = activeCommand.Flags().GetInt("page-size")
+		opts.Page, _ = activeCommand.Flags().GetInt("page")
+		opts.Quiet, _ = activeCommand.Flags().GetBool("quiet")
+	}
+	return opts
+}
+
+// getBridge returns the Python bridge for the current invocation
+func getBridge() *bridge.PythonBridge {
+	if inShell && sessionBridge != nil {
+		setCommandPolicy(sessionBridge)
+		setSessionEnv(sessionBridge)
+		return sessionBridge
+	}
+
 	// Create Python bridge
 	pythonPath := config.GetPythonPath()
 	scriptPath := config.GetScriptPath()
 	debug := config.IsDebug()
 
-	bridge := bridge.NewPythonBridge(pythonPath, scriptPath, debug)
+	pb := bridge.NewPythonBridge(pythonPath, scriptPath, debug)
+	rt, err := pyruntime.Discover(pyruntime.Options{
+		Home:   config.GetHomeDir(),
+		Python: pythonPath,
+		Image:  config.GetRuntimeImage(),
+	})
+	if err != nil {
+		slog.Debug("runtime discovery failed", "error", err)
+	} else {
+		pb.SetRuntime(rt)
+	}
+	setCommandPolicy(pb)
+	pb.SetDiagnosticsDir(filepath.Join(config.GetLogDir(), "diagnostics"))
+	setSessionEnv(pb)
+	if quiet, _ := activeFlagBool("quiet"); !quiet {
+		pb.SetProgressWriter(os.Stderr)
+	}
+	pb.EnableDaemon(
+		bridge.DefaultDaemonAddress(config.GetStateDir()),
+		filepath.Join(config.GetLogDir(), logging.DaemonLog),
+		config.IsDaemonEnabled(),
+	)
+	if inShell {
+		sessionBridge = pb
+	}
+	return pb
+}
+
+// setCommandPolicy sets how the bridge retries the active command, which
+// changes with each command of a shell session
+func setCommandPolicy(pb *bridge.PythonBridge) {
+	mutating := activeCommand != nil && activeCommand.Annotations[annotationMutating] == "true"
+	pb.SetMutating(mutating)
+	if mutating {
+		// A failed change may have been partly applied, never repeat it
+		pb.SetRetryPolicy(bridge.NoRetry)
+		return
+	}
+	retry := config.GetRetryConfig()
+	pb.SetRetryPolicy(bridge.RetryPolicy{
+		MaxAttempts:  retry.MaxAttempts,
+		InitialDelay: retry.InitialDelay,
+		MaxDelay:     retry.MaxDelay,
+	})
+}
+
+// setSessionEnv passes the active profile, its endpoint, its datasource, its
+// pricing model, its budgets and its stored credentials to the runtime
+func setSessionEnv(pb *bridge.PythonBridge) {
+	pb.SetEnv("UPID_API_URL", config.GetEndpoint())
+	pb.SetEnv("UPID_PROFILE", config.GetProfile())
+	// Dashboards and reports show the burn-down of the budgets
+	pb.SetEnv("UPID_BUDGETS_FILE", budgetFile())
+	setDatasourceEnv(pb)
+	setPricingEnv(pb)
+	setCredentialEnv(pb)
+}
+
+// executePythonCommand executes a Python command through the bridge
+func executePythonCommand(ctx context.Context, command string, args []string) error {
+	if err := checkLogin(ctx); err != nil {
+		return err
+	}
+	bridge := getBridge()
+	if IsDryRun() {
+		return printDryRun(bridge.CommandLine(command, append(args, "--format", "json")))
+	}
+
+	// Repeated analyses may be answered from the result cache
+	store, key := resultCache(command, args)
+	if result, ok := loadCachedResult(store, key); ok {
+		if err := renderResult(result); err != nil {
+			return err
+		}
+		return partialResultError(result)
+	}
 
-	// Execute command
-	output, err := bridge.ExecuteCommandWithTable(command, args)
+	// Execute command on cluster data collected in Go where possible
+	defer provideSnapshot(ctx, bridge, command)()
+	result, err := bridge.ExecuteCommandWithJSON(ctx, command, args)
 	if err != nil {
-		return fmt.Errorf("failed to execute %s command: %v", command, err)
+		return fmt.Errorf("failed to execute %s command: %w", command, err)
+	}
+	storeCachedResult(store, key, result)
+
+	// Render output
+	if err := renderResult(result); err != nil {
+		return err
+	}
+	return partialResultError(result)
+}
+
+// streamPythonCommand executes a Python command and shows its output as it is
+// produced, for long-running commands whose output is not a single result
+func streamPythonCommand(ctx context.Context, command string, args []string) error {
+	if err := checkLogin(ctx); err != nil {
+		return err
+	}
+	bridge := getBridge()
+	if IsDryRun() {
+		return printDryRun(bridge.CommandLine(command, args))
 	}
 
-	// Print output
-	fmt.Print(output)
+	if err := bridge.ExecuteCommandStreaming(ctx, command, args, os.Stdout); err != nil {
+		return fmt.Errorf("failed to execute %s command: %w", command, err)
+	}
 	return nil
-} 
\ No newline at end of file
+}
+
+// renderResult writes a command result in the requested output format
+func renderResult(result interface{}) error {
+	opts := renderOptions()
+	return output.WithPager(usePager(), func(w io.Writer) error {
+		return output.Render(w, result, opts)
+	})
+}
+
+// partialResultError returns an error if the runtime reported that only part
+// of the result could be computed
+func partialResultError(result map[string]interface{}) error {
+	if partial, _ := result["partial"].(bool); !partial {
+		return nil
+	}
+	message := "command returned partial results"
+	if errs, ok := result["errors"].([]interface{}); ok && len(errs) > 0 {
+		message = fmt.Sprintf("%s (%d errors, first: %v)", message, len(errs), errs[0])
+	}
+	return clierr.New(clierr.CategoryPartial, "PARTIAL_RESULTS", message)
+}
+
+// IsJSONOutput returns true if results and errors are printed as JSON
+func IsJSONOutput() bool {
+	return config.GetOutputFormat() == output.FormatJSON
+}
@@ -0,0 +1,167 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/oidc"
+	"github.com/spf13/cobra"
+)
+
+// authWhoamiCmd creates the whoami command
+func authWhoamiCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "whoami",
+		Short: "Show the current identity",
+		Long: `Show the user, organization and roles of the current login and when its
+token expires. SSO sessions are read locally; other logins and API keys are
+looked up with the UPID API.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return authWhoami(cmd, args)
+		},
+	}
+
+	return cmd
+}
+
+// authCanICmd creates the can-i command
+func authCanICmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "can-i <command>...",
+		Short: "Check whether you may run a command",
+		Long: `Check whether the current identity is allowed to run a command, optionally
+on a given cluster. Prints yes or no, and exits with status 3 for no, so
+scripts can check permissions before making changes.`,
+		Example: `  # May I apply optimizations to the prod cluster?
+  upid auth can-i optimize apply --cluster prod
+
+  # Check quietly in a script
+  upid auth can-i report generate -q && upid report generate executive`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return authCanI(cmd, args)
+		},
+	}
+
+	// Add flags
+	cmd.Flags().String("cluster", "", "cluster the command would run on (default all clusters)")
+
+	return cmd
+}
+
+// Implementation functions
+func authWhoami(cmd *cobra.Command, args []string) error {
+	session, err := loadSession()
+	if err != nil {
+		return err
+	}
+	if session == nil || apiKeyInUse() {
+		return executePythonCommand(cmd.Context(), "auth", []string{"whoami"})
+	}
+	if session, err = currentSession(cmd.Context()); err != nil {
+		return err
+	}
+	return renderResult(sessionIdentity(session))
+}
+
+func authCanI(cmd *cobra.Command, args []string) error {
+	cluster, _ := cmd.Flags().GetString("cluster")
+
+	// Name the operation like the command path, e.g. optimize.apply
+	target, rest, err := cmd.Root().Find(args)
+	if err != nil || target == cmd.Root() || len(rest) > 0 {
+		return clierr.New(clierr.CategoryUsage, "UNKNOWN_COMMAND", fmt.Sprintf("unknown command %q", strings.Join(args, " "))).
+			WithHint("Name a command as you would run it, e.g. 'upid auth can-i optimize apply'")
+	}
+	operation := strings.ReplaceAll(strings.TrimPrefix(target.CommandPath(), cmd.Root().Name()+" "), " ", ".")
+
+	cmdArgs := []string{"can-i", operation}
+	if cluster != "" {
+		cmdArgs = append(cmdArgs, "--cluster", cluster)
+	}
+	pb := getBridge()
+	if IsDryRun() {
+		return printDryRun(pb.CommandLine("auth", append(cmdArgs, "--format", "json")))
+	}
+	result, err := pb.ExecuteCommandWithJSON(cmd.Context(), "auth", cmdArgs)
+	if err != nil {
+		return fmt.Errorf("failed to execute auth command: %w", err)
+	}
+
+	allowed, _ := result["allowed"].(bool)
+	answer := "no"
+	if allowed {
+		answer = "yes"
+	}
+	if _, ok := result["message"]; !ok {
+		result["message"] = answer
+	}
+	if err := renderResult(result); err != nil {
+		return err
+	}
+	if !allowed {
+		message := fmt.Sprintf("not allowed to run %s", operation)
+		if reason, _ := result["reason"].(string); reason != "" {
+			message += ": " + reason
+		}
+		return clierr.New(clierr.CategoryAuth, "NOT_ALLOWED", message)
+	}
+	return nil
+}
+
+// apiKeyInUse returns true if an API key authenticates commands in place of
+// the stored login
+func apiKeyInUse() bool {
+	return os.Getenv(apiKeyEnv) != ""
+}
+
+// sessionIdentity describes the identity of an SSO session from the claims
+// of its ID token
+func sessionIdentity(session *oidc.Session) map[string]interface{} {
+	claims := session.Claims()
+	identity := map[string]interface{}{
+		"user":        session.Subject(),
+		"auth_method": "sso",
+		"issuer":      session.Issuer,
+	}
+	if subject, ok := claims["sub"].(string); ok {
+		identity["subject"] = subject
+	}
+	for _, claim := range []string{"org", "org_name", "organization", "org_id", "tid"} {
+		if org, ok := claims[claim].(string); ok && org != "" {
+			identity["organization"] = org
+			break
+		}
+	}
+	roles := claimStrings(claims, "roles", "groups")
+	if len(roles) > 0 {
+		identity["roles"] = roles
+	}
+	if !session.Expiry.IsZero() {
+		identity["expires_at"] = session.Expiry.Format(time.RFC3339)
+		identity["expires_in"] = time.Until(session.Expiry).Round(time.Second).String()
+	}
+	return identity
+}
+
+// claimStrings returns the sorted, distinct string values of list claims
+func claimStrings(claims map[string]interface{}, names ...string) []string {
+	seen := map[string]bool{}
+	var values []string
+	for _, name := range names {
+		list, _ := claims[name].([]interface{})
+		for _, item := range list {
+			if value, ok := item.(string); ok && !seen[value] {
+				seen[value] = true
+				values = append(values, value)
+			}
+		}
+	}
+	sort.Strings(values)
+	return values
+}
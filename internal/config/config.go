@@ -1,10 +1,18 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/kubilitics/upid-cli/internal/logging"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -17,7 +25,248 @@ type Config struct {
 	PythonPath string `mapstructure:"python_path"`
 	ScriptPath  string `mapstructure:"script_path"`
 	OutputFormat string `mapstructure:"output_format"`
+	NoColor      bool   `mapstructure:"no_color"`
 	ConfigFile   string `mapstructure:"config_file"`
+	Daemon       bool   `mapstructure:"daemon"`
+	Timeout      time.Duration `mapstructure:"timeout"`
+	RuntimeImage string `mapstructure:"runtime_image"`
+	Retry        RetryConfig `mapstructure:"retry"`
+	Cache        CacheConfig `mapstructure:"cache"`
+	Profile      string `mapstructure:"profile"`
+	Endpoint     string `mapstructure:"endpoint"`
+	Cluster      string `mapstructure:"cluster"`
+	Auth         AuthConfig `mapstructure:"auth"`
+	CredentialStore string `mapstructure:"credential_store"`
+	Sync         SyncConfig `mapstructure:"sync"`
+	Datasource   DatasourceConfig `mapstructure:"datasource"`
+	Approvals    ApprovalsConfig `mapstructure:"approvals"`
+	Exclude      ExcludeConfig `mapstructure:"exclude"`
+	Guardrails   GuardrailsConfig `mapstructure:"guardrails"`
+	Pricing      PricingConfig `mapstructure:"pricing"`
+	Alerts       AlertsConfig `mapstructure:"alerts"`
+	Dashboard    DashboardConfig `mapstructure:"dashboard"`
+}
+
+// AlertsConfig routes alerts to notification channels by severity. A team
+// config can set it for everyone.
+type AlertsConfig struct {
+	Routes AlertRoutes `mapstructure:"routes"`
+}
+
+// AlertRoutes are comma-separated names of the channels alerts of each
+// severity are posted to; alerts of a severity without a route go to every
+// channel that accepts them
+type AlertRoutes struct {
+	Critical string `mapstructure:"critical"`
+	Warning  string `mapstructure:"warning"`
+	Info     string `mapstructure:"info"`
+}
+
+// Channels returns the channels routed alerts of each severity are posted
+// to, by severity
+func (r AlertRoutes) Channels() map[string][]string {
+	routes := map[string][]string{}
+	for severity, names := range map[string]string{"critical": r.Critical, "warning": r.Warning, "info": r.Info} {
+		for _, name := range strings.Split(names, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				routes[severity] = append(routes[severity], name)
+			}
+		}
+	}
+	return routes
+}
+
+// PricingConfig selects where cost analyses get prices from: list prices,
+// or what AWS billed from a Cost and Usage Report or Cost Explorer. The list
+// prices can be replaced by those of an on-premises or private cloud.
+type PricingConfig struct {
+	// Source is list, aws-cur or aws-cost-explorer
+	Source string `mapstructure:"source"`
+	// CUR is the s3:// URL or local path of the manifest of a Cost and
+	// Usage Report, or of one of its CSV files
+	CUR string `mapstructure:"cur"`
+	// AWSRegion is the region of the report bucket
+	AWSRegion string `mapstructure:"aws_region"`
+	// AWSProfile selects credentials of the shared AWS credentials file
+	AWSProfile string `mapstructure:"aws_profile"`
+	// CPU is the price of a vCPU-hour
+	CPU float64 `mapstructure:"cpu"`
+	// Memory is the price of a GiB-hour of memory
+	Memory float64 `mapstructure:"memory"`
+	// GPU is the price of a GPU-hour, 0 to leave GPUs unpriced
+	GPU float64 `mapstructure:"gpu"`
+	// Storage is the price of a GiB-month of persistent volumes
+	Storage float64 `mapstructure:"storage"`
+	// Currency is the ISO 4217 code of the prices
+	Currency string `mapstructure:"currency"`
+}
+
+// GuardrailsConfig are the checks optimizations must pass before they
+// change a workload. A change failing one is blocked unless overridden.
+type GuardrailsConfig struct {
+	// MinReplicas is the fewest replicas a workload may be scaled to
+	MinReplicas int `mapstructure:"min_replicas"`
+	// MaxReduction is the largest reduction of replicas, CPU or memory
+	// requests of one change, in percent, 0 for no limit
+	MaxReduction int `mapstructure:"max_reduction"`
+	// ProtectedNamespaces are comma-separated glob patterns of namespaces
+	// whose workloads are only changed with an override
+	ProtectedNamespaces string `mapstructure:"protected_namespaces"`
+	// BusinessHours are windows, such as Mon-Fri 09:00-18:00, during which
+	// no changes are made
+	BusinessHours string `mapstructure:"business_hours"`
+	// Timezone of the business hours, local time if empty
+	Timezone string `mapstructure:"timezone"`
+	// PDB blocks changes that PodDisruptionBudgets do not allow
+	PDB bool `mapstructure:"pdb"`
+	// HPA blocks changes that conflict with HorizontalPodAutoscalers
+	HPA bool `mapstructure:"hpa"`
+}
+
+// ExcludeConfig lists the workloads that optimizations leave alone, like
+// those annotated with upid.io/exclude: "true"
+type ExcludeConfig struct {
+	// Namespaces are comma-separated glob patterns
+	Namespaces string `mapstructure:"namespaces"`
+	// Selector is a label selector matching workloads
+	Selector string `mapstructure:"selector"`
+	// Workloads are comma-separated glob patterns of names, as name or
+	// namespace/name
+	Workloads string `mapstructure:"workloads"`
+}
+
+// ApprovalsConfig is the policy selecting the clusters and namespaces where
+// optimizations wait for the approval of a second user before they are
+// applied, and where pending changes are kept. A team config can set it for
+// everyone.
+type ApprovalsConfig struct {
+	// Clusters are comma-separated glob patterns of protected kubeconfig
+	// contexts, none if empty
+	Clusters string `mapstructure:"clusters"`
+	// Namespaces are comma-separated glob patterns of the protected
+	// namespaces of those clusters, all if empty
+	Namespaces string `mapstructure:"namespaces"`
+	// Namespace holds the pending changes in each protected cluster
+	Namespace string `mapstructure:"namespace"`
+	// TTL is how long a change can be approved, and once approved applied
+	TTL time.Duration `mapstructure:"ttl"`
+}
+
+// Required reports whether changes to a namespace of the cluster of a
+// kubeconfig context need approval. Changes to the whole cluster, with an
+// empty namespace, do whenever the cluster is protected.
+func (a ApprovalsConfig) Required(kubeContext, namespace string) bool {
+	if !matchesAny(a.Clusters, kubeContext) {
+		return false
+	}
+	return namespace == "" || strings.TrimSpace(a.Namespaces) == "" || matchesAny(a.Namespaces, namespace)
+}
+
+// matchesAny reports whether name matches one of comma-separated glob
+// patterns
+func matchesAny(patterns, name string) bool {
+	for _, pattern := range strings.Split(patterns, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DatasourceConfig locates the Prometheus compatible datasource (Prometheus,
+// Thanos or Mimir) queried for historical usage. Its token and password are
+// kept in the credential store.
+type DatasourceConfig struct {
+	URL                string        `mapstructure:"url"`
+	Username           string        `mapstructure:"username"`
+	Tenant             string        `mapstructure:"tenant"`
+	CAFile             string        `mapstructure:"ca_file"`
+	CertFile           string        `mapstructure:"cert_file"`
+	KeyFile            string        `mapstructure:"key_file"`
+	InsecureSkipVerify bool          `mapstructure:"insecure_skip_verify"`
+	Timeout            time.Duration `mapstructure:"timeout"`
+}
+
+// DashboardConfig protects the dashboard server: Auth is "none", "basic"
+// (Username and a password), "token" (a bearer token) or "oidc" (single
+// sign-on with the auth.issuer, open to Users if set). The password and
+// token are kept in the credential store.
+//
+// It also lays the dashboard out: the comma-separated Widgets of its pages
+// in order, the clusters Pinned first, and the TimeRange and Refresh the
+// dashboard uses without --time-range and --refresh.
+type DashboardConfig struct {
+	Auth      string        `mapstructure:"auth"`
+	Username  string        `mapstructure:"username"`
+	Users     string        `mapstructure:"users"`
+	Widgets   string        `mapstructure:"widgets"`
+	Pinned    string        `mapstructure:"pinned"`
+	TimeRange string        `mapstructure:"time_range"`
+	Refresh   time.Duration `mapstructure:"refresh"`
+}
+
+// DashboardWidgets are the widgets of the dashboard, in their default order:
+// the summary figures, the cost trend, the cost by resource and by
+// namespace, the recommendations, and the clusters of the fleet view
+var DashboardWidgets = []string{"summary", "trend", "resources", "namespaces", "recommendations", "clusters"}
+
+// WidgetList returns the widgets the dashboard shows, in order
+func (d DashboardConfig) WidgetList() []string {
+	if widgets := splitList(d.Widgets); len(widgets) > 0 {
+		return widgets
+	}
+	return DashboardWidgets
+}
+
+// PinnedList returns the kubeconfig contexts of the pinned clusters
+func (d DashboardConfig) PinnedList() []string {
+	return splitList(d.Pinned)
+}
+
+// splitList returns the items of a comma-separated list
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Allows reports whether a user signed in with oidc may see the dashboard
+func (d DashboardConfig) Allows(user string) bool {
+	return strings.TrimSpace(d.Users) == "" || matchesAny(d.Users, user)
+}
+
+// SyncConfig controls downloading of a shared team config
+type SyncConfig struct {
+	URL      string        `mapstructure:"url"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// AuthConfig holds authentication preferences
+type AuthConfig struct {
+	Provider string `mapstructure:"provider"`
+	Issuer   string `mapstructure:"issuer"`
+	ClientID string `mapstructure:"client_id"`
+	Scopes   string `mapstructure:"scopes"`
+}
+
+// RetryConfig controls retries of transient runtime failures
+type RetryConfig struct {
+	MaxAttempts  int           `mapstructure:"max_attempts"`
+	InitialDelay time.Duration `mapstructure:"initial_delay"`
+	MaxDelay     time.Duration `mapstructure:"max_delay"`
+}
+
+// CacheConfig controls caching of analysis results
+type CacheConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	TTL     time.Duration `mapstructure:"ttl"`
 }
 
 var (
@@ -34,39 +283,169 @@ func Init() error {
 	viper.SetDefault("output_format", "table")
 	viper.SetDefault("python_path", "python3")
 	viper.SetDefault("script_path", "./upid_python/cli.py")
+	viper.SetDefault("daemon", false)
+	viper.SetDefault("runtime_image", "")
+	viper.SetDefault("retry.max_attempts", 3)
+	viper.SetDefault("retry.initial_delay", "500ms")
+	viper.SetDefault("retry.max_delay", "10s")
+	viper.SetDefault("cache.enabled", false)
+	viper.SetDefault("cache.ttl", "5m")
+	viper.SetDefault("profile", "")
+	viper.SetDefault("endpoint", "")
+	viper.SetDefault("cluster", "default")
+	viper.SetDefault("auth.provider", "default")
+	viper.SetDefault("auth.issuer", "")
+	viper.SetDefault("auth.client_id", "")
+	viper.SetDefault("auth.scopes", "openid profile email offline_access")
+	viper.SetDefault("credential_store", "auto")
+	viper.SetDefault("sync.url", "")
+	viper.SetDefault("sync.interval", "24h")
+	viper.SetDefault("datasource.url", "")
+	viper.SetDefault("datasource.timeout", "30s")
+	viper.SetDefault("approvals.clusters", "")
+	viper.SetDefault("approvals.namespaces", "")
+	viper.SetDefault("approvals.namespace", "upid-system")
+	viper.SetDefault("approvals.ttl", "24h")
+	viper.SetDefault("exclude.namespaces", "")
+	viper.SetDefault("exclude.selector", "")
+	viper.SetDefault("exclude.workloads", "")
+	viper.SetDefault("guardrails.min_replicas", 0)
+	viper.SetDefault("guardrails.max_reduction", 0)
+	viper.SetDefault("guardrails.protected_namespaces", "")
+	viper.SetDefault("guardrails.business_hours", "")
+	viper.SetDefault("guardrails.timezone", "")
+	viper.SetDefault("guardrails.pdb", true)
+	viper.SetDefault("guardrails.hpa", true)
+	viper.SetDefault("pricing.source", "list")
+	viper.SetDefault("pricing.cur", "")
+	viper.SetDefault("pricing.aws_region", "")
+	viper.SetDefault("pricing.aws_profile", "")
+	viper.SetDefault("pricing.cpu", 0.0316)
+	viper.SetDefault("pricing.memory", 0.0042)
+	viper.SetDefault("pricing.gpu", 0.0)
+	viper.SetDefault("pricing.storage", 0.08)
+	viper.SetDefault("pricing.currency", "USD")
+	viper.SetDefault("dashboard.auth", "none")
+	viper.SetDefault("dashboard.username", "")
+	viper.SetDefault("dashboard.users", "")
+	viper.SetDefault("dashboard.widgets", "")
+	viper.SetDefault("dashboard.pinned", "")
+	viper.SetDefault("dashboard.time_range", "")
+	viper.SetDefault("dashboard.refresh", "0s")
 
 	// Environment variables
 	viper.SetEnvPrefix("UPID")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
 	// Config file
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 	
-	// Search for config file in multiple locations
-	home, err := os.UserHomeDir()
-	if err == nil {
-		viper.AddConfigPath(filepath.Join(home, ".upid"))
+	// Move files from ~/.upid before searching for the config file
+	if notice := migrateLegacyDir(); notice != "" {
+		fmt.Fprintln(os.Stderr, notice)
 	}
+
+	// Search for config file in multiple locations
+	viper.AddConfigPath(GetConfigDir())
 	viper.AddConfigPath(".")
 	viper.AddConfigPath("./config")
 
-	// Read config file if it exists
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			return fmt.Errorf("failed to read config file: %v", err)
+	// Read config file if it exists. Invalid settings are reported by
+	// Reload, so that commands which repair the file still run.
+	_ = ReadConfigFile()
+
+	// Parse into struct, keeping the valid settings if some are not
+	globalConfig = &Config{}
+	_ = viper.Unmarshal(globalConfig)
+
+	return nil
+}
+
+// BindFlags binds the global command-line flags to configuration keys, so a
+// flag given on the command line overrides the config file and environment
+func BindFlags(flags *pflag.FlagSet) error {
+	bindings := map[string]string{
+		"debug":         "debug",
+		"verbose":       "verbose",
+		"output_format": "output",
+		"no_color":      "no-color",
+		"timeout":       "timeout",
+		"config_file":   "config",
+		"profile":       "profile",
+	}
+	for key, flag := range bindings {
+		if err := viper.BindPFlag(key, flags.Lookup(flag)); err != nil {
+			return fmt.Errorf("failed to bind flag %s: %v", flag, err)
 		}
 	}
+	return nil
+}
 
-	// Parse into struct
-	globalConfig = &Config{}
-	if err := viper.Unmarshal(globalConfig); err != nil {
-		return fmt.Errorf("failed to unmarshal config: %v", err)
+// Reload refreshes the global configuration from all sources, picking up
+// values of flags parsed since Init
+func Reload() error {
+	// A config file given with --config replaces the one found by search
+	var readErr error
+	if file := viper.GetString("config_file"); file != "" && file != viper.ConfigFileUsed() {
+		viper.SetConfigFile(file)
+		readErr = ReadConfigFile()
+	} else if ActiveProfile() != appliedProfile {
+		readErr = ReadConfigFile()
 	}
 
+	cfg := &Config{}
+	decodeErr := viper.Unmarshal(cfg)
+	globalConfig = cfg
+
+	// Prefer validation errors, which point at the offending line
+	problems = Validate()
+	if err := ValidationError(problems); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("failed to unmarshal config: %v", decodeErr)
+	}
 	return nil
 }
 
+// ReadConfigFile reads the config file again, e.g. after it was edited, and
+// applies the selected profile. A missing file is not an error.
+func ReadConfigFile() error {
+	err := viper.ReadInConfig()
+	if path := viper.ConfigFileUsed(); err != nil && path != "" {
+		// Encrypted files are not YAML until decrypted
+		if raw, readErr := os.ReadFile(path); readErr == nil && IsEncrypted(raw) {
+			err = readEncryptedConfig(path)
+		}
+	}
+	if err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to read config file: %v", err)
+		}
+	} else if err := expandConfigFile(); err != nil {
+		return fmt.Errorf("failed to expand config file: %v", err)
+	}
+	if err := applyTeamConfig(); err != nil {
+		return err
+	}
+	return applyProfile()
+}
+
+// readEncryptedConfig reads an encrypted config file into viper
+func readEncryptedConfig(path string) error {
+	data, err := ReadConfigData(path)
+	if err != nil {
+		return err
+	}
+	return viper.ReadConfig(bytes.NewReader(data))
+}
+
 // GetConfig returns the global configuration
 func GetConfig() *Config {
 	return globalConfig
@@ -82,9 +461,21 @@ func SetupLogging() {
 	}
 
 	// Configure logging output
-	if globalConfig.LogFile != "" {
-		// TODO: Implement file logging
+	logFile := globalConfig.LogFile
+	if logFile == "" {
+		logFile = filepath.Join(GetLogDir(), logging.CLILog)
+	}
+	if err := logging.Setup(globalConfig.LogLevel, logFile); err != nil && globalConfig.Debug {
+		fmt.Fprintf(os.Stderr, "Warning: file logging disabled: %v\n", err)
+	}
+}
+
+// GetLogDir returns the directory holding CLI and daemon log files
+func GetLogDir() string {
+	if globalConfig != nil && globalConfig.LogFile != "" {
+		return filepath.Dir(globalConfig.LogFile)
 	}
+	return filepath.Join(GetStateDir(), "logs")
 }
 
 // GetPythonPath returns the Python executable path
@@ -102,6 +493,109 @@ func GetOutputFormat() string {
 	return globalConfig.OutputFormat
 }
 
+// IsNoColor returns true if colored output is disabled
+func IsNoColor() bool {
+	return globalConfig.NoColor
+}
+
+// IsDaemonEnabled returns true if commands should run in the runtime daemon,
+// starting it when needed
+func IsDaemonEnabled() bool {
+	return globalConfig.Daemon
+}
+
+// GetRuntimeImage returns the container image used when no local Python
+// runtime is installed, "" to disable container runtimes
+func GetRuntimeImage() string {
+	return globalConfig.RuntimeImage
+}
+
+// GetRetryConfig returns the retry settings for transient failures
+func GetRetryConfig() RetryConfig {
+	return globalConfig.Retry
+}
+
+// GetCacheConfig returns the result cache settings
+func GetCacheConfig() CacheConfig {
+	return globalConfig.Cache
+}
+
+// GetEndpoint returns the UPID API endpoint, "" for the runtime default
+func GetEndpoint() string {
+	return globalConfig.Endpoint
+}
+
+// GetDefaultCluster returns the cluster used when a command names none
+func GetDefaultCluster() string {
+	return globalConfig.Cluster
+}
+
+// GetAuthProvider returns the provider used by 'auth login' by default
+func GetAuthProvider() string {
+	return globalConfig.Auth.Provider
+}
+
+// GetAuthConfig returns the authentication settings, including the OpenID
+// Connect issuer and client used for single sign-on
+func GetAuthConfig() AuthConfig {
+	return globalConfig.Auth
+}
+
+// GetDatasourceConfig returns the settings of the historical metrics
+// datasource, whose URL is empty if none is configured
+func GetDatasourceConfig() DatasourceConfig {
+	return globalConfig.Datasource
+}
+
+// GetApprovalsConfig returns the policy of which changes need approval
+func GetApprovalsConfig() ApprovalsConfig {
+	return globalConfig.Approvals
+}
+
+// GetExcludeConfig returns the workloads excluded from optimizations
+func GetExcludeConfig() ExcludeConfig {
+	return globalConfig.Exclude
+}
+
+// GetGuardrailsConfig returns the checks optimizations must pass before
+// they are applied
+func GetGuardrailsConfig() GuardrailsConfig {
+	return globalConfig.Guardrails
+}
+
+// GetPricingConfig returns where cost analyses get prices from
+func GetPricingConfig() PricingConfig {
+	return globalConfig.Pricing
+}
+
+// GetAlertsConfig returns the routes of alerts to notification channels
+func GetAlertsConfig() AlertsConfig {
+	return globalConfig.Alerts
+}
+
+// GetDashboardConfig returns how the dashboard server authenticates users
+// and lays out the dashboard
+func GetDashboardConfig() DashboardConfig {
+	return globalConfig.Dashboard
+}
+
+// GetCredentialStore returns where tokens and secrets are stored: "auto"
+// (the OS keychain if available, else a file), "keychain" or "file"
+func GetCredentialStore() string {
+	return globalConfig.CredentialStore
+}
+
+// GetCredentialsFile returns the file holding credentials when the OS
+// keychain is not used
+func GetCredentialsFile() string {
+	return filepath.Join(GetConfigDir(), "credentials.json")
+}
+
+// GetTimeout returns the maximum run time of a command, 0 for no limit
+func GetTimeout() time.Duration {
+	return globalConfig.Timeout
+}
+
 // IsDebug returns true if debug mode is enabled
 func IsDebug() bool {
 	return globalConfig.Debug
@@ -110,4 +604,4 @@ func IsDebug() bool {
 // IsVerbose returns true if verbose mode is enabled
 func IsVerbose() bool {
 	return globalConfig.Verbose
-} 
\ No newline at end of file
+} 
@@ -0,0 +1,427 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"filippo.io/age/plugin"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"golang.org/x/term"
+)
+
+// passphraseRecipient marks a config file encrypted with a passphrase in its
+// recipients file
+const passphraseRecipient = "passphrase"
+
+var (
+	// cachedPassphrase is the passphrase entered for the config file, so that
+	// it is asked for at most once per process
+	cachedPassphrase string
+
+	// decrypted caches the plaintext of encrypted config files, decryption
+	// with a passphrase is deliberately slow
+	decrypted = map[string]decryptedFile{}
+)
+
+// decryptedFile is the plaintext of an encrypted file at a point in time
+type decryptedFile struct {
+	modTime time.Time
+	size    int64
+	data    []byte
+}
+
+// IsEncrypted returns true if data is an encrypted config file
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header))
+}
+
+// RecipientsFile returns the file listing who can decrypt the config file at
+// path. It holds only public keys and can be committed with the config.
+func RecipientsFile(path string) string {
+	return path + ".recipients"
+}
+
+// IdentityFile returns the age identity used to decrypt the config file,
+// $UPID_CONFIG_IDENTITY or identity.txt in the data directory
+func IdentityFile() string {
+	if file := os.Getenv("UPID_CONFIG_IDENTITY"); file != "" {
+		return file
+	}
+	return filepath.Join(GetDataDir(), "identity.txt")
+}
+
+// ReadConfigData reads a config file, decrypting it if it is encrypted
+func ReadConfigData(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if cached, ok := decrypted[path]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.data, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !IsEncrypted(data) {
+		return data, err
+	}
+
+	plain, err := decrypt(data)
+	if err != nil {
+		return nil, clierr.Wrap(err, clierr.CategoryUsage, "CONFIG_DECRYPT_FAILED", fmt.Sprintf("failed to decrypt %s", path)).
+			WithHint("Set UPID_CONFIG_PASSPHRASE, or UPID_CONFIG_IDENTITY to an age identity file that can decrypt it")
+	}
+	decrypted[path] = decryptedFile{modTime: info.ModTime(), size: info.Size(), data: plain}
+	return plain, nil
+}
+
+// WriteConfigData writes a config file, encrypting it if it has a recipients
+// file
+func WriteConfigData(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+	if lines, err := readRecipientsFile(path); err == nil && len(data) > 0 {
+		recipients, err := parseRecipients(lines, false)
+		if err != nil {
+			return err
+		}
+		if data, err = encrypt(data, recipients); err != nil {
+			return err
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %v", RecipientsFile(path), err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	return nil
+}
+
+// EncryptFile encrypts the config file at path for recipients, which are age
+// public keys, plugin recipients (e.g. for a cloud KMS) or "passphrase". An
+// encrypted file is encrypted again, e.g. to change the recipients. The
+// recipients file is only kept if the encrypted file is written.
+func EncryptFile(path string, recipients []string) error {
+	data, err := ReadConfigData(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// A new passphrase is asked for even if the old one is known
+	cachedPassphrase = ""
+	parsed, err := parseRecipients(recipients, true)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		// Encrypt an empty document, so the file is marked as encrypted
+		data = []byte("{}\n")
+	}
+	encrypted, err := encrypt(data, parsed)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+	restore := recipientsRestorer(path)
+	content := "# Recipients of " + filepath.Base(path) + ", see 'upid config encrypt --help'\n" + strings.Join(recipients, "\n") + "\n"
+	if err := os.WriteFile(RecipientsFile(path), []byte(content), 0o644); err != nil {
+		restore()
+		return fmt.Errorf("failed to write %s: %v", RecipientsFile(path), err)
+	}
+	if err := os.WriteFile(path, encrypted, 0o600); err != nil {
+		restore()
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	return nil
+}
+
+// DecryptFile stores the config file at path in plain text again. The
+// recipients file is restored if the plain text cannot be written.
+func DecryptFile(path string) error {
+	data, err := ReadConfigData(path)
+	if err != nil {
+		return err
+	}
+	restore := recipientsRestorer(path)
+	if err := os.Remove(RecipientsFile(path)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %v", RecipientsFile(path), err)
+	}
+	if err := WriteConfigData(path, data); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+// recipientsRestorer returns a function that puts the recipients file of the
+// config file at path back as it is now, removing it if there is none, so
+// that a config file is never left in plain text with recipients or
+// encrypted without them
+func recipientsRestorer(path string) func() {
+	file := RecipientsFile(path)
+	previous, err := os.ReadFile(file)
+	return func() {
+		if err != nil {
+			os.Remove(file)
+			return
+		}
+		os.WriteFile(file, previous, 0o644)
+	}
+}
+
+// GenerateIdentity creates an age identity in IdentityFile and returns its
+// public key. An existing identity is kept.
+func GenerateIdentity() (string, error) {
+	path := IdentityFile()
+	if data, err := os.ReadFile(path); err == nil {
+		identities, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return "", fmt.Errorf("invalid identity file %s: %v", path, err)
+		}
+		if x25519, ok := identities[0].(*age.X25519Identity); ok {
+			return x25519.Recipient().String(), nil
+		}
+		return "", fmt.Errorf("identity file %s has no age key", path)
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate identity: %v", err)
+	}
+	content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), identity.Recipient(), identity)
+	if err := writePrivateFile(path, []byte(content)); err != nil {
+		return "", err
+	}
+	return identity.Recipient().String(), nil
+}
+
+// readRecipientsFile returns the recipients listed for the config file
+func readRecipientsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(RecipientsFile(path))
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// parseRecipients converts recipient strings to age recipients. confirm asks
+// for a new passphrase twice.
+func parseRecipients(lines []string, confirm bool) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, line := range lines {
+		switch {
+		case line == passphraseRecipient:
+			if len(lines) > 1 {
+				return nil, clierr.New(clierr.CategoryUsage, "INVALID_RECIPIENT", "a passphrase cannot be combined with other recipients")
+			}
+			passphrase, err := configPassphrase(confirm)
+			if err != nil {
+				return nil, err
+			}
+			recipient, err := age.NewScryptRecipient(passphrase)
+			if err != nil {
+				return nil, err
+			}
+			recipients = append(recipients, recipient)
+		case strings.HasPrefix(line, "age1") && strings.Count(line, "1") > 1 && !isX25519(line):
+			// age1<plugin>1..., handled by the age-plugin-<plugin> program
+			recipient, err := plugin.NewRecipient(line, pluginUI())
+			if err != nil {
+				return nil, clierr.Wrap(err, clierr.CategoryUsage, "INVALID_RECIPIENT", fmt.Sprintf("invalid recipient %q", line))
+			}
+			recipients = append(recipients, recipient)
+		default:
+			recipient, err := age.ParseX25519Recipient(line)
+			if err != nil {
+				return nil, clierr.Wrap(err, clierr.CategoryUsage, "INVALID_RECIPIENT", fmt.Sprintf("invalid recipient %q", line)).
+					WithHint("Use an age public key (age1...), a plugin recipient or passphrase")
+			}
+			recipients = append(recipients, recipient)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, clierr.New(clierr.CategoryUsage, "INVALID_RECIPIENT", "no recipients given")
+	}
+	return recipients, nil
+}
+
+// isX25519 returns true if s is an age X25519 public key
+func isX25519(s string) bool {
+	_, err := age.ParseX25519Recipient(s)
+	return err == nil
+}
+
+// encrypt encrypts data for recipients in ASCII armor, which diffs and
+// commits well
+func encrypt(data []byte, recipients []age.Recipient) ([]byte, error) {
+	var buf bytes.Buffer
+	armored := armor.NewWriter(&buf)
+	w, err := age.Encrypt(armored, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt config file: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to encrypt config file: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt config file: %v", err)
+	}
+	if err := armored.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt config file: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decrypt decrypts an armored config file with the identity file, falling
+// back to a passphrase
+func decrypt(data []byte) ([]byte, error) {
+	identities, err := loadIdentities()
+	if err != nil {
+		return nil, err
+	}
+	if len(identities) > 0 {
+		plain, err := decryptWith(data, identities)
+		var noMatch *age.NoIdentityMatchError
+		if err == nil || !errors.As(err, &noMatch) {
+			return plain, err
+		}
+	}
+
+	passphrase, err := configPassphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := decryptWith(data, []age.Identity{identity})
+	if err != nil {
+		cachedPassphrase = ""
+	}
+	return plain, err
+}
+
+// decryptWith decrypts an armored file with identities
+func decryptWith(data []byte, identities []age.Identity) ([]byte, error) {
+	r, err := age.Decrypt(armor.NewReader(bytes.NewReader(data)), identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// loadIdentities reads the identity file, which may hold age keys and plugin
+// identities; a missing file holds none
+func loadIdentities() ([]age.Identity, error) {
+	path := IdentityFile()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity file: %v", err)
+	}
+
+	var identities []age.Identity
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var identity age.Identity
+		if strings.HasPrefix(line, "AGE-PLUGIN-") {
+			identity, err = plugin.NewIdentity(line, pluginUI())
+		} else {
+			identity, err = age.ParseX25519Identity(line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity in %s: %v", path, err)
+		}
+		identities = append(identities, identity)
+	}
+	return identities, nil
+}
+
+// configPassphrase returns the passphrase of the config file from
+// $UPID_CONFIG_PASSPHRASE, or asks for it on the terminal. confirm asks
+// twice, for a new passphrase.
+func configPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv("UPID_CONFIG_PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+	if cachedPassphrase != "" {
+		return cachedPassphrase, nil
+	}
+	passphrase, err := readSecret("Config passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", clierr.New(clierr.CategoryUsage, "EMPTY_PASSPHRASE", "the passphrase cannot be empty")
+	}
+	if confirm {
+		again, err := readSecret("Confirm passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", clierr.New(clierr.CategoryUsage, "PASSPHRASE_MISMATCH", "the passphrases do not match")
+		}
+	}
+	cachedPassphrase = passphrase
+	return passphrase, nil
+}
+
+// readSecret reads a line from the terminal without echoing it
+func readSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", clierr.New(clierr.CategoryUsage, "PASSPHRASE_REQUIRED", "the config file is encrypted and no terminal is available to ask for the passphrase").
+			WithHint("Set UPID_CONFIG_PASSPHRASE")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	return string(secret), nil
+}
+
+// pluginUI lets age plugins, e.g. for a cloud KMS, talk to the user
+func pluginUI() *plugin.ClientUI {
+	return &plugin.ClientUI{
+		DisplayMessage: func(name, message string) error {
+			fmt.Fprintf(os.Stderr, "age-plugin-%s: %s\n", name, message)
+			return nil
+		},
+		RequestValue: func(name, prompt string, secret bool) (string, error) {
+			return readSecret(fmt.Sprintf("age-plugin-%s: %s ", name, prompt))
+		},
+		Confirm: func(name, prompt, yes, no string) (bool, error) {
+			return false, fmt.Errorf("age-plugin-%s asked for confirmation, which is not supported", name)
+		},
+		WaitTimer: func(name string) {
+			fmt.Fprintf(os.Stderr, "Waiting for age-plugin-%s...\n", name)
+		},
+	}
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/plugin"
+)
+
+// TestEncryptFileFailure encrypts config files for a plugin that is not
+// installed, which must leave them and their recipients as they were
+func TestEncryptFileFailure(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	missing := plugin.EncodeRecipient("upidmissing", []byte{1})
+
+	plain := filepath.Join(dir, "plain.yaml")
+	if err := os.WriteFile(plain, []byte("profile: default\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptFile(plain, []string{missing}); err == nil {
+		t.Fatal("encrypted for a missing plugin")
+	}
+	if _, err := os.Stat(RecipientsFile(plain)); !os.IsNotExist(err) {
+		t.Errorf("recipients file left after a failure: %v", err)
+	}
+	if err := WriteConfigData(plain, []byte("profile: other\n")); err != nil {
+		t.Errorf("config cannot be written after a failure: %v", err)
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(dir, "identity.txt")
+	if err := os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("UPID_CONFIG_IDENTITY", identityFile)
+	encrypted := filepath.Join(dir, "encrypted.yaml")
+	if err := os.WriteFile(encrypted, []byte("profile: default\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptFile(encrypted, []string{identity.Recipient().String()}); err != nil {
+		t.Fatal(err)
+	}
+	recipients, err := os.ReadFile(RecipientsFile(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptFile(encrypted, []string{missing}); err == nil {
+		t.Fatal("encrypted for a missing plugin")
+	}
+	if after, err := os.ReadFile(RecipientsFile(encrypted)); err != nil || string(after) != string(recipients) {
+		t.Errorf("recipients file changed after a failure: %q, %v", after, err)
+	}
+	data, err := ReadConfigData(encrypted)
+	if err != nil || string(data) != "profile: default\n" {
+		t.Errorf("config changed after a failure: %q, %v", data, err)
+	}
+}
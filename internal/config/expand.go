@@ -0,0 +1,71 @@
+package config
+
+import (
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// ExpandEnv replaces ${VAR} and $VAR in s with the values of environment
+// variables. $$ stands for a literal $.
+func ExpandEnv(s string) string {
+	return os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		return os.Getenv(name)
+	})
+}
+
+// undefinedVariables returns the environment variables referenced by s that
+// are not set
+func undefinedVariables(s string) []string {
+	var missing []string
+	os.Expand(s, func(name string) string {
+		if _, ok := os.LookupEnv(name); !ok && name != "$" {
+			missing = append(missing, name)
+		}
+		return ""
+	})
+	return missing
+}
+
+// expandValues expands environment variables in the strings of a decoded
+// config file
+func expandValues(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return ExpandEnv(v)
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = expandValues(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = expandValues(item)
+		}
+	}
+	return value
+}
+
+// expandConfigFile replaces the values read from the config file with their
+// expansions, so that secrets and machine-specific paths can come from the
+// environment
+func expandConfigFile() error {
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		return nil
+	}
+	data, err := ReadConfigData(path)
+	if err != nil || !strings.Contains(string(data), "$") {
+		return nil
+	}
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil || settings == nil {
+		// Reported by Validate
+		return nil
+	}
+	return viper.MergeConfigMap(expandValues(settings).(map[string]interface{}))
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// File is a config file being edited. Edits keep the comments and order of
+// the existing content.
+type File struct {
+	Path string
+	doc  yaml.Node
+}
+
+// FilePath returns the config file in use, or the default location if there
+// is none yet
+func FilePath() string {
+	if file := viper.GetString("config_file"); file != "" {
+		return file
+	}
+	if used := viper.ConfigFileUsed(); used != "" {
+		return used
+	}
+	return filepath.Join(GetConfigDir(), "config.yaml")
+}
+
+// OpenFile reads a config file for editing; a missing file is empty
+func OpenFile(path string) (*File, error) {
+	f := &File{Path: path}
+	data, err := ReadConfigData(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &f.doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	if f.doc.Kind == 0 {
+		f.doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if f.root().Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config file %s must contain a mapping of keys to values", path)
+	}
+	return f, nil
+}
+
+// Set sets a dotted key to value, creating parent mappings as needed
+func (f *File) Set(key string, value interface{}) error {
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return fmt.Errorf("failed to encode %s: %v", key, err)
+	}
+	// Empty mappings encode in flow style, which later keys would inherit
+	node.Style &^= yaml.FlowStyle
+
+	mapping := f.root()
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		child := lookup(mapping, part)
+		if child == nil {
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: part}, child)
+		}
+		if child.Kind != yaml.MappingNode {
+			return fmt.Errorf("cannot set %s: %s is not a mapping", key, part)
+		}
+		mapping = child
+	}
+
+	last := parts[len(parts)-1]
+	if existing := lookup(mapping, last); existing != nil {
+		// Keep comments attached to the old value
+		node.HeadComment, node.LineComment, node.FootComment = existing.HeadComment, existing.LineComment, existing.FootComment
+		*existing = node
+		return nil
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: last}, &node)
+	return nil
+}
+
+// Unset removes a dotted key and returns false if it was not set. Mappings
+// left empty are removed too.
+func (f *File) Unset(key string) bool {
+	return unset(f.root(), strings.Split(key, "."))
+}
+
+// Save writes the file, creating its directory if needed
+func (f *File) Save() error {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&f.doc); err != nil {
+		return fmt.Errorf("failed to encode config file: %v", err)
+	}
+	data := buf.Bytes()
+	if len(f.root().Content) == 0 {
+		data = nil
+	}
+	return WriteConfigData(f.Path, data)
+}
+
+// root returns the top-level mapping of the document
+func (f *File) root() *yaml.Node {
+	return f.doc.Content[0]
+}
+
+// lookup returns the value of key in a mapping node
+func lookup(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// unset removes the key at path from a mapping node
+func unset(mapping *yaml.Node, path []string) bool {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != path[0] {
+			continue
+		}
+		if len(path) > 1 {
+			child := mapping.Content[i+1]
+			if child.Kind != yaml.MappingNode || !unset(child, path[1:]) {
+				return false
+			}
+			if len(child.Content) > 0 {
+				return true
+			}
+		}
+		mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,363 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Value kinds of configuration keys
+const (
+	KindBool     = "bool"
+	KindInt      = "int"
+	KindDuration = "duration"
+	KindFloat    = "float"
+	KindString   = "string"
+)
+
+// Key describes a configuration key that can be set in the config file
+type Key struct {
+	// Name is the dotted path of the key, e.g. "retry.max_attempts"
+	Name string
+	// Kind is one of the Kind* constants
+	Kind string
+	// Description is shown in help and validation messages
+	Description string
+	// validate checks a parsed value beyond its kind
+	validate func(value interface{}) error
+	// fix suggests a valid value when validation fails
+	fix string
+}
+
+// keys are the configuration keys that can be set
+var keys = []Key{
+	{Name: "debug", Kind: KindBool, Description: "enable debug mode"},
+	{Name: "verbose", Kind: KindBool, Description: "enable verbose output"},
+	{Name: "log_level", Kind: KindString, Description: "log level (debug, verbose, info, warn, error)",
+		validate: oneOf("debug", "verbose", "info", "warn", "error"), fix: "use debug, verbose, info, warn or error"},
+	{Name: "log_file", Kind: KindString, Description: "CLI log file (default $XDG_STATE_HOME/upid/logs/cli.log)"},
+	{Name: "python_path", Kind: KindString, Description: "Python interpreter used for the runtime",
+		validate: executable, fix: "install Python 3 or set python_path to an existing interpreter, e.g. /usr/bin/python3"},
+	{Name: "script_path", Kind: KindString, Description: "legacy Python entry point"},
+	{Name: "output_format", Kind: KindString, Description: "default output format"},
+	{Name: "no_color", Kind: KindBool, Description: "disable colored output"},
+	{Name: "daemon", Kind: KindBool, Description: "run commands in the runtime daemon, starting it when needed"},
+	{Name: "timeout", Kind: KindDuration, Description: "abort commands that run longer than this (0 waits forever)"},
+	{Name: "runtime_image", Kind: KindString, Description: "container image used when no local runtime is installed"},
+	{Name: "retry.max_attempts", Kind: KindInt, Description: "attempts for transient runtime failures",
+		validate: atLeast(1), fix: "use 1 to disable retries, or a larger number"},
+	{Name: "retry.initial_delay", Kind: KindDuration, Description: "delay before the first retry"},
+	{Name: "retry.max_delay", Kind: KindDuration, Description: "upper bound of the delay between retries"},
+	{Name: "cache.enabled", Kind: KindBool, Description: "cache results of analysis commands"},
+	{Name: "cache.ttl", Kind: KindDuration, Description: "how long cached results are used"},
+	{Name: "endpoint", Kind: KindString, Description: "UPID API endpoint, e.g. https://api.upid.io",
+		validate: httpURL, fix: "use a full URL such as https://api.upid.io"},
+	{Name: "cluster", Kind: KindString, Description: "cluster used when a command names none"},
+	{Name: "auth.provider", Kind: KindString, Description: "provider used by 'auth login' when none is given"},
+	{Name: "auth.issuer", Kind: KindString, Description: "OpenID Connect issuer used by 'auth login --sso'",
+		validate: httpURL, fix: "use the issuer URL of your identity provider, e.g. https://login.example.com"},
+	{Name: "auth.client_id", Kind: KindString, Description: "OpenID Connect client ID of the UPID CLI"},
+	{Name: "auth.scopes", Kind: KindString, Description: "space-separated OpenID Connect scopes requested at login"},
+	{Name: "credential_store", Kind: KindString, Description: "where tokens and secrets are stored (auto, keychain, file)",
+		validate: oneOf("auto", "keychain", "file"), fix: "use auto, keychain or file"},
+	{Name: "current_profile", Kind: KindString, Description: "profile used when --profile and UPID_PROFILE are not set"},
+	{Name: "sync.url", Kind: KindString, Description: "URL of a team config downloaded by 'config sync'",
+		validate: httpsURL, fix: "use an https:// URL such as https://config.example.com/team-config.yaml"},
+	{Name: "sync.interval", Kind: KindDuration, Description: "how often the team config is downloaded again (0 only with 'config sync')"},
+	{Name: "datasource.url", Kind: KindString, Description: "Prometheus, Thanos or Mimir URL queried for historical usage",
+		validate: httpURL, fix: "use the base URL of the Prometheus API, e.g. https://prometheus.example.com"},
+	{Name: "datasource.username", Kind: KindString, Description: "user for basic authentication to the datasource"},
+	{Name: "datasource.tenant", Kind: KindString, Description: "tenant sent as X-Scope-OrgID, required by Mimir"},
+	{Name: "datasource.ca_file", Kind: KindString, Description: "CA certificate file verifying the datasource"},
+	{Name: "datasource.cert_file", Kind: KindString, Description: "client certificate file for mutual TLS with the datasource"},
+	{Name: "datasource.key_file", Kind: KindString, Description: "client key file for mutual TLS with the datasource"},
+	{Name: "datasource.insecure_skip_verify", Kind: KindBool, Description: "do not verify the datasource certificate"},
+	{Name: "datasource.timeout", Kind: KindDuration, Description: "time limit of each datasource query"},
+	{Name: "approvals.clusters", Kind: KindString, Description: "comma-separated kubeconfig contexts (globs) where optimizations need approval",
+		validate: globs, fix: "use patterns such as prod-* or prod-*,payments"},
+	{Name: "approvals.namespaces", Kind: KindString, Description: "comma-separated namespaces (globs) of those clusters that need approval (default all)",
+		validate: globs, fix: "use patterns such as shop or team-*,payments"},
+	{Name: "approvals.namespace", Kind: KindString, Description: "namespace holding changes awaiting approval in each protected cluster"},
+	{Name: "approvals.ttl", Kind: KindDuration, Description: "how long a change can be approved, and once approved applied"},
+	{Name: "exclude.namespaces", Kind: KindString, Description: "comma-separated namespaces (globs) optimizations leave alone",
+		validate: globs, fix: "use patterns such as kube-* or kube-*,monitoring"},
+	{Name: "exclude.selector", Kind: KindString, Description: "label selector of workloads optimizations leave alone, e.g. tier in (db,cache)",
+		validate: selector, fix: "use a Kubernetes label selector such as tier=db or tier in (db,cache)"},
+	{Name: "exclude.workloads", Kind: KindString, Description: "comma-separated workload names (globs), as name or namespace/name, optimizations leave alone",
+		validate: globs, fix: "use patterns such as payments or shop/db-*"},
+	{Name: "guardrails.min_replicas", Kind: KindInt, Description: "fewest replicas optimizations may scale a workload to",
+		validate: atLeast(0), fix: "use 0 to allow scaling to zero"},
+	{Name: "guardrails.max_reduction", Kind: KindInt, Description: "largest reduction of replicas or requests of one change, in percent (0 for no limit)",
+		validate: percent, fix: "use a percentage from 0 to 100, e.g. 50"},
+	{Name: "guardrails.protected_namespaces", Kind: KindString, Description: "comma-separated namespaces (globs) optimizations change only with --override",
+		validate: globs, fix: "use patterns such as prod-* or payments,billing"},
+	{Name: "guardrails.business_hours", Kind: KindString, Description: "windows during which optimizations are not applied, e.g. Mon-Fri 09:00-18:00",
+		validate: windows, fix: "use windows such as Mon-Fri 09:00-18:00 or Mon-Fri 08:00-20:00,Sat 10:00-14:00"},
+	{Name: "guardrails.timezone", Kind: KindString, Description: "IANA timezone of the business hours (default local time)",
+		validate: timezone, fix: "use a timezone such as Europe/Berlin or America/New_York"},
+	{Name: "guardrails.pdb", Kind: KindBool, Description: "block changes that PodDisruptionBudgets do not allow"},
+	{Name: "guardrails.hpa", Kind: KindBool, Description: "block changes that conflict with HorizontalPodAutoscalers"},
+	{Name: "pricing.source", Kind: KindString, Description: "where cost analyses get prices from (list, aws-cur, aws-cost-explorer)",
+		validate: oneOf("list", "aws-cur", "aws-cost-explorer"), fix: "use list, aws-cur or aws-cost-explorer"},
+	{Name: "pricing.cur", Kind: KindString, Description: "s3:// URL or path of the manifest of an AWS Cost and Usage Report",
+		validate: reportLocation, fix: "use s3://<bucket>/<prefix>/<report>/<period>/<report>-Manifest.json or a local CSV file"},
+	{Name: "pricing.aws_region", Kind: KindString, Description: "AWS region of the report bucket (default $AWS_REGION)"},
+	{Name: "pricing.aws_profile", Kind: KindString, Description: "profile of the shared AWS credentials file (default $AWS_PROFILE or the environment)"},
+	{Name: "pricing.cpu", Kind: KindFloat, Description: "price of a vCPU-hour when no billing source prices it",
+		validate: nonNegative},
+	{Name: "pricing.memory", Kind: KindFloat, Description: "price of a GiB-hour of memory when no billing source prices it",
+		validate: nonNegative},
+	{Name: "pricing.gpu", Kind: KindFloat, Description: "price of a GPU-hour when no billing source prices it (0 leaves GPUs unpriced)",
+		validate: nonNegative},
+	{Name: "pricing.storage", Kind: KindFloat, Description: "price of a GiB-month of persistent volumes when no billing source prices it",
+		validate: nonNegative},
+	{Name: "pricing.currency", Kind: KindString, Description: "ISO 4217 code of the currency of the prices, e.g. EUR",
+		validate: currency, fix: "use a three-letter currency code such as USD or EUR"},
+	{Name: "alerts.routes.critical", Kind: KindString, Description: "comma-separated notification channels critical alerts are posted to (default all)"},
+	{Name: "alerts.routes.warning", Kind: KindString, Description: "comma-separated notification channels warning alerts are posted to (default all)"},
+	{Name: "dashboard.auth", Kind: KindString, Description: "how the dashboard authenticates users (none, basic, token, oidc)",
+		validate: oneOf("none", "basic", "token", "oidc"), fix: "use none, basic, token or oidc"},
+	{Name: "dashboard.username", Kind: KindString, Description: "user of basic authentication of the dashboard"},
+	{Name: "dashboard.users", Kind: KindString, Description: "comma-separated emails or subjects (globs) signed in with oidc allowed to the dashboard (default all)",
+		validate: globs, fix: "use patterns such as *@example.com or alice@example.com,bob@example.com"},
+	{Name: "dashboard.widgets", Kind: KindString, Description: "comma-separated widgets the dashboard shows, in order (default " + strings.Join(DashboardWidgets, ",") + ")",
+		validate: widgets, fix: "use some of " + strings.Join(DashboardWidgets, ", ") + ", each once"},
+	{Name: "dashboard.pinned", Kind: KindString, Description: "comma-separated kubeconfig contexts the dashboard lists first"},
+	{Name: "dashboard.time_range", Kind: KindString, Description: "time range of usage the dashboard shows without --time-range (default current usage)",
+		validate: timeRange, fix: "use a time range such as 6h, 7d or 2w"},
+	{Name: "dashboard.refresh", Kind: KindDuration, Description: "how often the dashboard collects the clusters without --refresh (0 for 1m, 30s in the terminal)",
+		validate: minDuration(10 * time.Second), fix: "use 10s or more, e.g. 1m"},
+	{Name: "alerts.routes.info", Kind: KindString, Description: "comma-separated notification channels info alerts are posted to (default all)"},
+}
+
+// Keys returns the configuration keys that can be set, sorted by name
+func Keys() []Key {
+	result := append([]Key(nil), keys...)
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// LookupKey returns the configuration key with the given name
+func LookupKey(name string) (Key, bool) {
+	for _, key := range keys {
+		if key.Name == name {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// Parse converts a value given on the command line to the key's kind and
+// validates it. Values that reference environment variables are validated
+// after expansion and kept as written.
+func (k Key) Parse(raw string) (interface{}, error) {
+	written := raw
+	raw = ExpandEnv(raw)
+
+	var value interface{}
+	switch k.Kind {
+	case KindBool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false, got %q", k.Name, raw)
+		}
+		value = b
+	case KindInt:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a whole number, got %q", k.Name, raw)
+		}
+		value = n
+	case KindFloat:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number, got %q", k.Name, raw)
+		}
+		value = f
+	case KindDuration:
+		if _, err := time.ParseDuration(raw); err != nil {
+			return nil, fmt.Errorf("%s must be a duration such as 30s or 5m, got %q", k.Name, raw)
+		}
+		value = raw
+	default:
+		value = raw
+	}
+
+	if k.validate != nil {
+		if err := k.validate(value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", k.Name, err)
+		}
+	}
+	if written != raw {
+		return written, nil
+	}
+	return value, nil
+}
+
+// oneOf accepts only the given strings
+func oneOf(allowed ...string) func(interface{}) error {
+	return func(value interface{}) error {
+		for _, a := range allowed {
+			if value == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", value, strings.Join(allowed, ", "))
+	}
+}
+
+// atLeast accepts integers of at least min
+func atLeast(min int) func(interface{}) error {
+	return func(value interface{}) error {
+		if n, _ := value.(int); n < min {
+			return fmt.Errorf("must be at least %d", min)
+		}
+		return nil
+	}
+}
+
+// percent accepts integers from 0 to 100
+func percent(value interface{}) error {
+	if n, _ := value.(int); n < 0 || n > 100 {
+		return fmt.Errorf("must be from 0 to 100")
+	}
+	return nil
+}
+
+// nonNegative accepts numbers of at least 0
+func nonNegative(value interface{}) error {
+	if f, _ := value.(float64); f < 0 {
+		return fmt.Errorf("must not be negative")
+	}
+	return nil
+}
+
+// currency accepts ISO 4217 codes, three upper case letters
+func currency(value interface{}) error {
+	code := value.(string)
+	if len(code) != 3 || strings.ToUpper(code) != code || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return fmt.Errorf("%q is not a three-letter currency code", code)
+	}
+	return nil
+}
+
+// httpURL accepts absolute HTTP and HTTPS URLs
+func httpURL(value interface{}) error {
+	u, err := url.Parse(value.(string))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http:// or https:// URL", value)
+	}
+	return nil
+}
+
+// httpsURL accepts https:// URLs, for settings downloaded from servers
+// whose identity must be checked
+func httpsURL(value interface{}) error {
+	u, err := url.Parse(value.(string))
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q is not an https:// URL", value)
+	}
+	return nil
+}
+
+// reportLocation accepts s3://bucket/key URLs and local paths of reports
+func reportLocation(value interface{}) error {
+	location := value.(string)
+	if !strings.Contains(location, "://") {
+		return nil
+	}
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "s3" || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return fmt.Errorf("%q is not an s3://<bucket>/<key> URL or a local path", location)
+	}
+	return nil
+}
+
+// globs accepts comma-separated glob patterns
+func globs(value interface{}) error {
+	for _, pattern := range strings.Split(value.(string), ",") {
+		if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+			return fmt.Errorf("%q is not a valid pattern", strings.TrimSpace(pattern))
+		}
+	}
+	return nil
+}
+
+// widgets accepts comma-separated widgets of the dashboard, each once
+func widgets(value interface{}) error {
+	seen := map[string]bool{}
+	for _, widget := range splitList(value.(string)) {
+		known := false
+		for _, w := range DashboardWidgets {
+			known = known || w == widget
+		}
+		if !known {
+			return fmt.Errorf("%q is not a widget of the dashboard", widget)
+		}
+		if seen[widget] {
+			return fmt.Errorf("%q is listed twice", widget)
+		}
+		seen[widget] = true
+	}
+	return nil
+}
+
+// timeRange accepts time ranges such as 7d, or none
+func timeRange(value interface{}) error {
+	if value == "" {
+		return nil
+	}
+	if d, err := timeutil.ParseDuration(value.(string)); err != nil || d <= 0 {
+		return fmt.Errorf("%q is not a time range", value)
+	}
+	return nil
+}
+
+// minDuration accepts durations of at least min, or 0
+func minDuration(min time.Duration) func(interface{}) error {
+	return func(value interface{}) error {
+		if d, _ := time.ParseDuration(value.(string)); d != 0 && d < min {
+			return fmt.Errorf("must be 0 or at least %s", min)
+		}
+		return nil
+	}
+}
+
+// selector accepts Kubernetes label selectors
+func selector(value interface{}) error {
+	if _, err := labels.Parse(value.(string)); err != nil {
+		return fmt.Errorf("%q is not a label selector", value)
+	}
+	return nil
+}
+
+// windows accepts comma-separated time windows such as Mon-Fri 09:00-18:00
+func windows(value interface{}) error {
+	_, err := kube.ParseWindows(value.(string))
+	return err
+}
+
+// timezone accepts IANA timezone names
+func timezone(value interface{}) error {
+	if _, err := time.LoadLocation(value.(string)); err != nil {
+		return fmt.Errorf("%q is not a timezone", value)
+	}
+	return nil
+}
+
+// executable accepts paths or names of programs that can be found
+func executable(value interface{}) error {
+	if _, err := exec.LookPath(value.(string)); err != nil {
+		return fmt.Errorf("%q is not an executable program", value)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// appName names the UPID directory below each base directory
+const appName = "upid"
+
+// legacyLayout is true while files are kept in ~/.upid because they could
+// not be moved to the XDG base directories
+var legacyLayout bool
+
+// GetConfigDir returns the directory holding the config file and
+// credentials, $XDG_CONFIG_HOME/upid
+func GetConfigDir() string {
+	return baseDir("XDG_CONFIG_HOME", ".config", os.UserConfigDir)
+}
+
+// GetTemplateDir returns the directory holding the report templates of the
+// user, templates in the config directory
+func GetTemplateDir() string {
+	return filepath.Join(GetConfigDir(), "templates")
+}
+
+// GetCacheDir returns the directory holding cached results,
+// $XDG_CACHE_HOME/upid
+func GetCacheDir() string {
+	if legacyLayout {
+		return filepath.Join(legacyDir(), "cache")
+	}
+	return baseDir("XDG_CACHE_HOME", ".cache", os.UserCacheDir)
+}
+
+// GetStateDir returns the directory holding logs, shell history and the
+// daemon socket, $XDG_STATE_HOME/upid
+func GetStateDir() string {
+	return baseDir("XDG_STATE_HOME", filepath.Join(".local", "state"), localAppData)
+}
+
+// GetDataDir returns the directory holding the installed runtime,
+// $XDG_DATA_HOME/upid
+func GetDataDir() string {
+	return baseDir("XDG_DATA_HOME", filepath.Join(".local", "share"), localAppData)
+}
+
+// GetHomeDir returns the UPID home directory holding the installed runtime,
+// $UPID_HOME if set and the data directory otherwise
+func GetHomeDir() string {
+	if home := os.Getenv("UPID_HOME"); home != "" {
+		return home
+	}
+	return GetDataDir()
+}
+
+// baseDir returns the UPID directory below the XDG base directory named by
+// variable. Without the variable it is below ~/fallback, or on Windows below
+// the directory returned by windowsDir.
+func baseDir(variable, fallback string, windowsDir func() (string, error)) string {
+	if legacyLayout {
+		return legacyDir()
+	}
+	// Relative values are invalid per the specification
+	if base := os.Getenv(variable); filepath.IsAbs(base) {
+		return filepath.Join(base, appName)
+	}
+	if runtime.GOOS == "windows" {
+		if base, err := windowsDir(); err == nil {
+			return filepath.Join(base, appName)
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return legacyDir()
+	}
+	return filepath.Join(home, fallback, appName)
+}
+
+// localAppData returns %LOCALAPPDATA%
+func localAppData() (string, error) {
+	if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+		return dir, nil
+	}
+	return "", fmt.Errorf("%%LOCALAPPDATA%% is not set")
+}
+
+// legacyDir returns ~/.upid, where all files were kept before the XDG base
+// directories were used
+func legacyDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".upid"
+	}
+	return filepath.Join(home, ".upid")
+}
+
+// legacyEntries maps files in ~/.upid to their new locations
+func legacyEntries() map[string]string {
+	return map[string]string{
+		"config.yaml":      filepath.Join(GetConfigDir(), "config.yaml"),
+		"credentials.json": filepath.Join(GetConfigDir(), "credentials.json"),
+		"cache":            GetCacheDir(),
+		"logs":             filepath.Join(GetStateDir(), "logs"),
+		"shell_history":    filepath.Join(GetStateDir(), "shell_history"),
+		"runtime":          filepath.Join(GetDataDir(), "runtime"),
+	}
+}
+
+// migrateLegacyDir moves files from ~/.upid to the XDG base directories the
+// first time UPID runs after upgrading. It returns a notice for the user, ""
+// if nothing was moved. If the files cannot be moved they stay in ~/.upid,
+// which is then used as before.
+func migrateLegacyDir() string {
+	legacy := legacyDir()
+	if info, err := os.Stat(legacy); err != nil || !info.IsDir() {
+		return ""
+	}
+
+	type move struct{ from, to string }
+	var done []move
+	undo := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			_ = os.Rename(done[i].to, done[i].from)
+		}
+	}
+	for name, target := range legacyEntries() {
+		source := filepath.Join(legacy, name)
+		if _, err := os.Lstat(source); err != nil {
+			continue
+		}
+		if _, err := os.Lstat(target); err == nil {
+			// Created by a newer version, keep it
+			continue
+		}
+		err := os.MkdirAll(filepath.Dir(target), 0o700)
+		if err == nil {
+			err = os.Rename(source, target)
+		}
+		if err != nil {
+			// Keep everything in one place rather than half migrated
+			undo()
+			legacyLayout = true
+			return fmt.Sprintf("Warning: could not move %s to %s (%v); still using %s", source, target, err, legacy)
+		}
+		done = append(done, move{source, target})
+	}
+	if err := os.MkdirAll(GetConfigDir(), 0o700); err != nil {
+		undo()
+		legacyLayout = true
+		return ""
+	}
+
+	// Remove ~/.upid unless something we do not know about is left
+	os.Remove(filepath.Join(legacy, "daemon.sock"))
+	os.Remove(legacy)
+	if len(done) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Moved UPID files from %s to the XDG base directories, see 'upid system paths'", legacy)
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/spf13/viper"
+)
+
+// Profiles are named sets of settings in the config file that override the
+// top-level settings while selected:
+//
+//	current_profile: dev
+//	profiles:
+//	  dev:
+//	    endpoint: https://upid.dev.example.com
+//	    cluster: dev-eu
+//	  prod-saas:
+//	    endpoint: https://api.upid.io
+//	    output_format: json
+//
+// The profile is selected by --profile, $UPID_PROFILE or current_profile, in
+// that order.
+
+// appliedProfile is the profile merged into the configuration
+var appliedProfile string
+
+// ActiveProfile returns the name of the selected profile, "" for none
+func ActiveProfile() string {
+	if name := viper.GetString("profile"); name != "" {
+		return name
+	}
+	return viper.GetString("current_profile")
+}
+
+// ProfileNames returns the profiles defined in the config file, sorted
+func ProfileNames() []string {
+	names := make([]string, 0)
+	for name := range viper.GetStringMap("profiles") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Profile returns the settings of a profile and whether it exists
+func Profile(name string) (map[string]interface{}, bool) {
+	profiles := viper.GetStringMap("profiles")
+	settings, ok := profiles[name]
+	if !ok {
+		return nil, false
+	}
+	values, _ := settings.(map[string]interface{})
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	return values, true
+}
+
+// applyProfile merges the settings of the active profile over the settings
+// read from the config file. Environment variables and flags still take
+// precedence.
+func applyProfile() error {
+	name := ActiveProfile()
+	appliedProfile = ""
+	if name == "" {
+		return nil
+	}
+
+	settings, ok := Profile(name)
+	if !ok {
+		return clierr.New(clierr.CategoryUsage, "UNKNOWN_PROFILE", fmt.Sprintf("profile %q not found in %s", name, FilePath())).
+			WithHint("List profiles with 'upid config profile list' or create one with 'upid config profile create'")
+	}
+	if err := viper.MergeConfigMap(settings); err != nil {
+		return fmt.Errorf("failed to apply profile %s: %v", name, err)
+	}
+	appliedProfile = name
+	return nil
+}
+
+// GetProfile returns the profile in effect, "" for none
+func GetProfile() string {
+	return appliedProfile
+}
+
+// SelectProfile switches the running command to a profile, "" for the
+// settings outside of profiles, without changing the default profile
+func SelectProfile(name string) error {
+	viper.Set("profile", name)
+	if name == "" {
+		viper.Set("current_profile", "")
+	}
+	return Reload()
+}
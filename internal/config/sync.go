@@ -0,0 +1,257 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// maxTeamConfigBytes limits the size of a downloaded team config
+const maxTeamConfigBytes = 1 << 20
+
+// teamSharedKeys are the prefixes of the settings a team config may set:
+// the shared policies of pricing, exclusions, guardrails, approvals and
+// alert routes. Other settings choose programs that are run, where secrets
+// are kept or which servers are trusted, and stay under the user's control.
+var teamSharedKeys = []string{"pricing.", "exclude.", "guardrails.", "approvals.", "alerts.routes."}
+
+// teamShared returns true if a team config may set key
+func teamShared(key string) bool {
+	for _, prefix := range teamSharedKeys {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncClient downloads team configs, refusing redirects away from https
+var syncClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to %s is not https", req.URL.Redacted())
+		}
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		return nil
+	},
+}
+
+// SyncState records the last download of the team config
+type SyncState struct {
+	URL       string    `json:"url"`
+	FetchedAt time.Time `json:"fetched_at"`
+	ETag      string    `json:"etag,omitempty"`
+}
+
+// SyncResult describes a team config download
+type SyncResult struct {
+	// Changed is false if the server reported the config as unchanged
+	Changed bool
+	// Applied are the settings taken from the team config
+	Applied []string
+	// Skipped are settings a team config may not set
+	Skipped []string
+}
+
+// GetSyncConfig returns the team config sync settings
+func GetSyncConfig() SyncConfig {
+	return globalConfig.Sync
+}
+
+// TeamConfigFile returns the local copy of the team config
+func TeamConfigFile() string {
+	return filepath.Join(GetConfigDir(), "team.yaml")
+}
+
+// syncStateFile returns the file recording the last download
+func syncStateFile() string {
+	return filepath.Join(GetConfigDir(), "team-sync.json")
+}
+
+// ReadSyncState returns the state of the last download, the zero value if
+// the team config was never downloaded
+func ReadSyncState() SyncState {
+	var state SyncState
+	if data, err := os.ReadFile(syncStateFile()); err == nil {
+		_ = json.Unmarshal(data, &state)
+	}
+	return state
+}
+
+// TeamConfigStale returns true if the team config should be downloaded
+// again because the sync interval has passed
+func TeamConfigStale() bool {
+	settings := GetSyncConfig()
+	if settings.URL == "" || settings.Interval <= 0 {
+		return false
+	}
+	state := ReadSyncState()
+	return state.URL != settings.URL || time.Since(state.FetchedAt) > settings.Interval
+}
+
+// SyncTeamConfig downloads the team config from url and applies it. Only
+// https:// URLs are accepted, since the config sets policies for everyone.
+// The local copy is only replaced by a valid config.
+func SyncTeamConfig(ctx context.Context, url string) (*SyncResult, error) {
+	state := ReadSyncState()
+	if err := httpsURL(url); err != nil {
+		return nil, clierr.New(clierr.CategoryUsage, "INVALID_SYNC_URL", fmt.Sprintf("invalid team config URL: %v", err)).
+			WithHint("Publish the team config on an https:// server")
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, clierr.Wrap(err, clierr.CategoryUsage, "INVALID_SYNC_URL", fmt.Sprintf("invalid team config URL %q", url))
+	}
+	if state.URL == url && state.ETag != "" {
+		if _, err := os.Stat(TeamConfigFile()); err == nil {
+			request.Header.Set("If-None-Match", state.ETag)
+		}
+	}
+
+	response, err := syncClient.Do(request)
+	if err != nil {
+		return nil, clierr.Wrap(err, clierr.CategoryUnreachable, "SYNC_FAILED", "failed to download team config").
+			WithHint("Check the URL and your network connection; the last downloaded config stays in use")
+	}
+	defer response.Body.Close()
+
+	result := &SyncResult{}
+	var data []byte
+	switch {
+	case response.StatusCode == http.StatusNotModified:
+		if data, err = os.ReadFile(TeamConfigFile()); err != nil {
+			return nil, fmt.Errorf("failed to read team config: %v", err)
+		}
+	case response.StatusCode == http.StatusOK:
+		data, err = io.ReadAll(io.LimitReader(response.Body, maxTeamConfigBytes+1))
+		if err != nil {
+			return nil, clierr.Wrap(err, clierr.CategoryUnreachable, "SYNC_FAILED", "failed to download team config")
+		}
+		if len(data) > maxTeamConfigBytes {
+			return nil, clierr.New(clierr.CategoryGeneral, "SYNC_FAILED", fmt.Sprintf("team config is larger than %d bytes", maxTeamConfigBytes))
+		}
+		if err := ValidationError(teamProblems(ValidateData(url, data))); err != nil {
+			return nil, err
+		}
+		result.Changed = true
+	default:
+		return nil, clierr.New(clierr.CategoryGeneral, "SYNC_FAILED", fmt.Sprintf("failed to download team config: %s", response.Status)).
+			WithHint("Check the URL; the last downloaded config stays in use")
+	}
+
+	settings, err := parseTeamConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	result.Applied, result.Skipped = teamSettings(settings)
+
+	if result.Changed {
+		if err := writePrivateFile(TeamConfigFile(), data); err != nil {
+			return nil, err
+		}
+	}
+	state = SyncState{URL: url, FetchedAt: time.Now(), ETag: response.Header.Get("ETag")}
+	stateData, _ := json.MarshalIndent(state, "", "  ")
+	if err := writePrivateFile(syncStateFile(), stateData); err != nil {
+		return nil, err
+	}
+	if err := applyTeamConfig(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// teamProblems drops the problems of settings a team config may not set,
+// which are ignored anyway
+func teamProblems(problems []Problem) []Problem {
+	var kept []Problem
+	for _, p := range problems {
+		if teamShared(p.Key) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// applyTeamConfig makes the settings of the local team config copy defaults,
+// so that the config file, environment and flags override them. Values are
+// used as written, so a team config cannot read the user's environment.
+func applyTeamConfig() error {
+	data, err := os.ReadFile(TeamConfigFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read team config: %v", err)
+	}
+	settings, err := parseTeamConfig(data)
+	if err != nil {
+		return err
+	}
+	applied, _ := teamSettings(settings)
+	for _, key := range applied {
+		viper.SetDefault(key, settings[key])
+	}
+	return nil
+}
+
+// parseTeamConfig decodes a team config into settings keyed by dotted path
+func parseTeamConfig(data []byte) (map[string]interface{}, error) {
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("invalid team config: %v", err)
+	}
+	settings := map[string]interface{}{}
+	flatten("", tree, settings)
+	return settings, nil
+}
+
+// flatten stores the leaves of tree in settings under their dotted paths
+func flatten(prefix string, tree map[string]interface{}, settings map[string]interface{}) {
+	for key, value := range tree {
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 && prefix+key != "profiles" {
+			flatten(prefix+key+".", nested, settings)
+			continue
+		}
+		settings[prefix+key] = value
+	}
+}
+
+// teamSettings splits the keys of settings into those a team config may set
+// and those it may not, both sorted
+func teamSettings(settings map[string]interface{}) (applied, skipped []string) {
+	for key := range settings {
+		if teamShared(key) {
+			applied = append(applied, key)
+		} else {
+			skipped = append(skipped, key)
+		}
+	}
+	sort.Strings(applied)
+	sort.Strings(skipped)
+	return applied, skipped
+}
+
+// writePrivateFile writes data to path, readable only by the user
+func writePrivateFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
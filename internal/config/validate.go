@@ -0,0 +1,296 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is an invalid or suspicious setting found by Validate
+type Problem struct {
+	// Source is the config file, or the environment variable, that holds
+	// the setting
+	Source string `json:"source"`
+	// Line is the line in Source, 0 for environment variables
+	Line int `json:"line,omitempty"`
+	// Key is the dotted configuration key
+	Key string `json:"key"`
+	// Message describes the problem
+	Message string `json:"message"`
+	// Fix suggests how to correct it
+	Fix string `json:"fix"`
+	// Warning is true for problems that do not stop commands, such as
+	// unknown keys
+	Warning bool `json:"warning"`
+}
+
+// String formats the problem like a compiler diagnostic
+func (p Problem) String() string {
+	location := p.Source
+	if p.Line > 0 {
+		location = fmt.Sprintf("%s:%d", p.Source, p.Line)
+	}
+	return fmt.Sprintf("%s: %s (%s)", location, p.Message, p.Fix)
+}
+
+// problems are the problems found by the last Reload
+var problems []Problem
+
+// Warnings returns the problems found by the last Reload that did not stop
+// it, such as unknown keys
+func Warnings() []Problem {
+	var warnings []Problem
+	for _, p := range problems {
+		if p.Warning {
+			warnings = append(warnings, p)
+		}
+	}
+	return warnings
+}
+
+// Validate checks the config file in use and the UPID_* environment
+// variables for unknown keys and invalid values
+func Validate() []Problem {
+	path := FilePath()
+	var problems []Problem
+	if data, err := ReadConfigData(path); err == nil {
+		problems = ValidateData(path, data)
+	} else if !os.IsNotExist(err) {
+		fix := "check the file permissions"
+		if failure := clierr.From(err); failure.Hint != "" {
+			fix = failure.Hint
+		}
+		problems = append(problems, Problem{Source: path, Message: err.Error(), Fix: fix})
+	}
+
+	for _, key := range keys {
+		variable := "UPID_" + strings.ToUpper(strings.ReplaceAll(key.Name, ".", "_"))
+		if raw, ok := os.LookupEnv(variable); ok && raw != "" {
+			if _, err := key.Parse(raw); err != nil {
+				problems = append(problems, Problem{Source: "$" + variable, Key: key.Name, Message: err.Error(), Fix: key.fixHint()})
+			}
+		}
+	}
+	return problems
+}
+
+// ValidateData checks the content of a config file. name identifies the file
+// in problems.
+func ValidateData(name string, data []byte) []Problem {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return []Problem{{Source: name, Line: yamlErrorLine(err), Message: err.Error(), Fix: "correct the YAML syntax"}}
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return []Problem{{Source: name, Line: root.Line, Message: "the file must contain a mapping of keys to values", Fix: "write settings as key: value lines"}}
+	}
+
+	v := validator{source: name}
+	v.mapping(root, "", true)
+
+	// current_profile must name a profile
+	if current := lookup(root, "current_profile"); current != nil && current.Value != "" {
+		profiles := lookup(root, "profiles")
+		if profiles == nil || lookup(profiles, current.Value) == nil {
+			v.add(current, "current_profile", fmt.Sprintf("profile %q is not defined", current.Value),
+				"define it under profiles or run 'upid config profile use' with an existing profile", false)
+		}
+	}
+	return v.problems
+}
+
+// validator collects problems while walking a config file
+type validator struct {
+	source   string
+	problems []Problem
+}
+
+// add records a problem at node
+func (v *validator) add(node *yaml.Node, key, message, fix string, warning bool) {
+	v.problems = append(v.problems, Problem{Source: v.source, Line: node.Line, Key: key, Message: message, Fix: fix, Warning: warning})
+}
+
+// mapping validates the keys of a mapping node below prefix
+func (v *validator) mapping(node *yaml.Node, prefix string, topLevel bool) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, value := node.Content[i], node.Content[i+1]
+		name := prefix + keyNode.Value
+
+		if topLevel && name == "profiles" {
+			v.profiles(value)
+			continue
+		}
+		if key, ok := LookupKey(name); ok {
+			v.value(key, value)
+			continue
+		}
+		if isKeyPrefix(name) {
+			if value.Kind != yaml.MappingNode {
+				v.add(value, name, fmt.Sprintf("%s must be a mapping of settings", name),
+					fmt.Sprintf("nest its settings below it, e.g. %s", exampleKey(name)), false)
+				continue
+			}
+			v.mapping(value, name+".", false)
+			continue
+		}
+
+		fix := "remove it, or run 'upid config set --help' to list the available keys"
+		if suggestion := closestKey(name); suggestion != "" {
+			fix = fmt.Sprintf("did you mean %s?", suggestion)
+		}
+		v.add(keyNode, name, fmt.Sprintf("unknown key %s", name), fix, true)
+	}
+}
+
+// profiles validates the settings of each profile
+func (v *validator) profiles(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		v.add(node, "profiles", "profiles must be a mapping of profile names to settings",
+			"write each profile as a name followed by its settings", false)
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, settings := node.Content[i], node.Content[i+1]
+		if settings.Kind == yaml.ScalarNode && settings.Tag == "!!null" {
+			continue
+		}
+		if settings.Kind != yaml.MappingNode {
+			v.add(settings, "profiles."+name.Value, fmt.Sprintf("profile %s must be a mapping of settings", name.Value),
+				"write the profile's settings below its name", false)
+			continue
+		}
+		// Profiles hold the same settings as the top level
+		start := len(v.problems)
+		v.mapping(settings, "", false)
+		for j := start; j < len(v.problems); j++ {
+			v.problems[j].Key = "profiles." + name.Value + "." + v.problems[j].Key
+		}
+	}
+}
+
+// value validates the value of a known key
+func (v *validator) value(key Key, node *yaml.Node) {
+	if node.Kind != yaml.ScalarNode {
+		v.add(node, key.Name, fmt.Sprintf("%s must be a single %s value", key.Name, key.Kind), key.fixHint(), false)
+		return
+	}
+	if node.Tag == "!!null" {
+		return
+	}
+	for _, name := range undefinedVariables(node.Value) {
+		v.add(node, key.Name, fmt.Sprintf("%s references %s, which is not set", key.Name, name),
+			fmt.Sprintf("export %s before running upid, or write $$ for a literal $", name), true)
+	}
+	if _, err := key.Parse(node.Value); err != nil {
+		v.add(node, key.Name, err.Error(), key.fixHint(), false)
+	}
+}
+
+// fixHint suggests a valid value for the key
+func (k Key) fixHint() string {
+	if k.fix != "" {
+		return k.fix
+	}
+	switch k.Kind {
+	case KindBool:
+		return "use true or false"
+	case KindInt:
+		return "use a whole number"
+	case KindFloat:
+		return "use a number such as 0.05"
+	case KindDuration:
+		return "use a number with a unit, such as 30s, 5m or 1h"
+	}
+	return "see 'upid config set --help'"
+}
+
+// isKeyPrefix returns true if name is the parent of dotted keys
+func isKeyPrefix(name string) bool {
+	for _, key := range keys {
+		if strings.HasPrefix(key.Name, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// exampleKey returns a key nested below prefix
+func exampleKey(prefix string) string {
+	for _, key := range keys {
+		if strings.HasPrefix(key.Name, prefix+".") {
+			return key.Name
+		}
+	}
+	return prefix
+}
+
+// closestKey returns the known key most similar to name, "" if none is close
+func closestKey(name string) string {
+	best, bestDistance := "", 4
+	for _, key := range keys {
+		candidates := []string{key.Name}
+		// Also compare the last part, for keys placed at the wrong level
+		if i := strings.LastIndex(key.Name, "."); i >= 0 {
+			candidates = append(candidates, key.Name[i+1:])
+		}
+		for _, candidate := range candidates {
+			if d := editDistance(name, candidate); d < bestDistance {
+				best, bestDistance = key.Name, d
+			}
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// yamlErrorLine extracts the line number from a YAML syntax error
+func yamlErrorLine(err error) int {
+	var line int
+	if _, scanErr := fmt.Sscanf(err.Error(), "yaml: line %d:", &line); scanErr == nil {
+		return line
+	}
+	return 0
+}
+
+// ValidationError reports the errors among problems, nil if there are only
+// warnings
+func ValidationError(problems []Problem) error {
+	var lines []string
+	for _, p := range problems {
+		if !p.Warning {
+			lines = append(lines, "  "+p.String())
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return clierr.New(clierr.CategoryUsage, "INVALID_CONFIG", "invalid configuration:\n"+strings.Join(lines, "\n")).
+		WithHint("Fix the settings above, e.g. with 'upid config edit', or check them with 'upid config validate'")
+}
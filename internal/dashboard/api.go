@@ -0,0 +1,146 @@
+package dashboard
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// clusterFields are the figures of a cluster /api/v1/clusters lists, from
+// its summary report
+var clusterFields = []string{"monthly_cost", "requested_cost", "used_cost", "idle_cost", "savings", "workloads", "pods", "usage"}
+
+// costFields are the figures of a cluster /api/v1/costs lists, from its
+// summary report
+var costFields = []string{"monthly_cost", "requested_cost", "used_cost", "idle_cost", "savings", "breakdown", "namespaces"}
+
+// handleAPI adds the REST API of the dashboard to mux, under /api/v1: the
+// state last collected as the clusters, their recommendations and their
+// costs. See docs/DASHBOARD_API.md.
+func (s *Server) handleAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/clusters", func(w http.ResponseWriter, r *http.Request) {
+		state, clusters, ok := s.apiClusters(w, r)
+		if !ok {
+			return
+		}
+		items := make([]map[string]interface{}, 0, len(clusters))
+		for _, cluster := range clusters {
+			item := apiCluster(cluster, clusterFields)
+			offenders, _ := cluster.Report["offenders"].([]interface{})
+			if cluster.Error == "" {
+				item["recommendations"] = len(offenders)
+			}
+			items = append(items, item)
+		}
+		writeJSON(w, http.StatusOK, apiResponse(state, "clusters", items))
+	})
+	mux.HandleFunc("GET /api/v1/recommendations", func(w http.ResponseWriter, r *http.Request) {
+		state, clusters, ok := s.apiClusters(w, r)
+		if !ok {
+			return
+		}
+		namespace, action := r.URL.Query().Get("namespace"), r.URL.Query().Get("action")
+		items := []map[string]interface{}{}
+		for _, cluster := range clusters {
+			offenders, _ := cluster.Report["offenders"].([]interface{})
+			for _, offender := range offenders {
+				fields := offender.(map[string]interface{})
+				if namespace != "" && fields["namespace"] != namespace || action != "" && fields["action"] != action {
+					continue
+				}
+				item := map[string]interface{}{"cluster": cluster.Context}
+				for key, value := range fields {
+					item[key] = value
+				}
+				items = append(items, item)
+			}
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			a, _ := items[i]["savings"].(float64)
+			b, _ := items[j]["savings"].(float64)
+			return a > b
+		})
+		writeJSON(w, http.StatusOK, apiResponse(state, "recommendations", items))
+	})
+	mux.HandleFunc("GET /api/v1/costs", func(w http.ResponseWriter, r *http.Request) {
+		state, clusters, ok := s.apiClusters(w, r)
+		if !ok {
+			return
+		}
+		items := make([]map[string]interface{}, 0, len(clusters))
+		for _, cluster := range clusters {
+			item := apiCluster(cluster, costFields)
+			item["trend"] = cluster.Trend
+			items = append(items, item)
+		}
+		writeJSON(w, http.StatusOK, apiResponse(state, "costs", items))
+	})
+	mux.HandleFunc("GET /api/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such API, see /api/v1/clusters, /api/v1/recommendations and /api/v1/costs"})
+	})
+}
+
+// apiClusters returns the state last collected and its clusters, only those
+// of the cluster parameters if any, and answers the request if there are
+// none to return
+func (s *Server) apiClusters(w http.ResponseWriter, r *http.Request) (*State, []*Cluster, bool) {
+	state := s.State()
+	if state == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "the dashboard is collecting the clusters"})
+		return nil, nil, false
+	}
+	var names []string
+	for _, value := range r.URL.Query()["cluster"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return state, state.Clusters, true
+	}
+	clusters := make([]*Cluster, 0, len(names))
+	for _, name := range names {
+		var found *Cluster
+		for _, cluster := range state.Clusters {
+			if cluster.Context == name {
+				found = cluster
+			}
+		}
+		if found == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("the dashboard does not show cluster %q", name)})
+			return nil, nil, false
+		}
+		clusters = append(clusters, found)
+	}
+	return state, clusters, true
+}
+
+// apiCluster returns the context of a cluster with fields of its report, or
+// with the error that kept it from being collected
+func apiCluster(cluster *Cluster, fields []string) map[string]interface{} {
+	item := map[string]interface{}{"cluster": cluster.Context}
+	if cluster.Error != "" {
+		item["status"] = "error"
+		item["error"] = cluster.Error
+		return item
+	}
+	item["status"] = "ok"
+	for _, field := range fields {
+		if value, ok := cluster.Report[field]; ok {
+			item[field] = value
+		}
+	}
+	return item
+}
+
+// apiResponse returns the body of an API response listing items under key
+func apiResponse(state *State, key string, items []map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"collected_at": state.CollectedAt,
+		"currency":     state.Currency,
+		key:            items,
+	}
+}
@@ -0,0 +1,427 @@
+// The UPID dashboard renders the state the server collects: the cost of each
+// cluster, its trend and its recommendations, and with several clusters the
+// fleet view of all of them, with the widgets of its layout. It reloads the
+// state every refresh interval of the server, except in a snapshot, whose
+// state is frozen, and in an offline bundle, whose state data.js sets.
+(function () {
+  "use strict";
+
+  var main = document.getElementById("main");
+  var status = document.getElementById("status");
+  var picker = document.getElementById("cluster");
+  var sharing = document.getElementById("share");
+  var notice = document.getElementById("notice");
+  var state = null;
+  var bundled = !!window.UPID_STATE;
+  var colors = { cost: "#3b6fd8", savings: "#e07a1f", used: "#2f855a" };
+
+  function escape(value) {
+    return String(value === undefined || value === null ? "" : value).replace(/[&<>"']/g, function (c) {
+      return { "&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;" }[c];
+    });
+  }
+
+  function number(value) {
+    return typeof value === "number" ? value : 0;
+  }
+
+  function cost(value) {
+    var currency = state && state.currency;
+    var amount = number(value).toLocaleString(undefined, { minimumFractionDigits: 2, maximumFractionDigits: 2 });
+    return !currency || currency === "USD" ? "$" + amount : amount + " " + currency;
+  }
+
+  function share(part, whole) {
+    return whole > 0 ? (100 * part / whole).toFixed(1) + "%" : "-";
+  }
+
+  function when(value) {
+    return new Date(value).toLocaleString();
+  }
+
+  function figure(label, value, note) {
+    return '<div class="figure"><div class="label">' + escape(label) + '</div><div class="value">' + escape(value) +
+      '</div><div class="note">' + escape(note) + "</div></div>";
+  }
+
+  function section(title, text, body) {
+    return "<section><h2>" + escape(title) + "</h2>" + (text ? "<p>" + escape(text) + "</p>" : "") + body + "</section>";
+  }
+
+  // table renders rows of cells; a cell is text, or {text, numeric, class,
+  // html} for other cells
+  function table(columns, rows) {
+    var head = columns.map(function (column) {
+      return '<th class="' + (column.numeric ? "numeric" : "") + '">' + escape(column.name) + "</th>";
+    }).join("");
+    var body = rows.map(function (row) {
+      return "<tr>" + row.map(function (cell, i) {
+        var classes = [columns[i].numeric ? "numeric" : "", cell && cell.class ? cell.class : ""].join(" ");
+        var content = cell && cell.html !== undefined ? cell.html : escape(cell && cell.text !== undefined ? cell.text : cell);
+        return '<td class="' + classes + '">' + content + "</td>";
+      }).join("") + "</tr>";
+    }).join("");
+    return "<table><thead><tr>" + head + "</tr></thead><tbody>" + body + "</tbody></table>";
+  }
+
+  // meter renders a value with a bar of its share of the largest
+  function meter(text, value, max) {
+    var width = max > 0 ? Math.round(120 * value / max) : 0;
+    return { html: '<span class="meter" style="width:' + width + 'px"></span>' + escape(text), numeric: true };
+  }
+
+  // lineChart renders series of samples over time
+  function lineChart(samples, series) {
+    var width = 960, height = 220, left = 80, right = 16, top = 12, bottom = 28;
+    var times = samples.map(function (s) { return new Date(s.time).getTime(); });
+    var first = times[0], last = times[times.length - 1];
+    var max = 0;
+    samples.forEach(function (s) {
+      series.forEach(function (line) { max = Math.max(max, number(s[line.field])); });
+    });
+    max = max > 0 ? max * 1.1 : 1;
+    function x(t) { return left + (last > first ? (t - first) / (last - first) : 0.5) * (width - left - right); }
+    function y(v) { return top + (1 - v / max) * (height - top - bottom); }
+
+    var svg = '<svg xmlns="http://www.w3.org/2000/svg" width="' + width + '" height="' + height + '" viewBox="0 0 ' + width + " " + height + '" role="img">';
+    for (var i = 0; i <= 4; i++) {
+      var value = max * i / 4;
+      svg += '<line x1="' + left + '" x2="' + (width - right) + '" y1="' + y(value).toFixed(1) + '" y2="' + y(value).toFixed(1) + '" stroke="#e4e7eb"/>';
+      svg += '<text x="' + (left - 8) + '" y="' + (y(value) + 4).toFixed(1) + '" text-anchor="end">' + escape(cost(value)) + "</text>";
+    }
+    [0, Math.floor((samples.length - 1) / 2), samples.length - 1].forEach(function (i, n, all) {
+      if (n > 0 && i === all[n - 1]) {
+        return;
+      }
+      var anchor = n === 0 ? "start" : n === 2 ? "end" : "middle";
+      svg += '<text x="' + x(times[i]).toFixed(1) + '" y="' + (height - 8) + '" text-anchor="' + anchor + '">' +
+        escape(new Date(times[i]).toLocaleDateString(undefined, { month: "short", day: "numeric", hour: "2-digit" })) + "</text>";
+    });
+    series.forEach(function (line) {
+      var points = samples.map(function (s, i) { return x(times[i]).toFixed(1) + "," + y(number(s[line.field])).toFixed(1); });
+      svg += '<polyline fill="none" stroke="' + line.color + '" stroke-width="2" points="' + points.join(" ") + '"/>';
+    });
+    svg += "</svg>";
+    var legend = '<div class="legend">' + series.map(function (line) {
+      return '<span><i style="background:' + line.color + '"></i>' + escape(line.name) + "</span>";
+    }).join("") + "</div>";
+    return legend + svg;
+  }
+
+  function renderCluster(cluster) {
+    var html = "<h1>" + escape(cluster.context) + "</h1>";
+    if (cluster.error) {
+      html += '<div class="alert">The cluster could not be collected: ' + escape(cluster.error) + "</div>";
+    }
+    var r = cluster.report;
+    if (!r) {
+      return html + (widgets(["trend"]).length ? trendSection(cluster) : "");
+    }
+    html += '<p class="subtitle">Costs are monthly, in ' + escape(state.currency || "USD") + "; usage is the " + escape(r.usage) + ". " +
+      escape(r.pods) + " pods in " + escape(r.workloads) + " workloads.</p>";
+
+    var breakdown = r.breakdown || [];
+    var namespaces = r.namespaces || [];
+    return html + arrange(widgets(["summary", "trend", "resources", "namespaces", "recommendations"]), {
+      summary: function () {
+        var count = 0;
+        (r.recommendations || []).forEach(function (item) { count += number(item.recommendations); });
+        return '<div class="figures">' +
+          figure("Monthly cost", cost(r.monthly_cost), "nodes, volumes and load balancers") +
+          figure("Requested by pods", cost(r.requested_cost), share(number(r.used_cost), number(r.requested_cost)) + " of it used") +
+          figure("Idle capacity", cost(r.idle_cost), "node capacity that no pod requests") +
+          figure("Potential savings", cost(r.savings), "in " + count + " recommendations") +
+          "</div>";
+      },
+      trend: function () {
+        return trendSection(cluster);
+      },
+      resources: function () {
+        var max = Math.max.apply(null, [0].concat(breakdown.map(function (item) { return number(item.monthly_cost); })));
+        return section("Cost by resource", "", table(
+          [{ name: "Resource" }, { name: "Count", numeric: true }, { name: "Monthly cost", numeric: true }, { name: "Share", numeric: true }],
+          breakdown.map(function (item) {
+            return [item.category, { text: item.resources, numeric: true }, meter(cost(item.monthly_cost), number(item.monthly_cost), max),
+              share(number(item.monthly_cost), number(r.monthly_cost))];
+          })));
+      },
+      namespaces: function () {
+        var max = Math.max.apply(null, [0].concat(namespaces.map(function (item) { return number(item.monthly_cost); })));
+        return section("Cost by namespace", "Requests, with the share of idle capacity and of the shared namespaces.", table(
+          [{ name: "Namespace" }, { name: "Pods", numeric: true }, { name: "Requests", numeric: true }, { name: "Idle", numeric: true }, { name: "Total", numeric: true }],
+          namespaces.map(function (item) {
+            return [item.name, item.pods, cost(item.direct_cost), cost(item.idle_cost), meter(cost(item.monthly_cost), number(item.monthly_cost), max)];
+          })));
+      },
+      recommendations: function () {
+        var actions = (r.recommendations || []).map(function (item) {
+          return item.recommendations + " to " + item.action + ", saving " + cost(item.savings);
+        }).join("; ");
+        var offenders = r.offenders || [];
+        var columns = [{ name: "Namespace" }, { name: "Workload" }, { name: "Kind" }, { name: "Pods", numeric: true }, { name: "Action" },
+          { name: "Requested", numeric: true }, { name: "Used", numeric: true }, { name: "Savings", numeric: true }, { name: "Severity" }];
+        if (!state.read_only) {
+          columns.push({ name: "" });
+        }
+        return section("Recommendations", offenders.length ? actions + "." : "No workload is idle or uses less than half its requests.", offenders.length ? table(
+          columns,
+          offenders.map(function (item) {
+            var row = [item.namespace, item.workload, item.kind, item.pods, item.action, cost(item.requested_cost), cost(item.used_cost),
+              cost(item.savings), { text: item.severity, class: item.severity }];
+            if (!state.read_only) {
+              row.push(scaleButton(cluster.context, item));
+            }
+            return row;
+          })) : "");
+      }
+    });
+  }
+
+  // widgets returns those of the widgets a page can show that the layout
+  // shows, in its order
+  function widgets(available) {
+    var layout = state.layout && state.layout.widgets && state.layout.widgets.length ? state.layout.widgets :
+      ["summary", "trend", "resources", "namespaces", "recommendations", "clusters"];
+    return layout.filter(function (name) { return available.indexOf(name) >= 0; });
+  }
+
+  // arrange renders widgets in order, resources and namespaces side by side
+  // when they follow each other
+  function arrange(names, render) {
+    var html = "";
+    for (var i = 0; i < names.length; i++) {
+      var next = names[i + 1];
+      if (names[i] === "resources" && next === "namespaces" || names[i] === "namespaces" && next === "resources") {
+        html += '<div class="columns">' + render[names[i]]() + render[next]() + "</div>";
+        i++;
+      } else {
+        html += render[names[i]]();
+      }
+    }
+    return html;
+  }
+
+  // pinned reports whether the layout lists a cluster first
+  function pinned(context) {
+    return ((state.layout && state.layout.pinned) || []).indexOf(context) >= 0;
+  }
+
+  // scaleButton renders the button scaling the workload of a recommendation
+  // to zero, if that is its action
+  function scaleButton(context, item) {
+    return {
+      html: item.action === "scale to zero" ? '<button type="button" data-cluster="' + escape(context) +
+        '" data-namespace="' + escape(item.namespace) + '" data-kind="' + escape(item.kind) + '" data-workload="' + escape(item.workload) +
+        '">Scale to zero</button>' : ""
+    };
+  }
+
+  function percent(value) {
+    return typeof value === "number" ? value.toFixed(1) + "%" : "-";
+  }
+
+  function link(context) {
+    return { html: '<a href="#' + encodeURIComponent(context) + '">' + (pinned(context) ? "★ " : "") + escape(context) + "</a>" };
+  }
+
+  function renderFleet(fleet) {
+    var comparison = fleet.comparison || {};
+    var totals = comparison.fleet || {};
+    var clusters = comparison.clusters || [];
+    var failed = clusters.filter(function (c) { return c.status !== "ok"; }).length;
+    var html = "<h1>All clusters</h1>" +
+      '<p class="subtitle">Costs are monthly, in ' + escape(state.currency || "USD") + "; " + escape(totals.workloads) + " workloads in " +
+      escape(totals.clusters) + " clusters" + (failed ? ", " + failed + " could not be collected" : "") + ".</p>";
+    if (comparison.attention && comparison.attention.length) {
+      html += '<div class="alert">' + escape(comparison.attention.join(", ")) + " need attention.</div>";
+    }
+    return html + arrange(widgets(["summary", "clusters", "recommendations"]), {
+      summary: function () {
+        return '<div class="figures">' +
+          figure("Monthly cost", cost(totals.monthly_cost), "of all clusters") +
+          figure("Efficiency", percent(totals.efficiency), "of the cost of requests used") +
+          figure("Waste", cost(totals.waste), percent(totals.waste_percent) + " of the cost") +
+          figure("Cost per workload", cost(totals.cost_per_workload), "on average") +
+          "</div>";
+      },
+      clusters: function () {
+        var maxCost = Math.max.apply(null, [0].concat(clusters.map(function (c) { return number(c.monthly_cost); })));
+        var maxWaste = Math.max.apply(null, [0].concat(clusters.map(function (c) { return number(c.waste); })));
+        // Pinned clusters first, the others in the order of the comparison
+        var ordered = clusters.filter(function (c) { return pinned(c.cluster); }).concat(clusters.filter(function (c) { return !pinned(c.cluster); }));
+        return section("Clusters", "Those that need attention first, then by waste: efficiency below 50%, or waste or cost per workload above the average.", table(
+          [{ name: "Cluster" }, { name: "Workloads", numeric: true }, { name: "Monthly cost", numeric: true }, { name: "Efficiency", numeric: true },
+            { name: "Waste", numeric: true }, { name: "Waste %", numeric: true }, { name: "Attention" }],
+          ordered.map(function (c) {
+            if (c.status !== "ok") {
+              return [link(c.cluster), "", "", "", "", "", { text: c.error, class: "critical" }];
+            }
+            return [link(c.cluster), c.workloads, meter(cost(c.monthly_cost), number(c.monthly_cost), maxCost), percent(c.efficiency),
+              meter(cost(c.waste), number(c.waste), maxWaste), percent(c.waste_percent), { text: c.attention, class: c.attention ? "warning" : "" }];
+          })));
+      },
+      recommendations: function () {
+        var waste = fleet.waste || [];
+        var columns = [{ name: "Cluster" }, { name: "Namespace" }, { name: "Workload" }, { name: "Kind" }, { name: "Action" },
+          { name: "Requested", numeric: true }, { name: "Savings", numeric: true }, { name: "Severity" }];
+        if (!state.read_only) {
+          columns.push({ name: "" });
+        }
+        return section("Waste across clusters", waste.length ? "The recommendations of all clusters by savings." : "No workload is idle or uses less than half its requests.",
+          waste.length ? table(columns, waste.map(function (item) {
+            var row = [link(item.cluster), item.namespace, item.workload, item.kind, item.action, cost(item.requested_cost), cost(item.savings),
+              { text: item.severity, class: item.severity }];
+            if (!state.read_only) {
+              row.push(scaleButton(item.cluster, item));
+            }
+            return row;
+          })) : "");
+      }
+    });
+  }
+
+  function trendSection(cluster) {
+    var samples = cluster.trend || [];
+    if (samples.length < 2) {
+      return section("Cost trend", "The trend builds up as the dashboard collects the cluster, one point per hour.", "");
+    }
+    return section("Cost trend", "Since " + when(samples[0].time) + ".", lineChart(samples, [
+      { field: "monthly_cost", name: "Monthly cost", color: colors.cost },
+      { field: "used_cost", name: "Used by pods", color: colors.used },
+      { field: "savings", name: "Potential savings", color: colors.savings }
+    ]));
+  }
+
+  // selected returns the cluster of the page, null for the fleet view
+  function selected() {
+    var name = decodeURIComponent(location.hash.slice(1));
+    var clusters = state.clusters || [];
+    for (var i = 0; i < clusters.length; i++) {
+      if (clusters[i].context === name) {
+        return clusters[i];
+      }
+    }
+    return state.fleet ? null : clusters[0];
+  }
+
+  function render() {
+    var clusters = state.clusters || [];
+    var cluster = selected();
+    picker.hidden = clusters.length < 2;
+    picker.innerHTML = (state.fleet ? '<option value=""' + (cluster ? "" : " selected") + ">All clusters</option>" : "") +
+      clusters.map(function (c) {
+        return '<option value="' + escape(c.context) + '"' + (c === cluster ? " selected" : "") + ">" + (pinned(c.context) ? "★ " : "") + escape(c.context) + "</option>";
+      }).join("");
+    if (cluster) {
+      main.innerHTML = renderCluster(cluster);
+    } else {
+      main.innerHTML = state.fleet ? renderFleet(state.fleet) : '<p class="empty">No clusters.</p>';
+    }
+    status.className = "status";
+    if (bundled) {
+      status.textContent = "Exported state of " + when(state.collected_at);
+    } else {
+      status.textContent = state.snapshot ?
+        "Snapshot of " + when(state.collected_at) + ", shared until " + when(state.snapshot.expires) :
+        "Collected " + when(state.collected_at);
+    }
+    sharing.hidden = bundled || !!state.snapshot;
+  }
+
+  function show(message, error) {
+    notice.className = error ? "notice error" : "notice";
+    notice.textContent = message;
+    notice.hidden = false;
+  }
+
+  // post sends a request of the page to the server and resolves with its
+  // answer
+  function post(path, body) {
+    return fetch(path, { method: "POST", headers: { "Content-Type": "application/json" }, body: JSON.stringify(body) })
+      .then(function (response) {
+        return response.json().then(function (answer) {
+          if (!response.ok) {
+            throw new Error(answer.error || response.statusText);
+          }
+          return answer;
+        });
+      });
+  }
+
+  function load() {
+    if (bundled) {
+      state = window.UPID_STATE;
+      render();
+      return;
+    }
+    fetch("data/state", { cache: "no-store" })
+      .then(function (response) {
+        // The session ended, the page signs in again
+        if (response.status === 401) {
+          location.reload();
+        }
+        return response.json().then(function (body) {
+          if (!response.ok) {
+            throw new Error(body.error || response.statusText);
+          }
+          return body;
+        });
+      })
+      .then(function (body) {
+        state = body;
+        render();
+      })
+      .catch(function (err) {
+        status.className = "status error";
+        status.textContent = state ? "Showing the state of " + when(state.collected_at) + ": " + err.message : err.message;
+      })
+      .then(function () {
+        if (state && state.snapshot) {
+          return;
+        }
+        // Until the first state is collected, check again soon
+        setTimeout(load, (state && state.refresh ? state.refresh : 2) * 1000);
+      });
+  }
+
+  picker.addEventListener("change", function () {
+    location.hash = encodeURIComponent(picker.value);
+  });
+  main.addEventListener("click", function (event) {
+    var button = event.target;
+    if (button.tagName !== "BUTTON" || !button.dataset.workload) {
+      return;
+    }
+    var action = { cluster: button.dataset.cluster, namespace: button.dataset.namespace, kind: button.dataset.kind, workload: button.dataset.workload };
+    if (!confirm("Scale " + action.kind + "/" + action.workload + " in namespace " + action.namespace + " of " + action.cluster + " to zero?")) {
+      return;
+    }
+    button.disabled = true;
+    post("data/actions/scale-to-zero", action)
+      .then(function (answer) {
+        button.textContent = "Scaled";
+        show(answer.message + ". The recommendations show it after the next collection.");
+      })
+      .catch(function (err) {
+        button.disabled = false;
+        show(err.message, true);
+      });
+  });
+  document.getElementById("share-button").addEventListener("click", function () {
+    var ttl = document.getElementById("share-ttl");
+    post("data/snapshots", { ttl: ttl.value })
+      .then(function (answer) {
+        var url = new URL(answer.path, location.href.replace(/#.*$/, "")).href;
+        show("Anyone with this link sees the dashboard as it is now until " + when(answer.expires) + ": " + url);
+      })
+      .catch(function (err) {
+        show(err.message, true);
+      });
+  });
+  window.addEventListener("hashchange", function () {
+    if (state) {
+      render();
+    }
+  });
+  load();
+})();
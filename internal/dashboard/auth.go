@@ -0,0 +1,316 @@
+package dashboard
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/oidc"
+)
+
+// Modes of authentication of the dashboard
+const (
+	AuthNone  = "none"
+	AuthBasic = "basic"
+	AuthToken = "token"
+	AuthOIDC  = "oidc"
+)
+
+const (
+	// sessionCookie holds the session of a user signed in with a token or
+	// with the identity provider
+	sessionCookie = "upid_session"
+	// loginCookie binds a sign-in with the identity provider to the browser
+	// that started it
+	loginCookie = "upid_login"
+	// callbackPath is where the identity provider redirects to
+	callbackPath = "/auth/callback"
+
+	sessionLifetime = 12 * time.Hour
+	loginLifetime   = 10 * time.Minute
+)
+
+// Auth authenticates the users of the dashboard: with basic authentication
+// against Username and Password, with the bearer Token, or by signing them
+// in at the identity provider of Provider. Sessions are kept in memory and
+// end when the dashboard stops.
+type Auth struct {
+	Mode     string
+	Username string
+	Password string
+	Token    string
+	Provider *oidc.Provider
+	// Allow reports whether a user signed in at the identity provider may
+	// see the dashboard, nil to allow all
+	Allow func(user string) bool
+	// VerifyToken returns the user of an ID token of the identity provider
+	// that a client of the API sends as its bearer token, nil to accept
+	// signed-in browsers only
+	VerifyToken func(ctx context.Context, token string) (string, error)
+
+	mu       sync.Mutex
+	sessions map[string]session
+	logins   map[string]login
+}
+
+// session is a signed-in user
+type session struct {
+	user    string
+	expires time.Time
+}
+
+// login is a sign-in at the identity provider in progress
+type login struct {
+	*oidc.WebLogin
+	target  string
+	expires time.Time
+}
+
+// Handler returns next, served only to authenticated users. A nil Auth or
+// one of mode none serves everyone.
+func (a *Auth) Handler(next http.Handler) http.Handler {
+	if a == nil || a.Mode == "" || a.Mode == AuthNone {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The token in the URL of a snapshot is its credential
+		if strings.HasPrefix(r.URL.Path, "/snapshots/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var user string
+		switch a.Mode {
+		case AuthBasic:
+			username, password, ok := r.BasicAuth()
+			if !ok || !equal(username, a.Username) || !equal(password, a.Password) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="UPID dashboard", charset="UTF-8"`)
+				deny(w, r, "sign in with the user and password of the dashboard")
+				return
+			}
+			user = username
+		case AuthToken:
+			user = "token"
+			if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && equal(token, a.Token) {
+				break
+			}
+			if _, ok := a.session(r); ok {
+				break
+			}
+			// The URL the dashboard prints carries the token once, the
+			// session cookie replaces it
+			if token := r.URL.Query().Get("token"); token != "" && equal(token, a.Token) && r.Method == http.MethodGet {
+				a.startSession(w, r, "token")
+				query := r.URL.Query()
+				query.Del("token")
+				target := *r.URL
+				target.RawQuery = query.Encode()
+				http.Redirect(w, r, target.RequestURI(), http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="UPID dashboard"`)
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				deny(w, r, "send the token of the dashboard as a bearer token")
+				return
+			}
+			deny(w, r, "open the URL with the token that 'upid dashboard start' printed")
+			return
+		case AuthOIDC:
+			if r.URL.Path == callbackPath {
+				a.callback(w, r)
+				return
+			}
+			var ok bool
+			if user, ok = a.session(r); ok {
+				break
+			}
+			if token, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); bearer && a.VerifyToken != nil && api(r) {
+				verified, err := a.VerifyToken(r.Context(), token)
+				if err == nil && (a.Allow == nil || a.Allow(verified)) {
+					user = verified
+					break
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer realm="UPID dashboard", error="invalid_token"`)
+				if err != nil {
+					deny(w, r, err.Error())
+				} else {
+					deny(w, r, verified+" is not allowed to see this dashboard")
+				}
+				return
+			}
+			// Pages redirect to the identity provider, the data the page
+			// loads and the API fail until the user signs in again
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				deny(w, r, "send an ID token of the identity provider as a bearer token")
+				return
+			}
+			if r.Method != http.MethodGet || api(r) {
+				deny(w, r, "sign in again")
+				return
+			}
+			a.redirect(w, r)
+			return
+		default:
+			deny(w, r, fmt.Sprintf("unknown authentication %q", a.Mode))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+// userKey is the context key of the authenticated user of a request
+type userKey struct{}
+
+// User returns the authenticated user of a request, "" if the dashboard
+// does not authenticate users
+func User(r *http.Request) string {
+	user, _ := r.Context().Value(userKey{}).(string)
+	return user
+}
+
+// session returns the user of the session of a request
+func (a *Auth) session(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[cookie.Value]
+	if !ok || time.Now().After(s.expires) {
+		delete(a.sessions, cookie.Value)
+		return "", false
+	}
+	return s.user, true
+}
+
+// startSession signs a user in, with a session cookie
+func (a *Auth) startSession(w http.ResponseWriter, r *http.Request, user string) {
+	id := randomID()
+	expires := time.Now().Add(sessionLifetime)
+	a.mu.Lock()
+	if a.sessions == nil {
+		a.sessions = map[string]session{}
+	}
+	for key, s := range a.sessions {
+		if time.Now().After(s.expires) {
+			delete(a.sessions, key)
+		}
+	}
+	a.sessions[id] = session{user: user, expires: expires}
+	a.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{
+		Name: sessionCookie, Value: id, Path: "/", Expires: expires,
+		HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// redirect sends the user to the identity provider to sign in, and back to
+// the page requested afterwards
+func (a *Auth) redirect(w http.ResponseWriter, r *http.Request) {
+	webLogin, authURL := a.Provider.StartWebLogin(externalURL(r, callbackPath))
+	expires := time.Now().Add(loginLifetime)
+	a.mu.Lock()
+	if a.logins == nil {
+		a.logins = map[string]login{}
+	}
+	for key, l := range a.logins {
+		if time.Now().After(l.expires) {
+			delete(a.logins, key)
+		}
+	}
+	a.logins[webLogin.State] = login{WebLogin: webLogin, target: r.URL.RequestURI(), expires: expires}
+	a.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{
+		Name: loginCookie, Value: webLogin.State, Path: callbackPath, Expires: expires,
+		HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// callback completes a sign-in at the identity provider
+func (a *Auth) callback(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	cookie, err := r.Cookie(loginCookie)
+	a.mu.Lock()
+	l, ok := a.logins[state]
+	delete(a.logins, state)
+	a.mu.Unlock()
+	if err != nil || cookie.Value != state || !ok || time.Now().After(l.expires) {
+		loginFailed(w, "the sign-in expired or was not started by this browser, reload the dashboard to sign in again")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: loginCookie, Path: callbackPath, MaxAge: -1})
+
+	signedIn, err := a.Provider.FinishWebLogin(r.Context(), l.WebLogin, r.URL.Query())
+	if err != nil {
+		loginFailed(w, err.Error())
+		return
+	}
+	user := signedIn.Subject()
+	if a.Allow != nil && !a.Allow(user) {
+		slog.Warn("dashboard sign-in of a user not allowed", "user", user)
+		loginFailed(w, user+" is not allowed to see this dashboard")
+		return
+	}
+	slog.Info("signed in to the dashboard", "user", user)
+	a.startSession(w, r, user)
+	http.Redirect(w, r, l.target, http.StatusSeeOther)
+}
+
+// externalURL returns the URL of path on the dashboard as the browser
+// reaches it, through a proxy terminating TLS if any
+func externalURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
+
+// api reports whether a request is for data, of the page or the REST API,
+// rather than for a page
+func api(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/data/") || strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// deny answers a request of an unauthenticated user
+func deny(w http.ResponseWriter, r *http.Request, reason string) {
+	if api(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized: " + reason})
+		return
+	}
+	http.Error(w, "Unauthorized: "+reason, http.StatusUnauthorized)
+}
+
+// loginFailed answers a callback of a failed sign-in
+func loginFailed(w http.ResponseWriter, reason string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprintf(w, "<html><body><h3>UPID dashboard sign-in failed</h3><p>%s</p></body></html>", html.EscapeString(reason))
+}
+
+// equal compares secrets in constant time
+func equal(given, expected string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// randomID returns a random URL-safe session identifier
+func randomID() string {
+	buf := make([]byte, 32)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// NewToken returns a random bearer token for a dashboard without a stored
+// one
+func NewToken() string {
+	return randomID()
+}
@@ -0,0 +1,55 @@
+package dashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// BundleFiles are the files of an offline bundle of the dashboard: the
+// frontend, the state it shows as JSON, and the script that hands it to the
+// page, which browsers do not let fetch files
+var BundleFiles = []string{"index.html", "dashboard.js", "dashboard.css", "data.json", "data.js"}
+
+// WriteBundle writes the dashboard showing state to dir, as static files
+// that open in a browser without a server. The state is frozen and
+// read-only, like that of a snapshot.
+func WriteBundle(dir string, state *State) error {
+	frozen := *state
+	frozen.Refresh = 0
+	frozen.ReadOnly = true
+	data, err := json.MarshalIndent(&frozen, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the dashboard state: %v", err)
+	}
+
+	files := map[string][]byte{
+		"data.json": append(data, '\n'),
+		// The JSON encoder escapes <, > and &, the state cannot close the
+		// script
+		"data.js": append(append([]byte("window.UPID_STATE = "), data...), ";\n"...),
+	}
+	for _, name := range []string{"index.html", "dashboard.js", "dashboard.css"} {
+		content, err := fs.ReadFile(Assets(), name)
+		if err != nil {
+			return err
+		}
+		files[name] = content
+	}
+	script := []byte(`<script src="dashboard.js"></script>`)
+	files["index.html"] = bytes.Replace(files["index.html"], script, append([]byte(`<script src="data.js"></script>`+"\n"), script...), 1)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	for _, name := range BundleFiles {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, files[name], 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+	}
+	return nil
+}
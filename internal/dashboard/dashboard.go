@@ -0,0 +1,201 @@
+// Package dashboard serves the UPID web dashboard: a page of embedded
+// assets that renders the state of the clusters, which the server collects
+// from the Kubernetes API with the native client every refresh interval.
+package dashboard
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/native"
+)
+
+//go:embed assets
+var assets embed.FS
+
+// Assets returns the files of the frontend: index.html, with its script and
+// stylesheet
+func Assets() fs.FS {
+	files, _ := fs.Sub(assets, "assets")
+	return files
+}
+
+// State is what the dashboard shows: the clusters as last collected
+type State struct {
+	CollectedAt time.Time `json:"collected_at"`
+	Currency    string    `json:"currency"`
+	// Refresh is how often the page reloads the state, in seconds
+	Refresh  int        `json:"refresh"`
+	Clusters []*Cluster `json:"clusters"`
+	// Fleet sums up the clusters when there are several
+	Fleet *Fleet `json:"fleet,omitempty"`
+	// Layout is how the page arranges the state
+	Layout Layout `json:"layout"`
+	// ReadOnly is true when recommendations cannot be applied from the page
+	ReadOnly bool `json:"read_only"`
+	// Snapshot is set in the frozen state a shared snapshot shows
+	Snapshot *Snapshot `json:"snapshot,omitempty"`
+}
+
+// Cluster is the state of a cluster: its summary report and cost trend, or
+// the error that kept it from being collected
+type Cluster struct {
+	Context string `json:"context"`
+	// Report is the summary report of the cluster, with its cost by
+	// resource and namespace and its recommendations
+	Report map[string]interface{} `json:"report,omitempty"`
+	Trend  []Sample               `json:"trend"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// Fleet is the view of all the clusters of the dashboard
+type Fleet struct {
+	// Comparison compares the clusters, with the totals of the fleet, as
+	// 'upid report generate compare' does
+	Comparison map[string]interface{} `json:"comparison"`
+	// Waste ranks the recommendations of all clusters by their savings
+	Waste []interface{} `json:"waste"`
+}
+
+// Layout arranges the page: its widgets in order, and the clusters listed
+// first, by kubeconfig context
+type Layout struct {
+	Widgets []string `json:"widgets"`
+	Pinned  []string `json:"pinned"`
+}
+
+// Collector collects the state of the clusters of the dashboard
+type Collector struct {
+	Clients  []*native.Client
+	Options  native.ReportOptions
+	Currency string
+	Layout   Layout
+	// TrendFile returns the file the cost trend of a context is kept in,
+	// nil to not keep trends
+	TrendFile func(kubeContext string) string
+
+	mu     sync.Mutex
+	trends map[string]*Trend
+}
+
+// Collect collects the clusters concurrently. A cluster that fails keeps
+// its trend, with the error in place of its report.
+func (c *Collector) Collect(ctx context.Context) *State {
+	state := &State{
+		CollectedAt: time.Now().UTC(),
+		Currency:    c.Currency,
+		Layout:      c.Layout,
+		Clusters:    make([]*Cluster, len(c.Clients)),
+	}
+	var wg sync.WaitGroup
+	for i, client := range c.Clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			state.Clusters[i] = c.collect(ctx, client, state.CollectedAt)
+		}()
+	}
+	wg.Wait()
+	if len(state.Clusters) > 1 {
+		state.Fleet = c.fleet(state.Clusters)
+	}
+	return state
+}
+
+// fleet compares the clusters and ranks their recommendations
+func (c *Collector) fleet(clusters []*Cluster) *Fleet {
+	names := make([]string, len(clusters))
+	reports := make([]map[string]interface{}, len(clusters))
+	errs := make([]error, len(clusters))
+	var waste []map[string]interface{}
+	for i, cluster := range clusters {
+		names[i], reports[i] = cluster.Context, cluster.Report
+		if cluster.Error != "" {
+			errs[i] = errors.New(cluster.Error)
+			continue
+		}
+		offenders, _ := cluster.Report["offenders"].([]interface{})
+		for _, offender := range offenders {
+			item := map[string]interface{}{"cluster": cluster.Context}
+			for key, value := range offender.(map[string]interface{}) {
+				item[key] = value
+			}
+			waste = append(waste, item)
+		}
+	}
+	sort.SliceStable(waste, func(i, j int) bool {
+		a, _ := waste[i]["savings"].(float64)
+		b, _ := waste[j]["savings"].(float64)
+		return a > b
+	})
+	top := c.Options.Top
+	if top <= 0 {
+		top = native.DefaultReportTop
+	}
+	fleet := &Fleet{Comparison: native.CompareReports(names, reports, errs), Waste: make([]interface{}, 0, top)}
+	for _, item := range waste[:min(top, len(waste))] {
+		fleet.Waste = append(fleet.Waste, item)
+	}
+	return fleet
+}
+
+// collect collects a cluster and adds its cost to its trend
+func (c *Collector) collect(ctx context.Context, client *native.Client, now time.Time) *Cluster {
+	cluster := &Cluster{Context: client.Context(), Trend: []Sample{}}
+	report, err := client.Report(ctx, c.Options)
+	trend := c.trend(client.Context())
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("failed to collect the dashboard", "context", client.Context(), "error", err)
+		}
+		cluster.Error = err.Error()
+	} else {
+		cluster.Report = report
+		if trend != nil {
+			number := func(key string) float64 {
+				v, _ := report[key].(float64)
+				return v
+			}
+			c.mu.Lock()
+			trend.Add(Sample{Time: now, Cost: number("monthly_cost"), Requested: number("requested_cost"), Used: number("used_cost"), Savings: number("savings")})
+			if err := trend.Save(); err != nil {
+				slog.Warn("failed to save cost trend", "context", client.Context(), "error", err)
+			}
+			c.mu.Unlock()
+		}
+	}
+	if trend != nil {
+		c.mu.Lock()
+		cluster.Trend = append(cluster.Trend, trend.Samples...)
+		c.mu.Unlock()
+	}
+	return cluster
+}
+
+// trend returns the cost trend of a context, nil if trends are not kept or
+// it cannot be read
+func (c *Collector) trend(kubeContext string) *Trend {
+	if c.TrendFile == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if trend, ok := c.trends[kubeContext]; ok {
+		return trend
+	}
+	trend, err := LoadTrend(c.TrendFile(kubeContext), kubeContext)
+	if err != nil {
+		slog.Warn("failed to read cost trend", "context", kubeContext, "error", err)
+	}
+	if c.trends == nil {
+		c.trends = map[string]*Trend{}
+	}
+	c.trends[kubeContext] = trend
+	return trend
+}
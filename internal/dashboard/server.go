@@ -0,0 +1,182 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/timeutil"
+)
+
+// Server serves the dashboard: its frontend at / and the state it renders
+// at /data/state. Snapshots of the state are shared at /snapshots/<token>/,
+// and the REST API serves it to other tools at /api/v1.
+type Server struct {
+	collector *Collector
+	interval  time.Duration
+	// Apply scales an idle workload to zero from the page, nil for a
+	// read-only dashboard
+	Apply func(ctx context.Context, action Action) (map[string]interface{}, error)
+
+	mu        sync.RWMutex
+	state     *State
+	snapshots snapshots
+}
+
+// Action is a recommendation to scale an idle workload to zero applied
+// from the page
+type Action struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Workload  string `json:"workload"`
+}
+
+// NewServer returns a server of the state the collector collects every
+// interval
+func NewServer(collector *Collector, interval time.Duration) *Server {
+	return &Server{collector: collector, interval: interval}
+}
+
+// Run collects the state every interval until ctx is done
+func (s *Server) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		state := s.collector.Collect(ctx)
+		state.Refresh = int(s.interval.Seconds())
+		state.ReadOnly = s.Apply == nil
+		s.mu.Lock()
+		s.state = state
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// State returns the state last collected, nil before the first collection
+func (s *Server) State() *State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
+
+// Handler returns the handler of the dashboard
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServerFS(Assets()))
+	mux.HandleFunc("GET /data/state", func(w http.ResponseWriter, r *http.Request) {
+		state := s.State()
+		if state == nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "the dashboard is collecting the clusters"})
+			return
+		}
+		writeJSON(w, http.StatusOK, state)
+	})
+	mux.HandleFunc("POST /data/actions/scale-to-zero", s.apply)
+	mux.HandleFunc("POST /data/snapshots", s.share)
+	mux.HandleFunc("GET /snapshots/{token}/", func(w http.ResponseWriter, r *http.Request) {
+		state := s.snapshots.get(r.PathValue("token"))
+		if state == nil {
+			http.Error(w, "This snapshot of the UPID dashboard expired or does not exist.", http.StatusNotFound)
+			return
+		}
+		if strings.TrimPrefix(r.URL.Path, "/snapshots/"+r.PathValue("token")) == "/data/state" {
+			writeJSON(w, http.StatusOK, state)
+			return
+		}
+		http.StripPrefix("/snapshots/"+r.PathValue("token"), http.FileServerFS(Assets())).ServeHTTP(w, r)
+	})
+	s.handleAPI(mux)
+	return mux
+}
+
+// apply applies an action of the page
+func (s *Server) apply(w http.ResponseWriter, r *http.Request) {
+	if s.Apply == nil {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "the dashboard is read-only"})
+		return
+	}
+	var action Action
+	if !decodeJSON(w, r, &action) {
+		return
+	}
+	if action.Cluster == "" || action.Namespace == "" || action.Kind == "" || action.Workload == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "the action needs a cluster, namespace, kind and workload"})
+		return
+	}
+	slog.Info("dashboard action", "action", "scale to zero", "user", User(r), "context", action.Cluster,
+		"namespace", action.Namespace, "workload", action.Kind+"/"+action.Workload)
+	result, err := s.Apply(r.Context(), action)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// share freezes the state as a snapshot shared for the ttl of the request
+func (s *Server) share(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		TTL string `json:"ttl"`
+	}
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+	ttl, err := timeutil.ParseDuration(request.TTL)
+	if err != nil || ttl < MinSnapshotTTL || ttl > MaxSnapshotTTL {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid ttl %q (expected a duration from 1m to 30d)", request.TTL)})
+		return
+	}
+	state := s.State()
+	if state == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "the dashboard is collecting the clusters"})
+		return
+	}
+	token, frozen := s.snapshots.add(state, ttl, User(r))
+	slog.Info("shared a dashboard snapshot", "user", User(r), "expires", frozen.Snapshot.Expires)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"path":    "snapshots/" + token + "/",
+		"expires": frozen.Snapshot.Expires,
+	})
+}
+
+// decodeJSON decodes the JSON body of a request of the page into v, and
+// answers the request if it fails. Requiring JSON, which forms of other
+// sites cannot send without the consent of the browser, keeps them from
+// making them.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "expected a JSON request"})
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "cross-origin requests are not allowed"})
+			return false
+		}
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+// writeJSON writes a value as the JSON body of a response
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
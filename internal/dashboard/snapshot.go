@@ -0,0 +1,65 @@
+package dashboard
+
+import (
+	"sync"
+	"time"
+)
+
+// Snapshots can be shared for at least MinSnapshotTTL and at most
+// MaxSnapshotTTL
+const (
+	MinSnapshotTTL = time.Minute
+	MaxSnapshotTTL = 30 * 24 * time.Hour
+)
+
+// Snapshot is a frozen state of the dashboard shared with a tokenized URL,
+// which shows it to anyone until it expires
+type Snapshot struct {
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	// CreatedBy is the user who shared it, if the dashboard authenticates
+	// users
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+// snapshots are the snapshots shared from a dashboard, by token. They are
+// kept in memory and expire when the dashboard stops.
+type snapshots struct {
+	mu     sync.Mutex
+	states map[string]*State
+}
+
+// add freezes state as a snapshot shared for ttl and returns its token
+func (s *snapshots) add(state *State, ttl time.Duration, user string) (string, *State) {
+	frozen := *state
+	frozen.Refresh = 0
+	frozen.ReadOnly = true
+	now := time.Now().UTC()
+	frozen.Snapshot = &Snapshot{Created: now, Expires: now.Add(ttl), CreatedBy: user}
+
+	token := randomID()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = map[string]*State{}
+	}
+	for key, state := range s.states {
+		if now.After(state.Snapshot.Expires) {
+			delete(s.states, key)
+		}
+	}
+	s.states[token] = &frozen
+	return token, &frozen
+}
+
+// get returns the state of a snapshot, nil if it does not exist or expired
+func (s *snapshots) get(token string) *State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[token]
+	if !ok || time.Now().After(state.Snapshot.Expires) {
+		delete(s.states, token)
+		return nil
+	}
+	return state
+}
@@ -0,0 +1,94 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// trendStep is the resolution of the cost trend: a sample replaces the last
+// one when both fall in the same step, so that the trend keeps one sample
+// per step whatever the refresh interval
+const trendStep = time.Hour
+
+// trendKeep is how long samples of the cost trend are kept
+const trendKeep = 90 * 24 * time.Hour
+
+// Sample is the cost of a cluster at a collection. Costs are monthly.
+type Sample struct {
+	Time      time.Time `json:"time"`
+	Cost      float64   `json:"monthly_cost"`
+	Requested float64   `json:"requested_cost"`
+	Used      float64   `json:"used_cost"`
+	Savings   float64   `json:"savings"`
+}
+
+// Trend is the cost of a cluster over time, kept in a file by context
+type Trend struct {
+	path    string
+	Context string   `json:"context"`
+	Samples []Sample `json:"samples"`
+}
+
+// LoadTrend reads the trend of a context from path; a missing file, or one
+// of another context, holds no samples
+func LoadTrend(path, kubeContext string) (*Trend, error) {
+	trend := &Trend{path: path, Context: kubeContext}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return trend, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cost trend: %v", err)
+	}
+	var stored Trend
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("invalid cost trend file %s: %v", path, err)
+	}
+	if stored.Context == kubeContext {
+		trend.Samples = stored.Samples
+	}
+	return trend, nil
+}
+
+// Add adds a sample, replacing the last one in the same step and dropping
+// those older than trendKeep
+func (t *Trend) Add(sample Sample) {
+	if n := len(t.Samples); n > 0 && sample.Time.Truncate(trendStep).Equal(t.Samples[n-1].Time.Truncate(trendStep)) {
+		t.Samples = t.Samples[:n-1]
+	}
+	t.Samples = append(t.Samples, sample)
+	for len(t.Samples) > 0 && sample.Time.Sub(t.Samples[0].Time) > trendKeep {
+		t.Samples = t.Samples[1:]
+	}
+}
+
+// Save replaces the trend file, readable only by the user
+func (t *Trend) Save() error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("failed to encode cost trend: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	// Write to a temporary file first so that a crash never leaves the
+	// trend half written
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), filepath.Base(t.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write cost trend: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cost trend: %v", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), t.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cost trend: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Package gitops proposes changes to workloads through the Git repositories
+// they are deployed from: it clones a repository, locates the manifests or
+// Helm values of workloads, edits their resources on a branch, pushes it and
+// opens a pull request on GitHub or a merge request on GitLab. It uses the
+// git command, so repositories are reached with the user's own SSH keys or
+// credential helpers.
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// The identity commits are made with when git has none configured
+const (
+	committerName  = "upid"
+	committerEmail = "upid@localhost"
+)
+
+// Repo is a local clone of a repository
+type Repo struct {
+	// Dir is the working tree
+	Dir string
+	// URL is the repository cloned
+	URL string
+}
+
+// Clone clones the repository at url into dir, checking out base, or the
+// default branch if empty
+func Clone(ctx context.Context, url, dir, base string) (*Repo, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, clierr.New(clierr.CategoryUsage, "GIT_NOT_FOUND", "git is not installed").
+			WithHint("Install git and make sure it is in your PATH")
+	}
+	args := []string{"clone", "--depth", "1"}
+	if base != "" {
+		args = append(args, "--branch", base)
+	}
+	if _, err := git(ctx, "", append(args, url, dir)...); err != nil {
+		return nil, clierr.Wrap(err, clierr.CategoryUnreachable, "GIT_CLONE_FAILED", "failed to clone "+url).
+			WithHint("Check that the repository exists and that git can reach it with your credentials")
+	}
+	return &Repo{Dir: dir, URL: url}, nil
+}
+
+// Branch returns the branch checked out
+func (r *Repo) Branch(ctx context.Context) (string, error) {
+	return git(ctx, r.Dir, "rev-parse", "--abbrev-ref", "HEAD")
+}
+
+// Checkout creates and checks out a new branch
+func (r *Repo) Checkout(ctx context.Context, branch string) error {
+	_, err := git(ctx, r.Dir, "checkout", "-b", branch)
+	return err
+}
+
+// Diff returns the changes to the working tree
+func (r *Repo) Diff(ctx context.Context) (string, error) {
+	return git(ctx, r.Dir, "diff")
+}
+
+// Commit commits all changes to the working tree
+func (r *Repo) Commit(ctx context.Context, message string) error {
+	if _, err := git(ctx, r.Dir, "add", "-A"); err != nil {
+		return err
+	}
+	var args []string
+	if email, _ := git(ctx, r.Dir, "config", "user.email"); email == "" {
+		args = append(args, "-c", "user.name="+committerName, "-c", "user.email="+committerEmail)
+	}
+	_, err := git(ctx, r.Dir, append(args, "commit", "-m", message)...)
+	return err
+}
+
+// Push pushes a branch to the repository cloned
+func (r *Repo) Push(ctx context.Context, branch string) error {
+	if _, err := git(ctx, r.Dir, "push", "origin", branch); err != nil {
+		return clierr.Wrap(err, clierr.CategoryAuth, "GIT_PUSH_FAILED", "failed to push "+branch+" to "+r.URL).
+			WithHint("Check that your git credentials can push to the repository")
+	}
+	return nil
+}
+
+// git runs a git command in dir and returns its trimmed output
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], message)
+		}
+		return "", fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
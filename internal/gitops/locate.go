@@ -0,0 +1,481 @@
+package gitops
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Workload is a workload whose container resources are to be changed
+type Workload struct {
+	Kind      string
+	Namespace string
+	Name      string
+	// Chart and Release are set for workloads installed with Helm
+	Chart   string
+	Release string
+	// Containers are the resources to set, by container
+	Containers []Container
+}
+
+// Container is the requests and limits to set on a container, as
+// Kubernetes quantities by resource name
+type Container struct {
+	Name     string
+	Requests map[string]string
+	Limits   map[string]string
+}
+
+// Edit is a file changed for a workload
+type Edit struct {
+	// File is relative to the root of the repository
+	File string
+	// Source is "manifest" or "helm-values"
+	Source string
+}
+
+// Sources of the settings of workloads
+const (
+	SourceManifest   = "manifest"
+	SourceHelmValues = "helm-values"
+)
+
+// yamlFile is a parsed YAML file of the repository
+type yamlFile struct {
+	path string
+	docs []*yaml.Node
+	// compact is true if sequences are not indented under their keys
+	compact bool
+	changed bool
+}
+
+// ApplyResources sets the resources of workloads in the manifests under dir,
+// or in the values of their Helm charts, and returns the files changed per
+// workload, by index. Manifests are matched by kind, name and namespace
+// (or none); every copy, e.g. in several overlays, is changed. Helm values
+// are only changed where no manifest matches, at the resources of the
+// component or container, or the top-level resources, that the chart
+// templates refer to. Comments and the order of keys are kept, the
+// indentation of changed files is normalized.
+func ApplyResources(root, dir string, workloads []Workload) ([][]Edit, error) {
+	files, charts, err := scan(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byPath := map[string]*yamlFile{}
+	for _, file := range files {
+		byPath[file.path] = file
+	}
+	edits := make([][]Edit, len(workloads))
+	for i, w := range workloads {
+		for _, file := range files {
+			for _, doc := range file.docs {
+				if matchesManifest(doc, w) && setPodResources(doc.Content[0], w) {
+					file.changed = true
+					edits[i] = appendEdit(edits[i], root, file.path, SourceManifest)
+				}
+			}
+		}
+		if len(edits[i]) > 0 || w.Chart == "" {
+			continue
+		}
+		for _, chart := range charts[w.Chart] {
+			path := filepath.Join(chart, "values.yaml")
+			values := byPath[path]
+			if values == nil || values.root() == nil {
+				values = &yamlFile{path: path, docs: []*yaml.Node{{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}}}
+				byPath[path] = values
+				files = append(files, values)
+			}
+			if setValuesResources(values, chart, w) {
+				values.changed = true
+				edits[i] = appendEdit(edits[i], root, path, SourceHelmValues)
+			}
+		}
+	}
+
+	for _, file := range files {
+		if file.changed {
+			if err := file.write(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return edits, nil
+}
+
+// appendEdit records a changed file once
+func appendEdit(edits []Edit, root, path, source string) []Edit {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	for _, e := range edits {
+		if e.File == rel {
+			return edits
+		}
+	}
+	return append(edits, Edit{File: rel, Source: source})
+}
+
+// scan parses the YAML files under dir, skipping chart templates and files
+// that are not valid YAML, and indexes the directories of charts by name
+func scan(dir string) ([]*yamlFile, map[string][]string, error) {
+	var files []*yamlFile
+	charts := map[string][]string{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" || (entry.Name() == "templates" && exists(filepath.Join(filepath.Dir(path), "Chart.yaml"))) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := filepath.Ext(path)
+		if ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+		file, err := parseFile(path)
+		if err != nil {
+			return nil
+		}
+		if entry.Name() == "Chart.yaml" {
+			if name := scalar(file.root(), "name"); name != "" {
+				charts[name] = append(charts[name], filepath.Dir(path))
+			}
+			return nil
+		}
+		files = append(files, file)
+		return nil
+	})
+	return files, charts, err
+}
+
+// parseFile parses the documents of a YAML file
+func parseFile(path string) (*yamlFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &yamlFile{path: path, compact: compactSequences(data)}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		doc := &yaml.Node{}
+		err := decoder.Decode(doc)
+		if errors.Is(err, io.EOF) {
+			return file, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(doc.Content) > 0 {
+			file.docs = append(file.docs, doc)
+		}
+	}
+}
+
+// compactSequences reports whether the first sequence of a YAML file is at
+// the indentation of its key
+func compactSequences(data []byte) bool {
+	lines := strings.Split(string(data), "\n")
+	for i := 1; i < len(lines); i++ {
+		item := strings.TrimLeft(lines[i], " ")
+		if !strings.HasPrefix(item, "- ") && item != "-" {
+			continue
+		}
+		key := lines[i-1]
+		if !strings.HasSuffix(strings.TrimSpace(key), ":") {
+			continue
+		}
+		return len(key)-len(strings.TrimLeft(key, " ")) == len(lines[i])-len(item)
+	}
+	return false
+}
+
+// root returns the top-level node of the first document
+func (f *yamlFile) root() *yaml.Node {
+	if len(f.docs) == 0 {
+		return nil
+	}
+	return f.docs[0].Content[0]
+}
+
+// write writes the documents back to the file
+func (f *yamlFile) write() error {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	for _, doc := range f.docs {
+		if err := encoder.Encode(doc); err != nil {
+			return err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	if f.compact {
+		data = unindentSequences(data)
+	}
+	perm := os.FileMode(0o644)
+	if info, err := os.Stat(f.path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return os.WriteFile(f.path, data, perm)
+}
+
+// unindentSequences moves the sequences of YAML written with an indentation
+// of 2 to the indentation of their keys, as kubectl writes them. Block
+// scalars are moved with their key and otherwise left as they are.
+func unindentSequences(data []byte) []byte {
+	lines := strings.Split(string(data), "\n")
+	// A sequence moves the lines below its key, indented deeper than the
+	// key, by shift, with those of the sequences it is in
+	type sequence struct{ key, shift int }
+	var sequences []sequence
+	// key is the column of the key ending the last line, -1 if it has a
+	// value, and comments the lines of comments after it, which move with
+	// its sequence. Lines deeper than scalar are the content of a block
+	// scalar.
+	key, scalar, scalarShift := -1, -1, 0
+	var comments []int
+	for i, line := range lines {
+		item := strings.TrimLeft(line, " ")
+		if item == "" {
+			continue
+		}
+		indent := len(line) - len(item)
+		if scalar >= 0 && indent > scalar {
+			lines[i] = line[scalarShift:]
+			continue
+		}
+		scalar = -1
+		for len(sequences) > 0 && indent <= sequences[len(sequences)-1].key {
+			sequences = sequences[:len(sequences)-1]
+		}
+		shift := 0
+		if len(sequences) > 0 {
+			shift = sequences[len(sequences)-1].shift
+		}
+		if strings.HasPrefix(item, "#") {
+			lines[i] = line[shift:]
+			if key >= 0 && indent == key+2 {
+				comments = append(comments, i)
+			}
+			continue
+		}
+
+		if (strings.HasPrefix(item, "- ") || item == "-") && indent == key+2 {
+			shift += 2
+			sequences = append(sequences, sequence{key: key, shift: shift})
+			for _, c := range comments {
+				lines[c] = lines[c][2:]
+			}
+		}
+		lines[i] = line[shift:]
+		comments = nil
+
+		// The column of the key on the line, after the dashes of the
+		// sequence items it starts
+		column := indent
+		for strings.HasPrefix(item, "- ") {
+			item = strings.TrimLeft(item[2:], " ")
+			column = len(line) - len(item)
+		}
+		key = -1
+		value := strings.TrimSpace(item)
+		indicator := value[strings.LastIndex(value, " ")+1:]
+		switch {
+		case strings.HasSuffix(value, ":"):
+			key = column
+		case strings.HasPrefix(indicator, "|") || strings.HasPrefix(indicator, ">"):
+			// The content is deeper than the key, or than the dash of a
+			// sequence item that is the scalar
+			scalar, scalarShift = column, shift
+			if indicator == value {
+				scalar = column - 2
+			}
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// matchesManifest reports whether a document is the manifest of a workload
+func matchesManifest(doc *yaml.Node, w Workload) bool {
+	root := doc.Content[0]
+	if scalar(root, "kind") != w.Kind || scalar(root, "metadata", "name") != w.Name {
+		return false
+	}
+	namespace := scalar(root, "metadata", "namespace")
+	return namespace == "" || namespace == w.Namespace
+}
+
+// podSpecPath is the path of the pod spec in the manifest of a workload
+func podSpecPath(kind string) []string {
+	if kind == "CronJob" {
+		return []string{"spec", "jobTemplate", "spec", "template", "spec"}
+	}
+	return []string{"spec", "template", "spec"}
+}
+
+// setPodResources sets the resources of the containers of a manifest, and
+// reports whether any container was found
+func setPodResources(root *yaml.Node, w Workload) bool {
+	containers := lookup(root, append(podSpecPath(w.Kind), "containers")...)
+	if containers == nil || containers.Kind != yaml.SequenceNode {
+		return false
+	}
+	found := false
+	for _, c := range w.Containers {
+		for _, item := range containers.Content {
+			if scalar(item, "name") == c.Name {
+				setResources(ensureMap(item, "resources"), c)
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+// setValuesResources sets the resources of a workload in the values of its
+// chart, and reports whether any container was found. Single containers are
+// looked up under the component, then the container name, then at the top
+// level; containers of pods with several under their names only.
+func setValuesResources(values *yamlFile, chartDir string, w Workload) bool {
+	templates := readTemplates(filepath.Join(chartDir, "templates"))
+
+	// Workloads are usually named <release>-<chart>-<component>
+	component := strings.TrimPrefix(strings.TrimPrefix(w.Name, w.Release+"-"), w.Chart+"-")
+	found := false
+	for _, c := range w.Containers {
+		candidates := [][]string{{camelCase(c.Name), "resources"}}
+		if len(w.Containers) == 1 {
+			candidates = [][]string{{camelCase(component), "resources"}, candidates[0], {"resources"}}
+		}
+		for _, candidate := range candidates {
+			if lookup(values.root(), candidate...) == nil &&
+				!strings.Contains(templates, ".Values."+strings.Join(candidate, ".")) {
+				continue
+			}
+			node := values.root()
+			for _, key := range candidate {
+				node = ensureMap(node, key)
+			}
+			setResources(node, c)
+			found = true
+			break
+		}
+	}
+	return found
+}
+
+// readTemplates returns the templates of a chart as one string
+func readTemplates(dir string) string {
+	var b strings.Builder
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			if data, err := os.ReadFile(path); err == nil {
+				b.Write(data)
+			}
+		}
+		return nil
+	})
+	return b.String()
+}
+
+// setResources sets requests and limits in a resources mapping, keeping
+// other resources
+func setResources(resources *yaml.Node, c Container) {
+	for _, field := range []string{"requests", "limits"} {
+		values := c.Requests
+		if field == "limits" {
+			values = c.Limits
+		}
+		if len(values) == 0 {
+			continue
+		}
+		node := ensureMap(resources, field)
+		for _, name := range []string{"cpu", "memory"} {
+			if value, ok := values[name]; ok {
+				setScalar(node, name, value)
+			}
+		}
+	}
+}
+
+// lookup returns the node at a path of mapping keys, nil if missing
+func lookup(node *yaml.Node, path ...string) *yaml.Node {
+	for _, key := range path {
+		if node == nil || node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+				break
+			}
+		}
+		node = next
+	}
+	return node
+}
+
+// scalar returns the value of the scalar at a path, "" if missing
+func scalar(node *yaml.Node, path ...string) string {
+	if node = lookup(node, path...); node == nil || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return node.Value
+}
+
+// ensureMap returns the mapping under key, adding it if missing or null
+func ensureMap(node *yaml.Node, key string) *yaml.Node {
+	if value := lookup(node, key); value != nil && value.Kind == yaml.MappingNode {
+		return value
+	} else if value != nil {
+		*value = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		return value
+	}
+	value := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	return value
+}
+
+// setScalar sets the string under key
+func setScalar(node *yaml.Node, key, value string) {
+	if existing := lookup(node, key); existing != nil {
+		*existing = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, LineComment: existing.LineComment}
+		return
+	}
+	node.Content = append(node.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+}
+
+// camelCase converts a dashed name such as "api-server" to the key style
+// of Helm values, "apiServer"
+func camelCase(name string) string {
+	parts := strings.Split(name, "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// exists reports whether a file exists
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,164 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// Providers hosting repositories
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+)
+
+// tokenEnv are the variables the API token of each provider is read from,
+// in order
+var tokenEnv = map[string][]string{
+	ProviderGitHub: {"GITHUB_TOKEN", "GH_TOKEN"},
+	ProviderGitLab: {"GITLAB_TOKEN"},
+}
+
+// Remote is a repository on GitHub or GitLab
+type Remote struct {
+	Provider string
+	// API is the base URL of the REST API of the host
+	API string
+	// Path is the owner and name of the repository, or the full path of
+	// the GitLab project
+	Path string
+}
+
+// PullRequest is a pull or merge request to open
+type PullRequest struct {
+	Title string
+	Body  string
+	// Head is the branch with the changes, Base the one to merge them into
+	Head string
+	Base string
+}
+
+// ParseRemote finds the provider, API and path of a repository from its
+// clone URL, such as git@github.com:org/repo.git or
+// https://gitlab.example.com/group/repo. provider and api override those
+// guessed from the host name.
+func ParseRemote(repoURL, provider, api string) (*Remote, error) {
+	var host, path string
+	if u, err := url.Parse(repoURL); err == nil && u.Host != "" {
+		host, path = u.Hostname(), u.Path
+	} else if at := strings.Index(repoURL, "@"); at >= 0 && strings.Contains(repoURL[at:], ":") {
+		// scp-like syntax: user@host:path
+		host, path, _ = strings.Cut(repoURL[at+1:], ":")
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if host == "" || path == "" {
+		return nil, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+			fmt.Sprintf("cannot tell where repository %q is hosted", repoURL)).
+			WithHint("Use the SSH or HTTPS clone URL of a GitHub or GitLab repository")
+	}
+
+	if provider == "" {
+		switch {
+		case strings.Contains(host, ProviderGitHub):
+			provider = ProviderGitHub
+		case strings.Contains(host, ProviderGitLab):
+			provider = ProviderGitLab
+		default:
+			return nil, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+				fmt.Sprintf("cannot tell whether %s is GitHub or GitLab", host)).
+				WithHint("Pass --provider github or --provider gitlab")
+		}
+	}
+	if api == "" {
+		switch {
+		case provider == ProviderGitHub && host == "github.com":
+			api = "https://api.github.com"
+		case provider == ProviderGitHub:
+			api = "https://" + host + "/api/v3"
+		default:
+			api = "https://" + host + "/api/v4"
+		}
+	}
+	return &Remote{Provider: provider, API: strings.TrimSuffix(api, "/"), Path: path}, nil
+}
+
+// Token returns the API token of the provider from the environment
+func (r *Remote) Token() (string, error) {
+	for _, name := range tokenEnv[r.Provider] {
+		if token := os.Getenv(name); token != "" {
+			return token, nil
+		}
+	}
+	names := tokenEnv[r.Provider]
+	return "", clierr.New(clierr.CategoryAuth, "GIT_TOKEN_MISSING",
+		fmt.Sprintf("no %s token to open the pull request with", r.Provider)).
+		WithHint(fmt.Sprintf("Set $%s to a token that can open pull requests on %s", names[0], r.Path))
+}
+
+// Open opens a pull request, a merge request on GitLab, and returns its URL
+func (r *Remote) Open(ctx context.Context, token string, pr PullRequest) (string, error) {
+	var endpoint string
+	var payload map[string]string
+	header := map[string]string{}
+	switch r.Provider {
+	case ProviderGitHub:
+		endpoint = r.API + "/repos/" + r.Path + "/pulls"
+		payload = map[string]string{"title": pr.Title, "body": pr.Body, "head": pr.Head, "base": pr.Base}
+		header["Authorization"] = "Bearer " + token
+		header["Accept"] = "application/vnd.github+json"
+	case ProviderGitLab:
+		endpoint = r.API + "/projects/" + url.PathEscape(r.Path) + "/merge_requests"
+		payload = map[string]string{"title": pr.Title, "description": pr.Body, "source_branch": pr.Head, "target_branch": pr.Base}
+		header["PRIVATE-TOKEN"] = token
+	default:
+		return "", fmt.Errorf("unsupported provider %s", r.Provider)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return "", clierr.Wrap(err, clierr.CategoryUnreachable, "GIT_PROVIDER_UNREACHABLE", "failed to reach "+r.API)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	var result struct {
+		HTMLURL string          `json:"html_url"`
+		WebURL  string          `json:"web_url"`
+		Message json.RawMessage `json:"message"`
+	}
+	_ = json.Unmarshal(data, &result)
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return "", clierr.New(clierr.CategoryAuth, "GIT_PROVIDER_AUTH_FAILED",
+			fmt.Sprintf("%s rejected the token (%s)", r.Provider, resp.Status)).
+			WithHint(fmt.Sprintf("Set $%s to a token that can open pull requests on %s", tokenEnv[r.Provider][0], r.Path))
+	case resp.StatusCode >= 300:
+		return "", clierr.New(clierr.CategoryGeneral, "PULL_REQUEST_FAILED",
+			fmt.Sprintf("failed to open the pull request on %s (%s): %s", r.Path, resp.Status, strings.Trim(string(result.Message), `"`)))
+	}
+	if result.HTMLURL != "" {
+		return result.HTMLURL, nil
+	}
+	return result.WebURL, nil
+}
@@ -0,0 +1,91 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// FieldManager owns the fields of the objects UPID applies
+const FieldManager = "upid"
+
+// appliedResources maps the kinds that can be applied to their resources,
+// and whether they are namespaced
+var appliedResources = map[string]struct {
+	resource   schema.GroupVersionResource
+	namespaced bool
+}{
+	"Namespace":                {schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, false},
+	"ServiceAccount":           {schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}, true},
+	"ConfigMap":                {schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, true},
+	"Secret":                   {schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, true},
+	"Deployment":               {schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, true},
+	"ClusterRole":              {schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}, false},
+	"ClusterRoleBinding":       {schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterrolebindings"}, false},
+	"CustomResourceDefinition": {schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}, false},
+}
+
+// Apply creates or updates objects with server-side apply, in order. Fields
+// set by others are left alone; conflicting fields are taken over.
+func (c *Client) Apply(ctx context.Context, objects []map[string]interface{}) error {
+	client, err := dynamic.NewForConfig(c.Config)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		obj := &unstructured.Unstructured{Object: object}
+		resource, err := appliedResource(client, obj)
+		if err != nil {
+			return err
+		}
+		_, err = resource.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{FieldManager: FieldManager, Force: true})
+		if err != nil {
+			return APIError(err, fmt.Sprintf("apply %s %s", obj.GetKind(), obj.GetName()))
+		}
+	}
+	return nil
+}
+
+// Delete deletes objects in order and returns those that existed.
+// Dependents are deleted in the background.
+func (c *Client) Delete(ctx context.Context, objects []map[string]interface{}) ([]map[string]interface{}, error) {
+	client, err := dynamic.NewForConfig(c.Config)
+	if err != nil {
+		return nil, err
+	}
+	background := metav1.DeletePropagationBackground
+	var deleted []map[string]interface{}
+	for _, object := range objects {
+		obj := &unstructured.Unstructured{Object: object}
+		resource, err := appliedResource(client, obj)
+		if err != nil {
+			return deleted, err
+		}
+		err = resource.Delete(ctx, obj.GetName(), metav1.DeleteOptions{PropagationPolicy: &background})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return deleted, APIError(err, fmt.Sprintf("delete %s %s", obj.GetKind(), obj.GetName()))
+		}
+		deleted = append(deleted, object)
+	}
+	return deleted, nil
+}
+
+// appliedResource returns the client of the resource of obj
+func appliedResource(client dynamic.Interface, obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	kind, ok := appliedResources[obj.GetKind()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported kind %s", obj.GetKind())
+	}
+	if kind.namespaced {
+		return client.Resource(kind.resource).Namespace(obj.GetNamespace()), nil
+	}
+	return client.Resource(kind.resource), nil
+}
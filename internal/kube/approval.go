@@ -0,0 +1,238 @@
+package kube
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ApprovalLabel marks the ConfigMaps holding changes that need approval,
+// with the status of the change as value
+const ApprovalLabel = "upid.io/approval"
+
+// approvalPrefix is the prefix of the names of those ConfigMaps, followed by
+// the ID of the change
+const approvalPrefix = "upid-approval-"
+
+// Statuses of a change that needs approval
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalApplied  = "applied"
+	// ApprovalExpired changes were not approved, or not applied after
+	// their approval, in time
+	ApprovalExpired = "expired"
+)
+
+// Approval is a change to a protected cluster, which a second user approves
+// or rejects before it is applied. It is kept in a ConfigMap in the cluster,
+// so every user of the cluster sees the same changes.
+type Approval struct {
+	ID string `json:"id"`
+	// Command is the upid command that applies the change
+	Command string `json:"command"`
+	// Namespace the change is confined to, empty for the whole cluster
+	Namespace   string `json:"namespace,omitempty"`
+	Status      string `json:"status"`
+	RequestedBy string `json:"requestedBy"`
+	RequestedAt string `json:"requestedAt"`
+	ReviewedBy  string `json:"reviewedBy,omitempty"`
+	ReviewedAt  string `json:"reviewedAt,omitempty"`
+	// Reason is given when the change is rejected
+	Reason    string `json:"reason,omitempty"`
+	AppliedAt string `json:"appliedAt,omitempty"`
+	// ExpiresAt is when the change can no longer be approved, once
+	// approved when it can no longer be applied
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	// Override is the --override of guardrails the change was requested
+	// with, which the approval covers
+	Override string `json:"override,omitempty"`
+	// Changes are the workloads the change scales, sorted by kind and name.
+	// Only these are scaled once approved, and only from the recorded
+	// replicas.
+	Changes []ApprovalChange `json:"changes,omitempty"`
+
+	// resourceVersion guards updates against concurrent reviews
+	resourceVersion string
+}
+
+// ApprovalChange is the scaling of one workload by a change
+type ApprovalChange struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	From int32  `json:"from"`
+	To   int32  `json:"to"`
+}
+
+// String describes the change, e.g. Deployment/web 3 -> 0
+func (c ApprovalChange) String() string {
+	return fmt.Sprintf("%s/%s %d -> %d", c.Kind, c.Name, c.From, c.To)
+}
+
+// SortChanges sorts changes by kind and name
+func SortChanges(changes []ApprovalChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		return changes[i].Name < changes[j].Name
+	})
+}
+
+// Matches returns true if the approval is for the same change as request:
+// the same command, namespace and override, and the same workloads scaled
+// from and to the same replicas
+func (a *Approval) Matches(request *Approval) bool {
+	if a.Command != request.Command || a.Namespace != request.Namespace || a.Override != request.Override || len(a.Changes) != len(request.Changes) {
+		return false
+	}
+	for i := range a.Changes {
+		if a.Changes[i] != request.Changes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Expired returns true if a pending or approved change expired at now
+func (a *Approval) Expired(now time.Time) bool {
+	if a.Status != ApprovalPending && a.Status != ApprovalApproved {
+		return false
+	}
+	expiry, err := time.Parse(time.RFC3339, a.ExpiresAt)
+	return err == nil && now.After(expiry)
+}
+
+// User returns the name the cluster authenticates the client as
+func (c *Client) User(ctx context.Context) (string, error) {
+	review, err := c.Clientset.AuthenticationV1().SelfSubjectReviews().Create(ctx, &authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", clierr.New(clierr.CategoryUsage, "IDENTITY_UNAVAILABLE", "the cluster cannot tell who you are").
+			WithHint("Approvals need the SelfSubjectReview API of Kubernetes 1.28 or later")
+	}
+	if err != nil {
+		return "", APIError(err, "look up the current user")
+	}
+	if review.Status.UserInfo.Username == "" {
+		return "", clierr.New(clierr.CategoryAuth, "IDENTITY_UNAVAILABLE", "the cluster authenticates you anonymously").
+			WithHint("Use a kubeconfig context with your own credentials")
+	}
+	return review.Status.UserInfo.Username, nil
+}
+
+// Approvals lists the changes kept in namespace, oldest first
+func (c *Client) Approvals(ctx context.Context, namespace string) ([]*Approval, error) {
+	list, err := c.Clientset.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: ApprovalLabel})
+	if err != nil {
+		return nil, APIError(err, "list changes awaiting approval")
+	}
+	approvals := make([]*Approval, 0, len(list.Items))
+	for i := range list.Items {
+		approval, err := decodeApproval(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, approval)
+	}
+	sort.SliceStable(approvals, func(i, j int) bool { return approvals[i].RequestedAt < approvals[j].RequestedAt })
+	return approvals, nil
+}
+
+// GetApproval returns the change with an ID
+func (c *Client) GetApproval(ctx context.Context, namespace, id string) (*Approval, error) {
+	configMap, err := c.Clientset.CoreV1().ConfigMaps(namespace).Get(ctx, approvalPrefix+id, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, clierr.New(clierr.CategoryUsage, "APPROVAL_NOT_FOUND", fmt.Sprintf("no change %s awaits approval in %s", id, namespace)).
+			WithHint("List the changes with 'upid optimize approvals list'")
+	}
+	if err != nil {
+		return nil, APIError(err, "read change "+id)
+	}
+	return decodeApproval(configMap)
+}
+
+// CreateApproval stores a new change in namespace, setting its ID
+func (c *Client) CreateApproval(ctx context.Context, namespace string, approval *Approval) error {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	approval.ID = hex.EncodeToString(id)
+	configMap, err := encodeApproval(namespace, approval)
+	if err != nil {
+		return err
+	}
+	created, err := c.Clientset.CoreV1().ConfigMaps(namespace).Create(ctx, configMap, metav1.CreateOptions{FieldManager: FieldManager})
+	if apierrors.IsNotFound(err) {
+		return clierr.New(clierr.CategoryUsage, "APPROVALS_NAMESPACE_MISSING",
+			fmt.Sprintf("namespace %s, which holds changes awaiting approval, does not exist", namespace)).
+			WithHint(fmt.Sprintf("Create it with 'kubectl create namespace %s', or set approvals.namespace", namespace))
+	}
+	if err != nil {
+		return APIError(err, "record the change for approval")
+	}
+	approval.resourceVersion = created.ResourceVersion
+	return nil
+}
+
+// UpdateApproval stores the new status of a change. It fails if the change
+// was updated since it was read, so two reviews never overwrite each other.
+func (c *Client) UpdateApproval(ctx context.Context, namespace string, approval *Approval) error {
+	configMap, err := encodeApproval(namespace, approval)
+	if err != nil {
+		return err
+	}
+	configMap.ResourceVersion = approval.resourceVersion
+	updated, err := c.Clientset.CoreV1().ConfigMaps(namespace).Update(ctx, configMap, metav1.UpdateOptions{FieldManager: FieldManager})
+	if apierrors.IsConflict(err) {
+		return clierr.New(clierr.CategoryGeneral, "APPROVAL_CONFLICT", fmt.Sprintf("change %s was updated by someone else", approval.ID)).
+			WithHint("Check its status with 'upid optimize approvals list' and try again")
+	}
+	if err != nil {
+		return APIError(err, "update change "+approval.ID)
+	}
+	approval.resourceVersion = updated.ResourceVersion
+	return nil
+}
+
+// encodeApproval builds the ConfigMap holding a change
+func encodeApproval(namespace string, approval *Approval) (*corev1.ConfigMap, error) {
+	data, err := json.Marshal(approval)
+	if err != nil {
+		return nil, err
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      approvalPrefix + approval.ID,
+			Namespace: namespace,
+			Labels: map[string]string{
+				ApprovalLabel:                  approval.Status,
+				"app.kubernetes.io/managed-by": "upid",
+			},
+		},
+		Data: map[string]string{"approval.json": string(data)},
+	}, nil
+}
+
+// decodeApproval reads the change held by a ConfigMap
+func decodeApproval(configMap *corev1.ConfigMap) (*Approval, error) {
+	approval := &Approval{}
+	if err := json.Unmarshal([]byte(configMap.Data["approval.json"]), approval); err != nil {
+		return nil, fmt.Errorf("invalid change %s/%s: %v", configMap.Namespace, configMap.Name, err)
+	}
+	approval.ID = strings.TrimPrefix(configMap.Name, approvalPrefix)
+	approval.resourceVersion = configMap.ResourceVersion
+	return approval, nil
+}
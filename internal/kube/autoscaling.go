@@ -0,0 +1,178 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// vpaResource is the VerticalPodAutoscaler custom resource of the
+// autoscaler project
+var vpaResource = schema.GroupVersionResource{Group: "autoscaling.k8s.io", Version: "v1", Resource: "verticalpodautoscalers"}
+
+// VPA is a VerticalPodAutoscaler with its current recommendation
+type VPA struct {
+	Namespace string      `json:"namespace"`
+	Name      string      `json:"name"`
+	Target    WorkloadRef `json:"target"`
+	// UpdateMode is Off, Initial, Recreate, InPlaceOrRecreate or Auto
+	UpdateMode string `json:"update_mode"`
+	// Recommendation is the target requests summed over containers, nil
+	// until the recommender has produced one
+	Recommendation *Resources `json:"recommendation,omitempty"`
+	// ControlledResources lists the resources the VPA sets, cpu and memory
+	// by default
+	ControlledResources []string `json:"controlled_resources"`
+}
+
+// HPAs lists the HorizontalPodAutoscalers in namespace, all if empty
+func (c *Client) HPAs(ctx context.Context, namespace string) ([]autoscalingv2.HorizontalPodAutoscaler, error) {
+	var hpas []autoscalingv2.HorizontalPodAutoscaler
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		hpas = append(hpas, list.Items...)
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, APIError(err, "list horizontal pod autoscalers")
+	}
+	return hpas, nil
+}
+
+// VPAs lists the VerticalPodAutoscalers in namespace, all if empty. It
+// returns false if the VPA custom resource is not installed.
+func (c *Client) VPAs(ctx context.Context, namespace string) ([]VPA, bool, error) {
+	client, err := dynamic.NewForConfig(c.Config)
+	if err != nil {
+		return nil, false, err
+	}
+	list, err := client.Resource(vpaResource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, APIError(err, "list vertical pod autoscalers")
+	}
+
+	vpas := make([]VPA, 0, len(list.Items))
+	for _, item := range list.Items {
+		data, err := item.MarshalJSON()
+		if err != nil {
+			return nil, false, err
+		}
+		var object vpaObject
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, false, err
+		}
+		vpas = append(vpas, object.vpa())
+	}
+	return vpas, true, nil
+}
+
+// vpaObject holds the fields of a VerticalPodAutoscaler that are analyzed
+type vpaObject struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		TargetRef struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"targetRef"`
+		UpdatePolicy *struct {
+			UpdateMode string `json:"updateMode"`
+		} `json:"updatePolicy"`
+		ResourcePolicy *struct {
+			ContainerPolicies []struct {
+				ControlledResources []string `json:"controlledResources"`
+			} `json:"containerPolicies"`
+		} `json:"resourcePolicy"`
+	} `json:"spec"`
+	Status struct {
+		Recommendation *struct {
+			ContainerRecommendations []struct {
+				Target map[string]resource.Quantity `json:"target"`
+			} `json:"containerRecommendations"`
+		} `json:"recommendation"`
+	} `json:"status"`
+}
+
+// vpa converts the analyzed fields
+func (o vpaObject) vpa() VPA {
+	vpa := VPA{
+		Namespace:           o.Metadata.Namespace,
+		Name:                o.Metadata.Name,
+		Target:              WorkloadRef{Kind: o.Spec.TargetRef.Kind, Name: o.Spec.TargetRef.Name},
+		UpdateMode:          "Auto",
+		ControlledResources: []string{"cpu", "memory"},
+	}
+	if o.Spec.UpdatePolicy != nil && o.Spec.UpdatePolicy.UpdateMode != "" {
+		vpa.UpdateMode = o.Spec.UpdatePolicy.UpdateMode
+	}
+	if policy := o.Spec.ResourcePolicy; policy != nil {
+		for _, container := range policy.ContainerPolicies {
+			if len(container.ControlledResources) > 0 {
+				vpa.ControlledResources = container.ControlledResources
+				break
+			}
+		}
+	}
+	if recommendation := o.Status.Recommendation; recommendation != nil && len(recommendation.ContainerRecommendations) > 0 {
+		var total Resources
+		for _, container := range recommendation.ContainerRecommendations {
+			total = total.Add(quantities(container.Target))
+		}
+		vpa.Recommendation = &total
+	}
+	return vpa
+}
+
+// GetWorkload reads a Deployment, StatefulSet, DaemonSet, Job or CronJob
+func (c *Client) GetWorkload(ctx context.Context, kind, namespace, name string) (Workload, error) {
+	apps := c.Clientset.AppsV1()
+	switch kind {
+	case "Deployment":
+		d, err := apps.Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return Workload{}, err
+		}
+		return newWorkload(kind, d.ObjectMeta, d.Spec.Replicas, d.Status.ReadyReplicas, d.Spec.Template.Spec), nil
+	case "StatefulSet":
+		s, err := apps.StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return Workload{}, err
+		}
+		return newWorkload(kind, s.ObjectMeta, s.Spec.Replicas, s.Status.ReadyReplicas, s.Spec.Template.Spec), nil
+	case "DaemonSet":
+		d, err := apps.DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return Workload{}, err
+		}
+		return newWorkload(kind, d.ObjectMeta, nil, d.Status.NumberReady, d.Spec.Template.Spec), nil
+	case "Job":
+		j, err := c.Clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return Workload{}, err
+		}
+		var ready int32
+		if j.Status.Ready != nil {
+			ready = *j.Status.Ready
+		}
+		return newWorkload(kind, j.ObjectMeta, j.Spec.Parallelism, ready, j.Spec.Template.Spec), nil
+	case "CronJob":
+		j, err := c.Clientset.BatchV1().CronJobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return Workload{}, err
+		}
+		return newWorkload(kind, j.ObjectMeta, nil, 0, j.Spec.JobTemplate.Spec.Template.Spec), nil
+	}
+	return Workload{}, fmt.Errorf("unsupported workload kind %s", kind)
+}
@@ -0,0 +1,68 @@
+// Package kube collects data about workloads and capacity directly from the
+// Kubernetes API with client-go: nodes, pods with their requests and limits,
+// the workloads that own them, and recent events. Commands use it instead of
+// the Python runtime to gather cluster data, so a single binary can analyze
+// and optimize clusters.
+package kube
+
+import (
+	"fmt"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Client talks to the cluster of one kubeconfig context
+type Client struct {
+	// Clientset is the typed client of the cluster
+	Clientset kubernetes.Interface
+	// Config is the REST configuration the clientset was created with
+	Config *rest.Config
+	// Context is the kubeconfig context of the cluster
+	Context string
+}
+
+// NewClient connects to the cluster of a kubeconfig context, the current
+// context if empty
+func NewClient(kubeContext string) (*Client, error) {
+	return NewClientFromKubeconfig("", kubeContext)
+}
+
+// NewClientFromKubeconfig connects to the cluster of a context of a
+// kubeconfig file. An empty path reads the files named by $KUBECONFIG, or
+// ~/.kube/config.
+func NewClientFromKubeconfig(path, kubeContext string) (*Client, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if path != "" {
+		rules = &clientcmd.ClientConfigLoadingRules{ExplicitPath: path}
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+
+	raw, err := loader.RawConfig()
+	if err != nil {
+		return nil, clierr.Wrap(err, clierr.CategoryUsage, "KUBECONFIG_INVALID", "failed to load kubeconfig")
+	}
+	if kubeContext == "" {
+		kubeContext = raw.CurrentContext
+	}
+
+	restConfig, err := loader.ClientConfig()
+	if err != nil {
+		return nil, clierr.Wrap(err, clierr.CategoryUsage, "KUBECONFIG_INVALID", "no usable Kubernetes context").
+			WithHint("Select a context with 'kubectl config use-context'")
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
+	}
+	return &Client{Clientset: clientset, Config: restConfig, Context: kubeContext}, nil
+}
+
+// APIError classifies a failed Kubernetes API call
+func APIError(err error, action string) error {
+	return clierr.Wrap(err, clierr.CategoryUnreachable, "CLUSTER_UNREACHABLE", "failed to "+action).
+		WithHint("Check cluster access with 'kubectl cluster-info'")
+}
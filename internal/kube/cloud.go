@@ -0,0 +1,99 @@
+package kube
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ebsCSIDriver is the CSI driver of EBS volumes
+const ebsCSIDriver = "ebs.csi.aws.com"
+
+// PersistentVolumes lists the persistent volumes of the cluster
+func (c *Client) PersistentVolumes(ctx context.Context) ([]corev1.PersistentVolume, error) {
+	var volumes []corev1.PersistentVolume
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.CoreV1().PersistentVolumes().List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		volumes = append(volumes, list.Items...)
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, APIError(err, "list persistent volumes")
+	}
+	return volumes, nil
+}
+
+// LoadBalancers lists the services of type LoadBalancer of the cluster
+func (c *Client) LoadBalancers(ctx context.Context) ([]corev1.Service, error) {
+	var services []corev1.Service
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.CoreV1().Services("").List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		for _, service := range list.Items {
+			if service.Spec.Type == corev1.ServiceTypeLoadBalancer {
+				services = append(services, service)
+			}
+		}
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, APIError(err, "list services")
+	}
+	return services, nil
+}
+
+// InstanceID returns the EC2 instance ID of a node from its provider ID,
+// aws:///us-east-1a/i-0123456789abcdef0, or "" if it is not an EC2 instance
+func InstanceID(node *corev1.Node) string {
+	if node == nil || !strings.HasPrefix(node.Spec.ProviderID, "aws://") {
+		return ""
+	}
+	id := node.Spec.ProviderID[strings.LastIndex(node.Spec.ProviderID, "/")+1:]
+	if !strings.HasPrefix(id, "i-") {
+		return ""
+	}
+	return id
+}
+
+// VolumeID returns the EBS volume ID of a persistent volume provisioned by
+// the EBS CSI driver or the in-tree plugin, or "" for other volumes
+func VolumeID(volume *corev1.PersistentVolume) string {
+	var id string
+	switch {
+	case volume.Spec.CSI != nil && volume.Spec.CSI.Driver == ebsCSIDriver:
+		id = volume.Spec.CSI.VolumeHandle
+	case volume.Spec.AWSElasticBlockStore != nil:
+		// aws://us-east-1a/vol-0123456789abcdef0 or vol-0123456789abcdef0
+		id = volume.Spec.AWSElasticBlockStore.VolumeID
+		id = id[strings.LastIndex(id, "/")+1:]
+	}
+	if !strings.HasPrefix(id, "vol-") {
+		return ""
+	}
+	return id
+}
+
+// LoadBalancerName returns the name of the AWS load balancer of a service
+// from its hostname, such as web-50dc6c495c0c9188.elb.us-east-1.amazonaws.com
+// or internal-web-1234567890.us-east-1.elb.amazonaws.com, or "" if it has
+// no AWS load balancer
+func LoadBalancerName(service *corev1.Service) string {
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if !strings.HasSuffix(ingress.Hostname, ".amazonaws.com") {
+			continue
+		}
+		label, _, _ := strings.Cut(ingress.Hostname, ".")
+		label = strings.TrimPrefix(label, "internal-")
+		if i := strings.LastIndex(label, "-"); i > 0 {
+			return label[:i]
+		}
+	}
+	return ""
+}
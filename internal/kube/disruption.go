@@ -0,0 +1,26 @@
+package kube
+
+import (
+	"context"
+
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DisruptionBudgets lists the PodDisruptionBudgets in namespace, all if
+// empty
+func (c *Client) DisruptionBudgets(ctx context.Context, namespace string) ([]policyv1.PodDisruptionBudget, error) {
+	var budgets []policyv1.PodDisruptionBudget
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.PolicyV1().PodDisruptionBudgets(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		budgets = append(budgets, list.Items...)
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, APIError(err, "list pod disruption budgets")
+	}
+	return budgets, nil
+}
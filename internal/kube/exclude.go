@@ -0,0 +1,134 @@
+package kube
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ExcludeAnnotation opts a workload, its pods or a pod out of optimizations
+// when set to "true"
+const ExcludeAnnotation = "upid.io/exclude"
+
+// Exclusions select the workloads optimizations leave alone, besides those
+// annotated with ExcludeAnnotation
+type Exclusions struct {
+	// Namespaces are glob patterns of namespaces
+	Namespaces []string
+	// Selector matches the labels of workloads, nil for none
+	Selector labels.Selector
+	// Workloads are glob patterns of workload names, as name or
+	// namespace/name
+	Workloads []string
+}
+
+// ParseExclusions parses comma-separated namespace and workload patterns
+// and a label selector, as set in the config file
+func ParseExclusions(namespaces, selector, workloads string) (*Exclusions, error) {
+	e := &Exclusions{Namespaces: splitPatterns(namespaces), Workloads: splitPatterns(workloads)}
+	for _, pattern := range append(append([]string(nil), e.Namespaces...), e.Workloads...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("invalid exclusion pattern %q", pattern))
+		}
+	}
+	if strings.TrimSpace(selector) != "" {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return nil, clierr.Wrap(err, clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("invalid exclusion selector %q", selector))
+		}
+		e.Selector = parsed
+	}
+	return e, nil
+}
+
+// Reason returns why a workload is excluded, "" if it is not. annotated
+// tells whether the workload or its pods carry ExcludeAnnotation. A nil
+// Exclusions only excludes annotated workloads.
+func (e *Exclusions) Reason(namespace, name string, workloadLabels map[string]string, annotated bool) string {
+	if annotated {
+		return "annotated " + ExcludeAnnotation
+	}
+	if e == nil {
+		return ""
+	}
+	for _, pattern := range e.Namespaces {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return "namespace matches " + pattern
+		}
+	}
+	if e.Selector != nil && !e.Selector.Empty() && e.Selector.Matches(labels.Set(workloadLabels)) {
+		return "labels match " + e.Selector.String()
+	}
+	for _, pattern := range e.Workloads {
+		target := name
+		if strings.Contains(pattern, "/") {
+			target = namespace + "/" + name
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return "name matches " + pattern
+		}
+	}
+	return ""
+}
+
+// Excluded returns the excluded workloads of the snapshot, keyed by
+// namespace/kind/name, with the reason they are. Workloads are excluded if
+// any of their pods is annotated; the labels of pods count for bare pods
+// and workloads not in the snapshot.
+func (s *Snapshot) Excluded(e *Exclusions) map[string]string {
+	excluded := map[string]string{}
+	for _, w := range s.Workloads {
+		if reason := e.Reason(w.Namespace, w.Name, w.Labels, w.Excluded); reason != "" {
+			excluded[w.Namespace+"/"+w.Kind+"/"+w.Name] = reason
+		}
+	}
+	for i := range s.Pods {
+		p := &s.Pods[i]
+		key := p.Namespace + "/" + p.Workload.Kind + "/" + p.Workload.Name
+		if _, ok := excluded[key]; ok {
+			continue
+		}
+		podLabels := p.Labels
+		if w, ok := s.Workload(p.Workload.Kind, p.Namespace, p.Workload.Name); ok {
+			podLabels = w.Labels
+		}
+		if reason := e.Reason(p.Namespace, p.Workload.Name, podLabels, p.Excluded); reason != "" {
+			excluded[key] = reason
+		}
+	}
+	return excluded
+}
+
+// WithoutExcluded returns a copy of the snapshot without the excluded
+// workloads and their pods
+func (s *Snapshot) WithoutExcluded(e *Exclusions) *Snapshot {
+	excluded := s.Excluded(e)
+	kept := *s
+	kept.Pods = make([]Pod, 0, len(s.Pods))
+	for _, p := range s.Pods {
+		if excluded[p.Namespace+"/"+p.Workload.Kind+"/"+p.Workload.Name] == "" {
+			kept.Pods = append(kept.Pods, p)
+		}
+	}
+	kept.Workloads = make([]Workload, 0, len(s.Workloads))
+	for _, w := range s.Workloads {
+		if excluded[w.Namespace+"/"+w.Kind+"/"+w.Name] == "" {
+			kept.Workloads = append(kept.Workloads, w)
+		}
+	}
+	return &kept
+}
+
+// splitPatterns splits comma-separated patterns, dropping empty ones
+func splitPatterns(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
@@ -0,0 +1,62 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// workloadResources maps the kinds of workloads with a mutable pod template
+// to their resources
+var workloadResources = map[string]schema.GroupVersionResource{
+	"Deployment":  {Group: "apps", Version: "v1", Resource: "deployments"},
+	"StatefulSet": {Group: "apps", Version: "v1", Resource: "statefulsets"},
+	"DaemonSet":   {Group: "apps", Version: "v1", Resource: "daemonsets"},
+	"CronJob":     {Group: "batch", Version: "v1", Resource: "cronjobs"},
+}
+
+// serverFields are the metadata fields set by the API server, which do not
+// belong in manifests
+var serverFields = []string{"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields", "selfLink"}
+
+// lastApplied is the annotation kubectl apply records the applied manifest in
+const lastApplied = "kubectl.kubernetes.io/last-applied-configuration"
+
+// Manifest reads a Deployment, StatefulSet, DaemonSet or CronJob as a
+// manifest: without its status and the metadata set by the API server
+func (c *Client) Manifest(ctx context.Context, kind, namespace, name string) (map[string]interface{}, error) {
+	resource, ok := workloadResources[kind]
+	if !ok {
+		return nil, fmt.Errorf("unsupported workload kind %s", kind)
+	}
+	client, err := dynamic.NewForConfig(c.Config)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := client.Resource(resource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, APIError(err, fmt.Sprintf("get %s %s", kind, name))
+	}
+
+	manifest := obj.Object
+	delete(manifest, "status")
+	for _, field := range serverFields {
+		unstructured.RemoveNestedField(manifest, "metadata", field)
+	}
+	unstructured.RemoveNestedField(manifest, "metadata", "annotations", lastApplied)
+	if annotations, _, _ := unstructured.NestedMap(manifest, "metadata", "annotations"); len(annotations) == 0 {
+		unstructured.RemoveNestedField(manifest, "metadata", "annotations")
+	}
+	return manifest, nil
+}
+
+// SupportsManifest reports whether the pod template of a kind of workload
+// can be patched
+func SupportsManifest(kind string) bool {
+	_, ok := workloadResources[kind]
+	return ok
+}
@@ -0,0 +1,127 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// metricsGroupVersion is the API served by metrics-server
+const metricsGroupVersion = "metrics.k8s.io/v1beta1"
+
+// metricsList is the subset of a metrics.k8s.io list used here
+type metricsList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Timestamp  time.Time                    `json:"timestamp"`
+		Usage      map[string]resource.Quantity `json:"usage"`
+		Containers []struct {
+			Name  string                       `json:"name"`
+			Usage map[string]resource.Quantity `json:"usage"`
+		} `json:"containers"`
+	} `json:"items"`
+}
+
+// MetricsStatus tells whether usage could be read from metrics-server
+type MetricsStatus struct {
+	Available bool `json:"available"`
+	// Timestamp is the time the usage was last scraped
+	Timestamp time.Time `json:"timestamp,omitzero"`
+	// Error explains why usage is missing
+	Error string `json:"error,omitempty"`
+}
+
+// PodMetrics returns the current usage of pods in namespace (all if empty)
+// keyed by "namespace/name"
+func (c *Client) PodMetrics(ctx context.Context, namespace string) (map[string]Resources, time.Time, error) {
+	path := "/apis/" + metricsGroupVersion + "/pods"
+	if namespace != "" {
+		path = "/apis/" + metricsGroupVersion + "/namespaces/" + namespace + "/pods"
+	}
+	list, err := c.metrics(ctx, path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var latest time.Time
+	result := make(map[string]Resources, len(list.Items))
+	for _, item := range list.Items {
+		var total Resources
+		for _, container := range item.Containers {
+			total = total.Add(quantities(container.Usage))
+		}
+		result[item.Metadata.Namespace+"/"+item.Metadata.Name] = total
+		if item.Timestamp.After(latest) {
+			latest = item.Timestamp
+		}
+	}
+	return result, latest, nil
+}
+
+// NodeMetrics returns the current usage of nodes keyed by name
+func (c *Client) NodeMetrics(ctx context.Context) (map[string]Resources, error) {
+	list, err := c.metrics(ctx, "/apis/"+metricsGroupVersion+"/nodes")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]Resources, len(list.Items))
+	for _, item := range list.Items {
+		result[item.Metadata.Name] = quantities(item.Usage)
+	}
+	return result, nil
+}
+
+// metrics fetches a list from the metrics.k8s.io API. Errors tell a
+// cluster without metrics-server from one whose metrics-server fails.
+func (c *Client) metrics(ctx context.Context, path string) (*metricsList, error) {
+	data, err := c.Clientset.Discovery().RESTClient().Get().AbsPath(path).DoRaw(ctx)
+	if err != nil {
+		return nil, c.metricsError(err)
+	}
+	var list metricsList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid metrics response: %v", err)
+	}
+	return &list, nil
+}
+
+// metricsError classifies a failed metrics request
+func (c *Client) metricsError(err error) error {
+	if apierrors.IsNotFound(err) {
+		return clierr.Wrap(err, clierr.CategoryUnreachable, "METRICS_UNAVAILABLE",
+			fmt.Sprintf("metrics-server is not installed in context %s (the %s API is not served)", c.Context, metricsGroupVersion)).
+			WithHint("Install metrics-server: kubectl apply -f https://github.com/kubernetes-sigs/metrics-server/releases/latest/download/components.yaml")
+	}
+	if apierrors.IsServiceUnavailable(err) || apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) {
+		return clierr.Wrap(err, clierr.CategoryUnreachable, "METRICS_UNAVAILABLE",
+			fmt.Sprintf("metrics-server in context %s is not answering", c.Context)).
+			WithHint("Check it with 'kubectl -n kube-system get deploy metrics-server' and 'kubectl top nodes'")
+	}
+	if apierrors.IsForbidden(err) {
+		return clierr.Wrap(err, clierr.CategoryAuth, "METRICS_FORBIDDEN", "not allowed to read metrics from metrics-server").
+			WithHint("Grant get and list on pods and nodes in the metrics.k8s.io API group")
+	}
+	return clierr.Wrap(err, clierr.CategoryUnreachable, "METRICS_UNAVAILABLE", "failed to read metrics from metrics-server").
+		WithHint("Check it with 'kubectl top nodes'")
+}
+
+// quantities converts the CPU and memory of a metrics usage map
+func quantities(usage map[string]resource.Quantity) Resources {
+	var r Resources
+	if cpu, ok := usage["cpu"]; ok {
+		r.CPU = cpu.AsApproximateFloat64()
+	}
+	if memory, ok := usage["memory"]; ok {
+		r.Memory = memory.AsApproximateFloat64()
+	}
+	return r
+}
@@ -0,0 +1,204 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+)
+
+// PolicyResource is the OptimizationPolicy custom resource, which declares
+// how UPID optimizes namespaces continuously
+var PolicyResource = schema.GroupVersionResource{Group: "upid.io", Version: "v1alpha1", Resource: "optimizationpolicies"}
+
+// OptimizationPolicy is a cluster-wide optimization policy
+type OptimizationPolicy struct {
+	Name       string       `json:"name"`
+	Generation int64        `json:"generation"`
+	Spec       PolicySpec   `json:"spec"`
+	Status     PolicyStatus `json:"status"`
+}
+
+// PolicySpec declares which workloads a policy scales to zero, and when
+type PolicySpec struct {
+	// Namespaces the policy applies to
+	Namespaces []string   `json:"namespaces"`
+	Idle       PolicyIdle `json:"idle"`
+	// Schedule lists the windows in which idle workloads are scaled to
+	// zero; outside of them they are restored. Empty means always.
+	Schedule []PolicyWindow `json:"schedule,omitempty"`
+	// Timezone of the schedule, UTC if empty
+	Timezone string `json:"timezone,omitempty"`
+	// MaxDisruption caps the workloads held at zero replicas at once, as a
+	// count or a percentage of the Deployments and StatefulSets in the
+	// namespaces. Unlimited if nil.
+	MaxDisruption *intstr.IntOrString `json:"maxDisruption,omitempty"`
+	// Suspend stops enforcing the policy, leaving workloads as they are
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// PolicyIdle sets when a workload counts as idle
+type PolicyIdle struct {
+	// MinConfidence is the confidence from 0 to 1 every pod of a workload
+	// must be idle with
+	MinConfidence float64 `json:"minConfidence,omitempty"`
+	// Window is the usage history considered with a datasource, e.g. 24h
+	Window string `json:"window,omitempty"`
+}
+
+// PolicyWindow is a daily time window, which ends the next day if End is
+// not after Start
+type PolicyWindow struct {
+	// Days the window starts on, e.g. Mon or Saturday; every day if empty
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// ParseWindows parses comma-separated windows written as
+// "[days ]HH:MM-HH:MM", where days are a day or a range of days such as
+// Mon-Fri, e.g. "Mon-Fri 09:00-18:00, Sat 10:00-14:00"
+func ParseWindows(spec string) ([]PolicyWindow, error) {
+	var windows []PolicyWindow
+	for _, part := range strings.Split(spec, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("invalid window %q (expected e.g. Mon-Fri 09:00-18:00)", strings.TrimSpace(part))
+		}
+		var window PolicyWindow
+		if len(fields) == 2 {
+			days, err := parseDays(fields[0])
+			if err != nil {
+				return nil, err
+			}
+			window.Days = days
+		}
+		times := strings.Split(fields[len(fields)-1], "-")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("invalid window %q (expected e.g. Mon-Fri 09:00-18:00)", strings.TrimSpace(part))
+		}
+		for _, value := range times {
+			if _, err := time.Parse("15:04", value); err != nil {
+				return nil, fmt.Errorf("invalid time of day %q (expected HH:MM)", value)
+			}
+		}
+		window.Start, window.End = times[0], times[1]
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// parseDays expands a day or a range of days, which may wrap around the
+// week, e.g. Fri-Mon
+func parseDays(value string) ([]string, error) {
+	bounds := strings.Split(value, "-")
+	if len(bounds) > 2 {
+		return nil, fmt.Errorf("invalid days %q (expected e.g. Mon or Mon-Fri)", value)
+	}
+	var indexes []time.Weekday
+	for _, bound := range bounds {
+		day, ok := weekday(bound)
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", bound)
+		}
+		indexes = append(indexes, day)
+	}
+	if len(indexes) == 1 {
+		return []string{indexes[0].String()[:3]}, nil
+	}
+	var days []string
+	for day := indexes[0]; ; day = (day + 1) % 7 {
+		days = append(days, day.String()[:3])
+		if day == indexes[1] {
+			return days, nil
+		}
+	}
+}
+
+// weekday parses the name of a day or its three letter abbreviation
+func weekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(name)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if full := strings.ToLower(d.String()); name == full || name == full[:3] {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// PolicyStatus is the outcome of the last enforcement of a policy
+type PolicyStatus struct {
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	Active             bool   `json:"active"`
+	LastEnforced       string `json:"lastEnforced,omitempty"`
+	// ScaledWorkloads counts the workloads the policy holds at zero
+	ScaledWorkloads int    `json:"scaledWorkloads"`
+	Message         string `json:"message,omitempty"`
+}
+
+// Policies lists the optimization policies. It returns false if the
+// OptimizationPolicy custom resource is not installed.
+func (c *Client) Policies(ctx context.Context) ([]OptimizationPolicy, bool, error) {
+	client, err := dynamic.NewForConfig(c.Config)
+	if err != nil {
+		return nil, false, err
+	}
+	list, err := client.Resource(PolicyResource).List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, APIError(err, "list optimization policies")
+	}
+
+	policies := make([]OptimizationPolicy, 0, len(list.Items))
+	for _, item := range list.Items {
+		data, err := item.MarshalJSON()
+		if err != nil {
+			return nil, false, err
+		}
+		var object struct {
+			Metadata metav1.ObjectMeta `json:"metadata"`
+			Spec     PolicySpec        `json:"spec"`
+			Status   PolicyStatus      `json:"status"`
+		}
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, false, fmt.Errorf("invalid optimization policy %s: %v", item.GetName(), err)
+		}
+		policies = append(policies, OptimizationPolicy{
+			Name:       object.Metadata.Name,
+			Generation: object.Metadata.Generation,
+			Spec:       object.Spec,
+			Status:     object.Status,
+		})
+	}
+	return policies, true, nil
+}
+
+// SetPolicyStatus replaces the status of a policy
+func (c *Client) SetPolicyStatus(ctx context.Context, name string, status PolicyStatus) error {
+	client, err := dynamic.NewForConfig(c.Config)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{"status": status})
+	if err != nil {
+		return err
+	}
+	_, err = client.Resource(PolicyResource).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager}, "status")
+	if err != nil {
+		return APIError(err, "update status of optimization policy "+name)
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package kube
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// References are the ConfigMaps, Secrets, persistent volume claims and
+// Services the objects of a cluster refer to
+type References struct {
+	// objects are keyed by "Kind/namespace/name"
+	objects map[string]bool
+	// labels are those of the pods and pod templates by namespace, which
+	// Services select
+	labels map[string][]labels.Set
+}
+
+// Has returns whether anything refers to an object
+func (r *References) Has(kind, namespace, name string) bool {
+	return r.objects[kind+"/"+namespace+"/"+name]
+}
+
+// Selects returns whether a Service selector matches a pod, or the pod
+// template of a workload, in its namespace
+func (r *References) Selects(namespace string, selector map[string]string) bool {
+	if len(selector) == 0 {
+		return false
+	}
+	s := labels.SelectorFromSet(selector)
+	for _, set := range r.labels[namespace] {
+		if s.Matches(set) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *References) add(kind, namespace, name string) {
+	if name != "" {
+		r.objects[kind+"/"+namespace+"/"+name] = true
+	}
+}
+
+// References lists what the pods, the pod templates of Deployments,
+// ReplicaSets, StatefulSets, DaemonSets, Jobs and CronJobs, the service
+// accounts and the Ingresses in namespace (all if empty) refer to, and the
+// Services of admission webhooks. Pod templates count even when scaled to
+// zero, and those of the ReplicaSets a Deployment keeps for rollbacks. The
+// service accounts themselves are recorded, as their tokens refer to them.
+func (c *Client) References(ctx context.Context, namespace string) (*References, error) {
+	r := &References{objects: map[string]bool{}, labels: map[string][]labels.Set{}}
+	template := func(ns string, t corev1.PodTemplateSpec) {
+		r.podSpec(ns, &t.Spec)
+		r.labels[ns] = append(r.labels[ns], t.Labels)
+	}
+
+	lists := []struct {
+		action string
+		list   func(opts metav1.ListOptions) (string, error)
+	}{
+		{"list pods", func(opts metav1.ListOptions) (string, error) {
+			list, err := c.Clientset.CoreV1().Pods(namespace).List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			for i := range list.Items {
+				pod := &list.Items[i]
+				r.podSpec(pod.Namespace, &pod.Spec)
+				r.labels[pod.Namespace] = append(r.labels[pod.Namespace], pod.Labels)
+			}
+			return list.Continue, nil
+		}},
+		{"list deployments", func(opts metav1.ListOptions) (string, error) {
+			list, err := c.Clientset.AppsV1().Deployments(namespace).List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			for _, d := range list.Items {
+				template(d.Namespace, d.Spec.Template)
+			}
+			return list.Continue, nil
+		}},
+		{"list replicasets", func(opts metav1.ListOptions) (string, error) {
+			list, err := c.Clientset.AppsV1().ReplicaSets(namespace).List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			for _, s := range list.Items {
+				template(s.Namespace, s.Spec.Template)
+			}
+			return list.Continue, nil
+		}},
+		{"list statefulsets", func(opts metav1.ListOptions) (string, error) {
+			list, err := c.Clientset.AppsV1().StatefulSets(namespace).List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			for _, s := range list.Items {
+				template(s.Namespace, s.Spec.Template)
+				r.add("Service", s.Namespace, s.Spec.ServiceName)
+			}
+			return list.Continue, nil
+		}},
+		{"list daemonsets", func(opts metav1.ListOptions) (string, error) {
+			list, err := c.Clientset.AppsV1().DaemonSets(namespace).List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			for _, d := range list.Items {
+				template(d.Namespace, d.Spec.Template)
+			}
+			return list.Continue, nil
+		}},
+		{"list jobs", func(opts metav1.ListOptions) (string, error) {
+			list, err := c.Clientset.BatchV1().Jobs(namespace).List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			for _, j := range list.Items {
+				template(j.Namespace, j.Spec.Template)
+			}
+			return list.Continue, nil
+		}},
+		{"list cronjobs", func(opts metav1.ListOptions) (string, error) {
+			list, err := c.Clientset.BatchV1().CronJobs(namespace).List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			for _, j := range list.Items {
+				template(j.Namespace, j.Spec.JobTemplate.Spec.Template)
+			}
+			return list.Continue, nil
+		}},
+		{"list service accounts", func(opts metav1.ListOptions) (string, error) {
+			list, err := c.Clientset.CoreV1().ServiceAccounts(namespace).List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			for _, account := range list.Items {
+				r.add("ServiceAccount", account.Namespace, account.Name)
+				for _, secret := range account.Secrets {
+					r.add("Secret", account.Namespace, secret.Name)
+				}
+				for _, secret := range account.ImagePullSecrets {
+					r.add("Secret", account.Namespace, secret.Name)
+				}
+			}
+			return list.Continue, nil
+		}},
+		{"list ingresses", func(opts metav1.ListOptions) (string, error) {
+			list, err := c.Clientset.NetworkingV1().Ingresses(namespace).List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			for _, ingress := range list.Items {
+				for _, tls := range ingress.Spec.TLS {
+					r.add("Secret", ingress.Namespace, tls.SecretName)
+				}
+				if b := ingress.Spec.DefaultBackend; b != nil && b.Service != nil {
+					r.add("Service", ingress.Namespace, b.Service.Name)
+				}
+				for _, rule := range ingress.Spec.Rules {
+					if rule.HTTP == nil {
+						continue
+					}
+					for _, p := range rule.HTTP.Paths {
+						if p.Backend.Service != nil {
+							r.add("Service", ingress.Namespace, p.Backend.Service.Name)
+						}
+					}
+				}
+			}
+			return list.Continue, nil
+		}},
+		{"list validating webhook configurations", func(opts metav1.ListOptions) (string, error) {
+			list, err := c.Clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			for _, config := range list.Items {
+				for _, webhook := range config.Webhooks {
+					if s := webhook.ClientConfig.Service; s != nil {
+						r.add("Service", s.Namespace, s.Name)
+					}
+				}
+			}
+			return list.Continue, nil
+		}},
+		{"list mutating webhook configurations", func(opts metav1.ListOptions) (string, error) {
+			list, err := c.Clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().List(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			for _, config := range list.Items {
+				for _, webhook := range config.Webhooks {
+					if s := webhook.ClientConfig.Service; s != nil {
+						r.add("Service", s.Namespace, s.Name)
+					}
+				}
+			}
+			return list.Continue, nil
+		}},
+	}
+	for _, l := range lists {
+		if err := eachPage(ctx, l.list); err != nil {
+			return nil, APIError(err, l.action)
+		}
+	}
+	return r, nil
+}
+
+// podSpec records the ConfigMaps, Secrets and claims a pod spec refers to
+func (r *References) podSpec(namespace string, spec *corev1.PodSpec) {
+	for _, secret := range spec.ImagePullSecrets {
+		r.add("Secret", namespace, secret.Name)
+	}
+	for _, volume := range spec.Volumes {
+		switch {
+		case volume.ConfigMap != nil:
+			r.add("ConfigMap", namespace, volume.ConfigMap.Name)
+		case volume.Secret != nil:
+			r.add("Secret", namespace, volume.Secret.SecretName)
+		case volume.PersistentVolumeClaim != nil:
+			r.add("PersistentVolumeClaim", namespace, volume.PersistentVolumeClaim.ClaimName)
+		case volume.CSI != nil && volume.CSI.NodePublishSecretRef != nil:
+			r.add("Secret", namespace, volume.CSI.NodePublishSecretRef.Name)
+		case volume.Projected != nil:
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					r.add("ConfigMap", namespace, source.ConfigMap.Name)
+				}
+				if source.Secret != nil {
+					r.add("Secret", namespace, source.Secret.Name)
+				}
+			}
+		}
+	}
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, c := range spec.EphemeralContainers {
+		containers = append(containers, corev1.Container(c.EphemeralContainerCommon))
+	}
+	for _, c := range containers {
+		for _, from := range c.EnvFrom {
+			if from.ConfigMapRef != nil {
+				r.add("ConfigMap", namespace, from.ConfigMapRef.Name)
+			}
+			if from.SecretRef != nil {
+				r.add("Secret", namespace, from.SecretRef.Name)
+			}
+		}
+		for _, env := range c.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+				r.add("ConfigMap", namespace, ref.Name)
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+				r.add("Secret", namespace, ref.Name)
+			}
+		}
+	}
+}
+
+// ConfigMaps lists the ConfigMaps in namespace, all namespaces if empty
+func (c *Client) ConfigMaps(ctx context.Context, namespace string) ([]corev1.ConfigMap, error) {
+	var configMaps []corev1.ConfigMap
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.CoreV1().ConfigMaps(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		configMaps = append(configMaps, list.Items...)
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, APIError(err, "list configmaps")
+	}
+	return configMaps, nil
+}
+
+// Secrets lists the Secrets in namespace, all namespaces if empty
+func (c *Client) Secrets(ctx context.Context, namespace string) ([]corev1.Secret, error) {
+	var secrets []corev1.Secret
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.CoreV1().Secrets(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		secrets = append(secrets, list.Items...)
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, APIError(err, "list secrets")
+	}
+	return secrets, nil
+}
+
+// Services lists the Services in namespace, all namespaces if empty
+func (c *Client) Services(ctx context.Context, namespace string) ([]corev1.Service, error) {
+	var services []corev1.Service
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.CoreV1().Services(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		services = append(services, list.Items...)
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, APIError(err, "list services")
+	}
+	return services, nil
+}
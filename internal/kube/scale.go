@@ -0,0 +1,102 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Replicas returns the desired replicas of a Deployment or StatefulSet,
+// read from its scale subresource
+func (c *Client) Replicas(ctx context.Context, kind, namespace, name string) (int32, error) {
+	scale, err := c.getScale(ctx, kind, namespace, name)
+	if err != nil {
+		return 0, err
+	}
+	return scale.Spec.Replicas, nil
+}
+
+// Scale sets the desired replicas of a Deployment or StatefulSet through its
+// scale subresource. The update fails if the workload was scaled since
+// expected was read, so a concurrent change is never overwritten.
+func (c *Client) Scale(ctx context.Context, kind, namespace, name string, expected, replicas int32) error {
+	scale, err := c.getScale(ctx, kind, namespace, name)
+	if err != nil {
+		return err
+	}
+	if scale.Spec.Replicas != expected {
+		return fmt.Errorf("%s %s/%s was scaled to %d replicas in the meantime", kind, namespace, name, scale.Spec.Replicas)
+	}
+	scale.Spec.Replicas = replicas
+
+	switch kind {
+	case "Deployment":
+		_, err = c.Clientset.AppsV1().Deployments(namespace).UpdateScale(ctx, name, scale, metav1.UpdateOptions{})
+	case "StatefulSet":
+		_, err = c.Clientset.AppsV1().StatefulSets(namespace).UpdateScale(ctx, name, scale, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// Annotate sets the annotations of a Deployment or StatefulSet; a nil value
+// removes the annotation
+func (c *Client) Annotate(ctx context.Context, kind, namespace, name string, annotations map[string]*string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case "Deployment":
+		_, err = c.Clientset.AppsV1().Deployments(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case "StatefulSet":
+		_, err = c.Clientset.AppsV1().StatefulSets(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	default:
+		err = fmt.Errorf("%s %s/%s cannot be scaled", kind, namespace, name)
+	}
+	return err
+}
+
+// Annotated returns the Deployments and StatefulSets in namespace that carry
+// annotation, with its value
+func (c *Client) Annotated(ctx context.Context, namespace, annotation string) (map[WorkloadRef]string, error) {
+	annotated := map[WorkloadRef]string{}
+	add := func(kind string, meta metav1.ObjectMeta) {
+		if value, ok := meta.Annotations[annotation]; ok {
+			annotated[WorkloadRef{Kind: kind, Name: meta.Name}] = value
+		}
+	}
+
+	deployments, err := c.Clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deployments.Items {
+		add("Deployment", d.ObjectMeta)
+	}
+	statefulSets, err := c.Clientset.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range statefulSets.Items {
+		add("StatefulSet", s.ObjectMeta)
+	}
+	return annotated, nil
+}
+
+// getScale reads the scale subresource of a Deployment or StatefulSet
+func (c *Client) getScale(ctx context.Context, kind, namespace, name string) (*autoscalingv1.Scale, error) {
+	switch kind {
+	case "Deployment":
+		return c.Clientset.AppsV1().Deployments(namespace).GetScale(ctx, name, metav1.GetOptions{})
+	case "StatefulSet":
+		return c.Clientset.AppsV1().StatefulSets(namespace).GetScale(ctx, name, metav1.GetOptions{})
+	}
+	return nil, fmt.Errorf("%s %s/%s cannot be scaled", kind, namespace, name)
+}
@@ -0,0 +1,621 @@
+package kube
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// pageSize is the number of objects requested per list call, so that large
+// clusters are read in pages instead of one huge response
+const pageSize = 500
+
+// Resources are amounts of CPU and memory
+type Resources struct {
+	// CPU is in cores
+	CPU float64 `json:"cpu"`
+	// Memory is in bytes
+	Memory float64 `json:"memory"`
+	// GPU counts the GPUs of all vendors
+	GPU float64 `json:"gpu,omitempty"`
+}
+
+// gpuResources are the extended resources device plugins advertise GPUs as
+var gpuResources = []corev1.ResourceName{"nvidia.com/gpu", "amd.com/gpu", "gpu.intel.com/i915"}
+
+// Add returns the sum of r and other
+func (r Resources) Add(other Resources) Resources {
+	return Resources{CPU: r.CPU + other.CPU, Memory: r.Memory + other.Memory, GPU: r.GPU + other.GPU}
+}
+
+// resources converts a Kubernetes resource list
+func resources(list corev1.ResourceList) Resources {
+	r := Resources{CPU: list.Cpu().AsApproximateFloat64(), Memory: list.Memory().AsApproximateFloat64()}
+	for _, name := range gpuResources {
+		if quantity, ok := list[name]; ok {
+			r.GPU += quantity.AsApproximateFloat64()
+		}
+	}
+	return r
+}
+
+// Node is a cluster node and its capacity
+type Node struct {
+	Name          string            `json:"name"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	InstanceType  string            `json:"instance_type,omitempty"`
+	Region        string            `json:"region,omitempty"`
+	Zone          string            `json:"zone,omitempty"`
+	Capacity      Resources         `json:"capacity"`
+	Allocatable   Resources         `json:"allocatable"`
+	Ready         bool              `json:"ready"`
+	Unschedulable bool              `json:"unschedulable"`
+	Created       time.Time         `json:"created"`
+	// Usage is the current usage, nil without metrics-server
+	Usage *Resources `json:"usage,omitempty"`
+
+	// Object is the node as returned by the API
+	Object *corev1.Node `json:"-"`
+}
+
+// WorkloadRef names the workload that owns a pod
+type WorkloadRef struct {
+	// Kind is Deployment, StatefulSet, DaemonSet, Job, CronJob, ReplicaSet
+	// or Pod for pods without a controller
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// Container is a container of a pod with its resource settings
+type Container struct {
+	Name     string    `json:"name"`
+	Requests Resources `json:"requests"`
+	Limits   Resources `json:"limits"`
+	Restarts int32     `json:"restarts"`
+	// LastTermination is the reason the container last terminated, e.g.
+	// OOMKilled
+	LastTermination string `json:"last_termination,omitempty"`
+}
+
+// Pod is a pod with its resource settings and owning workload
+type Pod struct {
+	Namespace  string            `json:"namespace"`
+	Name       string            `json:"name"`
+	Node       string            `json:"node,omitempty"`
+	Phase      string            `json:"phase"`
+	QOSClass   string            `json:"qos_class,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Excluded   bool              `json:"excluded,omitempty"` // annotated, e.g. in the template of its workload
+	Workload   WorkloadRef       `json:"workload"`
+	Containers []Container       `json:"containers"`
+	Created    time.Time         `json:"created"`
+	// Usage is the current usage, nil without metrics-server or for pods
+	// not scraped yet
+	Usage *Resources `json:"usage,omitempty"`
+
+	// Object is the pod as returned by the API
+	Object *corev1.Pod `json:"-"`
+}
+
+// Requests returns the sum of the container requests
+func (p *Pod) Requests() Resources {
+	var total Resources
+	for _, c := range p.Containers {
+		total = total.Add(c.Requests)
+	}
+	return total
+}
+
+// Limits returns the sum of the container limits
+func (p *Pod) Limits() Resources {
+	var total Resources
+	for _, c := range p.Containers {
+		total = total.Add(c.Limits)
+	}
+	return total
+}
+
+// Restarts returns the restarts of all containers
+func (p *Pod) Restarts() int32 {
+	var total int32
+	for _, c := range p.Containers {
+		total += c.Restarts
+	}
+	return total
+}
+
+// Workload is a Deployment, StatefulSet, DaemonSet, Job or CronJob
+type Workload struct {
+	Kind      string            `json:"kind"`
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	// Annotations are those of the workload, not of its pod template
+	Annotations map[string]string `json:"-"`
+	// Excluded is true if the workload is annotated to be left alone by
+	// optimizations
+	Excluded bool `json:"excluded,omitempty"`
+	// Replicas is the desired number of pods, the parallelism of Jobs, nil
+	// for DaemonSets and CronJobs
+	Replicas      *int32    `json:"replicas,omitempty"`
+	ReadyReplicas int32     `json:"ready_replicas"`
+	Template      Resources `json:"pod_requests"`
+	Created       time.Time `json:"created"`
+}
+
+// Event is a Kubernetes event about an object
+type Event struct {
+	Namespace string    `json:"namespace"`
+	Kind      string    `json:"kind"`
+	Object    string    `json:"object"`
+	Type      string    `json:"type"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Count     int32     `json:"count"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Snapshot is the state of a cluster at one point in time
+type Snapshot struct {
+	Context     string     `json:"context"`
+	Namespace   string     `json:"namespace,omitempty"`
+	CollectedAt time.Time  `json:"collected_at"`
+	Nodes       []Node     `json:"nodes"`
+	Pods        []Pod      `json:"pods"`
+	Workloads   []Workload `json:"workloads"`
+	Events      []Event    `json:"events,omitempty"`
+	// Metrics tells whether usage was read, nil if it was not asked for
+	Metrics *MetricsStatus `json:"metrics,omitempty"`
+
+	metricsErr error
+}
+
+// MetricsError returns why usage could not be read, nil if it was read or
+// not asked for
+func (s *Snapshot) MetricsError() error {
+	return s.metricsErr
+}
+
+// CollectOptions select what Collect reads
+type CollectOptions struct {
+	// Namespace limits pods, workloads and events, all namespaces if empty
+	Namespace string
+	// RunningOnly skips pods that are not running
+	RunningOnly bool
+	// Events also reads the events of the last EventWindow, an hour if
+	// zero
+	Events      bool
+	EventWindow time.Duration
+	// Metrics also reads the current usage of pods and nodes from
+	// metrics-server. A cluster without it is reported in Snapshot.Metrics
+	// instead of failing the collection.
+	Metrics bool
+}
+
+// Collect reads nodes, pods, workloads and optionally events and usage of
+// the cluster. The lists are read concurrently.
+func (c *Client) Collect(ctx context.Context, opts CollectOptions) (*Snapshot, error) {
+	snapshot := &Snapshot{Context: c.Context, Namespace: opts.Namespace, CollectedAt: time.Now().UTC()}
+	owners := &ownerIndex{replicaSets: map[string]string{}, jobs: map[string]string{}}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		pods     []corev1.Pod
+	)
+	run := func(action string, list func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := list(); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = APIError(err, action)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	run("list nodes", func() error {
+		nodes, err := c.listNodes(ctx)
+		snapshot.Nodes = nodes
+		return err
+	})
+	run("list pods", func() error {
+		var err error
+		pods, err = c.listPods(ctx, opts)
+		return err
+	})
+	run("list replica sets", func() error { return c.indexReplicaSets(ctx, opts.Namespace, owners) })
+	run("list jobs", func() error { return c.indexJobs(ctx, opts.Namespace, owners) })
+	run("list workloads", func() error {
+		workloads, err := c.listWorkloads(ctx, opts.Namespace)
+		snapshot.Workloads = workloads
+		return err
+	})
+	var podUsage, nodeUsage map[string]Resources
+	if opts.Metrics {
+		snapshot.Metrics = &MetricsStatus{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			podUsage, snapshot.Metrics.Timestamp, err = c.PodMetrics(ctx, opts.Namespace)
+			if err == nil {
+				nodeUsage, err = c.NodeMetrics(ctx)
+			}
+			if err != nil {
+				snapshot.metricsErr = err
+				snapshot.Metrics.Error = clierr.From(err).Message
+				return
+			}
+			snapshot.Metrics.Available = true
+		}()
+	}
+	if opts.Events {
+		run("list events", func() error {
+			window := opts.EventWindow
+			if window == 0 {
+				window = time.Hour
+			}
+			events, err := c.listEvents(ctx, opts.Namespace, window)
+			snapshot.Events = events
+			return err
+		})
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	snapshot.Pods = make([]Pod, 0, len(pods))
+	for i := range pods {
+		pod := newPod(&pods[i], owners)
+		if used, ok := podUsage[pod.Namespace+"/"+pod.Name]; ok {
+			pod.Usage = &used
+		}
+		snapshot.Pods = append(snapshot.Pods, pod)
+	}
+	for i := range snapshot.Nodes {
+		if used, ok := nodeUsage[snapshot.Nodes[i].Name]; ok {
+			snapshot.Nodes[i].Usage = &used
+		}
+	}
+	sort.Slice(snapshot.Pods, func(i, j int) bool {
+		if snapshot.Pods[i].Namespace != snapshot.Pods[j].Namespace {
+			return snapshot.Pods[i].Namespace < snapshot.Pods[j].Namespace
+		}
+		return snapshot.Pods[i].Name < snapshot.Pods[j].Name
+	})
+	return snapshot, nil
+}
+
+// Workload returns the workload of the snapshot with the given kind,
+// namespace and name
+func (s *Snapshot) Workload(kind, namespace, name string) (*Workload, bool) {
+	for i := range s.Workloads {
+		w := &s.Workloads[i]
+		if w.Kind == kind && w.Namespace == namespace && w.Name == name {
+			return w, true
+		}
+	}
+	return nil, false
+}
+
+// ownerIndex resolves the intermediate controllers of pods to workloads:
+// ReplicaSets to Deployments and Jobs to CronJobs
+type ownerIndex struct {
+	mu          sync.Mutex
+	replicaSets map[string]string
+	jobs        map[string]string
+}
+
+// workload returns the workload that owns a pod
+func (o *ownerIndex) workload(pod *corev1.Pod) WorkloadRef {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return WorkloadRef{Kind: "Pod", Name: pod.Name}
+	}
+	key := pod.Namespace + "/" + owner.Name
+	switch owner.Kind {
+	case "ReplicaSet":
+		if deployment, ok := o.replicaSets[key]; ok {
+			return WorkloadRef{Kind: "Deployment", Name: deployment}
+		}
+	case "Job":
+		if cronJob, ok := o.jobs[key]; ok {
+			return WorkloadRef{Kind: "CronJob", Name: cronJob}
+		}
+	}
+	return WorkloadRef{Kind: owner.Kind, Name: owner.Name}
+}
+
+// newPod converts a pod of the API
+func newPod(pod *corev1.Pod, owners *ownerIndex) Pod {
+	p := Pod{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Node:      pod.Spec.NodeName,
+		Phase:     string(pod.Status.Phase),
+		QOSClass:  string(pod.Status.QOSClass),
+		Labels:    pod.Labels,
+		Excluded:  pod.Annotations[ExcludeAnnotation] == "true",
+		Workload:  owners.workload(pod),
+		Created:   pod.CreationTimestamp.Time,
+		Object:    pod,
+	}
+	statuses := map[string]corev1.ContainerStatus{}
+	for _, status := range pod.Status.ContainerStatuses {
+		statuses[status.Name] = status
+	}
+	for _, container := range pod.Spec.Containers {
+		c := Container{
+			Name:     container.Name,
+			Requests: resources(container.Resources.Requests),
+			Limits:   resources(container.Resources.Limits),
+		}
+		if status, ok := statuses[container.Name]; ok {
+			c.Restarts = status.RestartCount
+			if terminated := status.LastTerminationState.Terminated; terminated != nil {
+				c.LastTermination = terminated.Reason
+			}
+		}
+		p.Containers = append(p.Containers, c)
+	}
+	return p
+}
+
+// listNodes reads all nodes
+func (c *Client) listNodes(ctx context.Context) ([]Node, error) {
+	var nodes []Node
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.CoreV1().Nodes().List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		for i := range list.Items {
+			node := &list.Items[i]
+			n := Node{
+				Name:          node.Name,
+				Labels:        node.Labels,
+				InstanceType:  node.Labels[corev1.LabelInstanceTypeStable],
+				Region:        node.Labels[corev1.LabelTopologyRegion],
+				Zone:          node.Labels[corev1.LabelTopologyZone],
+				Capacity:      resources(node.Status.Capacity),
+				Allocatable:   resources(node.Status.Allocatable),
+				Unschedulable: node.Spec.Unschedulable,
+				Created:       node.CreationTimestamp.Time,
+				Object:        node,
+			}
+			for _, condition := range node.Status.Conditions {
+				if condition.Type == corev1.NodeReady {
+					n.Ready = condition.Status == corev1.ConditionTrue
+				}
+			}
+			nodes = append(nodes, n)
+		}
+		return list.Continue, nil
+	})
+	return nodes, err
+}
+
+// listPods reads the pods of a namespace
+func (c *Client) listPods(ctx context.Context, opts CollectOptions) ([]corev1.Pod, error) {
+	var pods []corev1.Pod
+	err := eachPage(ctx, func(listOpts metav1.ListOptions) (string, error) {
+		if opts.RunningOnly {
+			listOpts.FieldSelector = "status.phase=Running"
+		}
+		list, err := c.Clientset.CoreV1().Pods(opts.Namespace).List(ctx, listOpts)
+		if err != nil {
+			return "", err
+		}
+		pods = append(pods, list.Items...)
+		return list.Continue, nil
+	})
+	return pods, err
+}
+
+// indexReplicaSets records the Deployments owning the replica sets of a
+// namespace
+func (c *Client) indexReplicaSets(ctx context.Context, namespace string, owners *ownerIndex) error {
+	return eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.AppsV1().ReplicaSets(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		owners.mu.Lock()
+		defer owners.mu.Unlock()
+		for i := range list.Items {
+			rs := &list.Items[i]
+			if owner := metav1.GetControllerOf(rs); owner != nil && owner.Kind == "Deployment" {
+				owners.replicaSets[rs.Namespace+"/"+rs.Name] = owner.Name
+			}
+		}
+		return list.Continue, nil
+	})
+}
+
+// indexJobs records the CronJobs owning the jobs of a namespace
+func (c *Client) indexJobs(ctx context.Context, namespace string, owners *ownerIndex) error {
+	return eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.BatchV1().Jobs(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		owners.mu.Lock()
+		defer owners.mu.Unlock()
+		for i := range list.Items {
+			job := &list.Items[i]
+			if owner := metav1.GetControllerOf(job); owner != nil && owner.Kind == "CronJob" {
+				owners.jobs[job.Namespace+"/"+job.Name] = owner.Name
+			}
+		}
+		return list.Continue, nil
+	})
+}
+
+// listWorkloads reads the Deployments, StatefulSets, DaemonSets and CronJobs
+// of a namespace
+func (c *Client) listWorkloads(ctx context.Context, namespace string) ([]Workload, error) {
+	var workloads []Workload
+	apps := c.Clientset.AppsV1()
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := apps.Deployments(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		for _, d := range list.Items {
+			workloads = append(workloads, newWorkload("Deployment", d.ObjectMeta, d.Spec.Replicas, d.Status.ReadyReplicas, d.Spec.Template.Spec))
+		}
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := apps.StatefulSets(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		for _, s := range list.Items {
+			workloads = append(workloads, newWorkload("StatefulSet", s.ObjectMeta, s.Spec.Replicas, s.Status.ReadyReplicas, s.Spec.Template.Spec))
+		}
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := apps.DaemonSets(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		for _, d := range list.Items {
+			workloads = append(workloads, newWorkload("DaemonSet", d.ObjectMeta, nil, d.Status.NumberReady, d.Spec.Template.Spec))
+		}
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.BatchV1().CronJobs(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		for _, j := range list.Items {
+			workloads = append(workloads, newWorkload("CronJob", j.ObjectMeta, nil, 0, j.Spec.JobTemplate.Spec.Template.Spec))
+		}
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(workloads, func(i, j int) bool {
+		a, b := workloads[i], workloads[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return workloads, nil
+}
+
+// newWorkload converts a workload of the API
+func newWorkload(kind string, meta metav1.ObjectMeta, replicas *int32, ready int32, template corev1.PodSpec) Workload {
+	w := Workload{
+		Kind:          kind,
+		Namespace:     meta.Namespace,
+		Name:          meta.Name,
+		Labels:        meta.Labels,
+		Annotations:   meta.Annotations,
+		Excluded:      meta.Annotations[ExcludeAnnotation] == "true",
+		Replicas:      replicas,
+		ReadyReplicas: ready,
+		Created:       meta.CreationTimestamp.Time,
+	}
+	if replicas == nil && (kind == "Deployment" || kind == "StatefulSet") {
+		// The API defaults unset replicas to 1
+		one := int32(1)
+		w.Replicas = &one
+	}
+	for _, container := range template.Containers {
+		w.Template = w.Template.Add(resources(container.Resources.Requests))
+	}
+	return w
+}
+
+// listEvents reads the events of a namespace seen within the given time
+func (c *Client) listEvents(ctx context.Context, namespace string, within time.Duration) ([]Event, error) {
+	since := time.Now().Add(-within)
+	var events []Event
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.CoreV1().Events(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		for i := range list.Items {
+			event := NewEvent(&list.Items[i])
+			if event.LastSeen.Before(since) {
+				continue
+			}
+			events = append(events, event)
+		}
+		return list.Continue, nil
+	})
+	sort.Slice(events, func(i, j int) bool { return events[i].LastSeen.Before(events[j].LastSeen) })
+	return events, err
+}
+
+// NewEvent converts an event of the API
+func NewEvent(event *corev1.Event) Event {
+	lastSeen := event.LastTimestamp.Time
+	if lastSeen.IsZero() {
+		lastSeen = event.EventTime.Time
+	}
+	if lastSeen.IsZero() {
+		lastSeen = event.CreationTimestamp.Time
+	}
+	count := event.Count
+	if event.Series != nil {
+		count = event.Series.Count
+	}
+	return Event{
+		Namespace: event.Namespace,
+		Kind:      event.InvolvedObject.Kind,
+		Object:    event.InvolvedObject.Name,
+		Type:      event.Type,
+		Reason:    event.Reason,
+		Message:   event.Message,
+		Count:     count,
+		LastSeen:  lastSeen,
+	}
+}
+
+// eachPage calls list with the options of each page until the API reports
+// no more pages
+func eachPage(ctx context.Context, list func(opts metav1.ListOptions) (string, error)) error {
+	opts := metav1.ListOptions{Limit: pageSize}
+	for {
+		next, err := list(opts)
+		if err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		opts.Continue = next
+	}
+}
@@ -0,0 +1,241 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	veleroBackupResource          = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "backups"}
+	veleroScheduleResource        = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "schedules"}
+	veleroPodVolumeBackupResource = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "podvolumebackups"}
+	veleroDataUploadResource      = schema.GroupVersionResource{Group: "velero.io", Version: "v2alpha1", Resource: "datauploads"}
+)
+
+// veleroBackupLabel labels the objects of a Velero backup with its name
+const veleroBackupLabel = "velero.io/backup-name"
+
+// veleroScheduleLabel labels backups with the schedule that created them
+const veleroScheduleLabel = "velero.io/schedule-name"
+
+// VeleroBackup is a Velero backup with the data of the volumes it uploaded
+// to its backup storage location
+type VeleroBackup struct {
+	Name     string
+	Schedule string
+	Location string
+	// Namespaces are those included, all if empty
+	Namespaces []string
+	// Phase is Completed, PartiallyFailed, Failed, FailedValidation or
+	// one of the phases of a backup in progress
+	Phase         string
+	FailureReason string
+	Errors        int
+	Warnings      int
+	Started       time.Time
+	Completed     time.Time
+	Expiration    time.Time
+	// VolumeSnapshots counts the volumes backed up by snapshots, which are
+	// not in the backup storage location
+	VolumeSnapshots int
+	// Volumes are the bytes of the volumes uploaded by file system backups
+	// and snapshot data movement, keyed by the namespace of their pod or
+	// claim
+	Volumes map[string]float64
+}
+
+// VeleroSchedule is a Velero schedule of backups
+type VeleroSchedule struct {
+	Name     string
+	Schedule string
+	// TTL is how long its backups are kept, 30 days by default
+	TTL        time.Duration
+	Paused     bool
+	LastBackup time.Time
+}
+
+// VeleroBackups lists the Velero backups and schedules of the cluster,
+// with the bytes each backup uploaded for volumes. It returns false if
+// Velero is not installed.
+func (c *Client) VeleroBackups(ctx context.Context) ([]VeleroBackup, []VeleroSchedule, bool, error) {
+	client, err := dynamic.NewForConfig(c.Config)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	list, err := client.Resource(veleroBackupResource).List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil, false, nil
+	}
+	if err != nil {
+		return nil, nil, false, APIError(err, "list velero backups")
+	}
+
+	backups := make([]VeleroBackup, 0, len(list.Items))
+	index := map[string]int{}
+	for _, item := range list.Items {
+		var object veleroBackupObject
+		data, err := item.MarshalJSON()
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, nil, false, err
+		}
+		backup := VeleroBackup{
+			Name:            object.Metadata.Name,
+			Schedule:        object.Metadata.Labels[veleroScheduleLabel],
+			Location:        object.Spec.StorageLocation,
+			Namespaces:      object.Spec.IncludedNamespaces,
+			Phase:           object.Status.Phase,
+			FailureReason:   object.Status.FailureReason,
+			Errors:          object.Status.Errors,
+			Warnings:        object.Status.Warnings,
+			VolumeSnapshots: object.Status.VolumeSnapshotsCompleted + object.Status.CSIVolumeSnapshotsCompleted,
+			Started:         object.Metadata.CreationTimestamp.Time,
+			Volumes:         map[string]float64{},
+		}
+		if object.Status.StartTimestamp != nil {
+			backup.Started = object.Status.StartTimestamp.Time
+		}
+		if object.Status.CompletionTimestamp != nil {
+			backup.Completed = object.Status.CompletionTimestamp.Time
+		}
+		if object.Status.Expiration != nil {
+			backup.Expiration = object.Status.Expiration.Time
+		}
+		index[backup.Name] = len(backups)
+		backups = append(backups, backup)
+	}
+
+	// The volume data of the backups, from file system backups and, since
+	// Velero 1.12, snapshot data movement
+	uploads := []struct {
+		resource  schema.GroupVersionResource
+		action    string
+		namespace func(veleroUploadObject) string
+	}{
+		{veleroPodVolumeBackupResource, "list velero pod volume backups", func(o veleroUploadObject) string { return o.Spec.Pod.Namespace }},
+		{veleroDataUploadResource, "list velero data uploads", func(o veleroUploadObject) string { return o.Spec.SourceNamespace }},
+	}
+	for _, upload := range uploads {
+		list, err := client.Resource(upload.resource).List(ctx, metav1.ListOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, false, APIError(err, upload.action)
+		}
+		for _, item := range list.Items {
+			var object veleroUploadObject
+			data, err := item.MarshalJSON()
+			if err != nil {
+				return nil, nil, false, err
+			}
+			if err := json.Unmarshal(data, &object); err != nil {
+				return nil, nil, false, err
+			}
+			i, ok := index[object.Metadata.Labels[veleroBackupLabel]]
+			if !ok {
+				continue
+			}
+			bytes := object.Status.Progress.TotalBytes
+			if bytes == 0 {
+				bytes = object.Status.Progress.BytesDone
+			}
+			backups[i].Volumes[upload.namespace(object)] += float64(bytes)
+		}
+	}
+
+	var schedules []VeleroSchedule
+	scheduleList, err := client.Resource(veleroScheduleResource).List(ctx, metav1.ListOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, nil, false, APIError(err, "list velero schedules")
+	}
+	if scheduleList != nil {
+		for _, item := range scheduleList.Items {
+			var object veleroScheduleObject
+			data, err := item.MarshalJSON()
+			if err != nil {
+				return nil, nil, false, err
+			}
+			if err := json.Unmarshal(data, &object); err != nil {
+				return nil, nil, false, err
+			}
+			schedule := VeleroSchedule{
+				Name:     object.Metadata.Name,
+				Schedule: object.Spec.Schedule,
+				TTL:      30 * 24 * time.Hour,
+				Paused:   object.Spec.Paused || object.Status.Phase == "Disabled",
+			}
+			if object.Spec.Template.TTL.Duration > 0 {
+				schedule.TTL = object.Spec.Template.TTL.Duration
+			}
+			if object.Status.LastBackup != nil {
+				schedule.LastBackup = object.Status.LastBackup.Time
+			}
+			schedules = append(schedules, schedule)
+		}
+	}
+	return backups, schedules, true, nil
+}
+
+// veleroBackupObject holds the fields of a Velero Backup that are analyzed
+type veleroBackupObject struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		IncludedNamespaces []string `json:"includedNamespaces"`
+		StorageLocation    string   `json:"storageLocation"`
+	} `json:"spec"`
+	Status struct {
+		Phase                       string       `json:"phase"`
+		FailureReason               string       `json:"failureReason"`
+		Errors                      int          `json:"errors"`
+		Warnings                    int          `json:"warnings"`
+		StartTimestamp              *metav1.Time `json:"startTimestamp"`
+		CompletionTimestamp         *metav1.Time `json:"completionTimestamp"`
+		Expiration                  *metav1.Time `json:"expiration"`
+		VolumeSnapshotsCompleted    int          `json:"volumeSnapshotsCompleted"`
+		CSIVolumeSnapshotsCompleted int          `json:"csiVolumeSnapshotsCompleted"`
+	} `json:"status"`
+}
+
+// veleroUploadObject holds the fields of a PodVolumeBackup or DataUpload
+// that are analyzed
+type veleroUploadObject struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		Pod struct {
+			Namespace string `json:"namespace"`
+		} `json:"pod"`
+		SourceNamespace string `json:"sourceNamespace"`
+	} `json:"spec"`
+	Status struct {
+		Progress struct {
+			TotalBytes int64 `json:"totalBytes"`
+			BytesDone  int64 `json:"bytesDone"`
+		} `json:"progress"`
+	} `json:"status"`
+}
+
+// veleroScheduleObject holds the fields of a Velero Schedule that are
+// analyzed
+type veleroScheduleObject struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		Schedule string `json:"schedule"`
+		Paused   bool   `json:"paused"`
+		Template struct {
+			TTL metav1.Duration `json:"ttl"`
+		} `json:"template"`
+	} `json:"spec"`
+	Status struct {
+		Phase      string       `json:"phase"`
+		LastBackup *metav1.Time `json:"lastBackup"`
+	} `json:"status"`
+}
@@ -0,0 +1,176 @@
+package kube
+
+import (
+	"context"
+	"regexp"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// PersistentVolumeClaims lists the persistent volume claims in namespace,
+// all namespaces if empty
+func (c *Client) PersistentVolumeClaims(ctx context.Context, namespace string) ([]corev1.PersistentVolumeClaim, error) {
+	var claims []corev1.PersistentVolumeClaim
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.CoreV1().PersistentVolumeClaims(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		claims = append(claims, list.Items...)
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, APIError(err, "list persistent volume claims")
+	}
+	return claims, nil
+}
+
+// StorageClasses returns the storage classes of the cluster by name
+func (c *Client) StorageClasses(ctx context.Context) (map[string]storagev1.StorageClass, error) {
+	classes := map[string]storagev1.StorageClass{}
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.StorageV1().StorageClasses().List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		for _, class := range list.Items {
+			classes[class.Name] = class
+		}
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, APIError(err, "list storage classes")
+	}
+	return classes, nil
+}
+
+// VolumeAttributesClass returns a VolumeAttributesClass, nil if there is
+// none of that name or the cluster does not serve them, before Kubernetes
+// 1.34 unless the beta API is enabled
+func (c *Client) VolumeAttributesClass(ctx context.Context, name string) (*storagev1.VolumeAttributesClass, error) {
+	class, err := c.Clientset.StorageV1().VolumeAttributesClasses().Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, APIError(err, "get volume attributes class "+name)
+	}
+	return class, nil
+}
+
+// ClaimUsers returns the pods in namespace (all if empty) that mount each
+// persistent volume claim, keyed by "namespace/claim". Pods that completed
+// or failed no longer hold their claims.
+func (c *Client) ClaimUsers(ctx context.Context, namespace string) (map[string][]string, error) {
+	users := map[string][]string{}
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.CoreV1().Pods(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		for _, pod := range list.Items {
+			if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			for _, volume := range pod.Spec.Volumes {
+				if volume.PersistentVolumeClaim != nil {
+					key := pod.Namespace + "/" + volume.PersistentVolumeClaim.ClaimName
+					users[key] = append(users[key], pod.Name)
+				}
+			}
+		}
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, APIError(err, "list pods")
+	}
+	return users, nil
+}
+
+// ClaimOwners returns a function finding the StatefulSet in namespace (all
+// if empty) whose volume claim templates a persistent volume claim was
+// created from, named <template>-<statefulset>-<ordinal>, "" if none. Such
+// claims outlive pods, so that a StatefulSet scaled down finds its data when
+// scaled up again.
+func (c *Client) ClaimOwners(ctx context.Context, namespace string) (func(namespace, claim string) string, error) {
+	type template struct {
+		pattern *regexp.Regexp
+		owner   string
+	}
+	var templates []template
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.AppsV1().StatefulSets(namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		for _, set := range list.Items {
+			for _, claim := range set.Spec.VolumeClaimTemplates {
+				templates = append(templates, template{
+					pattern: regexp.MustCompile("^" + regexp.QuoteMeta(set.Namespace+"/"+claim.Name+"-"+set.Name+"-") + "[0-9]+$"),
+					owner:   set.Name,
+				})
+			}
+		}
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, APIError(err, "list statefulsets")
+	}
+	return func(namespace, claim string) string {
+		for _, t := range templates {
+			if t.pattern.MatchString(namespace + "/" + claim) {
+				return t.owner
+			}
+		}
+		return ""
+	}, nil
+}
+
+// NamespaceNames returns the names of the namespaces of the cluster
+func (c *Client) NamespaceNames(ctx context.Context) (map[string]bool, error) {
+	names := map[string]bool{}
+	err := eachPage(ctx, func(opts metav1.ListOptions) (string, error) {
+		list, err := c.Clientset.CoreV1().Namespaces().List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		for _, namespace := range list.Items {
+			names[namespace.Name] = true
+		}
+		return list.Continue, nil
+	})
+	if err != nil {
+		return nil, APIError(err, "list namespaces")
+	}
+	return names, nil
+}
+
+// DeletePersistentVolumeClaim deletes a persistent volume claim, unless it
+// was replaced by one of the same name since it was read. A claim already
+// gone is not an error.
+func (c *Client) DeletePersistentVolumeClaim(ctx context.Context, namespace, name string, uid types.UID) error {
+	err := c.Clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &uid},
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return APIError(err, "delete persistent volume claim "+namespace+"/"+name)
+	}
+	return nil
+}
+
+// DeletePersistentVolume deletes a persistent volume, unless it was replaced
+// by one of the same name since it was read. A volume already gone is not an
+// error.
+func (c *Client) DeletePersistentVolume(ctx context.Context, name string, uid types.UID) error {
+	err := c.Clientset.CoreV1().PersistentVolumes().Delete(ctx, name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &uid},
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return APIError(err, "delete persistent volume "+name)
+	}
+	return nil
+}
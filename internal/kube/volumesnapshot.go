@@ -0,0 +1,214 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	volumeSnapshotResource        = schema.GroupVersionResource{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshots"}
+	volumeSnapshotContentResource = schema.GroupVersionResource{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshotcontents"}
+	volumeSnapshotClassResource   = schema.GroupVersionResource{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshotclasses"}
+)
+
+// defaultSnapshotClassAnnotation marks the VolumeSnapshotClass of a driver
+// used by snapshots that name none
+const defaultSnapshotClassAnnotation = "snapshot.storage.kubernetes.io/is-default-class"
+
+// VolumeSnapshot is a CSI VolumeSnapshot with the content it is bound to,
+// or a VolumeSnapshotContent whose VolumeSnapshot was deleted
+type VolumeSnapshot struct {
+	// Namespace and Name are those of the VolumeSnapshot, Name is "" for
+	// content whose snapshot was deleted, Namespace that it referenced
+	Namespace string
+	Name      string
+	// Claim is the persistent volume claim the snapshot was taken of
+	Claim string
+	Class string
+	// Content is the VolumeSnapshotContent and Handle the ID of the
+	// snapshot at the provider, e.g. snap-0123456789abcdef0 for EBS
+	Content        string
+	Handle         string
+	Driver         string
+	DeletionPolicy string
+	// Size is the size of a volume restored from it, in bytes
+	Size    float64
+	Ready   bool
+	Created time.Time
+}
+
+// VolumeSnapshots lists the VolumeSnapshots in namespace, all if empty, and
+// the contents whose snapshot was deleted. It returns false if the snapshot
+// custom resources are not installed.
+func (c *Client) VolumeSnapshots(ctx context.Context, namespace string) ([]VolumeSnapshot, bool, error) {
+	client, err := dynamic.NewForConfig(c.Config)
+	if err != nil {
+		return nil, false, err
+	}
+	list, err := client.Resource(volumeSnapshotResource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, APIError(err, "list volume snapshots")
+	}
+	contentList, err := client.Resource(volumeSnapshotContentResource).List(ctx, metav1.ListOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, false, APIError(err, "list volume snapshot contents")
+	}
+
+	contents := map[string]volumeSnapshotContentObject{}
+	if contentList != nil {
+		for _, item := range contentList.Items {
+			data, err := item.MarshalJSON()
+			if err != nil {
+				return nil, false, err
+			}
+			var object volumeSnapshotContentObject
+			if err := json.Unmarshal(data, &object); err != nil {
+				return nil, false, err
+			}
+			contents[object.Metadata.Name] = object
+		}
+	}
+
+	snapshots := make([]VolumeSnapshot, 0, len(list.Items))
+	bound := map[string]bool{}
+	for _, item := range list.Items {
+		data, err := item.MarshalJSON()
+		if err != nil {
+			return nil, false, err
+		}
+		var object volumeSnapshotObject
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, false, err
+		}
+		snapshot := VolumeSnapshot{
+			Namespace: object.Metadata.Namespace,
+			Name:      object.Metadata.Name,
+			Claim:     object.Spec.Source.PersistentVolumeClaimName,
+			Class:     object.Spec.VolumeSnapshotClassName,
+			Content:   object.Status.BoundVolumeSnapshotContentName,
+			Ready:     object.Status.ReadyToUse,
+			Created:   object.Metadata.CreationTimestamp.Time,
+		}
+		if object.Status.CreationTime != nil {
+			snapshot.Created = object.Status.CreationTime.Time
+		}
+		if object.Status.RestoreSize != nil {
+			snapshot.Size = object.Status.RestoreSize.AsApproximateFloat64()
+		}
+		if content, ok := contents[snapshot.Content]; ok {
+			bound[snapshot.Content] = true
+			content.fill(&snapshot)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	for name, content := range contents {
+		ref := content.Spec.VolumeSnapshotRef
+		if bound[name] || namespace != "" && ref.Namespace != namespace {
+			continue
+		}
+		snapshot := VolumeSnapshot{
+			Namespace: ref.Namespace,
+			Class:     content.Spec.VolumeSnapshotClassName,
+			Content:   name,
+			Created:   content.Metadata.CreationTimestamp.Time,
+		}
+		content.fill(&snapshot)
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, true, nil
+}
+
+// VolumeSnapshotClasses returns the VolumeSnapshotClass of each CSI driver,
+// its default class if it has several, keyed by driver. It is empty if the
+// snapshot custom resources are not installed.
+func (c *Client) VolumeSnapshotClasses(ctx context.Context) (map[string]string, error) {
+	client, err := dynamic.NewForConfig(c.Config)
+	if err != nil {
+		return nil, err
+	}
+	list, err := client.Resource(volumeSnapshotClassResource).List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, APIError(err, "list volume snapshot classes")
+	}
+	classes := map[string]string{}
+	for _, item := range list.Items {
+		driver, _, _ := unstructured.NestedString(item.Object, "driver")
+		if _, ok := classes[driver]; !ok || item.GetAnnotations()[defaultSnapshotClassAnnotation] == "true" {
+			classes[driver] = item.GetName()
+		}
+	}
+	return classes, nil
+}
+
+// volumeSnapshotObject holds the fields of a VolumeSnapshot that are
+// analyzed
+type volumeSnapshotObject struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		Source struct {
+			PersistentVolumeClaimName string `json:"persistentVolumeClaimName"`
+		} `json:"source"`
+		VolumeSnapshotClassName string `json:"volumeSnapshotClassName"`
+	} `json:"spec"`
+	Status struct {
+		BoundVolumeSnapshotContentName string             `json:"boundVolumeSnapshotContentName"`
+		CreationTime                   *metav1.Time       `json:"creationTime"`
+		ReadyToUse                     bool               `json:"readyToUse"`
+		RestoreSize                    *resource.Quantity `json:"restoreSize"`
+	} `json:"status"`
+}
+
+// volumeSnapshotContentObject holds the fields of a VolumeSnapshotContent
+// that are analyzed
+type volumeSnapshotContentObject struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		DeletionPolicy          string `json:"deletionPolicy"`
+		Driver                  string `json:"driver"`
+		VolumeSnapshotClassName string `json:"volumeSnapshotClassName"`
+		VolumeSnapshotRef       struct {
+			Namespace string `json:"namespace"`
+			Name      string `json:"name"`
+		} `json:"volumeSnapshotRef"`
+	} `json:"spec"`
+	Status struct {
+		// CreationTime is in nanoseconds since the epoch
+		CreationTime   *int64 `json:"creationTime"`
+		ReadyToUse     *bool  `json:"readyToUse"`
+		RestoreSize    *int64 `json:"restoreSize"`
+		SnapshotHandle string `json:"snapshotHandle"`
+	} `json:"status"`
+}
+
+// fill completes a snapshot with what its content knows
+func (o volumeSnapshotContentObject) fill(snapshot *VolumeSnapshot) {
+	snapshot.Handle = o.Status.SnapshotHandle
+	snapshot.Driver = o.Spec.Driver
+	snapshot.DeletionPolicy = o.Spec.DeletionPolicy
+	if snapshot.Size == 0 && o.Status.RestoreSize != nil {
+		snapshot.Size = float64(*o.Status.RestoreSize)
+	}
+	if snapshot.Name == "" {
+		if o.Status.CreationTime != nil {
+			snapshot.Created = time.Unix(0, *o.Status.CreationTime)
+		}
+		if o.Status.ReadyToUse != nil {
+			snapshot.Ready = *o.Status.ReadyToUse
+		}
+	}
+}
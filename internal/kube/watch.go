@@ -0,0 +1,217 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Severities of changes, from least to most severe
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Severities lists the valid severities in increasing order
+var Severities = []string{SeverityInfo, SeverityWarning, SeverityCritical}
+
+// syncTimeout bounds the initial listing of a watch, after which an
+// unreachable cluster is reported instead of retried forever
+const syncTimeout = 30 * time.Second
+
+// Change is a notable change of a pod or workload seen while watching
+type Change struct {
+	Time     time.Time `json:"time"`
+	Severity string    `json:"severity"`
+	// Reason is Restarted, OOMKilled, Evicted or Scaled, or Budget for the
+	// alerts of budgets reported along
+	Reason    string `json:"reason"`
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Message   string `json:"message"`
+}
+
+// WatchOptions selects the changes to watch
+type WatchOptions struct {
+	// Namespace limits the watch to one namespace, all if empty
+	Namespace string
+	// MinSeverity drops changes less severe than it, none if empty
+	MinSeverity string
+}
+
+// SeverityRank orders severities; unknown severities rank lowest
+func SeverityRank(severity string) int {
+	for i, s := range Severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// Watch streams pod restarts, OOM kills, evictions and workload scaling to
+// emit as they happen, until ctx is done. Only changes after the watch
+// started are reported; emit is never called concurrently.
+func (c *Client) Watch(ctx context.Context, opts WatchOptions, emit func(Change)) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(c.Clientset, 0, informers.WithNamespace(opts.Namespace))
+	defer factory.Shutdown()
+	// Stop the informers before waiting for them to shut down
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	changes := make(chan Change, 64)
+	send := func(found []Change) {
+		for _, change := range found {
+			if SeverityRank(change.Severity) < SeverityRank(opts.MinSeverity) {
+				continue
+			}
+			select {
+			case changes <- change:
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	// Failed lists and watches are retried; keep the last failure to
+	// report if the informers never sync
+	var mu sync.Mutex
+	var lastErr error
+	onError := func(_ *cache.Reflector, err error) {
+		slog.Debug("watch failed, retrying", "context", c.Context, "error", err)
+		mu.Lock()
+		lastErr = err
+		mu.Unlock()
+	}
+	watch := func(informer cache.SharedIndexInformer, update func(oldObj, newObj interface{})) error {
+		if err := informer.SetWatchErrorHandler(onError); err != nil {
+			return err
+		}
+		_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{UpdateFunc: update})
+		return err
+	}
+
+	err := watch(factory.Core().V1().Pods().Informer(), func(oldObj, newObj interface{}) {
+		send(podChanges(oldObj.(*corev1.Pod), newObj.(*corev1.Pod)))
+	})
+	if err == nil {
+		err = watch(factory.Apps().V1().Deployments().Informer(), func(oldObj, newObj interface{}) {
+			old, updated := oldObj.(*appsv1.Deployment), newObj.(*appsv1.Deployment)
+			send(scaleChanges("Deployment", updated.Namespace, updated.Name, old.Spec.Replicas, updated.Spec.Replicas))
+		})
+	}
+	if err == nil {
+		err = watch(factory.Apps().V1().StatefulSets().Informer(), func(oldObj, newObj interface{}) {
+			old, updated := oldObj.(*appsv1.StatefulSet), newObj.(*appsv1.StatefulSet)
+			send(scaleChanges("StatefulSet", updated.Namespace, updated.Name, old.Spec.Replicas, updated.Spec.Replicas))
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	factory.Start(ctx.Done())
+	syncCtx, cancelSync := context.WithTimeout(ctx, syncTimeout)
+	defer cancelSync()
+	for _, synced := range factory.WaitForCacheSync(syncCtx.Done()) {
+		if !synced && ctx.Err() == nil {
+			mu.Lock()
+			err := lastErr
+			mu.Unlock()
+			if err == nil {
+				err = fmt.Errorf("no response within %s", syncTimeout)
+			}
+			return APIError(err, "watch cluster")
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case change := <-changes:
+			emit(change)
+		}
+	}
+}
+
+// podChanges compares two versions of a pod for restarts, OOM kills and
+// eviction
+func podChanges(old, updated *corev1.Pod) []Change {
+	var changes []Change
+	add := func(severity, reason, message string) {
+		changes = append(changes, Change{
+			Time:      time.Now(),
+			Severity:  severity,
+			Reason:    reason,
+			Namespace: updated.Namespace,
+			Kind:      "Pod",
+			Name:      updated.Name,
+			Message:   message,
+		})
+	}
+
+	if updated.Status.Reason == "Evicted" && old.Status.Reason != "Evicted" {
+		add(SeverityWarning, "Evicted", updated.Status.Message)
+	}
+
+	previous := map[string]corev1.ContainerStatus{}
+	for _, status := range old.Status.ContainerStatuses {
+		previous[status.Name] = status
+	}
+	for _, status := range updated.Status.ContainerStatuses {
+		before, ok := previous[status.Name]
+		if !ok {
+			continue
+		}
+		if status.RestartCount > before.RestartCount {
+			terminated := status.LastTerminationState.Terminated
+			switch {
+			case terminated != nil && terminated.Reason == "OOMKilled":
+				add(SeverityCritical, "OOMKilled", fmt.Sprintf("container %s was OOM killed and restarted (%d restarts)", status.Name, status.RestartCount))
+			case terminated != nil:
+				add(SeverityWarning, "Restarted", fmt.Sprintf("container %s restarted after exiting with code %d (%d restarts)", status.Name, terminated.ExitCode, status.RestartCount))
+			default:
+				add(SeverityWarning, "Restarted", fmt.Sprintf("container %s restarted (%d restarts)", status.Name, status.RestartCount))
+			}
+			continue
+		}
+		// Containers that are not restarted only report the kill in their state
+		terminated := status.State.Terminated
+		if terminated != nil && terminated.Reason == "OOMKilled" && before.State.Terminated == nil {
+			add(SeverityCritical, "OOMKilled", fmt.Sprintf("container %s was OOM killed", status.Name))
+		}
+	}
+	return changes
+}
+
+// scaleChanges reports a change of the desired replicas of a workload
+func scaleChanges(kind, namespace, name string, old, updated *int32) []Change {
+	before, after := int32(1), int32(1)
+	if old != nil {
+		before = *old
+	}
+	if updated != nil {
+		after = *updated
+	}
+	if before == after {
+		return nil
+	}
+	return []Change{{
+		Time:      time.Now(),
+		Severity:  SeverityInfo,
+		Reason:    "Scaled",
+		Namespace: namespace,
+		Kind:      kind,
+		Name:      name,
+		Message:   fmt.Sprintf("scaled from %d to %d replicas", before, after),
+	}}
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// CLILog is the log file written by CLI invocations
+	CLILog = "upid.log"
+
+	// DaemonLog is the log file written by the background daemon
+	DaemonLog = "daemon.log"
+
+	// maxLogSize is the size at which a log file is rotated
+	maxLogSize = 10 << 20
+)
+
+var logFile *os.File
+
+// Setup configures the default structured logger to append JSON lines to file.
+// The file is rotated once it grows past maxLogSize, keeping one old copy.
+func Setup(level, file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return fmt.Errorf("failed to create log directory: %v", err)
+	}
+	rotate(file)
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = f
+
+	slog.SetDefault(New(f, level, "cli"))
+	return nil
+}
+
+// New creates a JSON logger writing to w at the given level. The source is
+// recorded on every entry so CLI and daemon logs can be told apart.
+func New(w io.Writer, level, source string) *slog.Logger {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: ParseLevel(level)})
+	return slog.New(handler).With("source", source)
+}
+
+// Close flushes and closes the log file
+func Close() {
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+}
+
+// ParseLevel converts a UPID log level name to a slog level
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		// info and verbose
+		return slog.LevelInfo
+	}
+}
+
+// rotate moves file aside once it exceeds maxLogSize
+func rotate(file string) {
+	info, err := os.Stat(file)
+	if err != nil || info.Size() < maxLogSize {
+		return
+	}
+	_ = os.Rename(file, file+".1")
+}
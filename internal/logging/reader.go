@@ -0,0 +1,187 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry is a single structured log record
+type Entry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"msg"`
+	Source  string                 `json:"source"`
+	Fields  map[string]interface{} `json:"-"`
+}
+
+// Query selects log entries
+type Query struct {
+	// MinLevel drops entries below this level
+	MinLevel slog.Level
+	// Since drops entries older than this time (zero keeps everything)
+	Since time.Time
+	// Text keeps only entries whose message or fields contain it
+	Text string
+	// Sources restricts entries to these log files (e.g. CLILog, DaemonLog)
+	Sources []string
+}
+
+// Matches reports whether the entry satisfies the query
+func (q Query) Matches(e Entry) bool {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(e.Level)); err == nil && level < q.MinLevel {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if q.Text != "" && !strings.Contains(strings.ToLower(e.String()), strings.ToLower(q.Text)) {
+		return false
+	}
+	return true
+}
+
+// String formats the entry for display
+func (e Entry) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s [%s] %s", e.Time.Format(time.RFC3339), e.Level, e.Source, e.Message)
+
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, e.Fields[k])
+	}
+	return b.String()
+}
+
+// parseEntry decodes one JSON log line
+func parseEntry(line []byte) (Entry, bool) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return Entry{}, false
+	}
+
+	var e Entry
+	if err := json.Unmarshal(line, &e); err != nil {
+		return Entry{}, false
+	}
+	for _, k := range []string{"time", "level", "msg", "source"} {
+		delete(raw, k)
+	}
+	e.Fields = raw
+	return e, true
+}
+
+// Read returns all entries in dir matching the query, oldest first
+func Read(dir string, q Query) ([]Entry, error) {
+	var entries []Entry
+	for _, source := range q.Sources {
+		base := filepath.Join(dir, source)
+		// Rotated file first so entries stay in order
+		for _, file := range []string{base + ".1", base} {
+			f, err := os.Open(file)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to open log file: %v", err)
+			}
+			scanner := bufio.NewScanner(f)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				if e, ok := parseEntry(scanner.Bytes()); ok && q.Matches(e) {
+					entries = append(entries, e)
+				}
+			}
+			f.Close()
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// Follow waits for new entries appended to the log files in dir and calls fn
+// for each one matching the query, until ctx is cancelled. Rotated or
+// truncated files are reopened from the start.
+func Follow(ctx context.Context, dir string, q Query, fn func(Entry)) error {
+	offsets := make(map[string]int64)
+	for _, source := range q.Sources {
+		file := filepath.Join(dir, source)
+		if info, err := os.Stat(file); err == nil {
+			offsets[file] = info.Size()
+		}
+	}
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		for _, source := range q.Sources {
+			file := filepath.Join(dir, source)
+			offset, err := readFrom(file, offsets[file], q, fn)
+			if err != nil {
+				return err
+			}
+			offsets[file] = offset
+		}
+	}
+}
+
+// readFrom emits matching entries appended to file after offset and returns
+// the new offset
+func readFrom(file string, offset int64, q Query, fn func(Entry)) (int64, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return offset, fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return offset, err
+	}
+	if info.Size() < offset {
+		// File was rotated or truncated
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// Leave partial lines for the next poll
+			return offset, nil
+		}
+		offset += int64(len(line))
+		if e, ok := parseEntry(line); ok && q.Matches(e) {
+			fn(e)
+		}
+	}
+}
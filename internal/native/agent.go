@@ -0,0 +1,448 @@
+package native
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"gopkg.in/yaml.v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Targets the agent pushes its metrics to
+const (
+	// AgentTargetEnterprise pushes to the UPID API, authenticated with an
+	// API key
+	AgentTargetEnterprise = "enterprise"
+	// AgentTargetLocal keeps the metrics in the data volume of the agent,
+	// where in-cluster tools can read them
+	AgentTargetLocal = "local"
+)
+
+// AgentTargets lists the valid push targets
+var AgentTargets = []string{AgentTargetEnterprise, AgentTargetLocal}
+
+// AgentImage is the image of the collector, tagged with the CLI version. It
+// also contains the CLI, which runs the policy controller.
+const AgentImage = "ghcr.io/kubilitics/upid-agent"
+
+// agentName names all objects of the agent
+const agentName = "upid-agent"
+
+// Paths and keys inside the agent pod
+const (
+	agentConfigDir  = "/etc/upid-agent"
+	agentConfigFile = "agent.yaml"
+	agentDataDir    = "/var/lib/upid-agent"
+	agentAPIKeyKey  = "api-key"
+	// agentConfigHash restarts the agent when its configuration changes
+	agentConfigHash = "upid.io/config-hash"
+)
+
+// agentLabels select the objects of the agent
+var agentLabels = map[string]interface{}{
+	"app.kubernetes.io/name":       agentName,
+	"app.kubernetes.io/managed-by": kube.FieldManager,
+}
+
+// AgentOptions configures the in-cluster agent
+type AgentOptions struct {
+	// Namespace the agent runs in, created if missing
+	Namespace string
+	Image     string
+	// Cluster is the name the agent reports its metrics under
+	Cluster string
+	// Target is AgentTargetEnterprise or AgentTargetLocal
+	Target string
+	// Endpoint and APIKey authenticate pushes to the enterprise target
+	Endpoint string
+	APIKey   string
+	// Interval between two collections
+	Interval time.Duration
+	// Controller also installs the OptimizationPolicy resource and runs
+	// the controller that enforces policies
+	Controller bool
+}
+
+// agentConfig is the configuration file of the agent
+type agentConfig struct {
+	Cluster  string `yaml:"cluster"`
+	Interval string `yaml:"interval"`
+	Target   string `yaml:"target"`
+	Endpoint string `yaml:"endpoint,omitempty"`
+	DataDir  string `yaml:"data_dir"`
+}
+
+// AgentManifests returns the objects of the agent in the order they are
+// applied: its namespace, RBAC, configuration and Deployment. The API key is
+// stored in a Secret for the enterprise target. With the controller, the
+// OptimizationPolicy resource follows the namespace, and the agent may also
+// scale Deployments and StatefulSets.
+func AgentManifests(opts AgentOptions) []map[string]interface{} {
+	conf, _ := yaml.Marshal(agentConfig{
+		Cluster:  opts.Cluster,
+		Interval: formatInterval(opts.Interval),
+		Target:   opts.Target,
+		Endpoint: opts.Endpoint,
+		DataDir:  agentDataDir,
+	})
+	hash := sha256.New()
+	hash.Write(conf)
+	hash.Write([]byte(opts.APIKey))
+
+	metadata := func(namespaced bool) map[string]interface{} {
+		meta := map[string]interface{}{"name": agentName, "labels": agentLabels}
+		if namespaced {
+			meta["namespace"] = opts.Namespace
+		}
+		return meta
+	}
+
+	// The collector only reads
+	rules := []interface{}{
+		map[string]interface{}{
+			"apiGroups": []interface{}{""},
+			"resources": []interface{}{"nodes", "pods", "namespaces", "events"},
+			"verbs":     []interface{}{"get", "list", "watch"},
+		},
+		map[string]interface{}{
+			"apiGroups": []interface{}{"apps"},
+			"resources": []interface{}{"deployments", "statefulsets", "daemonsets", "replicasets"},
+			"verbs":     []interface{}{"get", "list", "watch"},
+		},
+		map[string]interface{}{
+			"apiGroups": []interface{}{"batch"},
+			"resources": []interface{}{"jobs", "cronjobs"},
+			"verbs":     []interface{}{"get", "list", "watch"},
+		},
+		map[string]interface{}{
+			"apiGroups": []interface{}{"autoscaling"},
+			"resources": []interface{}{"horizontalpodautoscalers"},
+			"verbs":     []interface{}{"get", "list", "watch"},
+		},
+		map[string]interface{}{
+			"apiGroups": []interface{}{"metrics.k8s.io"},
+			"resources": []interface{}{"nodes", "pods"},
+			"verbs":     []interface{}{"get", "list"},
+		},
+	}
+	if opts.Controller {
+		rules = append(rules,
+			map[string]interface{}{
+				"apiGroups": []interface{}{kube.PolicyResource.Group},
+				"resources": []interface{}{kube.PolicyResource.Resource},
+				"verbs":     []interface{}{"get", "list", "watch"},
+			},
+			map[string]interface{}{
+				"apiGroups": []interface{}{kube.PolicyResource.Group},
+				"resources": []interface{}{kube.PolicyResource.Resource + "/status"},
+				"verbs":     []interface{}{"get", "patch", "update"},
+			},
+			map[string]interface{}{
+				"apiGroups": []interface{}{"apps"},
+				"resources": []interface{}{"deployments", "statefulsets"},
+				"verbs":     []interface{}{"patch"},
+			},
+			map[string]interface{}{
+				"apiGroups": []interface{}{"apps"},
+				"resources": []interface{}{"deployments/scale", "statefulsets/scale"},
+				"verbs":     []interface{}{"get", "update"},
+			},
+		)
+	}
+
+	manifests := []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": opts.Namespace, "labels": agentLabels},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "ServiceAccount",
+			"metadata":   metadata(true),
+		},
+		{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRole",
+			"metadata":   metadata(false),
+			"rules":      rules,
+		},
+		{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRoleBinding",
+			"metadata":   metadata(false),
+			"roleRef": map[string]interface{}{
+				"apiGroup": "rbac.authorization.k8s.io",
+				"kind":     "ClusterRole",
+				"name":     agentName,
+			},
+			"subjects": []interface{}{
+				map[string]interface{}{"kind": "ServiceAccount", "name": agentName, "namespace": opts.Namespace},
+			},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   metadata(true),
+			"data":       map[string]interface{}{agentConfigFile: string(conf)},
+		},
+	}
+
+	container := map[string]interface{}{
+		"name":  "agent",
+		"image": opts.Image,
+		"args":  []interface{}{"--config", agentConfigDir + "/" + agentConfigFile},
+		"resources": map[string]interface{}{
+			"requests": map[string]interface{}{"cpu": "50m", "memory": "64Mi"},
+			"limits":   map[string]interface{}{"cpu": "200m", "memory": "256Mi"},
+		},
+		"securityContext": map[string]interface{}{
+			"allowPrivilegeEscalation": false,
+			"readOnlyRootFilesystem":   true,
+			"capabilities":             map[string]interface{}{"drop": []interface{}{"ALL"}},
+		},
+		"volumeMounts": []interface{}{
+			map[string]interface{}{"name": "config", "mountPath": agentConfigDir, "readOnly": true},
+			map[string]interface{}{"name": "data", "mountPath": agentDataDir},
+		},
+	}
+	if opts.Controller {
+		manifests = append(manifests[:1], append([]map[string]interface{}{PolicyCRD()}, manifests[1:]...)...)
+	}
+
+	if opts.Target == AgentTargetEnterprise {
+		manifests = append(manifests, map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   metadata(true),
+			"type":       "Opaque",
+			"stringData": map[string]interface{}{agentAPIKeyKey: opts.APIKey},
+		})
+		container["env"] = []interface{}{
+			map[string]interface{}{
+				"name": "UPID_API_KEY",
+				"valueFrom": map[string]interface{}{
+					"secretKeyRef": map[string]interface{}{"name": agentName, "key": agentAPIKeyKey},
+				},
+			},
+		}
+	}
+
+	containers := []interface{}{container}
+	if opts.Controller {
+		containers = append(containers, map[string]interface{}{
+			"name":    "controller",
+			"image":   opts.Image,
+			"command": []interface{}{"upid", "agent", "controller", "--interval", formatInterval(opts.Interval)},
+			"resources": map[string]interface{}{
+				"requests": map[string]interface{}{"cpu": "50m", "memory": "64Mi"},
+				"limits":   map[string]interface{}{"cpu": "200m", "memory": "256Mi"},
+			},
+			"securityContext": container["securityContext"],
+		})
+	}
+
+	selector := map[string]interface{}{"app.kubernetes.io/name": agentName}
+	manifests = append(manifests, map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   metadata(true),
+		"spec": map[string]interface{}{
+			"replicas": int64(1),
+			"selector": map[string]interface{}{"matchLabels": selector},
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"labels":      agentLabels,
+					"annotations": map[string]interface{}{agentConfigHash: hex.EncodeToString(hash.Sum(nil))[:16]},
+				},
+				"spec": map[string]interface{}{
+					"serviceAccountName": agentName,
+					"securityContext":    map[string]interface{}{"runAsNonRoot": true, "runAsUser": int64(65532)},
+					"containers":         containers,
+					"volumes": []interface{}{
+						map[string]interface{}{"name": "config", "configMap": map[string]interface{}{"name": agentName}},
+						map[string]interface{}{"name": "data", "emptyDir": map[string]interface{}{"sizeLimit": "1Gi"}},
+					},
+				},
+			},
+		},
+	})
+	return manifests
+}
+
+// InstallAgent applies the manifests of the agent. The namespace is only
+// created, and later removed by UninstallAgent, if it does not exist yet.
+func (c *Client) InstallAgent(ctx context.Context, opts AgentOptions) (map[string]interface{}, error) {
+	manifests := AgentManifests(opts)
+	_, err := c.kube.Clientset.CoreV1().Namespaces().Get(ctx, opts.Namespace, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		manifests = manifests[1:]
+	case !apierrors.IsNotFound(err):
+		return nil, kube.APIError(err, "read namespace "+opts.Namespace)
+	}
+
+	if err := c.kube.Apply(ctx, manifests); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"message":   fmt.Sprintf("Installed the UPID agent in namespace %s, pushing to the %s target every %s", opts.Namespace, opts.Target, formatInterval(opts.Interval)),
+		"context":   c.kube.Context,
+		"namespace": opts.Namespace,
+		"image":     opts.Image,
+		"target":    opts.Target,
+		"objects":   objectRefs(manifests),
+	}, nil
+}
+
+// AgentStatus reports whether the agent in namespace is installed and
+// running, and how it is configured
+func (c *Client) AgentStatus(ctx context.Context, namespace string) (map[string]interface{}, error) {
+	result := map[string]interface{}{
+		"context":   c.kube.Context,
+		"namespace": namespace,
+		"installed": false,
+	}
+	deployment, err := c.kube.Clientset.AppsV1().Deployments(namespace).Get(ctx, agentName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		result["status"] = "not installed"
+		result["message"] = fmt.Sprintf("The UPID agent is not installed in namespace %s, install it with 'upid agent install'", namespace)
+		return result, nil
+	}
+	if err != nil {
+		return nil, kube.APIError(err, "read agent deployment")
+	}
+
+	desired := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
+	}
+	status := "running"
+	switch {
+	case deployment.Status.ReadyReplicas == 0:
+		status = "unavailable"
+	case deployment.Status.ReadyReplicas < desired || deployment.Status.UpdatedReplicas < desired:
+		status = "progressing"
+	}
+	result["installed"] = true
+	result["status"] = status
+	result["ready"] = fmt.Sprintf("%d/%d", deployment.Status.ReadyReplicas, desired)
+	result["installed_at"] = deployment.CreationTimestamp.UTC().Format(time.RFC3339)
+	if containers := deployment.Spec.Template.Spec.Containers; len(containers) > 0 {
+		result["image"] = containers[0].Image
+	}
+
+	configMap, err := c.kube.Clientset.CoreV1().ConfigMaps(namespace).Get(ctx, agentName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, kube.APIError(err, "read agent configuration")
+	}
+	if err == nil {
+		var conf agentConfig
+		if err := yaml.Unmarshal([]byte(configMap.Data[agentConfigFile]), &conf); err == nil {
+			result["cluster"] = conf.Cluster
+			result["target"] = conf.Target
+			result["interval"] = conf.Interval
+			if conf.Endpoint != "" {
+				result["endpoint"] = conf.Endpoint
+			}
+		}
+	}
+
+	pods, err := c.kube.Clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "app.kubernetes.io/name=" + agentName,
+	})
+	if err != nil {
+		return nil, kube.APIError(err, "list agent pods")
+	}
+	var restarts int32
+	for _, pod := range pods.Items {
+		for _, container := range pod.Status.ContainerStatuses {
+			restarts += container.RestartCount
+		}
+	}
+	result["restarts"] = restarts
+	if status != "running" {
+		result["warning"] = fmt.Sprintf("the UPID agent is %s (%s pods ready)", status, result["ready"])
+		result["hint"] = fmt.Sprintf("Check its pods with 'kubectl -n %s describe deployment %s'", namespace, agentName)
+	}
+	return result, nil
+}
+
+// UninstallAgent deletes the agent in namespace, and the namespace itself
+// if the agent created it. With dryRun, it only lists what would be deleted.
+func (c *Client) UninstallAgent(ctx context.Context, namespace string, dryRun bool) (map[string]interface{}, error) {
+	// Deleting everything the agent may have created is harmless, objects
+	// that are not found are skipped
+	manifests := AgentManifests(AgentOptions{Namespace: namespace, Target: AgentTargetEnterprise})
+	objects := make([]map[string]interface{}, 0, len(manifests))
+	for i := len(manifests) - 1; i > 0; i-- {
+		objects = append(objects, manifests[i])
+	}
+	ns, err := c.kube.Clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, kube.APIError(err, "read namespace "+namespace)
+	}
+	if err == nil && ns.Labels["app.kubernetes.io/name"] == agentName {
+		objects = append(objects, manifests[0])
+	}
+
+	if dryRun {
+		return map[string]interface{}{
+			"message": fmt.Sprintf("Dry run: would delete the UPID agent in namespace %s", namespace),
+			"context": c.kube.Context,
+			"dry_run": true,
+			"objects": objectRefs(objects),
+		}, nil
+	}
+
+	deleted, err := c.kube.Delete(ctx, objects)
+	if err != nil {
+		return nil, err
+	}
+	message := fmt.Sprintf("Uninstalled the UPID agent from namespace %s", namespace)
+	if len(deleted) == 0 {
+		message = fmt.Sprintf("The UPID agent is not installed in namespace %s", namespace)
+	}
+	return map[string]interface{}{
+		"message": message,
+		"context": c.kube.Context,
+		"dry_run": false,
+		"objects": objectRefs(deleted),
+	}, nil
+}
+
+// formatInterval formats an interval without trailing zero units, e.g. 1m
+// rather than 1m0s
+func formatInterval(interval time.Duration) string {
+	s := interval.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// objectRefs lists the kind, name and namespace of objects
+func objectRefs(objects []map[string]interface{}) []interface{} {
+	refs := make([]interface{}, 0, len(objects))
+	for _, object := range objects {
+		metadata, _ := object["metadata"].(map[string]interface{})
+		ref := map[string]interface{}{
+			"kind": object["kind"],
+			"name": metadata["name"],
+		}
+		if namespace, ok := metadata["namespace"]; ok {
+			ref["namespace"] = namespace
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
@@ -0,0 +1,301 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// Policies for spreading idle capacity and shared namespaces over groups
+const (
+	// IdleProportional spreads them in proportion to the cost of each group
+	IdleProportional = "proportional"
+	// IdleEven spreads them evenly over the groups
+	IdleEven = "even"
+	// IdleSeparate reports them as groups of their own
+	IdleSeparate = "separate"
+)
+
+// Groups that do not come from the dimension
+const (
+	groupUnallocated = "(unallocated)"
+	groupIdle        = "(idle)"
+	groupShared      = "(shared)"
+)
+
+// Dimension is what cost is allocated by: the namespace, the owning
+// workload, or the value of a pod label or annotation
+type Dimension struct {
+	// Kind is namespace, owner, label or annotation
+	Kind string
+	// Key is the label or annotation key
+	Key string
+}
+
+// String formats the dimension as it is given on the command line
+func (d Dimension) String() string {
+	if d.Key != "" {
+		return d.Kind + ":" + d.Key
+	}
+	return d.Kind
+}
+
+// ParseDimension parses a dimension given as namespace, owner, label:<key>
+// or annotation:<key>
+func ParseDimension(value string) (Dimension, error) {
+	kind, key, hasKey := strings.Cut(value, ":")
+	switch {
+	case (kind == "namespace" || kind == "owner") && !hasKey:
+		return Dimension{Kind: kind}, nil
+	case (kind == "label" || kind == "annotation") && key != "":
+		return Dimension{Kind: kind, Key: key}, nil
+	}
+	return Dimension{}, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+		fmt.Sprintf("invalid dimension %q (expected namespace, owner, label:<key> or annotation:<key>)", value))
+}
+
+// ParseIdlePolicy parses a policy for idle and shared cost
+func ParseIdlePolicy(value string) (string, error) {
+	switch value {
+	case IdleProportional, IdleEven, IdleSeparate:
+		return value, nil
+	}
+	return "", clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+		fmt.Sprintf("invalid idle policy %q (expected %s, %s or %s)", value, IdleProportional, IdleEven, IdleSeparate))
+}
+
+// AllocationOptions configure how cluster cost is allocated
+type AllocationOptions struct {
+	// By is the dimension pods are grouped by
+	By Dimension
+	// Idle is the policy for idle capacity and shared namespaces
+	Idle string
+	// SharedNamespaces hold pods that serve the whole cluster, such as
+	// kube-system. Their cost is spread like idle capacity.
+	SharedNamespaces []string
+	// Prices are used to price nodes by their capacity and pods by their
+	// requests
+	Prices ComputePrices
+}
+
+// allocationGroup is the pods and cost of one value of the dimension
+type allocationGroup struct {
+	name     string
+	pods     int
+	requests kube.Resources
+	direct   float64
+	idle     float64
+	shared   float64
+	// workloads are the direct cost of the group by owning workload
+	workloads map[string]*workloadCost
+}
+
+// workloadCost is the pods and direct cost of a workload in a group
+type workloadCost struct {
+	namespace string
+	kind      string
+	name      string
+	pods      int
+	requests  kube.Resources
+	cost      float64
+}
+
+// addWorkload adds the requests and cost of a pod to its workload
+func (g *allocationGroup) addWorkload(pod *kube.Pod, requests kube.Resources, cost float64) {
+	key := pod.Namespace + "/" + pod.Workload.Kind + "/" + pod.Workload.Name
+	if g.workloads == nil {
+		g.workloads = map[string]*workloadCost{}
+	}
+	w := g.workloads[key]
+	if w == nil {
+		w = &workloadCost{namespace: pod.Namespace, kind: pod.Workload.Kind, name: pod.Workload.Name}
+		g.workloads[key] = w
+	}
+	w.pods++
+	w.requests = w.requests.Add(requests)
+	w.cost += cost
+}
+
+// allocation is the cost of the cluster attributed to the values of a
+// dimension, before idle and shared cost is spread over them
+type allocation struct {
+	clusterCost float64
+	// idle is the cost of the capacity that is not requested
+	idle float64
+	// shared holds the pods of the shared namespaces
+	shared *allocationGroup
+	groups []*allocationGroup
+}
+
+// AnalyzeAllocation attributes the cost of the cluster to the values of a
+// dimension. Nodes are priced by their capacity and running pods by their
+// requests, projected to a month; pods without the label or annotation are
+// grouped as unallocated. The capacity that is not requested and the pods
+// of shared namespaces are spread over the groups by the idle policy, so
+// that the groups add up to the cost of the cluster.
+func (c *Client) AnalyzeAllocation(ctx context.Context, opts AllocationOptions) (map[string]interface{}, error) {
+	a, err := c.allocate(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	clusterCost, idle, sharedGroup := a.clusterCost, a.idle, a.shared
+	list := a.spread(opts.Idle)
+
+	total := func(g *allocationGroup) float64 { return g.direct + g.idle + g.shared }
+	sort.Slice(list, func(i, j int) bool {
+		if total(list[i]) != total(list[j]) {
+			return total(list[i]) > total(list[j])
+		}
+		return list[i].name < list[j].name
+	})
+	items := make([]interface{}, len(list))
+	var unallocated float64
+	for i, g := range list {
+		if g.name == groupUnallocated {
+			unallocated = total(g)
+		}
+		items[i] = map[string]interface{}{
+			"name":            g.name,
+			"pods":            g.pods,
+			"cpu_requests":    round(g.requests.CPU, 3),
+			"memory_requests": formatMemory(g.requests.Memory),
+			"direct_cost":     round(g.direct, 2),
+			"shared_cost":     round(g.shared, 2),
+			"idle_cost":       round(g.idle, 2),
+			"monthly_cost":    round(total(g), 2),
+			"cost_percent":    percent(total(g), clusterCost),
+		}
+	}
+
+	spread := "spread " + opts.Idle
+	if opts.Idle == IdleSeparate {
+		spread = "reported separately"
+	}
+	result := map[string]interface{}{
+		"message": fmt.Sprintf("%.2f per month of cluster cost allocated by %s to %d groups, with %.2f idle and %.2f shared %s",
+			clusterCost, opts.By, len(a.groups), idle, sharedGroup.direct, spread),
+		"context":           c.kube.Context,
+		"by":                opts.By.String(),
+		"idle_policy":       opts.Idle,
+		"shared_namespaces": opts.SharedNamespaces,
+		"monthly_cost":      round(clusterCost, 2),
+		"idle_cost":         round(idle, 2),
+		"shared_cost":       round(sharedGroup.direct, 2),
+		"unallocated_cost":  round(unallocated, 2),
+		"groups":            items,
+	}
+	if unallocated > 0 && (opts.By.Kind == "label" || opts.By.Kind == "annotation") {
+		result["warning"] = fmt.Sprintf("%.2f per month (%v%%) is unallocated because its pods have no %s %q",
+			unallocated, percent(unallocated, clusterCost), opts.By.Kind, opts.By.Key)
+	}
+	return result, nil
+}
+
+// allocate prices the nodes of the cluster by their capacity and its
+// running pods by their requests, and groups the pods by the dimension
+func (c *Client) allocate(ctx context.Context, opts AllocationOptions) (*allocation, error) {
+	snapshot, err := c.kube.Collect(ctx, kube.CollectOptions{RunningOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	a := &allocation{shared: &allocationGroup{name: groupShared}}
+	for i := range snapshot.Nodes {
+		a.clusterCost += nodeCost(snapshot.Nodes[i].Capacity, opts.Prices)
+	}
+	shared := map[string]bool{}
+	for _, ns := range opts.SharedNamespaces {
+		shared[ns] = true
+	}
+
+	groups := map[string]*allocationGroup{}
+	var requested float64
+	for i := range snapshot.Pods {
+		pod := &snapshot.Pods[i]
+		if pod.Node == "" {
+			continue
+		}
+		g := a.shared
+		if !shared[pod.Namespace] {
+			name := allocationKey(pod, opts.By)
+			if g = groups[name]; g == nil {
+				g = &allocationGroup{name: name}
+				groups[name] = g
+				a.groups = append(a.groups, g)
+			}
+		}
+		requests := pod.Requests()
+		cost := requestCost(requests, 1, opts.Prices)
+		g.pods++
+		g.requests = g.requests.Add(requests)
+		g.direct += cost
+		g.addWorkload(pod, requests, cost)
+		requested += cost
+	}
+	// Requests over capacity, e.g. on nodes missing from the list, leave
+	// nothing idle
+	if a.idle = a.clusterCost - requested; a.idle < 0 {
+		a.idle = 0
+	}
+	return a, nil
+}
+
+// spread spreads the idle and shared cost over the groups by the idle
+// policy, and returns the groups with those of idle and shared cost if
+// they are reported separately
+func (a *allocation) spread(policy string) []*allocationGroup {
+	list := append([]*allocationGroup(nil), a.groups...)
+	var direct float64
+	for _, g := range list {
+		direct += g.direct
+	}
+	switch {
+	case policy == IdleSeparate || len(list) == 0:
+		if a.shared.pods > 0 {
+			list = append(list, a.shared)
+		}
+		if a.idle > 0 {
+			list = append(list, &allocationGroup{name: groupIdle, idle: a.idle})
+		}
+	case policy == IdleEven:
+		for _, g := range list {
+			g.idle = a.idle / float64(len(list))
+			g.shared = a.shared.direct / float64(len(list))
+		}
+	default:
+		for _, g := range list {
+			share := 1 / float64(len(list))
+			if direct > 0 {
+				share = g.direct / direct
+			}
+			g.idle = a.idle * share
+			g.shared = a.shared.direct * share
+		}
+	}
+	return list
+}
+
+// allocationKey is the group of a pod in a dimension
+func allocationKey(pod *kube.Pod, by Dimension) string {
+	var value string
+	switch by.Kind {
+	case "namespace":
+		value = pod.Namespace
+	case "owner":
+		value = pod.Namespace + "/" + pod.Workload.Kind + "/" + pod.Workload.Name
+	case "label":
+		value = pod.Labels[by.Key]
+	case "annotation":
+		if pod.Object != nil {
+			value = pod.Object.Annotations[by.Key]
+		}
+	}
+	if value == "" {
+		return groupUnallocated
+	}
+	return value
+}
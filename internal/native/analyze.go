@@ -0,0 +1,333 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/prometheus"
+)
+
+// idleThreshold is the share of its CPU request below which a pod is idle
+const idleThreshold = 0.05
+
+// minIdleCPU is the usage below which a pod without a CPU request is idle
+const minIdleCPU = 0.005
+
+// maxIdleTraffic is the network traffic in bytes per second above which a
+// pod is not idle however little CPU it uses, such as a proxy or a stream
+// consumer; probes and metric scrapes stay well below it
+const maxIdleTraffic = 20 * 1024
+
+// podUsage relates the usage of a running pod to its requests
+type podUsage struct {
+	pod     *kube.Pod
+	used    kube.Resources
+	request kube.Resources
+	limit   kube.Resources
+	// history is the usage over the analyzed time range, nil for
+	// point-in-time usage
+	history *prometheus.PodUsage
+}
+
+// peakCPU is the CPU usage idleness is judged by: the 95th percentile over
+// the time range, or the current usage
+func (p *podUsage) peakCPU() float64 {
+	if p.history != nil {
+		return p.history.CPU.P95
+	}
+	return p.used.CPU
+}
+
+// peakTraffic is the 95th percentile of the bytes per second a pod received
+// and sent over the time range, 0 without usage history
+func (p *podUsage) peakTraffic() float64 {
+	if p.history == nil {
+		return 0
+	}
+	return p.history.NetworkReceive.P95 + p.history.NetworkTransmit.P95
+}
+
+// runningPods returns the running pods in namespace (all if empty) with
+// their usage, and the snapshot of the cluster they were read from. The
+// usage is the average over window from the datasource if both are set,
+// else the current usage from metrics-server. Without metrics-server it
+// fails unless usage is optional, in which case all pods are returned
+// without usage.
+func (c *Client) runningPods(ctx context.Context, namespace string, window time.Duration, usageOptional bool) ([]podUsage, *kube.Snapshot, error) {
+	useHistory := c.history != nil && window > 0
+	snapshot, err := c.kube.Collect(ctx, kube.CollectOptions{Namespace: namespace, RunningOnly: true, Metrics: !useHistory})
+	if err != nil {
+		return nil, nil, err
+	}
+	var history map[string]*prometheus.PodUsage
+	if useHistory {
+		if history, err = c.history.PodHistory(ctx, namespace, window); err != nil {
+			return nil, nil, err
+		}
+	} else if err := snapshot.MetricsError(); err != nil && !usageOptional {
+		return nil, nil, err
+	}
+
+	result := make([]podUsage, 0, len(snapshot.Pods))
+	for i := range snapshot.Pods {
+		pod := &snapshot.Pods[i]
+		entry := podUsage{pod: pod, request: pod.Requests(), limit: pod.Limits()}
+		switch {
+		case useHistory:
+			h, ok := history[pod.Namespace+"/"+pod.Name]
+			if !ok {
+				// Not scraped by the datasource
+				continue
+			}
+			entry.history = h
+			entry.used = kube.Resources{CPU: h.CPU.Avg, Memory: h.Memory.Avg}
+		case pod.Usage != nil:
+			entry.used = *pod.Usage
+		case snapshot.Metrics.Available:
+			// Started too recently to have been scraped
+			continue
+		}
+		result = append(result, entry)
+	}
+	return result, snapshot, nil
+}
+
+// AnalyzeResources reports the CPU, memory and network usage of running pods
+// against their requests. resourceType is "all", "cpu", "memory" or
+// "network". With a datasource and a window the usage over the window is
+// summarized, else current usage is read from metrics-server; without
+// metrics-server only requests and limits are reported.
+func (c *Client) AnalyzeResources(ctx context.Context, namespace, resourceType string, window time.Duration) (map[string]interface{}, error) {
+	showCPU := resourceType == "all" || resourceType == "cpu"
+	showMemory := resourceType == "all" || resourceType == "memory"
+	showNetwork := resourceType == "all" || resourceType == "network"
+	if !showCPU && !showMemory && !showNetwork {
+		return nil, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+			fmt.Sprintf("unsupported resource type %q (expected all, cpu, memory or network)", resourceType))
+	}
+	useHistory := c.history != nil && window > 0
+	if resourceType == "network" && !useHistory {
+		return nil, clierr.New(clierr.CategoryUsage, "DATASOURCE_NOT_CONFIGURED", "network usage is only known from a datasource").
+			WithHint("Configure one with 'upid config datasource set --url <url>'")
+	}
+
+	pods, snapshot, err := c.runningPods(ctx, namespace, window, true)
+	if err != nil {
+		return nil, err
+	}
+	if useHistory {
+		return c.analyzeHistory(pods, window, showCPU, showMemory, showNetwork), nil
+	}
+	withUsage := snapshot.Metrics.Available
+
+	var used, requested, limited kube.Resources
+	items := make([]interface{}, 0, len(pods))
+	for _, p := range pods {
+		used = used.Add(p.used)
+		requested = requested.Add(p.request)
+		limited = limited.Add(p.limit)
+
+		item := map[string]interface{}{
+			"name":      p.pod.Name,
+			"namespace": p.pod.Namespace,
+		}
+		if showCPU {
+			item["cpu_request"] = round(p.request.CPU, 3)
+			item["cpu_limit"] = round(p.limit.CPU, 3)
+			if withUsage {
+				item["cpu_usage"] = round(p.used.CPU, 3)
+				item["cpu_usage_percent"] = percent(p.used.CPU, p.request.CPU)
+			}
+		}
+		if showMemory {
+			item["memory_request_mib"] = round(p.request.Memory/(1<<20), 1)
+			item["memory_limit_mib"] = round(p.limit.Memory/(1<<20), 1)
+			if withUsage {
+				item["memory_usage_mib"] = round(p.used.Memory/(1<<20), 1)
+				item["memory_usage_percent"] = percent(p.used.Memory, p.request.Memory)
+			}
+		}
+		items = append(items, item)
+	}
+
+	result := map[string]interface{}{
+		"context":           c.kube.Context,
+		"metrics_available": withUsage,
+		"pods":              items,
+	}
+	if !withUsage {
+		metricsErr := clierr.From(snapshot.MetricsError())
+		result["message"] = fmt.Sprintf("Requests of %d running pods: %.2f cores, %.2f GiB (usage unknown, %s)",
+			len(pods), requested.CPU, requested.Memory/(1<<30), metricsErr.Message)
+		result["warning"] = metricsErr.Message
+		if metricsErr.Hint != "" {
+			result["hint"] = metricsErr.Hint
+		}
+		return result, nil
+	}
+
+	result["message"] = fmt.Sprintf("Current usage of %d running pods: %.2f cores, %.2f GiB (point-in-time from metrics-server)",
+		len(pods), used.CPU, used.Memory/(1<<30))
+	result["collected_at"] = snapshot.Metrics.Timestamp.Format(time.RFC3339)
+	if showCPU {
+		result["cpu_usage_percent"] = percent(used.CPU, requested.CPU)
+	}
+	if showMemory {
+		result["memory_usage_percent"] = percent(used.Memory, requested.Memory)
+	}
+	return result, nil
+}
+
+// analyzeHistory summarizes the usage of pods over a time range
+func (c *Client) analyzeHistory(pods []podUsage, window time.Duration, showCPU, showMemory, showNetwork bool) map[string]interface{} {
+	var used, requested kube.Resources
+	items := make([]interface{}, 0, len(pods))
+	for _, p := range pods {
+		used = used.Add(p.used)
+		requested = requested.Add(p.request)
+
+		h := p.history
+		item := map[string]interface{}{
+			"name":           p.pod.Name,
+			"namespace":      p.pod.Namespace,
+			"observed_hours": round(h.Observed.Hours(), 1),
+		}
+		if showCPU {
+			item["cpu_request"] = round(p.request.CPU, 3)
+			item["cpu_avg"] = round(h.CPU.Avg, 3)
+			item["cpu_p95"] = round(h.CPU.P95, 3)
+			item["cpu_max"] = round(h.CPU.Max, 3)
+			item["cpu_p95_percent"] = percent(h.CPU.P95, p.request.CPU)
+		}
+		if showMemory {
+			item["memory_request_mib"] = round(p.request.Memory/(1<<20), 1)
+			item["memory_avg_mib"] = round(h.Memory.Avg/(1<<20), 1)
+			item["memory_p95_mib"] = round(h.Memory.P95/(1<<20), 1)
+			item["memory_max_mib"] = round(h.Memory.Max/(1<<20), 1)
+			item["memory_p95_percent"] = percent(h.Memory.P95, p.request.Memory)
+		}
+		if showNetwork {
+			item["network_receive_kib_s"] = round(h.NetworkReceive.Avg/1024, 1)
+			item["network_transmit_kib_s"] = round(h.NetworkTransmit.Avg/1024, 1)
+			item["network_peak_kib_s"] = round(math.Max(h.NetworkReceive.Max, h.NetworkTransmit.Max)/1024, 1)
+		}
+		items = append(items, item)
+	}
+
+	result := map[string]interface{}{
+		"message": fmt.Sprintf("Average usage of %d running pods over %s: %.2f cores, %.2f GiB (from %s)",
+			len(pods), formatWindow(window), used.CPU, used.Memory/(1<<30), c.history.URL()),
+		"context":    c.kube.Context,
+		"time_range": formatWindow(window),
+		"datasource": c.history.URL(),
+		"pods":       items,
+	}
+	if showCPU {
+		result["cpu_usage_percent"] = percent(used.CPU, requested.CPU)
+	}
+	if showMemory {
+		result["memory_usage_percent"] = percent(used.Memory, requested.Memory)
+	}
+	return result
+}
+
+// idlePod is a pod whose CPU usage is a negligible share of its request
+type idlePod struct {
+	podUsage
+	confidence float64
+}
+
+// idlePods returns the idle running pods in namespace with at least the
+// given confidence, and the snapshot of the cluster they were read from.
+// With a datasource and a window a pod is idle if the 95th percentile of its
+// usage over the window is and its network traffic is low, and pods
+// observed for only part of the window get a lower confidence.
+func (c *Client) idlePods(ctx context.Context, namespace string, minConfidence float64, window time.Duration) ([]idlePod, *kube.Snapshot, error) {
+	pods, snapshot, err := c.runningPods(ctx, namespace, window, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var idle []idlePod
+	for _, p := range pods {
+		// The further below the threshold, the more certain; a pod using
+		// nothing at all gets full confidence
+		cpu := p.peakCPU()
+		ratio := cpu / minIdleCPU
+		if p.request.CPU > 0 {
+			ratio = cpu / (idleThreshold * p.request.CPU)
+		}
+		if ratio >= 1 || p.peakTraffic() > maxIdleTraffic {
+			continue
+		}
+		confidence := 0.5 + 0.5*(1-ratio)
+		if p.history != nil {
+			confidence *= math.Min(1, p.history.Observed.Seconds()/window.Seconds())
+		}
+		confidence = round(confidence, 2)
+		if confidence < minConfidence {
+			continue
+		}
+		idle = append(idle, idlePod{podUsage: p, confidence: confidence})
+	}
+	return idle, snapshot, nil
+}
+
+// FindIdle lists running pods whose CPU usage is below 5% of their request,
+// with a confidence derived from how far below it they are. The usage is
+// the 95th percentile over window with a datasource, else the current usage.
+// With a datasource, pods with network traffic are not idle.
+func (c *Client) FindIdle(ctx context.Context, namespace string, minConfidence float64, window time.Duration) (map[string]interface{}, error) {
+	idle, _, err := c.idlePods(ctx, namespace, minConfidence, window)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]interface{}, 0, len(idle))
+	for _, p := range idle {
+		item := map[string]interface{}{
+			"name":                 p.pod.Name,
+			"namespace":            p.pod.Namespace,
+			"workload_type":        p.pod.Workload.Kind,
+			"workload":             p.pod.Workload.Name,
+			"cpu_usage_percent":    percent(p.peakCPU(), p.request.CPU),
+			"memory_usage_percent": percent(p.used.Memory, p.request.Memory),
+			"confidence":           p.confidence,
+			"recommendation":       "scale to zero",
+			"risk_assessment":      "based on current usage only, verify before scaling",
+		}
+		if p.history != nil {
+			item["idle_duration_hours"] = round(p.history.Observed.Hours(), 1)
+			item["network_kib_s"] = round(p.peakTraffic()/1024, 1)
+			item["risk_assessment"] = "95th percentile of usage below 5% of request and little network traffic over the time range"
+		}
+		items = append(items, item)
+	}
+	source := "point-in-time usage from metrics-server"
+	if c.history != nil && window > 0 {
+		source = fmt.Sprintf("95th percentile over %s from %s", formatWindow(window), c.history.URL())
+	}
+	return map[string]interface{}{
+		"message":   fmt.Sprintf("Found %d idle pods (%s)", len(items), source),
+		"context":   c.kube.Context,
+		"idle_pods": items,
+	}, nil
+}
+
+// formatWindow formats a time range in days or hours
+func formatWindow(window time.Duration) string {
+	if window >= 24*time.Hour && window%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", int(window.Hours()/24))
+	}
+	return window.String()
+}
+
+// round rounds v to the given number of decimals
+func round(v float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(v*scale) / scale
+}
@@ -0,0 +1,193 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// RequestApproval checks a change to a protected cluster against the changes
+// kept in store. An approved change matching request, with the same command,
+// namespace, override and workloads, is returned, to be applied and
+// completed with CompleteApproval. Otherwise the change is recorded as
+// pending for ttl, unless it already is, and a result reporting it is
+// returned instead. Changes past their expiry are marked expired.
+func (c *Client) RequestApproval(ctx context.Context, store string, request *kube.Approval, ttl time.Duration) (*kube.Approval, map[string]interface{}, error) {
+	user, err := c.kube.User(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	approvals, err := c.kube.Approvals(ctx, store)
+	if err != nil {
+		return nil, nil, err
+	}
+	kube.SortChanges(request.Changes)
+
+	now := time.Now().UTC()
+	var pending *kube.Approval
+	for _, a := range approvals {
+		if !a.Matches(request) {
+			continue
+		}
+		if a.Expired(now) {
+			a.Status = kube.ApprovalExpired
+			if err := c.kube.UpdateApproval(ctx, store, a); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		switch a.Status {
+		case kube.ApprovalApproved:
+			return a, nil, nil
+		case kube.ApprovalPending:
+			pending = a
+		}
+	}
+
+	var message string
+	if pending == nil {
+		pending = &kube.Approval{
+			Command:     request.Command,
+			Namespace:   request.Namespace,
+			Override:    request.Override,
+			Changes:     request.Changes,
+			Status:      kube.ApprovalPending,
+			RequestedBy: user,
+			RequestedAt: now.Format(time.RFC3339),
+			ExpiresAt:   now.Add(ttl).Format(time.RFC3339),
+		}
+		if err := c.kube.CreateApproval(ctx, store, pending); err != nil {
+			return nil, nil, err
+		}
+		message = fmt.Sprintf("Cluster %s requires approval: recorded change %s for another user to approve", c.kube.Context, pending.ID)
+	} else {
+		message = fmt.Sprintf("Change %s is still awaiting approval, requested by %s at %s", pending.ID, pending.RequestedBy, pending.RequestedAt)
+	}
+	return nil, map[string]interface{}{
+		"approvals": []interface{}{approvalItem(pending, now)},
+		"message":   message,
+		"warning":   "the change is not applied until it is approved",
+		"hint": fmt.Sprintf("Once another user ran 'upid optimize approvals approve %s', run 'upid %s' again before %s to apply it",
+			pending.ID, request.Command, pending.ExpiresAt),
+	}, nil
+}
+
+// CompleteApproval records that an approved change was applied, so it is
+// not applied again
+func (c *Client) CompleteApproval(ctx context.Context, store string, approval *kube.Approval) error {
+	approval.Status = kube.ApprovalApplied
+	approval.AppliedAt = time.Now().UTC().Format(time.RFC3339)
+	return c.kube.UpdateApproval(ctx, store, approval)
+}
+
+// ListApprovals lists the changes kept in store, those with a status if not
+// empty
+func (c *Client) ListApprovals(ctx context.Context, store, status string) (map[string]interface{}, error) {
+	approvals, err := c.kube.Approvals(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	items := []interface{}{}
+	pending := 0
+	now := time.Now()
+	for _, a := range approvals {
+		current := approvalStatus(a, now)
+		if current == kube.ApprovalPending {
+			pending++
+		}
+		if status == "" || current == status {
+			items = append(items, approvalItem(a, now))
+		}
+	}
+	return map[string]interface{}{
+		"cluster":   c.kube.Context,
+		"approvals": items,
+		"message":   fmt.Sprintf("%d changes, %d awaiting approval, in %s", len(items), pending, c.kube.Context),
+	}, nil
+}
+
+// ReviewApproval approves or rejects a pending change. Changes cannot be
+// reviewed by the user who requested them, nor after they expired. An
+// approved change must be applied within ttl.
+func (c *Client) ReviewApproval(ctx context.Context, store, id string, approve bool, reason string, ttl time.Duration) (map[string]interface{}, error) {
+	user, err := c.kube.User(ctx)
+	if err != nil {
+		return nil, err
+	}
+	approval, err := c.kube.GetApproval(ctx, store, id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if status := approvalStatus(approval, now); status != kube.ApprovalPending {
+		return nil, clierr.New(clierr.CategoryUsage, "APPROVAL_NOT_PENDING",
+			fmt.Sprintf("change %s was already %s", id, status))
+	}
+	if approval.RequestedBy == user {
+		return nil, clierr.New(clierr.CategoryAuth, "APPROVAL_SELF_REVIEW",
+			fmt.Sprintf("change %s was requested by %s and must be reviewed by another user", id, user))
+	}
+
+	approval.Status = kube.ApprovalRejected
+	if approve {
+		approval.Status = kube.ApprovalApproved
+	}
+	approval.ReviewedBy = user
+	approval.ReviewedAt = now.Format(time.RFC3339)
+	approval.Reason = reason
+	if approve {
+		approval.ExpiresAt = now.Add(ttl).Format(time.RFC3339)
+	}
+	if err := c.kube.UpdateApproval(ctx, store, approval); err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{
+		"approvals": []interface{}{approvalItem(approval, now)},
+		"message":   fmt.Sprintf("Change %s (%s) was %s", id, approval.Command, approval.Status),
+	}
+	if approve {
+		result["hint"] = fmt.Sprintf("%s can now apply it by running 'upid %s' again before %s", approval.RequestedBy, approval.Command, approval.ExpiresAt)
+	}
+	return result, nil
+}
+
+// approvalStatus returns the status of a change at now, expired once a
+// pending or approved change passed its expiry
+func approvalStatus(a *kube.Approval, now time.Time) string {
+	if a.Expired(now) {
+		return kube.ApprovalExpired
+	}
+	return a.Status
+}
+
+// approvalItem describes a change in results
+func approvalItem(a *kube.Approval, now time.Time) map[string]interface{} {
+	changes := make([]string, 0, len(a.Changes))
+	for _, change := range a.Changes {
+		changes = append(changes, change.String())
+	}
+	item := map[string]interface{}{
+		"id":           a.ID,
+		"command":      "upid " + a.Command,
+		"namespace":    a.Namespace,
+		"status":       approvalStatus(a, now),
+		"changes":      strings.Join(changes, ", "),
+		"override":     a.Override,
+		"expires_at":   a.ExpiresAt,
+		"requested_by": a.RequestedBy,
+		"requested_at": a.RequestedAt,
+		"reviewed_by":  a.ReviewedBy,
+		"reviewed_at":  a.ReviewedAt,
+		"reason":       a.Reason,
+		"applied_at":   a.AppliedAt,
+	}
+	if a.Namespace == "" {
+		item["namespace"] = "(cluster)"
+	}
+	return item
+}
@@ -0,0 +1,404 @@
+package native
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Autoscaler statuses
+const (
+	autoscalerOK            = "ok"
+	autoscalerNeverScaling  = "never-scaling"
+	autoscalerMaxed         = "maxed"
+	autoscalerNotApplied    = "not-applied"
+	autoscalerConflict      = "conflict"
+	autoscalerMisconfigured = "misconfigured"
+)
+
+// Target CPU utilization outside of this range either leaves no headroom for
+// spikes or keeps replicas mostly idle; it is moved to the nearest
+// recommended value
+const (
+	minTargetUtilization     = 40
+	maxTargetUtilization     = 90
+	lowTargetRecommendation  = 60
+	highTargetRecommendation = 80
+	// defaultTargetUtilization is the CPU target of HPAs without one
+	defaultTargetUtilization = 80
+)
+
+// maxReplicasHeadroom is the margin of recommended maxReplicas over the
+// replicas needed at current load
+const maxReplicasHeadroom = 1.5
+
+// vpaDeviation is the relative difference between the VPA recommendation and
+// requests above which an unapplied recommendation is reported
+const vpaDeviation = 0.3
+
+// autoscaler is the analysis of one HPA or VPA
+type autoscaler struct {
+	kind      string
+	namespace string
+	name      string
+	target    kube.WorkloadRef
+	status    string
+	finding   string
+	advice    string
+	hpa       *hpaConfig
+	vpa       *kube.VPA
+}
+
+// hpaConfig is the current and recommended configuration of an HPA
+type hpaConfig struct {
+	replicas           int32
+	minReplicas        int32
+	maxReplicas        int32
+	targetUtilization  int32
+	currentUtilization *int32
+	lastScale          *time.Time
+	// Recommended values, equal to the current ones if nothing changes
+	recommendedMin    int32
+	recommendedMax    int32
+	recommendedTarget int32
+	object            *autoscalingv2.HorizontalPodAutoscaler
+}
+
+// AnalyzeAutoscaling inspects the HPAs and VPAs in namespace (all if empty)
+// for autoscalers that never scale, are pinned at maxReplicas, are
+// misconfigured or fight each other, and recommends HPA settings. HPAs that
+// have not scaled within window are considered never scaling. It also
+// returns manifests of the HPAs with changed recommendations.
+func (c *Client) AnalyzeAutoscaling(ctx context.Context, namespace string, window time.Duration) (map[string]interface{}, []map[string]interface{}, error) {
+	hpas, err := c.kube.HPAs(ctx, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	vpas, vpaInstalled, err := c.kube.VPAs(ctx, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var analyzed []*autoscaler
+	byTarget := map[string]*autoscaler{}
+	for i := range hpas {
+		a := analyzeHPA(&hpas[i], window)
+		analyzed = append(analyzed, a)
+		byTarget[a.namespace+"/"+a.target.Kind+"/"+a.target.Name] = a
+	}
+	for i := range vpas {
+		a := c.analyzeVPA(ctx, &vpas[i])
+		if hpa, ok := byTarget[a.namespace+"/"+a.target.Kind+"/"+a.target.Name]; ok {
+			markConflict(hpa, a)
+		}
+		analyzed = append(analyzed, a)
+	}
+	sort.Slice(analyzed, func(i, j int) bool {
+		a, b := analyzed[i], analyzed[j]
+		if a.namespace != b.namespace {
+			return a.namespace < b.namespace
+		}
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		return a.kind < b.kind
+	})
+
+	items := make([]interface{}, 0, len(analyzed))
+	var manifests []map[string]interface{}
+	attention := 0
+	for _, a := range analyzed {
+		items = append(items, a.item())
+		if a.status != autoscalerOK {
+			attention++
+		}
+		if a.hpa != nil && a.hpa.changed() {
+			manifests = append(manifests, a.hpa.manifest())
+		}
+	}
+
+	scope := "all namespaces"
+	if namespace != "" {
+		scope = "namespace " + namespace
+	}
+	result := map[string]interface{}{
+		"message":       fmt.Sprintf("Analyzed %d autoscalers in %s, %d need attention", len(items), scope, attention),
+		"context":       c.kube.Context,
+		"autoscalers":   items,
+		"vpa_installed": vpaInstalled,
+	}
+	return result, manifests, nil
+}
+
+// analyzeHPA checks the configuration and recent behavior of an HPA
+func analyzeHPA(hpa *autoscalingv2.HorizontalPodAutoscaler, window time.Duration) *autoscaler {
+	config := &hpaConfig{
+		replicas:          hpa.Status.CurrentReplicas,
+		minReplicas:       1,
+		maxReplicas:       hpa.Spec.MaxReplicas,
+		targetUtilization: defaultTargetUtilization,
+		object:            hpa,
+	}
+	if hpa.Spec.MinReplicas != nil {
+		config.minReplicas = *hpa.Spec.MinReplicas
+	}
+	for _, metric := range hpa.Spec.Metrics {
+		if metric.Type == autoscalingv2.ResourceMetricSourceType && metric.Resource != nil &&
+			metric.Resource.Name == corev1.ResourceCPU && metric.Resource.Target.AverageUtilization != nil {
+			config.targetUtilization = *metric.Resource.Target.AverageUtilization
+		}
+	}
+	for _, metric := range hpa.Status.CurrentMetrics {
+		if metric.Type == autoscalingv2.ResourceMetricSourceType && metric.Resource != nil &&
+			metric.Resource.Name == corev1.ResourceCPU && metric.Resource.Current.AverageUtilization != nil {
+			config.currentUtilization = metric.Resource.Current.AverageUtilization
+		}
+	}
+	if hpa.Status.LastScaleTime != nil {
+		config.lastScale = &hpa.Status.LastScaleTime.Time
+	}
+	config.recommendedMin, config.recommendedMax = config.minReplicas, config.maxReplicas
+	config.recommendedTarget = config.targetUtilization
+
+	a := &autoscaler{
+		kind:      "HPA",
+		namespace: hpa.Namespace,
+		name:      hpa.Name,
+		target:    kube.WorkloadRef{Kind: hpa.Spec.ScaleTargetRef.Kind, Name: hpa.Spec.ScaleTargetRef.Name},
+		status:    autoscalerOK,
+		hpa:       config,
+	}
+
+	// The controller reports targets it cannot scale or read metrics of
+	for _, condition := range hpa.Status.Conditions {
+		if condition.Status != corev1.ConditionFalse {
+			continue
+		}
+		switch condition.Type {
+		case autoscalingv2.AbleToScale:
+			a.set(autoscalerMisconfigured, "cannot scale its target: "+condition.Message,
+				"Check that the scale target exists and supports the scale subresource")
+			return a
+		case autoscalingv2.ScalingActive:
+			a.set(autoscalerMisconfigured, "scaling is inactive: "+condition.Message,
+				"Set CPU requests on all containers of the target and check that metrics-server is running")
+			return a
+		}
+	}
+
+	switch {
+	case config.targetUtilization > maxTargetUtilization:
+		config.recommendedTarget = highTargetRecommendation
+	case config.targetUtilization < minTargetUtilization:
+		config.recommendedTarget = lowTargetRecommendation
+	}
+	if config.minReplicas >= config.maxReplicas {
+		config.recommendedMax = config.minReplicas * 2
+		a.set(autoscalerMisconfigured, fmt.Sprintf("minReplicas and maxReplicas are both %d, so it never scales", config.maxReplicas),
+			fmt.Sprintf("Raise maxReplicas to %d", config.recommendedMax))
+		return a
+	}
+
+	utilization := config.currentUtilization
+	switch {
+	case utilization != nil && config.replicas >= config.maxReplicas && *utilization >= config.targetUtilization:
+		needed := config.neededReplicas()
+		config.recommendedMax = int32(math.Ceil(float64(max(needed, config.maxReplicas)) * maxReplicasHeadroom))
+		a.set(autoscalerMaxed, fmt.Sprintf("pinned at maxReplicas %d with CPU at %d%% of requests (target %d%%)", config.maxReplicas, *utilization, config.targetUtilization),
+			fmt.Sprintf("Raise maxReplicas to %d", config.recommendedMax))
+	case utilization != nil && config.replicas <= config.minReplicas && *utilization < config.recommendedTarget/2 && !config.scaledWithin(window):
+		// A single replica at low load is as small as it gets
+		if needed := max(1, config.neededReplicas()); needed < config.minReplicas {
+			config.recommendedMin = needed
+			a.set(autoscalerNeverScaling, fmt.Sprintf("stayed at minReplicas %d with CPU at %d%% of requests (target %d%%)", config.minReplicas, *utilization, config.targetUtilization),
+				fmt.Sprintf("Lower minReplicas to %d", config.recommendedMin))
+		}
+	}
+	if config.recommendedTarget != config.targetUtilization && a.status == autoscalerOK {
+		a.set(autoscalerMisconfigured, fmt.Sprintf("CPU target %d%% is outside %d-%d%%", config.targetUtilization, minTargetUtilization, maxTargetUtilization),
+			fmt.Sprintf("Set the CPU target to %d%%", config.recommendedTarget))
+	}
+	return a
+}
+
+// analyzeVPA compares the recommendation of a VPA with the requests of its
+// target
+func (c *Client) analyzeVPA(ctx context.Context, vpa *kube.VPA) *autoscaler {
+	a := &autoscaler{
+		kind:      "VPA",
+		namespace: vpa.Namespace,
+		name:      vpa.Name,
+		target:    vpa.Target,
+		status:    autoscalerOK,
+		vpa:       vpa,
+	}
+	if vpa.Recommendation == nil {
+		a.finding = "no recommendation yet"
+		return a
+	}
+	if vpa.UpdateMode != "Off" && vpa.UpdateMode != "Initial" {
+		return a
+	}
+
+	target, err := c.kube.GetWorkload(ctx, vpa.Target.Kind, vpa.Namespace, vpa.Target.Name)
+	if err != nil {
+		a.set(autoscalerMisconfigured, fmt.Sprintf("cannot read target %s/%s: %v", vpa.Target.Kind, vpa.Target.Name, err),
+			"Point targetRef at an existing workload")
+		return a
+	}
+	cpu := deviation(target.Template.CPU, vpa.Recommendation.CPU)
+	memory := deviation(target.Template.Memory, vpa.Recommendation.Memory)
+	if cpu > vpaDeviation || memory > vpaDeviation {
+		a.set(autoscalerNotApplied, fmt.Sprintf("requests differ from the recommendation by %.0f%% CPU and %.0f%% memory in update mode %s", cpu*100, memory*100, vpa.UpdateMode),
+			fmt.Sprintf("Set requests to %.2f cores and %.0f MiB, or switch the update mode to Auto", vpa.Recommendation.CPU, vpa.Recommendation.Memory/(1<<20)))
+	}
+	return a
+}
+
+// markConflict reports an HPA and a VPA that both scale a workload on the
+// same resource, which makes them work against each other
+func markConflict(hpa, vpa *autoscaler) {
+	if vpa.vpa.UpdateMode == "Off" {
+		return
+	}
+	var shared []string
+	for _, resource := range vpa.vpa.ControlledResources {
+		for _, metric := range hpa.hpa.object.Spec.Metrics {
+			if metric.Type == autoscalingv2.ResourceMetricSourceType && metric.Resource != nil && string(metric.Resource.Name) == resource {
+				shared = append(shared, resource)
+			}
+		}
+	}
+	if len(shared) == 0 {
+		return
+	}
+	finding := fmt.Sprintf("HPA %s and VPA %s both act on the %s of %s/%s", hpa.name, vpa.name, strings.Join(shared, " and "), hpa.target.Kind, hpa.target.Name)
+	advice := "Scale the HPA on other metrics, or limit the VPA's controlledResources to the others"
+	hpa.set(autoscalerConflict, finding, advice)
+	vpa.set(autoscalerConflict, finding, advice)
+}
+
+// set records a finding
+func (a *autoscaler) set(status, finding, advice string) {
+	a.status, a.finding, a.advice = status, finding, advice
+}
+
+// item describes the analysis of an autoscaler
+func (a *autoscaler) item() map[string]interface{} {
+	item := map[string]interface{}{
+		"name":           a.name,
+		"namespace":      a.namespace,
+		"kind":           a.kind,
+		"target":         a.target.Kind + "/" + a.target.Name,
+		"status":         a.status,
+		"finding":        a.finding,
+		"recommendation": a.advice,
+	}
+	if h := a.hpa; h != nil {
+		item["replicas"] = h.replicas
+		item["min_replicas"] = h.minReplicas
+		item["max_replicas"] = h.maxReplicas
+		item["target_utilization"] = h.targetUtilization
+		if h.currentUtilization != nil {
+			item["current_utilization"] = *h.currentUtilization
+		}
+		item["recommended_min_replicas"] = h.recommendedMin
+		item["recommended_max_replicas"] = h.recommendedMax
+		item["recommended_target_utilization"] = h.recommendedTarget
+		if h.lastScale != nil {
+			item["last_scale_time"] = h.lastScale.UTC().Format(time.RFC3339)
+		}
+	}
+	if v := a.vpa; v != nil {
+		item["update_mode"] = v.UpdateMode
+		if v.Recommendation != nil {
+			item["recommended_cpu"] = round(v.Recommendation.CPU, 3)
+			item["recommended_memory_mib"] = round(v.Recommendation.Memory/(1<<20), 1)
+		}
+	}
+	return item
+}
+
+// neededReplicas returns the replicas that would run the current CPU load at
+// the recommended target utilization
+func (h *hpaConfig) neededReplicas() int32 {
+	if h.currentUtilization == nil || h.recommendedTarget <= 0 {
+		return h.replicas
+	}
+	load := float64(h.replicas) * float64(*h.currentUtilization)
+	return int32(math.Ceil(load / float64(h.recommendedTarget)))
+}
+
+// scaledWithin returns true if the HPA changed the replicas within window
+func (h *hpaConfig) scaledWithin(window time.Duration) bool {
+	return h.lastScale != nil && time.Since(*h.lastScale) < window
+}
+
+// changed returns true if any recommended value differs from the current one
+func (h *hpaConfig) changed() bool {
+	return h.recommendedMin != h.minReplicas || h.recommendedMax != h.maxReplicas || h.recommendedTarget != h.targetUtilization
+}
+
+// manifest returns the HPA with its recommended settings, keeping its other
+// metrics and behavior
+func (h *hpaConfig) manifest() map[string]interface{} {
+	spec := h.object.Spec.DeepCopy()
+	spec.MinReplicas = &h.recommendedMin
+	spec.MaxReplicas = h.recommendedMax
+	updated := false
+	for i, metric := range spec.Metrics {
+		if metric.Type == autoscalingv2.ResourceMetricSourceType && metric.Resource != nil &&
+			metric.Resource.Name == corev1.ResourceCPU && metric.Resource.Target.AverageUtilization != nil {
+			spec.Metrics[i].Resource.Target.AverageUtilization = &h.recommendedTarget
+			updated = true
+		}
+	}
+	if !updated && h.recommendedTarget != h.targetUtilization {
+		spec.Metrics = append(spec.Metrics, autoscalingv2.MetricSpec{
+			Type: autoscalingv2.ResourceMetricSourceType,
+			Resource: &autoscalingv2.ResourceMetricSource{
+				Name:   corev1.ResourceCPU,
+				Target: autoscalingv2.MetricTarget{Type: autoscalingv2.UtilizationMetricType, AverageUtilization: &h.recommendedTarget},
+			},
+		})
+	}
+
+	// Convert through JSON so the manifest uses the field names of the API
+	var specFields map[string]interface{}
+	data, _ := json.Marshal(spec)
+	_ = json.Unmarshal(data, &specFields)
+
+	metadata := map[string]interface{}{
+		"name":      h.object.Name,
+		"namespace": h.object.Namespace,
+	}
+	if len(h.object.Labels) > 0 {
+		metadata["labels"] = h.object.Labels
+	}
+	return map[string]interface{}{
+		"apiVersion": "autoscaling/v2",
+		"kind":       "HorizontalPodAutoscaler",
+		"metadata":   metadata,
+		"spec":       specFields,
+	}
+}
+
+// deviation returns the relative difference of recommended from the actual
+// requests; requests that are not set differ entirely
+func deviation(actual, recommended float64) float64 {
+	if actual == 0 {
+		if recommended == 0 {
+			return 0
+		}
+		return 1
+	}
+	return math.Abs(actual-recommended) / actual
+}
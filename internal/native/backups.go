@@ -0,0 +1,353 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultBackupPrice is the list price in USD of a GB-month of S3 Standard,
+// where Velero keeps backups
+const DefaultBackupPrice = 0.023
+
+// failedBackupPhases are the phases of Velero backups that did not complete
+var failedBackupPhases = map[string]bool{"Failed": true, "PartiallyFailed": true, "FailedValidation": true}
+
+// StorageCostOptions configure the analysis of storage costs
+type StorageCostOptions struct {
+	// Namespace is the namespace of the claims and backed up volumes, all
+	// if empty
+	Namespace string
+	// GroupBy groups the volume costs by namespace, type or class
+	GroupBy string
+	// Detailed lists the volumes
+	Detailed bool
+	// Window is the time range failed backups are reported over
+	Window time.Duration
+	// StoragePrice is the price of a GiB-month of volumes that are not EBS
+	// volumes of a known type, and BackupPrice of a GB-month of the backup
+	// storage locations
+	StoragePrice float64
+	BackupPrice  float64
+	// Backups analyzes the Velero backups
+	Backups bool
+}
+
+// StorageCosts prices the persistent volumes of the claims in a namespace,
+// grouped by namespace, volume type or storage class. With Backups, it
+// prices the volume data Velero uploaded to its backup storage locations
+// for the backups it keeps, by namespace, projects the growth of each
+// schedule until its retention is full and lists the backups that failed
+// over the window. Backups of volumes by snapshot are priced by
+// AnalyzeSnapshots. Velero deduplicates the data of file system backups,
+// so backup costs are upper bounds.
+func (c *Client) StorageCosts(ctx context.Context, opts StorageCostOptions) (map[string]interface{}, error) {
+	volumes, err := c.kube.PersistentVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	classes, err := c.kube.StorageClasses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type group struct {
+		volumes        int
+		capacity, cost float64
+	}
+	groups := map[string]*group{}
+	var items []interface{}
+	var capacity, volumeCost float64
+	count := 0
+	for i := range volumes {
+		pv := &volumes[i]
+		ref := pv.Spec.ClaimRef
+		if ref == nil || (opts.Namespace != "" && ref.Namespace != opts.Namespace) {
+			continue
+		}
+		var size float64
+		if q, ok := pv.Spec.Capacity[corev1.ResourceStorage]; ok {
+			size = q.AsApproximateFloat64() / gib
+		}
+		class := classes[pv.Spec.StorageClassName]
+		volumeType, monthly := class.Provisioner, size*opts.StoragePrice
+		if perf, ok := ebsPerformance(class, size); ok {
+			volumeType, monthly = perf.volumeType, perf.cost
+		}
+		if volumeType == "" {
+			volumeType = "unknown"
+		}
+		key := ref.Namespace
+		switch opts.GroupBy {
+		case "type":
+			key = volumeType
+		case "class":
+			key = pv.Spec.StorageClassName
+		}
+		g := groups[key]
+		if g == nil {
+			g = &group{}
+			groups[key] = g
+		}
+		g.volumes++
+		count++
+		g.capacity += size
+		g.cost += monthly
+		capacity += size
+		volumeCost += monthly
+		if opts.Detailed {
+			items = append(items, map[string]interface{}{
+				"name":          pv.Name,
+				"claim":         ref.Name,
+				"namespace":     ref.Namespace,
+				"storage_class": pv.Spec.StorageClassName,
+				"type":          volumeType,
+				"capacity_gib":  round(size, 1),
+				"monthly_cost":  round(monthly, 2),
+			})
+		}
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if groups[keys[i]].cost != groups[keys[j]].cost {
+			return groups[keys[i]].cost > groups[keys[j]].cost
+		}
+		return keys[i] < keys[j]
+	})
+	breakdown := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		g := groups[key]
+		breakdown = append(breakdown, map[string]interface{}{
+			"group":        key,
+			"volumes":      g.volumes,
+			"capacity_gib": round(g.capacity, 1),
+			"monthly_cost": round(g.cost, 2),
+			"share":        round(g.cost/math.Max(volumeCost, 0.01)*100, 1),
+		})
+	}
+	if opts.Detailed {
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].(map[string]interface{})["monthly_cost"].(float64) > items[j].(map[string]interface{})["monthly_cost"].(float64)
+		})
+	}
+
+	result := map[string]interface{}{
+		"context":      c.kube.Context,
+		"group_by":     opts.GroupBy,
+		"volumes":      count,
+		"capacity_gib": round(capacity, 1),
+		"volume_cost":  round(volumeCost, 2),
+		"monthly_cost": round(volumeCost, 2),
+		"breakdown":    breakdown,
+		"message":      fmt.Sprintf("%.1f GiB of persistent volumes cost $%.2f per month", capacity, volumeCost),
+	}
+	if opts.Detailed {
+		result["persistent_volumes"] = items
+	}
+	if !opts.Backups {
+		return result, nil
+	}
+
+	backups, schedules, installed, err := c.kube.VeleroBackups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !installed {
+		result["warning"] = "the Velero custom resources are not installed, no backups are priced"
+		return result, nil
+	}
+	analysis := analyzeBackups(backups, schedules, opts, time.Now())
+	for key, value := range analysis.sections {
+		result[key] = value
+	}
+	result["backups"] = analysis.retained
+	result["backup_gib"] = round(analysis.size/gib, 1)
+	result["backup_cost"] = round(analysis.cost, 2)
+	result["monthly_cost"] = round(volumeCost+analysis.cost, 2)
+	result["message"] = fmt.Sprintf("%.1f GiB of persistent volumes cost $%.2f per month, %d backups keep up to %.1f GiB of their data for $%.2f; %d backups failed in the last %s",
+		capacity, volumeCost, analysis.retained, analysis.size/gib, analysis.cost, analysis.failed, formatWindow(opts.Window))
+	if analysis.expired > 0 {
+		result["warning"] = fmt.Sprintf("%d backups are past their expiration but not deleted, check the garbage collection of Velero", analysis.expired)
+	}
+	return result, nil
+}
+
+// backupAnalysis is the analysis of the Velero backups
+type backupAnalysis struct {
+	// sections are the lists of the result: backup_namespaces,
+	// backup_schedules and failed_backups
+	sections map[string]interface{}
+	retained int
+	failed   int
+	expired  int
+	// size is the volume data of the retained backups in bytes
+	size float64
+	cost float64
+}
+
+// analyzeBackups prices the volume data of the retained Velero backups by
+// namespace, projects the data each schedule keeps once its retention is
+// full from the interval between its backups, and lists the backups that
+// failed over the window
+func analyzeBackups(backups []kube.VeleroBackup, schedules []kube.VeleroSchedule, opts StorageCostOptions, now time.Time) backupAnalysis {
+	var a backupAnalysis
+	type namespaceData struct {
+		backups int
+		size    float64
+	}
+	namespaces := map[string]*namespaceData{}
+	bySchedule := map[string][]kube.VeleroBackup{}
+	failed := []interface{}{}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Started.After(backups[j].Started) })
+	for _, b := range backups {
+		if failedBackupPhases[b.Phase] && now.Sub(b.Started) <= opts.Window {
+			a.failed++
+			failed = append(failed, map[string]interface{}{
+				"name":     b.Name,
+				"schedule": b.Schedule,
+				"phase":    b.Phase,
+				"started":  b.Started.UTC().Format(time.RFC3339),
+				"errors":   b.Errors,
+				"warnings": b.Warnings,
+				"reason":   b.FailureReason,
+			})
+		}
+		if b.Schedule != "" {
+			bySchedule[b.Schedule] = append(bySchedule[b.Schedule], b)
+		}
+		// Backups that failed outright keep no data
+		if b.Phase != "Completed" && b.Phase != "PartiallyFailed" {
+			continue
+		}
+		if !b.Expiration.IsZero() && b.Expiration.Before(now) {
+			a.expired++
+		}
+		a.retained++
+		for namespace, size := range b.Volumes {
+			if opts.Namespace != "" && namespace != opts.Namespace {
+				continue
+			}
+			n := namespaces[namespace]
+			if n == nil {
+				n = &namespaceData{}
+				namespaces[namespace] = n
+			}
+			n.backups++
+			n.size += size
+			a.size += size
+		}
+	}
+	a.cost = a.size / 1e9 * opts.BackupPrice
+
+	names := make([]string, 0, len(namespaces))
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if namespaces[names[i]].size != namespaces[names[j]].size {
+			return namespaces[names[i]].size > namespaces[names[j]].size
+		}
+		return names[i] < names[j]
+	})
+	byNamespace := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		n := namespaces[name]
+		byNamespace = append(byNamespace, map[string]interface{}{
+			"namespace":    name,
+			"backups":      n.backups,
+			"backup_gib":   round(n.size/gib, 1),
+			"monthly_cost": round(n.size/1e9*opts.BackupPrice, 2),
+		})
+	}
+
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].Name < schedules[j].Name })
+	scheduleItems := make([]interface{}, 0, len(schedules))
+	for _, s := range schedules {
+		scheduled := bySchedule[s.Name]
+		// Newest first, as backups are
+		var retained, failedRecently int
+		var size float64
+		var starts []time.Time
+		for _, b := range scheduled {
+			if failedBackupPhases[b.Phase] && now.Sub(b.Started) <= opts.Window {
+				failedRecently++
+			}
+			if b.Phase != "Completed" && b.Phase != "PartiallyFailed" {
+				continue
+			}
+			retained++
+			starts = append(starts, b.Started)
+			for namespace, bytes := range b.Volumes {
+				if opts.Namespace == "" || namespace == opts.Namespace {
+					size += bytes
+				}
+			}
+		}
+		item := map[string]interface{}{
+			"name":         s.Name,
+			"schedule":     s.Schedule,
+			"ttl":          formatWindow(s.TTL),
+			"backups":      retained,
+			"failed":       failedRecently,
+			"backup_gib":   round(size/gib, 1),
+			"monthly_cost": round(size/1e9*opts.BackupPrice, 2),
+		}
+		var flags []string
+		if s.Paused {
+			flags = append(flags, "paused")
+		}
+		if len(scheduled) > 0 {
+			item["last_status"] = scheduled[0].Phase
+			if failedBackupPhases[scheduled[0].Phase] {
+				flags = append(flags, "failing")
+			}
+		}
+		// Once its retention is full, a schedule keeps a backup per
+		// interval of its TTL, each the size of its average backup
+		if interval := backupInterval(starts); interval > 0 && !s.Paused {
+			steady := math.Ceil(float64(s.TTL) / float64(interval))
+			projected := size / float64(retained) * steady
+			item["interval"] = formatWindow(interval.Round(time.Minute))
+			item["retained_backups"] = int(steady)
+			item["projected_gib"] = round(projected/gib, 1)
+			item["growth_gib"] = round(math.Max(projected-size, 0)/gib, 1)
+			item["projected_cost"] = round(projected/1e9*opts.BackupPrice, 2)
+			if now.Sub(starts[0]) > 2*interval {
+				flags = append(flags, "stale")
+			}
+		}
+		item["flags"] = strings.Join(flags, ",")
+		scheduleItems = append(scheduleItems, item)
+	}
+
+	a.sections = map[string]interface{}{
+		"backup_namespaces": byNamespace,
+		"backup_schedules":  scheduleItems,
+		"failed_backups":    failed,
+	}
+	return a
+}
+
+// backupInterval returns the median interval between backups, newest
+// first, 0 with fewer than two
+func backupInterval(starts []time.Time) time.Duration {
+	if len(starts) < 2 {
+		return 0
+	}
+	gaps := make([]time.Duration, 0, len(starts)-1)
+	for i := 1; i < len(starts); i++ {
+		gaps = append(gaps, starts[i-1].Sub(starts[i]))
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	return gaps[len(gaps)/2]
+}
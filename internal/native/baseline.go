@@ -0,0 +1,282 @@
+package native
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// baselineVersion is the format version of baseline files
+const baselineVersion = 1
+
+// Drift found by CheckBaseline
+const (
+	driftNew      = "new"
+	driftRequests = "requests-inflated"
+	driftCost     = "cost-growth"
+	driftRemoved  = "removed"
+)
+
+// Baseline is an approved resource and cost profile of a cluster
+type Baseline struct {
+	Version    int                `json:"version"`
+	Context    string             `json:"context"`
+	Namespace  string             `json:"namespace,omitempty"`
+	CapturedAt time.Time          `json:"captured_at"`
+	Prices     ComputePrices      `json:"prices"`
+	Workloads  []BaselineWorkload `json:"workloads"`
+}
+
+// BaselineWorkload is the approved profile of one workload
+type BaselineWorkload struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Replicas  int32  `json:"replicas"`
+	// Requests are those of one pod
+	Requests    kube.Resources `json:"requests"`
+	MonthlyCost float64        `json:"monthly_cost"`
+}
+
+// key identifies a workload across baselines
+func (w BaselineWorkload) key() string {
+	return w.Namespace + "/" + w.Kind + "/" + w.Name
+}
+
+// MonthlyCost returns the cost of all workloads of the baseline
+func (b *Baseline) MonthlyCost() float64 {
+	var cost float64
+	for _, w := range b.Workloads {
+		cost += w.MonthlyCost
+	}
+	return cost
+}
+
+// LoadBaseline reads a baseline file
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, clierr.New(clierr.CategoryUsage, "BASELINE_NOT_FOUND", fmt.Sprintf("baseline %s does not exist", path)).
+			WithHint("Save one with 'upid analyze baseline save --file " + path + "'")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baseline: %v", err)
+	}
+	var baseline Baseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, fmt.Errorf("invalid baseline file %s: %v", path, err)
+	}
+	if baseline.Version != baselineVersion {
+		return nil, fmt.Errorf("unsupported baseline version %d in %s (expected %d)", baseline.Version, path, baselineVersion)
+	}
+	return &baseline, nil
+}
+
+// Save writes the baseline to path
+func (b *Baseline) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write baseline: %v", err)
+	}
+	return nil
+}
+
+// CaptureBaseline records the replicas, requests and cost of the workloads
+// in namespace (all if empty). Costs are those of the requests at prices.
+func (c *Client) CaptureBaseline(ctx context.Context, namespace string, prices ComputePrices) (*Baseline, error) {
+	snapshot, err := c.kube.Collect(ctx, kube.CollectOptions{Namespace: namespace, RunningOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	running := map[string]int32{}
+	for _, pod := range snapshot.Pods {
+		running[pod.Namespace+"/"+pod.Workload.Kind+"/"+pod.Workload.Name]++
+	}
+
+	baseline := &Baseline{
+		Version:    baselineVersion,
+		Context:    c.kube.Context,
+		Namespace:  namespace,
+		CapturedAt: snapshot.CollectedAt.UTC(),
+		Prices:     prices,
+		Workloads:  make([]BaselineWorkload, 0, len(snapshot.Workloads)),
+	}
+	for _, w := range snapshot.Workloads {
+		item := BaselineWorkload{Kind: w.Kind, Namespace: w.Namespace, Name: w.Name, Requests: w.Template}
+		switch {
+		case w.Replicas != nil:
+			item.Replicas = *w.Replicas
+		case w.Kind == "CronJob":
+			// Runs come and go, each is counted as one pod
+			item.Replicas = 1
+		default:
+			// DaemonSets run a pod per eligible node
+			item.Replicas = running[item.key()]
+		}
+		item.MonthlyCost = round(requestCost(w.Template, item.Replicas, prices), 2)
+		baseline.Workloads = append(baseline.Workloads, item)
+	}
+	sort.Slice(baseline.Workloads, func(i, j int) bool {
+		return baseline.Workloads[i].key() < baseline.Workloads[j].key()
+	})
+	return baseline, nil
+}
+
+// SaveBaseline captures the baseline of namespace (all if empty) and writes it
+// to path
+func (c *Client) SaveBaseline(ctx context.Context, namespace string, prices ComputePrices, path string) (map[string]interface{}, error) {
+	baseline, err := c.CaptureBaseline(ctx, namespace, prices)
+	if err != nil {
+		return nil, err
+	}
+	if err := baseline.Save(path); err != nil {
+		return nil, err
+	}
+	items := make([]interface{}, 0, len(baseline.Workloads))
+	for _, w := range baseline.Workloads {
+		items = append(items, map[string]interface{}{
+			"name":               w.Name,
+			"namespace":          w.Namespace,
+			"kind":               w.Kind,
+			"replicas":           w.Replicas,
+			"cpu_request":        round(w.Requests.CPU, 3),
+			"memory_request_mib": round(w.Requests.Memory/(1<<20), 1),
+			"monthly_cost":       w.MonthlyCost,
+		})
+	}
+	return map[string]interface{}{
+		"message": fmt.Sprintf("Saved a baseline of %d workloads, monthly cost %.2f, to %s",
+			len(baseline.Workloads), baseline.MonthlyCost(), path),
+		"context":      baseline.Context,
+		"file":         path,
+		"monthly_cost": round(baseline.MonthlyCost(), 2),
+		"workloads":    items,
+	}, nil
+}
+
+// CheckBaseline compares the workloads of the cluster with a baseline and
+// reports new workloads, pod requests and workload costs that grew by more
+// than tolerance (a fraction), and removed workloads. The cluster drifted
+// if anything but removals was found, or the total cost grew by more than
+// tolerance.
+func (c *Client) CheckBaseline(ctx context.Context, baseline *Baseline, tolerance float64) (map[string]interface{}, bool, error) {
+	current, err := c.CaptureBaseline(ctx, baseline.Namespace, baseline.Prices)
+	if err != nil {
+		return nil, false, err
+	}
+	approved := map[string]BaselineWorkload{}
+	for _, w := range baseline.Workloads {
+		approved[w.key()] = w
+	}
+
+	var items []interface{}
+	counts := map[string]int{}
+	drifted := 0
+	for _, w := range current.Workloads {
+		var findings []string
+		item := map[string]interface{}{
+			"name":               w.Name,
+			"namespace":          w.Namespace,
+			"kind":               w.Kind,
+			"replicas":           w.Replicas,
+			"cpu_request":        round(w.Requests.CPU, 3),
+			"memory_request_mib": round(w.Requests.Memory/(1<<20), 1),
+			"monthly_cost":       w.MonthlyCost,
+		}
+		old, ok := approved[w.key()]
+		delete(approved, w.key())
+		if !ok {
+			findings = append(findings, driftNew)
+		} else {
+			item["baseline_replicas"] = old.Replicas
+			item["baseline_cpu_request"] = round(old.Requests.CPU, 3)
+			item["baseline_memory_request_mib"] = round(old.Requests.Memory/(1<<20), 1)
+			item["baseline_monthly_cost"] = old.MonthlyCost
+			if grew(old.Requests.CPU, w.Requests.CPU, tolerance) || grew(old.Requests.Memory, w.Requests.Memory, tolerance) {
+				findings = append(findings, driftRequests)
+			}
+			if grew(old.MonthlyCost, w.MonthlyCost, tolerance) {
+				findings = append(findings, driftCost)
+			}
+		}
+		if len(findings) == 0 {
+			continue
+		}
+		drifted++
+		for _, finding := range findings {
+			counts[finding]++
+		}
+		item["drift"] = strings.Join(findings, ",")
+		items = append(items, item)
+	}
+	removed := make([]BaselineWorkload, 0, len(approved))
+	for _, w := range approved {
+		removed = append(removed, w)
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].key() < removed[j].key() })
+	for _, w := range removed {
+		counts[driftRemoved]++
+		items = append(items, map[string]interface{}{
+			"name":                        w.Name,
+			"namespace":                   w.Namespace,
+			"kind":                        w.Kind,
+			"baseline_replicas":           w.Replicas,
+			"baseline_cpu_request":        round(w.Requests.CPU, 3),
+			"baseline_memory_request_mib": round(w.Requests.Memory/(1<<20), 1),
+			"baseline_monthly_cost":       w.MonthlyCost,
+			"drift":                       driftRemoved,
+		})
+	}
+	if items == nil {
+		items = []interface{}{}
+	}
+
+	oldCost, newCost := baseline.MonthlyCost(), current.MonthlyCost()
+	costDrift := grew(oldCost, newCost, tolerance)
+	result := map[string]interface{}{
+		"context":               c.kube.Context,
+		"baseline_captured_at":  baseline.CapturedAt.Format(time.RFC3339),
+		"tolerance_percent":     round(tolerance*100, 1),
+		"baseline_monthly_cost": round(oldCost, 2),
+		"monthly_cost":          round(newCost, 2),
+		"cost_change_percent":   percent(newCost-oldCost, oldCost),
+		"new":                   counts[driftNew],
+		"requests_inflated":     counts[driftRequests],
+		"cost_growth":           counts[driftCost],
+		"removed":               counts[driftRemoved],
+		"drifted":               drifted > 0 || costDrift,
+		"workloads":             items,
+	}
+	if baseline.Context != c.kube.Context {
+		result["warning"] = fmt.Sprintf("the baseline was captured in context %s, not %s", baseline.Context, c.kube.Context)
+	}
+	summary := fmt.Sprintf("%d new, %d with inflated requests, %d with cost growth, %d removed; monthly cost %.2f of %.2f approved",
+		counts[driftNew], counts[driftRequests], counts[driftCost], counts[driftRemoved], newCost, oldCost)
+	if drifted == 0 && !costDrift {
+		result["message"] = "No drift from the baseline: " + summary
+		return result, false, nil
+	}
+	result["message"] = fmt.Sprintf("Drift from the baseline in %d workloads: %s", drifted, summary)
+	return result, true, nil
+}
+
+// requestCost is the monthly cost of the requests of replicas pods
+func requestCost(requests kube.Resources, replicas int32, prices ComputePrices) float64 {
+	return prices.hourly(requests) * float64(replicas) * month.Hours()
+}
+
+// grew reports whether value exceeds the approved one by more than
+// tolerance, a fraction
+func grew(approved, value, tolerance float64) bool {
+	return value > approved*(1+tolerance) && value-approved > 1e-9
+}
@@ -0,0 +1,569 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// nodeOperators maps the operators of node selector requirements to label
+// selector operators
+var nodeOperators = map[corev1.NodeSelectorOperator]selection.Operator{
+	corev1.NodeSelectorOpIn:           selection.In,
+	corev1.NodeSelectorOpNotIn:        selection.NotIn,
+	corev1.NodeSelectorOpExists:       selection.Exists,
+	corev1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	corev1.NodeSelectorOpGt:           selection.GreaterThan,
+	corev1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+// BinpackOptions configure a node consolidation simulation
+type BinpackOptions struct {
+	// NodeSize is the capacity of the nodes to repack all pods onto; nil
+	// repacks onto the current nodes, removing those that empty
+	NodeSize *kube.Resources
+	// Prices are used to price nodes by their capacity
+	Prices ComputePrices
+}
+
+// binNode is a node in a packing simulation
+type binNode struct {
+	name string
+	// node is nil for simulated nodes, which have no labels or taints
+	node        *kube.Node
+	allocatable kube.Resources
+	maxPods     int64
+	requested   kube.Resources
+	pods        []*kube.Pod
+}
+
+// utilization is the larger share of allocatable CPU and memory requested
+func (n *binNode) utilization() float64 {
+	cpu, memory := 0.0, 0.0
+	if n.allocatable.CPU > 0 {
+		cpu = n.requested.CPU / n.allocatable.CPU
+	}
+	if n.allocatable.Memory > 0 {
+		memory = n.requested.Memory / n.allocatable.Memory
+	}
+	if cpu > memory {
+		return cpu
+	}
+	return memory
+}
+
+// add places a pod on the node
+func (n *binNode) add(pod *kube.Pod) {
+	n.requested = n.requested.Add(pod.Requests())
+	n.pods = append(n.pods, pod)
+}
+
+// remove takes a pod placed last off the node
+func (n *binNode) remove(pod *kube.Pod) {
+	requests := pod.Requests()
+	n.requested = kube.Resources{CPU: n.requested.CPU - requests.CPU, Memory: n.requested.Memory - requests.Memory, GPU: n.requested.GPU - requests.GPU}
+	for i := len(n.pods) - 1; i >= 0; i-- {
+		if n.pods[i] == pod {
+			n.pods = append(n.pods[:i], n.pods[i+1:]...)
+			return
+		}
+	}
+}
+
+// admits reports whether pod could be scheduled on the node: its requests
+// fit, it selects and tolerates the node, and its required pod affinity and
+// anti-affinity over hostnames hold
+func (n *binNode) admits(pod *kube.Pod) bool {
+	requests := pod.Requests()
+	if n.requested.CPU+requests.CPU > n.allocatable.CPU || n.requested.Memory+requests.Memory > n.allocatable.Memory {
+		return false
+	}
+	if n.maxPods > 0 && int64(len(n.pods)) >= n.maxPods {
+		return false
+	}
+	spec := &pod.Object.Spec
+	if n.node != nil {
+		for key, value := range spec.NodeSelector {
+			if n.node.Labels[key] != value {
+				return false
+			}
+		}
+		if affinity := spec.Affinity; affinity != nil && affinity.NodeAffinity != nil {
+			if required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil && !matchesNode(required.NodeSelectorTerms, n.node) {
+				return false
+			}
+		}
+		for i := range n.node.Object.Spec.Taints {
+			taint := &n.node.Object.Spec.Taints[i]
+			if taint.Effect != corev1.TaintEffectPreferNoSchedule && !tolerates(spec.Tolerations, taint) {
+				return false
+			}
+		}
+	}
+	affinity := spec.Affinity
+	if affinity == nil {
+		return true
+	}
+	if affinity.PodAntiAffinity != nil {
+		for _, term := range affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+			if term.TopologyKey == corev1.LabelHostname && n.hasPod(pod, term) {
+				return false
+			}
+		}
+	}
+	if affinity.PodAffinity != nil {
+		for _, term := range affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+			if term.TopologyKey == corev1.LabelHostname && !n.hasPod(pod, term) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hasPod reports whether a pod on the node other than pod matches a pod
+// affinity term of pod
+func (n *binNode) hasPod(pod *kube.Pod, term corev1.PodAffinityTerm) bool {
+	selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil {
+		return false
+	}
+	namespaces := map[string]bool{}
+	for _, namespace := range term.Namespaces {
+		namespaces[namespace] = true
+	}
+	if len(namespaces) == 0 && term.NamespaceSelector == nil {
+		namespaces[pod.Namespace] = true
+	}
+	for _, other := range n.pods {
+		if other == pod || (len(namespaces) > 0 && !namespaces[other.Namespace]) {
+			continue
+		}
+		if selector.Matches(labels.Set(other.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesNode reports whether a node matches any of the terms of a node
+// selector
+func matchesNode(terms []corev1.NodeSelectorTerm, node *kube.Node) bool {
+	for _, term := range terms {
+		if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+			continue
+		}
+		matches := true
+		for _, requirement := range term.MatchExpressions {
+			matches = matches && matchesRequirement(requirement, node.Labels)
+		}
+		for _, requirement := range term.MatchFields {
+			matches = matches && requirement.Key == "metadata.name" &&
+				matchesRequirement(requirement, map[string]string{requirement.Key: node.Name})
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesRequirement reports whether a set of labels meets a node selector
+// requirement
+func matchesRequirement(requirement corev1.NodeSelectorRequirement, set map[string]string) bool {
+	operator, ok := nodeOperators[requirement.Operator]
+	if !ok {
+		return false
+	}
+	r, err := labels.NewRequirement(requirement.Key, operator, requirement.Values)
+	if err != nil {
+		return false
+	}
+	return r.Matches(labels.Set(set))
+}
+
+// tolerates reports whether any of the tolerations tolerates taint
+func tolerates(tolerations []corev1.Toleration, taint *corev1.Taint) bool {
+	for i := range tolerations {
+		if tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// staysOnNode reports whether a pod goes away with its node rather than
+// being rescheduled: DaemonSet pods and static pods
+func staysOnNode(pod *kube.Pod) bool {
+	return pod.Workload.Kind == "DaemonSet" || pod.Workload.Kind == "Node"
+}
+
+// sameTopology reports whether moving pod from one node to another keeps
+// it in the topology domains of its required pod affinity and anti-affinity
+// terms other than hostnames, such as its zone
+func sameTopology(pod *kube.Pod, from, to *kube.Node) bool {
+	affinity := pod.Object.Spec.Affinity
+	if affinity == nil {
+		return true
+	}
+	var terms []corev1.PodAffinityTerm
+	if affinity.PodAffinity != nil {
+		terms = append(terms, affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution...)
+	}
+	if affinity.PodAntiAffinity != nil {
+		terms = append(terms, affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution...)
+	}
+	for _, term := range terms {
+		if term.TopologyKey != corev1.LabelHostname && from.Labels[term.TopologyKey] != to.Labels[term.TopologyKey] {
+			return false
+		}
+	}
+	return true
+}
+
+// byRequests sorts pods by decreasing requests, CPU first, so that the
+// largest are placed while there is most room
+func byRequests(pods []*kube.Pod) {
+	sort.SliceStable(pods, func(i, j int) bool {
+		a, b := pods[i].Requests(), pods[j].Requests()
+		if a.CPU != b.CPU {
+			return a.CPU > b.CPU
+		}
+		return a.Memory > b.Memory
+	})
+}
+
+// nodeCost is the monthly cost of a node of the given capacity
+func nodeCost(capacity kube.Resources, prices ComputePrices) float64 {
+	return prices.hourly(capacity) * month.Hours()
+}
+
+// Binpack simulates repacking the running pods of the cluster by their
+// requests. By default nodes are drained one at a time, least utilized
+// first, and removed if their pods fit on the remaining nodes, respecting
+// node selectors and affinity, taints, pod affinity and anti-affinity, pod
+// limits and PodDisruptionBudgets. With a node size, all pods are repacked
+// onto as few nodes of that size as fit them. Nodes are priced by their
+// capacity, projected to a month.
+func (c *Client) Binpack(ctx context.Context, opts BinpackOptions) (map[string]interface{}, error) {
+	snapshot, err := c.kube.Collect(ctx, kube.CollectOptions{RunningOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := map[string]*binNode{}
+	ordered := make([]*binNode, 0, len(snapshot.Nodes))
+	for i := range snapshot.Nodes {
+		node := &snapshot.Nodes[i]
+		n := &binNode{name: node.Name, node: node, allocatable: node.Allocatable}
+		if node.Object != nil {
+			n.maxPods = node.Object.Status.Allocatable.Pods().Value()
+		}
+		nodes[node.Name] = n
+		ordered = append(ordered, n)
+	}
+	for i := range snapshot.Pods {
+		pod := &snapshot.Pods[i]
+		if n := nodes[pod.Node]; n != nil {
+			n.add(pod)
+		}
+	}
+
+	if opts.NodeSize != nil {
+		return c.binpackResize(ordered, *opts.NodeSize, opts.Prices), nil
+	}
+	budgets, err := c.kube.DisruptionBudgets(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return c.binpackConsolidate(ordered, budgets, opts.Prices), nil
+}
+
+// binpackConsolidate drains the nodes whose pods fit on the others
+func (c *Client) binpackConsolidate(nodes []*binNode, budgets []policyv1.PodDisruptionBudget, prices ComputePrices) map[string]interface{} {
+	type outcome struct {
+		action string
+		reason string
+		moved  int
+	}
+	outcomes := map[*binNode]*outcome{}
+	before := map[*binNode]float64{}
+	podCounts := map[*binNode]int{}
+	var candidates []*binNode
+	var requested, allocatable kube.Resources
+	for _, n := range nodes {
+		before[n] = n.utilization()
+		podCounts[n] = len(n.pods)
+		requested = requested.Add(n.requested)
+		allocatable = allocatable.Add(n.allocatable)
+		switch {
+		case !n.node.Ready:
+			outcomes[n] = &outcome{action: "keep", reason: "not ready"}
+		case n.node.Unschedulable:
+			outcomes[n] = &outcome{action: "keep", reason: "cordoned"}
+		default:
+			candidates = append(candidates, n)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return before[candidates[i]] < before[candidates[j]] })
+
+	allowed := make([]int32, len(budgets))
+	selectors := make([]labels.Selector, len(budgets))
+	for i := range budgets {
+		allowed[i] = budgets[i].Status.DisruptionsAllowed
+		selectors[i], _ = metav1.LabelSelectorAsSelector(budgets[i].Spec.Selector)
+	}
+	// budgetsOf returns the indexes of the budgets covering a pod
+	budgetsOf := func(pod *kube.Pod) []int {
+		var matched []int
+		for i := range budgets {
+			if budgets[i].Namespace == pod.Namespace && selectors[i] != nil && selectors[i].Matches(labels.Set(pod.Labels)) {
+				matched = append(matched, i)
+			}
+		}
+		return matched
+	}
+
+	removed := map[*binNode]bool{}
+	for _, n := range candidates {
+		var movable []*kube.Pod
+		reason := ""
+		needed := make([]int32, len(budgets))
+		for _, pod := range n.pods {
+			if staysOnNode(pod) {
+				continue
+			}
+			if pod.Workload.Kind == "Pod" {
+				reason = fmt.Sprintf("pod %s/%s has no controller to recreate it", pod.Namespace, pod.Name)
+				break
+			}
+			for _, i := range budgetsOf(pod) {
+				needed[i]++
+				if needed[i] > allowed[i] {
+					reason = fmt.Sprintf("PodDisruptionBudget %s/%s allows %d disruptions", budgets[i].Namespace, budgets[i].Name, allowed[i])
+				}
+			}
+			if reason != "" {
+				break
+			}
+			movable = append(movable, pod)
+		}
+		if reason != "" {
+			outcomes[n] = &outcome{action: "keep", reason: reason}
+			continue
+		}
+
+		// Pods go to the most utilized nodes first, to leave others empty
+		var targets []*binNode
+		for _, other := range candidates {
+			if other != n && !removed[other] {
+				targets = append(targets, other)
+			}
+		}
+		sort.SliceStable(targets, func(i, j int) bool { return targets[i].utilization() > targets[j].utilization() })
+		byRequests(movable)
+		type placement struct {
+			pod    *kube.Pod
+			target *binNode
+		}
+		var placed []placement
+		for _, pod := range movable {
+			var target *binNode
+			for _, t := range targets {
+				if sameTopology(pod, n.node, t.node) && t.admits(pod) {
+					target = t
+					break
+				}
+			}
+			if target == nil {
+				reason = fmt.Sprintf("pod %s/%s does not fit on the other nodes", pod.Namespace, pod.Name)
+				break
+			}
+			target.add(pod)
+			placed = append(placed, placement{pod, target})
+		}
+		if reason != "" {
+			for i := len(placed) - 1; i >= 0; i-- {
+				placed[i].target.remove(placed[i].pod)
+			}
+			outcomes[n] = &outcome{action: "keep", reason: reason}
+			continue
+		}
+		for i := range budgets {
+			allowed[i] -= needed[i]
+		}
+		removed[n] = true
+		reason = "its pods fit on the other nodes"
+		if len(movable) == 0 {
+			reason = "no pods to move"
+		}
+		outcomes[n] = &outcome{action: "remove", reason: reason, moved: len(movable)}
+	}
+
+	var cost, savings float64
+	var requestedAfter, allocatableAfter kube.Resources
+	items := make([]interface{}, 0, len(nodes))
+	sorted := append([]*binNode(nil), nodes...)
+	sort.SliceStable(sorted, func(i, j int) bool { return before[sorted[i]] < before[sorted[j]] })
+	for _, n := range sorted {
+		monthly := nodeCost(n.node.Capacity, prices)
+		cost += monthly
+		if removed[n] {
+			savings += monthly
+		} else {
+			requestedAfter = requestedAfter.Add(n.requested)
+			allocatableAfter = allocatableAfter.Add(n.allocatable)
+		}
+		o := outcomes[n]
+		items = append(items, map[string]interface{}{
+			"name":              n.name,
+			"instance_type":     n.node.InstanceType,
+			"zone":              n.node.Zone,
+			"pods":              podCounts[n],
+			"moved_pods":        o.moved,
+			"requested_percent": round(before[n]*100, 1),
+			"action":            o.action,
+			"reason":            o.reason,
+			"monthly_cost":      round(monthly, 2),
+		})
+	}
+
+	return map[string]interface{}{
+		"message": fmt.Sprintf("%d of %d nodes could be removed by repacking their pods onto the others: %.2f per month in savings",
+			len(removed), len(nodes), savings),
+		"context":                        c.kube.Context,
+		"node_count":                     len(nodes),
+		"removable_nodes":                len(removed),
+		"monthly_cost":                   round(cost, 2),
+		"monthly_savings":                round(savings, 2),
+		"cpu_requested_percent":          percent(requested.CPU, allocatable.CPU),
+		"memory_requested_percent":       percent(requested.Memory, allocatable.Memory),
+		"cpu_requested_percent_after":    percent(requestedAfter.CPU, allocatableAfter.CPU),
+		"memory_requested_percent_after": percent(requestedAfter.Memory, allocatableAfter.Memory),
+		"nodes":                          items,
+	}
+}
+
+// binpackResize repacks all pods onto as few nodes of the given capacity as
+// fit them. The new nodes reserve the same share of their capacity for the
+// system as the current ones, and run the DaemonSets of the busiest node.
+func (c *Client) binpackResize(nodes []*binNode, size kube.Resources, prices ComputePrices) map[string]interface{} {
+	var capacity, allocatable, daemons kube.Resources
+	var maxPods int64
+	var daemonPods int
+	var pods []*kube.Pod
+	var cost float64
+	for _, n := range nodes {
+		capacity = capacity.Add(n.node.Capacity)
+		allocatable = allocatable.Add(n.allocatable)
+		cost += nodeCost(n.node.Capacity, prices)
+		if n.maxPods > maxPods {
+			maxPods = n.maxPods
+		}
+		var overhead kube.Resources
+		count := 0
+		for _, pod := range n.pods {
+			if staysOnNode(pod) {
+				overhead = overhead.Add(pod.Requests())
+				count++
+				continue
+			}
+			pods = append(pods, pod)
+		}
+		if overhead.CPU > daemons.CPU {
+			daemons.CPU = overhead.CPU
+		}
+		if overhead.Memory > daemons.Memory {
+			daemons.Memory = overhead.Memory
+		}
+		if count > daemonPods {
+			daemonPods = count
+		}
+	}
+
+	template := &binNode{allocatable: size}
+	if capacity.CPU > 0 && capacity.Memory > 0 {
+		template.allocatable = kube.Resources{
+			CPU:    size.CPU*allocatable.CPU/capacity.CPU - daemons.CPU,
+			Memory: size.Memory*allocatable.Memory/capacity.Memory - daemons.Memory,
+		}
+	}
+	if maxPods > 0 {
+		template.maxPods = maxPods - int64(daemonPods)
+	}
+
+	byRequests(pods)
+	var bins []*binNode
+	var oversized, pinned []string
+	for _, pod := range pods {
+		spec := &pod.Object.Spec
+		if len(spec.NodeSelector) > 0 || (spec.Affinity != nil && spec.Affinity.NodeAffinity != nil &&
+			spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil) {
+			pinned = append(pinned, pod.Namespace+"/"+pod.Name)
+		}
+		var target *binNode
+		for _, bin := range bins {
+			if bin.admits(pod) {
+				target = bin
+				break
+			}
+		}
+		if target == nil {
+			bin := &binNode{name: fmt.Sprintf("node-%d", len(bins)+1), allocatable: template.allocatable, maxPods: template.maxPods}
+			if !bin.admits(pod) {
+				oversized = append(oversized, pod.Namespace+"/"+pod.Name)
+				continue
+			}
+			bins = append(bins, bin)
+			target = bin
+		}
+		target.add(pod)
+	}
+
+	perNode := nodeCost(size, prices)
+	newCost := perNode * float64(len(bins))
+	items := make([]interface{}, 0, len(bins))
+	for _, bin := range bins {
+		items = append(items, map[string]interface{}{
+			"name":              bin.name,
+			"pods":              len(bin.pods) + daemonPods,
+			"requested_percent": round(bin.utilization()*100, 1),
+			"action":            "new",
+			"monthly_cost":      round(perNode, 2),
+		})
+	}
+
+	savings := cost - newCost
+	impact := fmt.Sprintf("%.2f per month in savings", savings)
+	if savings < 0 {
+		impact = fmt.Sprintf("%.2f per month more", -savings)
+	}
+	result := map[string]interface{}{
+		"message": fmt.Sprintf("The pods fit on %d nodes of %g cores and %.1f GiB instead of %d nodes: %s",
+			len(bins), size.CPU, size.Memory/(1<<30), len(nodes), impact),
+		"context":            c.kube.Context,
+		"node_count":         len(nodes),
+		"node_count_after":   len(bins),
+		"node_cpu":           size.CPU,
+		"node_memory_gib":    round(size.Memory/(1<<30), 1),
+		"monthly_cost":       round(cost, 2),
+		"monthly_cost_after": round(newCost, 2),
+		"monthly_savings":    round(savings, 2),
+		"nodes":              items,
+	}
+	switch {
+	case len(oversized) > 0:
+		result["warning"] = fmt.Sprintf("%d pods do not fit on a node of this size, such as %s", len(oversized), oversized[0])
+		result["hint"] = "Simulate larger nodes with --node-cpu and --node-memory"
+	case len(pinned) > 0:
+		result["warning"] = fmt.Sprintf("%d pods select nodes by label, such as %s, which the new nodes are assumed to match", len(pinned), pinned[0])
+	}
+	return result
+}
@@ -0,0 +1,148 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// SharedFixed charges each group a fixed overhead for idle capacity and
+// shared namespaces instead of spreading their cost
+const SharedFixed = "fixed"
+
+// ParseSharedPolicy parses a policy for the shared cost of chargeback
+func ParseSharedPolicy(value string) (string, error) {
+	switch value {
+	case IdleProportional, IdleEven, SharedFixed:
+		return value, nil
+	}
+	return "", clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+		fmt.Sprintf("invalid shared cost policy %q (expected %s, %s or %s)", value, IdleProportional, IdleEven, SharedFixed))
+}
+
+// ChargebackOptions configure the invoices of a chargeback
+type ChargebackOptions struct {
+	// By is the dimension pods are invoiced by, e.g. label:team
+	By Dimension
+	// Shared is the policy for idle capacity and shared namespaces:
+	// proportional, even or fixed
+	Shared string
+	// SharedNamespaces hold pods that serve the whole cluster, such as
+	// kube-system
+	SharedNamespaces []string
+	// Overhead is what the fixed policy charges each group: a monthly
+	// amount, or a percentage of its direct cost if OverheadPercent is set
+	Overhead        float64
+	OverheadPercent bool
+	// Prices are used to price nodes by their capacity and pods by their
+	// requests
+	Prices ComputePrices
+	// Start and End are the period invoiced
+	Start time.Time
+	End   time.Time
+}
+
+// Chargeback invoices the cost of the cluster over a period to the values of
+// a dimension. Each invoice has a line per workload, priced by its requests,
+// and the share of idle capacity and shared namespaces charged by the shared
+// policy. Costs are those of the current requests and capacity projected
+// over the period.
+func (c *Client) Chargeback(ctx context.Context, opts ChargebackOptions) (map[string]interface{}, error) {
+	a, err := c.allocate(ctx, AllocationOptions{By: opts.By, SharedNamespaces: opts.SharedNamespaces, Prices: opts.Prices})
+	if err != nil {
+		return nil, err
+	}
+	hours := opts.End.Sub(opts.Start).Hours()
+	scale := hours / month.Hours()
+
+	list := a.groups
+	if opts.Shared == SharedFixed {
+		for _, g := range list {
+			if opts.OverheadPercent {
+				g.shared = g.direct * opts.Overhead / 100
+			} else {
+				g.shared = opts.Overhead
+			}
+		}
+	} else {
+		list = a.spread(opts.Shared)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+
+	invoices := make([]interface{}, len(list))
+	var charged float64
+	for i, g := range list {
+		total := g.direct + g.idle + g.shared
+		charged += total
+		invoices[i] = map[string]interface{}{
+			"group":        g.name,
+			"pods":         g.pods,
+			"direct_cost":  round(g.direct*scale, 2),
+			"shared_cost":  round((g.idle+g.shared)*scale, 2),
+			"total_cost":   round(total*scale, 2),
+			"cost_percent": percent(total, a.clusterCost),
+			"lines":        invoiceLines(g, scale),
+		}
+	}
+
+	sharedCost := a.idle + a.shared.direct
+	result := map[string]interface{}{
+		"message": fmt.Sprintf("%d invoices by %s for %s to %s, %.2f of %.2f cluster cost charged with shared cost %s",
+			len(invoices), opts.By, opts.Start.Format("2006-01-02"), opts.End.Format("2006-01-02"), charged*scale, a.clusterCost*scale, opts.Shared),
+		"context":           c.kube.Context,
+		"by":                opts.By.String(),
+		"shared_policy":     opts.Shared,
+		"shared_namespaces": opts.SharedNamespaces,
+		"period_start":      opts.Start.Format(time.RFC3339),
+		"period_end":        opts.End.Format(time.RFC3339),
+		"hours":             round(hours, 1),
+		"cluster_cost":      round(a.clusterCost*scale, 2),
+		"idle_cost":         round(a.idle*scale, 2),
+		"shared_cost":       round(a.shared.direct*scale, 2),
+		"charged_cost":      round(charged*scale, 2),
+		"invoices":          invoices,
+	}
+	if opts.Shared == SharedFixed {
+		// The overhead rarely matches the shared cost, so report what the
+		// cluster owner recovers too little, or too much when negative
+		unrecovered := sharedCost
+		for _, g := range list {
+			unrecovered -= g.shared
+		}
+		result["unrecovered_cost"] = round(unrecovered*scale, 2)
+	}
+	return result, nil
+}
+
+// invoiceLines returns the workloads of a group priced over the period,
+// the most expensive first
+func invoiceLines(g *allocationGroup, scale float64) []interface{} {
+	workloads := make([]*workloadCost, 0, len(g.workloads))
+	for _, w := range g.workloads {
+		workloads = append(workloads, w)
+	}
+	sort.Slice(workloads, func(i, j int) bool {
+		if workloads[i].cost != workloads[j].cost {
+			return workloads[i].cost > workloads[j].cost
+		}
+		if workloads[i].namespace != workloads[j].namespace {
+			return workloads[i].namespace < workloads[j].namespace
+		}
+		return workloads[i].kind+"/"+workloads[i].name < workloads[j].kind+"/"+workloads[j].name
+	})
+	lines := make([]interface{}, len(workloads))
+	for i, w := range workloads {
+		lines[i] = map[string]interface{}{
+			"namespace":       w.namespace,
+			"workload":        w.kind + "/" + w.name,
+			"pods":            w.pods,
+			"cpu_requests":    round(w.requests.CPU, 3),
+			"memory_requests": formatMemory(w.requests.Memory),
+			"cost":            round(w.cost*scale, 2),
+		}
+	}
+	return lines
+}
@@ -0,0 +1,62 @@
+// Package native implements a core subset of UPID commands in Go, using the
+// Kubernetes API and metrics-server directly. It is used when the Python
+// runtime is not installed, so that a standalone binary remains useful.
+package native
+
+import (
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/prometheus"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Client talks to the cluster of one kubeconfig context
+type Client struct {
+	kube    *kube.Client
+	history *prometheus.Client
+	// exclusions select the workloads optimizations leave alone, besides
+	// annotated ones
+	exclusions *kube.Exclusions
+	// guardrails are checked before optimizations change workloads
+	guardrails *Guardrails
+}
+
+// LoadKubeconfig reads the kubeconfig files named by $KUBECONFIG, or
+// ~/.kube/config
+func LoadKubeconfig() (*clientcmdapi.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	kubeconfig, err := rules.Load()
+	if err != nil {
+		return nil, clierr.Wrap(err, clierr.CategoryUsage, "KUBECONFIG_INVALID", "failed to load kubeconfig").
+			WithHint("Check the files named by $KUBECONFIG or ~/.kube/config")
+	}
+	return kubeconfig, nil
+}
+
+// NewClient connects to the cluster of a kubeconfig context, the current
+// context if empty
+func NewClient(kubeContext string) (*Client, error) {
+	client, err := kube.NewClient(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{kube: client}, nil
+}
+
+// SetHistory makes analyses with a time range use the usage history of a
+// datasource instead of the current usage from metrics-server
+func (c *Client) SetHistory(history *prometheus.Client) {
+	c.history = history
+}
+
+// SetExclusions makes optimizations leave the workloads selected by
+// exclusions alone, as they do those annotated with upid.io/exclude
+func (c *Client) SetExclusions(exclusions *kube.Exclusions) {
+	c.exclusions = exclusions
+}
+
+// Context returns the kubeconfig context the client uses
+func (c *Client) Context() string {
+	return c.kube.Context
+}
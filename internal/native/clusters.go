@@ -0,0 +1,171 @@
+package native
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// probeTimeout bounds how long each cluster is probed when listing clusters
+const probeTimeout = 5 * time.Second
+
+// ListClusters lists the clusters of the kubeconfig contexts with their
+// version, size and current utilization. Only clusters with the given status
+// ("active" or "error") are listed if status is set.
+func ListClusters(ctx context.Context, status string) (map[string]interface{}, error) {
+	kubeconfig, err := LoadKubeconfig()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(kubeconfig.Contexts))
+	for name := range kubeconfig.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Probe all clusters at once, an unreachable one takes the full timeout
+	clusters := make([]map[string]interface{}, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+			defer cancel()
+			clusters[i] = probeCluster(probeCtx, name)
+			clusters[i]["current"] = name == kubeconfig.CurrentContext
+		}(i, name)
+	}
+	wg.Wait()
+
+	items := make([]interface{}, 0, len(clusters))
+	for _, cluster := range clusters {
+		if status == "" || cluster["status"] == status {
+			items = append(items, cluster)
+		}
+	}
+	return map[string]interface{}{
+		"message":  fmt.Sprintf("Found %d clusters in kubeconfig", len(items)),
+		"clusters": items,
+	}, nil
+}
+
+// probeCluster describes the cluster of a kubeconfig context
+func probeCluster(ctx context.Context, name string) map[string]interface{} {
+	cluster := map[string]interface{}{
+		"name":   name,
+		"id":     name,
+		"status": "error",
+	}
+
+	client, err := NewClient(name)
+	if err != nil {
+		cluster["error"] = err.Error()
+		return cluster
+	}
+	data, err := client.kube.Clientset.Discovery().RESTClient().Get().AbsPath("/version").DoRaw(ctx)
+	if err != nil {
+		cluster["error"] = err.Error()
+		return cluster
+	}
+	var info version.Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		cluster["error"] = fmt.Sprintf("invalid version response: %v", err)
+		return cluster
+	}
+	cluster["status"] = "active"
+	cluster["kubernetes_version"] = info.GitVersion
+
+	nodes, err := client.kube.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return cluster
+	}
+	cluster["node_count"] = len(nodes.Items)
+	if len(nodes.Items) > 0 {
+		if region := nodes.Items[0].Labels[corev1.LabelTopologyRegion]; region != "" {
+			cluster["region"] = region
+		}
+	}
+	if pods, err := client.kube.Clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{}); err == nil {
+		cluster["pod_count"] = len(pods.Items)
+	}
+
+	// Utilization is only known when metrics-server is installed
+	used, err := client.kube.NodeMetrics(ctx)
+	if err != nil {
+		return cluster
+	}
+	var allocatable, total kube.Resources
+	for _, node := range nodes.Items {
+		allocatable.CPU += node.Status.Allocatable.Cpu().AsApproximateFloat64()
+		allocatable.Memory += node.Status.Allocatable.Memory().AsApproximateFloat64()
+		total = total.Add(used[node.Name])
+	}
+	cluster["cpu_utilization"] = percent(total.CPU, allocatable.CPU)
+	cluster["memory_utilization"] = percent(total.Memory, allocatable.Memory)
+	return cluster
+}
+
+// percent returns part as a rounded percentage of whole, nil if whole is 0
+func percent(part, whole float64) interface{} {
+	if whole == 0 {
+		return nil
+	}
+	return round(100*part/whole, 1)
+}
+
+// Reachability is the result of probing the API server of a kubeconfig
+// context
+type Reachability struct {
+	Reachable bool
+	Version   string
+	Latency   time.Duration
+	Error     string
+}
+
+// ProbeContexts checks concurrently whether the API servers of kubeconfig
+// contexts answer. An empty path reads the files named by $KUBECONFIG, or
+// ~/.kube/config.
+func ProbeContexts(ctx context.Context, path string, contexts []KubeContext) []Reachability {
+	results := make([]Reachability, len(contexts))
+	var wg sync.WaitGroup
+	for i, kubeContext := range contexts {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+			defer cancel()
+			results[i] = probeVersion(probeCtx, path, name)
+		}(i, kubeContext.Name)
+	}
+	wg.Wait()
+	return results
+}
+
+// probeVersion reads the version of the API server of a context
+func probeVersion(ctx context.Context, path, name string) Reachability {
+	client, err := kube.NewClientFromKubeconfig(path, name)
+	if err != nil {
+		return Reachability{Error: clierr.From(err).Message}
+	}
+	started := time.Now()
+	data, err := client.Clientset.Discovery().RESTClient().Get().AbsPath("/version").DoRaw(ctx)
+	if err != nil {
+		return Reachability{Error: err.Error()}
+	}
+	var info version.Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return Reachability{Error: fmt.Sprintf("invalid version response: %v", err)}
+	}
+	return Reachability{Reachable: true, Version: info.GitVersion, Latency: time.Since(started)}
+}
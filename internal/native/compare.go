@@ -0,0 +1,148 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// lowEfficiency is the share of requests used below which a cluster needs
+// attention, in percent
+const lowEfficiency = 50
+
+// CompareClusters reports the clusters of clients side by side: their
+// efficiency (the share of the cost of requests that is used), cost per
+// workload and waste (the savings of the recommendations of their summary
+// report). Clusters are reported at once; one that fails is listed with its
+// error. A cluster needs attention when its efficiency is below 50%, or its
+// waste or cost per workload is above the average of the fleet.
+func CompareClusters(ctx context.Context, clients []*Client, opts ReportOptions) map[string]interface{} {
+	reports := make([]map[string]interface{}, len(clients))
+	errs := make([]error, len(clients))
+	names := make([]string, len(clients))
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		names[i] = client.Context()
+		wg.Add(1)
+		go func(i int, client *Client) {
+			defer wg.Done()
+			reports[i], errs[i] = client.Report(ctx, opts)
+		}(i, client)
+	}
+	wg.Wait()
+	return CompareReports(names, reports, errs)
+}
+
+// CompareReports compares clusters by their summary reports, as
+// CompareClusters does, given the context, report and error of each
+func CompareReports(names []string, reports []map[string]interface{}, errs []error) map[string]interface{} {
+	var monthly, requested, used, savings float64
+	var workloads, compared int
+	for i, report := range reports {
+		if errs[i] != nil {
+			continue
+		}
+		compared++
+		monthly += report["monthly_cost"].(float64)
+		requested += report["requested_cost"].(float64)
+		used += report["used_cost"].(float64)
+		savings += report["savings"].(float64)
+		workloads += report["workloads"].(int)
+	}
+	fleetWaste := percent(savings, monthly)
+	fleetPerWorkload := perWorkload(monthly, workloads)
+
+	clusters := make([]interface{}, 0, len(names))
+	attention := []string{}
+	for i, report := range reports {
+		name := names[i]
+		if errs[i] != nil {
+			clusters = append(clusters, map[string]interface{}{"cluster": name, "status": "error", "error": errs[i].Error()})
+			continue
+		}
+		cost := report["monthly_cost"].(float64)
+		count := report["workloads"].(int)
+		efficiency := percent(report["used_cost"].(float64), report["requested_cost"].(float64))
+		waste := percent(report["savings"].(float64), cost)
+		costPerWorkload := perWorkload(cost, count)
+
+		var reasons []string
+		if e, ok := efficiency.(float64); ok && e < lowEfficiency {
+			reasons = append(reasons, "low efficiency")
+		}
+		if w, ok := waste.(float64); ok && compared > 1 && w > fleetWaste.(float64) {
+			reasons = append(reasons, "waste above average")
+		}
+		if c, ok := costPerWorkload.(float64); ok && compared > 1 && c > fleetPerWorkload.(float64) {
+			reasons = append(reasons, "cost per workload above average")
+		}
+		if len(reasons) > 0 {
+			attention = append(attention, name)
+		}
+		var recommendations int
+		for _, item := range report["recommendations"].([]interface{}) {
+			recommendations += item.(map[string]interface{})["recommendations"].(int)
+		}
+		clusters = append(clusters, map[string]interface{}{
+			"cluster":           name,
+			"status":            "ok",
+			"usage":             report["usage"],
+			"pods":              report["pods"],
+			"workloads":         count,
+			"monthly_cost":      cost,
+			"requested_cost":    report["requested_cost"],
+			"used_cost":         report["used_cost"],
+			"idle_cost":         report["idle_cost"],
+			"efficiency":        efficiency,
+			"cost_per_workload": costPerWorkload,
+			"waste":             report["savings"],
+			"waste_percent":     waste,
+			"recommendations":   recommendations,
+			"attention":         strings.Join(reasons, ", "),
+		})
+	}
+	// Those that need attention first, by waste
+	needs := func(i int) bool {
+		reasons, _ := clusters[i].(map[string]interface{})["attention"].(string)
+		return reasons != ""
+	}
+	wasteOf := func(i int) float64 {
+		w, _ := clusters[i].(map[string]interface{})["waste"].(float64)
+		return w
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		if needs(i) != needs(j) {
+			return needs(i)
+		}
+		return wasteOf(i) > wasteOf(j)
+	})
+
+	message := fmt.Sprintf("Compared %d clusters, %.2f per month with %.2f per month of waste", compared, monthly, savings)
+	if len(attention) > 0 {
+		message += fmt.Sprintf("; %s need attention", strings.Join(attention, ", "))
+	}
+	return map[string]interface{}{
+		"message": message,
+		"fleet": map[string]interface{}{
+			"clusters":          compared,
+			"workloads":         workloads,
+			"monthly_cost":      round(monthly, 2),
+			"efficiency":        percent(used, requested),
+			"cost_per_workload": fleetPerWorkload,
+			"waste":             round(savings, 2),
+			"waste_percent":     fleetWaste,
+		},
+		"clusters":  clusters,
+		"attention": attention,
+	}
+}
+
+// perWorkload returns the monthly cost of a workload, nil without workloads
+func perWorkload(cost float64, workloads int) interface{} {
+	if workloads == 0 {
+		return nil
+	}
+	return round(cost/float64(workloads), 2)
+}
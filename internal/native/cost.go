@@ -0,0 +1,236 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/kubilitics/upid-cli/internal/billing"
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// Pricing of resources in cost analyses
+const (
+	pricingList   = "list"
+	pricingBilled = "billed"
+)
+
+// Default list prices in USD of resources other than compute
+const (
+	// DefaultStoragePrice is per GiB-month of persistent volumes, as for
+	// general purpose SSDs
+	DefaultStoragePrice = 0.08
+	// DefaultLoadBalancerPrice is per hour of a load balancer
+	DefaultLoadBalancerPrice = 0.0225
+)
+
+// CostOptions configure how the cost of the cluster is analyzed
+type CostOptions struct {
+	// Prices are the list prices of node capacity
+	Prices ComputePrices
+	// StoragePrice is the list price of a GiB-month of persistent volumes
+	StoragePrice float64
+	// LoadBalancerPrice is the list price of an hour of a load balancer
+	LoadBalancerPrice float64
+	// Billed are the costs billed by the cloud provider, which replace list
+	// prices for the resources they include; nil to use list prices only
+	Billed *billing.Costs
+	// Detailed lists each node, volume and load balancer
+	Detailed bool
+}
+
+// costCategory is the cost of one kind of resource
+type costCategory struct {
+	name      string
+	resources int
+	cost      float64
+	list      float64
+	// billed counts the resources priced from billing, which cost
+	// billedCost and would cost billedList at list prices
+	billed     int
+	billedCost float64
+	billedList float64
+}
+
+// price is the monthly cost of a resource, billed if known
+type price struct {
+	pricing string
+	cost    float64
+	list    float64
+	// covered is the percentage of the billed cost covered by Reserved
+	// Instances or Savings Plans, nil if none or unknown
+	covered interface{}
+}
+
+// AnalyzeCost reports the monthly cost of the nodes, persistent volumes and
+// load balancers of the cluster. Resources are priced at list prices, nodes
+// by their capacity; with billed costs, those of the EC2 instances, EBS
+// volumes and load balancers they include replace list prices, projected to
+// a month from the hours each was used.
+func (c *Client) AnalyzeCost(ctx context.Context, opts CostOptions) (map[string]interface{}, error) {
+	snapshot, err := c.kube.Collect(ctx, kube.CollectOptions{RunningOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := c.kube.PersistentVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	services, err := c.kube.LoadBalancers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	billed := map[string]*billing.Resource{}
+	loadBalancers := map[string]*billing.Resource{}
+	if opts.Billed != nil {
+		billed = opts.Billed.Resources
+		loadBalancers = opts.Billed.LoadBalancers()
+	}
+
+	compute := &costCategory{name: "compute"}
+	nodes := make([]interface{}, 0, len(snapshot.Nodes))
+	for i := range snapshot.Nodes {
+		node := &snapshot.Nodes[i]
+		id := kube.InstanceID(node.Object)
+		p := priceOf(billed[id], nodeCost(node.Capacity, opts.Prices))
+		compute.add(p)
+		nodes = append(nodes, costRecord(map[string]interface{}{
+			"name":          node.Name,
+			"instance_type": node.InstanceType,
+			"zone":          node.Zone,
+			"resource_id":   id,
+		}, p))
+	}
+
+	storage := &costCategory{name: "storage"}
+	volumeRecords := make([]interface{}, 0, len(volumes))
+	for i := range volumes {
+		volume := &volumes[i]
+		size := volume.Spec.Capacity.Storage().AsApproximateFloat64() / (1 << 30)
+		id := kube.VolumeID(volume)
+		p := priceOf(billed[id], size*opts.StoragePrice)
+		storage.add(p)
+		record := map[string]interface{}{
+			"name":          volume.Name,
+			"storage_class": volume.Spec.StorageClassName,
+			"size_gib":      round(size, 1),
+			"resource_id":   id,
+		}
+		if claim := volume.Spec.ClaimRef; claim != nil {
+			record["claim"] = claim.Namespace + "/" + claim.Name
+		}
+		volumeRecords = append(volumeRecords, costRecord(record, p))
+	}
+
+	balancing := &costCategory{name: "load-balancing"}
+	serviceRecords := make([]interface{}, 0, len(services))
+	for i := range services {
+		service := &services[i]
+		name := kube.LoadBalancerName(service)
+		p := priceOf(loadBalancers[name], opts.LoadBalancerPrice*month.Hours())
+		balancing.add(p)
+		serviceRecords = append(serviceRecords, costRecord(map[string]interface{}{
+			"name":          service.Name,
+			"namespace":     service.Namespace,
+			"load_balancer": name,
+		}, p))
+	}
+
+	var total, list, billedCost, billedList float64
+	var resources, billedResources int
+	breakdown := make([]interface{}, 0, 3)
+	for _, category := range []*costCategory{compute, storage, balancing} {
+		total += category.cost
+		list += category.list
+		resources += category.resources
+		billedResources += category.billed
+		billedCost += category.billedCost
+		billedList += category.billedList
+		breakdown = append(breakdown, map[string]interface{}{
+			"category":     category.name,
+			"resources":    category.resources,
+			"billed":       category.billed,
+			"monthly_cost": round(category.cost, 2),
+			"list_cost":    round(category.list, 2),
+		})
+	}
+
+	result := map[string]interface{}{
+		"context":      c.kube.Context,
+		"pricing":      pricingList,
+		"monthly_cost": round(total, 2),
+		"list_cost":    round(list, 2),
+		"breakdown":    breakdown,
+		"message": fmt.Sprintf("%.2f per month for %d nodes, %d persistent volumes and %d load balancers at list prices",
+			total, len(nodes), len(volumeRecords), len(serviceRecords)),
+	}
+	if opts.Billed != nil {
+		result["pricing"] = opts.Billed.Source
+		result["billing_period"] = opts.Billed.Start.Format("2006-01-02") + " to " + opts.Billed.End.Format("2006-01-02")
+		result["billed_resources"] = billedResources
+		result["billed_cost"] = round(billedCost, 2)
+		// What list prices get wrong for the billed resources, positive if
+		// they overestimate
+		result["list_difference"] = round(billedList-billedCost, 2)
+		result["message"] = fmt.Sprintf("%.2f per month for %d nodes, %d persistent volumes and %d load balancers, %d of %d resources priced from %s billing",
+			total, len(nodes), len(volumeRecords), len(serviceRecords), billedResources, resources, opts.Billed.Source)
+		if billedResources == 0 && resources > 0 {
+			result["warning"] = "no resource of the cluster is in the billing data, check that it covers the account and period of the cluster"
+		} else if billedResources < resources {
+			result["warning"] = fmt.Sprintf("%d resources are not in the billing data and are priced at list prices", resources-billedResources)
+		}
+	}
+	if opts.Detailed {
+		for _, records := range [][]interface{}{nodes, volumeRecords, serviceRecords} {
+			sortByCost(records)
+		}
+		result["nodes"] = nodes
+		result["volumes"] = volumeRecords
+		result["load_balancers"] = serviceRecords
+	}
+	return result, nil
+}
+
+// priceOf prices a resource at its billed cost if it was billed, else at
+// its list price
+func priceOf(r *billing.Resource, list float64) price {
+	if r == nil {
+		return price{pricing: pricingList, cost: list, list: list}
+	}
+	p := price{pricing: pricingBilled, cost: r.Hourly() * month.Hours(), list: list}
+	if r.Covered > 0 {
+		p.covered = percent(r.Covered, r.Cost)
+	}
+	return p
+}
+
+// add counts a resource of the category
+func (c *costCategory) add(p price) {
+	c.resources++
+	c.cost += p.cost
+	c.list += p.list
+	if p.pricing == pricingBilled {
+		c.billed++
+		c.billedCost += p.cost
+		c.billedList += p.list
+	}
+}
+
+// costRecord adds the price of a resource to its record
+func costRecord(record map[string]interface{}, p price) map[string]interface{} {
+	record["pricing"] = p.pricing
+	record["monthly_cost"] = round(p.cost, 2)
+	record["list_cost"] = round(p.list, 2)
+	if p.covered != nil {
+		record["covered_percent"] = p.covered
+	}
+	return record
+}
+
+// sortByCost orders records by their monthly cost, highest first
+func sortByCost(records []interface{}) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].(map[string]interface{})["monthly_cost"].(float64) > records[j].(map[string]interface{})["monthly_cost"].(float64)
+	})
+}
@@ -0,0 +1,285 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/prometheus"
+)
+
+// ComputePrices are the costs of requested compute
+type ComputePrices struct {
+	// CPU is per core-hour
+	CPU float64
+	// Memory is per GiB-hour
+	Memory float64
+	// GPU is per GPU-hour
+	GPU float64 `json:",omitempty"`
+}
+
+// DefaultComputePrices are typical public cloud on-demand prices in USD,
+// split from general purpose instances. GPUs are not priced by default.
+var DefaultComputePrices = ComputePrices{CPU: 0.0316, Memory: 0.0042}
+
+// hourly is the cost of an hour of resources
+func (p ComputePrices) hourly(r kube.Resources) float64 {
+	return r.CPU*p.CPU + r.Memory/(1<<30)*p.Memory + r.GPU*p.GPU
+}
+
+// A workload got more expensive if its monthly cost rose by more than
+// costIncreaseThreshold and minCostChange, and regressed if the share of its
+// requests it used fell by more than efficiencyRegression percentage points
+const (
+	costIncreaseThreshold = 0.1
+	minCostChange         = 1.0
+	efficiencyRegression  = 10
+)
+
+// Workload changes found by AnalyzeDiff
+const (
+	diffMoreExpensive = "more-expensive"
+	diffRegressed     = "regressed"
+	diffNewlyIdle     = "newly-idle"
+	diffNew           = "new"
+	diffRemoved       = "removed"
+)
+
+// Pod names end in suffixes their controllers generate from this alphabet:
+// a random one per pod and a template hash per ReplicaSet
+var (
+	podSuffix      = regexp.MustCompile(`-[bcdfghjklmnpqrstvwxz2456789]{5}$`)
+	templateHash   = regexp.MustCompile(`-[bcdfghjklmnpqrstvwxz2456789]{6,10}$`)
+	numericSuffix  = regexp.MustCompile(`-[0-9]+$`)
+	scheduleSuffix = regexp.MustCompile(`-[0-9]{8,}$`)
+)
+
+// Period is a span of time analyses compare
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+// String formats a period as its start and end
+func (p Period) String() string {
+	return p.Start.UTC().Format(time.RFC3339) + ".." + p.End.UTC().Format(time.RFC3339)
+}
+
+// periodUsage is the usage of the pods of a workload over a period
+type periodUsage struct {
+	prometheus.PodPeriod
+	seconds float64
+}
+
+// add adds the usage of a pod
+func (p *periodUsage) add(pod *prometheus.PodPeriod) {
+	p.CPUUsed += pod.CPUUsed
+	p.CPURequested += pod.CPURequested
+	p.MemoryUsed += pod.MemoryUsed
+	p.MemoryRequested += pod.MemoryRequested
+	p.NetworkPeak = math.Max(p.NetworkPeak, pod.NetworkPeak)
+}
+
+// monthlyCost is the cost of the requests, or of the usage without
+// requests, at the rate of the period
+func (p *periodUsage) monthlyCost(prices ComputePrices, requests bool) float64 {
+	cpu, memory := p.CPUUsed, p.MemoryUsed
+	if requests {
+		cpu, memory = p.CPURequested, p.MemoryRequested
+	}
+	cost := cpu/3600*prices.CPU + memory/(1<<30)/3600*prices.Memory
+	return cost * month.Seconds() / p.seconds
+}
+
+// idle reports whether the workload used under 5% of its CPU request, or
+// next to no CPU without one, and had little network traffic
+func (p *periodUsage) idle() bool {
+	if p.NetworkPeak > maxIdleTraffic {
+		return false
+	}
+	if p.CPURequested > 0 {
+		return p.CPUUsed < idleThreshold*p.CPURequested
+	}
+	return p.CPUUsed/p.seconds < minIdleCPU
+}
+
+// AnalyzeDiff compares the usage of the workloads in namespace (all if
+// empty) between two periods from the datasource, and reports workloads that
+// got more expensive, regressed in efficiency, became idle, appeared or
+// disappeared. Costs are those of the requests, or of the usage if the
+// datasource has no kube-state-metrics, projected to a month so that
+// periods of different lengths compare. Workloads are recognized by the
+// names their controllers give their pods.
+func (c *Client) AnalyzeDiff(ctx context.Context, namespace string, from, to Period, prices ComputePrices) (map[string]interface{}, error) {
+	if c.history == nil {
+		return nil, clierr.New(clierr.CategoryUsage, "DATASOURCE_NOT_CONFIGURED", "past usage is only known from a datasource").
+			WithHint("Configure one with 'upid config datasource set --url <url>'")
+	}
+
+	before, beforeRequests, err := c.workloadPeriod(ctx, namespace, from)
+	if err != nil {
+		return nil, err
+	}
+	after, afterRequests, err := c.workloadPeriod(ctx, namespace, to)
+	if err != nil {
+		return nil, err
+	}
+	requests := beforeRequests && afterRequests
+
+	keys := map[string]bool{}
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	var items []map[string]interface{}
+	counts := map[string]int{}
+	unchanged := 0
+	var beforeCost, afterCost float64
+	for key := range keys {
+		b, a := before[key], after[key]
+		ns, name, _ := strings.Cut(key, "/")
+		item := map[string]interface{}{
+			"name":      name,
+			"namespace": ns,
+		}
+		var findings []string
+		var oldCost, newCost float64
+		if b != nil {
+			oldCost = b.monthlyCost(prices, requests)
+			item["from_monthly_cost"] = round(oldCost, 2)
+			item["from_cpu_cores"] = round(b.CPUUsed/b.seconds, 3)
+			if requests {
+				item["from_cpu_efficiency"] = percent(b.CPUUsed, b.CPURequested)
+				item["from_memory_efficiency"] = percent(b.MemoryUsed, b.MemoryRequested)
+			}
+		}
+		if a != nil {
+			newCost = a.monthlyCost(prices, requests)
+			item["to_monthly_cost"] = round(newCost, 2)
+			item["to_cpu_cores"] = round(a.CPUUsed/a.seconds, 3)
+			if requests {
+				item["to_cpu_efficiency"] = percent(a.CPUUsed, a.CPURequested)
+				item["to_memory_efficiency"] = percent(a.MemoryUsed, a.MemoryRequested)
+			}
+		}
+		beforeCost += oldCost
+		afterCost += newCost
+		item["cost_change"] = round(newCost-oldCost, 2)
+		item["cost_change_percent"] = percent(newCost-oldCost, oldCost)
+
+		switch {
+		case b == nil:
+			findings = append(findings, diffNew)
+		case a == nil:
+			findings = append(findings, diffRemoved)
+		default:
+			if newCost-oldCost > minCostChange && newCost > oldCost*(1+costIncreaseThreshold) {
+				findings = append(findings, diffMoreExpensive)
+			}
+			if requests && (efficiencyDrop(b.CPUUsed, b.CPURequested, a.CPUUsed, a.CPURequested) > efficiencyRegression ||
+				efficiencyDrop(b.MemoryUsed, b.MemoryRequested, a.MemoryUsed, a.MemoryRequested) > efficiencyRegression) {
+				findings = append(findings, diffRegressed)
+			}
+			if a.idle() && !b.idle() {
+				findings = append(findings, diffNewlyIdle)
+			}
+		}
+		if len(findings) == 0 {
+			unchanged++
+			continue
+		}
+		for _, finding := range findings {
+			counts[finding]++
+		}
+		item["changes"] = strings.Join(findings, ",")
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i]["cost_change"].(float64), items[j]["cost_change"].(float64)
+		if a != b {
+			return a > b
+		}
+		return items[i]["namespace"].(string)+"/"+items[i]["name"].(string) < items[j]["namespace"].(string)+"/"+items[j]["name"].(string)
+	})
+	list := make([]interface{}, len(items))
+	for i, item := range items {
+		list[i] = item
+	}
+
+	result := map[string]interface{}{
+		"message": fmt.Sprintf("Compared %d workloads: %d more expensive, %d regressed, %d newly idle, %d new, %d removed; monthly cost from %.2f to %.2f",
+			len(keys), counts[diffMoreExpensive], counts[diffRegressed], counts[diffNewlyIdle], counts[diffNew], counts[diffRemoved], beforeCost, afterCost),
+		"context":           c.kube.Context,
+		"datasource":        c.history.URL(),
+		"from":              from.String(),
+		"to":                to.String(),
+		"from_monthly_cost": round(beforeCost, 2),
+		"to_monthly_cost":   round(afterCost, 2),
+		"cost_change":       round(afterCost-beforeCost, 2),
+		"more_expensive":    counts[diffMoreExpensive],
+		"regressed":         counts[diffRegressed],
+		"newly_idle":        counts[diffNewlyIdle],
+		"new":               counts[diffNew],
+		"removed":           counts[diffRemoved],
+		"unchanged":         unchanged,
+		"workloads":         list,
+	}
+	if !requests {
+		result["warning"] = "no requests from kube-state-metrics in both periods, costs are those of the usage and efficiency is unknown"
+		result["hint"] = "Scrape kube-state-metrics for kube_pod_container_resource_requests"
+	}
+	return result, nil
+}
+
+// workloadPeriod returns the usage of the workloads in namespace over a
+// period keyed by "namespace/name", and whether requests are known
+func (c *Client) workloadPeriod(ctx context.Context, namespace string, period Period) (map[string]*periodUsage, bool, error) {
+	pods, requests, err := c.history.PeriodUsage(ctx, namespace, period.Start, period.End)
+	if err != nil {
+		return nil, false, err
+	}
+	seconds := period.End.Sub(period.Start).Seconds()
+	workloads := map[string]*periodUsage{}
+	for key, pod := range pods {
+		ns, name, _ := strings.Cut(key, "/")
+		key = ns + "/" + podWorkload(name)
+		if workloads[key] == nil {
+			workloads[key] = &periodUsage{seconds: seconds}
+		}
+		workloads[key].add(pod)
+	}
+	return workloads, requests, nil
+}
+
+// podWorkload returns the name of the workload of a pod from the pod name:
+// Deployment pods are named <name>-<template hash>-<suffix>, CronJob pods
+// <name>-<schedule time>-<suffix>, DaemonSet and Job pods <name>-<suffix>
+// and StatefulSet pods <name>-<ordinal>
+func podWorkload(pod string) string {
+	name := podSuffix.ReplaceAllString(pod, "")
+	if name == pod {
+		return numericSuffix.ReplaceAllString(pod, "")
+	}
+	if workload := scheduleSuffix.ReplaceAllString(name, ""); workload != name {
+		return workload
+	}
+	return templateHash.ReplaceAllString(name, "")
+}
+
+// efficiencyDrop is the fall in percentage points of the share of requests
+// used, 0 if either is unknown
+func efficiencyDrop(usedBefore, requestedBefore, usedAfter, requestedAfter float64) float64 {
+	if requestedBefore == 0 || requestedAfter == 0 {
+		return 0
+	}
+	return 100 * (usedBefore/requestedBefore - usedAfter/requestedAfter)
+}
@@ -0,0 +1,114 @@
+package native
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// ebsProvisioners are the provisioners of storage classes of EBS volumes,
+// the CSI driver and the in-tree plugin
+var ebsProvisioners = map[string]bool{"ebs.csi.aws.com": true, "kubernetes.io/aws-ebs": true}
+
+// ebsVolumeType is the price and performance of an EBS volume type, at the
+// us-east-1 list prices in USD
+type ebsVolumeType struct {
+	// price is the price of a GB-month
+	price float64
+	// iopsPrice and throughputPrice are the prices of a provisioned IOPS and
+	// MiB/s per month beyond those included
+	iopsPrice, throughputPrice float64
+	// includedIOPS and includedThroughput come with the volume
+	includedIOPS, includedThroughput float64
+	// provisioned is true for types whose IOPS are set by the class, io1
+	// and io2
+	provisioned bool
+}
+
+var ebsVolumeTypes = map[string]ebsVolumeType{
+	"gp2": {price: 0.10},
+	"gp3": {price: 0.08, iopsPrice: 0.005, throughputPrice: 0.04, includedIOPS: 3000, includedThroughput: 125},
+	"io1": {price: 0.125, iopsPrice: 0.065, provisioned: true},
+	"io2": {price: 0.125, iopsPrice: 0.065, provisioned: true},
+	"st1": {price: 0.045},
+	"sc1": {price: 0.015},
+}
+
+// volumePerformance is the baseline IOPS and throughput in MiB/s of a
+// volume, and its monthly cost
+type volumePerformance struct {
+	volumeType string
+	iops       float64
+	throughput float64
+	cost       float64
+}
+
+// ebsPerformance returns the performance and cost of an EBS volume of size
+// GiB of a storage class, false if the class does not provision EBS volumes
+// of a known type
+func ebsPerformance(class storagev1.StorageClass, size float64) (volumePerformance, bool) {
+	if !ebsProvisioners[class.Provisioner] {
+		return volumePerformance{}, false
+	}
+	volumeType := strings.ToLower(classParameter(class, "type"))
+	if volumeType == "" {
+		// The default of the CSI driver, gp2 for the in-tree plugin
+		volumeType = "gp3"
+		if class.Provisioner == "kubernetes.io/aws-ebs" {
+			volumeType = "gp2"
+		}
+	}
+	t, ok := ebsVolumeTypes[volumeType]
+	if !ok {
+		return volumePerformance{}, false
+	}
+	p := volumePerformance{volumeType: volumeType}
+	iops, _ := strconv.ParseFloat(classParameter(class, "iops"), 64)
+	if perGB, _ := strconv.ParseFloat(classParameter(class, "iopsPerGB"), 64); iops == 0 && perGB > 0 {
+		iops = perGB * size
+	}
+	throughput, _ := strconv.ParseFloat(classParameter(class, "throughput"), 64)
+	switch volumeType {
+	case "gp2":
+		// 3 IOPS per GiB, and the throughput of the burst bucket beyond
+		// 170 GiB
+		p.iops = math.Min(math.Max(3*size, 100), 16000)
+		p.throughput = 128
+		if size > 170 {
+			p.throughput = 250
+		}
+	case "gp3":
+		p.iops = math.Min(math.Max(iops, t.includedIOPS), 16000)
+		p.throughput = math.Min(math.Max(throughput, t.includedThroughput), 1000)
+	case "io1", "io2":
+		if iops == 0 {
+			iops = 100
+		}
+		p.iops = iops
+		// 256 KiB per I/O
+		p.throughput = math.Min(iops/4, 1000)
+	case "st1":
+		p.iops = 500
+		p.throughput = math.Min(40*size/1024, 500)
+	case "sc1":
+		p.iops = 250
+		p.throughput = math.Min(12*size/1024, 250)
+	}
+	p.cost = size*t.price +
+		math.Max(p.iops-t.includedIOPS, 0)*t.iopsPrice +
+		math.Max(p.throughput-t.includedThroughput, 0)*t.throughputPrice
+	return p, true
+}
+
+// classParameter returns a parameter of a storage class, whose keys the
+// EBS CSI driver matches regardless of case
+func classParameter(class storagev1.StorageClass, key string) string {
+	for k, v := range class.Parameters {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,55 @@
+package native
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+)
+
+// execInfoEnv is set by client-go when it runs a credential plugin
+const execInfoEnv = "KUBERNETES_EXEC_INFO"
+
+// ExecCredential returns the response of a client-go credential plugin that
+// authenticates with token until expiry. The API version follows the request
+// in $KUBERNETES_EXEC_INFO, defaulting to client.authentication.k8s.io/v1.
+func ExecCredential(token string, expiry time.Time) ([]byte, error) {
+	apiVersion := clientauthv1.SchemeGroupVersion.String()
+	if info := os.Getenv(execInfoEnv); info != "" {
+		var request metav1.TypeMeta
+		if err := json.Unmarshal([]byte(info), &request); err != nil {
+			return nil, clierr.Wrap(err, clierr.CategoryUsage, "INVALID_EXEC_INFO", fmt.Sprintf("invalid %s", execInfoEnv))
+		}
+		if request.APIVersion != "" {
+			apiVersion = request.APIVersion
+		}
+	}
+
+	var expiration *metav1.Time
+	if !expiry.IsZero() {
+		expiration = &metav1.Time{Time: expiry}
+	}
+
+	var credential interface{}
+	switch apiVersion {
+	case clientauthv1.SchemeGroupVersion.String():
+		credential = &clientauthv1.ExecCredential{
+			TypeMeta: metav1.TypeMeta{APIVersion: apiVersion, Kind: "ExecCredential"},
+			Status:   &clientauthv1.ExecCredentialStatus{Token: token, ExpirationTimestamp: expiration},
+		}
+	case clientauthv1beta1.SchemeGroupVersion.String():
+		credential = &clientauthv1beta1.ExecCredential{
+			TypeMeta: metav1.TypeMeta{APIVersion: apiVersion, Kind: "ExecCredential"},
+			Status:   &clientauthv1beta1.ExecCredentialStatus{Token: token, ExpirationTimestamp: expiration},
+		}
+	default:
+		return nil, clierr.New(clierr.CategoryUsage, "UNSUPPORTED_EXEC_VERSION", fmt.Sprintf("unsupported ExecCredential version %q", apiVersion)).
+			WithHint("Use apiVersion client.authentication.k8s.io/v1 in the exec section of your kubeconfig")
+	}
+	return json.Marshal(credential)
+}
@@ -0,0 +1,488 @@
+package native
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Names of the guardrails
+const (
+	GuardrailMinReplicas        = "min-replicas"
+	GuardrailMaxReduction       = "max-reduction"
+	GuardrailProtectedNamespace = "protected-namespace"
+	GuardrailBusinessHours      = "business-hours"
+	GuardrailPDB                = "pdb"
+	GuardrailHPA                = "hpa"
+)
+
+// Guardrails are the checks a change to a workload must pass before an
+// optimization makes it. A change failing one is blocked, unless Override
+// gives the reason to make it anyway, which is written to the audit log.
+type Guardrails struct {
+	// MinReplicas is the fewest replicas a workload may be scaled to
+	MinReplicas int32
+	// MaxReduction is the largest reduction of replicas or requests of one
+	// change, as a fraction, 0 for no limit
+	MaxReduction float64
+	// ProtectedNamespaces are glob patterns of namespaces whose workloads
+	// are not changed
+	ProtectedNamespaces []string
+	// BusinessHours are the windows during which no changes are made, in
+	// Timezone, local time if empty
+	BusinessHours []kube.PolicyWindow
+	Timezone      string
+	// PDB and HPA block changes that PodDisruptionBudgets do not allow and
+	// that conflict with HorizontalPodAutoscalers
+	PDB bool
+	HPA bool
+	// Override is why changes failing guardrails are made anyway
+	Override string
+	// AuditPath is the file overridden changes are appended to
+	AuditPath string
+}
+
+// Violation is a guardrail a change fails
+type Violation struct {
+	Guardrail string `json:"guardrail"`
+	Message   string `json:"message"`
+}
+
+// AuditEntry records a change made despite failing guardrails, or the
+// deletion of an orphaned volume
+type AuditEntry struct {
+	Time       time.Time   `json:"time"`
+	User       string      `json:"user"`
+	Context    string      `json:"context,omitempty"`
+	Namespace  string      `json:"namespace,omitempty"`
+	Kind       string      `json:"kind,omitempty"`
+	Name       string      `json:"name,omitempty"`
+	Action     string      `json:"action"`
+	Detail     string      `json:"detail,omitempty"`
+	Violations []Violation `json:"violations,omitempty"`
+	Reason     string      `json:"reason"`
+}
+
+// SetGuardrails makes optimizations check changes against guardrails
+// before they are made
+func (c *Client) SetGuardrails(guardrails *Guardrails) {
+	c.guardrails = guardrails
+}
+
+// DuringBusinessHours returns the violation of the business hours
+// guardrail at now, nil outside of them
+func (g *Guardrails) DuringBusinessHours(now time.Time) (*Violation, error) {
+	if g == nil || len(g.BusinessHours) == 0 {
+		return nil, nil
+	}
+	timezone := g.Timezone
+	if timezone == "" {
+		timezone = "Local"
+	}
+	active, err := scheduleActive(g.BusinessHours, timezone, now)
+	if err != nil || !active {
+		return nil, err
+	}
+	return &Violation{GuardrailBusinessHours, "changes are not made during business hours"}, nil
+}
+
+// Audit appends a change made despite failing guardrails, or a deletion, to
+// the audit log
+func (g *Guardrails) Audit(entry AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	if entry.User == "" {
+		entry.User = localUser()
+	}
+	entry.Reason = g.Override
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(g.AuditPath), 0o700); err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	f, err := os.OpenFile(g.AuditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	return nil
+}
+
+// localUser returns the name of the user running the CLI
+func localUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// change is a change to a workload checked against the guardrails
+type change struct {
+	kind      string
+	namespace string
+	name      string
+	// podLabels are the labels of the pods of the workload
+	podLabels map[string]string
+	// replicas and target are the replicas before and after scaling
+	replicas int32
+	target   int32
+	// resize is set for new requests, which reduce the requests of a
+	// container by at most reduction, as a fraction
+	resize    bool
+	reduction float64
+}
+
+// guard checks changes against the guardrails of a client, listing the
+// PodDisruptionBudgets and HPAs of each namespace once
+type guard struct {
+	c *Client
+	// hours checks the business hours, which only apply to changes made
+	// right away
+	hours   bool
+	now     time.Time
+	budgets map[string][]policyv1.PodDisruptionBudget
+	hpas    map[string][]autoscalingv2.HorizontalPodAutoscaler
+}
+
+// newGuard checks changes at now, and during business hours if hours is set
+func (c *Client) newGuard(hours bool, now time.Time) *guard {
+	return &guard{c: c, hours: hours, now: now,
+		budgets: map[string][]policyv1.PodDisruptionBudget{},
+		hpas:    map[string][]autoscalingv2.HorizontalPodAutoscaler{}}
+}
+
+// check returns the guardrails a change fails
+func (g *guard) check(ctx context.Context, ch change) ([]Violation, error) {
+	rails := g.c.guardrails
+	if rails == nil {
+		return nil, nil
+	}
+	var violations []Violation
+	if g.hours {
+		violation, err := rails.DuringBusinessHours(g.now)
+		if err != nil {
+			return nil, err
+		}
+		if violation != nil {
+			violations = append(violations, *violation)
+		}
+	}
+	for _, pattern := range rails.ProtectedNamespaces {
+		if ok, _ := path.Match(pattern, ch.namespace); ok {
+			violations = append(violations, Violation{GuardrailProtectedNamespace,
+				fmt.Sprintf("namespace %s is protected by the pattern %s", ch.namespace, pattern)})
+			break
+		}
+	}
+	if !ch.resize && ch.target < rails.MinReplicas {
+		violations = append(violations, Violation{GuardrailMinReplicas,
+			fmt.Sprintf("%d replicas is below the minimum of %d", ch.target, rails.MinReplicas)})
+	}
+	reduction := ch.reduction
+	if !ch.resize && ch.replicas > 0 {
+		reduction = float64(ch.replicas-ch.target) / float64(ch.replicas)
+	}
+	if rails.MaxReduction > 0 && reduction > rails.MaxReduction {
+		violations = append(violations, Violation{GuardrailMaxReduction,
+			fmt.Sprintf("a reduction of %.0f%% exceeds the maximum of %.0f%%", reduction*100, rails.MaxReduction*100)})
+	}
+	if rails.PDB {
+		found, err := g.checkBudgets(ctx, ch)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, found...)
+	}
+	if rails.HPA {
+		found, err := g.checkHPAs(ctx, ch)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, found...)
+	}
+	return violations, nil
+}
+
+// checkBudgets checks a change against the PodDisruptionBudgets covering the
+// pods of the workload. Scaling must keep their minimum available pods, and
+// new requests, which restart the pods, need disruptions to be allowed.
+func (g *guard) checkBudgets(ctx context.Context, ch change) ([]Violation, error) {
+	budgets, ok := g.budgets[ch.namespace]
+	if !ok {
+		var err error
+		if budgets, err = g.c.kube.DisruptionBudgets(ctx, ch.namespace); err != nil {
+			return nil, err
+		}
+		g.budgets[ch.namespace] = budgets
+	}
+
+	var violations []Violation
+	for i := range budgets {
+		budget := &budgets[i]
+		selector, err := metav1.LabelSelectorAsSelector(budget.Spec.Selector)
+		if err != nil || selector.Empty() || !selector.Matches(labels.Set(ch.podLabels)) {
+			continue
+		}
+		name := budget.Namespace + "/" + budget.Name
+		switch {
+		case ch.resize:
+			if budget.Status.DisruptionsAllowed == 0 {
+				violations = append(violations, Violation{GuardrailPDB,
+					fmt.Sprintf("PodDisruptionBudget %s allows no disruptions to restart the pods with new requests", name)})
+			}
+		case budget.Spec.MinAvailable != nil:
+			min, err := intstr.GetScaledValueFromIntOrPercent(budget.Spec.MinAvailable, int(ch.replicas), true)
+			if err == nil && int(ch.target) < min {
+				violations = append(violations, Violation{GuardrailPDB,
+					fmt.Sprintf("PodDisruptionBudget %s requires %d available pods", name, min)})
+			}
+		case budget.Spec.MaxUnavailable != nil:
+			max, err := intstr.GetScaledValueFromIntOrPercent(budget.Spec.MaxUnavailable, int(ch.replicas), true)
+			if err == nil && int(ch.replicas-ch.target) > max {
+				violations = append(violations, Violation{GuardrailPDB,
+					fmt.Sprintf("PodDisruptionBudget %s allows %d unavailable pods", name, max)})
+			}
+		}
+	}
+	return violations, nil
+}
+
+// checkHPAs checks a change against the HorizontalPodAutoscalers of the
+// workload. Scaling fights the HPA, and new requests change the utilization
+// it scales on.
+func (g *guard) checkHPAs(ctx context.Context, ch change) ([]Violation, error) {
+	hpas, ok := g.hpas[ch.namespace]
+	if !ok {
+		var err error
+		if hpas, err = g.c.kube.HPAs(ctx, ch.namespace); err != nil {
+			return nil, err
+		}
+		g.hpas[ch.namespace] = hpas
+	}
+
+	var violations []Violation
+	for i := range hpas {
+		hpa := &hpas[i]
+		if hpa.Spec.ScaleTargetRef.Kind != ch.kind || hpa.Spec.ScaleTargetRef.Name != ch.name {
+			continue
+		}
+		name := hpa.Namespace + "/" + hpa.Name
+		if !ch.resize {
+			violations = append(violations, Violation{GuardrailHPA,
+				fmt.Sprintf("HorizontalPodAutoscaler %s manages the replicas", name)})
+			continue
+		}
+		if resources := utilizationMetrics(hpa); len(resources) > 0 {
+			violations = append(violations, Violation{GuardrailHPA,
+				fmt.Sprintf("HorizontalPodAutoscaler %s scales on %s utilization, which new requests change", name, strings.Join(resources, " and "))})
+		}
+	}
+	return violations, nil
+}
+
+// utilizationMetrics returns the resources an HPA scales on as a
+// utilization of their requests. HPAs without metrics scale on CPU.
+func utilizationMetrics(hpa *autoscalingv2.HorizontalPodAutoscaler) []string {
+	if len(hpa.Spec.Metrics) == 0 {
+		return []string{"cpu"}
+	}
+	var resources []string
+	for _, metric := range hpa.Spec.Metrics {
+		var target *autoscalingv2.MetricTarget
+		var resource string
+		switch {
+		case metric.Type == autoscalingv2.ResourceMetricSourceType && metric.Resource != nil:
+			target, resource = &metric.Resource.Target, string(metric.Resource.Name)
+		case metric.Type == autoscalingv2.ContainerResourceMetricSourceType && metric.ContainerResource != nil:
+			target, resource = &metric.ContainerResource.Target, string(metric.ContainerResource.Name)
+		default:
+			continue
+		}
+		if target.Type == autoscalingv2.UtilizationMetricType {
+			resources = append(resources, resource)
+		}
+	}
+	return resources
+}
+
+// admit checks a change and returns the guardrails it fails, and whether
+// it is blocked because they are not overridden
+func (g *guard) admit(ctx context.Context, ch change) ([]Violation, bool, error) {
+	violations, err := g.check(ctx, ch)
+	if err != nil || len(violations) == 0 {
+		return nil, false, err
+	}
+	return violations, g.c.guardrails.Override == "", nil
+}
+
+// audit records a change made despite failing guardrails
+func (g *guard) audit(ctx context.Context, ch change, action string, violations []Violation) error {
+	username, _ := g.c.kube.User(ctx)
+	return g.c.guardrails.Audit(AuditEntry{
+		User:       username,
+		Context:    g.c.kube.Context,
+		Namespace:  ch.namespace,
+		Kind:       ch.kind,
+		Name:       ch.name,
+		Action:     action,
+		Violations: violations,
+	})
+}
+
+// guardWorkloads splits the workloads to scale to zero into those the
+// guardrails allow, recording the violations of overridden ones, and those
+// they block
+func (c *Client) guardWorkloads(ctx context.Context, g *guard, workloads []*workload) ([]*workload, []*workload, error) {
+	var allowed, blocked []*workload
+	for _, w := range workloads {
+		violations, block, err := g.admit(ctx, w.change(0))
+		if err != nil {
+			return nil, nil, err
+		}
+		w.violations = violations
+		if block {
+			blocked = append(blocked, w)
+			continue
+		}
+		allowed = append(allowed, w)
+	}
+	return allowed, blocked, nil
+}
+
+// change describes scaling w to target replicas
+func (w *workload) change(target int32) change {
+	return change{kind: w.kind, namespace: w.namespace, name: w.name, podLabels: w.podLabels,
+		replicas: w.replicas, target: target}
+}
+
+// guardResizes splits the containers to resize into those the guardrails
+// allow and the items of the workloads they block. The business hours do
+// not apply, as the new requests are only proposed. Overrides are audited
+// unless dryRun.
+func (c *Client) guardResizes(ctx context.Context, resizes []*containerSizing, dryRun bool) ([]*containerSizing, []interface{}, error) {
+	if c.guardrails == nil {
+		return resizes, nil, nil
+	}
+	changes := map[string]*change{}
+	var order []string
+	for _, s := range resizes {
+		key := s.namespace + "/" + s.ref.Kind + "/" + s.ref.Name
+		ch := changes[key]
+		if ch == nil {
+			ch = &change{kind: s.ref.Kind, namespace: s.namespace, name: s.ref.Name, podLabels: s.podLabels, resize: true}
+			changes[key] = ch
+			order = append(order, key)
+		}
+		ch.reduction = math.Max(ch.reduction, math.Max(
+			reduction(s.requests.CPU, s.recommended.requests.CPU),
+			reduction(s.requests.Memory, s.recommended.requests.Memory)))
+	}
+
+	g := c.newGuard(false, time.Now())
+	blocked := map[string]bool{}
+	var items []interface{}
+	for _, key := range order {
+		ch := changes[key]
+		violations, block, err := g.admit(ctx, *ch)
+		if err != nil {
+			return nil, nil, err
+		}
+		if block {
+			blocked[key] = true
+			items = append(items, blockedItem(ch.namespace, ch.kind, ch.name, violations))
+			continue
+		}
+		if len(violations) > 0 && !dryRun {
+			if err := g.audit(ctx, *ch, "propose new requests", violations); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	var allowed []*containerSizing
+	for _, s := range resizes {
+		if !blocked[s.namespace+"/"+s.ref.Kind+"/"+s.ref.Name] {
+			allowed = append(allowed, s)
+		}
+	}
+	return allowed, items, nil
+}
+
+// reduction returns how much value reduces current, as a fraction, 0 if
+// it does not
+func reduction(current, value float64) float64 {
+	if current <= 0 || value >= current {
+		return 0
+	}
+	return (current - value) / current
+}
+
+// addBlocked lists the workloads an optimization left alone because they
+// fail guardrails, if any
+func addBlocked(result map[string]interface{}, blocked []*workload) {
+	if len(blocked) == 0 {
+		return
+	}
+	items := make([]interface{}, 0, len(blocked))
+	for _, w := range blocked {
+		items = append(items, blockedItem(w.namespace, w.kind, w.name, w.violations))
+	}
+	result["blocked"] = items
+}
+
+// blockedItem describes a workload whose change fails guardrails
+func blockedItem(namespace, kind, name string, violations []Violation) map[string]interface{} {
+	return map[string]interface{}{
+		"namespace":  namespace,
+		"kind":       kind,
+		"name":       name,
+		"guardrails": guardrailNames(violations),
+		"reason":     violationMessages(violations),
+	}
+}
+
+// guardrailNames lists the guardrails of violations
+func guardrailNames(violations []Violation) string {
+	names := make([]string, len(violations))
+	for i, v := range violations {
+		names[i] = v.Guardrail
+	}
+	return strings.Join(names, ", ")
+}
+
+// violationMessages joins the messages of violations
+func violationMessages(violations []Violation) string {
+	messages := make([]string, len(violations))
+	for i, v := range violations {
+		messages[i] = v.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// overridden notes the guardrails an action overrides, if any
+func overridden(action string, violations []Violation) string {
+	if len(violations) == 0 {
+		return action
+	}
+	return fmt.Sprintf("%s, overriding %s", action, guardrailNames(violations))
+}
@@ -0,0 +1,362 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultRegistryPrice is the list price in USD of a GB-month of ECR
+// storage
+const DefaultRegistryPrice = 0.10
+
+// maxNodeImages is the most images the kubelet reports in the status of a
+// node by default (--node-status-max-images)
+const maxNodeImages = 50
+
+// ImageOptions configure the analysis of container images
+type ImageOptions struct {
+	// Namespace is the namespace of the workloads whose images are
+	// inventoried, all if empty. Nodes are analyzed whole, as images are
+	// cached for all namespaces.
+	Namespace string
+	// MaxSize is the size in bytes beyond which images are oversized
+	MaxSize float64
+	// DiskPrice is the price of a GiB-month of the disks of the nodes, and
+	// RegistryPrice of a GB-month of the registry
+	DiskPrice     float64
+	RegistryPrice float64
+}
+
+// cachedImage is an image cached on the nodes
+type cachedImage struct {
+	name string
+	size float64
+	// nodes cache it, used on some of them
+	nodes map[string]bool
+	used  map[string]bool
+	pods  int
+	// workloads run it, keyed by namespace/kind/name/container
+	workloads map[string]bool
+}
+
+// AnalyzeImages inventories the container images cached on the nodes, with
+// their size on disk, the nodes caching them and the workloads running
+// them. Images cached on a node no pod of that node runs are unused, their
+// disk reclaimable. Images larger than MaxSize are oversized, and the
+// excess priced on the disks of the nodes caching them and in the registry.
+// Sizes are those of the unpacked images, more than the compressed layers
+// registries store, which also share layers between images, so registry
+// costs are upper bounds.
+func (c *Client) AnalyzeImages(ctx context.Context, opts ImageOptions) (map[string]interface{}, error) {
+	snapshot, err := c.kube.Collect(ctx, kube.CollectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// The images on each node, by the names and digests they are known by
+	images := map[string]*cachedImage{}
+	byRef := map[string]map[string]*cachedImage{}
+	var capped []string
+	for _, node := range snapshot.Nodes {
+		status := node.Object.Status.Images
+		if len(status) >= maxNodeImages {
+			capped = append(capped, node.Name)
+		}
+		byRef[node.Name] = map[string]*cachedImage{}
+		for _, entry := range status {
+			if len(entry.Names) == 0 {
+				continue
+			}
+			refs := imageRefs(entry.Names...)
+			image := images[refs[0]]
+			if image == nil {
+				image = &cachedImage{name: displayName(entry.Names), size: float64(entry.SizeBytes),
+					nodes: map[string]bool{}, used: map[string]bool{}, workloads: map[string]bool{}}
+				images[refs[0]] = image
+			}
+			image.nodes[node.Name] = true
+			for _, ref := range refs {
+				byRef[node.Name][ref] = image
+			}
+		}
+	}
+
+	// The images the pods of each node run
+	type running struct {
+		namespace, workload, container string
+		image                          *cachedImage
+	}
+	var containers []running
+	unmatched := 0
+	for _, pod := range snapshot.Pods {
+		p := pod.Object
+		if p.Spec.NodeName == "" || p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		statuses := map[string]corev1.ContainerStatus{}
+		for _, list := range [][]corev1.ContainerStatus{p.Status.InitContainerStatuses, p.Status.ContainerStatuses} {
+			for _, status := range list {
+				statuses[status.Name] = status
+			}
+		}
+		specs := append(append([]corev1.Container{}, p.Spec.InitContainers...), p.Spec.Containers...)
+		for _, container := range specs {
+			refs := imageRefs(container.Image)
+			if status, ok := statuses[container.Name]; ok {
+				refs = append(refs, imageRefs(status.Image, status.ImageID)...)
+			}
+			var image *cachedImage
+			for _, ref := range refs {
+				if image = byRef[p.Spec.NodeName][ref]; image != nil {
+					break
+				}
+			}
+			if image == nil {
+				unmatched++
+				continue
+			}
+			image.used[p.Spec.NodeName] = true
+			if opts.Namespace != "" && p.Namespace != opts.Namespace {
+				continue
+			}
+			image.pods++
+			workload := strings.ToLower(pod.Workload.Kind) + "/" + pod.Workload.Name
+			image.workloads[p.Namespace+"/"+workload+"/"+container.Name] = true
+			containers = append(containers, running{namespace: p.Namespace, workload: workload, container: container.Name, image: image})
+		}
+	}
+
+	// Unused images by node, sandbox images aside as every pod runs one
+	type nodeImages struct {
+		name             string
+		images, unused   int
+		size, unusedSize float64
+	}
+	var nodes []*nodeImages
+	for _, node := range snapshot.Nodes {
+		n := &nodeImages{name: node.Name}
+		seen := map[*cachedImage]bool{}
+		for _, image := range byRef[node.Name] {
+			if seen[image] {
+				continue
+			}
+			seen[image] = true
+			n.images++
+			n.size += image.size
+			if !image.used[node.Name] && !sandboxImage(image.name) {
+				n.unused++
+				n.unusedSize += image.size
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].unusedSize != nodes[j].unusedSize {
+			return nodes[i].unusedSize > nodes[j].unusedSize
+		}
+		return nodes[i].name < nodes[j].name
+	})
+	var nodeSize, unusedSize float64
+	nodeItems := make([]interface{}, 0, len(nodes))
+	for _, n := range nodes {
+		nodeSize += n.size
+		unusedSize += n.unusedSize
+		nodeItems = append(nodeItems, map[string]interface{}{
+			"name":          n.name,
+			"images":        n.images,
+			"image_gib":     round(n.size/gib, 2),
+			"unused_images": n.unused,
+			"unused_gib":    round(n.unusedSize/gib, 2),
+			"unused_cost":   round(n.unusedSize/gib*opts.DiskPrice, 2),
+		})
+	}
+
+	// The inventory, of the images of the namespace if one is given
+	var inventory []*cachedImage
+	for _, image := range images {
+		if opts.Namespace == "" || image.pods > 0 {
+			inventory = append(inventory, image)
+		}
+	}
+	sort.SliceStable(inventory, func(i, j int) bool {
+		a, b := inventory[i], inventory[j]
+		if a.size*float64(len(a.nodes)) != b.size*float64(len(b.nodes)) {
+			return a.size*float64(len(a.nodes)) > b.size*float64(len(b.nodes))
+		}
+		return a.name < b.name
+	})
+	imageItems := make([]interface{}, 0, len(inventory))
+	var registrySize float64
+	for _, image := range inventory {
+		if image.pods > 0 {
+			registrySize += image.size
+		}
+		var flags []string
+		unusedNodes := len(image.nodes) - len(image.used)
+		if sandboxImage(image.name) {
+			unusedNodes = 0
+		} else if image.pods == 0 {
+			flags = append(flags, "unused")
+		}
+		if opts.MaxSize > 0 && image.size > opts.MaxSize {
+			flags = append(flags, "oversized")
+		}
+		imageItems = append(imageItems, map[string]interface{}{
+			"image":         image.name,
+			"size_gib":      round(image.size/gib, 2),
+			"nodes":         len(image.nodes),
+			"unused_nodes":  unusedNodes,
+			"pods":          image.pods,
+			"workloads":     len(image.workloads),
+			"node_disk_gib": round(image.size*float64(len(image.nodes))/gib, 2),
+			"monthly_cost":  round(image.size*float64(len(image.nodes))/gib*opts.DiskPrice, 2),
+			"flags":         strings.Join(flags, ","),
+		})
+	}
+
+	// Oversized images by workload container, the excess priced on the
+	// nodes caching them and once in the registry
+	oversized := map[string]map[string]interface{}{}
+	var keys []string
+	var bloatCost float64
+	for _, r := range containers {
+		if opts.MaxSize <= 0 || r.image.size <= opts.MaxSize {
+			continue
+		}
+		key := r.namespace + "/" + r.workload + "/" + r.container
+		if oversized[key] != nil {
+			continue
+		}
+		excess := r.image.size - opts.MaxSize
+		cost := excess/gib*float64(len(r.image.nodes))*opts.DiskPrice + excess/gib*opts.RegistryPrice
+		bloatCost += cost
+		keys = append(keys, key)
+		oversized[key] = map[string]interface{}{
+			"namespace":    r.namespace,
+			"workload":     r.workload,
+			"container":    r.container,
+			"image":        r.image.name,
+			"size_gib":     round(r.image.size/gib, 2),
+			"excess_gib":   round(excess/gib, 2),
+			"nodes":        len(r.image.nodes),
+			"monthly_cost": round(cost, 2),
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, b := oversized[keys[i]]["monthly_cost"].(float64), oversized[keys[j]]["monthly_cost"].(float64)
+		if a != b {
+			return a > b
+		}
+		return keys[i] < keys[j]
+	})
+	oversizedItems := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		oversizedItems = append(oversizedItems, oversized[key])
+	}
+
+	unusedCost := unusedSize / gib * opts.DiskPrice
+	result := map[string]interface{}{
+		"context":        c.kube.Context,
+		"node_image_gib": round(nodeSize/gib, 2),
+		"node_disk_cost": round(nodeSize/gib*opts.DiskPrice, 2),
+		"unused_gib":     round(unusedSize/gib, 2),
+		"unused_cost":    round(unusedCost, 2),
+		"registry_gib":   round(registrySize/gib, 2),
+		"registry_cost":  round(registrySize/gib*opts.RegistryPrice, 2),
+		"bloat_cost":     round(bloatCost, 2),
+		"images":         imageItems,
+		"nodes":          nodeItems,
+		"oversized":      oversizedItems,
+		"message": fmt.Sprintf("%d images take %.1f GiB on %d nodes: %.1f GiB unused costing $%.2f per month, %d containers run oversized images costing $%.2f per month",
+			len(images), nodeSize/gib, len(nodes), unusedSize/gib, unusedCost, len(oversizedItems), bloatCost),
+	}
+	var warnings []string
+	if len(capped) > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d nodes report %d images, the most the kubelet lists, their other images are not counted", len(capped), maxNodeImages))
+	}
+	if unmatched > 0 {
+		warnings = append(warnings, fmt.Sprintf("the images of %d containers are not listed by their nodes", unmatched))
+	}
+	if len(warnings) > 0 {
+		result["warning"] = strings.Join(warnings, "; ")
+	}
+	if unusedSize > 0 {
+		result["hint"] = "The kubelet removes unused images once the disk of a node reaches --image-gc-high-threshold-percent, lower it or set imageMaximumGCAge to reclaim them sooner"
+	}
+	return result, nil
+}
+
+// imageRefs returns the normalized names and the digests of image
+// references, the first naming the image
+func imageRefs(names ...string) []string {
+	var refs []string
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		normalized := normalizeImage(name)
+		if strings.HasPrefix(normalized, "sha256:") {
+			refs = append([]string{normalized}, refs...)
+			continue
+		}
+		if i := strings.Index(normalized, "@"); i >= 0 {
+			// By digest first, as the same image may have several tags.
+			// The repository of a digest alone does not name the image.
+			refs = append([]string{normalized[i+1:]}, refs...)
+			normalized = normalized[:i]
+			if !strings.Contains(normalized[strings.LastIndex(normalized, "/")+1:], ":") {
+				continue
+			}
+		}
+		refs = append(refs, normalized)
+	}
+	return refs
+}
+
+// normalizeImage expands an image reference the way container runtimes do:
+// nginx is docker.io/library/nginx:latest
+func normalizeImage(ref string) string {
+	ref = strings.TrimPrefix(ref, "docker-pullable://")
+	ref = strings.TrimPrefix(ref, "docker://")
+	if strings.HasPrefix(ref, "sha256:") {
+		return ref
+	}
+	name, digest, hasDigest := strings.Cut(ref, "@")
+	domain, rest, ok := strings.Cut(name, "/")
+	if !ok || !strings.ContainsAny(domain, ".:") && domain != "localhost" {
+		domain, rest = "docker.io", name
+		if !strings.Contains(rest, "/") {
+			rest = "library/" + rest
+		}
+	}
+	name = domain + "/" + rest
+	if !hasDigest && !strings.Contains(rest[strings.LastIndex(rest, "/")+1:], ":") {
+		name += ":latest"
+	}
+	if hasDigest {
+		name += "@" + digest
+	}
+	return name
+}
+
+// displayName returns the name of an image to show, a tagged one if it has
+// any
+func displayName(names []string) string {
+	for _, name := range names {
+		if !strings.Contains(name, "@") {
+			return name
+		}
+	}
+	return names[0]
+}
+
+// sandboxImage reports whether an image is the pause image of pod sandboxes
+func sandboxImage(name string) bool {
+	repository, _, _ := strings.Cut(name[strings.LastIndex(name, "/")+1:], ":")
+	return repository == "pause"
+}
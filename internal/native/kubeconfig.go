@@ -0,0 +1,103 @@
+package native
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// KubeContext is a kubeconfig context that can be registered as a UPID
+// cluster
+type KubeContext struct {
+	// Name is the context name
+	Name string `json:"context"`
+	// Cluster is the kubeconfig cluster the context points at
+	Cluster string `json:"cluster"`
+	// Server is the API server URL of the cluster
+	Server string `json:"server"`
+	// Namespace is the context's default namespace, "" if unset
+	Namespace string `json:"namespace,omitempty"`
+	// Current is true for the current context
+	Current bool `json:"current"`
+}
+
+// ReadContexts returns the contexts of a kubeconfig file sorted by name. An
+// empty path reads the files named by $KUBECONFIG, or ~/.kube/config.
+func ReadContexts(path string) ([]KubeContext, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if path != "" {
+		rules = &clientcmd.ClientConfigLoadingRules{ExplicitPath: path}
+	}
+	kubeconfig, err := rules.Load()
+	if err != nil {
+		return nil, clierr.Wrap(err, clierr.CategoryUsage, "KUBECONFIG_INVALID", "failed to load kubeconfig").
+			WithHint("Check the file given with --kubeconfig")
+	}
+
+	contexts := make([]KubeContext, 0, len(kubeconfig.Contexts))
+	for name, context := range kubeconfig.Contexts {
+		item := KubeContext{
+			Name:      name,
+			Cluster:   context.Cluster,
+			Namespace: context.Namespace,
+			Current:   name == kubeconfig.CurrentContext,
+		}
+		if cluster, ok := kubeconfig.Clusters[context.Cluster]; ok {
+			item.Server = cluster.Server
+		}
+		contexts = append(contexts, item)
+	}
+	sort.Slice(contexts, func(i, j int) bool { return contexts[i].Name < contexts[j].Name })
+	if len(contexts) == 0 {
+		return nil, clierr.New(clierr.CategoryUsage, "KUBECONFIG_EMPTY", "kubeconfig has no contexts")
+	}
+	return contexts, nil
+}
+
+// ClusterNames suggests a UPID cluster name for each context, derived from
+// the cluster name embedded in provider-generated context names and made
+// unique by numbering
+func ClusterNames(contexts []KubeContext) []string {
+	names := make([]string, len(contexts))
+	used := map[string]int{}
+	for i, context := range contexts {
+		name := clusterName(context.Name)
+		used[name]++
+		if n := used[name]; n > 1 {
+			name = fmt.Sprintf("%s-%d", name, n)
+		}
+		names[i] = name
+	}
+	return names
+}
+
+var (
+	// eksContext matches arn:aws:eks:<region>:<account>:cluster/<name>
+	eksContext = regexp.MustCompile(`^arn:aws[\w-]*:eks:[^:]*:[^:]*:cluster/(.+)$`)
+	// gkeContext matches gke_<project>_<location>_<name>
+	gkeContext = regexp.MustCompile(`^gke_[^_]+_[^_]+_(.+)$`)
+	// unsafeChars are replaced in cluster names
+	unsafeChars = regexp.MustCompile(`[^a-z0-9-]+`)
+)
+
+// clusterName derives a cluster name from a context name
+func clusterName(context string) string {
+	name := context
+	if m := eksContext.FindStringSubmatch(name); m != nil {
+		name = m[1]
+	} else if m := gkeContext.FindStringSubmatch(name); m != nil {
+		name = m[1]
+	} else if i := strings.LastIndex(name, "@"); i >= 0 && i < len(name)-1 {
+		// user@cluster, as written by kubeadm and kind
+		name = name[i+1:]
+	}
+	name = strings.Trim(unsafeChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if name == "" {
+		return "cluster"
+	}
+	return name
+}
@@ -0,0 +1,205 @@
+package native
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Patch types of exported recommendations, named as by kubectl patch --type
+const (
+	PatchStrategic = "strategic"
+	PatchJSON      = "json"
+)
+
+// ParsePatchType parses the type of patches to export
+func ParsePatchType(value string) (string, error) {
+	switch value {
+	case PatchStrategic, PatchJSON:
+		return value, nil
+	}
+	return "", clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+		fmt.Sprintf("invalid patch type %q (expected %s or %s)", value, PatchStrategic, PatchJSON))
+}
+
+// templatePath is the path of the pod spec in a workload of a kind
+func templatePath(kind string) []string {
+	if kind == "CronJob" {
+		return []string{"spec", "jobTemplate", "spec", "template", "spec"}
+	}
+	return []string{"spec", "template", "spec"}
+}
+
+// exportManifests writes, for each workload with containers to resize, a
+// patch of their resources and the updated manifest of the workload to
+// dir/<namespace>/, and returns the files written. Bare pods, ReplicaSets
+// and Jobs, whose pod templates cannot change, are skipped.
+func (c *Client) exportManifests(ctx context.Context, containers []*containerSizing, dir, patchType string) ([]interface{}, int, error) {
+	byWorkload := map[string][]*containerSizing{}
+	var keys []string
+	for _, s := range containers {
+		key := s.namespace + "/" + s.ref.Kind + "/" + s.ref.Name
+		if byWorkload[key] == nil {
+			keys = append(keys, key)
+		}
+		byWorkload[key] = append(byWorkload[key], s)
+	}
+	sort.Strings(keys)
+
+	var files []interface{}
+	skipped := 0
+	for _, key := range keys {
+		sizings := byWorkload[key]
+		ref, namespace := sizings[0].ref, sizings[0].namespace
+		if !kube.SupportsManifest(ref.Kind) {
+			skipped++
+			continue
+		}
+		manifest, err := c.kube.Manifest(ctx, ref.Kind, namespace, ref.Name)
+		if err != nil {
+			return nil, 0, err
+		}
+		patch, err := resourcePatch(manifest, ref.Kind, sizings, patchType)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to patch %s %s/%s: %v", ref.Kind, namespace, ref.Name, err)
+		}
+
+		base := filepath.Join(dir, namespace, strings.ToLower(ref.Kind)+"-"+ref.Name)
+		patchFile := base + ".patch.yaml"
+		if patchType == PatchJSON {
+			patchFile = base + ".patch.json"
+		}
+		if err := os.MkdirAll(filepath.Dir(base), 0o755); err != nil {
+			return nil, 0, fmt.Errorf("failed to create %s: %v", filepath.Dir(base), err)
+		}
+		if err := writeManifest(patchFile, patch, patchType == PatchJSON); err != nil {
+			return nil, 0, err
+		}
+		if err := writeManifest(base+".yaml", manifest, false); err != nil {
+			return nil, 0, err
+		}
+		files = append(files, map[string]interface{}{
+			"name":       ref.Name,
+			"namespace":  namespace,
+			"kind":       ref.Kind,
+			"containers": len(sizings),
+			"patch":      patchFile,
+			"manifest":   base + ".yaml",
+			"command": fmt.Sprintf("kubectl patch %s %s -n %s --type %s --patch-file %s",
+				strings.ToLower(ref.Kind), ref.Name, namespace, patchType, patchFile),
+		})
+	}
+	return files, skipped, nil
+}
+
+// resourcePatch builds the patch that sets the recommended resources of the
+// containers of a workload, and applies them to its manifest. JSON patches
+// test the name of each container before changing it, and only add the
+// requests and limits set, keeping other resources such as
+// ephemeral-storage.
+func resourcePatch(manifest map[string]interface{}, kind string, sizings []*containerSizing, patchType string) (interface{}, error) {
+	path := templatePath(kind)
+	specContainers, _, err := unstructured.NestedSlice(manifest, append(path, "containers")...)
+	if err != nil {
+		return nil, err
+	}
+
+	var strategic []interface{}
+	var operations []interface{}
+	for _, s := range sizings {
+		index := -1
+		for i, item := range specContainers {
+			if container, ok := item.(map[string]interface{}); ok && container["name"] == s.name {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("container %s not found", s.name)
+		}
+		container := specContainers[index].(map[string]interface{})
+		settings := map[string]map[string]interface{}{
+			"requests": {"cpu": formatCPU(s.recommended.requests.CPU), "memory": formatMemory(s.recommended.requests.Memory)},
+			"limits":   {"memory": formatMemory(s.recommended.limits.Memory)},
+		}
+		if s.recommended.limits.CPU > 0 {
+			settings["limits"]["cpu"] = formatCPU(s.recommended.limits.CPU)
+		}
+
+		pointer := fmt.Sprintf("/%s/containers/%d", strings.Join(path, "/"), index)
+		operations = append(operations, map[string]interface{}{"op": "test", "path": pointer + "/name", "value": s.name})
+		resources, _ := container["resources"].(map[string]interface{})
+		if resources == nil {
+			resources = map[string]interface{}{}
+			container["resources"] = resources
+			operations = append(operations, map[string]interface{}{"op": "add", "path": pointer + "/resources", "value": map[string]interface{}{}})
+		}
+		for _, field := range []string{"requests", "limits"} {
+			current, _ := resources[field].(map[string]interface{})
+			if current == nil {
+				current = map[string]interface{}{}
+				resources[field] = current
+				operations = append(operations, map[string]interface{}{"op": "add", "path": pointer + "/resources/" + field, "value": map[string]interface{}{}})
+			}
+			for _, name := range []string{"cpu", "memory"} {
+				value, ok := settings[field][name]
+				if !ok {
+					continue
+				}
+				current[name] = value
+				operations = append(operations, map[string]interface{}{"op": "add", "path": pointer + "/resources/" + field + "/" + name, "value": value})
+			}
+		}
+		strategic = append(strategic, map[string]interface{}{
+			"name": s.name,
+			"resources": map[string]interface{}{
+				"requests": settings["requests"],
+				"limits":   settings["limits"],
+			},
+		})
+	}
+
+	if err := unstructured.SetNestedSlice(manifest, specContainers, append(path, "containers")...); err != nil {
+		return nil, err
+	}
+	if patchType == PatchJSON {
+		return operations, nil
+	}
+	patch := map[string]interface{}{"containers": strategic}
+	for i := len(path) - 1; i >= 0; i-- {
+		patch = map[string]interface{}{path[i]: patch}
+	}
+	return patch, nil
+}
+
+// writeManifest writes an object as YAML, or as indented JSON
+func writeManifest(path string, object interface{}, asJSON bool) error {
+	var buf bytes.Buffer
+	if asJSON {
+		data, err := json.MarshalIndent(object, "", "  ")
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	} else {
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(object); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
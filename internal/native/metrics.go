@@ -0,0 +1,199 @@
+package native
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// Monthly savings from which recommendations are warnings and critical
+const (
+	warningSavings  = 20
+	criticalSavings = 100
+)
+
+// maxUtilization is the share of its requests a workload must use for its
+// unused requests not to be a right-sizing recommendation
+const maxUtilization = 0.5
+
+// MetricsOptions configure the metrics of a cluster
+type MetricsOptions struct {
+	// Window is the time range of usage from the datasource, 0 for the
+	// current usage from metrics-server
+	Window time.Duration
+	// MinConfidence is the confidence from which pods are idle
+	MinConfidence float64
+	// Prices are used to price unused requests and savings
+	Prices ComputePrices
+}
+
+// Metrics are the figures of a cluster that are exported to monitoring, by
+// namespace. Costs are monthly.
+type Metrics struct {
+	Context     string
+	CollectedAt time.Time
+	// IdleWorkloads counts the workloads that could be scaled to zero
+	IdleWorkloads map[string]int
+	// Cost is the cost of the requests of running pods
+	Cost map[string]float64
+	// Usage is the CPU and memory running pods use
+	Usage map[string]kube.Resources
+	// Waste is the cost of the requests running pods do not use
+	Waste map[string]float64
+	// ScaledWorkloads counts the workloads UPID scaled to zero, and
+	// RealizedSavings is the cost of the requests of their replicas
+	ScaledWorkloads map[string]int
+	RealizedSavings map[string]float64
+	// Recommendations counts scaling idle workloads to zero and
+	// right-sizing workloads that use less than half their requests, by
+	// severity of their savings
+	Recommendations map[string]int
+}
+
+// CollectMetrics reads the cost, the usage, the idle workloads, the waste,
+// the realized savings and the recommendations of the cluster. Usage is the
+// average over the window from the datasource, else the current usage from
+// metrics-server.
+func (c *Client) CollectMetrics(ctx context.Context, opts MetricsOptions) (*Metrics, error) {
+	pods, snapshot, err := c.runningPods(ctx, "", opts.Window, false)
+	if err != nil {
+		return nil, err
+	}
+	candidates, _, _, err := c.zeroPodCandidates(ctx, "", opts.MinConfidence, opts.Window)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Metrics{
+		Context:         c.kube.Context,
+		CollectedAt:     snapshot.CollectedAt,
+		IdleWorkloads:   map[string]int{},
+		Cost:            map[string]float64{},
+		Usage:           map[string]kube.Resources{},
+		Waste:           map[string]float64{},
+		ScaledWorkloads: map[string]int{},
+		RealizedSavings: map[string]float64{},
+		Recommendations: map[string]int{kube.SeverityInfo: 0, kube.SeverityWarning: 0, kube.SeverityCritical: 0},
+	}
+	for _, w := range candidates {
+		m.IdleWorkloads[w.namespace]++
+	}
+	for _, p := range pods {
+		unused := kube.Resources{
+			CPU:    math.Max(p.request.CPU-p.used.CPU, 0),
+			Memory: math.Max(p.request.Memory-p.used.Memory, 0),
+		}
+		m.Cost[p.pod.Namespace] += requestCost(p.request, 1, opts.Prices)
+		used := m.Usage[p.pod.Namespace]
+		used.CPU += p.used.CPU
+		used.Memory += p.used.Memory
+		m.Usage[p.pod.Namespace] = used
+		m.Waste[p.pod.Namespace] += requestCost(unused, 1, opts.Prices)
+	}
+	for _, r := range recommendWorkloads(pods, snapshot, candidates, opts.Prices) {
+		m.Recommendations[r.severity()]++
+	}
+
+	for i := range snapshot.Workloads {
+		w := &snapshot.Workloads[i]
+		replicas, err := strconv.ParseInt(w.Annotations[replicasAnnotation], 10, 32)
+		if err != nil || w.Replicas == nil || *w.Replicas != 0 {
+			// Not scaled to zero by UPID, or restored since
+			continue
+		}
+		m.ScaledWorkloads[w.Namespace]++
+		m.RealizedSavings[w.Namespace] += requestCost(w.Template, int32(replicas), opts.Prices)
+	}
+	return m, nil
+}
+
+// Actions of workload recommendations
+const (
+	actionScaleToZero = "scale to zero"
+	actionRightsize   = "right-size"
+)
+
+// workloadRecommendation is scaling an idle workload to zero, or
+// right-sizing one that uses less than maxUtilization of its requests.
+// Costs are monthly.
+type workloadRecommendation struct {
+	namespace string
+	kind      string
+	name      string
+	action    string
+	pods      int
+	requested float64
+	used      float64
+	savings   float64
+}
+
+// severity returns the severity of the recommendation by its savings
+func (r *workloadRecommendation) severity() string {
+	return savingsSeverity(r.savings)
+}
+
+// recommendWorkloads recommends scaling the idle candidates to zero, saving
+// the requests of their replicas, and right-sizing the other workloads of
+// pods that use less than maxUtilization of their requests, saving the
+// requests they do not use
+func recommendWorkloads(pods []podUsage, snapshot *kube.Snapshot, candidates []*workload, prices ComputePrices) []*workloadRecommendation {
+	workloads := map[string]*workloadRecommendation{}
+	var keys []string
+	for _, p := range pods {
+		key := p.pod.Namespace + "/" + p.pod.Workload.Kind + "/" + p.pod.Workload.Name
+		r := workloads[key]
+		if r == nil {
+			r = &workloadRecommendation{namespace: p.pod.Namespace, kind: p.pod.Workload.Kind, name: p.pod.Workload.Name, action: actionRightsize}
+			workloads[key] = r
+			keys = append(keys, key)
+		}
+		unused := kube.Resources{
+			CPU:    math.Max(p.request.CPU-p.used.CPU, 0),
+			Memory: math.Max(p.request.Memory-p.used.Memory, 0),
+		}
+		r.pods++
+		r.used += requestCost(p.used, 1, prices)
+		r.requested += requestCost(p.request, 1, prices)
+		r.savings += requestCost(unused, 1, prices)
+	}
+
+	var recommendations []*workloadRecommendation
+	idle := map[string]bool{}
+	for _, w := range candidates {
+		key := w.namespace + "/" + w.kind + "/" + w.name
+		idle[key] = true
+		current, ok := snapshot.Workload(w.kind, w.namespace, w.name)
+		if !ok {
+			continue
+		}
+		r := workloads[key]
+		if r == nil {
+			r = &workloadRecommendation{namespace: w.namespace, kind: w.kind, name: w.name}
+		}
+		r.action = actionScaleToZero
+		r.savings = requestCost(current.Template, w.replicas, prices)
+		recommendations = append(recommendations, r)
+	}
+	for _, key := range keys {
+		r := workloads[key]
+		if !idle[key] && r.requested > 0 && r.used < maxUtilization*r.requested {
+			recommendations = append(recommendations, r)
+		}
+	}
+	return recommendations
+}
+
+// savingsSeverity returns the severity of a recommendation by its monthly
+// savings
+func savingsSeverity(savings float64) string {
+	switch {
+	case savings >= criticalSavings:
+		return kube.SeverityCritical
+	case savings >= warningSavings:
+		return kube.SeverityWarning
+	}
+	return kube.SeverityInfo
+}
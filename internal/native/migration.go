@@ -0,0 +1,352 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// Methods of migrating persistent volume claims to another storage class
+const (
+	// MigrateSnapshot restores each claim under the same name in the new
+	// class from a VolumeSnapshot, while the pods mounting it are stopped
+	MigrateSnapshot = "snapshot"
+	// MigrateAttributes modifies the volumes in place by setting the
+	// VolumeAttributesClass of their claims, for classes of the same driver
+	MigrateAttributes = "vac"
+)
+
+// migrationStepLabel labels the exported manifests with the step of the
+// migration plan that applies them
+const migrationStepLabel = "upid.io/migration-step"
+
+// ParseMigrationMethod parses the method of a storage class migration
+func ParseMigrationMethod(value string) (string, error) {
+	switch value {
+	case MigrateSnapshot, MigrateAttributes:
+		return value, nil
+	}
+	return "", clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+		fmt.Sprintf("invalid migration method %q (expected %s or %s)", value, MigrateSnapshot, MigrateAttributes))
+}
+
+// ClassMigrationOptions configure the migration of persistent volume claims
+// from one storage class to another
+type ClassMigrationOptions struct {
+	// Namespace is the namespace of the claims to migrate, all if empty
+	Namespace string
+	// From is the storage class of the claims, To the storage class they
+	// migrate to, or with MigrateAttributes the VolumeAttributesClass
+	From, To string
+	// Method is MigrateSnapshot or MigrateAttributes
+	Method string
+	// StoragePrice is the price of a GiB-month of volumes that are not EBS
+	// volumes of a known type
+	StoragePrice float64
+	// Manifests is the file the manifests are exported to, named by the plan
+	Manifests string
+}
+
+// PlanClassMigration lists the bound persistent volume claims of the From
+// storage class, compares the cost, IOPS and throughput of their volumes in
+// the From and To classes, and plans their migration. It also returns the
+// manifests the plan applies: with MigrateSnapshot, a VolumeSnapshot of each
+// claim and the claim recreated from it in the To class, with
+// MigrateAttributes the VolumeAttributesClass the claims are patched to use
+// if the cluster has none of that name. Nothing is changed.
+func (c *Client) PlanClassMigration(ctx context.Context, opts ClassMigrationOptions) (map[string]interface{}, []map[string]interface{}, error) {
+	classes, err := c.kube.StorageClasses(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	from, fromFound := classes[opts.From]
+	to, toFound := classes[opts.To]
+	if opts.From == opts.To {
+		return nil, nil, clierr.New(clierr.CategoryUsage, "INVALID_USAGE", "--from and --to name the same storage class")
+	}
+
+	var manifests []map[string]interface{}
+	var snapshotClass string
+	var warnings []string
+	switch opts.Method {
+	case MigrateAttributes:
+		attributes, err := c.kube.VolumeAttributesClass(ctx, opts.To)
+		if err != nil {
+			return nil, nil, err
+		}
+		if attributes == nil && !toFound {
+			return nil, nil, clierr.New(clierr.CategoryUsage, "STORAGE_CLASS_NOT_FOUND",
+				fmt.Sprintf("no storage class or volume attributes class %s", opts.To)).
+				WithHint("The volume attributes class is created from the parameters of the storage class of that name")
+		}
+		driver := to.Provisioner
+		if attributes != nil {
+			driver = attributes.DriverName
+			// The performance of the volumes is that of the attributes
+			to = storagev1.StorageClass{Provisioner: attributes.DriverName, Parameters: attributes.Parameters}
+		}
+		if fromFound && from.Provisioner != driver {
+			return nil, nil, clierr.New(clierr.CategoryUsage, "INVALID_USAGE",
+				fmt.Sprintf("volumes of %s provisioned by %s cannot be modified by %s", opts.From, from.Provisioner, driver)).
+				WithHint("Migrate between drivers with --method snapshot")
+		}
+		if attributes == nil {
+			manifests = append(manifests, attributesClassManifest(opts.To, to))
+		}
+	default:
+		if !toFound {
+			return nil, nil, clierr.New(clierr.CategoryUsage, "STORAGE_CLASS_NOT_FOUND", "no storage class "+opts.To).
+				WithHint("List the storage classes with 'kubectl get storageclass'")
+		}
+		snapshotClasses, err := c.kube.VolumeSnapshotClasses(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		driver := from.Provisioner
+		if driver == "kubernetes.io/aws-ebs" {
+			// In-tree volumes are snapshotted by the CSI driver they
+			// migrated to
+			driver = "ebs.csi.aws.com"
+		}
+		if snapshotClass = snapshotClasses[driver]; snapshotClass == "" {
+			warnings = append(warnings, fmt.Sprintf("no VolumeSnapshotClass of the driver %s, the snapshots use the default class of the cluster", driver))
+		}
+	}
+
+	claims, err := c.kube.PersistentVolumeClaims(ctx, opts.Namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	users, err := c.kube.ClaimUsers(ctx, opts.Namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type migration struct {
+		claim   *corev1.PersistentVolumeClaim
+		item    map[string]interface{}
+		savings float64
+	}
+	var migrations []migration
+	var slower, unpriced int
+	var costSum, newCostSum float64
+	for i := range claims {
+		claim := &claims[i]
+		if claim.Status.Phase != corev1.ClaimBound || claimClass(claim, classes) != opts.From {
+			continue
+		}
+		if opts.Method == MigrateAttributes && claim.Spec.VolumeAttributesClassName != nil && *claim.Spec.VolumeAttributesClassName == opts.To {
+			continue
+		}
+		var size float64
+		if capacity, ok := claim.Status.Capacity[corev1.ResourceStorage]; ok {
+			size = capacity.AsApproximateFloat64() / gib
+		}
+		item := map[string]interface{}{
+			"name":         claim.Name,
+			"namespace":    claim.Namespace,
+			"volume":       claim.Spec.VolumeName,
+			"capacity_gib": round(size, 1),
+			"pods":         strings.Join(users[claim.Namespace+"/"+claim.Name], ","),
+		}
+		before, okBefore := ebsPerformance(from, size)
+		after, okAfter := ebsPerformance(to, size)
+		if !okBefore || !okAfter {
+			// Volumes of other provisioners cost the same in both classes
+			unpriced++
+			before = volumePerformance{cost: size * opts.StoragePrice}
+			after = before
+		} else {
+			item["from_type"] = before.volumeType
+			item["to_type"] = after.volumeType
+			item["from_iops"] = before.iops
+			item["to_iops"] = after.iops
+			item["from_throughput"] = before.throughput
+			item["to_throughput"] = after.throughput
+			var notes []string
+			if after.iops < before.iops {
+				notes = append(notes, fmt.Sprintf("%.0f fewer IOPS", before.iops-after.iops))
+			}
+			if after.throughput < before.throughput {
+				notes = append(notes, fmt.Sprintf("%.0f MiB/s less throughput", before.throughput-after.throughput))
+			}
+			if len(notes) > 0 {
+				slower++
+				item["note"] = strings.Join(notes, ", ") + ", raise the iops or throughput of " + opts.To + " if the workload needs them"
+			}
+		}
+		item["monthly_cost"] = round(before.cost, 2)
+		item["new_monthly_cost"] = round(after.cost, 2)
+		item["savings"] = round(before.cost-after.cost, 2)
+		costSum += before.cost
+		newCostSum += after.cost
+		migrations = append(migrations, migration{claim: claim, item: item, savings: before.cost - after.cost})
+	}
+
+	sort.SliceStable(migrations, func(i, j int) bool {
+		a, b := migrations[i], migrations[j]
+		if a.savings != b.savings {
+			return a.savings > b.savings
+		}
+		return a.claim.Namespace+"/"+a.claim.Name < b.claim.Namespace+"/"+b.claim.Name
+	})
+
+	file := opts.Manifests
+	if file == "" {
+		file = "migration.yaml"
+	}
+	plan := []string{}
+	items := make([]interface{}, 0, len(migrations))
+	switch opts.Method {
+	case MigrateAttributes:
+		if len(manifests) > 0 && len(migrations) > 0 {
+			plan = append(plan, "kubectl apply -f "+file)
+		}
+		for _, m := range migrations {
+			command := fmt.Sprintf(`kubectl patch pvc %s -n %s --type merge -p '{"spec":{"volumeAttributesClassName":"%s"}}'`,
+				m.claim.Name, m.claim.Namespace, opts.To)
+			m.item["command"] = command
+			plan = append(plan, command)
+			items = append(items, m.item)
+		}
+	default:
+		if len(migrations) > 0 {
+			plan = append(plan,
+				"Stop the pods mounting the claims by scaling their workloads to 0, so that the snapshots are consistent",
+				fmt.Sprintf("kubectl apply -f %s -l %s=snapshot", file, migrationStepLabel),
+				fmt.Sprintf("kubectl wait volumesnapshot -A -l %s=snapshot --for=jsonpath='{.status.readyToUse}'=true --timeout=1h", migrationStepLabel))
+		}
+		for _, m := range migrations {
+			snapshot := m.claim.Name + "-to-" + opts.To
+			manifests = append(manifests, migrationSnapshotManifest(m.claim, snapshot, snapshotClass), migrationClaimManifest(m.claim, snapshot, opts.To))
+			// The old volume is retained until the migrated data is verified
+			command := fmt.Sprintf(`kubectl patch pv %s -p '{"spec":{"persistentVolumeReclaimPolicy":"Retain"}}' && kubectl delete pvc %s -n %s`,
+				m.claim.Spec.VolumeName, m.claim.Name, m.claim.Namespace)
+			m.item["command"] = command
+			plan = append(plan, command)
+			items = append(items, m.item)
+		}
+		if len(migrations) > 0 {
+			plan = append(plan,
+				fmt.Sprintf("kubectl apply -f %s -l %s=claim", file, migrationStepLabel),
+				"Scale the workloads back up, and once their data is verified delete the retained volumes and the snapshots")
+		}
+	}
+
+	result := map[string]interface{}{
+		"context":          c.kube.Context,
+		"from":             opts.From,
+		"to":               opts.To,
+		"method":           opts.Method,
+		"monthly_cost":     round(costSum, 2),
+		"new_monthly_cost": round(newCostSum, 2),
+		"savings":          round(costSum-newCostSum, 2),
+		"volumes":          items,
+		"plan":             plan,
+		"manifests":        len(manifests),
+		"message": fmt.Sprintf("%d claims of %s to migrate to %s by %s, saving $%.2f per month",
+			len(migrations), opts.From, opts.To, opts.Method, costSum-newCostSum),
+	}
+	if !fromFound {
+		warnings = append(warnings, fmt.Sprintf("the storage class %s no longer exists, the cost and performance of its volumes are unknown", opts.From))
+	} else if unpriced > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d volumes are not EBS volumes of a known type, priced the same in both classes", unpriced))
+	}
+	if slower > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d volumes get fewer IOPS or less throughput in %s", slower, opts.To))
+	}
+	if len(warnings) > 0 {
+		result["warning"] = strings.Join(warnings, "; ")
+	}
+	if opts.Method == MigrateAttributes && len(migrations) > 0 {
+		result["hint"] = "Volumes are modified online; EBS allows one modification of a volume every 6 hours"
+	}
+	return result, manifests, nil
+}
+
+// attributesClassManifest returns the manifest of a VolumeAttributesClass
+// with the parameters of a storage class that EBS modifies volumes with
+func attributesClassManifest(name string, class storagev1.StorageClass) map[string]interface{} {
+	parameters := map[string]interface{}{}
+	for _, key := range []string{"type", "iops", "throughput"} {
+		if value := classParameter(class, key); value != "" {
+			parameters[key] = value
+		}
+	}
+	return map[string]interface{}{
+		"apiVersion": "storage.k8s.io/v1",
+		"kind":       "VolumeAttributesClass",
+		"metadata":   map[string]interface{}{"name": name},
+		"driverName": class.Provisioner,
+		"parameters": parameters,
+	}
+}
+
+// migrationSnapshotManifest returns the manifest of the VolumeSnapshot of a
+// claim to migrate
+func migrationSnapshotManifest(claim *corev1.PersistentVolumeClaim, name, class string) map[string]interface{} {
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{"persistentVolumeClaimName": claim.Name},
+	}
+	if class != "" {
+		spec["volumeSnapshotClassName"] = class
+	}
+	return map[string]interface{}{
+		"apiVersion": "snapshot.storage.k8s.io/v1",
+		"kind":       "VolumeSnapshot",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": claim.Namespace,
+			"labels":    map[string]interface{}{migrationStepLabel: "snapshot"},
+		},
+		"spec": spec,
+	}
+}
+
+// migrationClaimManifest returns the manifest of a claim recreated under the
+// same name in another storage class from its snapshot, so that the
+// workloads mounting it need no change
+func migrationClaimManifest(claim *corev1.PersistentVolumeClaim, snapshot, class string) map[string]interface{} {
+	labels := map[string]interface{}{migrationStepLabel: "claim"}
+	for k, v := range claim.Labels {
+		labels[k] = v
+	}
+	modes := make([]interface{}, 0, len(claim.Spec.AccessModes))
+	for _, mode := range claim.Spec.AccessModes {
+		modes = append(modes, string(mode))
+	}
+	request := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+	if capacity, ok := claim.Status.Capacity[corev1.ResourceStorage]; ok && capacity.Cmp(request) > 0 {
+		request = capacity
+	}
+	spec := map[string]interface{}{
+		"accessModes":      modes,
+		"storageClassName": class,
+		"resources": map[string]interface{}{
+			"requests": map[string]interface{}{"storage": request.String()},
+		},
+		"dataSource": map[string]interface{}{
+			"apiGroup": "snapshot.storage.k8s.io",
+			"kind":     "VolumeSnapshot",
+			"name":     snapshot,
+		},
+	}
+	if claim.Spec.VolumeMode != nil {
+		spec["volumeMode"] = string(*claim.Spec.VolumeMode)
+	}
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "PersistentVolumeClaim",
+		"metadata": map[string]interface{}{
+			"name":      claim.Name,
+			"namespace": claim.Namespace,
+			"labels":    labels,
+		},
+		"spec": spec,
+	}
+}
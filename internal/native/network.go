@@ -0,0 +1,225 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// NetworkPrices are the costs per GB of network traffic
+type NetworkPrices struct {
+	// CrossZone is charged for traffic between availability zones, counting
+	// both directions
+	CrossZone float64
+	// Egress is charged for traffic sent out of the cluster
+	Egress float64
+}
+
+// DefaultNetworkPrices are typical public cloud list prices in USD
+var DefaultNetworkPrices = NetworkPrices{CrossZone: 0.02, Egress: 0.09}
+
+// gb is the unit network traffic is priced in
+const gb = 1e9
+
+// month is the period costs are projected to
+const month = 30 * 24 * time.Hour
+
+// meshUnknown is the workload Istio reports for peers outside the mesh
+const meshUnknown = "unknown"
+
+// workloadTraffic is the traffic of the pods of one workload
+type workloadTraffic struct {
+	ref       kube.WorkloadRef
+	namespace string
+	// zones counts the running pods per zone
+	zones    map[string]int
+	pods     int
+	received float64
+	sent     float64
+	// crossZone and egress are attributed from mesh flows
+	crossZone float64
+	egress    float64
+}
+
+// AnalyzeNetwork reports the bytes received and sent by each workload in
+// namespace (all if empty) over the window from the datasource. With Istio
+// metrics the traffic between workloads is attributed to zones, assuming
+// requests spread evenly over the pods of the destination, and traffic to
+// peers outside the mesh is counted as egress; both are priced and projected
+// to a month.
+func (c *Client) AnalyzeNetwork(ctx context.Context, namespace string, window time.Duration, prices NetworkPrices) (map[string]interface{}, error) {
+	if c.history == nil || window == 0 {
+		return nil, clierr.New(clierr.CategoryUsage, "DATASOURCE_NOT_CONFIGURED", "network traffic is only known from a datasource").
+			WithHint("Configure one with 'upid config datasource set --url <url>'")
+	}
+
+	// Flows cross namespaces, so the zones of all pods are needed
+	snapshot, err := c.kube.Collect(ctx, kube.CollectOptions{RunningOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	traffic, err := c.history.NetworkHistory(ctx, namespace, window)
+	if err != nil {
+		return nil, err
+	}
+	flows, err := c.history.MeshFlows(ctx, window)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeZones := map[string]string{}
+	for _, node := range snapshot.Nodes {
+		nodeZones[node.Name] = node.Zone
+	}
+	workloads := map[string]*workloadTraffic{}
+	// byName finds workloads by namespace and name, which is how Istio
+	// names them
+	byName := map[string]*workloadTraffic{}
+	for i := range snapshot.Pods {
+		pod := &snapshot.Pods[i]
+		key := pod.Namespace + "/" + pod.Workload.Kind + "/" + pod.Workload.Name
+		w := workloads[key]
+		if w == nil {
+			w = &workloadTraffic{ref: pod.Workload, namespace: pod.Namespace, zones: map[string]int{}}
+			workloads[key] = w
+			byName[pod.Namespace+"/"+pod.Workload.Name] = w
+		}
+		w.pods++
+		if zone := nodeZones[pod.Node]; zone != "" {
+			w.zones[zone]++
+		}
+		if t, ok := traffic[pod.Namespace+"/"+pod.Name]; ok {
+			w.received += t.Received
+			w.sent += t.Sent
+		}
+	}
+
+	for _, flow := range flows {
+		source := byName[flow.SourceNamespace+"/"+flow.SourceWorkload]
+		if source == nil {
+			continue
+		}
+		if flow.DestinationWorkload == meshUnknown || flow.DestinationWorkload == "" {
+			// Responses from outside are ingress, which is free
+			source.egress += flow.Sent
+			continue
+		}
+		destination := byName[flow.DestinationNamespace+"/"+flow.DestinationWorkload]
+		if destination == nil {
+			continue
+		}
+		source.crossZone += (flow.Sent + flow.Received) * crossZoneShare(source.zones, destination.zones)
+	}
+
+	mesh := len(flows) > 0
+	scale := float64(month) / float64(window)
+	var received, sent, crossZone, egress, cost float64
+	var sorted []*workloadTraffic
+	for _, w := range workloads {
+		if namespace != "" && w.namespace != namespace {
+			continue
+		}
+		sorted = append(sorted, w)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.received+a.sent != b.received+b.sent {
+			return a.received+a.sent > b.received+b.sent
+		}
+		return a.namespace+"/"+a.ref.Name < b.namespace+"/"+b.ref.Name
+	})
+
+	items := make([]interface{}, 0, len(sorted))
+	for _, w := range sorted {
+		received += w.received
+		sent += w.sent
+		item := map[string]interface{}{
+			"name":           w.ref.Name,
+			"namespace":      w.namespace,
+			"kind":           w.ref.Kind,
+			"pods":           w.pods,
+			"zones":          formatZones(w.zones),
+			"received_gb":    round(w.received/gb, 3),
+			"sent_gb":        round(w.sent/gb, 3),
+			"receive_kib_s":  round(w.received/window.Seconds()/1024, 1),
+			"transmit_kib_s": round(w.sent/window.Seconds()/1024, 1),
+		}
+		if mesh {
+			monthly := (w.crossZone/gb*prices.CrossZone + w.egress/gb*prices.Egress) * scale
+			crossZone += w.crossZone
+			egress += w.egress
+			cost += monthly
+			item["cross_zone_gb"] = round(w.crossZone/gb, 3)
+			item["egress_gb"] = round(w.egress/gb, 3)
+			item["monthly_cost"] = round(monthly, 2)
+		}
+		items = append(items, item)
+	}
+
+	result := map[string]interface{}{
+		"context":     c.kube.Context,
+		"time_range":  formatWindow(window),
+		"datasource":  c.history.URL(),
+		"received_gb": round(received/gb, 3),
+		"sent_gb":     round(sent/gb, 3),
+		"workloads":   items,
+	}
+	if !mesh {
+		result["source"] = "cadvisor"
+		result["message"] = fmt.Sprintf("Traffic of %d workloads over %s: %.2f GB received, %.2f GB sent (from %s)",
+			len(items), formatWindow(window), received/gb, sent/gb, c.history.URL())
+		result["warning"] = "no Istio metrics at the datasource, traffic is not attributed to zones or egress"
+		result["hint"] = "Cross-zone and egress costs need the istio_request_bytes and istio_tcp_sent_bytes_total metrics of Istio sidecars"
+		return result, nil
+	}
+	result["source"] = "istio"
+	result["cross_zone_gb"] = round(crossZone/gb, 3)
+	result["egress_gb"] = round(egress/gb, 3)
+	result["monthly_cost"] = round(cost, 2)
+	result["message"] = fmt.Sprintf("Traffic of %d workloads over %s: %.2f GB cross-zone, %.2f GB egress, $%.2f per month at $%g/GB cross-zone and $%g/GB egress",
+		len(items), formatWindow(window), crossZone/gb, egress/gb, cost, prices.CrossZone, prices.Egress)
+	return result, nil
+}
+
+// crossZoneShare is the share of traffic between two workloads that crosses
+// zones if every pod of one talks to every pod of the other equally: one
+// minus the chance that two pods picked at random share a zone. It is 0 if
+// the zones of either are unknown.
+func crossZoneShare(source, destination map[string]int) float64 {
+	sourcePods, destinationPods := 0, 0
+	for _, n := range source {
+		sourcePods += n
+	}
+	for _, n := range destination {
+		destinationPods += n
+	}
+	if sourcePods == 0 || destinationPods == 0 {
+		return 0
+	}
+	same := 0.0
+	for zone, n := range source {
+		same += float64(n) / float64(sourcePods) * float64(destination[zone]) / float64(destinationPods)
+	}
+	return 1 - same
+}
+
+// formatZones lists zones with their pod counts, e.g. "us-east-1a:2,us-east-1b:1"
+func formatZones(zones map[string]int) string {
+	if len(zones) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(zones))
+	for zone := range zones {
+		names = append(names, zone)
+	}
+	sort.Strings(names)
+	for i, zone := range names {
+		names[i] = fmt.Sprintf("%s:%d", zone, zones[zone])
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,439 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// workload is a Deployment or StatefulSet that could be scaled to zero
+type workload struct {
+	kind       string
+	namespace  string
+	name       string
+	replicas   int32
+	idlePods   int
+	confidence float64
+	// policy is the optimization policy that scales the workload, if any
+	policy string
+	// excluded is why the workload is excluded from optimizations, if it is
+	excluded string
+	// podLabels are the labels of its pods, checked by guardrails
+	podLabels map[string]string
+	// violations are the guardrails scaling it fails
+	violations []Violation
+}
+
+// Annotations on workloads scaled to zero. They let a rollback restore the
+// previous replicas from any machine.
+const (
+	replicasAnnotation = "upid.io/zero-pod-replicas"
+	scaledAtAnnotation = "upid.io/zero-pod-scaled-at"
+	// policyAnnotation names the optimization policy that scaled the
+	// workload, which restores it outside of its schedule
+	policyAnnotation = "upid.io/zero-pod-policy"
+)
+
+// ZeroPodPlan lists the workloads in namespace whose pods are all idle and
+// would be scaled to zero, and those the guardrails would block. Nothing is
+// changed.
+func (c *Client) ZeroPodPlan(ctx context.Context, namespace string, minConfidence float64, window time.Duration) (map[string]interface{}, error) {
+	candidates, excluded, _, err := c.zeroPodCandidates(ctx, namespace, minConfidence, window)
+	if err != nil {
+		return nil, err
+	}
+	candidates, blocked, err := c.guardWorkloads(ctx, c.newGuard(true, time.Now()), candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]interface{}, 0, len(candidates))
+	for _, w := range candidates {
+		items = append(items, w.item(overridden(fmt.Sprintf("scale %s/%s from %d to 0 replicas", w.kind, w.name, w.replicas), w.violations)))
+	}
+
+	result := map[string]interface{}{
+		"message":   fmt.Sprintf("Dry run: would scale %d workloads to zero in namespace %s", len(items), namespace),
+		"context":   c.kube.Context,
+		"dry_run":   true,
+		"workloads": items,
+	}
+	addExcluded(result, excluded)
+	addBlocked(result, blocked)
+	return result, nil
+}
+
+// ZeroPodChanges lists the workloads ApplyZeroPod would scale to zero, to
+// record them in a change that needs approval
+func (c *Client) ZeroPodChanges(ctx context.Context, namespace string, minConfidence float64, window time.Duration) ([]kube.ApprovalChange, error) {
+	candidates, _, _, err := c.zeroPodCandidates(ctx, namespace, minConfidence, window)
+	if err != nil {
+		return nil, err
+	}
+	candidates, _, err = c.guardWorkloads(ctx, c.newGuard(true, time.Now()), candidates)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]kube.ApprovalChange, 0, len(candidates))
+	for _, w := range candidates {
+		changes = append(changes, kube.ApprovalChange{Kind: w.kind, Name: w.name, From: w.replicas, To: 0})
+	}
+	return changes, nil
+}
+
+// ApplyZeroPod scales the workloads of ZeroPodPlan to zero. The previous
+// replicas are stored in an annotation on each workload and in records
+// before it is scaled. With autoRollback, a failure restores the workloads
+// already scaled, so that the namespace is left as it was. Workloads failing
+// guardrails are left alone unless overridden, which is audited. If only is
+// not nil, as for an approved change, just the workloads in it are scaled,
+// and only while they still run the recorded replicas.
+func (c *Client) ApplyZeroPod(ctx context.Context, namespace string, minConfidence float64, window time.Duration, records *ScaleRecords, autoRollback bool, only []kube.ApprovalChange) (map[string]interface{}, error) {
+	candidates, excluded, _, err := c.zeroPodCandidates(ctx, namespace, minConfidence, window)
+	if err != nil {
+		return nil, err
+	}
+	g := c.newGuard(true, time.Now())
+	candidates, blocked, err := c.guardWorkloads(ctx, g, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	var scaled []*workload
+	var failures []interface{}
+	actions := map[*workload]string{}
+	for _, w := range candidates {
+		if only != nil && !approved(w, only) {
+			actions[w] = fmt.Sprintf("left %s/%s at %d replicas, it is not part of the approved change", w.kind, w.name, w.replicas)
+			continue
+		}
+		if len(w.violations) > 0 {
+			if err := g.audit(ctx, w.change(0), "scale to zero", w.violations); err != nil {
+				failures = append(failures, fmt.Sprintf("%s/%s: %v", w.kind, w.name, err))
+				actions[w] = fmt.Sprintf("left %s/%s at %d replicas, the override could not be audited", w.kind, w.name, w.replicas)
+				continue
+			}
+		}
+		if err := c.scaleToZero(ctx, w, records); err != nil {
+			failures = append(failures, fmt.Sprintf("%s/%s: %v", w.kind, w.name, err))
+			actions[w] = fmt.Sprintf("failed to scale %s/%s to 0 replicas", w.kind, w.name)
+			continue
+		}
+		scaled = append(scaled, w)
+		actions[w] = overridden(fmt.Sprintf("scaled %s/%s from %d to 0 replicas", w.kind, w.name, w.replicas), w.violations)
+	}
+
+	if len(failures) > 0 && autoRollback {
+		for _, w := range scaled {
+			if _, err := c.restore(ctx, w.kind, w.namespace, w.name, w.replicas, records); err != nil {
+				failures = append(failures, fmt.Sprintf("%s/%s: rollback failed: %v", w.kind, w.name, err))
+				continue
+			}
+			actions[w] = fmt.Sprintf("rolled back %s/%s to %d replicas", w.kind, w.name, w.replicas)
+		}
+		scaled = nil
+	}
+
+	items := make([]interface{}, 0, len(candidates))
+	for _, w := range candidates {
+		items = append(items, w.item(actions[w]))
+	}
+	result := map[string]interface{}{
+		"message":    fmt.Sprintf("Scaled %d workloads to zero in namespace %s", len(scaled), namespace),
+		"context":    c.kube.Context,
+		"dry_run":    false,
+		"workloads":  items,
+		"state_file": records.Path(),
+	}
+	addExcluded(result, excluded)
+	addBlocked(result, blocked)
+	if len(failures) > 0 {
+		result["partial"] = true
+		result["errors"] = failures
+	}
+	return result, nil
+}
+
+// approved returns true if changes scale w to zero from its current replicas
+func approved(w *workload, changes []kube.ApprovalChange) bool {
+	for _, change := range changes {
+		if change.Kind == w.kind && change.Name == w.name && change.From == w.replicas && change.To == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RollbackZeroPod restores the replicas of the workloads in namespace that
+// were scaled to zero, as recorded in their annotation or, failing that, in
+// records. If only is not empty, it restores just the workloads named in it,
+// as name or kind/name. With dryRun, nothing is changed.
+func (c *Client) RollbackZeroPod(ctx context.Context, namespace string, records *ScaleRecords, only []string, dryRun bool) (map[string]interface{}, error) {
+	annotated, err := c.kube.Annotated(ctx, namespace, replicasAnnotation)
+	if err != nil {
+		return nil, kube.APIError(err, "list workloads")
+	}
+
+	targets := map[kube.WorkloadRef]int32{}
+	for ref, value := range annotated {
+		if replicas, err := strconv.ParseInt(value, 10, 32); err == nil && replicas > 0 {
+			targets[ref] = int32(replicas)
+		}
+	}
+	for _, record := range records.Records {
+		ref := kube.WorkloadRef{Kind: record.Kind, Name: record.Name}
+		if record.Context != c.kube.Context || record.Namespace != namespace {
+			continue
+		}
+		if _, ok := targets[ref]; !ok {
+			targets[ref] = record.Replicas
+		}
+	}
+
+	refs := make([]kube.WorkloadRef, 0, len(targets))
+	for ref := range targets {
+		if selected(ref, only) {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Kind != refs[j].Kind {
+			return refs[i].Kind < refs[j].Kind
+		}
+		return refs[i].Name < refs[j].Name
+	})
+
+	restored := 0
+	var failures []interface{}
+	items := make([]interface{}, 0, len(refs))
+	for _, ref := range refs {
+		w := &workload{kind: ref.Kind, namespace: namespace, name: ref.Name, replicas: targets[ref]}
+		if dryRun {
+			items = append(items, w.item(fmt.Sprintf("scale %s/%s from 0 to %d replicas", w.kind, w.name, w.replicas)))
+			continue
+		}
+		current, err := c.restore(ctx, w.kind, w.namespace, w.name, w.replicas, records)
+		switch {
+		case err != nil:
+			failures = append(failures, fmt.Sprintf("%s/%s: %v", w.kind, w.name, err))
+			items = append(items, w.item(fmt.Sprintf("failed to scale %s/%s to %d replicas", w.kind, w.name, w.replicas)))
+		case current != 0:
+			items = append(items, w.item(fmt.Sprintf("left %s/%s at %d replicas, it was scaled in the meantime", w.kind, w.name, current)))
+		default:
+			restored++
+			items = append(items, w.item(fmt.Sprintf("scaled %s/%s from 0 to %d replicas", w.kind, w.name, w.replicas)))
+		}
+	}
+
+	message := fmt.Sprintf("Restored %d workloads in namespace %s", restored, namespace)
+	if dryRun {
+		message = fmt.Sprintf("Dry run: would restore %d workloads in namespace %s", len(items), namespace)
+	}
+	result := map[string]interface{}{
+		"message":   message,
+		"context":   c.kube.Context,
+		"dry_run":   dryRun,
+		"workloads": items,
+	}
+	if len(failures) > 0 {
+		result["partial"] = true
+		result["errors"] = failures
+	}
+	return result, nil
+}
+
+// zeroPodCandidates returns the Deployments and StatefulSets in namespace
+// whose replicas are all idle, sorted by kind and name, those of them that
+// are excluded from optimizations, and the snapshot of the cluster they
+// were found in
+func (c *Client) zeroPodCandidates(ctx context.Context, namespace string, minConfidence float64, window time.Duration) ([]*workload, []*workload, *kube.Snapshot, error) {
+	idle, snapshot, err := c.idlePods(ctx, namespace, minConfidence, window)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	workloads := map[string]*workload{}
+	for _, p := range idle {
+		kind, name := p.pod.Workload.Kind, p.pod.Workload.Name
+		if kind != "Deployment" && kind != "StatefulSet" {
+			// Bare pods, DaemonSets and Jobs cannot be scaled to zero
+			continue
+		}
+		key := p.pod.Namespace + "/" + kind + "/" + name
+		w, ok := workloads[key]
+		if !ok {
+			w = &workload{kind: kind, namespace: p.pod.Namespace, name: name, confidence: 1, podLabels: p.pod.Labels}
+			workloads[key] = w
+		}
+		w.idlePods++
+		if p.confidence < w.confidence {
+			w.confidence = p.confidence
+		}
+	}
+
+	exclusions := snapshot.Excluded(c.exclusions)
+	candidates := make([]*workload, 0, len(workloads))
+	var excluded []*workload
+	for key, w := range workloads {
+		w.replicas = 1
+		if current, ok := snapshot.Workload(w.kind, w.namespace, w.name); ok && current.Replicas != nil {
+			w.replicas = *current.Replicas
+		}
+		if w.replicas == 0 || int32(w.idlePods) < w.replicas {
+			// Already scaled down, or some replicas are busy
+			continue
+		}
+		if w.excluded = exclusions[key]; w.excluded != "" {
+			excluded = append(excluded, w)
+			continue
+		}
+		candidates = append(candidates, w)
+	}
+	sortWorkloads(candidates)
+	sortWorkloads(excluded)
+	return candidates, excluded, snapshot, nil
+}
+
+// sortWorkloads sorts workloads by kind and name
+func sortWorkloads(workloads []*workload) {
+	sort.Slice(workloads, func(i, j int) bool {
+		if workloads[i].kind != workloads[j].kind {
+			return workloads[i].kind < workloads[j].kind
+		}
+		return workloads[i].name < workloads[j].name
+	})
+}
+
+// addExcluded lists the workloads an optimization left alone because they
+// are excluded, if any, apart from those it acted on
+func addExcluded(result map[string]interface{}, excluded []*workload) {
+	if len(excluded) == 0 {
+		return
+	}
+	items := make([]interface{}, 0, len(excluded))
+	for _, w := range excluded {
+		items = append(items, excludedItem(w.namespace, w.kind, w.name, w.excluded))
+	}
+	result["excluded"] = items
+}
+
+// excludedItem describes a workload excluded from optimizations
+func excludedItem(namespace, kind, name, reason string) map[string]interface{} {
+	return map[string]interface{}{
+		"namespace": namespace,
+		"kind":      kind,
+		"name":      name,
+		"reason":    reason,
+	}
+}
+
+// scaleToZero records the replicas of w and scales it to zero. The record
+// is removed again if the workload cannot be scaled.
+func (c *Client) scaleToZero(ctx context.Context, w *workload, records *ScaleRecords) error {
+	now := time.Now().UTC()
+	record := ScaleRecord{
+		Context:   c.kube.Context,
+		Kind:      w.kind,
+		Namespace: w.namespace,
+		Name:      w.name,
+		Replicas:  w.replicas,
+		ScaledAt:  now,
+	}
+	if err := records.put(record); err != nil {
+		return err
+	}
+
+	replicas := strconv.Itoa(int(w.replicas))
+	scaledAt := now.Format(time.RFC3339)
+	annotations := map[string]*string{
+		replicasAnnotation: &replicas,
+		scaledAtAnnotation: &scaledAt,
+	}
+	if w.policy != "" {
+		annotations[policyAnnotation] = &w.policy
+	}
+	err := c.kube.Annotate(ctx, w.kind, w.namespace, w.name, annotations)
+	if err == nil {
+		err = c.kube.Scale(ctx, w.kind, w.namespace, w.name, w.replicas, 0)
+		if err != nil {
+			_ = c.kube.Annotate(ctx, w.kind, w.namespace, w.name, map[string]*string{
+				replicasAnnotation: nil,
+				scaledAtAnnotation: nil,
+				policyAnnotation:   nil,
+			})
+		}
+	}
+	if err != nil {
+		_ = records.remove(record.Context, record.Kind, record.Namespace, record.Name)
+		return err
+	}
+	return nil
+}
+
+// restore scales a workload back from zero to replicas and removes its
+// annotations and record. If the workload no longer runs zero replicas, it
+// is left alone and its current replicas are returned.
+func (c *Client) restore(ctx context.Context, kind, namespace, name string, replicas int32, records *ScaleRecords) (int32, error) {
+	current, err := c.kube.Replicas(ctx, kind, namespace, name)
+	if apierrors.IsNotFound(err) {
+		_ = records.remove(c.kube.Context, kind, namespace, name)
+		return 0, fmt.Errorf("%s %s/%s no longer exists", kind, namespace, name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if current == 0 {
+		if err := c.kube.Scale(ctx, kind, namespace, name, 0, replicas); err != nil {
+			return 0, err
+		}
+	}
+
+	err = c.kube.Annotate(ctx, kind, namespace, name, map[string]*string{
+		replicasAnnotation: nil,
+		scaledAtAnnotation: nil,
+		policyAnnotation:   nil,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return current, records.remove(c.kube.Context, kind, namespace, name)
+}
+
+// item describes a workload and the action taken on it
+func (w *workload) item(action string) map[string]interface{} {
+	item := map[string]interface{}{
+		"name":      w.name,
+		"namespace": w.namespace,
+		"kind":      w.kind,
+		"replicas":  w.replicas,
+		"action":    action,
+	}
+	if w.confidence > 0 {
+		item["confidence"] = w.confidence
+	}
+	return item
+}
+
+// selected returns true if ref is named in only, as name or kind/name, or
+// only is empty
+func selected(ref kube.WorkloadRef, only []string) bool {
+	if len(only) == 0 {
+		return true
+	}
+	for _, name := range only {
+		kind, base, found := strings.Cut(name, "/")
+		if !found && name == ref.Name {
+			return true
+		}
+		if found && strings.EqualFold(kind, ref.Kind) && base == ref.Name {
+			return true
+		}
+	}
+	return false
+}
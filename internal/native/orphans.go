@@ -0,0 +1,313 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// CleanupOptions configure which orphaned volumes are cleaned up
+type CleanupOptions struct {
+	// Namespace is the namespace to clean up, all if empty. Persistent
+	// volumes belong to the namespace of their claim.
+	Namespace string
+	// MinAge is how long a volume must have been orphaned to be cleaned up
+	MinAge time.Duration
+	// StoragePrice is the price of a GiB-month, to project the savings
+	StoragePrice float64
+	// Apply deletes the orphaned volumes, otherwise they are only listed
+	Apply bool
+}
+
+// orphan is a persistent volume or claim nothing uses
+type orphan struct {
+	kind      string
+	namespace string
+	name      string
+	uid       types.UID
+	class     string
+	reclaim   corev1.PersistentVolumeReclaimPolicy
+	volumeID  string
+	size      float64
+	since     time.Time
+	reason    string
+	// kept is why the orphan is not deleted, "" to delete it
+	kept string
+}
+
+// CleanupVolumes finds the orphaned volumes in a namespace: persistent
+// volumes Released by their deleted claim, volumes whose claim was in a
+// namespace since deleted, and bound claims no pod mounted for MinAge, from
+// the volume stats of the datasource if configured, or since they were
+// created otherwise. Volumes with the Retain reclaim policy, claims of
+// StatefulSets, which keep them for when they scale up, and those in
+// namespaces protected by guardrails are kept. With Apply, the others are
+// deleted, each deletion appended to the audit log first.
+func (c *Client) CleanupVolumes(ctx context.Context, opts CleanupOptions) (map[string]interface{}, error) {
+	orphans, err := c.orphanedVolumes(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var failures []interface{}
+	actions := make([]string, len(orphans))
+	var deleted int
+	var savings, kept float64
+	for i, o := range orphans {
+		cost := o.size / gib * opts.StoragePrice
+		if o.kept != "" {
+			actions[i] = "keep"
+			kept += cost
+			continue
+		}
+		if !opts.Apply {
+			actions[i] = "delete"
+			savings += cost
+			continue
+		}
+		if err := c.auditDeletion(ctx, o); err != nil {
+			failures = append(failures, fmt.Sprintf("%s %s: %v", o.kind, o.path(), err))
+			actions[i] = "failed"
+			o.kept = "the deletion could not be audited"
+			continue
+		}
+		if o.kind == "PersistentVolumeClaim" {
+			err = c.kube.DeletePersistentVolumeClaim(ctx, o.namespace, o.name, o.uid)
+		} else {
+			err = c.kube.DeletePersistentVolume(ctx, o.name, o.uid)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s %s: %v", o.kind, o.path(), err))
+			actions[i] = "failed"
+			o.kept = err.Error()
+			continue
+		}
+		actions[i] = "deleted"
+		deleted++
+		savings += cost
+	}
+
+	items := make([]interface{}, 0, len(orphans))
+	for i, o := range orphans {
+		item := map[string]interface{}{
+			"kind":           o.kind,
+			"name":           o.name,
+			"namespace":      o.namespace,
+			"storage_class":  o.class,
+			"reclaim_policy": string(o.reclaim),
+			"capacity_gib":   round(o.size/gib, 1),
+			"orphaned_days":  round(time.Since(o.since).Hours()/24, 1),
+			"monthly_cost":   round(o.size/gib*opts.StoragePrice, 2),
+			"reason":         o.reason,
+			"action":         actions[i],
+		}
+		if o.volumeID != "" {
+			item["volume_id"] = o.volumeID
+		}
+		if o.kept != "" {
+			item["note"] = o.kept
+		}
+		items = append(items, item)
+	}
+
+	result := map[string]interface{}{
+		"context":       c.kube.Context,
+		"dry_run":       !opts.Apply,
+		"min_age":       formatWindow(opts.MinAge),
+		"savings":       round(savings, 2),
+		"retained_cost": round(kept, 2),
+		"volumes":       items,
+	}
+	deletable := 0
+	for _, action := range actions {
+		if action != "keep" {
+			deletable++
+		}
+	}
+	if opts.Apply {
+		result["message"] = fmt.Sprintf("Deleted %d orphaned volumes saving $%.2f per month, kept %d", deleted, savings, len(orphans)-deletable)
+		if c.guardrails != nil {
+			result["audit_log"] = c.guardrails.AuditPath
+		}
+	} else {
+		result["message"] = fmt.Sprintf("Found %d orphaned volumes: %d to delete saving $%.2f per month, %d kept", len(orphans), deletable, savings, len(orphans)-deletable)
+	}
+	if kept > 0 {
+		result["warning"] = fmt.Sprintf("kept volumes cost $%.2f per month", kept)
+		result["hint"] = "Volumes with the Retain reclaim policy keep their disk when deleted, delete it at the provider once its data is no longer needed"
+	}
+	if len(failures) > 0 {
+		result["partial"] = true
+		result["errors"] = failures
+	}
+	return result, nil
+}
+
+// orphanedVolumes lists the orphaned volumes in the namespace of opts, the
+// most expensive first
+func (c *Client) orphanedVolumes(ctx context.Context, opts CleanupOptions) ([]*orphan, error) {
+	volumes, err := c.kube.PersistentVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := c.kube.PersistentVolumeClaims(ctx, opts.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	namespaces, err := c.kube.NamespaceNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users, err := c.kube.ClaimUsers(ctx, opts.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := c.kube.ClaimOwners(ctx, opts.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var mounted map[string]bool
+	if c.history != nil {
+		if mounted, err = c.history.MountedVolumes(ctx, opts.Namespace, opts.MinAge); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	byName := map[string]*corev1.PersistentVolume{}
+	for i := range volumes {
+		byName[volumes[i].Name] = &volumes[i]
+	}
+	var orphans []*orphan
+	for i := range claims {
+		claim := &claims[i]
+		key := claim.Namespace + "/" + claim.Name
+		if claim.Status.Phase != corev1.ClaimBound || len(users[key]) > 0 || mounted[key] ||
+			now.Sub(claim.CreationTimestamp.Time) < opts.MinAge {
+			continue
+		}
+		o := &orphan{
+			kind:      "PersistentVolumeClaim",
+			namespace: claim.Namespace,
+			name:      claim.Name,
+			uid:       claim.UID,
+			since:     claim.CreationTimestamp.Time,
+			reason:    "not mounted by any pod, created more than " + formatWindow(opts.MinAge) + " ago",
+		}
+		if mounted != nil {
+			o.reason = "not mounted by any pod for " + formatWindow(opts.MinAge)
+			o.since = now.Add(-opts.MinAge)
+		}
+		if claim.Spec.StorageClassName != nil {
+			o.class = *claim.Spec.StorageClassName
+		}
+		if size, ok := claim.Status.Capacity[corev1.ResourceStorage]; ok {
+			o.size = size.AsApproximateFloat64()
+		}
+		if volume := byName[claim.Spec.VolumeName]; volume != nil {
+			o.reclaim = volume.Spec.PersistentVolumeReclaimPolicy
+			o.volumeID = kube.VolumeID(volume)
+			if o.class == "" {
+				o.class = volume.Spec.StorageClassName
+			}
+		}
+		switch set := owner(claim.Namespace, claim.Name); {
+		case set != "":
+			o.kept = "claim of StatefulSet " + set + ", kept for when it scales up"
+		case o.reclaim == corev1.PersistentVolumeReclaimRetain:
+			o.kept = "the volume has the Retain reclaim policy, deleting the claim would keep its disk"
+		}
+		orphans = append(orphans, o)
+	}
+
+	for i := range volumes {
+		volume := &volumes[i]
+		ref := volume.Spec.ClaimRef
+		if ref == nil || opts.Namespace != "" && ref.Namespace != opts.Namespace {
+			continue
+		}
+		o := &orphan{
+			kind:      "PersistentVolume",
+			namespace: ref.Namespace,
+			name:      volume.Name,
+			uid:       volume.UID,
+			class:     volume.Spec.StorageClassName,
+			reclaim:   volume.Spec.PersistentVolumeReclaimPolicy,
+			volumeID:  kube.VolumeID(volume),
+			since:     volume.CreationTimestamp.Time,
+		}
+		switch {
+		case !namespaces[ref.Namespace]:
+			o.reason = fmt.Sprintf("namespace %s of claim %s was deleted", ref.Namespace, ref.Name)
+		case volume.Status.Phase == corev1.VolumeReleased:
+			o.reason = fmt.Sprintf("Released, claim %s/%s was deleted", ref.Namespace, ref.Name)
+		default:
+			continue
+		}
+		if t := volume.Status.LastPhaseTransitionTime; t != nil {
+			o.since = t.Time
+		}
+		if now.Sub(o.since) < opts.MinAge {
+			continue
+		}
+		if size, ok := volume.Spec.Capacity[corev1.ResourceStorage]; ok {
+			o.size = size.AsApproximateFloat64()
+		}
+		if o.reclaim == corev1.PersistentVolumeReclaimRetain {
+			o.kept = "the Retain reclaim policy keeps the volume until its data is no longer needed"
+		}
+		orphans = append(orphans, o)
+	}
+
+	for _, o := range orphans {
+		if o.kept != "" || c.guardrails == nil {
+			continue
+		}
+		for _, pattern := range c.guardrails.ProtectedNamespaces {
+			if ok, _ := path.Match(pattern, o.namespace); ok {
+				o.kept = fmt.Sprintf("namespace %s is protected by the pattern %s", o.namespace, pattern)
+				break
+			}
+		}
+	}
+	sort.SliceStable(orphans, func(i, j int) bool {
+		a, b := orphans[i], orphans[j]
+		if a.size != b.size {
+			return a.size > b.size
+		}
+		return a.kind+"/"+a.path() < b.kind+"/"+b.path()
+	})
+	return orphans, nil
+}
+
+// auditDeletion appends the deletion of an orphaned volume to the audit log
+// of the guardrails
+func (c *Client) auditDeletion(ctx context.Context, o *orphan) error {
+	if c.guardrails == nil {
+		return nil
+	}
+	username, _ := c.kube.User(ctx)
+	return c.guardrails.Audit(AuditEntry{
+		User:      username,
+		Context:   c.kube.Context,
+		Namespace: o.namespace,
+		Kind:      o.kind,
+		Name:      o.name,
+		Action:    "delete orphaned volume",
+		Detail:    fmt.Sprintf("%s, %.1f GiB of %s, reclaim policy %s", o.reason, o.size/gib, o.class, o.reclaim),
+	})
+}
+
+// path names an orphan as namespace/name, or name for a volume
+func (o *orphan) path() string {
+	if o.kind == "PersistentVolume" {
+		return o.name
+	}
+	return o.namespace + "/" + o.name
+}
@@ -0,0 +1,442 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/timeutil"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Defaults of optimization policies
+const (
+	defaultPolicyConfidence = 0.9
+	defaultPolicyWindow     = 24 * time.Hour
+)
+
+// policyRun is the outcome of enforcing one policy
+type policyRun struct {
+	policy *kube.OptimizationPolicy
+	active bool
+	// held counts the workloads the policy holds at zero replicas
+	held      int
+	scaled    int
+	restored  int
+	workloads []interface{}
+	failures  []interface{}
+	message   string
+}
+
+// PolicyCRD returns the CustomResourceDefinition of OptimizationPolicy
+func PolicyCRD() map[string]interface{} {
+	str := map[string]interface{}{"type": "string"}
+	list := map[string]interface{}{"type": "array", "items": str}
+	return map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata": map[string]interface{}{
+			"name":   kube.PolicyResource.Resource + "." + kube.PolicyResource.Group,
+			"labels": agentLabels,
+		},
+		"spec": map[string]interface{}{
+			"group": kube.PolicyResource.Group,
+			"scope": "Cluster",
+			"names": map[string]interface{}{
+				"kind":       "OptimizationPolicy",
+				"listKind":   "OptimizationPolicyList",
+				"plural":     kube.PolicyResource.Resource,
+				"singular":   "optimizationpolicy",
+				"shortNames": []interface{}{"optpol"},
+			},
+			"versions": []interface{}{
+				map[string]interface{}{
+					"name":         kube.PolicyResource.Version,
+					"served":       true,
+					"storage":      true,
+					"subresources": map[string]interface{}{"status": map[string]interface{}{}},
+					"additionalPrinterColumns": []interface{}{
+						map[string]interface{}{"name": "Active", "type": "boolean", "jsonPath": ".status.active"},
+						map[string]interface{}{"name": "Scaled", "type": "integer", "jsonPath": ".status.scaledWorkloads"},
+						map[string]interface{}{"name": "Last Enforced", "type": "date", "jsonPath": ".status.lastEnforced"},
+						map[string]interface{}{"name": "Age", "type": "date", "jsonPath": ".metadata.creationTimestamp"},
+					},
+					"schema": map[string]interface{}{
+						"openAPIV3Schema": map[string]interface{}{
+							"type":     "object",
+							"required": []interface{}{"spec"},
+							"properties": map[string]interface{}{
+								"spec": map[string]interface{}{
+									"type":     "object",
+									"required": []interface{}{"namespaces"},
+									"properties": map[string]interface{}{
+										"namespaces": map[string]interface{}{"type": "array", "items": str, "minItems": 1},
+										"idle": map[string]interface{}{
+											"type": "object",
+											"properties": map[string]interface{}{
+												"minConfidence": map[string]interface{}{"type": "number", "minimum": 0, "maximum": 1},
+												"window":        str,
+											},
+										},
+										"schedule": map[string]interface{}{
+											"type": "array",
+											"items": map[string]interface{}{
+												"type":     "object",
+												"required": []interface{}{"start", "end"},
+												"properties": map[string]interface{}{
+													"days":  list,
+													"start": map[string]interface{}{"type": "string", "pattern": `^([01][0-9]|2[0-3]):[0-5][0-9]$`},
+													"end":   map[string]interface{}{"type": "string", "pattern": `^([01][0-9]|2[0-3]):[0-5][0-9]$`},
+												},
+											},
+										},
+										"timezone":      str,
+										"maxDisruption": map[string]interface{}{"x-kubernetes-int-or-string": true},
+										"suspend":       map[string]interface{}{"type": "boolean"},
+									},
+								},
+								"status": map[string]interface{}{
+									"type": "object",
+									"properties": map[string]interface{}{
+										"observedGeneration": map[string]interface{}{"type": "integer"},
+										"active":             map[string]interface{}{"type": "boolean"},
+										"lastEnforced":       map[string]interface{}{"type": "string", "format": "date-time"},
+										"scaledWorkloads":    map[string]interface{}{"type": "integer"},
+										"message":            str,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// EnforcePolicies enforces every optimization policy once: within its
+// schedule a policy scales the idle workloads of its namespaces to zero, up
+// to its maximum disruption, and outside of it restores those it scaled.
+// The outcome is written to the status of each policy. With dryRun, nothing
+// is changed.
+func (c *Client) EnforcePolicies(ctx context.Context, now time.Time, dryRun bool) (map[string]interface{}, error) {
+	policies, installed, err := c.kube.Policies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !installed {
+		return nil, clierr.New(clierr.CategoryUsage, "POLICY_CRD_MISSING", "the OptimizationPolicy resource is not installed in the cluster").
+			WithHint("Install it with 'upid agent install --controller'")
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+
+	// Annotations on the workloads are the only record the controller needs
+	records, _ := LoadScaleRecords("")
+	scaled, restored := 0, 0
+	items := make([]interface{}, 0, len(policies))
+	workloads := []interface{}{}
+	var failures []interface{}
+	for i := range policies {
+		run := c.enforcePolicy(ctx, &policies[i], now, dryRun, records)
+		scaled += run.scaled
+		restored += run.restored
+		workloads = append(workloads, run.workloads...)
+		failures = append(failures, run.failures...)
+		items = append(items, map[string]interface{}{
+			"name":             run.policy.Name,
+			"active":           run.active,
+			"scaled_workloads": run.held,
+			"message":          run.message,
+		})
+		if dryRun {
+			continue
+		}
+		status := kube.PolicyStatus{
+			ObservedGeneration: run.policy.Generation,
+			Active:             run.active,
+			LastEnforced:       now.UTC().Format(time.RFC3339),
+			ScaledWorkloads:    run.held,
+			Message:            run.message,
+		}
+		if err := c.kube.SetPolicyStatus(ctx, run.policy.Name, status); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", run.policy.Name, err))
+		}
+	}
+
+	message := fmt.Sprintf("Enforced %d policies: scaled %d workloads to zero and restored %d", len(policies), scaled, restored)
+	if dryRun {
+		message = fmt.Sprintf("Dry run: enforcing %d policies would scale %d workloads to zero and restore %d", len(policies), scaled, restored)
+	}
+	result := map[string]interface{}{
+		"message":  message,
+		"context":  c.kube.Context,
+		"dry_run":  dryRun,
+		"policies": items,
+		// The actions on workloads are what a table shows
+		"items": workloads,
+	}
+	if len(failures) > 0 {
+		result["partial"] = true
+		result["errors"] = failures
+	}
+	return result, nil
+}
+
+// enforcePolicy enforces one policy at now
+func (c *Client) enforcePolicy(ctx context.Context, policy *kube.OptimizationPolicy, now time.Time, dryRun bool, records *ScaleRecords) *policyRun {
+	run := &policyRun{policy: policy}
+	spec := policy.Spec
+	fail := func(err error) *policyRun {
+		run.message = err.Error()
+		run.failures = append(run.failures, fmt.Sprintf("%s: %v", policy.Name, err))
+		return run
+	}
+
+	active, err := scheduleActive(spec.Schedule, spec.Timezone, now)
+	if err != nil {
+		return fail(err)
+	}
+	confidence := spec.Idle.MinConfidence
+	if confidence == 0 {
+		confidence = defaultPolicyConfidence
+	}
+	window := defaultPolicyWindow
+	if spec.Idle.Window != "" {
+		if window, err = timeutil.ParseDuration(spec.Idle.Window); err != nil || window == 0 {
+			return fail(fmt.Errorf("invalid idle window %q", spec.Idle.Window))
+		}
+	}
+
+	held, err := c.policyWorkloads(ctx, policy)
+	if err != nil {
+		return fail(err)
+	}
+	run.held = len(held)
+	run.active = active && !spec.Suspend
+
+	switch {
+	case spec.Suspend:
+		run.message = "suspended"
+	case !active:
+		for _, w := range held {
+			if dryRun {
+				run.restored++
+				run.workloads = append(run.workloads, policyItem(policy, w, fmt.Sprintf("restore %s/%s to %d replicas", w.kind, w.name, w.replicas)))
+				continue
+			}
+			if _, err := c.restore(ctx, w.kind, w.namespace, w.name, w.replicas, records); err != nil {
+				run.failures = append(run.failures, fmt.Sprintf("%s: %s/%s: %v", policy.Name, w.kind, w.name, err))
+				run.workloads = append(run.workloads, policyItem(policy, w, fmt.Sprintf("failed to restore %s/%s to %d replicas", w.kind, w.name, w.replicas)))
+				continue
+			}
+			run.held--
+			run.restored++
+			run.workloads = append(run.workloads, policyItem(policy, w, fmt.Sprintf("restored %s/%s to %d replicas", w.kind, w.name, w.replicas)))
+		}
+		run.message = "outside of schedule"
+	default:
+		if err := c.scaleIdle(ctx, run, confidence, window, dryRun, records); err != nil {
+			return fail(err)
+		}
+		run.message = fmt.Sprintf("%d workloads at zero replicas", run.held)
+	}
+	if len(run.failures) > 0 {
+		run.message = fmt.Sprintf("%s, %d failures", run.message, len(run.failures))
+	}
+	return run
+}
+
+// scaleIdle scales the idle workloads of the namespaces of a policy to zero
+// while it holds fewer than its maximum disruption allows. Workloads failing
+// guardrails are skipped.
+func (c *Client) scaleIdle(ctx context.Context, run *policyRun, confidence float64, window time.Duration, dryRun bool, records *ScaleRecords) error {
+	policy := run.policy
+	if c.history == nil {
+		window = 0
+	}
+
+	g := c.newGuard(true, time.Now())
+	var candidates []*workload
+	total := 0
+	for _, namespace := range policy.Spec.Namespaces {
+		found, excluded, snapshot, err := c.zeroPodCandidates(ctx, namespace, confidence, window)
+		if err != nil {
+			return err
+		}
+		found, blocked, err := c.guardWorkloads(ctx, g, found)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, found...)
+		for _, w := range excluded {
+			item := policyItem(policy, w, fmt.Sprintf("left %s/%s at %d replicas, excluded: %s", w.kind, w.name, w.replicas, w.excluded))
+			item["skipped"] = true
+			run.workloads = append(run.workloads, item)
+		}
+		for _, w := range blocked {
+			item := policyItem(policy, w, fmt.Sprintf("left %s/%s at %d replicas, blocked by guardrails: %s", w.kind, w.name, w.replicas, violationMessages(w.violations)))
+			item["skipped"] = true
+			run.workloads = append(run.workloads, item)
+		}
+		for _, w := range snapshot.Workloads {
+			if w.Kind == "Deployment" || w.Kind == "StatefulSet" {
+				total++
+			}
+		}
+	}
+
+	limit := len(candidates) + run.held
+	if policy.Spec.MaxDisruption != nil {
+		max, err := intstr.GetScaledValueFromIntOrPercent(policy.Spec.MaxDisruption, total, false)
+		if err != nil {
+			return fmt.Errorf("invalid maxDisruption: %v", err)
+		}
+		limit = max
+	}
+
+	for _, w := range candidates {
+		w.policy = policy.Name
+		switch {
+		case run.held >= limit:
+			item := policyItem(policy, w, fmt.Sprintf("left %s/%s at %d replicas, maxDisruption of %d reached", w.kind, w.name, w.replicas, limit))
+			item["skipped"] = true
+			run.workloads = append(run.workloads, item)
+		case dryRun:
+			run.held++
+			run.scaled++
+			run.workloads = append(run.workloads, policyItem(policy, w, fmt.Sprintf("scale %s/%s from %d to 0 replicas", w.kind, w.name, w.replicas)))
+		default:
+			if err := c.scaleToZero(ctx, w, records); err != nil {
+				run.failures = append(run.failures, fmt.Sprintf("%s: %s/%s: %v", policy.Name, w.kind, w.name, err))
+				run.workloads = append(run.workloads, policyItem(policy, w, fmt.Sprintf("failed to scale %s/%s to 0 replicas", w.kind, w.name)))
+				continue
+			}
+			run.held++
+			run.scaled++
+			run.workloads = append(run.workloads, policyItem(policy, w, fmt.Sprintf("scaled %s/%s from %d to 0 replicas", w.kind, w.name, w.replicas)))
+		}
+	}
+	return nil
+}
+
+// policyWorkloads returns the workloads a policy scaled to zero, with the
+// replicas to restore
+func (c *Client) policyWorkloads(ctx context.Context, policy *kube.OptimizationPolicy) ([]*workload, error) {
+	var held []*workload
+	for _, namespace := range policy.Spec.Namespaces {
+		owners, err := c.kube.Annotated(ctx, namespace, policyAnnotation)
+		if err != nil {
+			return nil, kube.APIError(err, "list workloads")
+		}
+		replicas, err := c.kube.Annotated(ctx, namespace, replicasAnnotation)
+		if err != nil {
+			return nil, kube.APIError(err, "list workloads")
+		}
+		for ref, owner := range owners {
+			count, err := strconv.ParseInt(replicas[ref], 10, 32)
+			if owner != policy.Name || err != nil || count <= 0 {
+				continue
+			}
+			held = append(held, &workload{kind: ref.Kind, namespace: namespace, name: ref.Name, replicas: int32(count), policy: owner})
+		}
+	}
+	sort.Slice(held, func(i, j int) bool {
+		if held[i].namespace != held[j].namespace {
+			return held[i].namespace < held[j].namespace
+		}
+		if held[i].kind != held[j].kind {
+			return held[i].kind < held[j].kind
+		}
+		return held[i].name < held[j].name
+	})
+	return held, nil
+}
+
+// policyItem describes a workload and the action a policy took on it
+func policyItem(policy *kube.OptimizationPolicy, w *workload, action string) map[string]interface{} {
+	item := w.item(action)
+	item["policy"] = policy.Name
+	return item
+}
+
+// scheduleActive returns true if now falls within one of the windows of a
+// schedule in timezone. An empty schedule is always active.
+func scheduleActive(schedule []kube.PolicyWindow, timezone string, now time.Time) (bool, error) {
+	if len(schedule) == 0 {
+		return true, nil
+	}
+	location := time.UTC
+	if timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(timezone); err != nil {
+			return false, fmt.Errorf("invalid timezone %q", timezone)
+		}
+	}
+	now = now.In(location)
+	minute := now.Hour()*60 + now.Minute()
+
+	for _, window := range schedule {
+		start, err := minuteOfDay(window.Start)
+		if err != nil {
+			return false, err
+		}
+		end, err := minuteOfDay(window.End)
+		if err != nil {
+			return false, err
+		}
+		today, err := onDay(window.Days, now.Weekday())
+		if err != nil {
+			return false, err
+		}
+		if start < end {
+			if today && minute >= start && minute < end {
+				return true, nil
+			}
+			continue
+		}
+		// The window ends the next day
+		yesterday, _ := onDay(window.Days, (now.Weekday()+6)%7)
+		if (today && minute >= start) || (yesterday && minute < end) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// minuteOfDay parses a time of day as HH:MM
+func minuteOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (expected HH:MM)", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// onDay returns true if day is in days, given as names or three letter
+// abbreviations, or days is empty
+func onDay(days []string, day time.Weekday) (bool, error) {
+	if len(days) == 0 {
+		return true, nil
+	}
+	found := false
+	for _, name := range days {
+		name = strings.ToLower(name)
+		known := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			full := strings.ToLower(d.String())
+			if name == full || name == full[:3] {
+				known = true
+				found = found || d == day
+			}
+		}
+		if !known {
+			return false, fmt.Errorf("invalid day %q", name)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,274 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/gitops"
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// PullRequestOptions configure proposing recommendations through the Git
+// repository workloads are deployed from
+type PullRequestOptions struct {
+	// Repo is the clone URL of the repository
+	Repo string
+	// Path is the directory of the repository to look for manifests and
+	// charts in, all of it if empty
+	Path string
+	// Base is the branch to merge into, the default branch if empty
+	Base string
+	// Branch is the branch to create, generated if empty
+	Branch string
+	// Provider and API override the host and API guessed from Repo
+	Provider string
+	API      string
+	// DryRun edits a clone and returns the diff, without pushing it or
+	// opening a pull request
+	DryRun bool
+}
+
+// RightsizePullRequest right-sizes the workloads like Rightsize, and opens a
+// pull request that sets the new requests and limits in their manifests or
+// Helm values, with the savings in its description. Workloads not found in
+// the repository are reported in a warning, and those failing guardrails
+// are left out unless overridden.
+func (c *Client) RightsizePullRequest(ctx context.Context, opts RightsizeOptions, pr PullRequestOptions) (map[string]interface{}, error) {
+	result, resizes, warnings, err := c.rightsize(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	resizes, blocked, err := c.guardResizes(ctx, resizes, pr.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocked) > 0 {
+		result["blocked"] = blocked
+	}
+	result["repository"] = pr.Repo
+	defer func() {
+		if len(warnings) > 0 {
+			result["warning"] = strings.Join(warnings, "; ")
+		}
+	}()
+	if len(resizes) == 0 {
+		result["message"] = fmt.Sprintf("%s; nothing to change, no pull request opened", result["message"])
+		return result, nil
+	}
+
+	// Fail before cloning without a way to open the pull request
+	var remote *gitops.Remote
+	var token string
+	if !pr.DryRun {
+		if remote, err = gitops.ParseRemote(pr.Repo, pr.Provider, pr.API); err != nil {
+			return nil, err
+		}
+		if token, err = remote.Token(); err != nil {
+			return nil, err
+		}
+	}
+
+	workloads, refs, err := c.gitopsWorkloads(ctx, resizes)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "upid-gitops-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	repo, err := gitops.Clone(ctx, pr.Repo, dir, pr.Base)
+	if err != nil {
+		return nil, err
+	}
+	base, err := repo.Branch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	branch := pr.Branch
+	if branch == "" {
+		branch = "upid/rightsize-" + time.Now().UTC().Format("20060102-150405")
+	}
+	if err := repo.Checkout(ctx, branch); err != nil {
+		return nil, err
+	}
+	edits, err := gitops.ApplyResources(dir, filepath.Join(dir, pr.Path), workloads)
+	if err != nil {
+		return nil, fmt.Errorf("failed to edit the repository: %v", err)
+	}
+
+	var changes []interface{}
+	var missing []string
+	var changed []*containerSizing
+	for i, w := range workloads {
+		if len(edits[i]) == 0 {
+			missing = append(missing, w.Namespace+"/"+w.Kind+"/"+w.Name)
+			continue
+		}
+		changed = append(changed, refs[i]...)
+		for _, edit := range edits[i] {
+			changes = append(changes, map[string]interface{}{
+				"namespace": w.Namespace,
+				"kind":      w.Kind,
+				"name":      w.Name,
+				"file":      edit.File,
+				"source":    edit.Source,
+			})
+		}
+	}
+	if len(missing) > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d workloads were not found in the repository and are left out: %s",
+			len(missing), strings.Join(missing, ", ")))
+	}
+	if len(changes) == 0 {
+		return nil, clierr.New(clierr.CategoryGeneral, "MANIFESTS_NOT_FOUND",
+			fmt.Sprintf("none of the %d workloads to resize were found in %s", len(workloads), pr.Repo)).
+			WithHint("Point --path at the directory with their manifests or Helm charts")
+	}
+	result["changes"] = changes
+	result["branch"] = branch
+	result["base"] = base
+
+	title := fmt.Sprintf("Right-size requests and limits of %d workloads", len(workloads)-len(missing))
+	if pr.DryRun {
+		diff, err := repo.Diff(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result["diff"] = diff
+		result["message"] = fmt.Sprintf("Dry run: %q would change %d files on %s for %s", title, countFiles(changes), branch, base)
+		return result, nil
+	}
+	if err := repo.Commit(ctx, title); err != nil {
+		return nil, err
+	}
+	if err := repo.Push(ctx, branch); err != nil {
+		return nil, err
+	}
+	url, err := remote.Open(ctx, token, gitops.PullRequest{
+		Title: title,
+		Body:  pullRequestBody(changed, opts, c.kube.Context),
+		Head:  branch,
+		Base:  base,
+	})
+	if err != nil {
+		return nil, err
+	}
+	request := "pull request"
+	if remote.Provider == gitops.ProviderGitLab {
+		request = "merge request"
+	}
+	result["pull_request"] = url
+	result["message"] = fmt.Sprintf("Opened a %s to right-size %d workloads, saving %.2f per month: %s",
+		request, len(workloads)-len(missing), totalSavings(changed), url)
+	return result, nil
+}
+
+// gitopsWorkloads groups the containers to resize by workload, with the
+// chart and release of those installed with Helm. Bare pods, ReplicaSets
+// and Jobs are left out.
+func (c *Client) gitopsWorkloads(ctx context.Context, resizes []*containerSizing) ([]gitops.Workload, [][]*containerSizing, error) {
+	byWorkload := map[string][]*containerSizing{}
+	var keys []string
+	for _, s := range resizes {
+		if !kube.SupportsManifest(s.ref.Kind) {
+			continue
+		}
+		key := s.namespace + "/" + s.ref.Kind + "/" + s.ref.Name
+		if byWorkload[key] == nil {
+			keys = append(keys, key)
+		}
+		byWorkload[key] = append(byWorkload[key], s)
+	}
+	sort.Strings(keys)
+
+	workloads := make([]gitops.Workload, 0, len(keys))
+	refs := make([][]*containerSizing, 0, len(keys))
+	for _, key := range keys {
+		sizings := byWorkload[key]
+		w := gitops.Workload{Kind: sizings[0].ref.Kind, Namespace: sizings[0].namespace, Name: sizings[0].ref.Name}
+		manifest, err := c.kube.Manifest(ctx, w.Kind, w.Namespace, w.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		metadata, _ := manifest["metadata"].(map[string]interface{})
+		labels, _ := metadata["labels"].(map[string]interface{})
+		annotations, _ := metadata["annotations"].(map[string]interface{})
+		if chart, _ := labels["helm.sh/chart"].(string); chart != "" {
+			w.Chart = chartName(chart)
+			w.Release, _ = annotations["meta.helm.sh/release-name"].(string)
+			if w.Release == "" {
+				w.Release, _ = labels["app.kubernetes.io/instance"].(string)
+			}
+		}
+		for _, s := range sizings {
+			container := gitops.Container{
+				Name:     s.name,
+				Requests: map[string]string{"cpu": formatCPU(s.recommended.requests.CPU), "memory": formatMemory(s.recommended.requests.Memory)},
+				Limits:   map[string]string{"memory": formatMemory(s.recommended.limits.Memory)},
+			}
+			if s.recommended.limits.CPU > 0 {
+				container.Limits["cpu"] = formatCPU(s.recommended.limits.CPU)
+			}
+			w.Containers = append(w.Containers, container)
+		}
+		workloads = append(workloads, w)
+		refs = append(refs, sizings)
+	}
+	return workloads, refs, nil
+}
+
+// chartName strips the version from the helm.sh/chart label, e.g. "redis"
+// from "redis-17.3.2"
+func chartName(label string) string {
+	if i := strings.LastIndex(label, "-"); i > 0 && i+1 < len(label) && label[i+1] >= '0' && label[i+1] <= '9' {
+		return label[:i]
+	}
+	return label
+}
+
+// pullRequestBody describes the changes and their savings in Markdown
+func pullRequestBody(changed []*containerSizing, opts RightsizeOptions, kubeContext string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Right-sizes the requests and limits of %d containers to their p%d usage over the last %s plus %.0f%% headroom, "+
+		"with %.0f%% over the peak for memory limits. The requests of their pods change by %.2f per month.\n\n",
+		len(changed), opts.Percentile, formatWindow(opts.Window), opts.Headroom*100, opts.OOMBuffer*100, totalSavings(changed))
+	b.WriteString("| Workload | Container | CPU request | Memory request | CPU limit | Memory limit | Monthly savings |\n")
+	b.WriteString("|---|---|---|---|---|---|---|\n")
+	for _, s := range changed {
+		fmt.Fprintf(&b, "| %s/%s/%s | %s | %s → %s | %s → %s | %s → %s | %s → %s | %.2f |\n",
+			s.namespace, s.ref.Kind, s.ref.Name, s.name,
+			formatQuantity(s.requests.CPU, formatCPU), formatCPU(s.recommended.requests.CPU),
+			formatQuantity(s.requests.Memory, formatMemory), formatMemory(s.recommended.requests.Memory),
+			formatQuantity(s.limits.CPU, formatCPU), formatQuantity(s.recommended.limits.CPU, formatCPU),
+			formatQuantity(s.limits.Memory, formatMemory), formatMemory(s.recommended.limits.Memory),
+			s.savings)
+	}
+	fmt.Fprintf(&b, "\nGenerated by `upid optimize apply --via-pr` from the usage of cluster context `%s`.\n", kubeContext)
+	return b.String()
+}
+
+// totalSavings is the monthly savings of resizing containers
+func totalSavings(sizings []*containerSizing) float64 {
+	var total float64
+	for _, s := range sizings {
+		total += s.savings
+	}
+	return total
+}
+
+// countFiles counts the distinct files of changes
+func countFiles(changes []interface{}) int {
+	files := map[string]bool{}
+	for _, change := range changes {
+		files[change.(map[string]interface{})["file"].(string)] = true
+	}
+	return len(files)
+}
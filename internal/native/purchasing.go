@@ -0,0 +1,369 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// Risks of running a workload on nodes that can be interrupted
+const (
+	riskLow    = "low"
+	riskMedium = "medium"
+	riskHigh   = "high"
+)
+
+// riskOrder ranks the risks of interruption
+var riskOrder = map[string]int{riskLow: 0, riskMedium: 1, riskHigh: 2}
+
+// poolLabels are the node labels that name node pools, by provider
+var poolLabels = []string{
+	"eks.amazonaws.com/nodegroup",
+	"karpenter.sh/nodepool",
+	"cloud.google.com/gke-nodepool",
+	"kubernetes.azure.com/agentpool",
+	"agentpool",
+	"node.kubernetes.io/instance-type",
+}
+
+// spotLabels are the node labels, with their values, that mark nodes that
+// can be interrupted
+var spotLabels = map[string]string{
+	"eks.amazonaws.com/capacityType":        "SPOT",
+	"karpenter.sh/capacity-type":            "spot",
+	"cloud.google.com/gke-spot":             "true",
+	"cloud.google.com/gke-preemptible":      "true",
+	"kubernetes.azure.com/scalesetpriority": "spot",
+	"node.kubernetes.io/lifecycle":          "spot",
+}
+
+// commitments are the names of commitment discounts by the scheme of node
+// provider IDs
+var commitments = map[string]string{
+	"aws":   "Savings Plan or Reserved Instances",
+	"gce":   "committed use discount",
+	"azure": "savings plan or reservation",
+}
+
+// PurchasingOptions configure purchasing recommendations
+type PurchasingOptions struct {
+	// Window is how long on-demand nodes must have run to be steady state
+	Window time.Duration
+	// Term is the length of commitments in years
+	Term int
+	// CommitmentDiscount and SpotDiscount are the discounts off on-demand
+	// prices, as fractions
+	CommitmentDiscount float64
+	SpotDiscount       float64
+	// Prices are used to price nodes by their capacity
+	Prices ComputePrices
+}
+
+// purchasingPool is the nodes of a node pool and the workloads on them
+type purchasingPool struct {
+	name     string
+	nodes    int
+	spot     int
+	steady   int
+	onDemand float64
+	// steadyCost is the monthly cost of the steady state on-demand nodes
+	steadyCost float64
+	capacity   kube.Resources
+	requested  kube.Resources
+	workloads  map[string]*workloadRisk
+}
+
+// workloadRisk is the risk of a workload tolerating interruption
+type workloadRisk struct {
+	namespace string
+	kind      string
+	name      string
+	pods      int
+	pools     map[string]bool
+	risk      string
+	reason    string
+}
+
+// RecommendPurchasing recommends how to buy the capacity of each node pool.
+// On-demand nodes that ran for the whole window are steady state, and worth
+// a commitment discount: a Savings Plan or Reserved Instances on AWS, a
+// committed use discount on GCP. Pools whose workloads tolerate
+// interruption are worth moving to spot capacity instead, or their nodes
+// beyond the steady state if the risk is medium. Savings are projected to a
+// month.
+func (c *Client) RecommendPurchasing(ctx context.Context, opts PurchasingOptions) (map[string]interface{}, error) {
+	snapshot, err := c.kube.Collect(ctx, kube.CollectOptions{RunningOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	steadySince := snapshot.CollectedAt.Add(-opts.Window)
+
+	pools := map[string]*purchasingPool{}
+	nodePools := map[string]*purchasingPool{}
+	spotNodes := map[string]bool{}
+	provider := ""
+	for i := range snapshot.Nodes {
+		node := &snapshot.Nodes[i]
+		name := nodePool(node)
+		p := pools[name]
+		if p == nil {
+			p = &purchasingPool{name: name, workloads: map[string]*workloadRisk{}}
+			pools[name] = p
+		}
+		nodePools[node.Name] = p
+		p.nodes++
+		p.capacity = p.capacity.Add(node.Allocatable)
+		if node.Object != nil && provider == "" {
+			provider, _, _ = strings.Cut(node.Object.Spec.ProviderID, "://")
+		}
+		if isSpot(node) {
+			p.spot++
+			spotNodes[node.Name] = true
+			continue
+		}
+		cost := nodeCost(node.Capacity, opts.Prices)
+		p.onDemand += cost
+		if node.Created.Before(steadySince) {
+			p.steady++
+			p.steadyCost += cost
+		}
+	}
+
+	workloads := map[string]*workloadRisk{}
+	for i := range snapshot.Pods {
+		pod := &snapshot.Pods[i]
+		p := nodePools[pod.Node]
+		if p == nil {
+			continue
+		}
+		p.requested = p.requested.Add(pod.Requests())
+		if pod.Workload.Kind == "DaemonSet" {
+			// Runs on every node of the pool, whatever it costs
+			continue
+		}
+		key := pod.Namespace + "/" + pod.Workload.Kind + "/" + pod.Workload.Name
+		w := workloads[key]
+		if w == nil {
+			w = &workloadRisk{namespace: pod.Namespace, kind: pod.Workload.Kind, name: pod.Workload.Name, pools: map[string]bool{}}
+			workloads[key] = w
+		}
+		w.pods++
+		w.pools[p.name] = true
+		p.workloads[key] = w
+		if spotNodes[pod.Node] {
+			continue
+		}
+		risk, reason := interruptionRisk(snapshot, pod)
+		if w.risk == "" || riskOrder[risk] > riskOrder[w.risk] {
+			w.risk, w.reason = risk, reason
+		}
+	}
+	for _, w := range workloads {
+		if w.risk == "" {
+			w.risk, w.reason = riskLow, "already runs on spot capacity"
+		} else if w.risk == riskLow && w.pods < 2 {
+			w.risk, w.reason = riskHigh, "a single replica is unavailable while it is rescheduled"
+		}
+	}
+
+	commitment := commitments[provider]
+	if commitment == "" {
+		commitment = "commitment"
+	}
+	months := float64(12 * opts.Term)
+	breakEven := round(months*(1-opts.CommitmentDiscount), 1)
+
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var poolItems, recommendations []interface{}
+	var total, savings, committed float64
+	for _, name := range names {
+		p := pools[name]
+		risk := riskLow
+		for _, w := range p.workloads {
+			if riskOrder[w.risk] > riskOrder[risk] {
+				risk = w.risk
+			}
+		}
+		total += p.onDemand
+		poolItems = append(poolItems, map[string]interface{}{
+			"pool":              p.name,
+			"nodes":             p.nodes,
+			"spot_nodes":        p.spot,
+			"steady_nodes":      p.steady,
+			"cpu_requested":     percent(p.requested.CPU, p.capacity.CPU),
+			"memory_requested":  percent(p.requested.Memory, p.capacity.Memory),
+			"on_demand_cost":    round(p.onDemand, 2),
+			"steady_cost":       round(p.steadyCost, 2),
+			"interruption_risk": risk,
+			"workloads":         len(p.workloads),
+		})
+
+		burst := p.onDemand - p.steadyCost
+		spotCost, commitCost := 0.0, 0.0
+		switch risk {
+		case riskLow:
+			spotCost = p.onDemand
+		case riskMedium:
+			spotCost, commitCost = burst, p.steadyCost
+		default:
+			commitCost = p.steadyCost
+		}
+		if spotCost > 0 {
+			saved := spotCost * opts.SpotDiscount
+			savings += saved
+			nodes := p.nodes - p.spot
+			reason := "its workloads tolerate interruption"
+			if risk == riskMedium {
+				nodes -= p.steady
+				reason = "its nodes beyond the steady state serve workloads that tolerate interruption with medium risk"
+			}
+			recommendations = append(recommendations, map[string]interface{}{
+				"pool":            p.name,
+				"purchase":        "spot",
+				"nodes":           nodes,
+				"monthly_cost":    round(spotCost, 2),
+				"monthly_savings": round(saved, 2),
+				"risk":            risk,
+				"reason":          reason,
+			})
+		}
+		if commitCost > 0 {
+			saved := commitCost * opts.CommitmentDiscount
+			savings += saved
+			committed += commitCost * (1 - opts.CommitmentDiscount)
+			reason := fmt.Sprintf("%d nodes ran on demand for the last %s", p.steady, formatWindow(opts.Window))
+			if risk != riskLow {
+				reason += fmt.Sprintf(" and serve workloads with %s risk of interruption", risk)
+			}
+			recommendations = append(recommendations, map[string]interface{}{
+				"pool":              p.name,
+				"purchase":          commitment,
+				"nodes":             p.steady,
+				"monthly_cost":      round(commitCost, 2),
+				"monthly_savings":   round(saved, 2),
+				"hourly_commitment": round(commitCost*(1-opts.CommitmentDiscount)/month.Hours(), 4),
+				"break_even_months": breakEven,
+				"risk":              risk,
+				"reason":            reason,
+			})
+		}
+	}
+
+	keys := make([]string, 0, len(workloads))
+	for key := range workloads {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := workloads[keys[i]], workloads[keys[j]]
+		if a.risk != b.risk {
+			return riskOrder[a.risk] > riskOrder[b.risk]
+		}
+		return keys[i] < keys[j]
+	})
+	workloadItems := make([]interface{}, len(keys))
+	for i, key := range keys {
+		w := workloads[key]
+		pools := make([]string, 0, len(w.pools))
+		for pool := range w.pools {
+			pools = append(pools, pool)
+		}
+		sort.Strings(pools)
+		workloadItems[i] = map[string]interface{}{
+			"namespace": w.namespace,
+			"kind":      w.kind,
+			"name":      w.name,
+			"pods":      w.pods,
+			"pools":     strings.Join(pools, ","),
+			"risk":      w.risk,
+			"reason":    w.reason,
+		}
+	}
+	if poolItems == nil {
+		poolItems = []interface{}{}
+	}
+	if recommendations == nil {
+		recommendations = []interface{}{}
+	}
+
+	result := map[string]interface{}{
+		"message": fmt.Sprintf("%d purchasing recommendations for %d node pools: %.2f per month in savings of %.2f on demand",
+			len(recommendations), len(pools), savings, total),
+		"context":             c.kube.Context,
+		"steady_window":       formatWindow(opts.Window),
+		"term_years":          opts.Term,
+		"commitment":          commitment,
+		"commitment_discount": round(100*opts.CommitmentDiscount, 1),
+		"spot_discount":       round(100*opts.SpotDiscount, 1),
+		"break_even_months":   breakEven,
+		"on_demand_cost":      round(total, 2),
+		"committed_cost":      round(committed, 2),
+		"monthly_savings":     round(savings, 2),
+		"pools":               poolItems,
+		"recommendations":     recommendations,
+		"workloads":           workloadItems,
+	}
+	if committed > 0 {
+		result["warning"] = fmt.Sprintf("a %d-year %s costs %.2f per month whether the nodes run or not; it only saves if they keep running for %v of its %v months",
+			opts.Term, commitment, committed, breakEven, months)
+	}
+	return result, nil
+}
+
+// nodePool returns the node pool of a node from its labels, or its instance
+// type
+func nodePool(node *kube.Node) string {
+	for _, label := range poolLabels {
+		if value := node.Labels[label]; value != "" {
+			return value
+		}
+	}
+	if node.InstanceType != "" {
+		return node.InstanceType
+	}
+	return "(default)"
+}
+
+// isSpot reports whether a node is spot or preemptible capacity
+func isSpot(node *kube.Node) bool {
+	for label, value := range spotLabels {
+		if strings.EqualFold(node.Labels[label], value) {
+			return true
+		}
+	}
+	return false
+}
+
+// interruptionRisk returns the risk of a pod being interrupted, with the
+// reason. Replicas are checked over the whole workload afterwards.
+func interruptionRisk(snapshot *kube.Snapshot, pod *kube.Pod) (string, string) {
+	if pod.Object != nil {
+		if pod.Object.Annotations["cluster-autoscaler.kubernetes.io/safe-to-evict"] == "false" {
+			return riskHigh, "annotated as not safe to evict"
+		}
+		for _, volume := range pod.Object.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				return riskHigh, "uses persistent volume claim " + volume.PersistentVolumeClaim.ClaimName
+			}
+		}
+	}
+	switch pod.Workload.Kind {
+	case "Pod":
+		return riskHigh, "a bare pod is not recreated when its node is interrupted"
+	case "StatefulSet":
+		return riskHigh, "stateful pods keep their identity and are slow to move"
+	case "Job", "CronJob":
+		return riskMedium, "interrupted runs start over"
+	}
+	if w, ok := snapshot.Workload(pod.Workload.Kind, pod.Namespace, pod.Workload.Name); ok && w.Replicas != nil && *w.Replicas < 2 {
+		return riskHigh, "a single replica is unavailable while it is rescheduled"
+	}
+	return riskLow, "stateless with several replicas"
+}
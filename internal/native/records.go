@@ -0,0 +1,112 @@
+package native
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ScaleRecord remembers the replicas of a workload scaled to zero
+type ScaleRecord struct {
+	Context   string    `json:"context"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Replicas  int32     `json:"replicas"`
+	ScaledAt  time.Time `json:"scaled_at"`
+}
+
+// ScaleRecords is the local state file of zero-pod scaling. It keeps the
+// previous replicas of scaled workloads in case their annotations are lost.
+type ScaleRecords struct {
+	path    string
+	Records []ScaleRecord `json:"records"`
+}
+
+// LoadScaleRecords reads the state file at path; a missing file holds no
+// records. An empty path keeps the records in memory only.
+func LoadScaleRecords(path string) (*ScaleRecords, error) {
+	records := &ScaleRecords{path: path}
+	if path == "" {
+		return records, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zero-pod state: %v", err)
+	}
+	if err := json.Unmarshal(data, records); err != nil {
+		return nil, fmt.Errorf("invalid zero-pod state file %s: %v", path, err)
+	}
+	return records, nil
+}
+
+// Path returns the location of the state file
+func (r *ScaleRecords) Path() string {
+	return r.path
+}
+
+// put adds or replaces the record of a workload and saves the file
+func (r *ScaleRecords) put(record ScaleRecord) error {
+	r.drop(record.Context, record.Kind, record.Namespace, record.Name)
+	r.Records = append(r.Records, record)
+	return r.save()
+}
+
+// remove deletes the record of a workload and saves the file
+func (r *ScaleRecords) remove(kubeContext, kind, namespace, name string) error {
+	if !r.drop(kubeContext, kind, namespace, name) {
+		return nil
+	}
+	return r.save()
+}
+
+// drop deletes the record of a workload and returns true if there was one
+func (r *ScaleRecords) drop(kubeContext, kind, namespace, name string) bool {
+	for i, record := range r.Records {
+		if record.matches(kubeContext, kind, namespace, name) {
+			r.Records = append(r.Records[:i], r.Records[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// save replaces the state file, readable only by the user
+func (r *ScaleRecords) save() error {
+	if r.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode zero-pod state: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	// Write to a temporary file first so an interrupted write never loses
+	// the replicas to restore
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write zero-pod state: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write zero-pod state: %v", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write zero-pod state: %v", err)
+	}
+	return nil
+}
+
+func (r ScaleRecord) matches(kubeContext, kind, namespace, name string) bool {
+	return r.Context == kubeContext && r.Kind == kind && r.Namespace == namespace && r.Name == name
+}
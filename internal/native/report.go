@@ -0,0 +1,150 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// DefaultReportTop is how many workloads a report lists as top offenders
+const DefaultReportTop = 10
+
+// ReportOptions configure the summary report of a cluster
+type ReportOptions struct {
+	// Window is the time range of usage from the datasource, 0 for the
+	// current usage from metrics-server
+	Window time.Duration
+	// MinConfidence is the confidence from which pods are idle
+	MinConfidence float64
+	// Prices price nodes by their capacity and pods by their requests
+	Prices ComputePrices
+	// StoragePrice and LoadBalancerPrice price the other resources, as in
+	// CostOptions
+	StoragePrice      float64
+	LoadBalancerPrice float64
+	// SharedNamespaces hold pods that serve the whole cluster, whose cost
+	// is spread over the namespaces with idle capacity
+	SharedNamespaces []string
+	// Top is how many workloads are listed as top offenders
+	Top int
+}
+
+// Report summarizes the cost of the cluster: its breakdown by kind of
+// resource and by namespace, the workloads that waste the most, and the
+// recommendations to scale idle workloads to zero or right-size those that
+// use less than half their requests. Costs are monthly.
+func (c *Client) Report(ctx context.Context, opts ReportOptions) (map[string]interface{}, error) {
+	cost, err := c.AnalyzeCost(ctx, CostOptions{Prices: opts.Prices, StoragePrice: opts.StoragePrice, LoadBalancerPrice: opts.LoadBalancerPrice})
+	if err != nil {
+		return nil, err
+	}
+	allocation, err := c.AnalyzeAllocation(ctx, AllocationOptions{
+		By:               Dimension{Kind: "namespace"},
+		Idle:             IdleProportional,
+		SharedNamespaces: opts.SharedNamespaces,
+		Prices:           opts.Prices,
+	})
+	if err != nil {
+		return nil, err
+	}
+	pods, snapshot, err := c.runningPods(ctx, "", opts.Window, false)
+	if err != nil {
+		return nil, err
+	}
+	candidates, _, _, err := c.zeroPodCandidates(ctx, "", opts.MinConfidence, opts.Window)
+	if err != nil {
+		return nil, err
+	}
+
+	recommendations := recommendWorkloads(pods, snapshot, candidates, opts.Prices)
+	sort.SliceStable(recommendations, func(i, j int) bool { return recommendations[i].savings > recommendations[j].savings })
+	type summary struct {
+		count   int
+		savings float64
+	}
+	byAction := map[string]*summary{actionScaleToZero: {}, actionRightsize: {}}
+	bySeverity := map[string]*summary{}
+	for _, severity := range kube.Severities {
+		bySeverity[severity] = &summary{}
+	}
+	var savings float64
+	for _, r := range recommendations {
+		for _, s := range []*summary{byAction[r.action], bySeverity[r.severity()]} {
+			s.count++
+			s.savings += r.savings
+		}
+		savings += r.savings
+	}
+	var requested, used float64
+	workloads := map[string]bool{}
+	for _, p := range pods {
+		requested += requestCost(p.request, 1, opts.Prices)
+		used += requestCost(p.used, 1, opts.Prices)
+		workloads[p.pod.Namespace+"/"+p.pod.Workload.Kind+"/"+p.pod.Workload.Name] = true
+	}
+
+	top := opts.Top
+	if top <= 0 {
+		top = DefaultReportTop
+	}
+	offenders := make([]interface{}, 0, top)
+	for _, r := range recommendations[:min(top, len(recommendations))] {
+		offenders = append(offenders, map[string]interface{}{
+			"namespace":      r.namespace,
+			"workload":       r.name,
+			"kind":           r.kind,
+			"pods":           r.pods,
+			"action":         r.action,
+			"requested_cost": round(r.requested, 2),
+			"used_cost":      round(r.used, 2),
+			"utilization":    percent(r.used, r.requested),
+			"savings":        round(r.savings, 2),
+			"severity":       r.severity(),
+		})
+	}
+	actions := make([]interface{}, 0, len(byAction))
+	for _, action := range []string{actionScaleToZero, actionRightsize} {
+		actions = append(actions, map[string]interface{}{
+			"action":          action,
+			"recommendations": byAction[action].count,
+			"savings":         round(byAction[action].savings, 2),
+		})
+	}
+	severities := make([]interface{}, 0, len(kube.Severities))
+	for i := len(kube.Severities) - 1; i >= 0; i-- {
+		severity := kube.Severities[i]
+		severities = append(severities, map[string]interface{}{
+			"severity":        severity,
+			"recommendations": bySeverity[severity].count,
+			"savings":         round(bySeverity[severity].savings, 2),
+		})
+	}
+
+	usage := "current usage from metrics-server"
+	if c.history != nil && opts.Window > 0 {
+		usage = "average usage over " + formatWindow(opts.Window) + " from the datasource"
+	}
+	monthly, _ := cost["monthly_cost"].(float64)
+	return map[string]interface{}{
+		"message": fmt.Sprintf("%.2f per month for context %s, with %.2f per month of savings in %d recommendations",
+			monthly, c.kube.Context, savings, len(recommendations)),
+		"context":         c.kube.Context,
+		"generated_at":    time.Now().UTC().Format(time.RFC3339),
+		"usage":           usage,
+		"pods":            len(pods),
+		"workloads":       len(workloads),
+		"monthly_cost":    round(monthly, 2),
+		"requested_cost":  round(requested, 2),
+		"used_cost":       round(used, 2),
+		"idle_cost":       allocation["idle_cost"],
+		"savings":         round(savings, 2),
+		"breakdown":       cost["breakdown"],
+		"namespaces":      allocation["groups"],
+		"offenders":       offenders,
+		"recommendations": actions,
+		"severities":      severities,
+	}, nil
+}
@@ -0,0 +1,282 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// percentiles are the usage percentiles requests can be sized to
+var percentiles = map[string]int{"p90": 90, "p95": 95, "p99": 99}
+
+// RightsizeOptions configure how requests and limits are sized
+type RightsizeOptions struct {
+	// Namespace is the namespace to right-size, all if empty
+	Namespace string
+	// Window is the time range of usage from the datasource
+	Window time.Duration
+	// Percentile of the CPU and memory usage that requests are sized to
+	Percentile int
+	// Headroom is the margin over the percentile for requests, and over the
+	// peak for CPU limits, as a fraction
+	Headroom float64
+	// OOMBuffer is the margin over the peak memory, or over the limit of
+	// containers that were OOM killed, for memory limits, as a fraction
+	OOMBuffer float64
+	// Prices are used to project the savings of the new requests
+	Prices ComputePrices
+	// ExportDir, if set, is where a patch and the updated manifest of each
+	// workload to resize are written, as patches of PatchType
+	ExportDir string
+	PatchType string
+}
+
+// ParsePercentile parses a usage percentile given as p90, p95 or p99
+func ParsePercentile(value string) (int, error) {
+	if p, ok := percentiles[strings.ToLower(value)]; ok {
+		return p, nil
+	}
+	return 0, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+		fmt.Sprintf("invalid percentile %q (expected p90, p95 or p99)", value))
+}
+
+// containerSizing is the usage and settings of one container of a workload
+// over its pods
+type containerSizing struct {
+	ref       kube.WorkloadRef
+	namespace string
+	name      string
+	podLabels map[string]string
+	pods      int
+	requests  kube.Resources
+	limits    kube.Resources
+	oomKilled bool
+	// Usage is that of the busiest pod
+	cpu, cpuMax, memory, memoryMax float64
+	// recommended and savings are set by recommend; a CPU limit of 0 is
+	// left unset
+	recommended struct {
+		requests kube.Resources
+		limits   kube.Resources
+	}
+	savings float64
+}
+
+// Rightsize recommends requests and limits for every container of the
+// workloads in a namespace from their usage over a window from the
+// datasource: requests fit the percentile of the busiest pod plus headroom,
+// CPU limits, where set, its peak plus headroom, and memory limits its peak
+// plus the OOM buffer. Savings are those of the requests over all pods,
+// projected to a month.
+func (c *Client) Rightsize(ctx context.Context, opts RightsizeOptions) (map[string]interface{}, error) {
+	result, resizes, warnings, err := c.rightsize(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ExportDir != "" {
+		files, skipped, err := c.exportManifests(ctx, resizes, opts.ExportDir, opts.PatchType)
+		if err != nil {
+			return nil, err
+		}
+		result["message"] = fmt.Sprintf("%s; %s patches for %d workloads written to %s",
+			result["message"], opts.PatchType, len(files), opts.ExportDir)
+		result["manifests"] = files
+		if skipped > 0 {
+			warnings = append(warnings, fmt.Sprintf("%d workloads are bare pods, ReplicaSets or Jobs, whose pod templates cannot be patched, and were not exported", skipped))
+		}
+	}
+	if len(warnings) > 0 {
+		result["warning"] = strings.Join(warnings, "; ")
+	}
+	return result, nil
+}
+
+// rightsize recommends the requests and limits, and returns the result with
+// the containers to resize and the warnings
+func (c *Client) rightsize(ctx context.Context, opts RightsizeOptions) (map[string]interface{}, []*containerSizing, []string, error) {
+	if c.history == nil || opts.Window == 0 {
+		return nil, nil, nil, clierr.New(clierr.CategoryUsage, "DATASOURCE_NOT_CONFIGURED", "right-sizing needs usage history from a datasource").
+			WithHint("Configure one with 'upid config datasource set --url <url>'")
+	}
+
+	snapshot, err := c.kube.Collect(ctx, kube.CollectOptions{Namespace: opts.Namespace, RunningOnly: true})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	usage, err := c.history.ContainerHistory(ctx, opts.Namespace, opts.Window, float64(opts.Percentile)/100)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	exclusions := snapshot.Excluded(c.exclusions)
+	var excluded []interface{}
+	reported := map[string]bool{}
+	containers := map[string]*containerSizing{}
+	unobserved := map[string]bool{}
+	for i := range snapshot.Pods {
+		pod := &snapshot.Pods[i]
+		if workloadKey := pod.Namespace + "/" + pod.Workload.Kind + "/" + pod.Workload.Name; exclusions[workloadKey] != "" {
+			if !reported[workloadKey] {
+				reported[workloadKey] = true
+				excluded = append(excluded, excludedItem(pod.Namespace, pod.Workload.Kind, pod.Workload.Name, exclusions[workloadKey]))
+			}
+			continue
+		}
+		for _, container := range pod.Containers {
+			key := pod.Namespace + "/" + pod.Workload.Kind + "/" + pod.Workload.Name + "/" + container.Name
+			u, ok := usage[pod.Namespace+"/"+pod.Name+"/"+container.Name]
+			if !ok {
+				// Not scraped by the datasource
+				if containers[key] == nil {
+					unobserved[key] = true
+				}
+				continue
+			}
+			delete(unobserved, key)
+			s := containers[key]
+			if s == nil {
+				s = &containerSizing{ref: pod.Workload, namespace: pod.Namespace, name: container.Name,
+					podLabels: pod.Labels, requests: container.Requests, limits: container.Limits}
+				containers[key] = s
+			}
+			s.pods++
+			s.oomKilled = s.oomKilled || container.LastTermination == "OOMKilled"
+			s.cpu = math.Max(s.cpu, u.CPU)
+			s.cpuMax = math.Max(s.cpuMax, u.CPUMax)
+			s.memory = math.Max(s.memory, u.Memory)
+			s.memoryMax = math.Max(s.memoryMax, u.MemoryMax)
+		}
+	}
+
+	keys := make([]string, 0, len(containers))
+	for key := range containers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var items []map[string]interface{}
+	var resizes []*containerSizing
+	workloads := map[string]bool{}
+	var savings float64
+	for _, key := range keys {
+		s := containers[key]
+		item, monthly, resize := s.recommend(opts)
+		workloads[s.namespace+"/"+s.ref.Kind+"/"+s.ref.Name] = true
+		savings += monthly
+		if resize {
+			resizes = append(resizes, s)
+		}
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i]["monthly_savings"].(float64) > items[j]["monthly_savings"].(float64)
+	})
+	list := make([]interface{}, len(items))
+	for i, item := range items {
+		list[i] = item
+	}
+
+	impact := fmt.Sprintf("%.2f per month in savings", savings)
+	if savings < 0 {
+		impact = fmt.Sprintf("%.2f per month more in requests", -savings)
+	}
+	result := map[string]interface{}{
+		"message": fmt.Sprintf("%d of %d containers in %d workloads need new requests or limits at p%d with %.0f%% headroom: %s",
+			len(resizes), len(items), len(workloads), opts.Percentile, opts.Headroom*100, impact),
+		"context":            c.kube.Context,
+		"time_range":         formatWindow(opts.Window),
+		"datasource":         c.history.URL(),
+		"percentile":         fmt.Sprintf("p%d", opts.Percentile),
+		"headroom_percent":   round(opts.Headroom*100, 1),
+		"oom_buffer_percent": round(opts.OOMBuffer*100, 1),
+		"monthly_savings":    round(savings, 2),
+		"containers":         list,
+	}
+	if len(excluded) > 0 {
+		result["excluded"] = excluded
+	}
+	var warnings []string
+	if len(unobserved) > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d containers have no usage in the last %s at %s and are not right-sized",
+			len(unobserved), formatWindow(opts.Window), c.history.URL()))
+	}
+	return result, resizes, warnings, nil
+}
+
+// recommend sizes the requests and limits of a container, and returns them
+// with the monthly savings of the requests over all pods and whether any
+// setting changes by more than resizeThreshold
+func (s *containerSizing) recommend(opts RightsizeOptions) (map[string]interface{}, float64, bool) {
+	cpuRequest := math.Max(math.Ceil(s.cpu*(1+opts.Headroom)*1000)/1000, minCPURequest)
+	memoryRequest := math.Max(math.Ceil(s.memory*(1+opts.Headroom)/(1<<20))*(1<<20), minMemoryRequest)
+
+	memoryPeak := s.memoryMax
+	if s.oomKilled && s.limits.Memory > memoryPeak {
+		// The peak was cut short by the limit
+		memoryPeak = s.limits.Memory
+	}
+	memoryLimit := math.Max(math.Ceil(memoryPeak*(1+opts.OOMBuffer)/(1<<20))*(1<<20), memoryRequest)
+	// CPU limits throttle rather than kill, so they are only sized where set
+	var cpuLimit float64
+	if s.limits.CPU > 0 {
+		cpuLimit = math.Max(math.Ceil(s.cpuMax*(1+opts.Headroom)*1000)/1000, cpuRequest)
+	}
+
+	s.recommended.requests = kube.Resources{CPU: cpuRequest, Memory: memoryRequest}
+	s.recommended.limits = kube.Resources{CPU: cpuLimit, Memory: memoryLimit}
+
+	hourly := (s.requests.CPU-cpuRequest)*opts.Prices.CPU + (s.requests.Memory-memoryRequest)/(1<<30)*opts.Prices.Memory
+	savings := hourly * float64(s.pods) * month.Hours()
+	s.savings = savings
+	resize := resized(s.requests.CPU, cpuRequest) || resized(s.requests.Memory, memoryRequest) ||
+		resized(s.limits.CPU, cpuLimit) || resized(s.limits.Memory, memoryLimit)
+
+	item := map[string]interface{}{
+		"name":                       s.ref.Name,
+		"namespace":                  s.namespace,
+		"kind":                       s.ref.Kind,
+		"container":                  s.name,
+		"pods":                       s.pods,
+		"cpu_usage":                  round(s.cpu, 3),
+		"cpu_peak":                   round(s.cpuMax, 3),
+		"memory_usage_mib":           round(s.memory/(1<<20), 1),
+		"memory_peak_mib":            round(s.memoryMax/(1<<20), 1),
+		"cpu_request":                formatQuantity(s.requests.CPU, formatCPU),
+		"recommended_cpu_request":    formatCPU(cpuRequest),
+		"cpu_limit":                  formatQuantity(s.limits.CPU, formatCPU),
+		"recommended_cpu_limit":      formatQuantity(cpuLimit, formatCPU),
+		"memory_request":             formatQuantity(s.requests.Memory, formatMemory),
+		"recommended_memory_request": formatMemory(memoryRequest),
+		"memory_limit":               formatQuantity(s.limits.Memory, formatMemory),
+		"recommended_memory_limit":   formatMemory(memoryLimit),
+		"monthly_savings":            round(savings, 2),
+		"oom_killed":                 s.oomKilled,
+		"action":                     "keep",
+	}
+	if resize {
+		item["action"] = "resize"
+	}
+	return item, savings, resize
+}
+
+// resized reports whether a setting differs from the current one by more
+// than resizeThreshold, or is new
+func resized(current, value float64) bool {
+	if current == 0 {
+		return value > 0
+	}
+	return math.Abs(value-current)/current > resizeThreshold
+}
+
+// formatQuantity formats a request or limit, "none" if unset
+func formatQuantity(value float64, format func(float64) string) string {
+	if value == 0 {
+		return "none"
+	}
+	return format(value)
+}
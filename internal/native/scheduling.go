@@ -0,0 +1,239 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/prometheus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Scheduling and churn issues found by AnalyzeScheduling, in the order
+// they are listed
+const (
+	issuePending          = "pending"
+	issueCrashLoop        = "crashloop"
+	issueOOMKilled        = "oom-killed"
+	issueEvicted          = "evicted"
+	issuePreempted        = "preempted"
+	issueFailedScheduling = "failed-scheduling"
+)
+
+// issueOrder ranks issues for listing
+var issueOrder = map[string]int{
+	issuePending:          0,
+	issueCrashLoop:        1,
+	issueOOMKilled:        2,
+	issueEvicted:          3,
+	issuePreempted:        4,
+	issueFailedScheduling: 5,
+}
+
+// eventRetention is how long the API server keeps events by default
+const eventRetention = time.Hour
+
+// AnalyzeScheduling reports the pods in namespace (all if empty) that are
+// pending, crashlooping, OOM killed, evicted or preempted, and the failed
+// scheduling attempts of the others, over the window. Pending pods and
+// crashloops are read from the cluster, the rest from events; with a
+// datasource OOM kills and evictions are counted from kube-state-metrics,
+// which outlive events.
+func (c *Client) AnalyzeScheduling(ctx context.Context, namespace string, window time.Duration) (map[string]interface{}, error) {
+	snapshot, err := c.kube.Collect(ctx, kube.CollectOptions{Namespace: namespace, Events: true, EventWindow: window})
+	if err != nil {
+		return nil, err
+	}
+	var churn *prometheus.Churn
+	if c.history != nil {
+		if churn, err = c.history.ChurnHistory(ctx, namespace, window); err != nil {
+			return nil, err
+		}
+	}
+
+	var issues []map[string]interface{}
+	add := func(kind, namespace, pod, container, workload string, count int, reason, message string) map[string]interface{} {
+		item := map[string]interface{}{
+			"type":      kind,
+			"namespace": namespace,
+			"pod":       pod,
+			"count":     count,
+			"reason":    reason,
+			"message":   message,
+		}
+		if container != "" {
+			item["container"] = container
+		}
+		if workload != "" {
+			item["workload"] = workload
+		}
+		issues = append(issues, item)
+		return item
+	}
+
+	pending := map[string]map[string]interface{}{}
+	evicted := map[string]bool{}
+	oomKilled := map[string]bool{}
+	for i := range snapshot.Pods {
+		pod := &snapshot.Pods[i]
+		key := pod.Namespace + "/" + pod.Name
+		workload := pod.Workload.Kind + "/" + pod.Workload.Name
+		status := &pod.Object.Status
+
+		switch {
+		case pod.Phase == string(corev1.PodPending):
+			reason, message := pendingReason(pod.Object)
+			item := add(issuePending, pod.Namespace, pod.Name, "", workload, 1, reason, message)
+			item["since"] = pod.Created.UTC().Format(time.RFC3339)
+			pending[key] = item
+		case pod.Phase == string(corev1.PodFailed) && status.Reason == "Evicted":
+			add(issueEvicted, pod.Namespace, pod.Name, "", workload, 1, status.Reason, status.Message)
+			evicted[key] = true
+		}
+
+		for _, container := range status.ContainerStatuses {
+			containerKey := key + "/" + container.Name
+			if waiting := container.State.Waiting; waiting != nil && waiting.Reason == "CrashLoopBackOff" {
+				item := add(issueCrashLoop, pod.Namespace, pod.Name, container.Name, workload, int(container.RestartCount), waiting.Reason, waiting.Message)
+				if terminated := container.LastTerminationState.Terminated; terminated != nil {
+					item["message"] = fmt.Sprintf("last exited with %s (code %d): %s", terminated.Reason, terminated.ExitCode, waiting.Message)
+					if !terminated.FinishedAt.IsZero() {
+						item["last_seen"] = terminated.FinishedAt.UTC().Format(time.RFC3339)
+					}
+				}
+			}
+			terminated := container.LastTerminationState.Terminated
+			switch {
+			case churn != nil:
+				if kills := churn.OOMKills[containerKey]; kills > 0 {
+					add(issueOOMKilled, pod.Namespace, pod.Name, container.Name, workload, int(math.Round(kills)), "OOMKilled",
+						fmt.Sprintf("%d restarts in the time range, the last after an OOM kill", int(math.Round(churn.Restarts[containerKey]))))
+				}
+				oomKilled[containerKey] = true
+			case terminated != nil && terminated.Reason == "OOMKilled" &&
+				(terminated.FinishedAt.IsZero() || terminated.FinishedAt.After(time.Now().Add(-window))):
+				item := add(issueOOMKilled, pod.Namespace, pod.Name, container.Name, workload, 1, terminated.Reason,
+					fmt.Sprintf("last terminated by an OOM kill, %d restarts in total", container.RestartCount))
+				if !terminated.FinishedAt.IsZero() {
+					item["last_seen"] = terminated.FinishedAt.UTC().Format(time.RFC3339)
+				}
+			}
+		}
+	}
+
+	if churn != nil {
+		// Pods deleted since
+		keys := make([]string, 0, len(churn.OOMKills))
+		for key := range churn.OOMKills {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if oomKilled[key] {
+				continue
+			}
+			parts := strings.SplitN(key, "/", 3)
+			add(issueOOMKilled, parts[0], parts[1], parts[2], "", int(math.Round(churn.OOMKills[key])), "OOMKilled",
+				fmt.Sprintf("%d restarts in the time range, the last after an OOM kill", int(math.Round(churn.Restarts[key]))))
+		}
+		for _, key := range churn.Evicted {
+			if evicted[key] {
+				continue
+			}
+			evicted[key] = true
+			ns, name, _ := strings.Cut(key, "/")
+			add(issueEvicted, ns, name, "", "", 1, "Evicted", "evicted in the time range")
+		}
+	}
+
+	for _, event := range snapshot.Events {
+		if event.Kind != "Pod" {
+			continue
+		}
+		key := event.Namespace + "/" + event.Object
+		var item map[string]interface{}
+		switch event.Reason {
+		case "FailedScheduling":
+			if item = pending[key]; item != nil {
+				item["count"] = int(event.Count)
+				item["message"] = event.Message
+			} else {
+				item = add(issueFailedScheduling, event.Namespace, event.Object, "", "", int(event.Count), event.Reason, event.Message)
+			}
+		case "Preempted":
+			item = add(issuePreempted, event.Namespace, event.Object, "", "", int(event.Count), event.Reason, event.Message)
+		case "Evicted":
+			if evicted[key] {
+				continue
+			}
+			evicted[key] = true
+			item = add(issueEvicted, event.Namespace, event.Object, "", "", int(event.Count), event.Reason, event.Message)
+		default:
+			continue
+		}
+		item["last_seen"] = event.LastSeen.UTC().Format(time.RFC3339)
+	}
+
+	counts := map[string]int{}
+	for _, item := range issues {
+		kind := item["type"].(string)
+		if kind == issuePending || kind == issueCrashLoop {
+			counts[kind]++
+		} else {
+			counts[kind] += item["count"].(int)
+		}
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		a, b := issues[i], issues[j]
+		if a["type"] != b["type"] {
+			return issueOrder[a["type"].(string)] < issueOrder[b["type"].(string)]
+		}
+		return a["count"].(int) > b["count"].(int)
+	})
+	list := make([]interface{}, len(issues))
+	for i, item := range issues {
+		list[i] = item
+	}
+
+	result := map[string]interface{}{
+		"message": fmt.Sprintf("In the last %s: %d pending pods, %d crashlooping containers, %d OOM kills, %d evictions, %d preemptions and %d failed scheduling attempts",
+			formatWindow(window), counts[issuePending], counts[issueCrashLoop], counts[issueOOMKilled], counts[issueEvicted],
+			counts[issuePreempted], counts[issueFailedScheduling]),
+		"context":           c.kube.Context,
+		"time_range":        formatWindow(window),
+		"pending":           counts[issuePending],
+		"crashloops":        counts[issueCrashLoop],
+		"oom_kills":         counts[issueOOMKilled],
+		"evictions":         counts[issueEvicted],
+		"preemptions":       counts[issuePreempted],
+		"failed_scheduling": counts[issueFailedScheduling],
+		"issues":            list,
+	}
+	if churn != nil {
+		result["datasource"] = c.history.URL()
+	} else if window > eventRetention {
+		result["warning"] = "the API server keeps events for about an hour by default, so older OOM kills, evictions and preemptions may be missing"
+		result["hint"] = "Configure a datasource with kube-state-metrics with 'upid config datasource set --url <url>' to count them over the whole time range"
+	}
+	return result, nil
+}
+
+// pendingReason explains why a pod is pending: the scheduler could not
+// place it, or its containers are waiting to start
+func pendingReason(pod *corev1.Pod) (string, string) {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse {
+			return condition.Reason, condition.Message
+		}
+	}
+	for _, container := range pod.Status.ContainerStatuses {
+		if waiting := container.State.Waiting; waiting != nil {
+			return waiting.Reason, waiting.Message
+		}
+	}
+	return "Pending", ""
+}
@@ -0,0 +1,159 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/prometheus"
+)
+
+// SLOAnnotation lists the SLOs of a workload, by the sloth_service or
+// sloth_id of their rules, when its name is not that of their service
+const SLOAnnotation = "upid.io/slo"
+
+// Error budget headroom of the workload of a recommendation
+const (
+	// HeadroomAvailable is enough budget left, not burning faster than the
+	// period allows, to absorb the change
+	HeadroomAvailable = "headroom"
+	// HeadroomLimited is some budget left, but less than the minimum or
+	// burning too fast
+	HeadroomLimited = "limited"
+	// HeadroomExhausted is no budget left: the change should wait
+	HeadroomExhausted = "exhausted"
+	// HeadroomUnknown is a workload without an SLO
+	HeadroomUnknown = "no-slo"
+)
+
+// SLOReportOptions configure the SLO-aware efficiency report
+type SLOReportOptions struct {
+	// Window is the time range of usage from the datasource
+	Window time.Duration
+	// MinConfidence is the confidence from which pods are idle
+	MinConfidence float64
+	// Prices price pods by their requests
+	Prices ComputePrices
+	// MinBudget is the ratio of the error budget that must be left for a
+	// workload to have headroom
+	MinBudget float64
+}
+
+// SLOReport joins the recommendations of the summary report with the error
+// budgets of the SLOs recorded by Sloth rules in the datasource, so that
+// each recommendation shows whether its workload has the headroom to absorb
+// it. The SLOs of a workload are those whose service is its name, in its
+// namespace if they have one, or those listed by its upid.io/slo
+// annotation; the one with the least budget left counts.
+func (c *Client) SLOReport(ctx context.Context, opts SLOReportOptions) (map[string]interface{}, error) {
+	if c.history == nil || opts.Window == 0 {
+		return nil, clierr.New(clierr.CategoryUsage, "DATASOURCE_NOT_CONFIGURED", "error budgets are only known from a datasource").
+			WithHint("Configure one with 'upid config datasource set --url <url>'")
+	}
+	slos, err := c.history.SLOs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pods, snapshot, err := c.runningPods(ctx, "", opts.Window, false)
+	if err != nil {
+		return nil, err
+	}
+	candidates, _, _, err := c.zeroPodCandidates(ctx, "", opts.MinConfidence, opts.Window)
+	if err != nil {
+		return nil, err
+	}
+	recommendations := recommendWorkloads(pods, snapshot, candidates, opts.Prices)
+	sort.SliceStable(recommendations, func(i, j int) bool { return recommendations[i].savings > recommendations[j].savings })
+
+	savings := map[string]float64{}
+	counts := map[string]int{}
+	items := make([]interface{}, 0, len(recommendations))
+	for _, r := range recommendations {
+		var names []string
+		if w, ok := snapshot.Workload(r.kind, r.namespace, r.name); ok && w.Annotations[SLOAnnotation] != "" {
+			names = strings.Split(w.Annotations[SLOAnnotation], ",")
+		}
+		slo := workloadSLO(slos, r.namespace, r.name, names)
+		headroom := budgetHeadroom(slo, opts.MinBudget)
+		savings[headroom] += r.savings
+		counts[headroom]++
+
+		item := map[string]interface{}{
+			"namespace": r.namespace,
+			"workload":  r.name,
+			"kind":      r.kind,
+			"action":    r.action,
+			"savings":   round(r.savings, 2),
+			"severity":  r.severity(),
+			"headroom":  headroom,
+		}
+		if slo != nil {
+			item["slo"] = slo.ID
+			item["objective"] = round(100*slo.Objective, 3)
+			item["budget_remaining"] = round(100*slo.BudgetRemaining, 1)
+			if slo.HasBurnRate {
+				item["burn_rate"] = round(slo.BurnRate, 2)
+			}
+		}
+		items = append(items, item)
+	}
+
+	summary := make([]interface{}, 0, 4)
+	for _, headroom := range []string{HeadroomAvailable, HeadroomLimited, HeadroomExhausted, HeadroomUnknown} {
+		summary = append(summary, map[string]interface{}{
+			"headroom":        headroom,
+			"recommendations": counts[headroom],
+			"savings":         round(savings[headroom], 2),
+		})
+	}
+	return map[string]interface{}{
+		"message": fmt.Sprintf("%d recommendations for context %s: %d with error budget headroom saving %.2f per month, %d to defer",
+			len(recommendations), c.kube.Context, counts[HeadroomAvailable], savings[HeadroomAvailable], counts[HeadroomExhausted]),
+		"context":         c.kube.Context,
+		"generated_at":    time.Now().UTC().Format(time.RFC3339),
+		"slos":            len(slos),
+		"min_budget":      round(100*opts.MinBudget, 1),
+		"summary":         summary,
+		"recommendations": items,
+	}, nil
+}
+
+// workloadSLO returns the SLO of a workload with the least budget left, nil
+// if it has none. names are those of its annotation, if any.
+func workloadSLO(slos []prometheus.SLO, namespace, name string, names []string) *prometheus.SLO {
+	var found *prometheus.SLO
+	for i := range slos {
+		slo := &slos[i]
+		if slo.Namespace != "" && slo.Namespace != namespace {
+			continue
+		}
+		match := false
+		if names == nil {
+			match = slo.Service == name
+		}
+		for _, n := range names {
+			n = strings.TrimSpace(n)
+			match = match || n == slo.Service || n == slo.ID
+		}
+		if match && (found == nil || slo.BudgetRemaining < found.BudgetRemaining) {
+			found = slo
+		}
+	}
+	return found
+}
+
+// budgetHeadroom classifies the error budget of an SLO
+func budgetHeadroom(slo *prometheus.SLO, minBudget float64) string {
+	switch {
+	case slo == nil:
+		return HeadroomUnknown
+	case slo.BudgetRemaining <= 0:
+		return HeadroomExhausted
+	case slo.BudgetRemaining < minBudget || (slo.HasBurnRate && slo.BurnRate >= 1):
+		return HeadroomLimited
+	}
+	return HeadroomAvailable
+}
@@ -0,0 +1,288 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/billing"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultSnapshotPrice is the list price in USD of a GB-month of EBS
+// snapshots in the standard tier
+const DefaultSnapshotPrice = 0.05
+
+// archiveDiscount is the share of the standard price snapshots in the
+// archive tier cost
+const archiveDiscount = 0.25
+
+// duplicateWindow is how soon after a snapshot a newer one of the same
+// source makes it a duplicate
+const duplicateWindow = 24 * time.Hour
+
+// SnapshotOptions configure the analysis of volume snapshots
+type SnapshotOptions struct {
+	// Namespace is the namespace of the snapshots, all if empty. Snapshots
+	// at the provider belong to the namespace of the claim of their volume.
+	Namespace string
+	// MaxAge is the age beyond which snapshots are old, and planned for
+	// deletion unless they are the newest of their source
+	MaxAge time.Duration
+	// Price is the price of a GB-month of snapshots
+	Price float64
+	// Cloud lists the EBS snapshots of the account in a region, nil to
+	// analyze those of the cluster only. It is called for clusters with EBS
+	// volumes, in Region, or that of the nodes if empty.
+	Cloud  func(ctx context.Context, region string) ([]billing.Snapshot, error)
+	Region string
+}
+
+// volumeSnapshot is a snapshot of the cluster or of one of its volumes at
+// the provider
+type volumeSnapshot struct {
+	kind      string
+	namespace string
+	name      string
+	// source is the claim or volume the snapshot was taken of
+	source string
+	// content is the VolumeSnapshotContent of a VolumeSnapshot
+	content  string
+	id       string
+	size     float64
+	created  time.Time
+	archived bool
+	// retained is true for snapshots whose content, and the snapshot at
+	// the provider, outlive them
+	retained bool
+	ready    bool
+	flags    []string
+	delete   bool
+}
+
+// AnalyzeSnapshots lists the CSI VolumeSnapshots of a namespace and the EBS
+// snapshots of the volumes of the cluster, with their monthly cost at the
+// price of their full size, an upper bound as EBS bills snapshots of a
+// volume incrementally. Snapshots older than MaxAge are flagged old,
+// snapshots followed by another of the same source within a day duplicate,
+// and contents whose VolumeSnapshot was deleted orphaned. The cleanup plan
+// deletes the old snapshots but the newest of each source that is ready to
+// restore from.
+func (c *Client) AnalyzeSnapshots(ctx context.Context, opts SnapshotOptions) (map[string]interface{}, error) {
+	csi, installed, err := c.kube.VolumeSnapshots(ctx, opts.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := c.kube.PersistentVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []*volumeSnapshot
+	handles := map[string]bool{}
+	for _, s := range csi {
+		snapshot := &volumeSnapshot{
+			kind:      "VolumeSnapshot",
+			namespace: s.Namespace,
+			name:      s.Name,
+			source:    s.Namespace + "/" + s.Claim,
+			content:   s.Content,
+			id:        s.Handle,
+			size:      s.Size,
+			created:   s.Created,
+			retained:  s.DeletionPolicy == "Retain",
+			ready:     s.Ready,
+		}
+		if s.Name == "" {
+			snapshot.kind = "VolumeSnapshotContent"
+			snapshot.name = s.Content
+			snapshot.source = s.Content
+			snapshot.flags = append(snapshot.flags, "orphaned")
+		}
+		if !s.Ready {
+			snapshot.flags = append(snapshot.flags, "not ready")
+		}
+		if s.Handle != "" {
+			handles[s.Handle] = true
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	// Snapshots at the provider of the volumes of the cluster, named by
+	// their claim
+	claims := map[string]string{}
+	for i := range volumes {
+		if id := kube.VolumeID(&volumes[i]); id != "" && volumes[i].Spec.ClaimRef != nil {
+			claims[id] = volumes[i].Spec.ClaimRef.Namespace + "/" + volumes[i].Spec.ClaimRef.Name
+		}
+	}
+	var cloud []billing.Snapshot
+	var cloudErr error
+	region := opts.Region
+	if opts.Cloud != nil && len(claims) > 0 {
+		if region == "" {
+			region, err = c.nodeRegion(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+		cloud, cloudErr = opts.Cloud(ctx, region)
+	}
+	for _, s := range cloud {
+		claim, ok := claims[s.VolumeID]
+		if !ok || handles[s.ID] {
+			continue
+		}
+		if namespace, _, _ := strings.Cut(claim, "/"); opts.Namespace != "" && namespace != opts.Namespace {
+			continue
+		}
+		snapshot := &volumeSnapshot{
+			kind:     "EBSSnapshot",
+			name:     s.ID,
+			source:   claim,
+			id:       s.ID,
+			size:     float64(s.Size) * gib,
+			created:  s.Started,
+			archived: s.Tier == "archive",
+			ready:    s.State == "" || s.State == "completed",
+		}
+		snapshot.namespace, _, _ = strings.Cut(claim, "/")
+		snapshots = append(snapshots, snapshot)
+	}
+
+	// Newest first within each source, which is kept
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		a, b := snapshots[i], snapshots[j]
+		if a.source != b.source {
+			return a.source < b.source
+		}
+		return a.created.After(b.created)
+	})
+	// A source keeps its newest snapshot that is ready to restore from
+	now := time.Now()
+	restorable := map[string]bool{}
+	for i, s := range snapshots {
+		if i > 0 && snapshots[i-1].source == s.source && snapshots[i-1].created.Sub(s.created) < duplicateWindow {
+			s.flags = append(s.flags, "duplicate")
+		}
+		if now.Sub(s.created) > opts.MaxAge {
+			s.flags = append(s.flags, "old")
+			s.delete = restorable[s.source] || s.kind == "VolumeSnapshotContent"
+		}
+		if s.ready {
+			restorable[s.source] = true
+		}
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		a, b := snapshots[i], snapshots[j]
+		if a.size != b.size {
+			return a.size > b.size
+		}
+		return a.created.Before(b.created)
+	})
+	items := make([]interface{}, 0, len(snapshots))
+	plan := []string{}
+	var cost, savings float64
+	var old, duplicates int
+	for _, s := range snapshots {
+		monthly := s.size / gib * opts.Price
+		if s.archived {
+			monthly *= archiveDiscount
+		}
+		cost += monthly
+		item := map[string]interface{}{
+			"kind":         s.kind,
+			"name":         s.name,
+			"namespace":    s.namespace,
+			"source":       s.source,
+			"size_gib":     round(s.size/gib, 1),
+			"age_days":     round(now.Sub(s.created).Hours()/24, 1),
+			"monthly_cost": round(monthly, 2),
+			"flags":        strings.Join(s.flags, ","),
+			"action":       "keep",
+		}
+		if s.id != "" {
+			item["snapshot_id"] = s.id
+		}
+		for _, flag := range s.flags {
+			switch flag {
+			case "old":
+				old++
+			case "duplicate":
+				duplicates++
+			}
+		}
+		if s.delete {
+			command := s.command(region)
+			item["action"] = "delete"
+			item["command"] = command
+			plan = append(plan, command)
+			savings += monthly
+		}
+		items = append(items, item)
+	}
+
+	result := map[string]interface{}{
+		"context":      c.kube.Context,
+		"max_age":      formatWindow(opts.MaxAge),
+		"monthly_cost": round(cost, 2),
+		"savings":      round(savings, 2),
+		"snapshots":    items,
+		"plan":         plan,
+		"message": fmt.Sprintf("%d snapshots cost up to $%.2f per month: %d old, %d duplicate; deleting %d old snapshots saves up to $%.2f",
+			len(items), cost, old, duplicates, len(plan), savings),
+	}
+	switch {
+	case cloudErr != nil:
+		result["warning"] = fmt.Sprintf("failed to list the EBS snapshots in %s: %v", region, cloudErr)
+		result["hint"] = "Snapshots at the provider need AWS credentials allowed ec2:DescribeSnapshots, or pass --cloud=false"
+	case !installed:
+		result["warning"] = "the VolumeSnapshot custom resources are not installed, only snapshots at the provider are listed"
+	}
+	return result, nil
+}
+
+// nodeRegion returns the region of the nodes of the cluster, from their
+// topology.kubernetes.io/region label
+func (c *Client) nodeRegion(ctx context.Context) (string, error) {
+	nodes, err := c.kube.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{Limit: 1})
+	if err != nil {
+		return "", kube.APIError(err, "list nodes")
+	}
+	for _, node := range nodes.Items {
+		return node.Labels[corev1.LabelTopologyRegion], nil
+	}
+	return "", nil
+}
+
+// command returns the command that deletes a snapshot
+func (s *volumeSnapshot) command(region string) string {
+	ec2 := "aws ec2 delete-snapshot --snapshot-id " + s.id
+	if region != "" {
+		ec2 += " --region " + region
+	}
+	switch s.kind {
+	case "VolumeSnapshot":
+		command := fmt.Sprintf("kubectl delete volumesnapshot -n %s %s", s.namespace, s.name)
+		if s.retained {
+			// The content and the snapshot at the provider outlive it
+			command += " && kubectl delete volumesnapshotcontent " + s.content
+			if strings.HasPrefix(s.id, "snap-") {
+				command += " && " + ec2
+			}
+		}
+		return command
+	case "VolumeSnapshotContent":
+		command := "kubectl delete volumesnapshotcontent " + s.name
+		if s.retained && strings.HasPrefix(s.id, "snap-") {
+			command += " && " + ec2
+		}
+		return command
+	}
+	return ec2
+}
@@ -0,0 +1,313 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// staleAnnotation records on the exported manifests why an object is stale
+const staleAnnotation = "upid.io/stale"
+
+// systemNamespaces are not swept unless asked for, as the control plane and
+// its add-ons read objects there without referring to them
+var systemNamespaces = map[string]bool{"kube-system": true, "kube-public": true, "kube-node-lease": true}
+
+// StaleOptions configure the sweep of stale resources
+type StaleOptions struct {
+	// Namespace is the namespace to sweep, all but the system namespaces if
+	// empty
+	Namespace string
+	// MinAge is how old an object must be to be stale, so that those just
+	// created ahead of their users are not
+	MinAge time.Duration
+	// StoragePrice is the price of a GiB-month of persistent volumes, and
+	// LoadBalancerPrice of an hour of a load balancer
+	StoragePrice      float64
+	LoadBalancerPrice float64
+}
+
+// staleObject is a ConfigMap, Secret, persistent volume claim or Service
+// nothing refers to
+type staleObject struct {
+	kind      string
+	namespace string
+	name      string
+	// detail is the type of a Secret or Service, or the storage class of a
+	// claim
+	detail  string
+	size    float64
+	created time.Time
+	cost    float64
+	reason  string
+	// kept is why the object is not to be deleted, "" to delete it
+	kept   string
+	object runtime.Object
+}
+
+// SweepStale finds the ConfigMaps, Secrets, persistent volume claims and
+// Services in a namespace that nothing refers to: ConfigMaps and Secrets no
+// pod, pod template of a workload, service account or Ingress uses, claims
+// no pod or pod template mounts, and Services with a selector that matches
+// no pod or pod template and that no Ingress, StatefulSet or admission
+// webhook names. Objects younger than MinAge, owned by another object,
+// managed by Helm, cert-manager or leader election, and service account
+// tokens are left out. Claims of StatefulSets and objects in namespaces
+// protected by guardrails are listed but kept. Nothing is deleted: the
+// manifests returned, without server fields and Secret data, delete the
+// stale objects with kubectl delete -f and restore what they can with
+// kubectl apply -f.
+func (c *Client) SweepStale(ctx context.Context, opts StaleOptions) (map[string]interface{}, []map[string]interface{}, error) {
+	refs, err := c.kube.References(ctx, opts.Namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	configMaps, err := c.kube.ConfigMaps(ctx, opts.Namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	secrets, err := c.kube.Secrets(ctx, opts.Namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	claims, err := c.kube.PersistentVolumeClaims(ctx, opts.Namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	services, err := c.kube.Services(ctx, opts.Namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	owner, err := c.kube.ClaimOwners(ctx, opts.Namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	swept := func(meta metav1.ObjectMeta) bool {
+		if opts.Namespace == "" && systemNamespaces[meta.Namespace] {
+			return false
+		}
+		return len(meta.OwnerReferences) == 0 && now.Sub(meta.CreationTimestamp.Time) >= opts.MinAge
+	}
+	var stale []*staleObject
+	for i := range configMaps {
+		cm := &configMaps[i]
+		if !swept(cm.ObjectMeta) || refs.Has("ConfigMap", cm.Namespace, cm.Name) || managed(cm.ObjectMeta) ||
+			cm.Name == "kube-root-ca.crt" {
+			continue
+		}
+		var size float64
+		for _, v := range cm.Data {
+			size += float64(len(v))
+		}
+		for _, v := range cm.BinaryData {
+			size += float64(len(v))
+		}
+		stale = append(stale, &staleObject{kind: "ConfigMap", namespace: cm.Namespace, name: cm.Name, size: size,
+			created: cm.CreationTimestamp.Time, reason: "not mounted or read by any pod or workload", object: cm})
+	}
+	for i := range secrets {
+		secret := &secrets[i]
+		if !swept(secret.ObjectMeta) || refs.Has("Secret", secret.Namespace, secret.Name) || managed(secret.ObjectMeta) {
+			continue
+		}
+		reason := "not mounted or read by any pod or workload, nor used by a service account or Ingress"
+		switch secret.Type {
+		case "helm.sh/release.v1", corev1.SecretTypeBootstrapToken:
+			continue
+		case corev1.SecretTypeServiceAccountToken:
+			account := secret.Annotations[corev1.ServiceAccountNameKey]
+			if refs.Has("ServiceAccount", secret.Namespace, account) {
+				continue
+			}
+			reason = fmt.Sprintf("token of service account %s, which was deleted", account)
+		}
+		var size float64
+		for _, v := range secret.Data {
+			size += float64(len(v))
+		}
+		stale = append(stale, &staleObject{kind: "Secret", namespace: secret.Namespace, name: secret.Name, detail: string(secret.Type),
+			size: size, created: secret.CreationTimestamp.Time, reason: reason, object: secret})
+	}
+	for i := range claims {
+		claim := &claims[i]
+		if !swept(claim.ObjectMeta) || refs.Has("PersistentVolumeClaim", claim.Namespace, claim.Name) {
+			continue
+		}
+		o := &staleObject{kind: "PersistentVolumeClaim", namespace: claim.Namespace, name: claim.Name,
+			created: claim.CreationTimestamp.Time, reason: "not mounted by any pod or workload", object: claim}
+		if claim.Spec.StorageClassName != nil {
+			o.detail = *claim.Spec.StorageClassName
+		}
+		if size, ok := claim.Status.Capacity[corev1.ResourceStorage]; ok {
+			o.size = size.AsApproximateFloat64()
+			o.cost = o.size / gib * opts.StoragePrice
+		}
+		if set := owner(claim.Namespace, claim.Name); set != "" {
+			o.kept = "claim of StatefulSet " + set + ", kept for when it scales up"
+		}
+		stale = append(stale, o)
+	}
+	for i := range services {
+		service := &services[i]
+		// Services without a selector route to endpoints managed by hand,
+		// ExternalName Services to a name outside the cluster
+		if !swept(service.ObjectMeta) || len(service.Spec.Selector) == 0 || service.Spec.Type == corev1.ServiceTypeExternalName ||
+			refs.Has("Service", service.Namespace, service.Name) || refs.Selects(service.Namespace, service.Spec.Selector) {
+			continue
+		}
+		o := &staleObject{kind: "Service", namespace: service.Namespace, name: service.Name, detail: string(service.Spec.Type),
+			created: service.CreationTimestamp.Time, object: service,
+			reason: "selects no pod or workload, and no Ingress, StatefulSet or webhook names it"}
+		if service.Spec.Type == corev1.ServiceTypeLoadBalancer {
+			o.cost = opts.LoadBalancerPrice * month.Hours()
+		}
+		stale = append(stale, o)
+	}
+
+	for _, o := range stale {
+		if o.kept != "" || c.guardrails == nil {
+			continue
+		}
+		for _, pattern := range c.guardrails.ProtectedNamespaces {
+			if ok, _ := path.Match(pattern, o.namespace); ok {
+				o.kept = fmt.Sprintf("namespace %s is protected by the pattern %s", o.namespace, pattern)
+				break
+			}
+		}
+	}
+	sort.SliceStable(stale, func(i, j int) bool {
+		a, b := stale[i], stale[j]
+		if a.cost != b.cost {
+			return a.cost > b.cost
+		}
+		return a.kind+"/"+a.namespace+"/"+a.name < b.kind+"/"+b.namespace+"/"+b.name
+	})
+
+	items := make([]interface{}, 0, len(stale))
+	manifests := []map[string]interface{}{}
+	kinds := map[string]int{}
+	var savings, kept float64
+	for _, o := range stale {
+		item := map[string]interface{}{
+			"kind":         o.kind,
+			"namespace":    o.namespace,
+			"name":         o.name,
+			"type":         o.detail,
+			"age_days":     round(now.Sub(o.created).Hours()/24, 1),
+			"monthly_cost": round(o.cost, 2),
+			"reason":       o.reason,
+			"action":       "delete",
+		}
+		if o.kind == "PersistentVolumeClaim" {
+			item["size"] = fmt.Sprintf("%.1f GiB", o.size/gib)
+		} else if o.kind != "Service" {
+			item["size"] = fmt.Sprintf("%.1f KiB", o.size/1024)
+		}
+		if o.kept != "" {
+			item["action"] = "keep"
+			item["note"] = o.kept
+			kept += o.cost
+			items = append(items, item)
+			continue
+		}
+		manifest, err := staleManifest(o)
+		if err != nil {
+			return nil, nil, err
+		}
+		manifests = append(manifests, manifest)
+		kinds[o.kind]++
+		savings += o.cost
+		items = append(items, item)
+	}
+
+	counts := make([]string, 0, len(kinds))
+	for _, kind := range []string{"ConfigMap", "Secret", "PersistentVolumeClaim", "Service"} {
+		if kinds[kind] > 0 {
+			counts = append(counts, fmt.Sprintf("%d %ss", kinds[kind], kind))
+		}
+	}
+	summary := "nothing"
+	if len(counts) > 0 {
+		summary = strings.Join(counts, ", ")
+	}
+	result := map[string]interface{}{
+		"context":   c.kube.Context,
+		"min_age":   formatWindow(opts.MinAge),
+		"savings":   round(savings, 2),
+		"resources": items,
+		"message": fmt.Sprintf("Found %d stale resources: %s to delete saving $%.2f per month, %d kept",
+			len(items), summary, savings, len(items)-len(manifests)),
+	}
+	if kept > 0 {
+		result["retained_cost"] = round(kept, 2)
+	}
+	return result, manifests, nil
+}
+
+// managed returns whether an object is maintained by a controller that
+// reads it without other objects referring to it
+func managed(meta metav1.ObjectMeta) bool {
+	if meta.Labels["owner"] == "helm" || meta.Annotations["control-plane.alpha.kubernetes.io/leader"] != "" {
+		return true
+	}
+	for key := range meta.Annotations {
+		if strings.HasPrefix(key, "cert-manager.io/") {
+			return true
+		}
+	}
+	return false
+}
+
+// staleManifest returns the manifest of a stale object, without the fields
+// the API server sets, so that it deletes the object with kubectl delete -f
+// and restores it with kubectl apply -f. Secrets are exported without their
+// data, and claims without their volume, which is deleted with them unless
+// retained.
+func staleManifest(o *staleObject) (map[string]interface{}, error) {
+	manifest, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o.object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export %s %s/%s: %v", o.kind, o.namespace, o.name, err)
+	}
+	manifest["apiVersion"] = "v1"
+	manifest["kind"] = o.kind
+	delete(manifest, "status")
+	metadata, _ := manifest["metadata"].(map[string]interface{})
+	for _, field := range []string{"uid", "resourceVersion", "creationTimestamp", "managedFields", "generation", "selfLink"} {
+		delete(metadata, field)
+	}
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	if annotations == nil {
+		annotations = map[string]interface{}{}
+	}
+	for key := range annotations {
+		// The last applied configuration holds the data of Secrets
+		if key == "kubectl.kubernetes.io/last-applied-configuration" || strings.HasPrefix(key, "pv.kubernetes.io/") ||
+			strings.HasPrefix(key, "volume.") {
+			delete(annotations, key)
+		}
+	}
+	annotations[staleAnnotation] = o.reason
+	metadata["annotations"] = annotations
+	spec, _ := manifest["spec"].(map[string]interface{})
+	switch o.kind {
+	case "Secret":
+		delete(manifest, "data")
+		delete(manifest, "stringData")
+	case "PersistentVolumeClaim":
+		delete(spec, "volumeName")
+	case "Service":
+		for _, field := range []string{"clusterIP", "clusterIPs", "healthCheckNodePort"} {
+			delete(spec, field)
+		}
+	}
+	return manifest, nil
+}
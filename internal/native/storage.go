@@ -0,0 +1,202 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// gib is the unit persistent volumes are sized and priced in
+const gib = 1 << 30
+
+// minShrink is the share of its capacity a claim must be able to give up
+// to be worth migrating to a smaller volume
+const minShrink = 0.25
+
+// defaultClassAnnotation marks the storage class of claims that name none
+const defaultClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+
+// VolumeRightsizeOptions configure how persistent volume claims are sized
+type VolumeRightsizeOptions struct {
+	// Namespace is the namespace to right-size, all if empty
+	Namespace string
+	// Window is the time range of usage from the datasource
+	Window time.Duration
+	// Headroom is the margin over the peak usage, or the usage a month of
+	// growth from now if more, as a fraction
+	Headroom float64
+	// StoragePrice is the price of a GiB-month, to project the cost of the
+	// new sizes
+	StoragePrice float64
+}
+
+// RightsizeVolumes compares the capacity of the persistent volume claims in
+// a namespace against the filesystem usage the kubelet reports over the
+// window from the datasource. Claims are sized to their peak usage, or the
+// usage a month of the current growth from now if more, plus headroom:
+// claims that need more are to be expanded, in place if their storage class
+// allows it, and claims that would give up at least a quarter of their
+// capacity are to be migrated to a smaller volume, which Kubernetes cannot
+// shrink. The cost impact is projected at the storage price.
+func (c *Client) RightsizeVolumes(ctx context.Context, opts VolumeRightsizeOptions) (map[string]interface{}, error) {
+	if c.history == nil || opts.Window == 0 {
+		return nil, clierr.New(clierr.CategoryUsage, "DATASOURCE_NOT_CONFIGURED", "volume usage over time is only known from a datasource").
+			WithHint("Configure one with 'upid config datasource set --url <url>'")
+	}
+
+	claims, err := c.kube.PersistentVolumeClaims(ctx, opts.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	classes, err := c.kube.StorageClasses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := c.history.VolumeHistory(ctx, opts.Namespace, opts.Window)
+	if err != nil {
+		return nil, err
+	}
+
+	type sizing struct {
+		item    map[string]interface{}
+		urgent  bool
+		savings float64
+	}
+	var (
+		sized                         []sizing
+		unmounted                     int
+		capacity, used, savings, cost float64
+	)
+	for i := range claims {
+		claim := &claims[i]
+		if claim.Status.Phase != corev1.ClaimBound {
+			continue
+		}
+		stats, ok := usage[claim.Namespace+"/"+claim.Name]
+		if !ok {
+			unmounted++
+			continue
+		}
+		size := stats.Capacity
+		if provisioned, ok := claim.Status.Capacity[corev1.ResourceStorage]; ok {
+			size = provisioned.AsApproximateFloat64()
+		}
+		if size <= 0 {
+			continue
+		}
+		capacity += size
+		used += stats.Used
+
+		// The usage a month from now at the growth over the window
+		projected := stats.Used + math.Max(stats.Growth, 0)*month.Seconds()
+		needed := math.Max(stats.Peak, projected) * (1 + opts.Headroom)
+		recommended := math.Max(math.Ceil(needed/gib), 1)
+		current := size / gib
+		item := map[string]interface{}{
+			"name":           claim.Name,
+			"namespace":      claim.Namespace,
+			"storage_class":  claimClass(claim, classes),
+			"capacity_gib":   round(current, 1),
+			"used_gib":       round(stats.Used/gib, 2),
+			"peak_gib":       round(stats.Peak/gib, 2),
+			"utilization":    round(stats.Peak/size*100, 1),
+			"growth_gib_day": round(stats.Growth*24*3600/gib, 3),
+		}
+		if stats.Growth > 0 && size > stats.Used {
+			item["days_to_full"] = round((size-stats.Used)/stats.Growth/(24*3600), 1)
+		}
+
+		var s sizing
+		switch {
+		case recommended > current:
+			item["action"] = "expand"
+			item["how"] = "storage class does not allow expansion, migrate to a larger volume"
+			if expandable(claimClass(claim, classes), classes) {
+				item["how"] = "raise the storage request of the claim, expanded in place"
+			}
+			s.urgent = projected >= size
+		case recommended <= current*(1-minShrink):
+			item["action"] = "shrink"
+			item["how"] = "migrate the data to a new, smaller claim, volumes cannot shrink in place"
+			s.savings = (current - recommended) * opts.StoragePrice
+			savings += s.savings
+		default:
+			continue
+		}
+		change := (recommended - current) * opts.StoragePrice
+		cost += change
+		item["recommended_gib"] = recommended
+		item["monthly_cost_change"] = round(change, 2)
+		s.item = item
+		sized = append(sized, s)
+	}
+
+	// Claims filling up come first, then those to expand, then those to
+	// shrink by savings
+	sort.SliceStable(sized, func(i, j int) bool {
+		a, b := sized[i], sized[j]
+		if a.urgent != b.urgent {
+			return a.urgent
+		}
+		if a.item["action"] != b.item["action"] {
+			return a.item["action"] == "expand"
+		}
+		if a.savings != b.savings {
+			return a.savings > b.savings
+		}
+		return a.item["namespace"].(string)+"/"+a.item["name"].(string) < b.item["namespace"].(string)+"/"+b.item["name"].(string)
+	})
+	items := make([]interface{}, 0, len(sized))
+	expand := 0
+	for _, s := range sized {
+		if s.item["action"] == "expand" {
+			expand++
+		}
+		items = append(items, s.item)
+	}
+
+	result := map[string]interface{}{
+		"context":             c.kube.Context,
+		"time_range":          formatWindow(opts.Window),
+		"datasource":          c.history.URL(),
+		"capacity_gib":        round(capacity/gib, 1),
+		"used_gib":            round(used/gib, 2),
+		"savings":             round(savings, 2),
+		"monthly_cost_change": round(cost, 2),
+		"volumes":             items,
+		"message": fmt.Sprintf("%d volumes to shrink saving $%.2f per month, %d to expand (%s of usage from %s)",
+			len(items)-expand, savings, expand, formatWindow(opts.Window), c.history.URL()),
+	}
+	if unmounted > 0 {
+		result["warning"] = fmt.Sprintf("%d bound claims are not mounted by any pod, the kubelet reports no usage for them", unmounted)
+		result["hint"] = "Claims no pod mounts may be orphaned"
+	}
+	return result, nil
+}
+
+// claimClass returns the storage class of a claim, the default class of the
+// cluster if it names none
+func claimClass(claim *corev1.PersistentVolumeClaim, classes map[string]storagev1.StorageClass) string {
+	if claim.Spec.StorageClassName != nil {
+		return *claim.Spec.StorageClassName
+	}
+	for name, class := range classes {
+		if class.Annotations[defaultClassAnnotation] == "true" {
+			return name
+		}
+	}
+	return ""
+}
+
+// expandable reports whether the volumes of a storage class can be expanded
+// in place
+func expandable(name string, classes map[string]storagev1.StorageClass) bool {
+	class, ok := classes[name]
+	return ok && class.AllowVolumeExpansion != nil && *class.AllowVolumeExpansion
+}
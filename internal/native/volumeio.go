@@ -0,0 +1,338 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/billing"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// mib is the unit of the throughput of volumes
+const mib = 1 << 20
+
+// Thresholds of the I/O of volumes against their baseline performance
+const (
+	// ioHeadroom is the margin over the peak IOPS and throughput that
+	// recommended volumes provision
+	ioHeadroom = 1.3
+	// throttledShare is the share of its baseline a volume peaking above
+	// is considered throttled
+	throttledShare = 0.9
+	// idleShare is the share of its provisioned IOPS or throughput a volume
+	// peaking below has over-provisioned
+	idleShare = 0.5
+)
+
+// gp3 limits
+const (
+	gp3MaxIOPS       = 16000
+	gp3MaxThroughput = 1000
+)
+
+// StorageAnalysisOptions configure the analysis of persistent volumes
+type StorageAnalysisOptions struct {
+	// Namespace is the namespace of the claims of the volumes, all if empty
+	Namespace string
+	// Window is the time range of usage and I/O
+	Window time.Duration
+	// StoragePrice is the price of a GiB-month of volumes that are not EBS
+	// volumes of a known type
+	StoragePrice float64
+	// Cloud reads the I/O of EBS volumes in a region, for those the
+	// datasource has no metrics of, nil to read them from the datasource
+	// only. It is called in Region, or that of the nodes if empty.
+	Cloud  func(ctx context.Context, region string, volumeIDs []string, window time.Duration) (map[string]*billing.VolumeIO, error)
+	Region string
+}
+
+// AnalyzeStorage prices the persistent volumes of the claims in a namespace
+// and compares the IOPS and throughput of their EBS volumes against their
+// baseline. The I/O is read from the metrics of the EBS CSI driver at the
+// datasource, or from CloudWatch. Volumes peaking near their baseline or
+// held to it are throttled and recommended more, volumes of provisioned
+// IOPS or throughput peaking below half of it are recommended less, and gp2
+// volumes are recommended gp3 of the same baseline, which costs less.
+func (c *Client) AnalyzeStorage(ctx context.Context, opts StorageAnalysisOptions) (map[string]interface{}, error) {
+	volumes, err := c.kube.PersistentVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := c.kube.PersistentVolumeClaims(ctx, opts.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	classes, err := c.kube.StorageClasses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var usage map[string]*prometheus.VolumeUsage
+	io := map[string]*billing.VolumeIO{}
+	source := ""
+	if c.history != nil && opts.Window > 0 {
+		if usage, err = c.history.VolumeHistory(ctx, opts.Namespace, opts.Window); err != nil {
+			return nil, err
+		}
+		csi, err := c.history.VolumeIOHistory(ctx, opts.Window)
+		if err != nil {
+			return nil, err
+		}
+		for id, s := range csi {
+			io[id] = &billing.VolumeIO{IOPS: s.IOPS, PeakIOPS: s.PeakIOPS, Throughput: s.Throughput, PeakThroughput: s.PeakThroughput, Throttled: s.Throttled}
+		}
+		if len(csi) > 0 {
+			source = "ebs-csi"
+		}
+	}
+
+	byClaim := map[string]*corev1.PersistentVolumeClaim{}
+	for i := range claims {
+		byClaim[claims[i].Namespace+"/"+claims[i].Name] = &claims[i]
+	}
+	attributes := map[string]map[string]string{}
+	type volume struct {
+		pv    *corev1.PersistentVolume
+		claim *corev1.PersistentVolumeClaim
+		class storagev1.StorageClass
+		id    string
+		size  float64
+	}
+	var analyzed []volume
+	var missing []string
+	for i := range volumes {
+		pv := &volumes[i]
+		ref := pv.Spec.ClaimRef
+		if ref == nil || byClaim[ref.Namespace+"/"+ref.Name] == nil {
+			continue
+		}
+		v := volume{pv: pv, claim: byClaim[ref.Namespace+"/"+ref.Name], class: classes[pv.Spec.StorageClassName], id: kube.VolumeID(pv)}
+		if size, ok := pv.Spec.Capacity[corev1.ResourceStorage]; ok {
+			v.size = size.AsApproximateFloat64() / gib
+		}
+		// Volumes modified by a VolumeAttributesClass perform as it says
+		if name := v.claim.Status.CurrentVolumeAttributesClassName; name != nil && *name != "" {
+			if _, ok := attributes[*name]; !ok {
+				attributes[*name] = nil
+				if class, err := c.kube.VolumeAttributesClass(ctx, *name); err == nil && class != nil {
+					attributes[*name] = class.Parameters
+				}
+			}
+			if overrides := attributes[*name]; overrides != nil {
+				parameters := map[string]string{}
+				for k, value := range v.class.Parameters {
+					parameters[k] = value
+				}
+				for k, value := range overrides {
+					parameters[k] = value
+				}
+				v.class.Parameters = parameters
+			}
+		}
+		if v.id != "" && io[v.id] == nil {
+			missing = append(missing, v.id)
+		}
+		analyzed = append(analyzed, v)
+	}
+
+	var cloudErr error
+	if opts.Cloud != nil && len(missing) > 0 && opts.Window > 0 {
+		region := opts.Region
+		if region == "" {
+			if region, err = c.nodeRegion(ctx); err != nil {
+				return nil, err
+			}
+		}
+		var cloud map[string]*billing.VolumeIO
+		if cloud, cloudErr = opts.Cloud(ctx, region, missing, opts.Window); cloudErr == nil {
+			for id, s := range cloud {
+				io[id] = s
+			}
+			if len(cloud) > 0 && source == "" {
+				source = "cloudwatch"
+			} else if len(cloud) > 0 {
+				source += ",cloudwatch"
+			}
+		}
+	}
+
+	type finding struct {
+		item     map[string]interface{}
+		change   float64
+		throttle bool
+	}
+	var findings []finding
+	var cost, savings, increase, capacity float64
+	var throttled, overProvisioned, gp2, measured int
+	for _, v := range analyzed {
+		key := v.claim.Namespace + "/" + v.claim.Name
+		item := map[string]interface{}{
+			"name":          v.pv.Name,
+			"claim":         v.claim.Name,
+			"namespace":     v.claim.Namespace,
+			"storage_class": v.pv.Spec.StorageClassName,
+			"capacity_gib":  round(v.size, 1),
+		}
+		if v.id != "" {
+			item["volume_id"] = v.id
+		}
+		if u := usage[key]; u != nil && v.size > 0 {
+			item["used_gib"] = round(u.Used/gib, 2)
+			item["utilization"] = round(u.Peak/gib/v.size*100, 1)
+		}
+		capacity += v.size
+		perf, ok := ebsPerformance(v.class, v.size)
+		if !ok {
+			monthly := v.size * opts.StoragePrice
+			cost += monthly
+			item["monthly_cost"] = round(monthly, 2)
+			findings = append(findings, finding{item: item})
+			continue
+		}
+		cost += perf.cost
+		item["type"] = perf.volumeType
+		item["monthly_cost"] = round(perf.cost, 2)
+		item["iops"] = perf.iops
+		item["throughput"] = perf.throughput
+
+		f := finding{item: item}
+		s := io[v.id]
+		if s != nil {
+			measured++
+			item["avg_iops"] = round(s.IOPS, 0)
+			item["peak_iops"] = round(s.PeakIOPS, 0)
+			item["avg_throughput"] = round(s.Throughput/mib, 1)
+			item["peak_throughput"] = round(s.PeakThroughput/mib, 1)
+			item["throttled_minutes"] = round(s.Throttled/60, 1)
+		}
+		var target volumePerformance
+		switch {
+		case s != nil && (s.Throttled > 0 || s.PeakIOPS >= throttledShare*perf.iops || s.PeakThroughput/mib >= throttledShare*perf.throughput):
+			f.throttle = true
+			throttled++
+			item["finding"] = "throttled"
+			target = recommendedVolume(perf, throttledIO(perf, s), v.size)
+		case s != nil && overProvisionedIO(perf, s):
+			overProvisioned++
+			item["finding"] = "over-provisioned"
+			target = recommendedVolume(perf, volumePerformance{iops: s.PeakIOPS * ioHeadroom, throughput: s.PeakThroughput / mib * ioHeadroom}, v.size)
+		case perf.volumeType == "gp2":
+			gp2++
+			item["finding"] = "gp2"
+			target = recommendedVolume(perf, perf, v.size)
+		default:
+			findings = append(findings, f)
+			continue
+		}
+		f.change = target.cost - perf.cost
+		item["recommendation"] = fmt.Sprintf("%s with %.0f IOPS and %.0f MiB/s", target.volumeType, target.iops, target.throughput)
+		item["monthly_cost_change"] = round(f.change, 2)
+		if f.change < 0 {
+			savings -= f.change
+		} else {
+			increase += f.change
+		}
+		findings = append(findings, f)
+	}
+
+	// Throttled volumes come first, then the largest savings
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.throttle != b.throttle {
+			return a.throttle
+		}
+		if a.change != b.change {
+			return a.change < b.change
+		}
+		return a.item["namespace"].(string)+"/"+a.item["claim"].(string) < b.item["namespace"].(string)+"/"+b.item["claim"].(string)
+	})
+	items := make([]interface{}, 0, len(findings))
+	for _, f := range findings {
+		items = append(items, f.item)
+	}
+
+	result := map[string]interface{}{
+		"context":          c.kube.Context,
+		"time_range":       formatWindow(opts.Window),
+		"capacity_gib":     round(capacity, 1),
+		"monthly_cost":     round(cost, 2),
+		"savings":          round(savings, 2),
+		"cost_increase":    round(increase, 2),
+		"throttled":        throttled,
+		"over_provisioned": overProvisioned,
+		"volumes":          items,
+		"message": fmt.Sprintf("%d volumes cost $%.2f per month: %d throttled, %d over-provisioned, %d gp2; the recommendations save $%.2f and cost $%.2f more per month",
+			len(items), cost, throttled, overProvisioned, gp2, savings, increase),
+	}
+	if source != "" {
+		result["io_source"] = source
+	}
+	switch {
+	case cloudErr != nil:
+		result["warning"] = fmt.Sprintf("failed to read the I/O of the volumes from CloudWatch: %v", cloudErr)
+		result["hint"] = "CloudWatch metrics need AWS credentials allowed cloudwatch:GetMetricData, or pass --cloud=false"
+	case len(missing) > 0 && measured == 0:
+		result["warning"] = "no I/O metrics of the EBS volumes, only their cost is analyzed"
+		result["hint"] = "Scrape the metrics of the node plugin of the EBS CSI driver (node.enableMetrics) at the datasource, or read them from CloudWatch with --cloud"
+	case throttled+overProvisioned+gp2 > 0:
+		result["hint"] = "Modify the volumes in place with a VolumeAttributesClass, see 'upid storage migrate-plan --method vac'"
+	}
+	return result, nil
+}
+
+// overProvisionedIO reports whether a volume pays for IOPS or throughput
+// beyond the baseline of gp3 that it peaks below half of
+func overProvisionedIO(perf volumePerformance, io *billing.VolumeIO) bool {
+	gp3 := ebsVolumeTypes["gp3"]
+	paidIOPS := ebsVolumeTypes[perf.volumeType].provisioned || perf.volumeType == "gp3" && perf.iops > gp3.includedIOPS
+	paidThroughput := perf.volumeType == "gp3" && perf.throughput > gp3.includedThroughput
+	return paidIOPS && io.PeakIOPS < idleShare*perf.iops ||
+		paidThroughput && io.PeakThroughput/mib < idleShare*perf.throughput
+}
+
+// throttledIO returns the IOPS and throughput a throttled volume needs: the
+// headroom over its peak or baseline for those it peaked near, both if it
+// was held back within the 5 minutes its peaks are averaged over
+func throttledIO(perf volumePerformance, io *billing.VolumeIO) volumePerformance {
+	iopsBound := io.PeakIOPS >= throttledShare*perf.iops
+	throughputBound := io.PeakThroughput/mib >= throttledShare*perf.throughput
+	if !iopsBound && !throughputBound {
+		iopsBound, throughputBound = true, true
+	}
+	needed := perf
+	if iopsBound {
+		needed.iops = math.Max(perf.iops, io.PeakIOPS) * ioHeadroom
+	}
+	if throughputBound {
+		needed.throughput = math.Max(perf.throughput, io.PeakThroughput/mib) * ioHeadroom
+	}
+	return needed
+}
+
+// recommendedVolume returns the cheapest EBS volume of a size providing the
+// IOPS and throughput in MiB/s needed: gp3 up to its limits, io2 beyond, or
+// io1 for volumes already io1
+func recommendedVolume(current, needed volumePerformance, size float64) volumePerformance {
+	iops := math.Ceil(needed.iops/100) * 100
+	throughput := math.Ceil(needed.throughput)
+	parameters := map[string]string{"type": "gp3"}
+	if iops > gp3MaxIOPS || throughput > gp3MaxThroughput {
+		parameters["type"] = "io2"
+		if current.volumeType == "io1" {
+			parameters["type"] = "io1"
+		}
+	} else {
+		gp3 := ebsVolumeTypes["gp3"]
+		iops = math.Max(iops, gp3.includedIOPS)
+		throughput = math.Max(throughput, gp3.includedThroughput)
+		parameters["throughput"] = fmt.Sprint(throughput)
+	}
+	parameters["iops"] = fmt.Sprint(iops)
+	perf, _ := ebsPerformance(storagev1.StorageClass{Provisioner: "ebs.csi.aws.com", Parameters: parameters}, size)
+	return perf
+}
@@ -0,0 +1,367 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+	"github.com/kubilitics/upid-cli/internal/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// workloadKinds maps the accepted spellings of workload kinds to their kind
+var workloadKinds = map[string]string{
+	"deployment":  "Deployment",
+	"deploy":      "Deployment",
+	"statefulset": "StatefulSet",
+	"sts":         "StatefulSet",
+	"daemonset":   "DaemonSet",
+	"ds":          "DaemonSet",
+	"job":         "Job",
+	"cronjob":     "CronJob",
+	"cj":          "CronJob",
+}
+
+// timelinePoints is the number of steps the usage of a workload is shown at
+const timelinePoints = 24
+
+// Requests are suggested at this margin over the usage they must fit, and
+// only if they differ from the current ones by more than resizeThreshold
+const (
+	requestHeadroom = 1.2
+	resizeThreshold = 0.2
+	// oomHeadroom is the margin over the memory limit suggested for
+	// workloads whose containers were OOM killed
+	oomHeadroom      = 1.25
+	minCPURequest    = 0.01
+	minMemoryRequest = 16 << 20
+)
+
+// ParseWorkloadRef parses a workload given as kind/name, e.g.
+// deployment/web or sts/db
+func ParseWorkloadRef(ref string) (kube.WorkloadRef, error) {
+	kind, name, ok := strings.Cut(ref, "/")
+	if canonical, known := workloadKinds[strings.ToLower(kind)]; ok && known && name != "" {
+		return kube.WorkloadRef{Kind: canonical, Name: name}, nil
+	}
+	return kube.WorkloadRef{}, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+		fmt.Sprintf("invalid workload %q (expected <kind>/<name> with kind deployment, statefulset, daemonset, job or cronjob)", ref))
+}
+
+// AnalyzeWorkload aggregates the pods of a workload: their usage against
+// requests, over time with a datasource and a window, their restarts and the
+// warning events of the last hour, and suggests right-sized requests. Without
+// a datasource the suggestion is based on the current usage from
+// metrics-server and has low confidence; without either none is made.
+func (c *Client) AnalyzeWorkload(ctx context.Context, ref kube.WorkloadRef, namespace string, window time.Duration) (map[string]interface{}, error) {
+	workload, err := c.kube.GetWorkload(ctx, ref.Kind, namespace, ref.Name)
+	if apierrors.IsNotFound(err) {
+		return nil, clierr.New(clierr.CategoryUsage, "WORKLOAD_NOT_FOUND",
+			fmt.Sprintf("%s %s not found in namespace %s", ref.Kind, ref.Name, namespace)).
+			WithHint("Check the name and pass the namespace of the workload with --namespace")
+	}
+	if err != nil {
+		return nil, kube.APIError(err, fmt.Sprintf("read %s %s", ref.Kind, ref.Name))
+	}
+
+	useHistory := c.history != nil && window > 0
+	snapshot, err := c.kube.Collect(ctx, kube.CollectOptions{Namespace: namespace, Events: true, Metrics: !useHistory})
+	if err != nil {
+		return nil, err
+	}
+	var pods []*kube.Pod
+	names := map[string]bool{}
+	for i := range snapshot.Pods {
+		if pod := &snapshot.Pods[i]; ownedBy(pod, ref) {
+			pods = append(pods, pod)
+			names[pod.Name] = true
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+
+	var (
+		podHistory map[string]*prometheus.PodUsage
+		usage      *prometheus.WorkloadUsage
+	)
+	if useHistory {
+		if podHistory, err = c.history.PodHistory(ctx, namespace, window); err != nil {
+			return nil, err
+		}
+		if usage, err = c.history.WorkloadHistory(ctx, namespace, podPattern(ref), window, timelinePoints); err != nil {
+			return nil, err
+		}
+	}
+
+	request := workload.Template
+	var limit kube.Resources
+	var running int
+	var used, peak kube.Resources
+	var restarts int32
+	oomKilled := false
+	podItems := make([]interface{}, 0, len(pods))
+	restartItems := []interface{}{}
+	for _, pod := range pods {
+		if l := pod.Limits(); l.Memory > limit.Memory {
+			limit = l
+		}
+		item := map[string]interface{}{
+			"name":     pod.Name,
+			"phase":    pod.Phase,
+			"node":     pod.Node,
+			"restarts": pod.Restarts(),
+			"created":  pod.Created.UTC().Format(time.RFC3339),
+		}
+		restarts += pod.Restarts()
+
+		var podUsed, podPeak *kube.Resources
+		if h, ok := podHistory[pod.Namespace+"/"+pod.Name]; ok {
+			podUsed = &kube.Resources{CPU: h.CPU.Avg, Memory: h.Memory.Avg}
+			podPeak = &kube.Resources{CPU: h.CPU.P95, Memory: h.Memory.Max}
+		} else if !useHistory && pod.Usage != nil {
+			podUsed, podPeak = pod.Usage, pod.Usage
+		}
+		if podUsed != nil {
+			running++
+			used = used.Add(*podUsed)
+			peak = kube.Resources{CPU: math.Max(peak.CPU, podPeak.CPU), Memory: math.Max(peak.Memory, podPeak.Memory)}
+			item["cpu_usage"] = round(podUsed.CPU, 3)
+			item["cpu_usage_percent"] = percent(podUsed.CPU, request.CPU)
+			item["memory_usage_mib"] = round(podUsed.Memory/(1<<20), 1)
+			item["memory_usage_percent"] = percent(podUsed.Memory, request.Memory)
+			if useHistory {
+				item["cpu_p95"] = round(podPeak.CPU, 3)
+				item["memory_max_mib"] = round(podPeak.Memory/(1<<20), 1)
+			}
+		}
+		podItems = append(podItems, item)
+
+		for _, status := range pod.Object.Status.ContainerStatuses {
+			terminated := status.LastTerminationState.Terminated
+			if status.RestartCount == 0 && terminated == nil {
+				continue
+			}
+			restart := map[string]interface{}{
+				"pod":       pod.Name,
+				"container": status.Name,
+				"restarts":  status.RestartCount,
+			}
+			if terminated != nil {
+				restart["reason"] = terminated.Reason
+				restart["exit_code"] = terminated.ExitCode
+				if !terminated.FinishedAt.IsZero() {
+					restart["last_restart"] = terminated.FinishedAt.UTC().Format(time.RFC3339)
+				}
+				oomKilled = oomKilled || terminated.Reason == "OOMKilled"
+			}
+			restartItems = append(restartItems, restart)
+		}
+	}
+
+	eventItems := []interface{}{}
+	for _, event := range snapshot.Events {
+		ours := (event.Kind == "Pod" && names[event.Object]) || (event.Kind == ref.Kind && event.Object == ref.Name)
+		if !ours || event.Type != "Warning" {
+			continue
+		}
+		eventItems = append(eventItems, map[string]interface{}{
+			"last_seen": event.LastSeen.UTC().Format(time.RFC3339),
+			"object":    event.Kind + "/" + event.Object,
+			"reason":    event.Reason,
+			"count":     event.Count,
+			"message":   event.Message,
+		})
+	}
+
+	result := map[string]interface{}{
+		"context":            c.kube.Context,
+		"workload":           ref.Kind + "/" + ref.Name,
+		"namespace":          namespace,
+		"pod_count":          len(pods),
+		"ready":              workload.ReadyReplicas,
+		"restarts":           restarts,
+		"cpu_request":        round(request.CPU, 3),
+		"memory_request_mib": round(request.Memory/(1<<20), 1),
+		"pods":               podItems,
+		"restart_history":    restartItems,
+		"events":             eventItems,
+	}
+	if workload.Replicas != nil {
+		result["replicas"] = *workload.Replicas
+	}
+
+	confidence := ""
+	var basis kube.Resources
+	switch {
+	case useHistory:
+		result["time_range"] = formatWindow(window)
+		result["datasource"] = c.history.URL()
+		timeline := make([]interface{}, 0, len(usage.Points))
+		var cpu, memory float64
+		for _, point := range usage.Points {
+			cpu += point.CPU
+			memory += point.Memory
+			timeline = append(timeline, map[string]interface{}{
+				"time":                 point.Time.UTC().Format(time.RFC3339),
+				"pods":                 point.Pods,
+				"cpu_usage":            round(point.CPU, 3),
+				"cpu_usage_percent":    percent(point.CPU, request.CPU),
+				"memory_usage_mib":     round(point.Memory/(1<<20), 1),
+				"memory_usage_percent": percent(point.Memory, request.Memory),
+			})
+		}
+		result["timeline"] = timeline
+		if len(usage.Points) == 0 {
+			result["warning"] = fmt.Sprintf("no usage of %s/%s in the last %s at %s", ref.Kind, ref.Name, formatWindow(window), c.history.URL())
+			result["message"] = fmt.Sprintf("%s/%s: %d pods (usage unknown, %s)", ref.Kind, ref.Name, len(pods), result["warning"])
+			break
+		}
+		cpu /= float64(len(usage.Points))
+		memory /= float64(len(usage.Points))
+		result["cpu_usage"] = round(cpu, 3)
+		result["cpu_usage_percent"] = percent(cpu, request.CPU)
+		result["memory_usage_mib"] = round(memory/(1<<20), 1)
+		result["memory_usage_percent"] = percent(memory, request.Memory)
+		basis = kube.Resources{CPU: usage.CPU.P95, Memory: usage.Memory.Max}
+		// Points are the steps the workload had pods in
+		confidence = "high"
+		if len(usage.Points) < timelinePoints*3/4 && ref.Kind != "Job" && ref.Kind != "CronJob" {
+			confidence = "medium"
+		}
+		result["message"] = fmt.Sprintf("%s/%s: %d pods, average usage per pod over %s %.3f cores, %.1f MiB (from %s)",
+			ref.Kind, ref.Name, len(pods), formatWindow(window), cpu, memory/(1<<20), c.history.URL())
+	case snapshot.Metrics.Available:
+		if running > 0 {
+			result["cpu_usage"] = round(used.CPU/float64(running), 3)
+			result["cpu_usage_percent"] = percent(used.CPU/float64(running), request.CPU)
+			result["memory_usage_mib"] = round(used.Memory/float64(running)/(1<<20), 1)
+			result["memory_usage_percent"] = percent(used.Memory/float64(running), request.Memory)
+			basis = peak
+			confidence = "low"
+		}
+		result["collected_at"] = snapshot.Metrics.Timestamp.Format(time.RFC3339)
+		result["message"] = fmt.Sprintf("%s/%s: %d pods, %d with current usage (point-in-time from metrics-server)",
+			ref.Kind, ref.Name, len(pods), running)
+	default:
+		metricsErr := clierr.From(snapshot.MetricsError())
+		result["warning"] = metricsErr.Message
+		if metricsErr.Hint != "" {
+			result["hint"] = metricsErr.Hint
+		}
+		result["message"] = fmt.Sprintf("%s/%s: %d pods (usage unknown, %s)", ref.Kind, ref.Name, len(pods), metricsErr.Message)
+	}
+
+	if confidence != "" {
+		result["confidence"] = confidence
+		result["recommendations"] = []interface{}{
+			cpuRecommendation(request.CPU, basis.CPU, useHistory),
+			memoryRecommendation(request.Memory, limit.Memory, basis.Memory, useHistory, oomKilled),
+		}
+	}
+	return result, nil
+}
+
+// ownedBy reports whether pod belongs to the workload. Pods of a Job are
+// attributed to its CronJob, if any, so they are matched by owner as well.
+func ownedBy(pod *kube.Pod, ref kube.WorkloadRef) bool {
+	if pod.Workload == ref {
+		return true
+	}
+	if ref.Kind != "Job" {
+		return false
+	}
+	for _, owner := range pod.Object.OwnerReferences {
+		if owner.Kind == "Job" && owner.Name == ref.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// podPattern is a regular expression matching the names the controller of
+// a workload gives its pods
+func podPattern(ref kube.WorkloadRef) string {
+	name := regexp.QuoteMeta(ref.Name)
+	switch ref.Kind {
+	case "Deployment":
+		return name + "-[a-z0-9]+-[a-z0-9]{5}"
+	case "StatefulSet":
+		return name + "-[0-9]+"
+	case "CronJob":
+		return name + "-[0-9]+-[a-z0-9]{5}"
+	}
+	return name + "-[a-z0-9]{5}"
+}
+
+// cpuRecommendation suggests a CPU request per pod that fits the 95th
+// percentile of the busiest pod, or its current usage
+func cpuRecommendation(request, basis float64, fromHistory bool) map[string]interface{} {
+	suggested := math.Max(math.Ceil(basis*requestHeadroom*200)/200, minCPURequest)
+	reason := "current usage of the busiest pod"
+	if fromHistory {
+		reason = "95th percentile of the busiest pod"
+	}
+	return recommendation("cpu", request, suggested, formatCPU,
+		fmt.Sprintf("%s is %s, plus %.0f%% headroom", reason, formatCPU(basis), (requestHeadroom-1)*100))
+}
+
+// memoryRecommendation suggests a memory request per pod that fits the peak
+// of the busiest pod, and at least the limit of OOM killed pods with headroom
+func memoryRecommendation(request, limit, basis float64, fromHistory, oomKilled bool) map[string]interface{} {
+	suggested := math.Max(basis*requestHeadroom, minMemoryRequest)
+	reason := fmt.Sprintf("current usage of the busiest pod is %s", formatMemory(basis))
+	if fromHistory {
+		reason = fmt.Sprintf("peak of the busiest pod is %s", formatMemory(basis))
+	}
+	reason += fmt.Sprintf(", plus %.0f%% headroom", (requestHeadroom-1)*100)
+	if oomKilled && limit > 0 && limit*oomHeadroom > suggested {
+		suggested = limit * oomHeadroom
+		reason = fmt.Sprintf("containers were OOM killed at the %s limit; raise the limit as well", formatMemory(limit))
+	}
+	suggested = math.Ceil(suggested/(1<<20)) * (1 << 20)
+	return recommendation("memory", request, suggested, formatMemory, reason)
+}
+
+// recommendation is a suggested change of a request, or none if the
+// current one is close enough
+func recommendation(resource string, request, value float64, format func(float64) string, reason string) map[string]interface{} {
+	item := map[string]interface{}{
+		"resource":  resource,
+		"current":   "none",
+		"suggested": format(value),
+		"reason":    reason,
+	}
+	if request > 0 {
+		item["current"] = format(request)
+	}
+	switch {
+	case request == 0:
+		item["action"] = "set"
+	case math.Abs(value-request)/request <= resizeThreshold:
+		item["action"] = "keep"
+		item["suggested"] = item["current"]
+	case value < request:
+		item["action"] = "decrease"
+	default:
+		item["action"] = "increase"
+	}
+	if request > 0 {
+		item["change_percent"] = round(100*(value-request)/request, 1)
+	}
+	return item
+}
+
+// formatCPU formats cores as a Kubernetes quantity
+func formatCPU(cores float64) string {
+	return fmt.Sprintf("%dm", int64(math.Round(cores*1000)))
+}
+
+// formatMemory formats bytes as a Kubernetes quantity
+func formatMemory(bytes float64) string {
+	return fmt.Sprintf("%dMi", int64(math.Ceil(bytes/(1<<20))))
+}
@@ -0,0 +1,362 @@
+// Package notify posts the events of UPID, alerts of a cluster, completed
+// optimizations and savings summaries, to notification channels such as
+// Slack or any HTTP webhook, and pages PagerDuty and Opsgenie with
+// incidents that resolve when their condition clears. Channels are kept in
+// a local file without their secrets, which are looked up by the caller,
+// e.g. in the credential store.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// Kinds of events
+const (
+	EventAlert        = "alert"
+	EventOptimization = "optimization"
+	EventSummary      = "summary"
+)
+
+// EventKinds are the kinds of events, in the order they are listed
+var EventKinds = []string{EventAlert, EventOptimization, EventSummary}
+
+// Types of channels
+const (
+	TypeSlack     = "slack"
+	TypePagerDuty = "pagerduty"
+	TypeOpsgenie  = "opsgenie"
+)
+
+// Ways channels authenticate
+const (
+	// AuthWebhook posts to an incoming webhook URL
+	AuthWebhook = "webhook"
+	// AuthToken posts as an app with a bot token
+	AuthToken = "token"
+	// AuthRoutingKey sends to the integration of a PagerDuty service
+	AuthRoutingKey = "routing key"
+	// AuthAPIKey sends with the key of an Opsgenie API integration
+	AuthAPIKey = "API key"
+)
+
+// sendTimeout bounds how long posting an event to a channel may take
+const sendTimeout = 15 * time.Second
+
+// Event is something that happened that channels may be notified of
+type Event struct {
+	Kind string
+	// Severity is that of alerts; optimizations and summaries are info
+	Severity string
+	Title    string
+	Message  string
+	// Source is the cluster or system the event is about
+	Source string
+	// Key identifies the condition of an alert, so that paging services
+	// group the alerts of a condition in one incident
+	Key string
+	// Resolved reports that the condition of the alert with Key cleared
+	Resolved bool
+	// Acknowledged reports that someone handles the alert with Key
+	Acknowledged bool
+	// Fields are details shown after the message, in order
+	Fields []Field
+	Time   time.Time
+}
+
+// Field is a named detail of an event
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Channel is where events are posted
+type Channel struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Auth is how the channel authenticates: webhook, token, routing key
+	// or API key
+	Auth string `json:"auth"`
+	// SlackChannel is the channel an app posts to; webhooks post to the
+	// channel they were created for
+	SlackChannel string `json:"slack_channel,omitempty"`
+	// Endpoint is the base URL of the API of PagerDuty or Opsgenie
+	Endpoint string `json:"endpoint,omitempty"`
+	// Template renders the payload of a webhook, DefaultTemplate if empty
+	Template string `json:"template,omitempty"`
+	// Headers are the names of the headers a webhook sends, whose values
+	// are kept with its secret
+	Headers []string `json:"headers,omitempty"`
+	// Signed is true if the payloads of a webhook are signed
+	Signed bool `json:"signed,omitempty"`
+	// Severity is the minimum severity of the alerts posted
+	Severity string `json:"severity"`
+	// Events are the kinds of events posted
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Accepts returns true if the channel posts an event: one of its kinds and,
+// for alerts, at least its severity
+func (c *Channel) Accepts(e Event) bool {
+	for _, kind := range c.Events {
+		if kind == e.Kind {
+			return e.Kind != EventAlert || kube.SeverityRank(e.Severity) >= kube.SeverityRank(c.Severity)
+		}
+	}
+	return false
+}
+
+// ParseEvents parses kinds of events given as alerts, optimizations and
+// summaries, or in the singular, without duplicates and in the order of
+// EventKinds
+func ParseEvents(values []string) ([]string, error) {
+	selected := map[string]bool{}
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+		kind := value
+		switch value {
+		case "alerts":
+			kind = EventAlert
+		case "optimizations":
+			kind = EventOptimization
+		case "summaries":
+			kind = EventSummary
+		}
+		valid := false
+		for _, k := range EventKinds {
+			valid = valid || k == kind
+		}
+		if !valid {
+			return nil, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+				fmt.Sprintf("invalid event %q (expected alerts, optimizations or summaries)", value))
+		}
+		selected[kind] = true
+	}
+	var kinds []string
+	for _, kind := range EventKinds {
+		if selected[kind] {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == 0 {
+		return nil, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", "no events to notify of (expected alerts, optimizations or summaries)")
+	}
+	return kinds, nil
+}
+
+// Notifier posts events to the channels that accept them
+type Notifier struct {
+	Channels []*Channel
+	// Secret returns the webhook URL, token or key of a channel
+	Secret func(channel *Channel) (string, error)
+	HTTP   *http.Client
+	// Silences hold back the alerts they match, if not nil
+	Silences *Silences
+	// Routes name the channels alerts of a severity are posted to; alerts
+	// of a severity without a route go to every channel that accepts them
+	Routes map[string][]string
+}
+
+// Notify posts an event to each channel that accepts it, and returns the
+// errors of those it could not post to. Alerts a silence matches are not
+// posted, though their resolution and acknowledgement are.
+func (n *Notifier) Notify(ctx context.Context, e Event) []error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if !e.Resolved && !e.Acknowledged && n.silenced(e) {
+		return nil
+	}
+	var errs []error
+	for _, c := range n.Channels {
+		if !c.Accepts(e) || !n.routed(c, e) {
+			continue
+		}
+		if err := n.Send(ctx, c, e); err != nil {
+			errs = append(errs, fmt.Errorf("channel %s: %w", c.Name, err))
+		}
+	}
+	return errs
+}
+
+// routed returns true if the routes of the alerts of the severity of e
+// include channel c, or if there are none
+func (n *Notifier) routed(c *Channel, e Event) bool {
+	route := n.Routes[e.Severity]
+	if e.Kind != EventAlert || len(route) == 0 {
+		return true
+	}
+	for _, name := range route {
+		if name == c.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Send posts an event to a channel, whether it accepts it or not
+func (n *Notifier) Send(ctx context.Context, c *Channel, e Event) error {
+	secret, err := n.Secret(c)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return fmt.Errorf("no %s is stored for the channel, add it again", c.Auth)
+	}
+	client := n.HTTP
+	if client == nil {
+		client = &http.Client{Timeout: sendTimeout}
+	}
+	switch c.Type {
+	case TypeSlack:
+		return sendSlack(ctx, client, c, secret, e)
+	case TypePagerDuty:
+		return sendPagerDuty(ctx, client, c, secret, e)
+	case TypeOpsgenie:
+		return sendOpsgenie(ctx, client, c, secret, e)
+	case TypeWebhook:
+		return sendWebhook(ctx, client, c, secret, e)
+	}
+	return fmt.Errorf("unsupported channel type %q", c.Type)
+}
+
+// silenced returns true if a silence holds back an event
+func (n *Notifier) silenced(e Event) bool {
+	return n.Silences != nil && n.Silences.Matching(e, e.Time) != nil
+}
+
+// Raise posts the alert of an incident that stays open until it is
+// resolved, and records it in incidents. An incident already open is not
+// posted again, unless it was raised while silenced and no silence holds it
+// back anymore. It returns true if incidents changed.
+func (n *Notifier) Raise(ctx context.Context, incidents *Incidents, e Event) (bool, []error) {
+	if e.Key == "" {
+		return false, []error{fmt.Errorf("incident %q has no key", e.Title)}
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	silenced := n.silenced(e)
+	if incident := incidents.Get(e.Key); incident != nil {
+		if !incident.Silenced || silenced {
+			return false, nil
+		}
+		incident.Silenced = false
+		return true, n.Notify(ctx, e)
+	}
+	incidents.Open = append(incidents.Open, &Incident{
+		Key:      e.Key,
+		Title:    e.Title,
+		Severity: e.Severity,
+		Source:   e.Source,
+		Fields:   e.Fields,
+		Opened:   e.Time.UTC(),
+		Silenced: silenced,
+	})
+	if silenced {
+		return true, nil
+	}
+	return true, n.Notify(ctx, e)
+}
+
+// Resolve posts that the condition of the incident with a key cleared, and
+// removes it from incidents. It returns false if the incident is not open.
+// Incidents that were silenced since they opened resolve without a post.
+func (n *Notifier) Resolve(ctx context.Context, incidents *Incidents, key, message string) (bool, []error) {
+	incident := incidents.Get(key)
+	if incident == nil {
+		return false, nil
+	}
+	incidents.Remove(key)
+	if incident.Silenced {
+		return true, nil
+	}
+	return true, n.Notify(ctx, Event{
+		Kind:     EventAlert,
+		Severity: incident.Severity,
+		Title:    incident.Title,
+		Message:  message,
+		Source:   incident.Source,
+		Key:      key,
+		Resolved: true,
+		Fields:   []Field{{Name: "Open for", Value: time.Since(incident.Opened).Round(time.Minute).String()}},
+		Time:     time.Now(),
+	})
+}
+
+// Acknowledge marks an open incident, given by its ID or key, as handled by
+// someone, and posts it so that paging services stop escalating it. It
+// returns the incident, and false if it was already acknowledged.
+func (n *Notifier) Acknowledge(ctx context.Context, incidents *Incidents, id, by string) (*Incident, bool, []error) {
+	incident := incidents.Find(id)
+	if incident == nil {
+		return nil, false, []error{clierr.New(clierr.CategoryUsage, "NOT_FOUND", fmt.Sprintf("no open alert %q", id)).
+			WithHint("See the open alerts with 'upid monitor alerts'")}
+	}
+	if incident.AcknowledgedAt != nil {
+		return incident, false, nil
+	}
+	now := time.Now().UTC()
+	incident.AcknowledgedAt, incident.AcknowledgedBy = &now, by
+	if incident.Silenced {
+		return incident, true, nil
+	}
+	return incident, true, n.Notify(ctx, Event{
+		Kind:         EventAlert,
+		Severity:     incident.Severity,
+		Title:        incident.Title,
+		Message:      "Acknowledged by " + by,
+		Source:       incident.Source,
+		Key:          incident.Key,
+		Acknowledged: true,
+		Fields:       incident.Fields,
+		Time:         now,
+	})
+}
+
+// postJSON posts a JSON body, encoded unless it is bytes, to a service and
+// returns the body of the response, failing unless its status is one of
+// ok. Errors leave the URL out, as webhooks carry their secret in it.
+func postJSON(ctx context.Context, client *http.Client, service, endpoint string, header http.Header, body interface{}, ok ...int) ([]byte, error) {
+	data, encoded := body.([]byte)
+	if !encoded {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("failed to encode %s message: %v", service, err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s endpoint", service)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if urlErr, isURL := err.(*url.Error); isURL {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("failed to post to %s: %v", service, err)
+	}
+	defer resp.Body.Close()
+	reply, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return reply, nil
+		}
+	}
+	return nil, fmt.Errorf("%s returned %s: %s", service, resp.Status, strings.TrimSpace(string(reply)))
+}
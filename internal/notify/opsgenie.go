@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// Base URLs of the Opsgenie API, by region
+const (
+	OpsgenieUS = "https://api.opsgenie.com"
+	OpsgenieEU = "https://api.eu.opsgenie.com"
+)
+
+// Limits of the fields of Opsgenie alerts
+const (
+	opsgenieMessage     = 130
+	opsgenieDescription = 15000
+	opsgenieAlias       = 512
+)
+
+// opsgeniePriorities map severities to the priorities of alerts
+var opsgeniePriorities = map[string]string{
+	kube.SeverityInfo:     "P5",
+	kube.SeverityWarning:  "P3",
+	kube.SeverityCritical: "P1",
+}
+
+// opsgenieAlert is an alert of the Alert API
+type opsgenieAlert struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	Priority    string            `json:"priority,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+}
+
+// sendOpsgenie creates an alert, or acknowledges or closes the one with the
+// key of the event. Opsgenie counts alerts with the alias of an open one as
+// occurrences of it.
+func sendOpsgenie(ctx context.Context, client *http.Client, c *Channel, secret string, e Event) error {
+	base := strings.TrimSuffix(endpoint(c, OpsgenieUS), "/") + "/v2/alerts"
+	header := http.Header{"Authorization": {"GenieKey " + secret}}
+	alias := limit(e.Key, opsgenieAlias)
+	if e.Resolved {
+		note := map[string]string{"source": "UPID", "note": e.Message}
+		_, err := postJSON(ctx, client, "Opsgenie", base+"/"+url.PathEscape(alias)+"/close?identifierType=alias", header, note, http.StatusAccepted)
+		return err
+	}
+	if e.Acknowledged {
+		note := map[string]string{"source": "UPID", "note": e.Message}
+		_, err := postJSON(ctx, client, "Opsgenie", base+"/"+url.PathEscape(alias)+"/acknowledge?identifierType=alias", header, note, http.StatusAccepted)
+		return err
+	}
+
+	alert := opsgenieAlert{
+		Message:     limit(e.Title, opsgenieMessage),
+		Alias:       alias,
+		Description: limit(e.Message, opsgenieDescription),
+		Source:      e.Source,
+		Priority:    opsgeniePriorities[e.Severity],
+		Tags:        []string{"upid", e.Kind},
+	}
+	if alert.Priority == "" {
+		alert.Priority = opsgeniePriorities[kube.SeverityInfo]
+	}
+	if len(e.Fields) > 0 {
+		alert.Details = map[string]string{}
+		for _, f := range e.Fields {
+			alert.Details[f.Name] = f.Value
+		}
+	}
+	_, err := postJSON(ctx, client, "Opsgenie", base, header, alert, http.StatusAccepted)
+	return err
+}
+
+// limit shortens text to at most n bytes
+func limit(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	return text[:n]
+}
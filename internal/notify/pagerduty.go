@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// Base URLs of the PagerDuty Events API, by service region
+const (
+	PagerDutyUS = "https://events.pagerduty.com"
+	PagerDutyEU = "https://events.eu.pagerduty.com"
+)
+
+// pagerDutyEvent is an event of the Events API v2
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key,omitempty"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	Client      string            `json:"client,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp,omitempty"`
+	Component     string            `json:"component,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+// pagerDutySummary bounds the summary of an event
+const pagerDutySummary = 1024
+
+// sendPagerDuty triggers an alert of the service of a routing key, or
+// acknowledges or resolves it. Alerts with the same key are grouped in one
+// incident.
+func sendPagerDuty(ctx context.Context, client *http.Client, c *Channel, secret string, e Event) error {
+	event := pagerDutyEvent{RoutingKey: secret, EventAction: "trigger", DedupKey: e.Key, Client: "UPID"}
+	switch {
+	case e.Resolved:
+		event.EventAction = "resolve"
+	case e.Acknowledged:
+		event.EventAction = "acknowledge"
+	default:
+		severity := e.Severity
+		if kube.SeverityRank(severity) < 0 {
+			severity = kube.SeverityInfo
+		}
+		summary := e.Title
+		if e.Message != "" {
+			summary += ": " + e.Message
+		}
+		source := e.Source
+		if source == "" {
+			source = "upid"
+		}
+		event.Payload = &pagerDutyPayload{
+			Summary:   limit(summary, pagerDutySummary),
+			Source:    source,
+			Severity:  severity,
+			Timestamp: e.Time.UTC().Format(time.RFC3339),
+			Component: e.Kind,
+		}
+		if len(e.Fields) > 0 {
+			event.Payload.CustomDetails = map[string]string{}
+			for _, f := range e.Fields {
+				event.Payload.CustomDetails[f.Name] = f.Value
+			}
+		}
+	}
+	_, err := postJSON(ctx, client, "PagerDuty", strings.TrimSuffix(endpoint(c, PagerDutyUS), "/")+"/v2/enqueue", nil, event, http.StatusAccepted)
+	return err
+}
+
+// endpoint returns the base URL of the API of a channel
+func endpoint(c *Channel, fallback string) string {
+	if c.Endpoint != "" {
+		return c.Endpoint
+	}
+	return fallback
+}
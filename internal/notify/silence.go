@@ -0,0 +1,216 @@
+package notify
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// MatcherLabels are the labels of alerts that silences match
+var MatcherLabels = []string{"context", "namespace", "severity", "kind", "key", "title"}
+
+// Operators of matchers, longest first so that they are parsed greedily
+var matcherOperators = []string{"!=", "=~", "!~", "="}
+
+// Matcher matches a label of alerts: equal to a value with =, not equal
+// with !=, or matching a regular expression, anchored at both ends, with =~
+// and not matching with !~
+type Matcher struct {
+	Label    string `json:"label"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// ParseMatcher parses a matcher given as <label><operator><value>, e.g.
+// namespace=batch or key=~upid/prod/anomaly/.*
+func ParseMatcher(text string) (Matcher, error) {
+	invalid := func(reason string) error {
+		return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("invalid matcher %q: %s", text, reason)).
+			WithHint("Matchers are <label>=<value>, with the operators =, !=, =~ and !~, and the labels " + strings.Join(MatcherLabels, ", "))
+	}
+	at, operator := -1, ""
+	for _, op := range matcherOperators {
+		if i := strings.Index(text, op); i >= 0 && (at < 0 || i < at || (i == at && len(op) > len(operator))) {
+			at, operator = i, op
+		}
+	}
+	if at < 0 {
+		return Matcher{}, invalid("no operator")
+	}
+	m := Matcher{Label: strings.ToLower(strings.TrimSpace(text[:at])), Operator: operator, Value: strings.TrimSpace(text[at+len(operator):])}
+	known := false
+	for _, label := range MatcherLabels {
+		known = known || label == m.Label
+	}
+	if !known {
+		return Matcher{}, invalid(fmt.Sprintf("unknown label %q", m.Label))
+	}
+	if m.Operator == "=~" || m.Operator == "!~" {
+		if _, err := regexp.Compile(m.Value); err != nil {
+			return Matcher{}, invalid(err.Error())
+		}
+	}
+	return m, nil
+}
+
+// Matches returns true if the label of an alert matches
+func (m Matcher) Matches(labels map[string]string) bool {
+	value := labels[m.Label]
+	switch m.Operator {
+	case "=":
+		return value == m.Value
+	case "!=":
+		return value != m.Value
+	case "=~", "!~":
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		return err == nil && re.MatchString(value) == (m.Operator == "=~")
+	}
+	return false
+}
+
+// String formats the matcher as it is given on the command line
+func (m Matcher) String() string {
+	return m.Label + m.Operator + m.Value
+}
+
+// Labels returns the labels of an event that silences match: its context,
+// namespace, severity, kind, key and title
+func (e Event) Labels() map[string]string {
+	labels := map[string]string{
+		"context":  e.Source,
+		"severity": e.Severity,
+		"kind":     e.Kind,
+		"key":      e.Key,
+		"title":    e.Title,
+	}
+	for _, f := range e.Fields {
+		switch f.Name {
+		case "Context":
+			if labels["context"] == "" {
+				labels["context"] = f.Value
+			}
+		case "Namespace":
+			labels["namespace"] = f.Value
+		}
+	}
+	return labels
+}
+
+// Silence holds back the alerts its matchers all match between its start
+// and end, e.g. during planned maintenance
+type Silence struct {
+	ID        string    `json:"id"`
+	Matchers  []Matcher `json:"matchers"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+}
+
+// Active returns true if the silence holds back alerts at a time
+func (s *Silence) Active(t time.Time) bool {
+	return !t.Before(s.StartsAt) && t.Before(s.EndsAt)
+}
+
+// Matches returns true if the silence is active when an alert happens and
+// all its matchers match it. Only alerts are silenced.
+func (s *Silence) Matches(e Event, t time.Time) bool {
+	if e.Kind != EventAlert || !s.Active(t) {
+		return false
+	}
+	labels := e.Labels()
+	for _, m := range s.Matchers {
+		if !m.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Silences is the local file of silences
+type Silences struct {
+	path     string
+	Silences []*Silence `json:"silences"`
+}
+
+// LoadSilences reads the silences file at path; a missing file holds no
+// silences
+func LoadSilences(path string) (*Silences, error) {
+	silences := &Silences{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return silences, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read silences: %v", err)
+	}
+	if err := json.Unmarshal(data, silences); err != nil {
+		return nil, fmt.Errorf("invalid silences file %s: %v", path, err)
+	}
+	return silences, nil
+}
+
+// Path returns the location of the silences file
+func (s *Silences) Path() string {
+	return s.path
+}
+
+// Get returns the silence with an ID, nil if there is none
+func (s *Silences) Get(id string) *Silence {
+	for _, silence := range s.Silences {
+		if silence.ID == id {
+			return silence
+		}
+	}
+	return nil
+}
+
+// Add adds a silence, setting its ID, and forgets the silences that ended
+func (s *Silences) Add(silence *Silence) error {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	silence.ID = hex.EncodeToString(id)
+	now := time.Now()
+	kept := s.Silences[:0]
+	for _, existing := range s.Silences {
+		if now.Before(existing.EndsAt) {
+			kept = append(kept, existing)
+		}
+	}
+	s.Silences = append(kept, silence)
+	sort.SliceStable(s.Silences, func(i, j int) bool { return s.Silences[i].StartsAt.Before(s.Silences[j].StartsAt) })
+	return nil
+}
+
+// Matching returns the first silence holding back an event at a time, nil
+// if none does
+func (s *Silences) Matching(e Event, t time.Time) *Silence {
+	for _, silence := range s.Silences {
+		if silence.Matches(e, t) {
+			return silence
+		}
+	}
+	return nil
+}
+
+// Save replaces the silences file, readable only by the user
+func (s *Silences) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode silences: %v", err)
+	}
+	if err := writeFile(s.path, data); err != nil {
+		return fmt.Errorf("failed to write silences: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// slackPostMessage is the Web API method apps post messages with
+const slackPostMessage = "https://slack.com/api/chat.postMessage"
+
+// Colors of the attachments of Slack messages, by severity
+var slackColors = map[string]string{
+	kube.SeverityInfo:     "#2eb67d",
+	kube.SeverityWarning:  "#ecb22e",
+	kube.SeverityCritical: "#e01e5a",
+}
+
+// slackMessage is a message of a webhook or of chat.postMessage
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackAttachment struct {
+	Color    string       `json:"color,omitempty"`
+	Title    string       `json:"title,omitempty"`
+	Text     string       `json:"text,omitempty"`
+	Fields   []slackField `json:"fields,omitempty"`
+	Footer   string       `json:"footer,omitempty"`
+	Ts       int64        `json:"ts,omitempty"`
+	Markdown []string     `json:"mrkdwn_in,omitempty"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// ValidateWebhook checks that a Slack incoming webhook is an absolute HTTP
+// URL
+func ValidateWebhook(webhook string) error {
+	u, err := url.Parse(webhook)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+			"invalid Slack webhook (expected a URL such as https://hooks.slack.com/services/...)")
+	}
+	return nil
+}
+
+// sendSlack posts an event to a Slack webhook, or as an app with its token
+func sendSlack(ctx context.Context, client *http.Client, c *Channel, secret string, e Event) error {
+	severity := e.Severity
+	if severity == "" {
+		severity = kube.SeverityInfo
+	}
+	attachment := slackAttachment{
+		Color:    slackColors[severity],
+		Title:    e.Title,
+		Text:     e.Message,
+		Footer:   "UPID " + e.Kind,
+		Ts:       e.Time.Unix(),
+		Markdown: []string{"text"},
+	}
+	if e.Source != "" {
+		attachment.Footer += " | " + e.Source
+	}
+	for _, f := range e.Fields {
+		attachment.Fields = append(attachment.Fields, slackField{Title: f.Name, Value: f.Value, Short: len(f.Value) <= 40})
+	}
+	message := slackMessage{Text: e.Title, Attachments: []slackAttachment{attachment}}
+	switch {
+	case e.Resolved:
+		message.Text = "[RESOLVED] " + e.Title
+		message.Attachments[0].Color = slackColors[kube.SeverityInfo]
+	case e.Acknowledged:
+		message.Text = "[ACKNOWLEDGED] " + e.Title
+	case e.Kind == EventAlert:
+		message.Text = fmt.Sprintf("[%s] %s", strings.ToUpper(severity), e.Title)
+	}
+
+	if c.Auth != AuthToken {
+		_, err := postJSON(ctx, client, "Slack", secret, nil, message, http.StatusOK)
+		return err
+	}
+	message.Channel = c.SlackChannel
+	data, err := postJSON(ctx, client, "Slack", slackPostMessage, http.Header{"Authorization": {"Bearer " + secret}}, message, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	// The Web API reports errors in the body of successful responses
+	var reply struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return fmt.Errorf("invalid response from Slack: %v", err)
+	}
+	if !reply.OK {
+		return fmt.Errorf("Slack returned error %s", reply.Error)
+	}
+	return nil
+}
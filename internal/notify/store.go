@@ -0,0 +1,199 @@
+package notify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Store is the local file of notification channels
+type Store struct {
+	path     string
+	Channels []*Channel `json:"channels"`
+}
+
+// Load reads the channels file at path; a missing file holds no channels
+func Load(path string) (*Store, error) {
+	store := &Store{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification channels: %v", err)
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("invalid notification channels file %s: %v", path, err)
+	}
+	return store, nil
+}
+
+// Path returns the location of the channels file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Get returns the channel with a name, nil if there is none
+func (s *Store) Get(name string) *Channel {
+	for _, channel := range s.Channels {
+		if channel.Name == name {
+			return channel
+		}
+	}
+	return nil
+}
+
+// Put adds a channel, or replaces the one with the same name
+func (s *Store) Put(channel *Channel) {
+	for i, existing := range s.Channels {
+		if existing.Name == channel.Name {
+			s.Channels[i] = channel
+			return
+		}
+	}
+	s.Channels = append(s.Channels, channel)
+	sort.Slice(s.Channels, func(i, j int) bool { return s.Channels[i].Name < s.Channels[j].Name })
+}
+
+// Remove deletes the channel with a name and returns true if there was one
+func (s *Store) Remove(name string) bool {
+	for i, channel := range s.Channels {
+		if channel.Name == name {
+			s.Channels = append(s.Channels[:i], s.Channels[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Save replaces the channels file, readable only by the user
+func (s *Store) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode notification channels: %v", err)
+	}
+	if err := writeFile(s.path, data); err != nil {
+		return fmt.Errorf("failed to write notification channels: %v", err)
+	}
+	return nil
+}
+
+// Incident is an alert that stays open until its condition clears
+type Incident struct {
+	Key      string    `json:"key"`
+	Title    string    `json:"title"`
+	Severity string    `json:"severity"`
+	Source   string    `json:"source,omitempty"`
+	Fields   []Field   `json:"fields,omitempty"`
+	Opened   time.Time `json:"opened"`
+	// Silenced is true while the incident was not posted, as a silence
+	// held it back when it was raised
+	Silenced       bool       `json:"silenced,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+}
+
+// ID returns the short identifier of the incident, derived from its key
+func (i *Incident) ID() string {
+	sum := sha256.Sum256([]byte(i.Key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// Event returns the alert of the incident
+func (i *Incident) Event() Event {
+	return Event{Kind: EventAlert, Severity: i.Severity, Title: i.Title, Source: i.Source, Key: i.Key, Fields: i.Fields, Time: i.Opened}
+}
+
+// Incidents is the local file of the incidents that are open
+type Incidents struct {
+	path string
+	Open []*Incident `json:"open"`
+}
+
+// LoadIncidents reads the incidents file at path; a missing file holds no
+// incidents
+func LoadIncidents(path string) (*Incidents, error) {
+	incidents := &Incidents{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return incidents, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read incidents: %v", err)
+	}
+	if err := json.Unmarshal(data, incidents); err != nil {
+		return nil, fmt.Errorf("invalid incidents file %s: %v", path, err)
+	}
+	return incidents, nil
+}
+
+// Get returns the open incident with a key, nil if there is none
+func (i *Incidents) Get(key string) *Incident {
+	for _, incident := range i.Open {
+		if incident.Key == key {
+			return incident
+		}
+	}
+	return nil
+}
+
+// Find returns the open incident with an ID or key, nil if there is none
+func (i *Incidents) Find(id string) *Incident {
+	for _, incident := range i.Open {
+		if incident.ID() == id || incident.Key == id {
+			return incident
+		}
+	}
+	return nil
+}
+
+// Remove deletes the incident with a key
+func (i *Incidents) Remove(key string) {
+	for n, incident := range i.Open {
+		if incident.Key == key {
+			i.Open = append(i.Open[:n], i.Open[n+1:]...)
+			return
+		}
+	}
+}
+
+// Save replaces the incidents file, readable only by the user
+func (i *Incidents) Save() error {
+	data, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode incidents: %v", err)
+	}
+	if err := writeFile(i.path, data); err != nil {
+		return fmt.Errorf("failed to write incidents: %v", err)
+	}
+	return nil
+}
+
+// writeFile replaces a file of the state directory. It writes to a
+// temporary file first so that a monitor reading the file never sees it
+// half written.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
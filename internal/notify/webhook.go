@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"github.com/kubilitics/upid-cli/internal/kube"
+)
+
+// TypeWebhook posts events to any HTTP endpoint as templated JSON
+const TypeWebhook = "webhook"
+
+// Headers of signed webhook requests
+const (
+	TimestampHeader = "X-UPID-Timestamp"
+	SignatureHeader = "X-UPID-Signature"
+)
+
+// DefaultTemplate is the payload of webhooks without a template of their own
+const DefaultTemplate = `{
+  "kind": {{json .Kind}},
+  "status": {{json .Status}},
+  "severity": {{json .Severity}},
+  "title": {{json .Title}},
+  "message": {{json .Message}},
+  "source": {{json .Source}},
+  "key": {{json .Key}},
+  "time": {{json (rfc3339 .Time)}},
+  "details": {{json .Details}}
+}`
+
+// templateFuncs are the functions templates may call
+var templateFuncs = template.FuncMap{
+	// json encodes a value, e.g. a string with its quotes escaped
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"rfc3339": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+	"unix":    func(t time.Time) int64 { return t.Unix() },
+}
+
+// WebhookSecret is what a webhook channel keeps in the credential store: its
+// URL, which may carry a token, the values of its headers and the secret
+// its payloads are signed with
+type WebhookSecret struct {
+	URL           string            `json:"url"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	SigningSecret string            `json:"signing_secret,omitempty"`
+}
+
+// templateData is what the templates of webhooks render: the event, with
+// its status, firing, acknowledged or resolved, and its fields by name
+type templateData struct {
+	Event
+	Status  string
+	Details map[string]string
+}
+
+// newTemplateData returns the data templates render for an event
+func newTemplateData(e Event) templateData {
+	data := templateData{Event: e, Status: "firing", Details: map[string]string{}}
+	switch {
+	case e.Resolved:
+		data.Status = "resolved"
+	case e.Acknowledged:
+		data.Status = "acknowledged"
+	}
+	for _, f := range e.Fields {
+		data.Details[f.Name] = f.Value
+	}
+	return data
+}
+
+// ParseTemplate parses the template of a webhook payload, "" for
+// DefaultTemplate, and checks that it renders JSON
+func ParseTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultTemplate
+	}
+	tmpl, err := template.New("payload").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("invalid webhook template: %v", err))
+	}
+	sample := Event{
+		Kind:     EventAlert,
+		Severity: kube.SeverityCritical,
+		Title:    "Sample alert",
+		Message:  `A "quoted" message`,
+		Source:   "cluster",
+		Key:      "upid/cluster/sample",
+		Fields:   []Field{{Name: "Namespace", Value: "default"}},
+		Time:     time.Now(),
+	}
+	if _, err := render(tmpl, sample); err != nil {
+		return nil, clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("invalid webhook template: %v", err))
+	}
+	return tmpl, nil
+}
+
+// render renders the payload of an event, which must be JSON
+func render(tmpl *template.Template, e Event) ([]byte, error) {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, newTemplateData(e)); err != nil {
+		return nil, err
+	}
+	if !json.Valid(b.Bytes()) {
+		return nil, fmt.Errorf("the payload is not JSON: %s", limit(b.String(), 200))
+	}
+	return b.Bytes(), nil
+}
+
+// Sign returns the signature of a payload sent at a time: the hex HMAC-SHA256
+// of the Unix timestamp, a dot and the payload, keyed by the signing secret
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendWebhook posts the templated payload of an event, signed if the
+// channel has a signing secret
+func sendWebhook(ctx context.Context, client *http.Client, c *Channel, secret string, e Event) error {
+	var s WebhookSecret
+	if err := json.Unmarshal([]byte(secret), &s); err != nil || s.URL == "" {
+		return fmt.Errorf("invalid stored webhook, add the channel again")
+	}
+	tmpl, err := ParseTemplate(c.Template)
+	if err != nil {
+		return err
+	}
+	payload, err := render(tmpl, e)
+	if err != nil {
+		return fmt.Errorf("failed to render the payload: %v", err)
+	}
+
+	header := http.Header{}
+	for name, value := range s.Headers {
+		header.Set(name, value)
+	}
+	if s.SigningSecret != "" {
+		now := time.Now().Unix()
+		header.Set(TimestampHeader, strconv.FormatInt(now, 10))
+		header.Set(SignatureHeader, Sign(s.SigningSecret, now, payload))
+	}
+	_, err = postJSON(ctx, client, "the webhook", s.URL, header, payload,
+		http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent)
+	return err
+}
@@ -0,0 +1,167 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/awsauth"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// azureVersion is the version of the Blob Storage API, the first with blob
+// index tags and encryption scopes
+const azureVersion = "2019-12-12"
+
+// azureCredentials are the credentials of a storage account: its key, or
+// a shared access signature
+type azureCredentials struct {
+	account  string
+	key      []byte
+	sas      string
+	endpoint string
+}
+
+// loadAzureCredentials reads the credentials of a storage account from
+// $AZURE_STORAGE_CONNECTION_STRING, else from $AZURE_STORAGE_KEY or
+// $AZURE_STORAGE_SAS_TOKEN
+func loadAzureCredentials(account string) (*azureCredentials, error) {
+	creds := &azureCredentials{account: account}
+	var key string
+	if connection := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); connection != "" {
+		values := map[string]string{}
+		for _, part := range strings.Split(connection, ";") {
+			if name, value, ok := strings.Cut(part, "="); ok {
+				values[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+			}
+		}
+		if name := values["accountname"]; name != "" && name != account {
+			return nil, clierr.New(clierr.CategoryAuth, "AZURE_CREDENTIALS_MISSING",
+				fmt.Sprintf("the connection string is for storage account %s, not %s", name, account))
+		}
+		key, creds.sas, creds.endpoint = values["accountkey"], values["sharedaccesssignature"], values["blobendpoint"]
+	} else {
+		key, creds.sas = os.Getenv("AZURE_STORAGE_KEY"), os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+	}
+	if key != "" {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, clierr.New(clierr.CategoryAuth, "AZURE_CREDENTIALS_INVALID", "the key of the storage account is not base64")
+		}
+		creds.key = decoded
+	}
+	if creds.key == nil && creds.sas == "" {
+		return nil, clierr.New(clierr.CategoryAuth, "AZURE_CREDENTIALS_MISSING", fmt.Sprintf("no credentials for storage account %s", account)).
+			WithHint("Export AZURE_STORAGE_CONNECTION_STRING, AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN")
+	}
+	creds.sas = strings.TrimPrefix(creds.sas, "?")
+	return creds, nil
+}
+
+// putAzure uploads a block blob to Azure Blob Storage, or to the blob
+// endpoint of the connection string, such as that of Azurite
+func putAzure(ctx context.Context, client *http.Client, l *Location, key string, data []byte, contentType string, opts Options) error {
+	creds, err := loadAzureCredentials(l.Account)
+	if err != nil {
+		return err
+	}
+	base := firstOf(strings.TrimSuffix(creds.endpoint, "/"), "https://"+l.Account+".blob.core.windows.net")
+	u, err := url.Parse(base + "/" + l.Bucket + "/" + key)
+	if err != nil {
+		return err
+	}
+	u.RawPath = awsauth.EncodePath(u.Path)
+	if creds.key == nil {
+		u.RawQuery = creds.sas
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+	req.Header.Set("X-Ms-Version", azureVersion)
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	if opts.KMSKey != "" {
+		req.Header.Set("X-Ms-Encryption-Scope", opts.KMSKey)
+	}
+	if len(opts.Tags) > 0 {
+		req.Header.Set("X-Ms-Tags", tagQuery(opts.Tags))
+	}
+	if creds.key != nil {
+		signAzure(req, creds, len(data))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return clierr.Wrap(err, clierr.CategoryUnreachable, "AZURE_UNREACHABLE", "failed to reach Blob Storage")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return uploadError("Blob Storage", l.Object(key).String(), resp, detail)
+}
+
+// signAzure adds the Shared Key authorization of a storage account to a
+// request to Blob Storage
+func signAzure(req *http.Request, creds *azureCredentials, length int) {
+	contentLength := ""
+	if length > 0 {
+		contentLength = strconv.Itoa(length)
+	}
+	var headers []string
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			headers = append(headers, lower+":"+strings.TrimSpace(req.Header.Get(name)))
+		}
+	}
+	sort.Strings(headers)
+	resource := "/" + creds.account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, as X-Ms-Date is set
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		strings.Join(headers, "\n"),
+		resource,
+	}, "\n")
+	mac := hmac.New(sha256.New, creds.key)
+	mac.Write([]byte(stringToSign))
+	req.Header.Set("Authorization", "SharedKey "+creds.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
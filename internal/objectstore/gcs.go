@@ -0,0 +1,172 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/awsauth"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+// Endpoints of Google Cloud
+const (
+	gcsEndpoint    = "https://storage.googleapis.com"
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+	googleMetadata = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	gcsScope       = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// putGCS uploads an object to Google Cloud Storage with its XML API, or to
+// the emulator of $STORAGE_EMULATOR_HOST
+func putGCS(ctx context.Context, client *http.Client, l *Location, key string, data []byte, contentType string, opts Options) error {
+	base := gcsEndpoint
+	token := ""
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		base = strings.TrimSuffix(host, "/")
+		if !strings.Contains(base, "://") {
+			base = "http://" + base
+		}
+	} else {
+		var err error
+		if token, err = googleToken(ctx, client); err != nil {
+			return err
+		}
+	}
+
+	u, err := url.Parse(base + "/" + l.Bucket + "/" + key)
+	if err != nil {
+		return err
+	}
+	u.RawPath = awsauth.EncodePath(u.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if opts.KMSKey != "" {
+		req.Header.Set("X-Goog-Encryption-Kms-Key-Name", opts.KMSKey)
+	}
+	for name, value := range opts.Tags {
+		req.Header.Set("X-Goog-Meta-"+name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return clierr.Wrap(err, clierr.CategoryUnreachable, "GCP_UNREACHABLE", "failed to reach Cloud Storage")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return uploadError("Cloud Storage", l.Object(key).String(), resp, detail)
+}
+
+// googleToken returns an access token of Google Cloud: that of
+// $GOOGLE_OAUTH_ACCESS_TOKEN, else one for the application default
+// credentials of $GOOGLE_APPLICATION_CREDENTIALS or of 'gcloud auth
+// application-default login', else one of the service account of the
+// instance from the metadata server
+func googleToken(ctx context.Context, client *http.Client) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	file := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if file == "" {
+		dir := os.Getenv("CLOUDSDK_CONFIG")
+		if dir == "" && runtime.GOOS == "windows" {
+			dir = filepath.Join(os.Getenv("APPDATA"), "gcloud")
+		} else if home, err := os.UserHomeDir(); dir == "" && err == nil {
+			dir = filepath.Join(home, ".config", "gcloud")
+		}
+		file = filepath.Join(dir, "application_default_credentials.json")
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) && os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" {
+		return metadataToken(ctx, client)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read Google credentials: %v", err)
+	}
+	var creds struct {
+		Type         string `json:"type"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyID string `json:"private_key_id"`
+		TokenURI     string `json:"token_uri"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return "", fmt.Errorf("invalid Google credentials %s: %v", file, err)
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	var source oauth2.TokenSource
+	switch creds.Type {
+	case "service_account":
+		config := &jwt.Config{
+			Email:        creds.ClientEmail,
+			PrivateKey:   []byte(creds.PrivateKey),
+			PrivateKeyID: creds.PrivateKeyID,
+			Scopes:       []string{gcsScope},
+			TokenURL:     firstOf(creds.TokenURI, googleTokenURL),
+		}
+		source = config.TokenSource(ctx)
+	case "authorized_user":
+		config := &oauth2.Config{ClientID: creds.ClientID, ClientSecret: creds.ClientSecret, Endpoint: oauth2.Endpoint{TokenURL: googleTokenURL}}
+		source = config.TokenSource(ctx, &oauth2.Token{RefreshToken: creds.RefreshToken})
+	default:
+		return "", clierr.New(clierr.CategoryAuth, "GCP_CREDENTIALS_UNSUPPORTED", fmt.Sprintf("unsupported Google credentials of type %q in %s", creds.Type, file)).
+			WithHint("Use a service account key, or run 'gcloud auth application-default login'")
+	}
+	token, err := source.Token()
+	if err != nil {
+		return "", clierr.Wrap(err, clierr.CategoryAuth, "GCP_AUTH_FAILED", "failed to get a Google access token")
+	}
+	return token.AccessToken, nil
+}
+
+// metadataToken returns an access token of the service account of the
+// instance, from the metadata server of Compute Engine and GKE
+func metadataToken(ctx context.Context, client *http.Client) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, googleMetadata, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := client.Do(req)
+	if err == nil {
+		defer resp.Body.Close()
+		var token struct {
+			AccessToken string `json:"access_token"`
+		}
+		if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&token) == nil && token.AccessToken != "" {
+			return token.AccessToken, nil
+		}
+	}
+	return "", clierr.New(clierr.CategoryAuth, "GCP_CREDENTIALS_MISSING", "no Google credentials found").
+		WithHint("Run 'gcloud auth application-default login', or set GOOGLE_APPLICATION_CREDENTIALS to a service account key")
+}
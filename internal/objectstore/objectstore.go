@@ -0,0 +1,220 @@
+// Package objectstore uploads files to the object storage of clouds: S3,
+// Google Cloud Storage and Azure Blob Storage, with the credentials of the
+// environment variables and files their own tools use.
+package objectstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// Schemes of locations
+const (
+	SchemeS3    = "s3"
+	SchemeGCS   = "gs"
+	SchemeAzure = "azblob"
+)
+
+// Server-side encryption of S3
+const (
+	EncryptionAES256 = "AES256"
+	EncryptionKMS    = "aws:kms"
+)
+
+// Location is a bucket, or a container of an Azure storage account, and a
+// prefix or the key of an object within it
+type Location struct {
+	Scheme string
+	// Account is the storage account of Azure
+	Account string
+	Bucket  string
+	Path    string
+}
+
+// Parse parses a location: s3://<bucket>/<path>, gs://<bucket>/<path>, or
+// azblob://<account>/<container>/<path> or
+// https://<account>.blob.core.windows.net/<container>/<path> for Azure
+func Parse(value string) (*Location, error) {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return nil, locationError(value)
+	}
+	l := &Location{Scheme: u.Scheme, Bucket: u.Host, Path: strings.Trim(u.Path, "/")}
+	switch {
+	case u.Scheme == SchemeS3 || u.Scheme == SchemeGCS:
+	case u.Scheme == SchemeAzure:
+		l.Account = u.Host
+		l.Bucket, l.Path, _ = strings.Cut(l.Path, "/")
+	case u.Scheme == "https" && strings.HasSuffix(u.Host, ".blob.core.windows.net"):
+		l.Scheme = SchemeAzure
+		l.Account = strings.TrimSuffix(u.Host, ".blob.core.windows.net")
+		l.Bucket, l.Path, _ = strings.Cut(l.Path, "/")
+	default:
+		return nil, locationError(value)
+	}
+	if l.Bucket == "" {
+		return nil, locationError(value)
+	}
+	return l, nil
+}
+
+// locationError returns the error of an invalid location
+func locationError(value string) error {
+	return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("invalid upload location %q", value)).
+		WithHint("Use s3://<bucket>/<prefix>, gs://<bucket>/<prefix> or azblob://<account>/<container>/<prefix>")
+}
+
+// String returns the location as a URL
+func (l *Location) String() string {
+	bucket := l.Bucket
+	if l.Scheme == SchemeAzure {
+		bucket = l.Account + "/" + l.Bucket
+	}
+	if l.Path == "" {
+		return l.Scheme + "://" + bucket
+	}
+	return l.Scheme + "://" + bucket + "/" + l.Path
+}
+
+// Object returns the location of an object of the bucket
+func (l *Location) Object(key string) *Location {
+	object := *l
+	object.Path = key
+	return &object
+}
+
+// Key returns the key to upload a file to. A location whose path has an
+// extension, such as s3://reports/cost/latest.pdf, is the key itself.
+// Otherwise its path is a prefix, under which files are kept by kind,
+// context and day, so that lifecycle rules can expire them by prefix:
+// <prefix>/<kind>/<context>/2024/05/31/<name>-20240531T060000Z.pdf
+func (l *Location) Key(kind, kubeContext, file string, now time.Time) string {
+	if path.Ext(l.Path) != "" {
+		return l.Path
+	}
+	now = now.UTC()
+	ext := path.Ext(file)
+	parts := []string{l.Path, kind, kubeContext, now.Format("2006/01/02"),
+		strings.TrimSuffix(path.Base(file), ext) + "-" + now.Format("20060102T150405Z") + ext}
+	var key []string
+	for _, part := range parts {
+		if part != "" {
+			key = append(key, part)
+		}
+	}
+	return strings.Join(key, "/")
+}
+
+// Options configure uploads
+type Options struct {
+	// Encryption is the server-side encryption of S3, EncryptionAES256 or
+	// EncryptionKMS; buckets encrypt with their default when empty
+	Encryption string
+	// KMSKey is the KMS key of S3 with EncryptionKMS, the Cloud KMS key of
+	// GCS, or the encryption scope of Azure
+	KMSKey string
+	// Tags label objects: object tags of S3, metadata of GCS and blob index
+	// tags of Azure, which lifecycle rules of S3 and Azure can filter on
+	Tags map[string]string
+	// Region is the region of the bucket of S3, default $AWS_REGION,
+	// $AWS_DEFAULT_REGION or us-east-1
+	Region string
+	// Profile selects the AWS credentials, as in billing.Options
+	Profile string
+	// Timeout limits each upload, 5m if 0
+	Timeout time.Duration
+}
+
+// Validate checks that the options of an upload apply to the storage of a
+// location
+func (l *Location) Validate(opts Options) error {
+	switch opts.Encryption {
+	case "":
+	case EncryptionAES256, EncryptionKMS:
+		if l.Scheme != SchemeS3 {
+			return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", fmt.Sprintf("--sse %s applies only to S3", opts.Encryption)).
+				WithHint("Objects of GCS and Azure are always encrypted; give a key with --sse-kms-key")
+		}
+	default:
+		return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT",
+			fmt.Sprintf("invalid server-side encryption %q (expected %s or %s)", opts.Encryption, EncryptionAES256, EncryptionKMS))
+	}
+	if l.Scheme == SchemeS3 && opts.KMSKey != "" && opts.Encryption == EncryptionAES256 {
+		return clierr.New(clierr.CategoryUsage, "INVALID_ARGUMENT", "--sse-kms-key applies only to --sse aws:kms")
+	}
+	return nil
+}
+
+// Upload uploads data to an object of a location
+func Upload(ctx context.Context, l *Location, key string, data []byte, opts Options) error {
+	if err := l.Validate(opts); err != nil {
+		return err
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Minute
+	}
+	client := &http.Client{Timeout: opts.Timeout}
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	switch l.Scheme {
+	case SchemeS3:
+		return putS3(ctx, client, l, key, data, contentType, opts)
+	case SchemeGCS:
+		return putGCS(ctx, client, l, key, data, contentType, opts)
+	}
+	return putAzure(ctx, client, l, key, data, contentType, opts)
+}
+
+// tagQuery encodes tags as a query string, as S3 and Azure take them
+func tagQuery(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, url.QueryEscape(key)+"="+url.QueryEscape(tags[key]))
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uploadError returns the error of a response to an upload, extracting the
+// message of XML and JSON error responses
+func uploadError(service, location string, resp *http.Response, body []byte) error {
+	message := strings.TrimSpace(string(body))
+	if start := strings.Index(message, "<Message>"); start >= 0 {
+		if end := strings.Index(message[start:], "</Message>"); end >= 0 {
+			message = message[start+len("<Message>") : start+end]
+		}
+	} else {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil && e.Error.Message != "" {
+			message = e.Error.Message
+		}
+	}
+	if message == "" {
+		message = "no details"
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return clierr.New(clierr.CategoryAuth, "UPLOAD_DENIED", fmt.Sprintf("%s denied the upload to %s: %s", service, location, message)).
+			WithHint("Allow the credentials used to write objects to the bucket")
+	}
+	return clierr.New(clierr.CategoryGeneral, "UPLOAD_FAILED", fmt.Sprintf("%s answered %s for %s: %s", service, resp.Status, location, message))
+}
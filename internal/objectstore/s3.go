@@ -0,0 +1,89 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/awsauth"
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// putS3 uploads an object to S3, or to the endpoint of $AWS_ENDPOINT_URL_S3
+// or $AWS_ENDPOINT_URL
+func putS3(ctx context.Context, client *http.Client, l *Location, key string, data []byte, contentType string, opts Options) error {
+	creds, err := awsauth.LoadCredentials(opts.Profile)
+	if err != nil {
+		return err
+	}
+	region := firstOf(opts.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+
+	var target string
+	switch base := strings.TrimSuffix(firstOf(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL")), "/"); {
+	case base != "":
+		target = base + "/" + l.Bucket + "/" + key
+	case strings.Contains(l.Bucket, "."):
+		// Dotted bucket names do not match the wildcard certificate of
+		// virtual-hosted endpoints
+		target = "https://s3." + region + ".amazonaws.com/" + l.Bucket + "/" + key
+	default:
+		target = "https://" + l.Bucket + ".s3." + region + ".amazonaws.com/" + key
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	u.RawPath = awsauth.EncodePath(u.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if opts.KMSKey != "" && opts.Encryption == "" {
+		opts.Encryption = EncryptionKMS
+	}
+	if opts.Encryption != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", opts.Encryption)
+	}
+	if opts.KMSKey != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", opts.KMSKey)
+	}
+	if len(opts.Tags) > 0 {
+		req.Header.Set("X-Amz-Tagging", tagQuery(opts.Tags))
+	}
+	awsauth.Sign(req, awsauth.HashHex(data), creds, region, "s3", time.Now())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return clierr.Wrap(err, clierr.CategoryUnreachable, "AWS_UNREACHABLE", "failed to reach S3")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if bucketRegion := resp.Header.Get("X-Amz-Bucket-Region"); bucketRegion != "" && bucketRegion != region {
+		return clierr.New(clierr.CategoryUsage, "AWS_WRONG_REGION", fmt.Sprintf("bucket %s is in region %s, not %s", l.Bucket, bucketRegion, region)).
+			WithHint("Set AWS_REGION=" + bucketRegion)
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return uploadError("S3", l.Object(key).String(), resp, detail)
+}
+
+// firstOf returns the first value that is set
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
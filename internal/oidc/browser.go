@@ -0,0 +1,149 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"golang.org/x/oauth2"
+)
+
+// callbackPath is the path of the localhost redirect URL
+const callbackPath = "/callback"
+
+// BrowserLogin signs in with the authorization code flow and PKCE. It opens
+// the authorization URL in the browser, also printing it to status, and
+// waits until the identity provider redirects to a localhost callback.
+func (p *Provider) BrowserLogin(ctx context.Context, status io.Writer) (*Session, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the login callback server: %v", err)
+	}
+	defer listener.Close()
+
+	redirectURL := fmt.Sprintf("http://%s%s", listener.Addr(), callbackPath)
+	config := p.oauth2Config(redirectURL)
+	verifier := oauth2.GenerateVerifier()
+	state := randomString()
+	authURL := config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
+
+	type callback struct {
+		code string
+		err  error
+	}
+	results := make(chan callback, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != callbackPath {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		var result callback
+		switch {
+		case query.Get("state") != state:
+			result.err = clierr.New(clierr.CategoryAuth, "SSO_FAILED", "login callback with an unexpected state, the login was not started by this command")
+		case query.Get("error") != "":
+			message := query.Get("error")
+			if description := query.Get("error_description"); description != "" {
+				message += ": " + description
+			}
+			result.err = clierr.New(clierr.CategoryAuth, "SSO_FAILED", "identity provider rejected the login: "+message)
+		case query.Get("code") == "":
+			result.err = clierr.New(clierr.CategoryAuth, "SSO_FAILED", "login callback without an authorization code")
+		default:
+			result.code = query.Get("code")
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if result.err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "<html><body><h3>UPID login failed</h3><p>%s</p></body></html>", html.EscapeString(result.err.Error()))
+		} else {
+			fmt.Fprint(w, "<html><body><h3>UPID login complete</h3><p>You can close this window and return to the terminal.</p></body></html>")
+		}
+		select {
+		case results <- result:
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	fmt.Fprintf(status, "Opening the browser to sign in. If it does not open, visit:\n\n  %s\n\n", authURL)
+	if err := OpenBrowser(authURL); err != nil {
+		fmt.Fprintf(status, "Could not open the browser: %v\n", err)
+	}
+
+	var result callback
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return nil, clierr.Wrap(ctx.Err(), clierr.CategoryAuth, "SSO_FAILED", "login was not completed in the browser").
+			WithHint("Use 'upid auth login --device' on machines without a browser")
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	token, err := config.Exchange(ctx, result.code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, tokenError(err)
+	}
+	return newSession(p, token), nil
+}
+
+// OpenBrowser opens url in the user's browser, $BROWSER if set
+func OpenBrowser(url string) error {
+	var command *exec.Cmd
+	switch {
+	case os.Getenv("BROWSER") != "":
+		command = exec.Command(os.Getenv("BROWSER"), url)
+	case runtime.GOOS == "darwin":
+		command = exec.Command("open", url)
+	case runtime.GOOS == "windows":
+		command = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		command = exec.Command("xdg-open", url)
+	}
+	command.Stdout, command.Stderr = nil, nil
+	return command.Start()
+}
+
+// tokenError converts a failed token request into a structured error
+func tokenError(err error) error {
+	var retrieve *oauth2.RetrieveError
+	if errors.As(err, &retrieve) {
+		message := retrieve.ErrorCode
+		if retrieve.ErrorDescription != "" {
+			message += ": " + retrieve.ErrorDescription
+		}
+		if message == "" {
+			message = strings.TrimSpace(string(retrieve.Body))
+		}
+		return clierr.Wrap(err, clierr.CategoryAuth, "SSO_FAILED", "identity provider rejected the token request: "+message)
+	}
+	return clierr.Wrap(err, clierr.CategoryUnreachable, "IDP_UNREACHABLE", "failed to reach the identity provider")
+}
+
+// randomString returns a random URL-safe string for state parameters
+func randomString() string {
+	buf := make([]byte, 24)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// decodeSegment decodes a base64url JWT segment
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
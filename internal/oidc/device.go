@@ -0,0 +1,45 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// DeviceLogin signs in with the device authorization grant. It prints a
+// verification URL and user code to status, for the user to enter on any
+// device with a browser, and polls until the login is completed there.
+func (p *Provider) DeviceLogin(ctx context.Context, status io.Writer) (*Session, error) {
+	if p.Metadata.DeviceAuthorizationEndpoint == "" {
+		return nil, clierr.New(clierr.CategoryUsage, "DEVICE_FLOW_UNSUPPORTED", "identity provider does not support the device code flow").
+			WithHint("Use 'upid auth login --sso' on a machine with a browser")
+	}
+
+	config := p.oauth2Config("")
+	device, err := config.DeviceAuth(ctx)
+	if err != nil {
+		return nil, tokenError(err)
+	}
+
+	fmt.Fprintf(status, "To sign in, open %s and enter the code:\n\n  %s\n\n", device.VerificationURI, device.UserCode)
+	if device.VerificationURIComplete != "" {
+		fmt.Fprintf(status, "Or open %s\n\n", device.VerificationURIComplete)
+	}
+	fmt.Fprintln(status, "Waiting for the login to complete...")
+
+	token, err := config.DeviceAccessToken(ctx, device)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return nil, clierr.New(clierr.CategoryAuth, "SSO_FAILED", "the user code expired before the login was completed").
+				WithHint("Run 'upid auth login --device' again")
+		}
+		if ctx.Err() != nil {
+			return nil, clierr.Wrap(ctx.Err(), clierr.CategoryAuth, "SSO_FAILED", "login was not completed")
+		}
+		return nil, tokenError(err)
+	}
+	return newSession(p, token), nil
+}
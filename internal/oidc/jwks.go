@@ -0,0 +1,297 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// clockLeeway tolerates clock skew between this machine and the issuer
+const clockLeeway = 30 * time.Second
+
+// maxKeySetSize limits the size of a downloaded key set
+const maxKeySetSize = 1 << 20
+
+// JWK is a public key of an issuer's JSON Web Key Set
+type JWK struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid,omitempty"`
+	Use     string `json:"use,omitempty"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Elliptic curve and Ed25519 keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// KeySet is a cached JSON Web Key Set of an issuer
+type KeySet struct {
+	Issuer    string    `json:"issuer"`
+	URI       string    `json:"jwks_uri"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Keys      []JWK     `json:"keys"`
+}
+
+// KeyCache keeps the key sets of issuers on disk, so tokens can be verified
+// without a network round trip
+type KeyCache struct {
+	// Dir holds one file per issuer
+	Dir string
+	// TTL is how long a key set is used before it is downloaded again
+	TTL time.Duration
+}
+
+// Trust names the issuer whose keys may verify a token and the client the
+// token must be issued to
+type Trust struct {
+	// Issuer is the configured issuer; tokens of other issuers are rejected
+	Issuer string
+	// ClientID must be the aud or azp claim of the token, if set
+	ClientID string
+}
+
+// Verification is the result of verifying a token
+type Verification struct {
+	// Token is the parsed token
+	Token *JWT
+	// Verified is true if the signature was checked against the issuer's keys
+	Verified bool
+	// KeysFetchedAt is when the keys used were downloaded
+	KeysFetchedAt time.Time
+	// Reason explains why the signature was not checked
+	Reason string
+}
+
+// Verify checks a JWT against the cached keys of the trusted issuer. Tokens
+// of another issuer or client are rejected, since a token can name any issuer
+// and so any keys. With offline set, missing or stale keys are not
+// downloaded and the signature is left unchecked; the expiry is always
+// checked. Keys are downloaded again once if the token names an unknown key,
+// which happens when the issuer rotates them.
+func (c *KeyCache) Verify(ctx context.Context, raw string, trust Trust, offline bool) (*Verification, error) {
+	token, err := ParseJWT(raw)
+	if err != nil {
+		return nil, err
+	}
+	result := &Verification{Token: token}
+	if err := token.CheckTime(time.Now(), clockLeeway); err != nil {
+		return result, err
+	}
+
+	issuer := token.Issuer()
+	switch {
+	case issuer == "":
+		result.Reason = "token names no issuer"
+		return result, nil
+	case trust.Issuer == "":
+		result.Reason = "no issuer is configured to trust"
+		return result, nil
+	case strings.TrimSuffix(issuer, "/") != strings.TrimSuffix(trust.Issuer, "/"):
+		return result, invalidToken(fmt.Sprintf("issued by %s instead of %s", issuer, trust.Issuer))
+	case trust.ClientID != "" && !token.IssuedTo(trust.ClientID):
+		return result, invalidToken("not issued to client " + trust.ClientID)
+	}
+	keys, err := c.load(issuer)
+	if (err != nil || time.Since(keys.FetchedAt) > c.TTL) && !offline {
+		// Stale keys are still better than none when the issuer is unreachable
+		if fresh, err := c.fetch(ctx, issuer); err == nil {
+			keys = fresh
+		} else if keys == nil {
+			result.Reason = err.Error()
+			return result, nil
+		}
+	}
+	if keys == nil {
+		result.Reason = "no cached signing keys for " + issuer
+		return result, nil
+	}
+
+	if _, ok := keys.find(token); !ok && !offline && time.Since(keys.FetchedAt) > time.Minute {
+		if fresh, err := c.fetch(ctx, issuer); err == nil {
+			keys = fresh
+		}
+	}
+	return c.check(result, keys)
+}
+
+// check verifies the signature of a token with a key set
+func (c *KeyCache) check(result *Verification, keys *KeySet) (*Verification, error) {
+	key, ok := keys.find(result.Token)
+	if !ok {
+		return result, invalidToken(fmt.Sprintf("signing key %q is not in the key set of %s", result.Token.KeyID, keys.Issuer))
+	}
+	if err := verifySignature(result.Token, key); err != nil {
+		return result, invalidToken(err.Error())
+	}
+	result.Verified = true
+	result.KeysFetchedAt = keys.FetchedAt
+	result.Reason = ""
+	return result, nil
+}
+
+// find returns the key that signed token
+func (k *KeySet) find(token *JWT) (JWK, bool) {
+	for _, key := range k.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if token.KeyID == "" || key.KeyID == token.KeyID {
+			return key, true
+		}
+	}
+	return JWK{}, false
+}
+
+// path returns the cache file of an issuer
+func (c *KeyCache) path(issuer string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSuffix(issuer, "/")))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// load reads the cached key set of an issuer
+func (c *KeyCache) load(issuer string) (*KeySet, error) {
+	data, err := os.ReadFile(c.path(issuer))
+	if err != nil {
+		return nil, err
+	}
+	var keys KeySet
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	return &keys, nil
+}
+
+// fetch downloads the key set of an issuer and caches it
+func (c *KeyCache) fetch(ctx context.Context, issuer string) (*KeySet, error) {
+	metadata, err := discover(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("%s publishes no signing keys", issuer)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, metadata.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, clierr.Wrap(err, clierr.CategoryUnreachable, "IDP_UNREACHABLE", "failed to download signing keys")
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download signing keys: %s", response.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxKeySetSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download signing keys: %v", err)
+	}
+
+	keys := &KeySet{Issuer: issuer, URI: metadata.JWKSURI, FetchedAt: time.Now().UTC()}
+	if err := json.Unmarshal(data, keys); err != nil {
+		return nil, fmt.Errorf("invalid key set at %s: %v", keys.URI, err)
+	}
+	keys.Issuer, keys.URI = issuer, metadata.JWKSURI
+	if encoded, err := json.MarshalIndent(keys, "", "  "); err == nil {
+		if err := os.MkdirAll(c.Dir, 0700); err == nil {
+			_ = os.WriteFile(c.path(issuer), encoded, 0600)
+		}
+	}
+	return keys, nil
+}
+
+// verifySignature checks the signature of a token with a public key
+func verifySignature(token *JWT, key JWK) error {
+	hash := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}[strings.TrimLeft(token.Algorithm, "RSPEHS")]
+	digest := func() []byte {
+		h := hash.New()
+		h.Write([]byte(token.signed))
+		return h.Sum(nil)
+	}
+
+	switch {
+	case token.Algorithm == "EdDSA" && key.KeyType == "OKP" && key.Curve == "Ed25519":
+		x, err := decodeSegment(key.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return fmt.Errorf("malformed Ed25519 key")
+		}
+		if !ed25519.Verify(ed25519.PublicKey(x), []byte(token.signed), token.signature) {
+			return fmt.Errorf("signature does not match")
+		}
+	case (strings.HasPrefix(token.Algorithm, "RS") || strings.HasPrefix(token.Algorithm, "PS")) && key.KeyType == "RSA" && hash != 0:
+		public, err := rsaKey(key)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(token.Algorithm, "RS") {
+			err = rsa.VerifyPKCS1v15(public, hash, digest(), token.signature)
+		} else {
+			err = rsa.VerifyPSS(public, hash, digest(), token.signature, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("signature does not match")
+		}
+	case strings.HasPrefix(token.Algorithm, "ES") && key.KeyType == "EC" && hash != 0:
+		public, err := ecKey(key)
+		if err != nil {
+			return err
+		}
+		size := (public.Curve.Params().BitSize + 7) / 8
+		if len(token.signature) != 2*size {
+			return fmt.Errorf("malformed signature")
+		}
+		r := new(big.Int).SetBytes(token.signature[:size])
+		s := new(big.Int).SetBytes(token.signature[size:])
+		if !ecdsa.Verify(public, digest(), r, s) {
+			return fmt.Errorf("signature does not match")
+		}
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q for %s key", token.Algorithm, key.KeyType)
+	}
+	return nil
+}
+
+// rsaKey decodes an RSA public key
+func rsaKey(key JWK) (*rsa.PublicKey, error) {
+	n, errN := decodeSegment(key.N)
+	e, errE := decodeSegment(key.E)
+	if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 {
+		return nil, fmt.Errorf("malformed RSA key")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+// ecKey decodes an elliptic curve public key
+func ecKey(key JWK) (*ecdsa.PublicKey, error) {
+	curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+	curve, ok := curves[key.Curve]
+	x, errX := decodeSegment(key.X)
+	y, errY := decodeSegment(key.Y)
+	if !ok || errX != nil || errY != nil {
+		return nil, fmt.Errorf("malformed EC key")
+	}
+	public := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !curve.IsOnCurve(public.X, public.Y) {
+		return nil, fmt.Errorf("malformed EC key")
+	}
+	return public, nil
+}
@@ -0,0 +1,208 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+// testKeys are the signing keys of the tests
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ec      *ecdsa.PrivateKey
+	ed      ed25519.PrivateKey
+	rsaJWK  JWK
+	ecJWK   JWK
+	edJWK   JWK
+	otherRS JWK
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaJWK := func(key *rsa.PublicKey, kid string) JWK {
+		return JWK{KeyType: "RSA", KeyID: kid, N: encode(key.N.Bytes()), E: encode(big.NewInt(int64(key.E)).Bytes())}
+	}
+	return &testKeys{
+		rsa:     rsaKey,
+		ec:      ecKey,
+		ed:      edKey,
+		rsaJWK:  rsaJWK(&rsaKey.PublicKey, "rsa"),
+		otherRS: rsaJWK(&otherRSA.PublicKey, "other"),
+		ecJWK:   JWK{KeyType: "EC", KeyID: "ec", Curve: "P-256", X: encode(ecKey.X.FillBytes(make([]byte, 32))), Y: encode(ecKey.Y.FillBytes(make([]byte, 32)))},
+		edJWK:   JWK{KeyType: "OKP", KeyID: "ed", Curve: "Ed25519", X: encode(edPublic)},
+	}
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sign returns a compact JWT of claims signed with alg
+func (k *testKeys) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := encode(header) + "." + encode(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+	switch alg {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+	case "PS256":
+		signature, err = rsa.SignPSS(rand.Reader, k.rsa, crypto.SHA256, digest[:], nil)
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case "EdDSA":
+		signature = ed25519.Sign(k.ed, []byte(signed))
+	default:
+		signature = []byte("signature")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + encode(signature)
+}
+
+func TestVerifySignature(t *testing.T) {
+	keys := newTestKeys(t)
+	claims := map[string]interface{}{"iss": "https://issuer.example.com", "sub": "user"}
+	tampered := func(token string) string {
+		return token[:len(token)-4] + "AAAA"
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		key     JWK
+		wantErr bool
+	}{
+		{"RS256", keys.sign(t, "RS256", "rsa", claims), keys.rsaJWK, false},
+		{"PS256", keys.sign(t, "PS256", "rsa", claims), keys.rsaJWK, false},
+		{"ES256", keys.sign(t, "ES256", "ec", claims), keys.ecJWK, false},
+		{"EdDSA", keys.sign(t, "EdDSA", "ed", claims), keys.edJWK, false},
+		{"RS256 with another key", keys.sign(t, "RS256", "rsa", claims), keys.otherRS, true},
+		{"RS256 tampered", tampered(keys.sign(t, "RS256", "rsa", claims)), keys.rsaJWK, true},
+		{"ES256 tampered", tampered(keys.sign(t, "ES256", "ec", claims)), keys.ecJWK, true},
+		{"EdDSA tampered", tampered(keys.sign(t, "EdDSA", "ed", claims)), keys.edJWK, true},
+		{"RS256 with EC key", keys.sign(t, "RS256", "rsa", claims), keys.ecJWK, true},
+		{"ES256 with RSA key", keys.sign(t, "ES256", "ec", claims), keys.rsaJWK, true},
+		{"HS256", keys.sign(t, "HS256", "rsa", claims), keys.rsaJWK, true},
+		{"none", keys.sign(t, "none", "rsa", claims), keys.rsaJWK, true},
+		{"malformed RSA key", keys.sign(t, "RS256", "rsa", claims), JWK{KeyType: "RSA", N: "!", E: "AQAB"}, true},
+		{"EC point off the curve", keys.sign(t, "ES256", "ec", claims), JWK{KeyType: "EC", Curve: "P-256", X: encode([]byte{1}), Y: encode([]byte{2})}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := ParseJWT(tt.token)
+			if err != nil {
+				t.Fatalf("ParseJWT() error = %v", err)
+			}
+			if err := verifySignature(token, tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("verifySignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeySetFind(t *testing.T) {
+	keys := &KeySet{Keys: []JWK{
+		{KeyType: "RSA", KeyID: "enc", Use: "enc"},
+		{KeyType: "RSA", KeyID: "a", Use: "sig"},
+		{KeyType: "EC", KeyID: "b"},
+	}}
+
+	tests := []struct {
+		name   string
+		keyID  string
+		want   string
+		wantOK bool
+	}{
+		{"by key ID", "b", "b", true},
+		{"signing use", "a", "a", true},
+		{"encryption keys are skipped", "enc", "", false},
+		{"unknown key ID", "c", "", false},
+		{"no key ID takes the first signing key", "", "a", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := keys.find(&JWT{KeyID: tt.keyID})
+			if ok != tt.wantOK || key.KeyID != tt.want {
+				t.Errorf("find(%q) = %q, %v, want %q, %v", tt.keyID, key.KeyID, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestKeyCacheVerify(t *testing.T) {
+	keys := newTestKeys(t)
+	const issuer = "https://issuer.example.com"
+	cache := &KeyCache{Dir: t.TempDir(), TTL: time.Hour}
+	encoded, _ := json.Marshal(&KeySet{Issuer: issuer, FetchedAt: time.Now(), Keys: []JWK{keys.rsaJWK}})
+	if err := os.WriteFile(cache.path(issuer), encoded, 0600); err != nil {
+		t.Fatal(err)
+	}
+	expiry := float64(time.Now().Add(time.Hour).Unix())
+	token := func(iss string, aud interface{}) string {
+		return keys.sign(t, "RS256", "rsa", map[string]interface{}{"iss": iss, "aud": aud, "exp": expiry})
+	}
+
+	tests := []struct {
+		name         string
+		token        string
+		trust        Trust
+		wantVerified bool
+		wantErr      bool
+	}{
+		{"trusted issuer and client", token(issuer, "upid"), Trust{Issuer: issuer, ClientID: "upid"}, true, false},
+		{"client in an audience list", token(issuer, []string{"api", "upid"}), Trust{Issuer: issuer, ClientID: "upid"}, true, false},
+		{"trailing slash of the issuer", token(issuer+"/", "upid"), Trust{Issuer: issuer, ClientID: "upid"}, true, false},
+		{"another issuer", token("https://evil.example.com", "upid"), Trust{Issuer: issuer, ClientID: "upid"}, false, true},
+		{"another client", token(issuer, "other"), Trust{Issuer: issuer, ClientID: "upid"}, false, true},
+		{"no trusted issuer", token(issuer, "upid"), Trust{}, false, false},
+		{"expired", keys.sign(t, "RS256", "rsa", map[string]interface{}{"iss": issuer, "exp": float64(time.Now().Add(-time.Hour).Unix())}), Trust{Issuer: issuer}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := cache.Verify(context.Background(), tt.token, tt.trust, true)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result.Verified != tt.wantVerified {
+				t.Errorf("Verify() verified = %v, want %v (reason %q)", result.Verified, tt.wantVerified, result.Reason)
+			}
+		})
+	}
+}
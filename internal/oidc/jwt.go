@@ -0,0 +1,126 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// JWT is a parsed JSON Web Token. Parsing does not verify the signature, see
+// Verify.
+type JWT struct {
+	// Algorithm is the signing algorithm of the header, e.g. RS256
+	Algorithm string
+	// KeyID names the signing key in the issuer's key set
+	KeyID string
+	// Claims are the claims of the payload
+	Claims map[string]interface{}
+
+	signed    string
+	signature []byte
+}
+
+// IsJWT returns true if token looks like a JWT rather than an opaque token
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// ParseJWT parses a compact serialized JWT without verifying it
+func ParseJWT(token string) (*JWT, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalidToken("not a JWT")
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeJSONSegment(parts[0], &header); err != nil {
+		return nil, invalidToken("invalid header")
+	}
+	claims := map[string]interface{}{}
+	if err := decodeJSONSegment(parts[1], &claims); err != nil {
+		return nil, invalidToken("invalid payload")
+	}
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, invalidToken("invalid signature encoding")
+	}
+	return &JWT{
+		Algorithm: header.Algorithm,
+		KeyID:     header.KeyID,
+		Claims:    claims,
+		signed:    parts[0] + "." + parts[1],
+		signature: signature,
+	}, nil
+}
+
+// Issuer returns the iss claim
+func (t *JWT) Issuer() string {
+	issuer, _ := t.Claims["iss"].(string)
+	return issuer
+}
+
+// IssuedTo returns true if the aud claim, a string or a list, or the azp
+// claim names the client. Access tokens of some providers carry the API as
+// audience and the client as authorized party.
+func (t *JWT) IssuedTo(clientID string) bool {
+	switch aud := t.Claims["aud"].(type) {
+	case string:
+		if aud == clientID {
+			return true
+		}
+	case []interface{}:
+		for _, value := range aud {
+			if value == clientID {
+				return true
+			}
+		}
+	}
+	azp, _ := t.Claims["azp"].(string)
+	return azp == clientID
+}
+
+// Expiry returns the exp claim, the zero time if the token never expires
+func (t *JWT) Expiry() time.Time {
+	return t.timeClaim("exp")
+}
+
+// timeClaim returns a NumericDate claim
+func (t *JWT) timeClaim(name string) time.Time {
+	if seconds, ok := t.Claims[name].(float64); ok {
+		return time.Unix(int64(seconds), 0)
+	}
+	return time.Time{}
+}
+
+// CheckTime returns an error if the token is expired or not yet valid,
+// allowing for leeway in clock skew
+func (t *JWT) CheckTime(now time.Time, leeway time.Duration) error {
+	if exp := t.Expiry(); !exp.IsZero() && now.Add(-leeway).After(exp) {
+		return clierr.New(clierr.CategoryAuth, "TOKEN_EXPIRED", fmt.Sprintf("token expired at %s", exp.Format(time.RFC3339))).
+			WithHint("Run 'upid auth login' to sign in again")
+	}
+	if nbf := t.timeClaim("nbf"); !nbf.IsZero() && now.Add(leeway).Before(nbf) {
+		return invalidToken(fmt.Sprintf("token is not valid before %s", nbf.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// decodeJSONSegment decodes a base64url JSON segment of a JWT into v
+func decodeJSONSegment(segment string, v interface{}) error {
+	data, err := decodeSegment(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// invalidToken returns the error for a token that cannot be trusted
+func invalidToken(reason string) error {
+	return clierr.New(clierr.CategoryAuth, "TOKEN_INVALID", "invalid token: "+reason).
+		WithHint("Run 'upid auth login' to sign in again")
+}
@@ -0,0 +1,209 @@
+// Package oidc signs users in to the UPID API with OpenID Connect: in the
+// browser with a localhost callback, or with the device code flow on
+// machines without a browser.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"golang.org/x/oauth2"
+)
+
+// DefaultScopes are requested when none are configured; offline_access asks
+// for a refresh token
+var DefaultScopes = []string{"openid", "profile", "email", "offline_access"}
+
+// Metadata is the subset of the OpenID provider metadata used here
+type Metadata struct {
+	Issuer                      string `json:"issuer"`
+	AuthorizationEndpoint       string `json:"authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	UserinfoEndpoint            string `json:"userinfo_endpoint"`
+	JWKSURI                     string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID provider and the client UPID uses with it
+type Provider struct {
+	Metadata Metadata
+	ClientID string
+	Scopes   []string
+}
+
+// Discover reads the metadata of the OpenID provider at issuer
+func Discover(ctx context.Context, issuer, clientID string, scopes []string) (*Provider, error) {
+	if issuer == "" || clientID == "" {
+		return nil, clierr.New(clierr.CategoryUsage, "SSO_NOT_CONFIGURED", "single sign-on is not configured").
+			WithHint("Set auth.issuer and auth.client_id with 'upid config set', or pass --issuer and --client-id")
+	}
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	metadata, err := discover(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{Metadata: *metadata, ClientID: clientID, Scopes: scopes}, nil
+}
+
+// discover reads the OpenID provider metadata of issuer
+func discover(ctx context.Context, issuer string) (*Metadata, error) {
+	url := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, clierr.Wrap(err, clierr.CategoryUsage, "SSO_NOT_CONFIGURED", fmt.Sprintf("invalid issuer %q", issuer))
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, clierr.Wrap(err, clierr.CategoryUnreachable, "IDP_UNREACHABLE", "failed to reach the identity provider").
+			WithHint("Check auth.issuer and your network connection")
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, clierr.New(clierr.CategoryGeneral, "IDP_DISCOVERY_FAILED", fmt.Sprintf("identity provider discovery failed: %s", response.Status)).
+			WithHint(fmt.Sprintf("Check that %s is an OpenID Connect issuer", issuer))
+	}
+
+	var metadata Metadata
+	if err := json.NewDecoder(response.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("invalid identity provider metadata: %v", err)
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, clierr.New(clierr.CategoryAuth, "IDP_ISSUER_MISMATCH", fmt.Sprintf("identity provider reports issuer %q instead of %q", metadata.Issuer, issuer))
+	}
+	return &metadata, nil
+}
+
+// oauth2Config returns the OAuth 2.0 client configuration for redirectURL
+func (p *Provider) oauth2Config(redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID: p.ClientID,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:       p.Metadata.AuthorizationEndpoint,
+			TokenURL:      p.Metadata.TokenEndpoint,
+			DeviceAuthURL: p.Metadata.DeviceAuthorizationEndpoint,
+			// Public clients send their client ID in the request body
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+		RedirectURL: redirectURL,
+		Scopes:      p.Scopes,
+	}
+}
+
+// Session is a signed-in session with the identity provider
+type Session struct {
+	Issuer       string    `json:"issuer"`
+	ClientID     string    `json:"client_id"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	IDToken      string    `json:"id_token,omitempty"`
+	TokenType    string    `json:"token_type,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// newSession creates a session from a token response
+func newSession(p *Provider, token *oauth2.Token) *Session {
+	session := &Session{
+		Issuer:       p.Metadata.Issuer,
+		ClientID:     p.ClientID,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		TokenType:    token.TokenType,
+		Expiry:       token.Expiry,
+	}
+	if idToken, ok := token.Extra("id_token").(string); ok {
+		session.IDToken = idToken
+	}
+	return session
+}
+
+// ParseSession decodes a session saved with Encode
+func ParseSession(data string) (*Session, error) {
+	var session Session
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, fmt.Errorf("invalid stored session: %v", err)
+	}
+	return &session, nil
+}
+
+// Encode returns the session as JSON for storage
+func (s *Session) Encode() (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode session: %v", err)
+	}
+	return string(data), nil
+}
+
+// Claims returns the claims of the session's ID token. The token is not
+// verified; it was received directly from the identity provider.
+func (s *Session) Claims() map[string]interface{} {
+	token, err := ParseJWT(s.IDToken)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	return token.Claims
+}
+
+// Subject returns a readable name for the signed-in user
+func (s *Session) Subject() string {
+	claims := s.Claims()
+	for _, claim := range []string{"email", "preferred_username", "name", "sub"} {
+		if value, ok := claims[claim].(string); ok && value != "" {
+			return value
+		}
+	}
+	return "unknown user"
+}
+
+// Expired returns true if the access token expires within margin. Tokens
+// without an expiry never expire.
+func (s *Session) Expired(margin time.Duration) bool {
+	return !s.Expiry.IsZero() && time.Now().Add(margin).After(s.Expiry)
+}
+
+// IDTokenExpiry returns when the session's ID token expires, the zero time
+// if it has no expiry
+func (s *Session) IDTokenExpiry() time.Time {
+	token, err := ParseJWT(s.IDToken)
+	if err != nil {
+		return time.Time{}
+	}
+	return token.Expiry()
+}
+
+// Refresh returns a new session with fresh tokens obtained with the session's
+// refresh token
+func (p *Provider) Refresh(ctx context.Context, s *Session) (*Session, error) {
+	if s.RefreshToken == "" {
+		return nil, clierr.New(clierr.CategoryAuth, "SESSION_EXPIRED", "session expired and cannot be refreshed").
+			WithHint("Run 'upid auth login --sso' to sign in again")
+	}
+	expired := &oauth2.Token{RefreshToken: s.RefreshToken, Expiry: time.Now().Add(-time.Minute)}
+	token, err := p.oauth2Config("").TokenSource(ctx, expired).Token()
+	if err != nil {
+		var retrieve *oauth2.RetrieveError
+		if errors.As(err, &retrieve) && retrieve.ErrorCode == "invalid_grant" {
+			return nil, clierr.Wrap(err, clierr.CategoryAuth, "SESSION_EXPIRED", "session expired or was revoked by the identity provider").
+				WithHint("Run 'upid auth login --sso' to sign in again")
+		}
+		return nil, tokenError(err)
+	}
+
+	refreshed := newSession(p, token)
+	// Providers may keep the refresh token and issue no new ID token
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = s.RefreshToken
+	}
+	if refreshed.IDToken == "" {
+		refreshed.IDToken = s.IDToken
+	}
+	return refreshed, nil
+}
@@ -0,0 +1,47 @@
+package oidc
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"golang.org/x/oauth2"
+)
+
+// WebLogin is a sign-in of a user of a web application served by UPID, with
+// the authorization code flow and PKCE, between the redirect to the
+// identity provider and its callback
+type WebLogin struct {
+	State       string
+	RedirectURL string
+	verifier    string
+}
+
+// StartWebLogin starts a sign-in whose callback is redirectURL, and returns
+// it with the authorization URL to redirect the user to
+func (p *Provider) StartWebLogin(redirectURL string) (*WebLogin, string) {
+	login := &WebLogin{State: randomString(), RedirectURL: redirectURL, verifier: oauth2.GenerateVerifier()}
+	return login, p.oauth2Config(redirectURL).AuthCodeURL(login.State, oauth2.S256ChallengeOption(login.verifier))
+}
+
+// FinishWebLogin completes a sign-in with the query of its callback
+func (p *Provider) FinishWebLogin(ctx context.Context, login *WebLogin, query url.Values) (*Session, error) {
+	switch {
+	case query.Get("state") != login.State:
+		return nil, clierr.New(clierr.CategoryAuth, "SSO_FAILED", "login callback with an unexpected state")
+	case query.Get("error") != "":
+		message := query.Get("error")
+		if description := query.Get("error_description"); description != "" {
+			message += ": " + description
+		}
+		return nil, clierr.New(clierr.CategoryAuth, "SSO_FAILED", "identity provider rejected the login: "+message)
+	case query.Get("code") == "":
+		return nil, clierr.New(clierr.CategoryAuth, "SSO_FAILED", "login callback without an authorization code")
+	}
+
+	token, err := p.oauth2Config(login.RedirectURL).Exchange(ctx, query.Get("code"), oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		return nil, tokenError(err)
+	}
+	return newSession(p, token), nil
+}
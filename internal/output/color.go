@@ -0,0 +1,89 @@
+package output
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ANSI color codes
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorBold   = "\x1b[1m"
+)
+
+// ColorEnabled reports whether colored output should be written to f. Color
+// is disabled by --no-color, the NO_COLOR environment variable, a dumb
+// terminal, or when f is not a terminal.
+func ColorEnabled(noColor bool, f *os.File) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// Colorize wraps text in the given color code
+func Colorize(text, color string) string {
+	if color == "" || text == "" {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// cellColor picks the color for a table cell based on its column
+func cellColor(column, value string) string {
+	column = strings.ToLower(column)
+	switch {
+	case strings.Contains(column, "savings"):
+		if value != "" && value != "-" && value != "0" && !strings.HasPrefix(value, "-") {
+			return colorGreen
+		}
+	case column == "severity" || column == "priority" || column == "risk" || column == "level":
+		return severityColor(value)
+	case column == "status" || column == "state" || column == "health" || column == "phase":
+		return statusColor(value)
+	}
+	return ""
+}
+
+// severityColor maps severity values to colors
+func severityColor(value string) string {
+	switch strings.ToLower(value) {
+	case "critical", "fatal":
+		return colorBold + colorRed
+	case "high", "error":
+		return colorRed
+	case "medium", "warning", "warn":
+		return colorYellow
+	case "low", "info":
+		return colorGreen
+	}
+	return ""
+}
+
+// statusColor maps status values to colors
+func statusColor(value string) string {
+	switch strings.ToLower(value) {
+	case "active", "healthy", "running", "ready", "ok", "success", "succeeded", "completed", "accepted", "true":
+		return colorGreen
+	case "inactive", "pending", "degraded", "unknown", "warning", "idle", "deferred":
+		return colorYellow
+	case "error", "failed", "unhealthy", "crashloopbackoff", "false":
+		return colorRed
+	}
+	return ""
+}
+
+// ColorizeSeverity colors text by a severity such as critical or warning
+func ColorizeSeverity(text, severity string) string {
+	return Colorize(text, severityColor(severity))
+}
+
+// ColorizeStatus colors text by a status such as running or failed
+func ColorizeStatus(text, status string) string {
+	return Colorize(text, statusColor(status))
+}
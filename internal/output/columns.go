@@ -0,0 +1,94 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Column describes a table column
+type Column struct {
+	// Name is the key used to select the column with --columns
+	Name string
+	// Header is the column title; derived from Name when empty
+	Header string
+	// Field is the record field shown, a dot-separated path for nested values
+	Field string
+	// Wide columns are only shown with -o wide or when selected explicitly
+	Wide bool
+}
+
+// title returns the header text of the column
+func (c Column) title() string {
+	if c.Header != "" {
+		return c.Header
+	}
+	return header(c.Name)
+}
+
+// value looks up the column's field in a record
+func (c Column) value(record map[string]interface{}) interface{} {
+	field := c.Field
+	if field == "" {
+		field = c.Name
+	}
+
+	var current interface{} = record
+	for _, part := range strings.Split(field, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[part]
+	}
+	return current
+}
+
+// resolveColumns picks the columns to render for records. Registered columns
+// are used when present, otherwise every field becomes a column. An explicit
+// selection overrides the wide/narrow default.
+func resolveColumns(records []map[string]interface{}, opts Options) ([]Column, error) {
+	available := opts.Columns
+	if len(available) == 0 {
+		for _, name := range Columns(records) {
+			available = append(available, Column{Name: name, Field: name})
+		}
+	}
+
+	if len(opts.Select) == 0 {
+		if opts.Wide {
+			return available, nil
+		}
+		var columns []Column
+		for _, column := range available {
+			if !column.Wide {
+				columns = append(columns, column)
+			}
+		}
+		return columns, nil
+	}
+
+	var columns []Column
+	for _, name := range opts.Select {
+		column, ok := findColumn(available, name)
+		if !ok {
+			names := make([]string, len(available))
+			for i, c := range available {
+				names[i] = c.Name
+			}
+			return nil, fmt.Errorf("unknown column %q (available: %s)", name, strings.Join(names, ", "))
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// findColumn matches a selection against column names and fields
+func findColumn(columns []Column, name string) (Column, bool) {
+	name = strings.TrimSpace(name)
+	for _, column := range columns {
+		if strings.EqualFold(column.Name, name) || strings.EqualFold(column.Field, name) {
+			return column, true
+		}
+	}
+	return Column{}, false
+}
@@ -0,0 +1,67 @@
+package output
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+)
+
+// renderCSV writes data as CSV. Lists of records get a header row of field
+// names; other objects are written as key,value pairs.
+func renderCSV(w io.Writer, data interface{}, opts Options) error {
+	writer := csv.NewWriter(w)
+
+	if records := Rows(data); records != nil {
+		// CSV is meant for further processing, so include every column
+		// unless a selection was made
+		opts.Wide = true
+		columns, err := resolveColumns(records, opts)
+		if err != nil {
+			return err
+		}
+		names := make([]string, len(columns))
+		for i, column := range columns {
+			names[i] = column.Name
+		}
+		if err := writer.Write(names); err != nil {
+			return err
+		}
+		for _, record := range records {
+			row := make([]string, len(columns))
+			for i, column := range columns {
+				if value := column.value(record); value != nil {
+					row[i] = FormatValue(value)
+				}
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+	} else if object, ok := data.(map[string]interface{}); ok {
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		if err := writer.Write([]string{"key", "value"}); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			value := ""
+			if object[key] != nil {
+				value = FormatValue(object[key])
+			}
+			if err := writer.Write([]string{key, value}); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := writer.Write([]string{FormatValue(data)}); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
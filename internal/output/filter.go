@@ -0,0 +1,175 @@
+package output
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Filter is a client-side condition on a record field, such as
+// "confidence>0.9" or "namespace=default"
+type Filter struct {
+	Key   string
+	Op    string
+	Value string
+}
+
+// filterOperators are tried in order, so longer operators must come first
+var filterOperators = []string{" contains ", ">=", "<=", "!=", "==", "=", ">", "<", "~"}
+
+// ParseFilter parses a filter expression of the form key<op>value. Supported
+// operators are =, ==, !=, >, <, >=, <=, ~ and "contains".
+func ParseFilter(expr string) (Filter, error) {
+	for _, op := range filterOperators {
+		idx := strings.Index(expr, op)
+		if idx <= 0 {
+			continue
+		}
+		key := strings.TrimSpace(expr[:idx])
+		value := strings.TrimSpace(expr[idx+len(op):])
+		op = strings.TrimSpace(op)
+		if op == "==" {
+			op = "="
+		}
+		if op == "~" {
+			op = "contains"
+		}
+		return Filter{Key: key, Op: op, Value: value}, nil
+	}
+	return Filter{}, fmt.Errorf("invalid filter %q (expected key=value, key>value, key<value, key!=value or 'key contains value')", expr)
+}
+
+// Matches reports whether value satisfies the filter
+func (f Filter) Matches(value interface{}) bool {
+	if value == nil {
+		return f.Op == "!="
+	}
+
+	text := FormatValue(value)
+	if s, ok := value.(string); ok {
+		text = s
+	}
+
+	if f.Op == "contains" {
+		return strings.Contains(strings.ToLower(text), strings.ToLower(f.Value))
+	}
+
+	cmp := compareText(text, f.Value, value)
+	switch f.Op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// compareText compares a record value with a filter operand, numerically
+// when both are numbers
+func compareText(text, operand string, value interface{}) int {
+	if n, ok := value.(float64); ok {
+		if m, err := strconv.ParseFloat(operand, 64); err == nil {
+			switch {
+			case n < m:
+				return -1
+			case n > m:
+				return 1
+			}
+			return 0
+		}
+	}
+	if b, ok := value.(bool); ok {
+		text = strconv.FormatBool(b)
+	}
+	return strings.Compare(strings.ToLower(text), strings.ToLower(operand))
+}
+
+// transformRecords applies sorting and filtering from opts to the record
+// list in data and returns the updated data
+func transformRecords(data interface{}, opts Options) (interface{}, error) {
+	if opts.SortBy == "" && len(opts.Filters) == 0 {
+		return data, nil
+	}
+
+	list, replace := recordList(data)
+	if list == nil {
+		return nil, fmt.Errorf("--sort-by and --filter require a list result")
+	}
+
+	var filters []Filter
+	for _, expr := range opts.Filters {
+		filter, err := ParseFilter(expr)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	var kept []interface{}
+	for _, item := range list {
+		record := item.(map[string]interface{})
+		match := true
+		for _, filter := range filters {
+			if !filter.Matches(lookupColumn(opts.Columns, filter.Key).value(record)) {
+				match = false
+				break
+			}
+		}
+		if match {
+			kept = append(kept, item)
+		}
+	}
+
+	if opts.SortBy != "" {
+		key, descending := opts.SortBy, false
+		if strings.HasPrefix(key, "-") {
+			key, descending = key[1:], true
+		}
+		column := lookupColumn(opts.Columns, key)
+		sort.SliceStable(kept, func(i, j int) bool {
+			a := column.value(kept[i].(map[string]interface{}))
+			b := column.value(kept[j].(map[string]interface{}))
+			if descending {
+				return lessValue(b, a)
+			}
+			return lessValue(a, b)
+		})
+	}
+
+	if kept == nil {
+		kept = []interface{}{}
+	}
+	return replace(kept), nil
+}
+
+// lookupColumn resolves a key to a registered column, or to a field path
+func lookupColumn(columns []Column, key string) Column {
+	key = strings.TrimPrefix(strings.TrimSpace(key), ".")
+	if column, ok := findColumn(columns, key); ok {
+		return column
+	}
+	return Column{Name: key, Field: key}
+}
+
+// lessValue orders values: missing values last, numbers numerically and
+// everything else as text
+func lessValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a != nil && b == nil
+	}
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			return x < y
+		}
+	}
+	return FormatValue(a) < FormatValue(b)
+}
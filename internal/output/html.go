@@ -0,0 +1,227 @@
+package output
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"regexp"
+)
+
+//go:embed report.html
+var reportTemplate string
+
+// HTMLReport is a standalone HTML document: headline figures, then sections
+// of text, a chart and a table. Styles and charts are inline, so that the
+// file opens anywhere without network access.
+type HTMLReport struct {
+	Title    string
+	Subtitle string
+	Figures  []HTMLFigure
+	Sections []HTMLSection
+	Footer   string
+	Brand    Brand
+}
+
+// Brand is the branding of a report: the name of the company shown on top
+// of it, the color of its headings and its logo, a PNG or JPEG image
+type Brand struct {
+	Name  string
+	Color string
+	Logo  []byte
+}
+
+// brandColor matches the hex colors of brands
+var brandColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// Validate checks that the color of a brand is a hex color such as #3b6fd8
+// and that its logo is a PNG or JPEG image
+func (b Brand) Validate() error {
+	if b.Color != "" && !brandColor.MatchString(b.Color) {
+		return fmt.Errorf("invalid brand color %q (expected a hex color such as #3b6fd8)", b.Color)
+	}
+	if len(b.Logo) > 0 {
+		if _, _, err := image.DecodeConfig(bytes.NewReader(b.Logo)); err != nil {
+			return fmt.Errorf("invalid logo: %v (expected a PNG or JPEG image)", err)
+		}
+	}
+	return nil
+}
+
+// HTMLFigure is a headline figure of a report, such as its total cost
+type HTMLFigure struct {
+	Label string
+	Value string
+	Note  string
+}
+
+// HTMLSection is a titled part of a report; its chart and table are
+// optional
+type HTMLSection struct {
+	Title string
+	Text  string
+	Chart *BarChart
+	Table *HTMLTable
+}
+
+// HTMLTable is a table of formatted cells. Numeric columns are aligned
+// right, and cells that are a severity are colored by it.
+type HTMLTable struct {
+	Columns []string
+	Numeric []bool
+	Rows    [][]string
+}
+
+// BarChart is a horizontal bar chart, drawn as inline SVG. Each bar stacks
+// its values, one for each series.
+type BarChart struct {
+	Series []string
+	Bars   []Bar
+}
+
+// Bar is a bar of a chart with its label, and Text shown after it
+type Bar struct {
+	Label  string
+	Values []float64
+	Text   string
+}
+
+// Layout of charts, in pixels
+const (
+	chartWidth  = 720
+	chartLabel  = 180
+	chartText   = 110
+	chartRow    = 26
+	chartBar    = 16
+	chartLegend = 24
+)
+
+// chartColors are the colors of the series of charts, in order
+var chartColors = []string{"#3b6fd8", "#e8a33d", "#9aa5b1", "#2eaa6f", "#d9534f", "#8e6fd8"}
+
+// chartLayout is a chart laid out for the template
+type chartLayout struct {
+	Width, Height int
+	LabelX, TextX int
+	Legend        []chartSwatch
+	Bars          []barLayout
+}
+
+type chartSwatch struct {
+	X     int
+	Color string
+	Name  string
+}
+
+type barLayout struct {
+	Y        int
+	TextY    int
+	Label    string
+	Text     string
+	Segments []segmentLayout
+}
+
+type segmentLayout struct {
+	X, Width float64
+	Color    string
+	Title    string
+}
+
+// layout scales the bars of a chart to the width left by their labels and
+// texts
+func (c *BarChart) layout() chartLayout {
+	total := func(b Bar) float64 {
+		sum := 0.0
+		for _, v := range b.Values {
+			sum += math.Max(v, 0)
+		}
+		return sum
+	}
+	largest := 0.0
+	for _, b := range c.Bars {
+		largest = math.Max(largest, total(b))
+	}
+	span := float64(chartWidth - chartLabel - chartText - 8)
+
+	l := chartLayout{Width: chartWidth, LabelX: chartLabel - 8, TextX: chartWidth - chartText}
+	top := 0
+	if len(c.Series) > 1 {
+		x := chartLabel
+		for i, name := range c.Series {
+			l.Legend = append(l.Legend, chartSwatch{X: x, Color: chartColors[i%len(chartColors)], Name: name})
+			x += 24 + 8*len(name)
+		}
+		top = chartLegend
+	}
+	for i, b := range c.Bars {
+		y := top + i*chartRow
+		bar := barLayout{Y: y + (chartRow-chartBar)/2, TextY: y + chartRow/2 + 4, Label: b.Label, Text: b.Text}
+		x := float64(chartLabel)
+		for j, v := range b.Values {
+			if v <= 0 || largest == 0 {
+				continue
+			}
+			width := v / largest * span
+			title := fmt.Sprintf("%s: %.2f", b.Label, v)
+			if j < len(c.Series) {
+				title = fmt.Sprintf("%s %s: %.2f", b.Label, c.Series[j], v)
+			}
+			bar.Segments = append(bar.Segments, segmentLayout{
+				X:     x,
+				Width: width,
+				Color: chartColors[j%len(chartColors)],
+				Title: title,
+			})
+			x += width
+		}
+		l.Bars = append(l.Bars, bar)
+	}
+	l.Height = top + len(c.Bars)*chartRow
+	return l
+}
+
+// severityClass returns the class of the cells of a report that are a
+// severity: critical, warning or info
+func severityClass(value string) string {
+	switch severityColor(value) {
+	case colorBold + colorRed, colorRed:
+		return "critical"
+	case colorYellow:
+		return "warning"
+	case colorGreen:
+		return "info"
+	}
+	return ""
+}
+
+// WriteHTML writes a report as a standalone HTML document
+func WriteHTML(w io.Writer, report HTMLReport) error {
+	tmpl, err := template.New("report").Funcs(template.FuncMap{
+		"layout": func(c *BarChart) chartLayout { return c.layout() },
+		"numeric": func(t *HTMLTable, column int) bool {
+			return column < len(t.Numeric) && t.Numeric[column]
+		},
+		"severity": severityClass,
+		// logo returns the logo of a brand as a data URL
+		"logo": func(b Brand) template.URL {
+			_, format, err := image.DecodeConfig(bytes.NewReader(b.Logo))
+			if err != nil {
+				return ""
+			}
+			return template.URL("data:image/" + format + ";base64," + base64.StdEncoding.EncodeToString(b.Logo))
+		},
+	}).Parse(reportTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse report template: %v", err)
+	}
+	if err := tmpl.Execute(w, report); err != nil {
+		return fmt.Errorf("failed to write report: %v", err)
+	}
+	return nil
+}
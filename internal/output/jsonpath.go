@@ -0,0 +1,617 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONPath is a parsed kubectl-style JSONPath template such as
+// "{.items[*].name}" or "{range .items[*]}{.name}{'\n'}{end}".
+type JSONPath struct {
+	nodes []jsonPathNode
+}
+
+// jsonPathNode is a piece of a template: literal text, a path expression or
+// a range block
+type jsonPathNode struct {
+	text     string
+	path     []pathSegment
+	isPath   bool
+	children []jsonPathNode
+	isRange  bool
+}
+
+// pathSegment is one step of a path expression
+type pathSegment struct {
+	kind      segmentKind
+	name      string
+	names     []string
+	index     int
+	start     *int
+	end       *int
+	filter    *pathFilter
+	recursive bool
+}
+
+type segmentKind int
+
+const (
+	segmentRoot segmentKind = iota
+	segmentField
+	segmentFields
+	segmentWildcard
+	segmentIndex
+	segmentSlice
+	segmentFilter
+)
+
+// pathFilter is a [?(@.field op value)] expression
+type pathFilter struct {
+	path  []pathSegment
+	op    string
+	value interface{}
+}
+
+// ParseJSONPath parses a JSONPath template. A bare expression without braces
+// is treated as a single path, so ".items[*].name" also works.
+func ParseJSONPath(template string) (*JSONPath, error) {
+	if !strings.Contains(template, "{") {
+		template = "{" + template + "}"
+	}
+
+	nodes, _, err := parseTemplate(template, false)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONPath{nodes: nodes}, nil
+}
+
+// parseTemplate parses nodes until the end of input or, inside a range block,
+// until the closing {end}. It returns the unparsed remainder after {end}.
+func parseTemplate(template string, inRange bool) ([]jsonPathNode, string, error) {
+	var nodes []jsonPathNode
+	for template != "" {
+		open := strings.Index(template, "{")
+		if open < 0 {
+			nodes = append(nodes, jsonPathNode{text: template})
+			template = ""
+			break
+		}
+		if open > 0 {
+			nodes = append(nodes, jsonPathNode{text: template[:open]})
+		}
+
+		close := matchingBrace(template, open)
+		if close < 0 {
+			return nil, "", fmt.Errorf("jsonpath: unclosed action in %q", template)
+		}
+		action := strings.TrimSpace(template[open+1 : close])
+		template = template[close+1:]
+
+		switch {
+		case action == "end":
+			if !inRange {
+				return nil, "", fmt.Errorf("jsonpath: {end} without {range}")
+			}
+			return nodes, template, nil
+		case strings.HasPrefix(action, "range "):
+			path, err := parsePath(strings.TrimSpace(strings.TrimPrefix(action, "range ")))
+			if err != nil {
+				return nil, "", err
+			}
+			children, rest, err := parseTemplate(template, true)
+			if err != nil {
+				return nil, "", err
+			}
+			template = rest
+			nodes = append(nodes, jsonPathNode{path: path, children: children, isRange: true})
+		case isQuoted(action):
+			nodes = append(nodes, jsonPathNode{text: unescape(action[1 : len(action)-1])})
+		default:
+			path, err := parsePath(action)
+			if err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, jsonPathNode{path: path, isPath: true})
+		}
+	}
+
+	if inRange {
+		return nil, "", fmt.Errorf("jsonpath: {range} without {end}")
+	}
+	return nodes, "", nil
+}
+
+// matchingBrace finds the brace closing the action opened at start, skipping
+// braces inside quoted strings
+func matchingBrace(s string, start int) int {
+	var quote byte
+	for i := start + 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '}':
+			return i
+		}
+	}
+	return -1
+}
+
+// parsePath parses a path expression such as .items[0].name
+func parsePath(expr string) ([]pathSegment, error) {
+	expr = strings.TrimSpace(expr)
+	segments := []pathSegment{{kind: segmentRoot, name: "@"}}
+	if strings.HasPrefix(expr, "$") {
+		segments[0].name = "$"
+		expr = expr[1:]
+	} else if strings.HasPrefix(expr, "@") {
+		expr = expr[1:]
+	}
+
+	for expr != "" {
+		recursive := false
+		switch {
+		case strings.HasPrefix(expr, ".."):
+			recursive = true
+			expr = expr[2:]
+		case expr[0] == '.':
+			expr = expr[1:]
+		}
+
+		if expr == "" {
+			if recursive {
+				return nil, fmt.Errorf("jsonpath: incomplete recursive descent")
+			}
+			break
+		}
+
+		if expr[0] == '[' {
+			close := matchingBracket(expr)
+			if close < 0 {
+				return nil, fmt.Errorf("jsonpath: unclosed '[' in %q", expr)
+			}
+			segment, err := parseBracket(expr[1:close])
+			if err != nil {
+				return nil, err
+			}
+			segment.recursive = recursive
+			segments = append(segments, segment)
+			expr = expr[close+1:]
+			continue
+		}
+
+		end := strings.IndexAny(expr, ".[")
+		if end < 0 {
+			end = len(expr)
+		}
+		name := expr[:end]
+		expr = expr[end:]
+		if name == "*" {
+			segments = append(segments, pathSegment{kind: segmentWildcard, recursive: recursive})
+		} else {
+			segments = append(segments, pathSegment{kind: segmentField, name: name, recursive: recursive})
+		}
+	}
+
+	return segments, nil
+}
+
+// matchingBracket finds the ']' closing the '[' at the start of s
+func matchingBracket(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseBracket parses the contents of a [...] subscript
+func parseBracket(inner string) (pathSegment, error) {
+	inner = strings.TrimSpace(inner)
+	switch {
+	case inner == "*":
+		return pathSegment{kind: segmentWildcard}, nil
+	case strings.HasPrefix(inner, "?(") && strings.HasSuffix(inner, ")"):
+		filter, err := parseFilter(inner[2 : len(inner)-1])
+		if err != nil {
+			return pathSegment{}, err
+		}
+		return pathSegment{kind: segmentFilter, filter: filter}, nil
+	case strings.Contains(inner, ":"):
+		parts := strings.SplitN(inner, ":", 3)
+		segment := pathSegment{kind: segmentSlice}
+		for i, target := range []**int{&segment.start, &segment.end} {
+			if part := strings.TrimSpace(parts[i]); part != "" {
+				n, err := strconv.Atoi(part)
+				if err != nil {
+					return pathSegment{}, fmt.Errorf("jsonpath: invalid slice %q", inner)
+				}
+				*target = &n
+			}
+		}
+		return segment, nil
+	}
+
+	var names []string
+	for _, part := range strings.Split(inner, ",") {
+		part = strings.TrimSpace(part)
+		if isQuoted(part) {
+			names = append(names, part[1:len(part)-1])
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return pathSegment{}, fmt.Errorf("jsonpath: invalid subscript %q", inner)
+		}
+		if len(names) == 0 && !strings.Contains(inner, ",") {
+			return pathSegment{kind: segmentIndex, index: n}, nil
+		}
+		return pathSegment{}, fmt.Errorf("jsonpath: index lists are not supported: %q", inner)
+	}
+	if len(names) == 1 {
+		return pathSegment{kind: segmentField, name: names[0]}, nil
+	}
+	return pathSegment{kind: segmentFields, names: names}, nil
+}
+
+// parseFilter parses the body of a [?(...)] filter
+func parseFilter(expr string) (*pathFilter, error) {
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">", "=~"} {
+		idx := strings.Index(expr, op)
+		if idx < 0 {
+			continue
+		}
+		path, err := parsePath(strings.TrimSpace(expr[:idx]))
+		if err != nil {
+			return nil, err
+		}
+		return &pathFilter{path: path, op: op, value: parseLiteral(strings.TrimSpace(expr[idx+len(op):]))}, nil
+	}
+
+	// Existence check
+	path, err := parsePath(strings.TrimSpace(expr))
+	if err != nil {
+		return nil, err
+	}
+	return &pathFilter{path: path}, nil
+}
+
+// parseLiteral converts a filter operand to a JSON value
+func parseLiteral(s string) interface{} {
+	if isQuoted(s) {
+		return s[1 : len(s)-1]
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n
+	}
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	return s
+}
+
+// Execute evaluates the template against data and writes the result
+func (j *JSONPath) Execute(w io.Writer, data interface{}) error {
+	var b strings.Builder
+	if err := executeNodes(&b, j.nodes, data, data); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// executeNodes renders nodes with current as the @ context
+func executeNodes(b *strings.Builder, nodes []jsonPathNode, root, current interface{}) error {
+	for _, node := range nodes {
+		switch {
+		case node.isRange:
+			values, err := evaluatePath(node.path, root, current)
+			if err != nil {
+				return err
+			}
+			for _, value := range flattenLists(values) {
+				if err := executeNodes(b, node.children, root, value); err != nil {
+					return err
+				}
+			}
+		case node.isPath:
+			values, err := evaluatePath(node.path, root, current)
+			if err != nil {
+				return err
+			}
+			for i, value := range values {
+				if i > 0 {
+					b.WriteString(" ")
+				}
+				b.WriteString(jsonPathValue(value))
+			}
+		default:
+			b.WriteString(node.text)
+		}
+	}
+	return nil
+}
+
+// flattenLists expands a single list result so {range .items} and
+// {range .items[*]} behave the same
+func flattenLists(values []interface{}) []interface{} {
+	if len(values) == 1 {
+		if list, ok := values[0].([]interface{}); ok {
+			return list
+		}
+	}
+	return values
+}
+
+// Evaluate returns all values matched by a path expression
+func (j *JSONPath) Evaluate(data interface{}) ([]interface{}, error) {
+	var values []interface{}
+	for _, node := range j.nodes {
+		if !node.isPath {
+			continue
+		}
+		matched, err := evaluatePath(node.path, data, data)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, matched...)
+	}
+	return values, nil
+}
+
+// evaluatePath applies path segments to the current values
+func evaluatePath(path []pathSegment, root, current interface{}) ([]interface{}, error) {
+	values := []interface{}{current}
+	for _, segment := range path {
+		if segment.kind == segmentRoot {
+			if segment.name == "$" {
+				values = []interface{}{root}
+			}
+			continue
+		}
+
+		var next []interface{}
+		for _, value := range values {
+			candidates := []interface{}{value}
+			if segment.recursive {
+				candidates = descendants(value)
+			}
+			for _, candidate := range candidates {
+				matched, err := applySegment(segment, root, candidate)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, matched...)
+			}
+		}
+		values = next
+	}
+	return values, nil
+}
+
+// applySegment applies one path step to a value
+func applySegment(segment pathSegment, root, value interface{}) ([]interface{}, error) {
+	switch segment.kind {
+	case segmentField:
+		if object, ok := value.(map[string]interface{}); ok {
+			if field, ok := object[segment.name]; ok {
+				return []interface{}{field}, nil
+			}
+		}
+		return nil, nil
+	case segmentFields:
+		var out []interface{}
+		if object, ok := value.(map[string]interface{}); ok {
+			for _, name := range segment.names {
+				if field, ok := object[name]; ok {
+					out = append(out, field)
+				}
+			}
+		}
+		return out, nil
+	case segmentWildcard:
+		switch v := value.(type) {
+		case []interface{}:
+			return v, nil
+		case map[string]interface{}:
+			keys := sortedKeys(v)
+			out := make([]interface{}, 0, len(keys))
+			for _, key := range keys {
+				out = append(out, v[key])
+			}
+			return out, nil
+		}
+		return nil, nil
+	case segmentIndex:
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+		index := segment.index
+		if index < 0 {
+			index += len(list)
+		}
+		if index < 0 || index >= len(list) {
+			return nil, fmt.Errorf("jsonpath: index %d out of range", segment.index)
+		}
+		return []interface{}{list[index]}, nil
+	case segmentSlice:
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+		start, end := 0, len(list)
+		if segment.start != nil {
+			start = clampIndex(*segment.start, len(list))
+		}
+		if segment.end != nil {
+			end = clampIndex(*segment.end, len(list))
+		}
+		if start >= end {
+			return nil, nil
+		}
+		return list[start:end], nil
+	case segmentFilter:
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+		var out []interface{}
+		for _, item := range list {
+			match, err := segment.filter.matches(root, item)
+			if err != nil {
+				return nil, err
+			}
+			if match {
+				out = append(out, item)
+			}
+		}
+		return out, nil
+	}
+	return nil, nil
+}
+
+// matches evaluates the filter against a list element
+func (f *pathFilter) matches(root, item interface{}) (bool, error) {
+	values, err := evaluatePath(f.path, root, item)
+	if err != nil {
+		return false, err
+	}
+	if f.op == "" {
+		return len(values) > 0, nil
+	}
+	for _, value := range values {
+		if compareValues(value, f.op, f.value) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compareValues compares a value with a filter literal
+func compareValues(left interface{}, op string, right interface{}) bool {
+	if l, ok := left.(float64); ok {
+		if r, ok := right.(float64); ok {
+			switch op {
+			case "==":
+				return l == r
+			case "!=":
+				return l != r
+			case "<":
+				return l < r
+			case ">":
+				return l > r
+			case "<=":
+				return l <= r
+			case ">=":
+				return l >= r
+			}
+		}
+	}
+
+	l, r := jsonPathValue(left), jsonPathValue(right)
+	switch op {
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	case "=~":
+		return strings.Contains(l, r)
+	case "<":
+		return l < r
+	case ">":
+		return l > r
+	case "<=":
+		return l <= r
+	case ">=":
+		return l >= r
+	}
+	return false
+}
+
+// descendants returns value and everything nested below it
+func descendants(value interface{}) []interface{} {
+	out := []interface{}{value}
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			out = append(out, descendants(item)...)
+		}
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			out = append(out, descendants(v[key])...)
+		}
+	}
+	return out
+}
+
+// jsonPathValue formats a matched value; strings are printed unquoted
+func jsonPathValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	out, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(out)
+}
+
+func clampIndex(i, length int) int {
+	if i < 0 {
+		i += length
+	}
+	if i < 0 {
+		return 0
+	}
+	if i > length {
+		return length
+	}
+	return i
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && (s[0] == '\'' && s[len(s)-1] == '\'' || s[0] == '"' && s[len(s)-1] == '"')
+}
+
+// unescape handles the escape sequences allowed in quoted template text
+func unescape(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\r`, "\r", `\\`, `\`).Replace(s)
+}
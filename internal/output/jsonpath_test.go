@@ -0,0 +1,133 @@
+package output
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const jsonPathDoc = `{
+	"kind": "List",
+	"items": [
+		{"name": "api", "namespace": "prod", "replicas": 3, "cost": 12.5, "labels": {"app": "api", "tier": "web"}},
+		{"name": "worker", "namespace": "prod", "replicas": 1, "cost": 4, "labels": {"app": "worker"}},
+		{"name": "cron", "namespace": "batch", "replicas": 0, "cost": 0.25, "suspended": true}
+	]
+}`
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  bool
+	}{
+		{"{.items[*].name}", false},
+		{".items[*].name", false},
+		{"$.items[0]", false},
+		{"{range .items[*]}{.name}{'\\n'}{end}", false},
+		{"{.items[?(@.cost > 1)].name}", false},
+		{"{.items['name','kind']}", false},
+		{"{.items[1:]}", false},
+		{"{..name}", false},
+		{"{.items[*].name", true},
+		{"{end}", true},
+		{"{range .items[*]}{.name}", true},
+		{"{.items[0}", true},
+		{"{.items[x]}", true},
+		{"{.items[0,1]}", true},
+		{"{.items[a:b]}", true},
+		{"{..}", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			if _, err := ParseJSONPath(tt.template); (err != nil) != tt.wantErr {
+				t.Errorf("ParseJSONPath(%q) error = %v, wantErr %v", tt.template, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJSONPathExecute(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(jsonPathDoc), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"field", "{.kind}", "List", false},
+		{"bare expression", ".kind", "List", false},
+		{"root", "{$.kind}", "List", false},
+		{"wildcard", "{.items[*].name}", "api worker cron", false},
+		{"index", "{.items[0].name}", "api", false},
+		{"negative index", "{.items[-1].name}", "cron", false},
+		{"index out of range", "{.items[3].name}", "", true},
+		{"slice", "{.items[1:].name}", "worker cron", false},
+		{"slice with end", "{.items[:2].name}", "api worker", false},
+		{"negative slice", "{.items[-2:].name}", "worker cron", false},
+		{"empty slice", "{.items[2:1].name}", "", false},
+		{"quoted field", "{.items[0]['name']}", "api", false},
+		{"field list", "{.items[0]['name','namespace']}", "api prod", false},
+		{"missing field", "{.items[*].missing}", "", false},
+		{"numbers and objects as JSON", "{.items[0].replicas} {.items[0].labels}", `3 {"app":"api","tier":"web"}`, false},
+		{"map wildcard sorts keys", "{.items[0].labels.*}", "api web", false},
+		{"recursive descent", "{..app}", "api worker", false},
+		{"filter by string", "{.items[?(@.namespace == 'batch')].name}", "cron", false},
+		{"filter by number", "{.items[?(@.cost > 1)].name}", "api worker", false},
+		{"filter not equal", "{.items[?(@.replicas != 1)].name}", "api cron", false},
+		{"filter at most", "{.items[?(@.replicas <= 1)].name}", "worker cron", false},
+		{"filter by bool", "{.items[?(@.suspended == true)].name}", "cron", false},
+		{"filter contains", "{.items[?(@.name =~ 'r')].name}", "worker cron", false},
+		{"filter existence", "{.items[?(@.labels.tier)].name}", "api", false},
+		{"literal text", "kind={.kind}", "kind=List", false},
+		{"quoted text", "{.kind}{'\\t'}{.items[0].name}", "List\tapi", false},
+		{"braces in quotes", "{'{x}'}", "{x}", false},
+		{"range", "{range .items[*]}{.name}:{.replicas}{'\\n'}{end}", "api:3\nworker:1\ncron:0\n", false},
+		{"range over a list", "{range .items}{.name},{end}", "api,worker,cron,", false},
+		{"range with root", "{range .items[:2]}{$.kind}/{.name} {end}", "List/api List/worker ", false},
+		{"nested range", "{range .items[:2]}{.name}[{range .labels.*}{@} {end}]{end}", "api[api web ]worker[worker ]", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := ParseJSONPath(tt.template)
+			if err != nil {
+				t.Fatalf("ParseJSONPath(%q) error = %v", tt.template, err)
+			}
+			var b strings.Builder
+			err = path.Execute(&b, data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Execute(%q) error = %v, wantErr %v", tt.template, err, tt.wantErr)
+			}
+			if err == nil && b.String() != tt.want {
+				t.Errorf("Execute(%q) = %q, want %q", tt.template, b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONPathEvaluate(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(jsonPathDoc), &data); err != nil {
+		t.Fatal(err)
+	}
+	path, err := ParseJSONPath("{.items[*].replicas}{.kind}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := path.Evaluate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{3.0, 1.0, 0.0, "List"}
+	if len(values) != len(want) {
+		t.Fatalf("Evaluate() = %v, want %v", values, want)
+	}
+	for i := range want {
+		if values[i] != want[i] {
+			t.Errorf("Evaluate()[%d] = %v, want %v", i, values[i], want[i])
+		}
+	}
+}
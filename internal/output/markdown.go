@@ -0,0 +1,164 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// renderMarkdown writes data as GitHub-flavored markdown. The message becomes
+// a heading, savings and cost fields are collected into a summary, remaining
+// fields form a key/value table and lists of records become a table.
+func renderMarkdown(w io.Writer, data interface{}, opts Options) error {
+	var b strings.Builder
+
+	object, isObject := data.(map[string]interface{})
+	records := Rows(data)
+	if !isObject {
+		if records != nil {
+			if err := writeMarkdownRecords(&b, records, opts); err != nil {
+				return err
+			}
+		} else {
+			fmt.Fprintln(&b, markdownCell(FormatValue(data)))
+		}
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	if message, ok := object["message"].(string); ok {
+		fmt.Fprintf(&b, "## %s\n\n", markdownCell(message))
+	}
+
+	var summary, fields []string
+	for key, value := range object {
+		if key == "message" {
+			continue
+		}
+		if _, ok := value.([]interface{}); ok && records != nil {
+			continue
+		}
+		if isSavingsField(key) {
+			summary = append(summary, key)
+		} else {
+			fields = append(fields, key)
+		}
+	}
+	sort.Strings(summary)
+	sort.Strings(fields)
+
+	if len(summary) > 0 {
+		b.WriteString("### Savings summary\n\n")
+		for _, key := range summary {
+			fmt.Fprintf(&b, "- **%s:** %s\n", markdownLabel(key), markdownCell(FormatValue(object[key])))
+		}
+		b.WriteString("\n")
+	}
+
+	if len(fields) > 0 {
+		rows := make([][]string, len(fields))
+		for i, key := range fields {
+			rows[i] = []string{markdownLabel(key), FormatValue(object[key])}
+		}
+		writeMarkdownTable(&b, []string{"Field", "Value"}, []bool{false, false}, rows)
+		b.WriteString("\n")
+	}
+
+	if records != nil {
+		if err := writeMarkdownRecords(&b, records, opts); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, strings.TrimRight(b.String(), "\n")+"\n")
+	return err
+}
+
+// writeMarkdownRecords writes records as a markdown table. Numeric columns
+// are right-aligned.
+func writeMarkdownRecords(b *strings.Builder, records []map[string]interface{}, opts Options) error {
+	if len(records) == 0 {
+		b.WriteString("_No resources found._\n")
+		return nil
+	}
+
+	columns, err := resolveColumns(records, opts)
+	if err != nil {
+		return err
+	}
+
+	headers := make([]string, len(columns))
+	numeric := make([]bool, len(columns))
+	for i, column := range columns {
+		headers[i] = markdownLabel(column.Name)
+		if column.Header != "" {
+			headers[i] = column.Header
+		}
+		numeric[i] = true
+		for _, record := range records {
+			if value := column.value(record); value != nil {
+				if _, ok := value.(float64); !ok {
+					numeric[i] = false
+					break
+				}
+			}
+		}
+	}
+
+	rows := make([][]string, len(records))
+	for r, record := range records {
+		rows[r] = make([]string, len(columns))
+		for i, column := range columns {
+			rows[r][i] = FormatValue(column.value(record))
+		}
+	}
+	writeMarkdownTable(b, headers, numeric, rows)
+	return nil
+}
+
+// writeMarkdownTable writes a GFM table
+func writeMarkdownTable(b *strings.Builder, headers []string, rightAlign []bool, rows [][]string) {
+	cells := make([]string, len(headers))
+	for i, h := range headers {
+		cells[i] = markdownCell(h)
+	}
+	fmt.Fprintf(b, "| %s |\n", strings.Join(cells, " | "))
+
+	for i := range headers {
+		if rightAlign[i] {
+			cells[i] = "---:"
+		} else {
+			cells[i] = "---"
+		}
+	}
+	fmt.Fprintf(b, "| %s |\n", strings.Join(cells, " | "))
+
+	for _, row := range rows {
+		for i, cell := range row {
+			cells[i] = markdownCell(cell)
+		}
+		fmt.Fprintf(b, "| %s |\n", strings.Join(cells, " | "))
+	}
+}
+
+// markdownCell escapes text so it stays within a single table cell
+func markdownCell(text string) string {
+	return strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>").Replace(text)
+}
+
+// markdownLabel converts a field name to a readable label
+func markdownLabel(name string) string {
+	return titleCase(strings.NewReplacer("_", " ", "-", " ").Replace(name))
+}
+
+// isSavingsField reports whether a field belongs in the savings summary
+func isSavingsField(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range []string{"saving", "cost", "spend"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
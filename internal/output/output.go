@@ -0,0 +1,263 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Supported output formats
+const (
+	FormatTable    = "table"
+	FormatJSON     = "json"
+	FormatYAML     = "yaml"
+	FormatCSV      = "csv"
+	FormatWide     = "wide"
+	FormatMarkdown = "markdown"
+
+	// FormatJSONPath and FormatJSONPathFile take a template after "="
+	FormatJSONPath     = "jsonpath"
+	FormatJSONPathFile = "jsonpath-file"
+
+	// FormatGoTemplate and FormatGoTemplateFile take a template after "="
+	FormatGoTemplate     = "go-template"
+	FormatGoTemplateFile = "go-template-file"
+)
+
+// Options controls how results are rendered
+type Options struct {
+	// Format is one of the Format* constants
+	Format string
+	// Color enables ANSI colors in table output
+	Color bool
+	// Wide shows all columns, including those marked Wide
+	Wide bool
+	// Columns are the registered table columns for the command, if any
+	Columns []Column
+	// Select restricts table and CSV output to these column names
+	Select []string
+	// SortBy orders list results by a column or field; a leading "-" sorts
+	// in descending order
+	SortBy string
+	// Filters keep only records matching every expression, see ParseFilter
+	Filters []string
+	// PageSize limits list results to pages of this many records (0 = all)
+	PageSize int
+	// Page selects the 1-based page to show when PageSize is set
+	Page int
+	// Quiet prints only record identifiers, one per line, ignoring Format
+	Quiet bool
+}
+
+// Render writes data in the requested format. Data is a decoded JSON value
+// as returned by the Python bridge: a map, a list, or a scalar.
+func Render(w io.Writer, data interface{}, opts Options) error {
+	data, err := transformRecords(data, opts)
+	if err != nil {
+		return err
+	}
+	data, page, err := paginate(data, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Quiet {
+		return renderQuiet(w, data)
+	}
+
+	format, arg, _ := strings.Cut(opts.Format, "=")
+
+	switch format {
+	case FormatJSONPath, FormatJSONPathFile:
+		return renderJSONPath(w, data, format, arg)
+	case FormatGoTemplate, FormatGoTemplateFile:
+		return renderTemplate(w, data, format, arg)
+	}
+
+	switch opts.Format {
+	case FormatTable, "", FormatWide:
+		opts.Wide = opts.Wide || opts.Format == FormatWide
+		if err := renderTable(w, data, opts); err != nil {
+			return err
+		}
+		if page != nil {
+			_, err = fmt.Fprintln(w, "\n"+page.footer())
+		}
+		return err
+	case FormatJSON:
+		out, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode JSON output: %v", err)
+		}
+		_, err = fmt.Fprintln(w, string(out))
+		return err
+	case FormatYAML:
+		out, err := yaml.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("failed to encode YAML output: %v", err)
+		}
+		_, err = w.Write(out)
+		return err
+	case FormatCSV:
+		return renderCSV(w, data, opts)
+	case FormatMarkdown, "md":
+		return renderMarkdown(w, data, opts)
+	default:
+		return fmt.Errorf("unsupported output format %q (expected table, wide, json, yaml, csv, markdown, jsonpath=..., jsonpath-file=..., go-template=... or go-template-file=...)", opts.Format)
+	}
+}
+
+// renderJSONPath evaluates a JSONPath template given inline or in a file
+func renderJSONPath(w io.Writer, data interface{}, format, arg string) error {
+	if arg == "" {
+		return fmt.Errorf("%s output requires a template, e.g. -o %s=...", format, format)
+	}
+
+	template := arg
+	if format == FormatJSONPathFile {
+		content, err := os.ReadFile(arg)
+		if err != nil {
+			return fmt.Errorf("failed to read JSONPath template: %v", err)
+		}
+		template = string(content)
+	}
+
+	path, err := ParseJSONPath(template)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if err := path.Execute(&b, data); err != nil {
+		return err
+	}
+	result := b.String()
+	if !strings.HasSuffix(result, "\n") {
+		result += "\n"
+	}
+	_, err = io.WriteString(w, result)
+	return err
+}
+
+// Rows extracts the list of records from a result. A list result is used
+// as-is; for an object the "items" field is preferred, otherwise the only
+// field holding a list of objects. Returns nil if there is no such list.
+func Rows(data interface{}) []map[string]interface{} {
+	list, _ := recordList(data)
+	if list == nil {
+		return nil
+	}
+	return toRecords(list)
+}
+
+// recordList finds the list of records in a result, as described for Rows,
+// and returns a function that rebuilds the result with a replacement list
+func recordList(data interface{}) ([]interface{}, func([]interface{}) interface{}) {
+	switch v := data.(type) {
+	case []interface{}:
+		if toRecords(v) == nil {
+			return nil, nil
+		}
+		return v, func(list []interface{}) interface{} { return list }
+	case map[string]interface{}:
+		key := ""
+		if items, ok := v["items"].([]interface{}); ok && toRecords(items) != nil {
+			key = "items"
+		} else {
+			for k, value := range v {
+				list, ok := value.([]interface{})
+				if !ok || toRecords(list) == nil {
+					continue
+				}
+				if key != "" {
+					// Ambiguous, more than one list of records
+					return nil, nil
+				}
+				key = k
+			}
+		}
+		if key == "" {
+			return nil, nil
+		}
+		return v[key].([]interface{}), func(list []interface{}) interface{} {
+			result := make(map[string]interface{}, len(v))
+			for k, value := range v {
+				result[k] = value
+			}
+			result[key] = list
+			return result
+		}
+	}
+	return nil, nil
+}
+
+// toRecords converts a list to records if every element is an object
+func toRecords(list []interface{}) []map[string]interface{} {
+	records := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		record, ok := item.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// Columns returns the column names of a set of records. Identifying fields
+// come first, the rest are sorted alphabetically.
+func Columns(records []map[string]interface{}) []string {
+	seen := map[string]bool{}
+	for _, record := range records {
+		for key := range record {
+			seen[key] = true
+		}
+	}
+
+	var columns []string
+	for _, key := range []string{"id", "name", "namespace"} {
+		if seen[key] {
+			columns = append(columns, key)
+			delete(seen, key)
+		}
+	}
+
+	rest := make([]string, 0, len(seen))
+	for key := range seen {
+		rest = append(rest, key)
+	}
+	sort.Strings(rest)
+	return append(columns, rest...)
+}
+
+// FormatValue renders a single value for table and CSV cells
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "-"
+	case string:
+		return v
+	case float64:
+		if v == float64(int64(v)) {
+			return fmt.Sprintf("%d", int64(v))
+		}
+		return fmt.Sprintf("%.2f", v)
+	case bool:
+		return fmt.Sprintf("%t", v)
+	default:
+		out, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(out)
+	}
+}
+
+// header converts a field name to a table header
+func header(name string) string {
+	return strings.ToUpper(strings.NewReplacer("_", " ", "-", " ").Replace(name))
+}
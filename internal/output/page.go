@@ -0,0 +1,110 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// pageInfo describes the slice of a list result that was rendered
+type pageInfo struct {
+	page  int
+	pages int
+	first int
+	last  int
+	total int
+}
+
+// footer returns a summary line for paginated table output
+func (p *pageInfo) footer() string {
+	if p.total == 0 {
+		return fmt.Sprintf("Page %d of %d (no items)", p.page, p.pages)
+	}
+	text := fmt.Sprintf("Showing items %d-%d of %d (page %d of %d)", p.first, p.last, p.total, p.page, p.pages)
+	if p.page < p.pages {
+		text += fmt.Sprintf(". Use --page %d for more.", p.page+1)
+	}
+	return text
+}
+
+// paginate keeps only the requested page of the record list in data
+func paginate(data interface{}, opts Options) (interface{}, *pageInfo, error) {
+	if opts.PageSize <= 0 {
+		return data, nil, nil
+	}
+
+	list, replace := recordList(data)
+	if list == nil {
+		return data, nil, nil
+	}
+
+	page := opts.Page
+	if page < 1 {
+		page = 1
+	}
+	pages := (len(list) + opts.PageSize - 1) / opts.PageSize
+	if pages == 0 {
+		pages = 1
+	}
+	if page > pages {
+		return nil, nil, fmt.Errorf("page %d is out of range (%d pages of %d items)", page, pages, opts.PageSize)
+	}
+
+	start := (page - 1) * opts.PageSize
+	end := start + opts.PageSize
+	if end > len(list) {
+		end = len(list)
+	}
+
+	info := &pageInfo{page: page, pages: pages, first: start + 1, last: end, total: len(list)}
+	return replace(list[start:end]), info, nil
+}
+
+// WithPager runs render with its output sent through the user's pager when
+// enabled and stdout is a terminal. The pager is taken from $PAGER and
+// defaults to "less". Otherwise render writes directly to stdout.
+func WithPager(enabled bool, render func(w io.Writer) error) error {
+	if !enabled || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return render(os.Stdout)
+	}
+
+	pager := strings.Fields(os.Getenv("PAGER"))
+	if len(pager) == 0 {
+		pager = []string{"less"}
+	}
+	if _, err := exec.LookPath(pager[0]); err != nil {
+		return render(os.Stdout)
+	}
+
+	cmd := exec.Command(pager[0], pager[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if os.Getenv("LESS") == "" {
+		// Quit if one screen, keep colors, don't clear the screen
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return render(os.Stdout)
+	}
+	if err := cmd.Start(); err != nil {
+		return render(os.Stdout)
+	}
+
+	renderErr := render(stdin)
+	stdin.Close()
+	waitErr := cmd.Wait()
+
+	if renderErr != nil {
+		return renderErr
+	}
+	if waitErr != nil {
+		return fmt.Errorf("pager %s failed: %v", pager[0], waitErr)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Layout of PDF pages, in points: A4 with 9 point Courier
+const (
+	pdfWidth    = 595
+	pdfHeight   = 842
+	pdfMargin   = 40
+	pdfFontSize = 9
+	pdfLeading  = 11
+)
+
+// pdfLinesPerPage is the number of lines of text that fit on a page
+const pdfLinesPerPage = (pdfHeight - 2*pdfMargin) / pdfLeading
+
+// WritePDF writes a PDF document of monospaced text. Each document is the
+// lines of one or more pages: it starts on a new page and continues on the
+// next when it does not fit. Characters outside Latin-1 are written as '?'.
+func WritePDF(w io.Writer, documents [][]string) error {
+	var pages [][]string
+	for _, lines := range documents {
+		if len(lines) == 0 {
+			lines = []string{""}
+		}
+		for len(lines) > 0 {
+			n := min(len(lines), pdfLinesPerPage)
+			pages = append(pages, lines[:n])
+			lines = lines[n:]
+		}
+	}
+	if len(pages) == 0 {
+		pages = [][]string{{""}}
+	}
+
+	f := &pdfFile{}
+	catalog := f.reserve()
+	tree := f.reserve()
+	font := f.add("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	kids := make([]string, len(pages))
+	for i, lines := range pages {
+		var content strings.Builder
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", pdfFontSize, pdfLeading, pdfMargin, pdfHeight-pdfMargin-pdfFontSize)
+		for _, line := range lines {
+			fmt.Fprintf(&content, "(%s) Tj T*\n", pdfString(line))
+		}
+		content.WriteString("ET")
+		contents := f.add(pdfStream("", content.String()))
+		kids[i] = fmt.Sprintf("%d 0 R", f.add(fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 %d 0 R >> >> /Contents %d 0 R >>",
+			tree, pdfWidth, pdfHeight, font, contents)))
+	}
+	f.set(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", tree))
+	f.set(tree, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	return f.write(w, catalog, 0)
+}
+
+// pdfFile assembles the numbered objects of a PDF file
+type pdfFile struct {
+	objects []string
+}
+
+// add adds an object to the file and returns its number
+func (f *pdfFile) add(body string) int {
+	f.objects = append(f.objects, body)
+	return len(f.objects)
+}
+
+// reserve numbers an object whose body is set later, for objects that
+// refer to each other
+func (f *pdfFile) reserve() int {
+	return f.add("")
+}
+
+// set sets the body of a reserved object
+func (f *pdfFile) set(n int, body string) {
+	f.objects[n-1] = body
+}
+
+// write writes the file with its catalog, and its information dictionary
+// unless 0
+func (f *pdfFile) write(w io.Writer, catalog, info int) error {
+	var b bytes.Buffer
+	// The comment of binary characters marks the file as binary
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(f.objects))
+	for i, body := range f.objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	trailer := fmt.Sprintf("/Size %d /Root %d 0 R", len(offsets)+1, catalog)
+	if info != 0 {
+		trailer += fmt.Sprintf(" /Info %d 0 R", info)
+	}
+	fmt.Fprintf(&b, "trailer\n<< %s >>\nstartxref\n%d\n%%%%EOF\n", trailer, xref)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// pdfStream returns the body of a stream object with the entries of its
+// dictionary
+func pdfStream(dict, data string) string {
+	return fmt.Sprintf("<< %s/Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+// pdfString escapes text for a PDF string literal, writing Latin-1
+// characters as octal escapes so that the document stays ASCII
+func pdfString(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteString("    ")
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,540 @@
+package output
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layout of PDF reports, in points: A4 pages with a header and a footer
+const (
+	reportTop    = pdfHeight - pdfMargin - 40 // top of the content, under the header
+	reportBottom = pdfMargin + 14             // bottom of the content, above the footer
+	reportWidth  = pdfWidth - 2*pdfMargin
+	reportLogo   = 22 // height of the logo in the header
+	reportCell   = 7.5
+	reportRow    = 13
+)
+
+// Fonts of PDF reports, the standard Type 1 fonts that need no embedding
+const (
+	fontRegular  = "F1"
+	fontBold     = "F2"
+	fontMono     = "F3"
+	fontMonoBold = "F4"
+)
+
+// Colors of PDF reports, as in the HTML template
+const (
+	inkColor     = "#1f2933"
+	textColor    = "#3e4c59"
+	mutedColor   = "#616e7c"
+	ruleColor    = "#e4e7eb"
+	shadeColor   = "#f5f6f8"
+	defaultBrand = "#3b6fd8"
+)
+
+// severityColors are the colors of the cells of a severity class
+var severityColors = map[string]string{"critical": "#c81e1e", "warning": "#b7791f", "info": "#2f855a"}
+
+// helveticaWidths are the widths of the printable ASCII characters of
+// Helvetica, in thousandths of the font size
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// pdfReport lays out a report on the pages of a PDF document
+type pdfReport struct {
+	brand string
+	pages []*strings.Builder
+	y     float64
+	marks []pdfMark
+	links []pdfLink
+}
+
+// pdfMark is a section of a report, for its table of contents and the
+// outline of the document
+type pdfMark struct {
+	title string
+	page  int
+	y     float64
+}
+
+// pdfLink is an area of a page that links to a section
+type pdfLink struct {
+	page           int
+	x1, y1, x2, y2 float64
+	mark           int
+}
+
+// WritePDFReport writes a report as a PDF document: the title, the figures
+// and the table of contents of the report on the first page, then its
+// sections, with its brand and title on top of each page and its footer
+// and page number at the bottom. Charts and tables are drawn as vector
+// graphics, with the standard fonts, so that the document needs no
+// external renderer. Characters outside Latin-1 are written as '?'.
+func WritePDFReport(w io.Writer, report HTMLReport) error {
+	r := &pdfReport{brand: defaultBrand}
+	if report.Brand.Color != "" {
+		r.brand = report.Brand.Color
+	}
+	r.newPage()
+
+	for _, line := range wrapText(fontBold, 20, reportWidth, report.Title) {
+		r.y -= 24
+		pdfText(r.page(), fontBold, 20, pdfMargin, r.y, inkColor, line)
+	}
+	r.y -= 4
+	for _, line := range wrapText(fontRegular, 9.5, reportWidth, report.Subtitle) {
+		r.y -= 13
+		pdfText(r.page(), fontRegular, 9.5, pdfMargin, r.y, mutedColor, line)
+	}
+	r.y -= 16
+	r.figures(report.Figures)
+
+	// The table of contents is drawn once the sections are laid out
+	contentsPage, contentsY := 0, r.y
+	if len(report.Sections) > 0 {
+		height := 34 + 16*float64(len(report.Sections))
+		r.need(height)
+		contentsPage, contentsY = len(r.pages)-1, r.y
+		r.y -= height
+	}
+	for _, s := range report.Sections {
+		r.section(s)
+	}
+	if len(report.Sections) > 0 {
+		r.contents(contentsPage, contentsY)
+	}
+
+	var logo []byte
+	var logoWidth, logoHeight int
+	if len(report.Brand.Logo) > 0 {
+		var err error
+		if logoWidth, logoHeight, logo, err = pdfImage(report.Brand.Logo); err != nil {
+			return err
+		}
+	}
+
+	f := &pdfFile{}
+	catalog := f.reserve()
+	tree := f.reserve()
+	outlines := f.reserve()
+	fonts := make([]string, 0, 4)
+	for i, name := range []string{"Helvetica", "Helvetica-Bold", "Courier", "Courier-Bold"} {
+		font := f.add(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+		fonts = append(fonts, fmt.Sprintf("/F%d %d 0 R", i+1, font))
+	}
+	resources := fmt.Sprintf("/Font << %s >>", strings.Join(fonts, " "))
+	if logo != nil {
+		xobject := f.add(pdfStream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode ",
+			logoWidth, logoHeight), string(logo)))
+		resources += fmt.Sprintf(" /XObject << /Im1 %d 0 R >>", xobject)
+	}
+	pages := make([]int, len(r.pages))
+	for i := range pages {
+		pages[i] = f.reserve()
+	}
+	dest := func(m pdfMark) string {
+		return fmt.Sprintf("[%d 0 R /XYZ 0 %s 0]", pages[m.page], pdfNumber(m.y))
+	}
+
+	kids := make([]string, len(r.pages))
+	for i, page := range r.pages {
+		var content strings.Builder
+		r.header(&content, report, logoWidth, logoHeight, logo != nil)
+		pdfText(&content, fontRegular, 7.5, pdfMargin, pdfMargin-12, mutedColor, report.Footer)
+		number := fmt.Sprintf("Page %d of %d", i+1, len(r.pages))
+		pdfText(&content, fontRegular, 7.5, pdfMargin+reportWidth-textWidth(fontRegular, 7.5, number), pdfMargin-12, mutedColor, number)
+		content.WriteString(page.String())
+		contents := f.add(pdfStream("", content.String()))
+
+		var annots []string
+		for _, l := range r.links {
+			if l.page == i {
+				annots = append(annots, fmt.Sprintf("%d 0 R", f.add(fmt.Sprintf("<< /Type /Annot /Subtype /Link /Rect [%s %s %s %s] /Border [0 0 0] /Dest %s >>",
+					pdfNumber(l.x1), pdfNumber(l.y1), pdfNumber(l.x2), pdfNumber(l.y2), dest(r.marks[l.mark])))))
+			}
+		}
+		dict := fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << %s >> /Contents %d 0 R",
+			tree, pdfWidth, pdfHeight, resources, contents)
+		if annots != nil {
+			dict += fmt.Sprintf(" /Annots [%s]", strings.Join(annots, " "))
+		}
+		f.set(pages[i], dict+" >>")
+		kids[i] = fmt.Sprintf("%d 0 R", pages[i])
+	}
+
+	// The outline lists the sections in the bookmarks of viewers
+	items := make([]int, len(r.marks))
+	for i := range items {
+		items[i] = f.reserve()
+	}
+	for i, m := range r.marks {
+		dict := fmt.Sprintf("<< /Title (%s) /Parent %d 0 R /Dest %s", pdfString(m.title), outlines, dest(m))
+		if i > 0 {
+			dict += fmt.Sprintf(" /Prev %d 0 R", items[i-1])
+		}
+		if i < len(items)-1 {
+			dict += fmt.Sprintf(" /Next %d 0 R", items[i+1])
+		}
+		f.set(items[i], dict+" >>")
+	}
+	if len(items) > 0 {
+		f.set(outlines, fmt.Sprintf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>", items[0], items[len(items)-1], len(items)))
+	} else {
+		f.set(outlines, "<< /Type /Outlines /Count 0 >>")
+	}
+
+	f.set(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R /Outlines %d 0 R /PageMode /UseOutlines >>", tree, outlines))
+	f.set(tree, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+	info := fmt.Sprintf("<< /Title (%s) /Producer (UPID) /CreationDate (D:%s) ", pdfString(report.Title), time.Now().UTC().Format("20060102150405Z"))
+	if report.Brand.Name != "" {
+		info += fmt.Sprintf("/Author (%s) ", pdfString(report.Brand.Name))
+	}
+	return f.write(w, catalog, f.add(info+">>"))
+}
+
+// page returns the content of the current page
+func (r *pdfReport) page() *strings.Builder {
+	return r.pages[len(r.pages)-1]
+}
+
+// newPage starts a new page at the top of its content
+func (r *pdfReport) newPage() {
+	r.pages = append(r.pages, &strings.Builder{})
+	r.y = reportTop
+}
+
+// need starts a new page unless height fits on the current one
+func (r *pdfReport) need(height float64) {
+	if r.y-height < reportBottom {
+		r.newPage()
+	}
+}
+
+// header draws the logo and the name of the brand of a report on top of a
+// page, with the title of the report on the right, over a rule
+func (r *pdfReport) header(b *strings.Builder, report HTMLReport, logoWidth, logoHeight int, logo bool) {
+	top := float64(pdfHeight - pdfMargin)
+	x := float64(pdfMargin)
+	if logo {
+		width := float64(reportLogo*logoWidth) / float64(logoHeight)
+		fmt.Fprintf(b, "q %s 0 0 %d %s %s cm /Im1 Do Q\n", pdfNumber(width), reportLogo, pdfNumber(x), pdfNumber(top-reportLogo))
+		x += width + 8
+	}
+	if report.Brand.Name != "" {
+		pdfText(b, fontBold, 11, x, top-15, inkColor, report.Brand.Name)
+		x += textWidth(fontBold, 11, report.Brand.Name) + 16
+	}
+	title := fitText(fontRegular, 8, pdfMargin+reportWidth-x, report.Title)
+	pdfText(b, fontRegular, 8, pdfMargin+reportWidth-textWidth(fontRegular, 8, title), top-15, mutedColor, title)
+	pdfLine(b, pdfMargin, top-reportLogo-5, pdfMargin+reportWidth, top-reportLogo-5, 1.5, r.brand)
+}
+
+// figures draws the headline figures of a report in boxes, four a row
+func (r *pdfReport) figures(figures []HTMLFigure) {
+	const gap, height = 8.0, 60.0
+	if len(figures) == 0 {
+		return
+	}
+	perRow := min(4, len(figures))
+	width := (reportWidth - gap*float64(perRow-1)) / float64(perRow)
+	for i := 0; i < len(figures); i += perRow {
+		r.need(height)
+		top := r.y
+		for j, figure := range figures[i:min(i+perRow, len(figures))] {
+			x := pdfMargin + float64(j)*(width+gap)
+			pdfRect(r.page(), x, top-height, width, height, shadeColor)
+			pdfText(r.page(), fontRegular, 7, x+8, top-14, mutedColor, fitText(fontRegular, 7, width-16, strings.ToUpper(figure.Label)))
+			pdfText(r.page(), fontBold, 15, x+8, top-33, inkColor, fitText(fontBold, 15, width-16, figure.Value))
+			for k, line := range wrapText(fontRegular, 7, width-16, figure.Note) {
+				if k == 2 {
+					break
+				}
+				pdfText(r.page(), fontRegular, 7, x+8, top-45-9*float64(k), mutedColor, line)
+			}
+		}
+		r.y = top - height - gap
+	}
+	r.y -= 8
+}
+
+// contents draws the table of contents at a position of a page, each
+// section with its page and a link to it
+func (r *pdfReport) contents(page int, y float64) {
+	b := r.pages[page]
+	pdfText(b, fontBold, 13, pdfMargin, y-16, inkColor, "Contents")
+	for i, m := range r.marks {
+		line := y - 34 - 16*float64(i)
+		title := fitText(fontRegular, 10, reportWidth-60, m.title)
+		number := strconv.Itoa(m.page + 1)
+		end := pdfMargin + reportWidth - textWidth(fontRegular, 10, number)
+		pdfText(b, fontRegular, 10, pdfMargin, line, inkColor, title)
+		pdfText(b, fontRegular, 10, end, line, inkColor, number)
+		fmt.Fprintf(b, "q [1 2] 0 d %s\n", pdfStroke(0.5, ruleColor))
+		fmt.Fprintf(b, "%s %s m %s %s l S Q\n", pdfNumber(pdfMargin+textWidth(fontRegular, 10, title)+6), pdfNumber(line), pdfNumber(end-6), pdfNumber(line))
+		r.links = append(r.links, pdfLink{page: page, x1: pdfMargin, y1: line - 4, x2: pdfMargin + reportWidth, y2: line + 11, mark: i})
+	}
+}
+
+// section draws a section of a report, starting it on a new page unless
+// its title and the start of its content fit on the current one
+func (r *pdfReport) section(s HTMLSection) {
+	r.need(80)
+	r.marks = append(r.marks, pdfMark{title: s.Title, page: len(r.pages) - 1, y: r.y})
+	r.y -= 18
+	pdfText(r.page(), fontBold, 14, pdfMargin, r.y, r.brand, s.Title)
+	r.y -= 6
+	for _, line := range wrapText(fontRegular, 9.5, reportWidth, s.Text) {
+		r.need(13)
+		r.y -= 13
+		pdfText(r.page(), fontRegular, 9.5, pdfMargin, r.y, textColor, line)
+	}
+	r.y -= 10
+	if s.Chart != nil {
+		r.chart(s.Chart)
+	}
+	if s.Table != nil {
+		r.table(s.Table)
+	}
+	r.y -= 18
+}
+
+// chart draws a bar chart, laid out as in HTML and scaled to the width of
+// the page
+func (r *pdfReport) chart(c *BarChart) {
+	l := c.layout()
+	scale := reportWidth / float64(l.Width)
+	row, bar := chartRow*scale, chartBar*scale
+	if len(l.Legend) > 0 {
+		r.need(chartLegend * scale)
+		for _, swatch := range l.Legend {
+			x := pdfMargin + float64(swatch.X)*scale
+			pdfRect(r.page(), x, r.y-9, 8, 8, swatch.Color)
+			pdfText(r.page(), fontRegular, 7.5, x+11, r.y-8, textColor, swatch.Name)
+		}
+		r.y -= chartLegend * scale
+	}
+	labelWidth := float64(l.LabelX) * scale
+	for _, b := range l.Bars {
+		r.need(row)
+		label := fitText(fontRegular, 7.5, labelWidth, b.Label)
+		pdfText(r.page(), fontRegular, 7.5, pdfMargin+labelWidth-textWidth(fontRegular, 7.5, label), r.y-row/2-2.5, textColor, label)
+		for _, segment := range b.Segments {
+			pdfRect(r.page(), pdfMargin+segment.X*scale, r.y-(row+bar)/2, segment.Width*scale, bar, segment.Color)
+		}
+		pdfText(r.page(), fontRegular, 7.5, pdfMargin+float64(l.TextX)*scale, r.y-row/2-2.5, textColor, b.Text)
+		r.y -= row
+	}
+	r.y -= 10
+}
+
+// table draws a table in a monospaced font, its columns as wide as their
+// widest cell, cut to fit the page. Its header is repeated on each page.
+func (r *pdfReport) table(t *HTMLTable) {
+	const gap = 2
+	widths := make([]int, len(t.Columns))
+	for i, c := range t.Columns {
+		widths[i] = len([]rune(c))
+	}
+	for _, row := range t.Rows {
+		for i, cell := range row {
+			if i < len(widths) {
+				widths[i] = max(widths[i], len([]rune(cell)))
+			}
+		}
+	}
+	fits := int(math.Floor((reportWidth - 4) / (0.6 * reportCell)))
+	for {
+		total, widest := gap*(len(widths)-1), 0
+		for i, width := range widths {
+			total += width
+			if width > widths[widest] {
+				widest = i
+			}
+		}
+		if total <= fits || widths[widest] <= 4 {
+			break
+		}
+		widths[widest]--
+	}
+	cell := func(text string, column int) string {
+		runes := []rune(text)
+		if len(runes) > widths[column] {
+			runes = append(runes[:widths[column]-1], '~')
+		}
+		padding := strings.Repeat(" ", widths[column]-len(runes))
+		if column < len(t.Numeric) && t.Numeric[column] {
+			return padding + string(runes)
+		}
+		return string(runes) + padding
+	}
+	header := func() {
+		r.need(2 * reportRow)
+		pdfRect(r.page(), pdfMargin, r.y-reportRow, reportWidth, reportRow, shadeColor)
+		x := pdfMargin + 2.0
+		for i, c := range t.Columns {
+			pdfText(r.page(), fontMonoBold, reportCell, x, r.y-9.5, mutedColor, cell(strings.ToUpper(c), i))
+			x += float64(widths[i]+gap) * 0.6 * reportCell
+		}
+		r.y -= reportRow
+	}
+
+	header()
+	for _, row := range t.Rows {
+		if r.y-reportRow < reportBottom {
+			r.newPage()
+			header()
+		}
+		x := pdfMargin + 2.0
+		for i, text := range row {
+			if i >= len(widths) {
+				break
+			}
+			color := inkColor
+			if i >= len(t.Numeric) || !t.Numeric[i] {
+				if severity, ok := severityColors[severityClass(text)]; ok {
+					color = severity
+				}
+			}
+			pdfText(r.page(), fontMono, reportCell, x, r.y-9.5, color, cell(text, i))
+			x += float64(widths[i]+gap) * 0.6 * reportCell
+		}
+		pdfLine(r.page(), pdfMargin, r.y-reportRow, pdfMargin+reportWidth, r.y-reportRow, 0.5, ruleColor)
+		r.y -= reportRow
+	}
+	r.y -= 6
+}
+
+// pdfText draws text with its baseline at a position
+func pdfText(b *strings.Builder, font string, size, x, y float64, color, text string) {
+	if text == "" {
+		return
+	}
+	fmt.Fprintf(b, "BT %s rg /%s %s Tf %s %s Td (%s) Tj ET\n", pdfColor(color), font, pdfNumber(size), pdfNumber(x), pdfNumber(y), pdfString(text))
+}
+
+// pdfRect fills a rectangle from its bottom left corner
+func pdfRect(b *strings.Builder, x, y, width, height float64, color string) {
+	fmt.Fprintf(b, "%s rg %s %s %s %s re f\n", pdfColor(color), pdfNumber(x), pdfNumber(y), pdfNumber(width), pdfNumber(height))
+}
+
+// pdfLine draws a line
+func pdfLine(b *strings.Builder, x1, y1, x2, y2, width float64, color string) {
+	fmt.Fprintf(b, "%s %s %s m %s %s l S\n", pdfStroke(width, color), pdfNumber(x1), pdfNumber(y1), pdfNumber(x2), pdfNumber(y2))
+}
+
+// pdfStroke sets the width and color of lines
+func pdfStroke(width float64, color string) string {
+	return fmt.Sprintf("%s w %s RG", pdfNumber(width), pdfColor(color))
+}
+
+// pdfColor returns the RGB components of a hex color such as #3b6fd8,
+// black if it is not one
+func pdfColor(hex string) string {
+	value, err := strconv.ParseUint(strings.TrimPrefix(hex, "#"), 16, 32)
+	if err != nil || len(hex) != 7 {
+		return "0 0 0"
+	}
+	return fmt.Sprintf("%.3f %.3f %.3f", float64(value>>16&0xff)/255, float64(value>>8&0xff)/255, float64(value&0xff)/255)
+}
+
+// pdfNumber formats a coordinate with at most two decimals
+func pdfNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+// textWidth returns the width of text in a font, in points. Bold Helvetica
+// is taken as 6% wider than regular, which is close enough for layout.
+func textWidth(font string, size float64, text string) float64 {
+	if font == fontMono || font == fontMonoBold {
+		return 0.6 * size * float64(len([]rune(text)))
+	}
+	width := 0
+	for _, r := range text {
+		if r >= ' ' && r <= '~' {
+			width += helveticaWidths[r-' ']
+		} else {
+			width += 556
+		}
+	}
+	if font == fontBold {
+		width = width * 106 / 100
+	}
+	return float64(width) * size / 1000
+}
+
+// wrapText breaks text into lines that fit a width, between words
+func wrapText(font string, size, width float64, text string) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && textWidth(font, size, line+" "+word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// fitText shortens text to fit a width, marking the cut with "..."
+func fitText(font string, size, width float64, text string) string {
+	if textWidth(font, size, text) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && textWidth(font, size, string(runes)+"...") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// pdfImage decodes a PNG or JPEG image into compressed RGB samples,
+// blending transparency with white
+func pdfImage(data []byte) (int, int, []byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("invalid logo: %v (expected a PNG or JPEG image)", err)
+	}
+	bounds := img.Bounds()
+	var b bytes.Buffer
+	z := zlib.NewWriter(&b)
+	row := make([]byte, 0, 3*bounds.Dx())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row = row[:0]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			row = append(row, blendWhite(c.R, c.A), blendWhite(c.G, c.A), blendWhite(c.B, c.A))
+		}
+		z.Write(row)
+	}
+	if err := z.Close(); err != nil {
+		return 0, 0, nil, err
+	}
+	return bounds.Dx(), bounds.Dy(), b.Bytes(), nil
+}
+
+// blendWhite blends a color component of a given opacity with white
+func blendWhite(v, alpha uint8) byte {
+	return byte((int(v)*int(alpha) + 255*(255-int(alpha))) / 255)
+}
@@ -0,0 +1,45 @@
+package output
+
+import (
+	"fmt"
+	"io"
+)
+
+// identifierFields are the record fields tried, in order, as the identifier
+// printed in quiet mode
+var identifierFields = []string{"id", "recommendation_id", "cluster_id", "name", "pod"}
+
+// renderQuiet writes only the identifiers of list results, one per line, so
+// output can be piped into other commands. A result without records is
+// printed as a single record. The identifier of a record is the first of its
+// "id", "recommendation_id", "cluster_id", "name" and "pod" fields that is
+// set; records with none of them print nothing.
+func renderQuiet(w io.Writer, data interface{}) error {
+	records := Rows(data)
+	if records == nil {
+		if object, ok := data.(map[string]interface{}); ok {
+			records = []map[string]interface{}{object}
+		}
+	}
+
+	for _, record := range records {
+		id, ok := identifier(record)
+		if !ok {
+			continue
+		}
+		if _, err := fmt.Fprintln(w, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// identifier returns the identifying value of a record
+func identifier(record map[string]interface{}) (string, bool) {
+	for _, field := range identifierFields {
+		if value, ok := record[field]; ok && value != nil {
+			return FormatValue(value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,115 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// columnPadding is the space between table columns
+const columnPadding = 3
+
+// renderTable writes data as an aligned table. Lists of records become one
+// row per record; other objects print their message followed by a
+// key/value table of the remaining fields.
+func renderTable(w io.Writer, data interface{}, opts Options) error {
+	if records := Rows(data); records != nil {
+		return writeRecords(w, records, opts)
+	}
+
+	object, ok := data.(map[string]interface{})
+	if !ok {
+		_, err := fmt.Fprintln(w, FormatValue(data))
+		return err
+	}
+
+	if message, ok := object["message"].(string); ok {
+		fmt.Fprintln(w, message)
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		if key != "message" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+
+	rows := make([][]string, len(keys))
+	colors := make([][]string, len(keys))
+	for i, key := range keys {
+		value := FormatValue(object[key])
+		rows[i] = []string{header(key) + ":", value}
+		colors[i] = []string{"", cellColor(key, value)}
+	}
+	return writeAligned(w, rows, colors, opts.Color)
+}
+
+// writeRecords writes records as a table with a header row
+func writeRecords(w io.Writer, records []map[string]interface{}, opts Options) error {
+	if len(records) == 0 {
+		_, err := fmt.Fprintln(w, "No resources found.")
+		return err
+	}
+
+	columns, err := resolveColumns(records, opts)
+	if err != nil {
+		return err
+	}
+	headers := make([]string, len(columns))
+	for i, column := range columns {
+		headers[i] = column.title()
+	}
+
+	rows := [][]string{headers}
+	colors := [][]string{make([]string, len(columns))}
+	for _, record := range records {
+		cells := make([]string, len(columns))
+		cellColors := make([]string, len(columns))
+		for i, column := range columns {
+			cells[i] = FormatValue(column.value(record))
+			cellColors[i] = cellColor(column.Name, cells[i])
+		}
+		rows = append(rows, cells)
+		colors = append(colors, cellColors)
+	}
+	return writeAligned(w, rows, colors, opts.Color)
+}
+
+// writeAligned writes rows with columns padded to a common width. Padding is
+// computed on the plain text so color codes do not break alignment.
+func writeAligned(w io.Writer, rows [][]string, colors [][]string, color bool) error {
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := len([]rune(cell)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	for r, row := range rows {
+		var line strings.Builder
+		for i, cell := range row {
+			text := cell
+			if color {
+				text = Colorize(cell, colors[r][i])
+			}
+			line.WriteString(text)
+			if i < len(row)-1 {
+				line.WriteString(strings.Repeat(" ", widths[i]-len([]rune(cell))+columnPadding))
+			}
+		}
+		if _, err := fmt.Fprintln(w, line.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// renderTemplate executes a Go template given inline or in a file
+func renderTemplate(w io.Writer, data interface{}, format, arg string) error {
+	if arg == "" {
+		return fmt.Errorf("%s output requires a template, e.g. -o %s=...", format, format)
+	}
+
+	text := arg
+	if format == FormatGoTemplateFile {
+		content, err := os.ReadFile(arg)
+		if err != nil {
+			return fmt.Errorf("failed to read template: %v", err)
+		}
+		text = string(content)
+	}
+
+	tmpl, err := template.New("output").Funcs(TemplateFuncs()).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %v", err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return fmt.Errorf("failed to execute template: %v", err)
+	}
+	result := b.String()
+	if !strings.HasSuffix(result, "\n") {
+		result += "\n"
+	}
+	_, err = io.WriteString(w, result)
+	return err
+}
+
+// TemplateFuncs returns the helper functions available to output templates
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		// Strings
+		"upper":     strings.ToUpper,
+		"lower":     strings.ToLower,
+		"title":     titleCase,
+		"trim":      strings.TrimSpace,
+		"contains":  func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix": func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"replace":   func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"repeat":    func(n int, s string) string { return strings.Repeat(s, n) },
+		"indent":    indent,
+		"join":      join,
+		"default":   defaultValue,
+
+		// Numbers
+		"add":     func(a, b interface{}) float64 { return toFloat(a) + toFloat(b) },
+		"sub":     func(a, b interface{}) float64 { return toFloat(a) - toFloat(b) },
+		"mul":     func(a, b interface{}) float64 { return toFloat(a) * toFloat(b) },
+		"div":     divide,
+		"round":   round,
+		"percent": func(v interface{}) string { return fmt.Sprintf("%.1f%%", toFloat(v)*100) },
+
+		// Units
+		"bytes":        formatBytes,
+		"currency":     func(v interface{}) string { return formatCurrency("$", v) },
+		"currencyWith": formatCurrency,
+		"duration":     formatDuration,
+
+		// Encoding
+		"toJson":   toJSON,
+		"toYaml":   toYAML,
+		"value":    FormatValue,
+		"datetime": formatTime,
+	}
+}
+
+// toFloat converts a template value to a number, returning 0 if it is not one
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case float32:
+		return float64(n)
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case json.Number:
+		f, _ := n.Float64()
+		return f
+	case string:
+		var f float64
+		fmt.Sscanf(n, "%g", &f)
+		return f
+	}
+	return 0
+}
+
+func divide(a, b interface{}) float64 {
+	d := toFloat(b)
+	if d == 0 {
+		return 0
+	}
+	return toFloat(a) / d
+}
+
+// round rounds v to the given number of decimal places
+func round(places int, v interface{}) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(toFloat(v)*p) / p
+}
+
+// formatBytes renders a byte count with binary units, e.g. 1.5 GiB
+func formatBytes(v interface{}) string {
+	b := toFloat(v)
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	i := 0
+	for math.Abs(b) >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", b, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", b, units[i])
+}
+
+// formatCurrency renders an amount with two decimals and thousands separators
+func formatCurrency(symbol string, v interface{}) string {
+	amount := toFloat(v)
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	whole := fmt.Sprintf("%.2f", amount)
+	intPart, frac := whole[:len(whole)-3], whole[len(whole)-3:]
+
+	var b strings.Builder
+	for i, digit := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	return sign + symbol + b.String() + frac
+}
+
+// formatDuration renders seconds or a duration string in a compact form
+func formatDuration(v interface{}) string {
+	var d time.Duration
+	if s, ok := v.(string); ok {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return s
+		}
+		d = parsed
+	} else {
+		d = time.Duration(toFloat(v) * float64(time.Second))
+	}
+
+	d = d.Round(time.Second)
+	days := d / (24 * time.Hour)
+	if days > 0 {
+		rest := d - days*24*time.Hour
+		if rest == 0 {
+			return fmt.Sprintf("%dd", days)
+		}
+		return fmt.Sprintf("%dd%s", days, strings.TrimSuffix(rest.String(), "0m0s"))
+	}
+	return d.String()
+}
+
+// formatTime reformats an RFC 3339 timestamp using a Go layout
+func formatTime(layout string, v interface{}) string {
+	s := fmt.Sprintf("%v", v)
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return s
+	}
+	return t.Format(layout)
+}
+
+func titleCase(s string) string {
+	words := strings.Fields(s)
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+func join(sep string, v interface{}) string {
+	list, ok := v.([]interface{})
+	if !ok {
+		return FormatValue(v)
+	}
+	parts := make([]string, len(list))
+	for i, item := range list {
+		parts[i] = FormatValue(item)
+	}
+	return strings.Join(parts, sep)
+}
+
+// defaultValue returns fallback when v is nil or empty
+func defaultValue(fallback, v interface{}) interface{} {
+	if v == nil || v == "" {
+		return fallback
+	}
+	return v
+}
+
+func toJSON(v interface{}) string {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+func toYAML(v interface{}) string {
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
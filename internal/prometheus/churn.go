@@ -0,0 +1,55 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Churn is the restarts and evictions of pods over a time range, as
+// reported by kube-state-metrics
+type Churn struct {
+	// Restarts and OOMKills are keyed by "namespace/pod/container". OOM
+	// kills are the restarts of containers whose last termination was an
+	// OOM kill.
+	Restarts map[string]float64 `json:"restarts"`
+	OOMKills map[string]float64 `json:"oom_kills"`
+	// Evicted lists the pods evicted in the time range as "namespace/pod"
+	Evicted []string `json:"evicted"`
+}
+
+// ChurnHistory returns the restarts, OOM kills and evictions of the pods in
+// namespace (all if empty) over the window up to now
+func (c *Client) ChurnHistory(ctx context.Context, namespace string, window time.Duration) (*Churn, error) {
+	selector := `pod!=""`
+	if namespace != "" {
+		selector += fmt.Sprintf(",namespace=%q", namespace)
+	}
+	rangeSel := fmt.Sprintf("[%ds]", int(window.Seconds()))
+	restarts := fmt.Sprintf("sum by (namespace, pod, container) (increase(kube_pod_container_status_restarts_total{%s}%s))", selector, rangeSel)
+	queries := map[string]string{
+		"restarts": restarts + " > 0",
+		"oom": fmt.Sprintf(`(%s > 0) and on (namespace, pod, container) (max by (namespace, pod, container) (kube_pod_container_status_last_terminated_reason{%s,reason="OOMKilled"}) == 1)`,
+			restarts, selector),
+		"evicted": fmt.Sprintf(`max by (namespace, pod) (max_over_time(kube_pod_status_reason{%s,reason="Evicted"}%s)) == 1`, selector, rangeSel),
+	}
+	results, err := c.queryAll(ctx, queries, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	churn := &Churn{Restarts: map[string]float64{}, OOMKills: map[string]float64{}}
+	key := func(labels map[string]string) string {
+		return labels["namespace"] + "/" + labels["pod"] + "/" + labels["container"]
+	}
+	for _, s := range results["restarts"] {
+		churn.Restarts[key(s.Labels)] = lastValue(s)
+	}
+	for _, s := range results["oom"] {
+		churn.OOMKills[key(s.Labels)] = lastValue(s)
+	}
+	for _, s := range results["evicted"] {
+		churn.Evicted = append(churn.Evicted, s.Labels["namespace"]+"/"+s.Labels["pod"])
+	}
+	return churn, nil
+}
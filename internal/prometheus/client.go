@@ -0,0 +1,236 @@
+// Package prometheus queries a Prometheus compatible datasource, such as
+// Prometheus, Thanos or Mimir, for historical usage series of pods.
+package prometheus
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+)
+
+// Options configure the connection to a datasource
+type Options struct {
+	// URL is the base URL the /api/v1 endpoints are served under, e.g.
+	// https://prometheus.example.com or https://mimir.example.com/prometheus
+	URL string
+	// Token is sent as a bearer token if set
+	Token string
+	// Username and Password are sent with basic authentication if set
+	Username string
+	Password string
+	// Tenant is sent as X-Scope-OrgID, as required by Mimir and multi-tenant
+	// Thanos setups
+	Tenant string
+	// CAFile verifies the server with a private certificate authority
+	CAFile string
+	// CertFile and KeyFile are a client certificate for mutual TLS
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables verification of the server certificate
+	InsecureSkipVerify bool
+	// Timeout limits each query, 30s if 0
+	Timeout time.Duration
+}
+
+// Client queries a datasource
+type Client struct {
+	opts Options
+	base *url.URL
+	http *http.Client
+}
+
+// New creates a client for a datasource
+func New(opts Options) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(opts.URL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, clierr.New(clierr.CategoryUsage, "DATASOURCE_INVALID", fmt.Sprintf("invalid datasource URL %q", opts.URL)).
+			WithHint("Use the base URL of the datasource, e.g. https://prometheus.example.com")
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, clierr.Wrap(err, clierr.CategoryUsage, "DATASOURCE_INVALID", "failed to read datasource CA file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, clierr.New(clierr.CategoryUsage, "DATASOURCE_INVALID", fmt.Sprintf("no certificates in %s", opts.CAFile))
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, clierr.Wrap(err, clierr.CategoryUsage, "DATASOURCE_INVALID", "failed to load datasource client certificate").
+				WithHint("Set both the certificate and the key file")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &Client{opts: opts, base: base, http: &http.Client{Transport: transport, Timeout: opts.Timeout}}, nil
+}
+
+// URL returns the base URL of the datasource
+func (c *Client) URL() string {
+	return c.base.String()
+}
+
+// Sample is a value of a series at a point in time
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series is the samples of one set of labels
+type Series struct {
+	Labels  map[string]string
+	Samples []Sample
+}
+
+// response is the envelope of Prometheus HTTP API responses
+type response struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+			Values [][]interface{}   `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// Query evaluates an instant query
+func (c *Client) Query(ctx context.Context, query string, at time.Time) ([]Series, error) {
+	params := url.Values{"query": {query}, "time": {formatTime(at)}}
+	return c.query(ctx, "/api/v1/query", params)
+}
+
+// QueryRange evaluates a query over a time range with the given resolution
+func (c *Client) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) ([]Series, error) {
+	params := url.Values{
+		"query": {query},
+		"start": {formatTime(start)},
+		"end":   {formatTime(end)},
+		"step":  {strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+	}
+	return c.query(ctx, "/api/v1/query_range", params)
+}
+
+// query posts a query and converts its vector or matrix result
+func (c *Client) query(ctx context.Context, path string, params url.Values) ([]Series, error) {
+	endpoint := *c.base
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case c.opts.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	case c.opts.Username != "":
+		req.SetBasicAuth(c.opts.Username, c.opts.Password)
+	}
+	if c.opts.Tenant != "" {
+		req.Header.Set("X-Scope-OrgID", c.opts.Tenant)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, clierr.Wrap(err, clierr.CategoryUnreachable, "DATASOURCE_UNREACHABLE", "failed to reach datasource "+c.base.Host).
+			WithHint("Check the URL with 'upid config datasource test'")
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256<<20))
+	if err != nil {
+		return nil, clierr.Wrap(err, clierr.CategoryUnreachable, "DATASOURCE_UNREACHABLE", "failed to read datasource response")
+	}
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, clierr.New(clierr.CategoryAuth, "DATASOURCE_AUTH_FAILED",
+			fmt.Sprintf("datasource %s rejected the credentials (%s)", c.base.Host, resp.Status)).
+			WithHint("Set credentials with 'upid config datasource set --token-stdin' or --username/--password-stdin")
+	}
+	var result response
+	if err := json.Unmarshal(body, &result); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, clierr.New(clierr.CategoryUnreachable, "DATASOURCE_UNREACHABLE",
+				fmt.Sprintf("datasource %s answered %s", c.base.Host, resp.Status))
+		}
+		return nil, clierr.New(clierr.CategoryGeneral, "DATASOURCE_INVALID_RESPONSE",
+			fmt.Sprintf("datasource %s did not answer with the Prometheus API", c.base.Host)).
+			WithHint("Use the base URL the /api/v1 endpoints are served under")
+	}
+	if result.Status != "success" {
+		return nil, clierr.New(clierr.CategoryGeneral, "DATASOURCE_QUERY_FAILED",
+			fmt.Sprintf("datasource query failed: %s: %s", result.ErrorType, result.Error))
+	}
+
+	series := make([]Series, 0, len(result.Data.Result))
+	for _, item := range result.Data.Result {
+		s := Series{Labels: item.Metric}
+		if item.Value != nil {
+			if sample, ok := parseSample(item.Value); ok {
+				s.Samples = append(s.Samples, sample)
+			}
+		}
+		for _, value := range item.Values {
+			if sample, ok := parseSample(value); ok {
+				s.Samples = append(s.Samples, sample)
+			}
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+// parseSample converts a [timestamp, "value"] pair
+func parseSample(pair []interface{}) (Sample, bool) {
+	if len(pair) != 2 {
+		return Sample{}, false
+	}
+	ts, ok := pair[0].(float64)
+	if !ok {
+		return Sample{}, false
+	}
+	text, ok := pair[1].(string)
+	if !ok {
+		return Sample{}, false
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return Sample{}, false
+	}
+	sec := int64(ts)
+	return Sample{Time: time.Unix(sec, int64((ts-float64(sec))*1e9)), Value: value}, true
+}
+
+// formatTime formats a time as Unix seconds
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', 3, 64)
+}
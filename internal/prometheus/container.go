@@ -0,0 +1,72 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ContainerUsage is the usage of a container over a time range at a
+// percentile, and its peak
+type ContainerUsage struct {
+	// CPU is in cores
+	CPU    float64 `json:"cpu"`
+	CPUMax float64 `json:"cpu_max"`
+	// Memory is the working set in bytes
+	Memory    float64 `json:"memory"`
+	MemoryMax float64 `json:"memory_max"`
+}
+
+// ContainerHistory returns the usage of the containers in namespace (all if
+// empty) over the window up to now at quantile (between 0 and 1) and at
+// its peak, keyed by "namespace/pod/container"
+func (c *Client) ContainerHistory(ctx context.Context, namespace string, window time.Duration, quantile float64) (map[string]*ContainerUsage, error) {
+	step := window / maxPoints
+	if step < minStep {
+		step = minStep
+	}
+	rate := step
+	if rate < rateWindow {
+		rate = rateWindow
+	}
+
+	selector := `container!="",container!="POD"`
+	if namespace != "" {
+		selector += fmt.Sprintf(",namespace=%q", namespace)
+	}
+	cpu := fmt.Sprintf("sum by (namespace, pod, container) (rate(container_cpu_usage_seconds_total{%s}[%ds]))", selector, int(rate.Seconds()))
+	memory := fmt.Sprintf("sum by (namespace, pod, container) (container_memory_working_set_bytes{%s})", selector)
+	subquery := fmt.Sprintf("[%ds:%ds]", int(window.Seconds()), int(step.Seconds()))
+	queries := map[string]string{
+		"cpu":        fmt.Sprintf("quantile_over_time(%g, (%s)%s)", quantile, cpu, subquery),
+		"cpu_max":    fmt.Sprintf("max_over_time((%s)%s)", cpu, subquery),
+		"memory":     fmt.Sprintf("quantile_over_time(%g, (%s)%s)", quantile, memory, subquery),
+		"memory_max": fmt.Sprintf("max_over_time((%s)%s)", memory, subquery),
+	}
+	results, err := c.queryAll(ctx, queries, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	containers := map[string]*ContainerUsage{}
+	container := func(labels map[string]string) *ContainerUsage {
+		key := labels["namespace"] + "/" + labels["pod"] + "/" + labels["container"]
+		if containers[key] == nil {
+			containers[key] = &ContainerUsage{}
+		}
+		return containers[key]
+	}
+	for _, s := range results["cpu"] {
+		container(s.Labels).CPU = lastValue(s)
+	}
+	for _, s := range results["cpu_max"] {
+		container(s.Labels).CPUMax = lastValue(s)
+	}
+	for _, s := range results["memory"] {
+		container(s.Labels).Memory = lastValue(s)
+	}
+	for _, s := range results["memory_max"] {
+		container(s.Labels).MemoryMax = lastValue(s)
+	}
+	return containers, nil
+}
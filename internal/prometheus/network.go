@@ -0,0 +1,161 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// PodTraffic is the bytes a pod received and sent over a time range, as
+// measured by cAdvisor
+type PodTraffic struct {
+	Received float64 `json:"received"`
+	Sent     float64 `json:"sent"`
+}
+
+// Flow is the traffic between two workloads over a time range, as reported
+// by the Istio sidecar of the source
+type Flow struct {
+	SourceNamespace      string `json:"source_namespace"`
+	SourceWorkload       string `json:"source_workload"`
+	DestinationNamespace string `json:"destination_namespace"`
+	// DestinationWorkload is "unknown" for destinations outside the mesh
+	DestinationWorkload string `json:"destination_workload"`
+	DestinationService  string `json:"destination_service"`
+	// Sent is the bytes of requests and Received of responses, seen from
+	// the source
+	Sent     float64 `json:"sent"`
+	Received float64 `json:"received"`
+}
+
+// flowLabels are the Istio labels flows are grouped by
+const flowLabels = "source_workload_namespace, source_workload, destination_workload_namespace, destination_workload, destination_service"
+
+// NetworkHistory returns the bytes received and sent by the pods in
+// namespace (all if empty) over the window up to now, keyed by
+// "namespace/name"
+func (c *Client) NetworkHistory(ctx context.Context, namespace string, window time.Duration) (map[string]*PodTraffic, error) {
+	selector := `pod!=""`
+	if namespace != "" {
+		selector += fmt.Sprintf(",namespace=%q", namespace)
+	}
+	rangeSel := fmt.Sprintf("[%ds]", int(window.Seconds()))
+	queries := map[string]string{
+		"receive": fmt.Sprintf("sum by (namespace, pod) (increase(container_network_receive_bytes_total{%s}%s))", selector, rangeSel),
+		"send":    fmt.Sprintf("sum by (namespace, pod) (increase(container_network_transmit_bytes_total{%s}%s))", selector, rangeSel),
+	}
+	results, err := c.queryAll(ctx, queries, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	pods := map[string]*PodTraffic{}
+	pod := func(labels map[string]string) *PodTraffic {
+		key := labels["namespace"] + "/" + labels["pod"]
+		if pods[key] == nil {
+			pods[key] = &PodTraffic{}
+		}
+		return pods[key]
+	}
+	for _, s := range results["receive"] {
+		pod(s.Labels).Received = lastValue(s)
+	}
+	for _, s := range results["send"] {
+		pod(s.Labels).Sent = lastValue(s)
+	}
+	return pods, nil
+}
+
+// MeshFlows returns the traffic between workloads over the window up to
+// now from Istio's HTTP and TCP byte metrics. It is empty if the datasource
+// has no Istio metrics.
+func (c *Client) MeshFlows(ctx context.Context, window time.Duration) ([]Flow, error) {
+	rangeSel := fmt.Sprintf(`{reporter="source"}[%ds]`, int(window.Seconds()))
+	increase := func(metric string) string {
+		return fmt.Sprintf("sum by (%s) (increase(%s%s))", flowLabels, metric, rangeSel)
+	}
+	queries := map[string]string{
+		"request":      increase("istio_request_bytes_sum"),
+		"response":     increase("istio_response_bytes_sum"),
+		"tcp_sent":     increase("istio_tcp_sent_bytes_total"),
+		"tcp_received": increase("istio_tcp_received_bytes_total"),
+	}
+	results, err := c.queryAll(ctx, queries, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var flows []Flow
+	index := map[Flow]int{}
+	flow := func(labels map[string]string) *Flow {
+		key := Flow{
+			SourceNamespace:      labels["source_workload_namespace"],
+			SourceWorkload:       labels["source_workload"],
+			DestinationNamespace: labels["destination_workload_namespace"],
+			DestinationWorkload:  labels["destination_workload"],
+			DestinationService:   labels["destination_service"],
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(flows)
+			index[key] = i
+			flows = append(flows, key)
+		}
+		return &flows[i]
+	}
+	for _, name := range []string{"request", "tcp_sent"} {
+		for _, s := range results[name] {
+			f := flow(s.Labels)
+			f.Sent += lastValue(s)
+		}
+	}
+	for _, name := range []string{"response", "tcp_received"} {
+		for _, s := range results[name] {
+			f := flow(s.Labels)
+			f.Received += lastValue(s)
+		}
+	}
+	return flows, nil
+}
+
+// queryAll evaluates instant queries concurrently, keyed like queries
+func (c *Client) queryAll(ctx context.Context, queries map[string]string, at time.Time) (map[string][]Series, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		results  = map[string][]Series{}
+	)
+	for name, query := range queries {
+		wg.Add(1)
+		go func(name, query string) {
+			defer wg.Done()
+			series, err := c.Query(ctx, query, at)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			results[name] = series
+		}(name, query)
+	}
+	wg.Wait()
+	return results, firstErr
+}
+
+// lastValue returns the latest sample of a series, 0 if it has none
+func lastValue(s Series) float64 {
+	if len(s.Samples) == 0 {
+		return 0
+	}
+	v := s.Samples[len(s.Samples)-1].Value
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,84 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PodPeriod is the resources a pod used and requested over a period,
+// integrated over time
+type PodPeriod struct {
+	// CPU is in core-seconds
+	CPUUsed      float64 `json:"cpu_used"`
+	CPURequested float64 `json:"cpu_requested"`
+	// Memory is in byte-seconds
+	MemoryUsed      float64 `json:"memory_used"`
+	MemoryRequested float64 `json:"memory_requested"`
+	// NetworkPeak is the 95th percentile of bytes per second received and
+	// sent
+	NetworkPeak float64 `json:"network_peak"`
+}
+
+// PeriodUsage returns the resources the pods in namespace (all if empty)
+// used and requested between start and end, keyed by "namespace/name".
+// Usage is measured by cAdvisor and requests by kube-state-metrics; it
+// reports false if the datasource has no requests from kube-state-metrics.
+func (c *Client) PeriodUsage(ctx context.Context, namespace string, start, end time.Time) (map[string]*PodPeriod, bool, error) {
+	window := end.Sub(start)
+	step := window / maxPoints
+	if step < rateWindow {
+		step = rateWindow
+	}
+
+	selector := `container!="",container!="POD"`
+	podSelector := `pod!=""`
+	if namespace != "" {
+		selector += fmt.Sprintf(",namespace=%q", namespace)
+		podSelector += fmt.Sprintf(",namespace=%q", namespace)
+	}
+	// Gauges are integrated by sampling them at every step
+	integral := func(metric, selector string) string {
+		return fmt.Sprintf("sum by (namespace, pod) (sum_over_time(%s{%s}[%ds:%ds])) * %d",
+			metric, selector, int(window.Seconds()), int(step.Seconds()), int(step.Seconds()))
+	}
+	rate := fmt.Sprintf("[%ds]", int(rateWindow.Seconds()))
+	queries := map[string]string{
+		"cpu":            fmt.Sprintf("sum by (namespace, pod) (increase(container_cpu_usage_seconds_total{%s}[%ds]))", selector, int(window.Seconds())),
+		"memory":         integral("container_memory_working_set_bytes", selector),
+		"cpu_request":    integral("kube_pod_container_resource_requests", podSelector+`,resource="cpu"`),
+		"memory_request": integral("kube_pod_container_resource_requests", podSelector+`,resource="memory"`),
+		"network": fmt.Sprintf("quantile_over_time(0.95, (sum by (namespace, pod) (rate(container_network_receive_bytes_total{%s}%s) + rate(container_network_transmit_bytes_total{%s}%s)))[%ds:%ds])",
+			podSelector, rate, podSelector, rate, int(window.Seconds()), int(step.Seconds())),
+	}
+	results, err := c.queryAll(ctx, queries, end)
+	if err != nil {
+		return nil, false, err
+	}
+
+	pods := map[string]*PodPeriod{}
+	pod := func(labels map[string]string) *PodPeriod {
+		key := labels["namespace"] + "/" + labels["pod"]
+		if pods[key] == nil {
+			pods[key] = &PodPeriod{}
+		}
+		return pods[key]
+	}
+	for _, s := range results["cpu"] {
+		pod(s.Labels).CPUUsed = lastValue(s)
+	}
+	for _, s := range results["memory"] {
+		pod(s.Labels).MemoryUsed = lastValue(s)
+	}
+	for _, s := range results["cpu_request"] {
+		pod(s.Labels).CPURequested = lastValue(s)
+	}
+	for _, s := range results["memory_request"] {
+		pod(s.Labels).MemoryRequested = lastValue(s)
+	}
+	for _, s := range results["network"] {
+		pod(s.Labels).NetworkPeak = lastValue(s)
+	}
+	requests := len(results["cpu_request"]) > 0 || len(results["memory_request"]) > 0
+	return pods, requests, nil
+}
@@ -0,0 +1,73 @@
+package prometheus
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// SLO is a service level objective with its error budget, as recorded by
+// the rules Sloth generates
+type SLO struct {
+	// Service, Name and ID are the sloth_service, sloth_slo and sloth_id
+	// labels of the SLO
+	Service string `json:"service"`
+	Name    string `json:"slo"`
+	ID      string `json:"id"`
+	// Namespace is the namespace label of the SLO, "" if it has none
+	Namespace string `json:"namespace,omitempty"`
+	// Objective is the ratio of good events, e.g. 0.999
+	Objective float64 `json:"objective"`
+	// BudgetRemaining is the ratio of the error budget of the period that
+	// is left, below 0 once it is spent
+	BudgetRemaining float64 `json:"budget_remaining"`
+	// BurnRate is how fast the budget is spent now, 1 spending it exactly
+	// over the period
+	BurnRate float64 `json:"burn_rate"`
+	// HasBurnRate is false when the current burn rate is not recorded
+	HasBurnRate bool `json:"-"`
+}
+
+// SLOs returns the SLOs whose remaining error budget is recorded by the
+// rules of Sloth: slo:period_error_budget_remaining:ratio, with
+// slo:objective:ratio and slo:current_burn_rate:ratio
+func (c *Client) SLOs(ctx context.Context) ([]SLO, error) {
+	const by = "sloth_id, sloth_service, sloth_slo, namespace"
+	results, err := c.queryAll(ctx, map[string]string{
+		"remaining": "max by (" + by + ") (slo:period_error_budget_remaining:ratio)",
+		"objective": "max by (" + by + ") (slo:objective:ratio)",
+		"burn":      "max by (" + by + ") (slo:current_burn_rate:ratio)",
+	}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	key := func(labels map[string]string) string {
+		return labels["sloth_id"] + "/" + labels["namespace"]
+	}
+	objectives := map[string]float64{}
+	for _, s := range results["objective"] {
+		objectives[key(s.Labels)] = lastValue(s)
+	}
+	burns := map[string]float64{}
+	for _, s := range results["burn"] {
+		burns[key(s.Labels)] = lastValue(s)
+	}
+
+	slos := make([]SLO, 0, len(results["remaining"]))
+	for _, s := range results["remaining"] {
+		burn, ok := burns[key(s.Labels)]
+		slos = append(slos, SLO{
+			Service:         s.Labels["sloth_service"],
+			Name:            s.Labels["sloth_slo"],
+			ID:              s.Labels["sloth_id"],
+			Namespace:       s.Labels["namespace"],
+			Objective:       objectives[key(s.Labels)],
+			BudgetRemaining: lastValue(s),
+			BurnRate:        burn,
+			HasBurnRate:     ok,
+		})
+	}
+	sort.Slice(slos, func(i, j int) bool { return slos[i].ID < slos[j].ID })
+	return slos, nil
+}
@@ -0,0 +1,243 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxPoints bounds the samples fetched per series, so that long time ranges
+// are read at a coarser resolution
+const maxPoints = 500
+
+// minStep is the finest resolution of usage series
+const minStep = time.Minute
+
+// rateWindow is the shortest window counters are turned into rates over;
+// it must cover several scrape intervals
+const rateWindow = 5 * time.Minute
+
+// Stats summarize the samples of a series
+type Stats struct {
+	Avg float64 `json:"avg"`
+	P95 float64 `json:"p95"`
+	Max float64 `json:"max"`
+}
+
+// PodUsage is the usage of a pod over a time range, as measured by cAdvisor
+type PodUsage struct {
+	// CPU is in cores
+	CPU Stats `json:"cpu"`
+	// Memory is the working set in bytes
+	Memory Stats `json:"memory"`
+	// NetworkReceive and NetworkTransmit are in bytes per second
+	NetworkReceive  Stats `json:"network_receive"`
+	NetworkTransmit Stats `json:"network_transmit"`
+	// Observed is the time between the first and last CPU sample
+	Observed time.Duration `json:"observed"`
+}
+
+// PodHistory returns the CPU, memory and network usage of the pods in
+// namespace (all if empty) over the window up to now, keyed by
+// "namespace/name"
+func (c *Client) PodHistory(ctx context.Context, namespace string, window time.Duration) (map[string]*PodUsage, error) {
+	end := time.Now()
+	start := end.Add(-window)
+	step := window / maxPoints
+	if step < minStep {
+		step = minStep
+	}
+	rate := step
+	if rate < rateWindow {
+		rate = rateWindow
+	}
+
+	selector := `container!="",container!="POD"`
+	podSelector := `pod!=""`
+	if namespace != "" {
+		selector += fmt.Sprintf(",namespace=%q", namespace)
+		podSelector += fmt.Sprintf(",namespace=%q", namespace)
+	}
+	rangeSel := fmt.Sprintf("[%ds]", int(rate.Seconds()))
+	queries := map[string]string{
+		"cpu":     fmt.Sprintf("sum by (namespace, pod) (rate(container_cpu_usage_seconds_total{%s}%s))", selector, rangeSel),
+		"memory":  fmt.Sprintf("sum by (namespace, pod) (container_memory_working_set_bytes{%s})", selector),
+		"receive": fmt.Sprintf("sum by (namespace, pod) (rate(container_network_receive_bytes_total{%s}%s))", podSelector, rangeSel),
+		"send":    fmt.Sprintf("sum by (namespace, pod) (rate(container_network_transmit_bytes_total{%s}%s))", podSelector, rangeSel),
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		results  = map[string][]Series{}
+	)
+	for name, query := range queries {
+		wg.Add(1)
+		go func(name, query string) {
+			defer wg.Done()
+			series, err := c.QueryRange(ctx, query, start, end, step)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			results[name] = series
+		}(name, query)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	pods := map[string]*PodUsage{}
+	pod := func(labels map[string]string) *PodUsage {
+		key := labels["namespace"] + "/" + labels["pod"]
+		if pods[key] == nil {
+			pods[key] = &PodUsage{}
+		}
+		return pods[key]
+	}
+	for _, s := range results["cpu"] {
+		usage := pod(s.Labels)
+		usage.CPU = summarize(s.Samples)
+		if n := len(s.Samples); n > 1 {
+			usage.Observed = s.Samples[n-1].Time.Sub(s.Samples[0].Time)
+		}
+	}
+	for _, s := range results["memory"] {
+		pod(s.Labels).Memory = summarize(s.Samples)
+	}
+	for _, s := range results["receive"] {
+		pod(s.Labels).NetworkReceive = summarize(s.Samples)
+	}
+	for _, s := range results["send"] {
+		pod(s.Labels).NetworkTransmit = summarize(s.Samples)
+	}
+	return pods, nil
+}
+
+// summarize returns the average, 95th percentile and maximum of samples
+func summarize(samples []Sample) Stats {
+	if len(samples) == 0 {
+		return Stats{}
+	}
+	values := make([]float64, 0, len(samples))
+	var sum float64
+	for _, s := range samples {
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		values = append(values, s.Value)
+		sum += s.Value
+	}
+	if len(values) == 0 {
+		return Stats{}
+	}
+	sort.Float64s(values)
+	p95 := values[int(math.Ceil(0.95*float64(len(values))))-1]
+	return Stats{Avg: sum / float64(len(values)), P95: p95, Max: values[len(values)-1]}
+}
+
+// UsagePoint is the usage of the pods of a workload at one time
+type UsagePoint struct {
+	Time time.Time `json:"time"`
+	// CPU in cores and Memory in bytes are averages over the pods
+	CPU    float64 `json:"cpu"`
+	Memory float64 `json:"memory"`
+	Pods   int     `json:"pods"`
+}
+
+// WorkloadUsage is the usage of the pods of a workload over a time range
+type WorkloadUsage struct {
+	// Points are the average usage per pod at regular steps
+	Points []UsagePoint `json:"points"`
+	// CPU and Memory summarize the usage of the busiest pod at each step,
+	// which requests must fit
+	CPU    Stats `json:"cpu"`
+	Memory Stats `json:"memory"`
+}
+
+// WorkloadHistory returns the usage of the pods in namespace whose names
+// match podPattern, a regular expression, over the window up to now in the
+// given number of points
+func (c *Client) WorkloadHistory(ctx context.Context, namespace, podPattern string, window time.Duration, points int) (*WorkloadUsage, error) {
+	end := time.Now()
+	start := end.Add(-window)
+	step := window / time.Duration(points)
+	if step < minStep {
+		step = minStep
+	}
+	rate := step
+	if rate < rateWindow {
+		rate = rateWindow
+	}
+
+	selector := fmt.Sprintf(`container!="",container!="POD",namespace=%q,pod=~%q`, namespace, podPattern)
+	cpu := fmt.Sprintf("sum by (pod) (rate(container_cpu_usage_seconds_total{%s}[%ds]))", selector, int(rate.Seconds()))
+	memory := fmt.Sprintf("sum by (pod) (container_memory_working_set_bytes{%s})", selector)
+	queries := map[string]string{
+		"cpu":        "avg(" + cpu + ")",
+		"cpu_max":    "max(" + cpu + ")",
+		"memory":     "avg(" + memory + ")",
+		"memory_max": "max(" + memory + ")",
+		"pods":       "count(" + memory + ")",
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		results  = map[string][]Sample{}
+	)
+	for name, query := range queries {
+		wg.Add(1)
+		go func(name, query string) {
+			defer wg.Done()
+			series, err := c.QueryRange(ctx, query, start, end, step)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			if len(series) > 0 {
+				results[name] = series[0].Samples
+			}
+		}(name, query)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	usage := &WorkloadUsage{
+		CPU:    summarize(results["cpu_max"]),
+		Memory: summarize(results["memory_max"]),
+	}
+	// A step without pods has no samples, and is left out
+	index := map[int64]int{}
+	for _, s := range results["pods"] {
+		index[s.Time.Unix()] = len(usage.Points)
+		usage.Points = append(usage.Points, UsagePoint{Time: s.Time, Pods: int(s.Value)})
+	}
+	for _, s := range results["cpu"] {
+		if i, ok := index[s.Time.Unix()]; ok {
+			usage.Points[i].CPU = s.Value
+		}
+	}
+	for _, s := range results["memory"] {
+		if i, ok := index[s.Time.Unix()]; ok {
+			usage.Points[i].Memory = s.Value
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,164 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// VolumeUsage is the filesystem usage of a persistent volume claim over a
+// time range, as reported by the kubelet of the node mounting it
+type VolumeUsage struct {
+	// Capacity is the size of the filesystem in bytes
+	Capacity float64 `json:"capacity"`
+	// Used is the bytes used now, Peak the most over the time range
+	Used float64 `json:"used"`
+	Peak float64 `json:"peak"`
+	// Growth is the trend of the bytes used over the time range, in bytes
+	// per second, below 0 when usage shrinks
+	Growth float64 `json:"growth"`
+}
+
+// VolumeHistory returns the usage of the persistent volume claims in
+// namespace (all if empty) over the window up to now from
+// kubelet_volume_stats_used_bytes and kubelet_volume_stats_capacity_bytes,
+// keyed by "namespace/claim". Claims not mounted by any pod have no stats.
+func (c *Client) VolumeHistory(ctx context.Context, namespace string, window time.Duration) (map[string]*VolumeUsage, error) {
+	selector := `persistentvolumeclaim!=""`
+	if namespace != "" {
+		selector += fmt.Sprintf(",namespace=%q", namespace)
+	}
+	rangeSel := fmt.Sprintf("[%ds]", int(window.Seconds()))
+	const by = "max by (namespace, persistentvolumeclaim) "
+	queries := map[string]string{
+		"capacity": by + fmt.Sprintf("(kubelet_volume_stats_capacity_bytes{%s})", selector),
+		"used":     by + fmt.Sprintf("(kubelet_volume_stats_used_bytes{%s})", selector),
+		"peak":     by + fmt.Sprintf("(max_over_time(kubelet_volume_stats_used_bytes{%s}%s))", selector, rangeSel),
+		"growth":   by + fmt.Sprintf("(deriv(kubelet_volume_stats_used_bytes{%s}%s))", selector, rangeSel),
+	}
+	results, err := c.queryAll(ctx, queries, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := map[string]*VolumeUsage{}
+	volume := func(labels map[string]string) *VolumeUsage {
+		key := labels["namespace"] + "/" + labels["persistentvolumeclaim"]
+		if volumes[key] == nil {
+			volumes[key] = &VolumeUsage{}
+		}
+		return volumes[key]
+	}
+	// Claims of pods that stopped during the time range have a peak but no
+	// current stats, only those mounted now are returned
+	for _, s := range results["capacity"] {
+		volume(s.Labels).Capacity = lastValue(s)
+	}
+	for _, s := range results["used"] {
+		volume(s.Labels).Used = lastValue(s)
+	}
+	for _, s := range results["peak"] {
+		if v, ok := volumes[s.Labels["namespace"]+"/"+s.Labels["persistentvolumeclaim"]]; ok {
+			v.Peak = lastValue(s)
+		}
+	}
+	for _, s := range results["growth"] {
+		if v, ok := volumes[s.Labels["namespace"]+"/"+s.Labels["persistentvolumeclaim"]]; ok {
+			v.Growth = lastValue(s)
+		}
+	}
+	for _, v := range volumes {
+		if v.Peak < v.Used {
+			v.Peak = v.Used
+		}
+	}
+	return volumes, nil
+}
+
+// MountedVolumes returns the persistent volume claims in namespace (all if
+// empty) that a pod mounted at some point of the window up to now, keyed by
+// "namespace/claim", as the kubelet reports stats of mounted claims only
+func (c *Client) MountedVolumes(ctx context.Context, namespace string, window time.Duration) (map[string]bool, error) {
+	selector := `persistentvolumeclaim!=""`
+	if namespace != "" {
+		selector += fmt.Sprintf(",namespace=%q", namespace)
+	}
+	query := fmt.Sprintf("count by (namespace, persistentvolumeclaim) (count_over_time(kubelet_volume_stats_used_bytes{%s}[%ds]))",
+		selector, int(window.Seconds()))
+	series, err := c.Query(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	mounted := map[string]bool{}
+	for _, s := range series {
+		mounted[s.Labels["namespace"]+"/"+s.Labels["persistentvolumeclaim"]] = true
+	}
+	return mounted, nil
+}
+
+// VolumeIO is the I/O of an EBS volume over a time range, as the node
+// plugin of the EBS CSI driver reports it from the NVMe statistics of the
+// volume
+type VolumeIO struct {
+	// IOPS and Throughput, in bytes per second, are the averages over the
+	// time range, PeakIOPS and PeakThroughput those of the busiest 5
+	// minutes
+	IOPS           float64 `json:"iops"`
+	PeakIOPS       float64 `json:"peak_iops"`
+	Throughput     float64 `json:"throughput"`
+	PeakThroughput float64 `json:"peak_throughput"`
+	// Throttled is the seconds the volume exceeded its provisioned IOPS or
+	// throughput
+	Throttled float64 `json:"throttled"`
+}
+
+// VolumeIOHistory returns the I/O of the EBS volumes over the window up to
+// now from the aws_ebs_csi_* metrics of the EBS CSI driver, keyed by volume
+// ID. It is empty unless the metrics of the node plugin are scraped.
+func (c *Client) VolumeIOHistory(ctx context.Context, window time.Duration) (map[string]*VolumeIO, error) {
+	seconds := int(window.Seconds())
+	// sum adds the rates of the read and write counters of a metric over a
+	// range
+	sum := func(metric, rangeSel string) string {
+		return fmt.Sprintf("sum by (volume_id) (rate(aws_ebs_csi_read_%s[%s])) + sum by (volume_id) (rate(aws_ebs_csi_write_%s[%s]))",
+			metric, rangeSel, metric, rangeSel)
+	}
+	queries := map[string]string{
+		"iops":            sum("ops_total", fmt.Sprintf("%ds", seconds)),
+		"peak_iops":       fmt.Sprintf("max_over_time((%s)[%ds:5m])", sum("ops_total", "5m"), seconds),
+		"throughput":      sum("bytes_total", fmt.Sprintf("%ds", seconds)),
+		"peak_throughput": fmt.Sprintf("max_over_time((%s)[%ds:5m])", sum("bytes_total", "5m"), seconds),
+		"throttled": fmt.Sprintf("sum by (volume_id) (increase(aws_ebs_csi_exceeded_iops_seconds_total[%ds])) + sum by (volume_id) (increase(aws_ebs_csi_exceeded_tp_seconds_total[%ds]))",
+			seconds, seconds),
+	}
+	results, err := c.queryAll(ctx, queries, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := map[string]*VolumeIO{}
+	volume := func(labels map[string]string) *VolumeIO {
+		id := labels["volume_id"]
+		if volumes[id] == nil {
+			volumes[id] = &VolumeIO{}
+		}
+		return volumes[id]
+	}
+	for _, s := range results["iops"] {
+		volume(s.Labels).IOPS = lastValue(s)
+	}
+	for _, s := range results["peak_iops"] {
+		volume(s.Labels).PeakIOPS = lastValue(s)
+	}
+	for _, s := range results["throughput"] {
+		volume(s.Labels).Throughput = lastValue(s)
+	}
+	for _, s := range results["peak_throughput"] {
+		volume(s.Labels).PeakThroughput = lastValue(s)
+	}
+	for _, s := range results["throttled"] {
+		volume(s.Labels).Throttled = lastValue(s)
+	}
+	delete(volumes, "")
+	return volumes, nil
+}
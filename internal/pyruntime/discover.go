@@ -0,0 +1,166 @@
+// Package pyruntime locates and installs the Python runtime used by the
+// bridge.
+package pyruntime
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Runtime kinds, in discovery order
+const (
+	KindVenv      = "venv"
+	KindZipapp    = "zipapp"
+	KindBundled   = "bundled"
+	KindSource    = "source"
+	KindContainer = "container"
+)
+
+// ScriptName is the runtime bootstrap script
+const ScriptName = "upid_runtime.py"
+
+// ZipappName is the single-file runtime shipped next to the binary
+const ZipappName = "upid-runtime.pyz"
+
+// Runtime describes how to invoke the Python runtime
+type Runtime struct {
+	// Kind is one of the Kind* constants
+	Kind string `json:"kind"`
+	// Command is the executable to run
+	Command string `json:"command"`
+	// Args are passed before the UPID command and its arguments
+	Args []string `json:"args"`
+	// Root is the directory or file the runtime was found in
+	Root string `json:"root"`
+}
+
+// CommandLine returns the full invocation for a UPID command
+func (r *Runtime) CommandLine(args ...string) []string {
+	line := append([]string{}, r.Args...)
+	return append(line, args...)
+}
+
+// Script returns the runtime script or zipapp passed to the interpreter, or
+// "" for container runtimes
+func (r *Runtime) Script() string {
+	if r.Kind == KindContainer || len(r.Args) == 0 {
+		return ""
+	}
+	return r.Args[len(r.Args)-1]
+}
+
+// Options controls runtime discovery
+type Options struct {
+	// Home is the UPID home directory ($UPID_HOME)
+	Home string
+	// Python is the interpreter used when the runtime has no venv
+	Python string
+	// Image is a container image to fall back to, if set
+	Image string
+}
+
+// Discover finds the Python runtime. It looks, in order, for:
+//
+//   - a runtime with its own venv in $UPID_HOME/runtime
+//   - a zipapp next to the upid binary
+//   - a runtime directory next to the upid binary
+//   - runtime/upid_runtime.py in the working directory (source checkouts)
+//   - a container image, if one is configured and docker is available
+func Discover(opts Options) (*Runtime, error) {
+	var searched []string
+
+	if opts.Home != "" {
+		dir := filepath.Join(opts.Home, "runtime")
+		searched = append(searched, dir)
+		if python := venvPython(filepath.Join(dir, "venv")); python != "" && isFile(filepath.Join(dir, ScriptName)) {
+			return &Runtime{Kind: KindVenv, Command: python, Args: []string{filepath.Join(dir, ScriptName)}, Root: dir}, nil
+		}
+	}
+
+	if exe, err := os.Executable(); err == nil {
+		if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+			exe = resolved
+		}
+		dir := filepath.Dir(exe)
+
+		zipapp := filepath.Join(dir, ZipappName)
+		searched = append(searched, zipapp)
+		if isFile(zipapp) {
+			return &Runtime{Kind: KindZipapp, Command: opts.Python, Args: []string{zipapp}, Root: zipapp}, nil
+		}
+
+		bundled := filepath.Join(dir, "runtime")
+		searched = append(searched, bundled)
+		if rt := scriptRuntime(KindBundled, bundled, opts.Python); rt != nil {
+			return rt, nil
+		}
+	}
+
+	searched = append(searched, "runtime")
+	if rt := scriptRuntime(KindSource, "runtime", opts.Python); rt != nil {
+		return rt, nil
+	}
+
+	if opts.Image != "" {
+		if docker, err := exec.LookPath("docker"); err == nil {
+			return containerRuntime(docker, opts.Image), nil
+		}
+		searched = append(searched, "container image "+opts.Image+" (docker not found)")
+	}
+
+	return nil, fmt.Errorf("no Python runtime found (searched %s)", strings.Join(searched, ", "))
+}
+
+// scriptRuntime returns a runtime for dir if it holds the bootstrap script,
+// preferring a venv inside it
+func scriptRuntime(kind, dir, python string) *Runtime {
+	script := filepath.Join(dir, ScriptName)
+	if !isFile(script) {
+		return nil
+	}
+	if venv := venvPython(filepath.Join(dir, "venv")); venv != "" {
+		python = venv
+	}
+	return &Runtime{Kind: kind, Command: python, Args: []string{script}, Root: dir}
+}
+
+// containerRuntime runs the runtime in a container with the user's
+// kubeconfig mounted read-only
+func containerRuntime(docker, image string) *Runtime {
+	args := []string{"run", "--rm", "-i", "--network", "host"}
+	if home, err := os.UserHomeDir(); err == nil {
+		kubeconfig := filepath.Join(home, ".kube")
+		if isDir(kubeconfig) {
+			args = append(args, "-v", kubeconfig+":/root/.kube:ro")
+		}
+	}
+	args = append(args, image, "python", "/opt/upid/runtime/"+ScriptName)
+	return &Runtime{Kind: KindContainer, Command: docker, Args: args, Root: image}
+}
+
+// venvPython returns the interpreter of a virtual environment, or "" if
+// there is none
+func venvPython(venv string) string {
+	python := filepath.Join(venv, "bin", "python")
+	if runtime.GOOS == "windows" {
+		python = filepath.Join(venv, "Scripts", "python.exe")
+	}
+	if isFile(python) {
+		return python
+	}
+	return ""
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
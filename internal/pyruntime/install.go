@@ -0,0 +1,251 @@
+package pyruntime
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// InstallOptions controls runtime installation
+type InstallOptions struct {
+	// Home is the UPID home directory; the runtime goes to Home/runtime
+	Home string
+	// Version is the runtime version being installed
+	Version string
+	// Source is the URL or local path of the runtime archive (.tar.gz)
+	Source string
+	// Checksum is the expected SHA-256 of the archive. When empty it is read
+	// from Source + ".sha256".
+	Checksum string
+	// Python creates the runtime's virtual environment
+	Python string
+	// SkipDeps skips installing Python dependencies into the venv
+	SkipDeps bool
+	// Force replaces an existing runtime
+	Force bool
+	// Log receives progress messages
+	Log io.Writer
+}
+
+// ReleaseURL returns the download URL of the runtime archive for a release
+func ReleaseURL(repository, version string) string {
+	return fmt.Sprintf("%s/releases/download/v%s/upid-runtime-%s.tar.gz", strings.TrimSuffix(repository, "/"), version, version)
+}
+
+// Install downloads, verifies and unpacks a runtime archive into
+// Home/runtime and creates its virtual environment
+func Install(ctx context.Context, opts InstallOptions) (*Runtime, error) {
+	if opts.Log == nil {
+		opts.Log = io.Discard
+	}
+	target := filepath.Join(opts.Home, "runtime")
+	if isDir(target) && !opts.Force {
+		return nil, fmt.Errorf("runtime already installed in %s (use --force to replace it)", target)
+	}
+	if err := os.MkdirAll(opts.Home, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", opts.Home, err)
+	}
+
+	// Download into the home directory so the final rename stays on one
+	// file system
+	fmt.Fprintf(opts.Log, "Downloading %s\n", opts.Source)
+	archive, err := os.CreateTemp(opts.Home, "runtime-*.tar.gz")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download file: %v", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	hash := sha256.New()
+	if err := fetch(ctx, opts.Source, io.MultiWriter(archive, hash)); err != nil {
+		return nil, err
+	}
+
+	expected := opts.Checksum
+	if expected == "" {
+		var sum strings.Builder
+		if err := fetch(ctx, opts.Source+".sha256", &sum); err != nil {
+			return nil, fmt.Errorf("failed to get archive checksum (pass --sha256 to provide it): %v", err)
+		}
+		expected = strings.Fields(sum.String() + " ")[0]
+	}
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(expected, actual) {
+		return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", opts.Source, expected, actual)
+	}
+	fmt.Fprintf(opts.Log, "Verified SHA-256 %s\n", actual)
+
+	staging, err := os.MkdirTemp(opts.Home, "runtime-new-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	defer os.RemoveAll(staging)
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	root, err := extract(archive, staging)
+	if err != nil {
+		return nil, err
+	}
+	if !isFile(filepath.Join(root, ScriptName)) {
+		return nil, fmt.Errorf("archive does not contain %s", ScriptName)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "VERSION"), []byte(opts.Version+"\n"), 0o644); err != nil {
+		return nil, err
+	}
+
+	// Swap in the new runtime, keeping the previous one until setup succeeds.
+	// The venv is created in place because it records its own location.
+	backup := target + ".old"
+	os.RemoveAll(backup)
+	if isDir(target) {
+		if err := os.Rename(target, backup); err != nil {
+			return nil, fmt.Errorf("failed to move previous runtime: %v", err)
+		}
+	}
+	if err := os.Rename(root, target); err != nil {
+		os.Rename(backup, target)
+		return nil, fmt.Errorf("failed to install runtime: %v", err)
+	}
+	if err := setupVenv(ctx, target, opts); err != nil {
+		os.RemoveAll(target)
+		os.Rename(backup, target)
+		return nil, err
+	}
+	os.RemoveAll(backup)
+
+	return Discover(Options{Home: opts.Home, Python: opts.Python})
+}
+
+// setupVenv creates the runtime's virtual environment and installs its
+// dependencies
+func setupVenv(ctx context.Context, root string, opts InstallOptions) error {
+	fmt.Fprintln(opts.Log, "Creating virtual environment")
+	if err := run(ctx, opts.Log, opts.Python, "-m", "venv", filepath.Join(root, "venv")); err != nil {
+		return fmt.Errorf("failed to create virtual environment: %v", err)
+	}
+
+	requirements := filepath.Join(root, "requirements.txt")
+	if !isFile(requirements) {
+		requirements = filepath.Join(root, "bundle", "requirements.txt")
+	}
+	if opts.SkipDeps || !isFile(requirements) {
+		return nil
+	}
+	fmt.Fprintln(opts.Log, "Installing Python dependencies")
+	if err := run(ctx, opts.Log, venvPython(filepath.Join(root, "venv")), "-m", "pip", "install", "--quiet", "-r", requirements); err != nil {
+		return fmt.Errorf("failed to install Python dependencies: %v", err)
+	}
+	return nil
+}
+
+// InstalledVersion returns the version of the runtime in Home/runtime, or ""
+func InstalledVersion(home string) string {
+	data, err := os.ReadFile(filepath.Join(home, "runtime", "VERSION"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// fetch copies a URL or local file to w
+func fetch(ctx context.Context, source string, w io.Writer) error {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", source, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", source, resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// extract unpacks a gzipped tar archive into dir and returns the runtime
+// root: dir itself, or its only subdirectory if the archive has one
+func extract(r io.Reader, dir string) (string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return "", fmt.Errorf("invalid runtime archive: %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid runtime archive: %v", err)
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return "", fmt.Errorf("invalid path in runtime archive: %s", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return "", err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return "", err
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode)&0o755|0o600)
+			if err != nil {
+				return "", err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+	return dir, nil
+}
+
+// run executes a setup command, sending its output to log
+func run(ctx context.Context, log io.Writer, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	return cmd.Run()
+}
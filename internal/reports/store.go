@@ -0,0 +1,162 @@
+// Package reports keeps the reports UPID generated with their metadata, so
+// that they can be listed, read again and exported after the fact.
+package reports
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Keep is how many reports the store keeps; older ones are removed as new
+// ones are added
+const Keep = 100
+
+// indexFile names the file listing the reports of the store
+const indexFile = "index.json"
+
+// Report is a generated report
+type Report struct {
+	ID string `json:"id"`
+	// Type is the report type, such as summary or chargeback
+	Type string `json:"type"`
+	// Context is the kubeconfig context of the cluster, "" for reports of
+	// several clusters
+	Context string `json:"context,omitempty"`
+	// Period is the time range the report covers, such as 30d or 2024-05
+	Period string `json:"period,omitempty"`
+	// Format is the format of the file, such as pdf, html or csv
+	Format string `json:"format"`
+	// Generator is what generated the report, such as upid 2.0.0 or the
+	// template of the user
+	Generator string `json:"generator"`
+	// CreatedBy is the local user who generated the report
+	CreatedBy string    `json:"created_by,omitempty"`
+	Created   time.Time `json:"created"`
+	Size      int       `json:"size"`
+	// Written is where the report was written when it was generated
+	Written string `json:"written,omitempty"`
+}
+
+// Store is the local directory of generated reports: their files and an
+// index of their metadata
+type Store struct {
+	dir     string
+	Reports []*Report `json:"reports"`
+}
+
+// Load reads the store in dir; a missing directory holds no reports
+func Load(dir string) (*Store, error) {
+	store := &Store{dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reports: %v", err)
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("invalid reports index %s: %v", filepath.Join(dir, indexFile), err)
+	}
+	return store, nil
+}
+
+// Dir returns the directory of the store
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// NewID returns the ID of a new report of a type, such as
+// summary-20240501-a1b2c3
+func NewID(kind string, now time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%s-%s", kind, now.UTC().Format("20060102"), hex.EncodeToString(suffix))
+}
+
+// Add keeps the file of a report and saves the index, removing the oldest
+// reports beyond Keep. The report is given an ID if it has none.
+func (s *Store) Add(report *Report, data []byte) error {
+	if report.Created.IsZero() {
+		report.Created = time.Now()
+	}
+	if report.ID == "" {
+		report.ID = NewID(report.Type, report.Created)
+	}
+	report.Size = len(data)
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create reports directory: %v", err)
+	}
+	if err := os.WriteFile(s.Path(report), data, 0o600); err != nil {
+		return fmt.Errorf("failed to keep report: %v", err)
+	}
+
+	s.Reports = append(s.Reports, report)
+	sort.SliceStable(s.Reports, func(i, j int) bool { return s.Reports[i].Created.After(s.Reports[j].Created) })
+	for len(s.Reports) > Keep {
+		os.Remove(s.Path(s.Reports[len(s.Reports)-1]))
+		s.Reports = s.Reports[:len(s.Reports)-1]
+	}
+	return s.save()
+}
+
+// Get returns the report with an ID or a unique prefix of one, nil if there
+// is none
+func (s *Store) Get(id string) (*Report, error) {
+	var found *Report
+	for _, report := range s.Reports {
+		if report.ID == id {
+			return report, nil
+		}
+		if strings.HasPrefix(report.ID, id) {
+			if found != nil {
+				return nil, fmt.Errorf("report ID %q is ambiguous", id)
+			}
+			found = report
+		}
+	}
+	return found, nil
+}
+
+// Path returns the file of a report
+func (s *Store) Path(report *Report) string {
+	return filepath.Join(s.dir, report.ID+"."+report.Format)
+}
+
+// Read returns the content of a report
+func (s *Store) Read(report *Report) ([]byte, error) {
+	data, err := os.ReadFile(s.Path(report))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report %s: %v", report.ID, err)
+	}
+	return data, nil
+}
+
+// save replaces the index, readable only by the user
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode reports: %v", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, indexFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write reports: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write reports: %v", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, indexFile)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write reports: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// Package scheduler runs UPID commands on cron schedules kept in a local
+// file, with jitter, a history of runs and catch-up of runs missed while no
+// scheduler was running.
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// macros are the cron shorthands for common schedules
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// field is the range and names of one field of a cron expression
+type field struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// Cron is a parsed cron expression: minute, hour, day of month, month and
+// day of week, in local time
+type Cron struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64
+	// restricted days of month and of week match if either does, as in
+	// cron; if only one is restricted, it alone decides
+	domRestricted, dowRestricted bool
+}
+
+// ParseCron parses a standard five field cron expression, such as
+// "0 2 * * 1-5", or a shorthand such as @daily. Fields accept lists, ranges,
+// steps, and names of months and days.
+func ParseCron(expr string) (*Cron, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := macros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("invalid cron expression %q (expected 5 fields: minute hour day-of-month month day-of-week)", expr)
+	}
+
+	c := &Cron{expr: strings.TrimSpace(expr)}
+	bits := []*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, part := range parts {
+		set, err := fields[i].parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+		*bits[i] = set
+	}
+	// 7 is another name for Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow = c.dow&^(1<<7) | 1
+	}
+	c.domRestricted = parts[2] != "*" && !strings.HasPrefix(parts[2], "*/")
+	c.dowRestricted = parts[4] != "*" && !strings.HasPrefix(parts[4], "*/")
+	return c, nil
+}
+
+// parse returns the values of a field as a bit set
+func (f field) parse(value string) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(value, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s", stepText, f.name)
+			}
+			step = n
+		}
+
+		low, high := f.min, f.max
+		switch {
+		case rng == "*":
+			if f.name == "day of week" {
+				high = 6
+			}
+		case strings.Contains(rng, "-"):
+			first, last, _ := strings.Cut(rng, "-")
+			var err error
+			if low, err = f.value(first); err != nil {
+				return 0, err
+			}
+			if high, err = f.value(last); err != nil {
+				return 0, err
+			}
+			if high < low {
+				return 0, fmt.Errorf("invalid range %q in %s", rng, f.name)
+			}
+		default:
+			n, err := f.value(rng)
+			if err != nil {
+				return 0, err
+			}
+			low, high = n, n
+			if hasStep {
+				high = f.max
+			}
+		}
+		for n := low; n <= high; n += step {
+			set |= 1 << n
+		}
+	}
+	return set, nil
+}
+
+// value parses one value of a field, a number or a name
+func (f field) value(text string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(text, name) {
+			return i + f.min, nil
+		}
+	}
+	n, err := strconv.Atoi(text)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("invalid %s %q (expected %d-%d)", f.name, text, f.min, f.max)
+	}
+	return n, nil
+}
+
+// String returns the expression as written
+func (c *Cron) String() string {
+	return c.expr
+}
+
+// Next returns the first time after t that the expression matches, in the
+// location of t, or the zero time if it never does, as for February 30
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Four years cover every day a valid expression can match
+	limit := t.AddDate(4, 0, 1)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay reports whether the day of t matches the day of month and day
+// of week fields
+func (c *Cron) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domRestricted && c.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
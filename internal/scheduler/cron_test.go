@@ -0,0 +1,105 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"0 2 * * 1-5", false},
+		{"*/15 * * * *", false},
+		{"0 0 1,15 * *", false},
+		{"30 8 * jan-mar MON-FRI", false},
+		{"0 0 * * 7", false},
+		{"5/10 * * * *", false},
+		{"@daily", false},
+		{"@HOURLY", false},
+		{"  @weekly  ", false},
+		{"", true},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"5-1 * * * *", true},
+		{"*/0 * * * *", true},
+		{"*/x * * * *", true},
+		{"* * * foo *", true},
+		{"@fortnightly", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCron(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+			if want := strings.TrimSpace(tt.expr); err == nil && c.String() != want {
+				t.Errorf("String() = %q, want %q", c.String(), want)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(value string) time.Time {
+		layout := "2006-01-02 15:04"
+		if len(value) > len(layout) {
+			layout += ":05"
+		}
+		parsed, err := time.ParseInLocation(layout, value, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	// 2026-03-02 is a Monday
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want string
+	}{
+		{"next minute", "* * * * *", "2026-03-02 10:00", "2026-03-02 10:01"},
+		{"seconds are dropped", "* * * * *", "2026-03-02 10:00:59", "2026-03-02 10:01"},
+		{"later today", "0 2 * * *", "2026-03-02 01:30", "2026-03-02 02:00"},
+		{"tomorrow", "0 2 * * *", "2026-03-02 02:00", "2026-03-03 02:00"},
+		{"step", "*/15 * * * *", "2026-03-02 10:16", "2026-03-02 10:30"},
+		{"step from a start", "5/20 * * * *", "2026-03-02 10:26", "2026-03-02 10:45"},
+		{"weekdays skip the weekend", "0 9 * * 1-5", "2026-03-06 10:00", "2026-03-09 09:00"},
+		{"sunday as 7", "0 0 * * 7", "2026-03-02 00:00", "2026-03-08 00:00"},
+		{"names", "0 0 * * sat", "2026-03-02 00:00", "2026-03-07 00:00"},
+		{"month rollover", "0 0 1 * *", "2026-03-02 00:00", "2026-04-01 00:00"},
+		{"year rollover", "@yearly", "2026-03-02 00:00", "2027-01-01 00:00"},
+		{"day of month or week", "0 0 13 * 5", "2026-03-02 00:00", "2026-03-06 00:00"},
+		{"day of month and every weekday", "0 0 13 * *", "2026-03-02 00:00", "2026-03-13 00:00"},
+		{"31st skips short months", "0 0 31 * *", "2026-04-01 00:00", "2026-05-31 00:00"},
+		{"leap day", "0 0 29 2 *", "2026-03-02 00:00", "2028-02-29 00:00"},
+		{"never", "0 0 30 2 *", "2026-03-02 00:00", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q) error = %v", tt.expr, err)
+			}
+			got := c.Next(at(tt.from))
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("Next(%s) = %s, want never", tt.from, got)
+				}
+				return
+			}
+			if want := at(tt.want); !got.Equal(want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got.Format("2006-01-02 15:04 Mon"), want.Format("2006-01-02 15:04 Mon"))
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// missedAfter is how late a run may start and still be on time. Later runs
+// were missed while no scheduler was running.
+const missedAfter = time.Minute
+
+// pollInterval is the longest the scheduler sleeps before reading the
+// schedules file again, which other commands change
+const pollInterval = time.Minute
+
+// maxMissed bounds the count of missed runs reported for a schedule
+const maxMissed = 1000
+
+// Runner runs the command of a schedule and returns its exit code and the
+// end of its output
+type Runner func(ctx context.Context, schedule *Schedule) (int, string)
+
+// ScheduleRun is a run of a named schedule
+type ScheduleRun struct {
+	Schedule string `json:"schedule"`
+	Run
+}
+
+// Scheduler runs the schedules of a file when they are due
+type Scheduler struct {
+	Path   string
+	Runner Runner
+}
+
+// RunNow runs a schedule right away, whether or not it is enabled, and
+// records the run. Its next scheduled run is unchanged.
+func (s *Scheduler) RunNow(ctx context.Context, name string) (*ScheduleRun, error) {
+	store, err := Load(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	schedule := store.Get(name)
+	if schedule == nil {
+		return nil, fmt.Errorf("no schedule named %q", name)
+	}
+	run := s.execute(ctx, schedule, TriggerManual, time.Time{})
+	if _, err := update(s.Path, name, func(current *Schedule) { current.record(run) }); err != nil {
+		return nil, err
+	}
+	return &ScheduleRun{Schedule: name, Run: run}, nil
+}
+
+// RunDue runs the enabled schedules due at now, earliest first, and returns
+// their runs. Runs due more than a minute before now were missed while no
+// scheduler was running: schedules that catch up run once for all of them,
+// the others record them as missed. Each schedule is then set to run next
+// at its first time after now.
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) ([]ScheduleRun, error) {
+	store, err := Load(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	var due []*Schedule
+	for _, schedule := range store.Schedules {
+		if !schedule.Enabled {
+			continue
+		}
+		if schedule.Next.IsZero() {
+			// Enabled by editing the file, or never set to run
+			if err := schedule.Advance(now); err != nil {
+				return nil, fmt.Errorf("schedule %s: %v", schedule.Name, err)
+			}
+			if _, err := update(s.Path, schedule.Name, func(current *Schedule) { current.Next = schedule.Next }); err != nil {
+				return nil, err
+			}
+		}
+		if !schedule.Next.After(now) {
+			due = append(due, schedule)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].Next.Before(due[j].Next) })
+
+	var runs []ScheduleRun
+	for _, schedule := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		var run Run
+		missed := now.Sub(schedule.Next) > missedAfter
+		switch {
+		case missed && !schedule.CatchUp:
+			count := missedRuns(schedule, now)
+			run = Run{Due: schedule.Next, Started: now, Finished: now, Trigger: TriggerSchedule, Status: StatusMissed,
+				Output: fmt.Sprintf("%d runs from %s missed while no scheduler was running", count, schedule.Next.Format(time.RFC3339))}
+			slog.Warn("scheduled runs missed", "schedule", schedule.Name, "count", count)
+		case missed:
+			run = s.execute(ctx, schedule, TriggerCatchUp, schedule.Next)
+		default:
+			run = s.execute(ctx, schedule, TriggerSchedule, schedule.Next)
+		}
+		runs = append(runs, ScheduleRun{Schedule: schedule.Name, Run: run})
+
+		var advanceErr error
+		_, err := update(s.Path, schedule.Name, func(current *Schedule) {
+			current.record(run)
+			advanceErr = current.Advance(time.Now())
+		})
+		if err != nil {
+			return runs, err
+		}
+		if advanceErr != nil {
+			return runs, fmt.Errorf("schedule %s: %v", schedule.Name, advanceErr)
+		}
+	}
+	return runs, nil
+}
+
+// Serve runs the schedules when they are due until ctx is done, and reports
+// each run to done
+func (s *Scheduler) Serve(ctx context.Context, done func(ScheduleRun)) error {
+	for {
+		runs, err := s.RunDue(ctx, time.Now())
+		for _, run := range runs {
+			done(run)
+		}
+		if err != nil {
+			return err
+		}
+
+		wait := pollInterval
+		if store, err := Load(s.Path); err == nil {
+			for _, schedule := range store.Schedules {
+				if until := time.Until(schedule.Next); schedule.Enabled && until < wait {
+					wait = until
+				}
+			}
+		}
+		if wait < time.Second {
+			wait = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// execute runs the command of a schedule
+func (s *Scheduler) execute(ctx context.Context, schedule *Schedule, trigger string, due time.Time) Run {
+	run := Run{Due: due, Started: time.Now(), Trigger: trigger}
+	slog.Info("running schedule", "schedule", schedule.Name, "trigger", trigger, "args", schedule.Args)
+	run.ExitCode, run.Output = s.Runner(ctx, schedule)
+	run.Finished = time.Now()
+	run.Status = StatusSucceeded
+	if run.ExitCode != 0 {
+		run.Status = StatusFailed
+	}
+	return run
+}
+
+// missedRuns counts the runs of a schedule due from its next run until now
+func missedRuns(schedule *Schedule, now time.Time) int {
+	cron, err := ParseCron(schedule.Cron)
+	if err != nil {
+		return 1
+	}
+	count := 0
+	for t := schedule.Next; !t.IsZero() && !t.After(now) && count < maxMissed; t = cron.Next(t) {
+		count++
+	}
+	return count
+}
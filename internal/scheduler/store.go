@@ -0,0 +1,204 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// maxHistory is the number of runs kept for each schedule
+const maxHistory = 20
+
+// Statuses of runs
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	// StatusMissed is a run that was due while no scheduler was running and
+	// that was not caught up
+	StatusMissed = "missed"
+)
+
+// Triggers of runs
+const (
+	TriggerSchedule = "schedule"
+	// TriggerCatchUp is a run made late, for one missed while no scheduler
+	// was running
+	TriggerCatchUp = "catch-up"
+	TriggerManual  = "manual"
+)
+
+// Schedule runs a UPID command on a cron schedule
+type Schedule struct {
+	Name string `json:"name"`
+	Cron string `json:"cron"`
+	// Args are the arguments of the command, e.g. optimize apply --all
+	Args []string `json:"args"`
+	// Cluster is the cluster the command runs against, the default if empty
+	Cluster string `json:"cluster,omitempty"`
+	Enabled bool   `json:"enabled"`
+	// Jitter delays each run by a random duration of up to itself
+	Jitter time.Duration `json:"jitter,omitempty"`
+	// CatchUp runs the schedule once when the scheduler starts after runs
+	// were missed; otherwise they are recorded as missed
+	CatchUp   bool      `json:"catch_up"`
+	CreatedAt time.Time `json:"created_at"`
+	// Next is when the schedule runs next, jitter included
+	Next time.Time `json:"next,omitzero"`
+	// History holds the latest runs, oldest first
+	History []Run `json:"history,omitempty"`
+}
+
+// Run is one run of a schedule
+type Run struct {
+	// Due is when the run was scheduled, zero for manual runs
+	Due      time.Time `json:"due,omitzero"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Trigger  string    `json:"trigger"`
+	Status   string    `json:"status"`
+	ExitCode int       `json:"exit_code"`
+	// Output is the end of the output of the command
+	Output string `json:"output,omitempty"`
+}
+
+// Last returns the latest run of the schedule, nil if it never ran
+func (s *Schedule) Last() *Run {
+	if len(s.History) == 0 {
+		return nil
+	}
+	return &s.History[len(s.History)-1]
+}
+
+// Advance sets the next run to the first time the cron expression matches
+// after now, delayed by a random jitter
+func (s *Schedule) Advance(now time.Time) error {
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return err
+	}
+	next := cron.Next(now)
+	if next.IsZero() {
+		return fmt.Errorf("cron expression %q never matches", s.Cron)
+	}
+	if s.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(s.Jitter))))
+	}
+	s.Next = next
+	return nil
+}
+
+// record adds a run to the history, dropping the oldest beyond maxHistory
+func (s *Schedule) record(run Run) {
+	s.History = append(s.History, run)
+	if len(s.History) > maxHistory {
+		s.History = s.History[len(s.History)-maxHistory:]
+	}
+}
+
+// Store is the local file of schedules
+type Store struct {
+	path      string
+	Schedules []*Schedule `json:"schedules"`
+}
+
+// Load reads the schedules file at path; a missing file holds no schedules
+func Load(path string) (*Store, error) {
+	store := &Store{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedules: %v", err)
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("invalid schedules file %s: %v", path, err)
+	}
+	return store, nil
+}
+
+// Path returns the location of the schedules file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Get returns the schedule with a name, nil if there is none
+func (s *Store) Get(name string) *Schedule {
+	for _, schedule := range s.Schedules {
+		if schedule.Name == name {
+			return schedule
+		}
+	}
+	return nil
+}
+
+// Put adds a schedule, or replaces the one with the same name
+func (s *Store) Put(schedule *Schedule) {
+	for i, existing := range s.Schedules {
+		if existing.Name == schedule.Name {
+			s.Schedules[i] = schedule
+			return
+		}
+	}
+	s.Schedules = append(s.Schedules, schedule)
+	sort.Slice(s.Schedules, func(i, j int) bool { return s.Schedules[i].Name < s.Schedules[j].Name })
+}
+
+// Remove deletes the schedule with a name and returns true if there was one
+func (s *Store) Remove(name string) bool {
+	for i, schedule := range s.Schedules {
+		if schedule.Name == name {
+			s.Schedules = append(s.Schedules[:i], s.Schedules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Save replaces the schedules file, readable only by the user
+func (s *Store) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schedules: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	// Write to a temporary file first so that a scheduler reading the file
+	// never sees it half written
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write schedules: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write schedules: %v", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write schedules: %v", err)
+	}
+	return nil
+}
+
+// update applies a change to the schedule with a name in the file at path,
+// reading it again so that changes made meanwhile by other commands are
+// kept. It returns false if the schedule was removed meanwhile.
+func update(path, name string, change func(*Schedule)) (bool, error) {
+	store, err := Load(path)
+	if err != nil {
+		return false, err
+	}
+	schedule := store.Get(name)
+	if schedule == nil {
+		return false, nil
+	}
+	change(schedule)
+	return true, store.Save()
+}
@@ -0,0 +1,164 @@
+// Package secrets stores credentials such as auth tokens and client secrets
+// in the OS keychain: the macOS Keychain, the Windows Credential Manager or
+// the Secret Service on Linux. When no keychain is available, credentials are
+// kept in a file readable only by the user.
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/zalando/go-keyring"
+)
+
+// service is the keychain service name credentials are stored under
+const service = "upid-cli"
+
+// Backends selecting where credentials are stored
+const (
+	BackendAuto     = "auto"
+	BackendKeychain = "keychain"
+	BackendFile     = "file"
+)
+
+// ErrNotFound is returned when no credential is stored under a name
+var ErrNotFound = errors.New("credential not found")
+
+// Store reads and writes named credentials
+type Store struct {
+	backend string
+	file    string
+}
+
+// New creates a store using backend, one of the Backend* constants. file is
+// the fallback file used without a keychain.
+func New(backend, file string) *Store {
+	if backend == "" {
+		backend = BackendAuto
+	}
+	return &Store{backend: backend, file: file}
+}
+
+// Get returns the credential stored under name
+func (s *Store) Get(name string) (string, error) {
+	if s.backend != BackendFile {
+		value, err := keyring.Get(service, name)
+		if err == nil {
+			return value, nil
+		}
+		if s.backend == BackendKeychain {
+			return "", keychainError(err)
+		}
+		if !errors.Is(err, keyring.ErrNotFound) {
+			slog.Debug("keychain unavailable, using credentials file", "error", err)
+		}
+	}
+
+	values, err := s.readFile()
+	if err != nil {
+		return "", err
+	}
+	value, ok := values[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// Set stores a credential under name and returns the backend used
+func (s *Store) Set(name, value string) (string, error) {
+	if s.backend != BackendFile {
+		err := keyring.Set(service, name, value)
+		if err == nil {
+			// Do not leave an older copy behind in the file
+			s.deleteFromFile(name)
+			return BackendKeychain, nil
+		}
+		if s.backend == BackendKeychain {
+			return "", keychainError(err)
+		}
+		slog.Warn("keychain unavailable, storing credential in file", "file", s.file, "error", err)
+	}
+
+	values, err := s.readFile()
+	if err != nil {
+		return "", err
+	}
+	values[name] = value
+	return BackendFile, s.writeFile(values)
+}
+
+// Delete removes the credential stored under name. Removing a missing
+// credential is not an error.
+func (s *Store) Delete(name string) error {
+	if s.backend != BackendFile {
+		err := keyring.Delete(service, name)
+		if err != nil && !errors.Is(err, keyring.ErrNotFound) && s.backend == BackendKeychain {
+			return keychainError(err)
+		}
+	}
+	return s.deleteFromFile(name)
+}
+
+// deleteFromFile removes a credential from the fallback file
+func (s *Store) deleteFromFile(name string) error {
+	values, err := s.readFile()
+	if err != nil {
+		return err
+	}
+	if _, ok := values[name]; !ok {
+		return nil
+	}
+	delete(values, name)
+	return s.writeFile(values)
+}
+
+// readFile reads the fallback file; a missing file holds no credentials
+func (s *Store) readFile() (map[string]string, error) {
+	values := map[string]string{}
+	data, err := os.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return values, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %v", err)
+	}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %v", s.file, err)
+	}
+	return values, nil
+}
+
+// writeFile replaces the fallback file, readable only by the user
+func (s *Store) writeFile(values map[string]string) error {
+	if len(values) == 0 {
+		if err := os.Remove(s.file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove credentials file: %v", err)
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode credentials: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.file), 0o700); err != nil {
+		return fmt.Errorf("failed to create credentials directory: %v", err)
+	}
+	if err := os.WriteFile(s.file, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write credentials file: %v", err)
+	}
+	// WriteFile keeps the mode of an existing file
+	return os.Chmod(s.file, 0o600)
+}
+
+// keychainError reports a keychain failure when the file fallback is disabled
+func keychainError(err error) error {
+	if errors.Is(err, keyring.ErrNotFound) {
+		return ErrNotFound
+	}
+	return fmt.Errorf("keychain unavailable: %v (set credential_store to auto or file to use the credentials file)", err)
+}
@@ -0,0 +1,154 @@
+// Package servertls provides the certificates of the servers UPID runs, such
+// as the dashboard and the Prometheus exporter: from files, self-signed and
+// kept in the state directory, or obtained from Let's Encrypt.
+package servertls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kubilitics/upid-cli/internal/clierr"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// selfSignedValidity is how long generated certificates are valid
+	selfSignedValidity = 365 * 24 * time.Hour
+	// selfSignedRenewal is how long before they expire they are replaced
+	selfSignedRenewal = 30 * 24 * time.Hour
+)
+
+// Options selects the certificate of a server; at most one of a certificate
+// file, self-signed or ACME is set
+type Options struct {
+	CertFile string
+	KeyFile  string
+	// SelfSigned generates a certificate for the host of the server
+	SelfSigned bool
+	// ACMEDomains are the public names to obtain a certificate for from
+	// Let's Encrypt, ACMEEmail the contact of the account
+	ACMEDomains []string
+	ACMEEmail   string
+	// Dir keeps generated and obtained certificates
+	Dir string
+}
+
+// Config returns the TLS configuration of a server bound to host, nil
+// without TLS
+func (opts Options) Config(host string) (*tls.Config, error) {
+	switch {
+	case opts.CertFile != "":
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, clierr.Wrap(err, clierr.CategoryUsage, "INVALID_CERTIFICATE", fmt.Sprintf("failed to load the certificate %s: %v", opts.CertFile, err)).
+				WithHint("Give a PEM certificate with --tls-cert and its PEM private key with --tls-key")
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+	case opts.SelfSigned:
+		cert, err := selfSigned(opts.Dir, host)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+	case len(opts.ACMEDomains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(opts.ACMEDomains...),
+			Cache:      autocert.DirCache(filepath.Join(opts.Dir, "acme")),
+			Email:      opts.ACMEEmail,
+		}
+		config := manager.TLSConfig()
+		config.MinVersion = tls.VersionTLS12
+		return config, nil
+	}
+	return nil, nil
+}
+
+// SelfSignedFile returns the file of the certificate generated for servers
+// whose certificates are kept in dir
+func SelfSignedFile(dir string) string {
+	return filepath.Join(dir, "self-signed.pem")
+}
+
+// selfSigned returns the certificate generated for host, generating a new
+// one when there is none, it does not cover host or it expires soon
+func selfSigned(dir, host string) (tls.Certificate, error) {
+	path := SelfSignedFile(dir)
+	if data, err := os.ReadFile(path); err == nil {
+		if cert, err := tls.X509KeyPair(data, data); err == nil && covers(cert, host) {
+			return cert, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate a key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate a serial number: %v", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "UPID self-signed", Organization: []string{"UPID"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		template.DNSNames = append(template.DNSNames, name)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !ip.IsUnspecified() && !ip.IsLoopback() {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		}
+	} else if host != "" && host != "localhost" {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create a certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to encode the key: %v", err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})...)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return tls.X509KeyPair(data, data)
+}
+
+// covers reports whether a certificate is valid for host long enough
+func covers(cert tls.Certificate, host string) bool {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil || time.Now().Add(selfSignedRenewal).After(leaf.NotAfter) {
+		return false
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		return true
+	}
+	return leaf.VerifyHostname(host) == nil
+}
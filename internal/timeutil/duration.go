@@ -0,0 +1,78 @@
+package timeutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration parses a time range such as "30m", "24h", "7d" or "2w".
+// In addition to the units understood by time.ParseDuration it accepts days
+// (d) and weeks (w), which are common in UPID time-range flags.
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty time range")
+	}
+
+	unit := value[len(value)-1]
+	if unit == 'd' || unit == 'w' {
+		n, err := strconv.ParseFloat(value[:len(value)-1], 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid time range %q", value)
+		}
+		day := 24 * time.Hour
+		if unit == 'w' {
+			return time.Duration(n * float64(7*day)), nil
+		}
+		return time.Duration(n * float64(day)), nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid time range %q", value)
+	}
+	return d, nil
+}
+
+// ParsePeriod parses a time period such as "2024-05" (a month), "2024-05-10"
+// (a day), "2024-05-01..2024-05-15" (from the start of the first day to the
+// end of the last) or a time range such as "7d" (ending at now). Dates are in
+// UTC.
+func ParsePeriod(value string, now time.Time) (time.Time, time.Time, error) {
+	value = strings.TrimSpace(value)
+	if first, last, ok := strings.Cut(value, ".."); ok {
+		start, _, err := parseDate(first)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		_, end, err := parseDate(last)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if !end.After(start) {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid period %q: ends before it starts", value)
+		}
+		return start, end, nil
+	}
+	if start, end, err := parseDate(value); err == nil {
+		return start, end, nil
+	}
+	d, err := ParseDuration(value)
+	if err != nil || d == 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid period %q (expected YYYY-MM, YYYY-MM-DD, <date>..<date> or a time range such as 7d)", value)
+	}
+	return now.Add(-d), now, nil
+}
+
+// parseDate parses a month or a day and returns its start and end
+func parseDate(value string) (time.Time, time.Time, error) {
+	if t, err := time.Parse("2006-01", value); err == nil {
+		return t, t.AddDate(0, 1, 0), nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, t.AddDate(0, 0, 1), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q (expected YYYY-MM or YYYY-MM-DD)", value)
+}
@@ -7,8 +7,16 @@ Initializes Python runtime environment and executes UPID commands
 import os
 import sys
 import subprocess
+import traceback
 from pathlib import Path
 
+def failure(message):
+    """Return an error result, with the traceback of the exception being handled"""
+    result = {"error": message}
+    if sys.exc_info()[0] is not None:
+        result["traceback"] = traceback.format_exc()
+    return result
+
 class UpidRuntime:
     """UPID CLI Python Runtime"""
     
@@ -39,7 +47,9 @@ class UpidRuntime:
     def execute_command(self, command_args):
         """Execute UPID command with proper environment"""
         try:
-            if command_args[0] == "auth":
+            if command_args[0] == "health":
+                return {"message": "UPID runtime healthy", "status": "healthy"}
+            elif command_args[0] == "auth":
                 return self.execute_auth_command(command_args[1:])
             elif command_args[0] == "analyze":
                 return self.execute_analyze_command(command_args[1:])
@@ -54,7 +64,7 @@ class UpidRuntime:
             else:
                 return {"error": f"Unknown command: {command_args[0]}"}
         except Exception as e:
-            return {"error": f"Command execution failed: {str(e)}"}
+            return failure(f"Command execution failed: {str(e)}")
     
     def execute_auth_command(self, args):
         """Execute authentication commands"""
@@ -86,9 +96,12 @@ class UpidRuntime:
                     elif args[i] == "--password" and i + 1 < len(args):
                         password = args[i + 1]
                         i += 2
-                    elif args[i] == "--token" and i + 1 < len(args):
-                        # Handle token-based login
+                    elif args[i] in ("--token", "--token-env") and i + 1 < len(args):
+                        # Handle token-based login; --token-env names the
+                        # variable holding the token
                         token = args[i + 1]
+                        if args[i] == "--token-env":
+                            token = os.environ.get(token, "")
                         return {
                             "message": f"🎫 Token authentication successful",
                             "user": "token-user",
@@ -131,10 +144,14 @@ class UpidRuntime:
             else:
                 return {"error": f"Unknown auth command: {command}"}
         except Exception as e:
-            return {"error": f"Auth command failed: {str(e)}"}
+            return failure(f"Auth command failed: {str(e)}")
     
     def execute_analyze_command(self, args):
         """Execute analysis commands"""
+        fixture_file = os.environ.get("UPID_FIXTURE_FILE")
+        if fixture_file:
+            return self.execute_fixture_analysis(fixture_file, args)
+
         try:
             from upid_python.core.resource_analyzer import ResourceAnalyzer
             from upid_python.core.k8s_client import KubernetesClient
@@ -152,10 +169,34 @@ class UpidRuntime:
             else:
                 return {"error": f"Unknown analyze command: {args[0]}"}
         except ImportError as e:
-            return {"error": f"Analysis module not available: {str(e)}"}
+            return failure(f"Analysis module not available: {str(e)}")
         except Exception as e:
-            return {"error": f"Analysis command failed: {str(e)}"}
+            return failure(f"Analysis command failed: {str(e)}")
     
+    def execute_fixture_analysis(self, fixture_file, args):
+        """Execute analysis commands against synthetic fixture data"""
+        import json
+
+        try:
+            with open(fixture_file) as f:
+                pods = json.load(f).get("pods", [])
+        except (OSError, ValueError) as e:
+            return failure(f"Failed to load fixture data: {str(e)}")
+
+        command = args[0] if args else "cluster"
+        if command == "idle":
+            idle = [p for p in pods if p["cpu_usage"] < 0.05 * p["cpu_request"]]
+            return {"message": f"Found {len(idle)} idle pods in fixture data", "idle_pods": len(idle)}
+        elif command == "resources":
+            cpu = sum(p["cpu_usage"] for p in pods)
+            memory = sum(p["memory_usage"] for p in pods)
+            return {"message": f"Fixture usage: {cpu:.2f} cores, {memory / 2**30:.2f} GiB", "cpu": cpu, "memory": memory}
+        elif command == "cluster":
+            namespaces = {p["namespace"] for p in pods}
+            return {"message": f"Analyzed {len(pods)} pods in {len(namespaces)} namespaces", "pods": len(pods)}
+        else:
+            return {"error": f"Unknown analyze command: {command}"}
+
     def execute_optimize_command(self, args):
         """Execute optimization commands"""
         try:
@@ -172,9 +213,9 @@ class UpidRuntime:
             else:
                 return {"error": f"Unknown optimize command: {args[0]}"}
         except ImportError as e:
-            return {"error": f"Optimization module not available: {str(e)}"}
+            return failure(f"Optimization module not available: {str(e)}")
         except Exception as e:
-            return {"error": f"Optimization command failed: {str(e)}"}
+            return failure(f"Optimization command failed: {str(e)}")
     
     def execute_report_command(self, args):
         """Execute reporting commands"""
@@ -190,9 +231,9 @@ class UpidRuntime:
             else:
                 return {"error": f"Unknown report command: {args[0]}"}
         except ImportError as e:
-            return {"error": f"Reporting module not available: {str(e)}"}
+            return failure(f"Reporting module not available: {str(e)}")
         except Exception as e:
-            return {"error": f"Report command failed: {str(e)}"}
+            return failure(f"Report command failed: {str(e)}")
     
     def execute_dashboard_command(self, args):
         """Execute dashboard commands"""
@@ -208,9 +249,9 @@ class UpidRuntime:
             else:
                 return {"error": f"Unknown dashboard command: {args[0]}"}
         except ImportError as e:
-            return {"error": f"Dashboard module not available: {str(e)}"}
+            return failure(f"Dashboard module not available: {str(e)}")
         except Exception as e:
-            return {"error": f"Dashboard command failed: {str(e)}"}
+            return failure(f"Dashboard command failed: {str(e)}")
     
     def execute_api_command(self, args):
         """Execute API server commands"""
@@ -224,7 +265,7 @@ class UpidRuntime:
             else:
                 return {"error": f"Unknown API command: {args[0]}"}
         except Exception as e:
-            return {"error": f"API command failed: {str(e)}"}
+            return failure(f"API command failed: {str(e)}")
     
     def start_api_server(self, port=8000):
         """Start the API server"""
@@ -251,19 +292,246 @@ class UpidRuntime:
                 "pid": process.pid
             }
         except Exception as e:
-            return {"error": f"Failed to start API server: {str(e)}"}
+            return failure(f"Failed to start API server: {str(e)}")
+
+class RuntimeServer:
+    """JSON-RPC 2.0 server that keeps the runtime loaded between CLI commands
+
+    Messages are framed with a 4-byte big-endian length prefix. On Unix the
+    server listens on a Unix domain socket, on Windows on a named pipe.
+    """
+
+    def __init__(self, runtime, address, idle_timeout=600):
+        self.runtime = runtime
+        self.address = address
+        self.idle_timeout = idle_timeout
+        self.running = True
+        self.log_file = os.environ.get("UPID_DAEMON_LOG")
+
+    def log(self, level, message, **fields):
+        """Write a structured log entry to the daemon log"""
+        if not self.log_file:
+            return
+        import json
+        from datetime import datetime, timezone
+
+        entry = {"time": datetime.now(timezone.utc).isoformat(), "level": level, "msg": message, "source": "daemon"}
+        entry.update(fields)
+        try:
+            with open(self.log_file, "a") as f:
+                f.write(json.dumps(entry, default=str) + "\n")
+        except OSError:
+            pass
+
+    def handle(self, payload):
+        """Handle one JSON-RPC request and return the response"""
+        import json
+
+        try:
+            request = json.loads(payload)
+        except ValueError as e:
+            return {"jsonrpc": "2.0", "id": None, "error": {"code": -32700, "message": f"Parse error: {e}"}}
+
+        request_id = request.get("id")
+        method = request.get("method")
+        params = request.get("params") or {}
+
+        if method == "ping":
+            return {"jsonrpc": "2.0", "id": request_id, "result": {"pid": os.getpid(), "status": "ok"}}
+        if method == "shutdown":
+            self.running = False
+            return {"jsonrpc": "2.0", "id": request_id, "result": {"status": "stopping"}}
+        if method != "execute":
+            return {"jsonrpc": "2.0", "id": request_id, "error": {"code": -32601, "message": f"Method not found: {method}"}}
+
+        args = params.get("args") or []
+        if not args:
+            return {"jsonrpc": "2.0", "id": request_id, "error": {"code": -32602, "message": "Missing command arguments"}}
+
+        # Apply per-request environment, e.g. UPID_FIXTURE_FILE
+        env = params.get("env") or {}
+        saved = {key: os.environ.get(key) for key in env}
+        os.environ.update(env)
+        try:
+            self.log("DEBUG", "executing command", command=" ".join(args))
+            result = self.runtime.execute_command(args)
+        finally:
+            for key, value in saved.items():
+                if value is None:
+                    os.environ.pop(key, None)
+                else:
+                    os.environ[key] = value
+
+        if "error" in result:
+            self.log("ERROR", "command failed", command=" ".join(args), error=result["error"], traceback=result.get("traceback"))
+            error = {"code": -32000, "message": result["error"]}
+            if result.get("traceback"):
+                error["data"] = {"traceback": result["traceback"]}
+            return {"jsonrpc": "2.0", "id": request_id, "error": error}
+        return {"jsonrpc": "2.0", "id": request_id, "result": result}
+
+    def serve(self):
+        """Serve requests until shut down or idle for idle_timeout seconds"""
+        self.log("INFO", "daemon started", pid=os.getpid(), address=self.address)
+        if sys.platform == "win32":
+            self.serve_pipe()
+        else:
+            self.serve_unix()
+        self.log("INFO", "daemon stopped", pid=os.getpid())
+
+    def serve_unix(self):
+        import socket
+        import struct
+
+        if os.path.exists(self.address):
+            os.unlink(self.address)
+        server = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+        server.bind(self.address)
+        os.chmod(self.address, 0o600)
+        server.listen(8)
+        server.settimeout(self.idle_timeout)
+
+        def read_exact(conn, size):
+            data = b""
+            while len(data) < size:
+                chunk = conn.recv(size - len(data))
+                if not chunk:
+                    return None
+                data += chunk
+            return data
+
+        try:
+            while self.running:
+                try:
+                    conn, _ = server.accept()
+                except socket.timeout:
+                    self.log("INFO", "daemon idle timeout reached")
+                    break
+                with conn:
+                    while self.running:
+                        header = read_exact(conn, 4)
+                        if header is None:
+                            break
+                        payload = read_exact(conn, struct.unpack("!I", header)[0])
+                        if payload is None:
+                            break
+                        response = self.encode(self.handle(payload))
+                        conn.sendall(struct.pack("!I", len(response)) + response)
+        finally:
+            server.close()
+            if os.path.exists(self.address):
+                os.unlink(self.address)
+
+    def serve_pipe(self):
+        import _winapi
+        import struct
+
+        # A byte-mode pipe carries the same 4-byte length framing as the
+        # Unix socket. multiprocessing's PipeConnection cannot be used: it
+        # reads message-mode frames without a length prefix.
+        def create(first=False):
+            mode = _winapi.PIPE_ACCESS_DUPLEX | _winapi.FILE_FLAG_OVERLAPPED
+            if first:
+                mode |= _winapi.FILE_FLAG_FIRST_PIPE_INSTANCE
+            return _winapi.CreateNamedPipe(
+                self.address, mode, _winapi.PIPE_WAIT,
+                _winapi.PIPE_UNLIMITED_INSTANCES, 65536, 65536,
+                _winapi.NMPWAIT_WAIT_FOREVER, _winapi.NULL)
+
+        def connect(handle):
+            try:
+                ov = _winapi.ConnectNamedPipe(handle, overlapped=True)
+            except OSError as e:
+                # A client that connected, wrote and closed before the call
+                if e.winerror != _winapi.ERROR_NO_DATA:
+                    raise
+                return True
+            res = _winapi.WaitForMultipleObjects([ov.event], False, self.idle_timeout * 1000)
+            if res == _winapi.WAIT_TIMEOUT:
+                ov.cancel()
+                return False
+            _, err = ov.GetOverlappedResult(True)
+            return err == 0
+
+        def read_exact(handle, size):
+            data = b""
+            while len(data) < size:
+                try:
+                    ov, _ = _winapi.ReadFile(handle, size - len(data), overlapped=True)
+                    nread, _ = ov.GetOverlappedResult(True)
+                except OSError:
+                    return None
+                if nread == 0:
+                    return None
+                data += ov.getbuffer()
+            return data
+
+        def write_all(handle, data):
+            ov, _ = _winapi.WriteFile(handle, data, overlapped=True)
+            ov.GetOverlappedResult(True)
+
+        handle = create(first=True)
+        try:
+            while self.running:
+                if not connect(handle):
+                    self.log("INFO", "daemon idle timeout reached")
+                    break
+                while self.running:
+                    header = read_exact(handle, 4)
+                    if header is None:
+                        break
+                    payload = read_exact(handle, struct.unpack("!I", header)[0])
+                    if payload is None:
+                        break
+                    response = self.encode(self.handle(payload))
+                    try:
+                        write_all(handle, struct.pack("!I", len(response)) + response)
+                    except OSError:
+                        break
+                # The next instance is created before this one is closed, so
+                # that clients never find the pipe missing
+                handle, previous = create(), handle
+                _winapi.CloseHandle(previous)
+        finally:
+            _winapi.CloseHandle(handle)
+
+    @staticmethod
+    def encode(response):
+        import json
+        return json.dumps(response, default=str).encode()
+
+def option_value(argv, name, default=None):
+    """Return the value following a command-line option"""
+    values = [argv[i + 1] for i, arg in enumerate(argv[:-1]) if arg == name]
+    return values[-1] if values else default
+
+def output_format(argv):
+    """Return the last --format value requested by the Go CLI"""
+    return option_value(argv, "--format", "table")
 
 # Runtime execution
 if __name__ == "__main__":
     runtime = UpidRuntime()
     
+    if len(sys.argv) > 1 and sys.argv[1] == "--serve":
+        address = option_value(sys.argv, "--socket")
+        if not address:
+            print("Error: --serve requires --socket", file=sys.stderr)
+            sys.exit(2)
+        idle_timeout = int(option_value(sys.argv, "--idle-timeout", "600"))
+        RuntimeServer(runtime, address, idle_timeout).serve()
     # Execute command from arguments
-    if len(sys.argv) > 1:
+    elif len(sys.argv) > 1:
         result = runtime.execute_command(sys.argv[1:])
         
         if "error" in result:
+            if result.get("traceback"):
+                print(result["traceback"], end="", file=sys.stderr)
             print(f"Error: {result['error']}", file=sys.stderr)
             sys.exit(1)
+        elif output_format(sys.argv) == "json":
+            import json
+            print(json.dumps(result, default=str))
         else:
             print(result.get("message", "Command completed successfully"))
     else: